@@ -0,0 +1,238 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addressbook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/random"
+)
+
+// ErrContactNotFound is returned if there is no contact with the given id.
+var ErrContactNotFound = errp.New("contact not found")
+
+// ErrNoAddress is returned if a contact has no address for the requested coin.
+var ErrNoAddress = errp.New("the contact has no address for this coin")
+
+// Contact is a named recipient with one or more addresses per coin.
+type Contact struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Addresses maps coin codes to the addresses of the contact.
+	Addresses map[string][]string `json:"addresses"`
+	// Rotate is true if the contact asked to not receive twice on the same address.
+	Rotate bool `json:"rotate"`
+}
+
+func (contact *Contact) copy() *Contact {
+	addresses := make(map[string][]string, len(contact.Addresses))
+	for coinCode, coinAddresses := range contact.Addresses {
+		addresses[coinCode] = append([]string(nil), coinAddresses...)
+	}
+	return &Contact{
+		ID:        contact.ID,
+		Name:      contact.Name,
+		Addresses: addresses,
+		Rotate:    contact.Rotate,
+	}
+}
+
+// AddressBook manages the contacts, persisted in a json file.
+type AddressBook struct {
+	lock     locker.Locker
+	filename string
+	contacts []*Contact
+	// validate returns an error if the address is not valid for the coin with the given code.
+	validate func(coinCode string, address string) error
+}
+
+// NewAddressBook creates a new AddressBook, stored in the given location. The filename must be
+// writable, but does not have to exist. Addresses are validated with validate when they are added.
+func NewAddressBook(filename string, validate func(coinCode string, address string) error) (
+	*AddressBook, error) {
+	addressBook := &AddressBook{
+		filename: filename,
+		contacts: []*Contact{},
+		validate: validate,
+	}
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return addressBook, nil
+	}
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := json.Unmarshal(jsonBytes, &addressBook.contacts); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return addressBook, nil
+}
+
+func (addressBook *AddressBook) save() error {
+	jsonBytes, err := json.Marshal(addressBook.contacts)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(addressBook.filename, jsonBytes, 0600))
+}
+
+// Contacts returns all contacts in the order in which they were added.
+func (addressBook *AddressBook) Contacts() []*Contact {
+	defer addressBook.lock.RLock()()
+	contacts := make([]*Contact, len(addressBook.contacts))
+	for i, contact := range addressBook.contacts {
+		contacts[i] = contact.copy()
+	}
+	return contacts
+}
+
+func (addressBook *AddressBook) index(id string) (int, error) {
+	for i, contact := range addressBook.contacts {
+		if contact.ID == id {
+			return i, nil
+		}
+	}
+	return 0, errp.WithStack(ErrContactNotFound)
+}
+
+// Contact returns the contact with the given id.
+func (addressBook *AddressBook) Contact(id string) (*Contact, error) {
+	defer addressBook.lock.RLock()()
+	i, err := addressBook.index(id)
+	if err != nil {
+		return nil, err
+	}
+	return addressBook.contacts[i].copy(), nil
+}
+
+// normalize trims the name and the addresses of the contact, drops empty entries and validates
+// each address against its coin.
+func (addressBook *AddressBook) normalize(contact *Contact) (*Contact, error) {
+	normalized := &Contact{
+		ID:        contact.ID,
+		Name:      strings.TrimSpace(contact.Name),
+		Addresses: map[string][]string{},
+		Rotate:    contact.Rotate,
+	}
+	if normalized.Name == "" {
+		return nil, errp.New("the contact name is empty")
+	}
+	for coinCode, coinAddresses := range contact.Addresses {
+		seen := map[string]struct{}{}
+		for _, address := range coinAddresses {
+			address = strings.TrimSpace(address)
+			if address == "" {
+				continue
+			}
+			if _, ok := seen[address]; ok {
+				continue
+			}
+			seen[address] = struct{}{}
+			if err := addressBook.validate(coinCode, address); err != nil {
+				return nil, errp.WithMessage(err, "coin "+coinCode+": "+address)
+			}
+			normalized.Addresses[coinCode] = append(normalized.Addresses[coinCode], address)
+		}
+	}
+	if len(normalized.Addresses) == 0 {
+		return nil, errp.New("the contact has no address")
+	}
+	return normalized, nil
+}
+
+// Add validates and persists a new contact. The id of the contact is assigned and returned.
+func (addressBook *AddressBook) Add(contact *Contact) (string, error) {
+	defer addressBook.lock.Lock()()
+	normalized, err := addressBook.normalize(contact)
+	if err != nil {
+		return "", err
+	}
+	normalized.ID, err = random.HexString(8)
+	if err != nil {
+		return "", err
+	}
+	addressBook.contacts = append(addressBook.contacts, normalized)
+	if err := addressBook.save(); err != nil {
+		addressBook.contacts = addressBook.contacts[:len(addressBook.contacts)-1]
+		return "", err
+	}
+	return normalized.ID, nil
+}
+
+// Update validates and persists the contact, replacing the contact with the same id.
+func (addressBook *AddressBook) Update(contact *Contact) error {
+	defer addressBook.lock.Lock()()
+	i, err := addressBook.index(contact.ID)
+	if err != nil {
+		return err
+	}
+	normalized, err := addressBook.normalize(contact)
+	if err != nil {
+		return err
+	}
+	previous := addressBook.contacts[i]
+	addressBook.contacts[i] = normalized
+	if err := addressBook.save(); err != nil {
+		addressBook.contacts[i] = previous
+		return err
+	}
+	return nil
+}
+
+// Delete removes the contact with the given id.
+func (addressBook *AddressBook) Delete(id string) error {
+	defer addressBook.lock.Lock()()
+	i, err := addressBook.index(id)
+	if err != nil {
+		return err
+	}
+	previous := addressBook.contacts
+	addressBook.contacts = append(append([]*Contact{}, previous[:i]...), previous[i+1:]...)
+	if err := addressBook.save(); err != nil {
+		addressBook.contacts = previous
+		return err
+	}
+	return nil
+}
+
+// Recipient returns the address to send to when paying the contact with the given coin. used
+// reports whether coins were already sent to an address. If the contact asked for address rotation,
+// the first unused address is picked. If all of them were used already, the first address is
+// returned and flagged as reused.
+func (addressBook *AddressBook) Recipient(
+	id string, coinCode string, used func(address string) bool) (string, bool, error) {
+	contact, err := addressBook.Contact(id)
+	if err != nil {
+		return "", false, err
+	}
+	addresses := contact.Addresses[coinCode]
+	if len(addresses) == 0 {
+		return "", false, errp.WithStack(ErrNoAddress)
+	}
+	if !contact.Rotate {
+		return addresses[0], false, nil
+	}
+	for _, address := range addresses {
+		if !used(address) {
+			return address, false, nil
+		}
+	}
+	return addresses[0], true, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addressbook_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/addressbook"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+const (
+	btcAddress1 = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+	btcAddress2 = "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy"
+	tbtcAddress = "mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn"
+	ethAddress  = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+)
+
+func validate(coinCode string, address string) error {
+	switch coinCode {
+	case "btc":
+		_, err := btc.DecodeAddress(address, &chaincfg.MainNetParams)
+		return err
+	case "eth":
+		return eth.ValidateAddress(address)
+	}
+	return errp.Newf("unknown coin %s", coinCode)
+}
+
+func newAddressBook(t *testing.T) (*addressbook.AddressBook, string) {
+	filename := filepath.Join(test.TstTempDir("addressbook"), "addressbook.json")
+	addressBook, err := addressbook.NewAddressBook(filename, validate)
+	require.NoError(t, err)
+	return addressBook, filename
+}
+
+func TestValidation(t *testing.T) {
+	addressBook, _ := newAddressBook(t)
+	add := func(coinCode, address string) error {
+		_, err := addressBook.Add(&addressbook.Contact{
+			Name:      "Alice",
+			Addresses: map[string][]string{coinCode: {address}},
+		})
+		return err
+	}
+	require.NoError(t, add("btc", btcAddress1))
+	require.NoError(t, add("eth", ethAddress))
+	// All lowercase addresses carry no checksum.
+	require.NoError(t, add("eth", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"))
+
+	// Address of a different network.
+	require.Equal(t, coin.ErrInvalidAddress, errp.Cause(add("btc", tbtcAddress)))
+	// Wrong EIP-55 checksum.
+	require.Equal(t, coin.ErrInvalidAddress,
+		errp.Cause(add("eth", "0x5aaeb6053F3E94C9b9A09f33669435E7Ef1BeAed")))
+	require.Equal(t, coin.ErrInvalidAddress, errp.Cause(add("eth", btcAddress1)))
+	require.Error(t, add("unknown", btcAddress1))
+	// No address at all.
+	require.Error(t, add("btc", " "))
+	// No name.
+	_, err := addressBook.Add(&addressbook.Contact{
+		Name:      " ",
+		Addresses: map[string][]string{"btc": {btcAddress1}},
+	})
+	require.Error(t, err)
+	require.Len(t, addressBook.Contacts(), 3)
+}
+
+func TestPersistence(t *testing.T) {
+	addressBook, filename := newAddressBook(t)
+	aliceID, err := addressBook.Add(&addressbook.Contact{
+		Name:      " Alice ",
+		Addresses: map[string][]string{"btc": {btcAddress1, " " + btcAddress1}},
+	})
+	require.NoError(t, err)
+	bobID, err := addressBook.Add(&addressbook.Contact{
+		Name:      "Bob",
+		Addresses: map[string][]string{"eth": {ethAddress}},
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, aliceID, bobID)
+
+	alice, err := addressBook.Contact(aliceID)
+	require.NoError(t, err)
+	require.Equal(t, &addressbook.Contact{
+		ID:        aliceID,
+		Name:      "Alice",
+		Addresses: map[string][]string{"btc": {btcAddress1}},
+	}, alice)
+
+	alice.Addresses["btc"] = append(alice.Addresses["btc"], btcAddress2)
+	alice.Rotate = true
+	require.NoError(t, addressBook.Update(alice))
+	require.NoError(t, addressBook.Delete(bobID))
+	require.Equal(t, addressbook.ErrContactNotFound, errp.Cause(addressBook.Delete(bobID)))
+	require.Equal(t, addressbook.ErrContactNotFound, errp.Cause(addressBook.Update(
+		&addressbook.Contact{ID: bobID, Name: "Bob", Addresses: map[string][]string{"eth": {ethAddress}}})))
+
+	reloaded, err := addressbook.NewAddressBook(filename, validate)
+	require.NoError(t, err)
+	require.Equal(t, []*addressbook.Contact{alice}, reloaded.Contacts())
+}
+
+func TestRecipient(t *testing.T) {
+	addressBook, _ := newAddressBook(t)
+	contact := &addressbook.Contact{
+		Name:      "Alice",
+		Addresses: map[string][]string{"btc": {btcAddress1, btcAddress2}},
+	}
+	id, err := addressBook.Add(contact)
+	require.NoError(t, err)
+	contact.ID = id
+
+	usedAddresses := map[string]bool{}
+	used := func(address string) bool { return usedAddresses[address] }
+
+	_, _, err = addressBook.Recipient(id, "eth", used)
+	require.Equal(t, addressbook.ErrNoAddress, errp.Cause(err))
+	_, _, err = addressBook.Recipient("unknown", "btc", used)
+	require.Equal(t, addressbook.ErrContactNotFound, errp.Cause(err))
+
+	// Without rotation, the first address is used and never flagged.
+	usedAddresses[btcAddress1] = true
+	address, reused, err := addressBook.Recipient(id, "btc", used)
+	require.NoError(t, err)
+	require.Equal(t, btcAddress1, address)
+	require.False(t, reused)
+
+	contact.Rotate = true
+	require.NoError(t, addressBook.Update(contact))
+	address, reused, err = addressBook.Recipient(id, "btc", used)
+	require.NoError(t, err)
+	require.Equal(t, btcAddress2, address)
+	require.False(t, reused)
+
+	usedAddresses[btcAddress2] = true
+	address, reused, err = addressBook.Recipient(id, "btc", used)
+	require.NoError(t, err)
+	require.Equal(t, btcAddress1, address)
+	require.True(t, reused)
+}
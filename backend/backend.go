@@ -18,42 +18,47 @@ import (
 	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"time"
 
 	"golang.org/x/text/language"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/cloudfoundry-attic/jibber_jabber"
-	"github.com/ethereum/go-ethereum/params"
 	"github.com/sirupsen/logrus"
 
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/addressbook"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/arguments"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/bitcoind"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/esplora"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/neutrino"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/signet"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
-	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/registry"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/invoices"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/labels"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable/action"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
-)
-
-const (
-	coinBTC  = "btc"
-	coinTBTC = "tbtc"
-	coinLTC  = "ltc"
-	coinTLTC = "tltc"
-	coinETH  = "eth"
-	coinTETH = "teth"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 )
 
 type backendEvent struct {
@@ -74,6 +79,10 @@ type AccountEvent struct {
 	Type string `json:"type"`
 	Code string `json:"code"`
 	Data string `json:"data"`
+	// TxEvent is set if Data is btc.EventTx.
+	TxEvent *btc.TxEvent `json:"txEvent,omitempty"`
+	// Invoice is set if Data is eventInvoice.
+	Invoice *invoices.Invoice `json:"invoice,omitempty"`
 }
 
 // Backend ties everything together and is the main starting point to use the BitBox wallet library.
@@ -81,6 +90,8 @@ type Backend struct {
 	arguments *arguments.Arguments
 
 	config *config.Config
+	// socksProxy is used by all outbound connections.
+	socksProxy *socksproxy.SocksProxy
 
 	events chan interface{}
 
@@ -96,27 +107,71 @@ type Backend struct {
 
 	accounts     []btc.Interface
 	accountsLock locker.Locker
+	// accountsInitLock serializes the changes of the keystores and the accounts built from them,
+	// so that the accounts are closed before they are replaced. It guards replacing keystores.
+	accountsInitLock locker.Locker
 
 	// Stored and exposed temporarily through the backend.
 	ratesUpdater coin.RatesUpdater
 
+	// portfolioTotal is the fiat currency and total of the portfolio last notified about.
+	portfolioTotal string
+	portfolioLock  locker.Locker
+
+	// addressBook holds the contacts. addressBookErr is set if it could not be loaded.
+	addressBook    *addressbook.AddressBook
+	addressBookErr error
+	// invoices holds the payment requests. invoicesErr is set if they could not be loaded.
+	invoices    *invoices.Store
+	invoicesErr error
+	// labels holds the labels of the transactions. labelsErr is set if they could not be loaded.
+	labels    *labels.Store
+	labelsErr error
+
 	log *logrus.Entry
 }
 
 // NewBackend creates a new backend with the given arguments.
 func NewBackend(arguments *arguments.Arguments) *Backend {
 	log := logging.Get().WithGroup("backend")
-	return &Backend{
-		arguments: arguments,
-		config:    config.NewConfig(arguments.ConfigFilename()),
-		events:    make(chan interface{}, 1000),
+	appConfig := config.NewConfig(arguments.ConfigFilename(), defaultConfig())
+	socksProxy := socksproxy.NewSocksProxy(appConfig.Config().Backend.Proxy)
+	historicalRatesFilename := filepath.Join(arguments.CacheDirectoryPath(), "historical-rates.json")
+	backend := &Backend{
+		arguments:  arguments,
+		config:     appConfig,
+		socksProxy: socksProxy,
+		events:     make(chan interface{}, 1000),
 
 		devices:      map[string]device.Interface{},
 		keystores:    keystore.NewKeystores(),
 		coins:        map[string]coin.Coin{},
-		ratesUpdater: btc.NewRatesUpdater(),
+		ratesUpdater: btc.NewRatesUpdater(socksProxy.HTTPClient(time.Minute), historicalRatesFilename),
 		log:          log,
 	}
+	backend.ratesUpdater.Observe(func(observable.Event) { go backend.updatePortfolio() })
+	backend.addressBook, backend.addressBookErr = addressbook.NewAddressBook(
+		filepath.Join(arguments.MainDirectoryPath(), "addressbook.json"), backend.validateAddress)
+	if backend.addressBookErr != nil {
+		log.WithError(backend.addressBookErr).Error("Could not load the address book")
+	}
+	backend.labels, backend.labelsErr = labels.NewStore(
+		filepath.Join(arguments.MainDirectoryPath(), "labels.json"))
+	if backend.labelsErr != nil {
+		log.WithError(backend.labelsErr).Error("Could not load the labels")
+	}
+	backend.invoices, backend.invoicesErr = invoices.NewStore(
+		filepath.Join(arguments.MainDirectoryPath(), "invoices.json"))
+	if backend.invoicesErr != nil {
+		log.WithError(backend.invoicesErr).Error("Could not load the invoices")
+	} else {
+		for _, invoice := range backend.invoices.Invoices() {
+			if invoice.Status == invoices.StatusUnpaid || invoice.Status == invoices.StatusPartiallyPaid {
+				backend.scheduleInvoicesUpdate(invoice.Expires)
+			}
+		}
+	}
+	return backend
 }
 
 func (backend *Backend) addAccount(
@@ -126,7 +181,7 @@ func (backend *Backend) addAccount(
 	keypath string,
 	scriptType signing.ScriptType,
 ) {
-	if !backend.config.Config().Backend.AccountActive(code) {
+	if !backend.accountActive(code) {
 		backend.log.WithField("code", code).WithField("name", name).Info("skipping inactive account")
 		return
 	}
@@ -135,8 +190,9 @@ func (backend *Backend) addAccount(
 	if err != nil {
 		panic(err)
 	}
+	keystores := backend.keystores
 	getSigningConfiguration := func() (*signing.Configuration, error) {
-		return backend.keystores.Configuration(scriptType, absoluteKeypath, backend.keystores.Count())
+		return keystores.Configuration(scriptType, absoluteKeypath, keystores.Count())
 	}
 	if backend.arguments.Multisig() {
 		name = name + " Multisig"
@@ -146,18 +202,36 @@ func (backend *Backend) addAccount(
 		onEvent := func(code string) func(btc.Event) {
 			return func(event btc.Event) {
 				backend.events <- AccountEvent{Type: "account", Code: code, Data: string(event)}
+				if event == btc.EventSyncDone {
+					go backend.updatePortfolio()
+					go backend.updateInvoices()
+				}
 			}
 		}
+		onTxEvent := func(event *btc.TxEvent) {
+			backend.events <- AccountEvent{
+				Type: "account", Code: code, Data: string(btc.EventTx), TxEvent: event}
+		}
 		account := btc.NewAccount(specificCoin, backend.arguments.CacheDirectoryPath(), code, name,
-			getSigningConfiguration, backend.keystores, onEvent(code), backend.log)
+			getSigningConfiguration, keystores, backend.labels, onEvent(code),
+			backend.config.Config().Backend.ConfirmationNotifications, onTxEvent, backend.log)
+		account.ReserveAddresses(backend.reservedAddresses(code))
 		backend.accounts = append(backend.accounts, account)
 	case *eth.Coin:
 		onEvent := func(event eth.Event) {
 			backend.events <- AccountEvent{Type: "account", Code: code, Data: string(event)}
+			if event == eth.Event(btc.EventSyncDone) {
+				go backend.updatePortfolio()
+			}
+		}
+		onTxEvent := func(event *btc.TxEvent) {
+			backend.events <- AccountEvent{
+				Type: "account", Code: code, Data: string(btc.EventTx), TxEvent: event}
 		}
 		account := eth.NewAccount(specificCoin, backend.arguments.CacheDirectoryPath(),
 			code, name,
-			getSigningConfiguration, backend.keystores, onEvent, backend.log)
+			getSigningConfiguration, keystores, onEvent,
+			backend.config.Config().Backend.ConfirmationNotifications, onTxEvent, backend.log)
 		backend.accounts = append(backend.accounts, account)
 	default:
 		panic("unknown coin type")
@@ -169,86 +243,114 @@ func (backend *Backend) Config() *config.Config {
 	return backend.config
 }
 
-// DefaultConfig returns the default app config.y
+// SocksProxy returns the proxy used by all outbound connections.
+func (backend *Backend) SocksProxy() *socksproxy.SocksProxy {
+	return backend.socksProxy
+}
+
+// DefaultConfig returns the default app config.
 func (backend *Backend) DefaultConfig() config.AppConfig {
-	return config.NewDefaultConfig()
-}
-
-func (backend *Backend) defaultProdServers(code string) []*rpc.ServerInfo {
-	switch code {
-	case coinBTC:
-		return backend.config.Config().Backend.BTC.ElectrumServers
-	case coinTBTC:
-		return backend.config.Config().Backend.TBTC.ElectrumServers
-	case coinLTC:
-		return backend.config.Config().Backend.LTC.ElectrumServers
-	case coinTLTC:
-		return backend.config.Config().Backend.TLTC.ElectrumServers
-	default:
-		panic(errp.Newf("The given code %s is unknown.", code))
-	}
-}
-
-func defaultDevServers(code string) []*rpc.ServerInfo {
-	const devShiftCA = `-----BEGIN CERTIFICATE-----
-MIIGGjCCBAKgAwIBAgIJAO1AEqR+xvjRMA0GCSqGSIb3DQEBDQUAMIGZMQswCQYD
-VQQGEwJDSDEPMA0GA1UECAwGWnVyaWNoMR0wGwYDVQQKDBRTaGlmdCBDcnlwdG9z
-ZWN1cml0eTEzMDEGA1UECwwqU2hpZnQgQ3J5cHRvc2VjdXJpdHkgQ2VydGlmaWNh
-dGUgQXV0aG9yaXR5MSUwIwYDVQQDDBxTaGlmdCBDcnlwdG9zZWN1cml0eSBSb290
-IENBMB4XDTE4MDMwNzE3MzUxMloXDTM4MDMwMjE3MzUxMlowgZkxCzAJBgNVBAYT
-AkNIMQ8wDQYDVQQIDAZadXJpY2gxHTAbBgNVBAoMFFNoaWZ0IENyeXB0b3NlY3Vy
-aXR5MTMwMQYDVQQLDCpTaGlmdCBDcnlwdG9zZWN1cml0eSBDZXJ0aWZpY2F0ZSBB
-dXRob3JpdHkxJTAjBgNVBAMMHFNoaWZ0IENyeXB0b3NlY3VyaXR5IFJvb3QgQ0Ew
-ggIiMA0GCSqGSIb3DQEBAQUAA4ICDwAwggIKAoICAQDlz32VZk/D3rfm7Qwx6WkE
-Fp9cdQV2FNYTeTjWVErVeTev02ctHHXV1fR3Svk8iIJWaALSJy7phdEDwC/3gDIQ
-Ylm15kpntCibOWiQPZZxGq7Udts20fooccdZqtG/PKFRCPWZ2MOgHAOWDKGk6Kb+
-siqkr55hkxwtiHuwkCcTh/Q2orEIuteSRbbYwgURZwd6dDIQq4ty7reC3j32xphh
-edbnVBoDE6DSdebSS5SJL/gb6LxUdio98XdJPwkaD8292uEODxx0DKw/Ou2e1f5Q
-Iv1WBl+LBaSrZ3sJSFUqoSvCQwBQmMAPoPJ1O13jCnFz1xoNygxUfz2eiKRL5E2l
-VTmTh7zIez4oniOh5MOmDnKMVgTUGP1II2UU5r6PAq2tDpw4lVwyezhyLaBegwMc
-pg/LinbABxUJrP8c8G2tve0yuTAhsir7r+Koo+nAE7FwcuIkD0UTyQcoag2IMS8O
-dKZdYMGXjfUPJRBWg60LfXJeqMyU1oHpDrsRoa5iaYPt7ZApxc41kyynqfuuuIRD
-du8327gd1nJ6ExMxGHY7dYelE4GNkOg3R0+5czykm/RxnGyDuDcO/RcYBJTChN1L
-HYq+dTt0dYPAzBtiXnfuvjDyOsDK5f65pbrDgoOr6AQ4lvDJabcXFsWPrulM9Dyu
-p0Y4+fuwXOCd8cr1Zm34MQIDAQABo2MwYTAdBgNVHQ4EFgQU486X86LMbNNSDw7J
-NcT2U30NrikwHwYDVR0jBBgwFoAU486X86LMbNNSDw7JNcT2U30NrikwDwYDVR0T
-AQH/BAUwAwEB/zAOBgNVHQ8BAf8EBAMCAYYwDQYJKoZIhvcNAQENBQADggIBAN0N
-IPVBv8aaKDHDK9Nsu5fwiGp8GgkAN0B1+D34CbxTuzCDurToVMHCPEdo9tk/AzE4
-Aa1p/kMW9X3XP8IyCFFj+BpEVkBRr9fXTVuh3XRHbyN6tXFbkKWQ/6QeUcnefq2k
-DCpqEGjJQWsujZ4tJKkJl2HLIBZL6FAa/kaDLFHd3LeV1immC66CiN3ieHejCJL1
-zZXiWi8pNxvEanTLPBaBjCw/AAl/owg/ySu2hGZzL0wsFboPrUbo4J+KvL1pvwql
-PCT8AylJKCu+cn/N9zZDtUsgZJQBIq7btoakC3mCSnfVTlcbxfHVef0DbfohFqoV
-ZpdmIuy0/njw7o+2uL/ArPJscPOhNl60ocDbdFIyYvc85oxyts8yMvKDdWV9Bm//
-kl7lv4QUAvjqjb7ZgUhYibVk3Eu6n1MGZOP40l1/mm922/Wcd2n/HZVk/LsJs4tt
-B6DLMDpf5nzeI1Yz/QtDGvNyb4aiJoRV5tQb9KkFfIeSzBS/ORZto4tVHKS37lxV
-d1r8kFyCgpL9KASdahfyLBWCC7awlcOQP1QJA5QoO9u5Feq3lU0VnJF0YCZh8GOy
-py3n1TR6S59eT495BiKDjWnhdVchEa8zMGIW/wFW7EX/LyW2zX3hQsdfnmMWUPVr
-O3nOxjgSfRAfKWQ2Ny1APKcn6I83P5PFLhtO5I12
------END CERTIFICATE-----`
-
-	switch code {
-	case coinBTC:
-		return []*rpc.ServerInfo{{Server: "dev.shiftcrypto.ch:50002", TLS: true, PEMCert: devShiftCA}}
-	case coinTBTC:
-		return []*rpc.ServerInfo{
-			{Server: "s1.dev.shiftcrypto.ch:51003", TLS: true, PEMCert: devShiftCA},
-			{Server: "s2.dev.shiftcrypto.ch:51003", TLS: true, PEMCert: devShiftCA},
+	return defaultConfig()
+}
+
+// defaultConfig returns the default app config. The coins with a dedicated setting get the default
+// servers of their definition, as these settings are edited in the frontend.
+func defaultConfig() config.AppConfig {
+	appConfig := config.NewDefaultConfig()
+	for _, definition := range registry.Definitions() {
+		if _, ok := appConfig.Backend.CoinConfig(definition.Code); ok {
+			appConfig.Backend.SetCoinConfig(
+				definition.Code, config.CoinConfig{ElectrumServers: definition.Servers})
+		}
+	}
+	return appConfig
+}
+
+// coinConfig returns the configuration of a Bitcoin-like coin. Coins which are not configured use
+// the default servers of their definition.
+func coinConfig(appConfig config.AppConfig, code string) config.CoinConfig {
+	if coinConfig, ok := appConfig.Backend.CoinConfig(code); ok {
+		return coinConfig
+	}
+	if definition, ok := registry.Get(code); ok {
+		return config.CoinConfig{ElectrumServers: definition.Servers}
+	}
+	return config.CoinConfig{ElectrumServers: []*rpc.ServerInfo{}}
+}
+
+// accountActive returns whether the account with the given code is enabled. Returns false for
+// unknown accounts.
+func (backend *Backend) accountActive(code string) bool {
+	_, template, ok := registry.Account(code)
+	if !ok {
+		return false
+	}
+	return backend.config.Config().Backend.AccountActive(
+		template.Code, template.ActiveSetting, template.ActiveByDefault)
+}
+
+// decodeBlockchainConfig decodes the configuration of the blockchain backend of a coin.
+func decodeBlockchainConfig(rawConfig json.RawMessage, blockchainConfig interface{}) error {
+	if len(rawConfig) == 0 || string(rawConfig) == "null" {
+		return errp.New("not configured")
+	}
+	return errp.WithStack(json.Unmarshal(rawConfig, blockchainConfig))
+}
+
+// blockchain returns the configured blockchain backend of a Bitcoin-like coin, or nil if the coin
+// is connected to its Electrum servers.
+func (backend *Backend) blockchain(code string, net *chaincfg.Params) (blockchain.Interface, error) {
+	coinConfig := coinConfig(backend.config.Config(), code)
+	switch coinConfig.Blockchain {
+	case "", config.BlockchainElectrum:
+		return nil, nil
+	case config.BlockchainBitcoind:
+		bitcoindConfig := &bitcoind.Config{}
+		if err := decodeBlockchainConfig(coinConfig.Bitcoind, bitcoindConfig); err != nil {
+			return nil, errp.Newf("the bitcoind backend: %v", err)
+		}
+		return bitcoind.NewClient(bitcoindConfig, backend.socksProxy, backend.log), nil
+	case config.BlockchainEsplora:
+		esploraConfig := &esplora.Config{}
+		if err := decodeBlockchainConfig(coinConfig.Esplora, esploraConfig); err != nil {
+			return nil, errp.Newf("the esplora backend: %v", err)
 		}
-	case coinLTC:
-		return []*rpc.ServerInfo{{Server: "dev.shiftcrypto.ch:50004", TLS: true, PEMCert: devShiftCA}}
-	case coinTLTC:
-		return []*rpc.ServerInfo{{Server: "dev.shiftcrypto.ch:51004", TLS: true, PEMCert: devShiftCA}}
+		return esplora.NewClient(esploraConfig, backend.socksProxy, backend.log), nil
+	case config.BlockchainNeutrino:
+		neutrinoConfig := &neutrino.Config{}
+		if err := decodeBlockchainConfig(coinConfig.Neutrino, neutrinoConfig); err != nil {
+			return nil, errp.Newf("the neutrino backend: %v", err)
+		}
+		if !neutrino.IsSupported(net) {
+			return nil, errp.New("the neutrino backend only supports Bitcoin")
+		}
+		return neutrino.NewClient(neutrinoConfig, net, backend.socksProxy, backend.log), nil
 	default:
-		panic(errp.Newf("The given code %s is unknown.", code))
+		return nil, errp.Newf("unknown blockchain backend %s", coinConfig.Blockchain)
 	}
 }
 
-func (backend *Backend) defaultElectrumXServers(code string) []*rpc.ServerInfo {
-	if backend.arguments.DevMode() {
-		return defaultDevServers(code)
+// defaultElectrumXServers returns the configured servers of a Bitcoin-like coin, or the dev servers
+// in dev mode.
+func (backend *Backend) defaultElectrumXServers(definition *registry.Definition) []*rpc.ServerInfo {
+	if backend.arguments.DevMode() && len(definition.DevServers) > 0 {
+		return definition.DevServers
 	}
+	return coinConfig(backend.config.Config(), definition.Code).ElectrumServers
+}
 
-	return backend.defaultProdServers(code)
+// utxoParams returns the chain params of a Bitcoin-like coin. Signet coins use the params of the
+// custom signet if a challenge is configured.
+func (backend *Backend) utxoParams(definition *registry.Definition) (*chaincfg.Params, error) {
+	challengeHex := coinConfig(backend.config.Config(), definition.Code).SignetChallenge
+	if !signet.IsSignet(definition.UTXOParams) || challengeHex == "" {
+		return definition.UTXOParams, nil
+	}
+	challenge, err := hex.DecodeString(challengeHex)
+	if err != nil || len(challenge) == 0 {
+		return nil, errp.Newf("invalid signet challenge %s", challengeHex)
+	}
+	return signet.NewParams(challenge), nil
 }
 
 // Coin returns a Coin instance for a coin type.
@@ -258,101 +360,200 @@ func (backend *Backend) Coin(code string) coin.Coin {
 	if ok {
 		return coin
 	}
-	dbFolder := backend.arguments.CacheDirectoryPath()
-	switch code {
-	case "rbtc":
-		servers := []*rpc.ServerInfo{{Server: "127.0.0.1:52001", TLS: false, PEMCert: ""}}
-		coin = btc.NewCoin("rbtc", "RBTC", &chaincfg.RegressionNetParams, dbFolder, servers, "", nil)
-	case coinTBTC:
-		servers := backend.defaultElectrumXServers(code)
-		coin = btc.NewCoin(coinTBTC, "TBTC", &chaincfg.TestNet3Params, dbFolder, servers,
-			"https://testnet.blockchain.info/tx/", backend.ratesUpdater)
-	case coinBTC:
-		servers := backend.defaultElectrumXServers(code)
-		coin = btc.NewCoin(coinBTC, "BTC", &chaincfg.MainNetParams, dbFolder, servers,
-			"https://blockchain.info/tx/", backend.ratesUpdater)
-	case coinTLTC:
-		servers := backend.defaultElectrumXServers(code)
-		coin = btc.NewCoin(coinTLTC, "TLTC", &ltc.TestNet4Params, dbFolder, servers,
-			"http://explorer.litecointools.com/tx/", backend.ratesUpdater)
-	case coinLTC:
-		servers := backend.defaultElectrumXServers(code)
-		coin = btc.NewCoin(coinLTC, "LTC", &ltc.MainNetParams, dbFolder, servers,
-			"https://insight.litecore.io/tx/", backend.ratesUpdater)
-	case coinETH:
-		coin = eth.NewCoin(code, params.MainnetChainConfig, "https://etherscan.io/address/")
-	case coinTETH:
-		coin = eth.NewCoin(code, params.TestnetChainConfig, "https://ropsten.etherscan.io/address/")
-	default:
+	definition, ok := registry.Get(code)
+	if !ok {
 		panic(errp.Newf("unknown coin code %s", code))
 	}
-	coin.Init()
+	switch definition.Type {
+	case registry.TypeUTXO:
+		ratesUpdater := backend.ratesUpdater
+		if definition.RatesUnit == "" {
+			ratesUpdater = nil
+		}
+		net, err := backend.utxoParams(definition)
+		if err != nil {
+			backend.log.WithError(err).Error("Falling back to the default chain params")
+			net = definition.UTXOParams
+		}
+		btcCoin := btc.NewCoin(code, definition.Unit, net,
+			backend.arguments.CacheDirectoryPath(), backend.defaultElectrumXServers(definition),
+			backend.socksProxy, definition.BlockExplorerTxPrefix, ratesUpdater)
+		btcCoin.SetCertificatePinner(&certificatePinner{backend: backend, coinCode: code})
+		blockchain, err := backend.blockchain(code, net)
+		if err != nil {
+			backend.log.WithField("coin", code).WithError(err).
+				Error("Falling back to the Electrum servers")
+		} else if blockchain != nil {
+			btcCoin.SetBlockchain(blockchain)
+		}
+		coin = btcCoin
+	case registry.TypeETH:
+		coin = eth.NewCoin(code, definition.ETHParams, definition.ETHNodeURL,
+			definition.ETHNodeHTTPURL, backend.socksProxy, definition.BlockExplorerTxPrefix)
+	default:
+		panic(errp.Newf("unknown coin type %s", definition.Type))
+	}
+	// Observe before Init to forward the events fired while initializing, e.g. a reset of the
+	// headers database.
 	coin.Observe(func(event observable.Event) { backend.events <- event })
+	coin.Init()
 	backend.coins[code] = coin
 	return coin
 }
 
+// network returns the network of the coins available with the current arguments.
+func (backend *Backend) network() registry.Network {
+	switch {
+	case !backend.arguments.Testing():
+		return registry.NetworkMainnet
+	case backend.arguments.Regtest():
+		return registry.NetworkRegtest
+	default:
+		return registry.NetworkTestnet
+	}
+}
+
+// initAccounts replaces the accounts by the ones of the registered keystores. accountsInitLock must
+// be held.
 func (backend *Backend) initAccounts() {
 	// Since initAccounts replaces all previous accounts, we need to properly close them first.
 	backend.uninitAccounts()
 	defer backend.accountsLock.Lock()()
 
 	backend.accounts = []btc.Interface{}
-	if backend.arguments.Testing() {
-		if backend.arguments.Regtest() {
-			RBTC := backend.Coin("rbtc")
-			backend.addAccount(RBTC, "rbtc-p2pkh", "Bitcoin Regtest Legacy", "m/44'/1'/0'",
-				signing.ScriptTypeP2PKH)
-			backend.addAccount(RBTC, "rbtc-p2wpkh-p2sh", "Bitcoin Regtest Segwit", "m/49'/1'/0'",
-				signing.ScriptTypeP2WPKHP2SH)
-		} else {
-			TBTC := backend.Coin(coinTBTC)
-			backend.addAccount(TBTC, "tbtc-p2wpkh-p2sh", "Bitcoin Testnet", "m/49'/1'/0'",
-				signing.ScriptTypeP2WPKHP2SH)
-			backend.addAccount(TBTC, "tbtc-p2wpkh", "Bitcoin Testnet: bech32", "m/84'/1'/0'",
-				signing.ScriptTypeP2WPKH)
-			backend.addAccount(TBTC, "tbtc-p2pkh", "Bitcoin Testnet Legacy", "m/44'/1'/0'",
-				signing.ScriptTypeP2PKH)
-
-			TLTC := backend.Coin(coinTLTC)
-			backend.addAccount(TLTC, "tltc-p2wpkh-p2sh", "Litecoin Testnet", "m/49'/1'/0'",
-				signing.ScriptTypeP2WPKHP2SH)
-			backend.addAccount(TLTC, "tltc-p2wpkh", "Litecoin Testnet: bech32", "m/84'/1'/0'",
-				signing.ScriptTypeP2WPKH)
-
-			if backend.arguments.DevMode() {
-				teth := backend.Coin(coinTETH)
-				backend.addAccount(teth, "teth", "Ethereum Testnet", "m/44'/1'/0'/0/0", signing.ScriptTypeP2WPKH)
+	for _, definition := range registry.Definitions() {
+		if definition.Network != backend.network() ||
+			(definition.DevModeOnly && !backend.arguments.DevMode()) {
+			continue
+		}
+		switch definition.Type {
+		case registry.TypeUTXO:
+			coin := backend.Coin(definition.Code)
+			for _, template := range definition.Accounts {
+				backend.addAccount(coin, template.Code, template.Name, template.Keypath,
+					template.ScriptType)
+			}
+		case registry.TypeETH:
+			for _, template := range definition.Accounts {
+				backend.initETHAccounts(definition, template)
 			}
 		}
-	} else {
-		BTC := backend.Coin(coinBTC)
-		backend.addAccount(BTC, "btc-p2wpkh-p2sh", "Bitcoin", "m/49'/0'/0'",
-			signing.ScriptTypeP2WPKHP2SH)
-		backend.addAccount(BTC, "btc-p2wpkh", "Bitcoin: bech32", "m/84'/0'/0'",
-			signing.ScriptTypeP2WPKH)
-		backend.addAccount(BTC, "btc-p2pkh", "Bitcoin Legacy", "m/44'/0'/0'",
-			signing.ScriptTypeP2PKH)
+	}
+	for _, account := range backend.accounts {
+		backend.onAccountInit(account)
+	}
+}
 
-		LTC := backend.Coin(coinLTC)
-		backend.addAccount(LTC, "ltc-p2wpkh-p2sh", "Litecoin", "m/49'/2'/0'",
-			signing.ScriptTypeP2WPKHP2SH)
-		backend.addAccount(LTC, "ltc-p2wpkh", "Litecoin: bech32", "m/84'/2'/0'",
+func (backend *Backend) setETHAccountsCount(coinCode string, accountsCount int) error {
+	appConfig := backend.config.Config()
+	ethConfig := appConfig.Backend.ETHConfig(coinCode)
+	ethConfig.AccountsCount = accountsCount
+	appConfig.Backend.SetETHConfig(coinCode, ethConfig)
+	return backend.config.Set(appConfig)
+}
+
+// ethAccountKeypath returns the keypath of the Ethereum account with the given index, according to
+// the configured derivation scheme.
+func (backend *Backend) ethAccountKeypath(
+	definition *registry.Definition, index uint32) (signing.AbsoluteKeypath, error) {
+	ethConfig := backend.config.Config().Backend.ETHConfig(definition.Code)
+	return eth.DerivationScheme(ethConfig.DerivationScheme).Keypath(definition.BIP44CoinType, index)
+}
+
+// initETHAccounts adds the configured number of Ethereum accounts of the template, and starts
+// looking for further used accounts in the background. The first account is coded by the template
+// code (e.g. eth), the following ones by their account index (e.g. eth-1, eth-2, ...).
+func (backend *Backend) initETHAccounts(
+	definition *registry.Definition, template registry.AccountTemplate) {
+	ethConfig := backend.config.Config().Backend.ETHConfig(definition.Code)
+	ethCoin := backend.Coin(definition.Code)
+	for index := 0; index < ethConfig.AccountsCount || index == 0; index++ {
+		keypath, err := backend.ethAccountKeypath(definition, uint32(index))
+		if err != nil {
+			backend.log.WithError(err).Error("Could not init the Ethereum accounts")
+			return
+		}
+		accountName := template.Name
+		if index > 0 {
+			accountName = fmt.Sprintf("%s %d", template.Name, index+1)
+		}
+		accountCode := template.Code
+		if index > 0 {
+			accountCode = fmt.Sprintf("%s-%d", template.Code, index)
+		}
+		backend.addAccount(ethCoin, accountCode, accountName, keypath.Encode(),
 			signing.ScriptTypeP2WPKH)
-
-		if backend.arguments.DevMode() {
-			eth := backend.Coin(coinETH)
-			backend.addAccount(eth, "eth", "Ethereum", "m/44'/60'/0'/0/0", signing.ScriptTypeP2WPKH)
+	}
+	go backend.discoverETHAccounts(definition, backend.keystores)
+}
+
+// discoverETHAccounts looks for used Ethereum accounts of the given keystores after the configured
+// ones. If there are any, they are added, unless the keystores were replaced in the meantime.
+func (backend *Backend) discoverETHAccounts(
+	definition *registry.Definition, keystores keystore.Keystores) {
+	ethConfig := backend.config.Config().Backend.ETHConfig(definition.Code)
+	accountsCount, err := backend.Coin(definition.Code).(*eth.Coin).DiscoverAccounts(
+		uint32(ethConfig.AccountsCount),
+		func(index uint32) (*signing.Configuration, error) {
+			keypath, err := backend.ethAccountKeypath(definition, index)
+			if err != nil {
+				return nil, err
+			}
+			return keystores.Configuration(signing.ScriptTypeP2WPKH, keypath, keystores.Count())
+		})
+	if err != nil {
+		backend.log.WithError(err).Error("Ethereum account discovery failed")
+		return
+	}
+	if int(accountsCount) <= ethConfig.AccountsCount {
+		return
+	}
+	added := func() bool {
+		defer backend.accountsInitLock.Lock()()
+		if keystores != backend.keystores {
+			return false
+		}
+		backend.log.WithField("coin", definition.Code).WithField("accounts", accountsCount).
+			Info("Discovered Ethereum accounts")
+		if err := backend.setETHAccountsCount(definition.Code, int(accountsCount)); err != nil {
+			backend.log.WithError(err).Error("Could not store the discovered Ethereum accounts")
+			return false
 		}
+		backend.initAccounts()
+		return true
+	}()
+	if added {
+		backend.events <- backendEvent{Type: "backend", Data: "accountsStatusChanged"}
 	}
-	for _, account := range backend.accounts {
-		backend.onAccountInit(account)
+}
+
+// AddETHAccount adds the next Ethereum account of the given coin.
+func (backend *Backend) AddETHAccount(coinCode string) error {
+	if definition, ok := registry.Get(coinCode); !ok || definition.Type != registry.TypeETH {
+		return errp.Newf("%s is not an Ethereum coin", coinCode)
+	}
+	err := func() error {
+		defer backend.accountsInitLock.Lock()()
+		if backend.keystores.Count() == 0 {
+			return errp.New("no keystore registered")
+		}
+		ethConfig := backend.config.Config().Backend.ETHConfig(coinCode)
+		if err := backend.setETHAccountsCount(coinCode, ethConfig.AccountsCount+1); err != nil {
+			return err
+		}
+		backend.initAccounts()
+		return nil
+	}()
+	if err != nil {
+		return err
 	}
+	backend.events <- backendEvent{Type: "backend", Data: "accountsStatusChanged"}
+	return nil
 }
 
 // AccountsStatus returns whether the accounts have been initialized.
 func (backend *Backend) AccountsStatus() string {
-	if backend.keystores.Count() > 0 {
+	if backend.Keystores().Count() > 0 {
 		return "initialized"
 	}
 	return "uninitialized"
@@ -424,6 +625,7 @@ func (backend *Backend) DevicesRegistered() []string {
 	return deviceIDs
 }
 
+// uninitAccounts closes the accounts. accountsInitLock must be held.
 func (backend *Backend) uninitAccounts() {
 	defer backend.accountsLock.Lock()()
 	for _, account := range backend.accounts {
@@ -436,27 +638,38 @@ func (backend *Backend) uninitAccounts() {
 
 // Keystores returns the keystores registered at this backend.
 func (backend *Backend) Keystores() keystore.Keystores {
+	defer backend.accountsInitLock.RLock()()
 	return backend.keystores
 }
 
 // RegisterKeystore registers the given keystore at this backend.
 func (backend *Backend) RegisterKeystore(keystore keystore.Keystore) {
 	backend.log.Info("registering keystore")
-	if err := backend.keystores.Add(keystore); err != nil {
-		backend.log.Panic("Failed to add a keystore.", err)
-	}
-	if backend.arguments.Multisig() && backend.keystores.Count() != 2 {
+	initialized := func() bool {
+		defer backend.accountsInitLock.Lock()()
+		if err := backend.keystores.Add(keystore); err != nil {
+			backend.log.Panic("Failed to add a keystore.", err)
+		}
+		if backend.arguments.Multisig() && backend.keystores.Count() != 2 {
+			return false
+		}
+		backend.initAccounts()
+		return true
+	}()
+	if !initialized {
 		return
 	}
-	backend.initAccounts()
 	backend.events <- backendEvent{Type: "backend", Data: "accountsStatusChanged"}
 }
 
 // DeregisterKeystore removes the registered keystore.
 func (backend *Backend) DeregisterKeystore() {
 	backend.log.Info("deregistering keystore")
-	backend.keystores = keystore.NewKeystores()
-	backend.uninitAccounts()
+	func() {
+		defer backend.accountsInitLock.Lock()()
+		backend.keystores = keystore.NewKeystores()
+		backend.uninitAccounts()
+	}()
 	backend.events <- backendEvent{Type: "backend", Data: "accountsStatusChanged"}
 }
 
@@ -481,13 +694,15 @@ func (backend *Backend) Register(theDevice device.Interface) error {
 			// 	[]*hdkeychain.ExtendedKey{extendedPublicKey}, 1)
 			if backend.arguments.Multisig() {
 				backend.RegisterKeystore(
-					theDevice.KeystoreForConfiguration(nil, backend.keystores.Count()))
+					theDevice.KeystoreForConfiguration(nil, backend.Keystores().Count()))
 			} else if mainKeystore {
 				// HACK: for device based, only one is supported at the moment.
-				backend.keystores = keystore.NewKeystores()
+				func() {
+					defer backend.accountsInitLock.Lock()()
+					backend.keystores = keystore.NewKeystores()
+				}()
 
-				backend.RegisterKeystore(
-					theDevice.KeystoreForConfiguration(nil, backend.keystores.Count()))
+				backend.RegisterKeystore(theDevice.KeystoreForConfiguration(nil, 0))
 			}
 		}
 		backend.events <- deviceEvent{
@@ -518,7 +733,9 @@ func (backend *Backend) Deregister(deviceID string) {
 }
 
 func (backend *Backend) listenHID() {
-	usb.NewManager(backend.arguments.MainDirectoryPath(), backend.Register, backend.Deregister).ListenHID()
+	usb.NewManager(
+		backend.arguments.MainDirectoryPath(), backend.socksProxy,
+		backend.Register, backend.Deregister).ListenHID()
 }
 
 // Rates return the latest rates.
@@ -526,10 +743,34 @@ func (backend *Backend) Rates() map[string]map[string]float64 {
 	return backend.ratesUpdater.Last()
 }
 
+// ratesUnit returns the unit under which the exchange rates of the coin with the given code are
+// listed, empty if the coin has no exchange rates.
+func ratesUnit(coinCode string) string {
+	definition, ok := registry.Get(coinCode)
+	if !ok {
+		return ""
+	}
+	return definition.RatesUnit
+}
+
+// HistoricalRate returns the exchange rate of the coin with the given code in the given fiat
+// currency at the end of the day (UTC) of the given time.
+func (backend *Backend) HistoricalRate(coinCode string, fiat string, t time.Time) (float64, error) {
+	unit := ratesUnit(coinCode)
+	if unit == "" {
+		return 0, errp.Newf("the coin %s has no exchange rates", coinCode)
+	}
+	return backend.ratesUpdater.HistoricalRate(unit, fiat, t)
+}
+
 // DownloadCert downloads the first element of the remote certificate chain.
 func (backend *Backend) DownloadCert(server string) (string, error) {
 	var pemCert []byte
-	conn, err := tls.Dial("tcp", server, &tls.Config{
+	tcpConn, err := backend.socksProxy.Dial("tcp", server)
+	if err != nil {
+		return "", err
+	}
+	conn := tls.Client(tcpConn, &tls.Config{
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 			if len(rawCerts) == 0 {
 				return errp.New("no remote certs")
@@ -545,18 +786,107 @@ func (backend *Backend) DownloadCert(server string) (string, error) {
 		},
 		InsecureSkipVerify: true,
 	})
-	if err != nil {
+	defer func() { _ = conn.Close() }()
+	if err := conn.Handshake(); err != nil {
 		return "", err
 	}
-	_ = conn.Close()
 	return string(pemCert), nil
 }
 
+// certificatePinner stores the certificates of the Electrum servers of a coin which are verified
+// with rpc.VerificationTOFU in the config.
+type certificatePinner struct {
+	backend  *Backend
+	coinCode string
+
+	reportedLock locker.Locker
+	// reported maps each server to the changed fingerprint which was reported last, so that the
+	// change is reported only once and not on every reconnection attempt.
+	reported map[string]string
+}
+
+// PinnedFingerprint implements electrum.CertificatePinner.
+func (pinner *certificatePinner) PinnedFingerprint(server string) string {
+	coinConfig := coinConfig(pinner.backend.config.Config(), pinner.coinCode)
+	for _, serverInfo := range coinConfig.ElectrumServers {
+		if serverInfo.Server == server && serverInfo.Verification == rpc.VerificationTOFU {
+			return serverInfo.Fingerprint
+		}
+	}
+	return ""
+}
+
+// PinFingerprint implements electrum.CertificatePinner.
+func (pinner *certificatePinner) PinFingerprint(server string, fingerprint string) error {
+	pinner.backend.log.WithFields(logrus.Fields{"coin": pinner.coinCode, "server": server}).
+		Infof("Pinning certificate %s", fingerprint)
+	return pinner.backend.setCertificateFingerprint(pinner.coinCode, server, fingerprint)
+}
+
+// CertificateChanged implements electrum.CertificatePinner.
+func (pinner *certificatePinner) CertificateChanged(err *electrum.CertificateChangedError) {
+	alreadyReported := func() bool {
+		defer pinner.reportedLock.Lock()()
+		if pinner.reported == nil {
+			pinner.reported = map[string]string{}
+		}
+		if pinner.reported[err.Server] == err.Fingerprint {
+			return true
+		}
+		pinner.reported[err.Server] = err.Fingerprint
+		return false
+	}
+	if alreadyReported() {
+		return
+	}
+	pinner.backend.log.WithField("coin", pinner.coinCode).WithError(err).Error("Certificate changed")
+	pinner.backend.events <- observable.Event{
+		Subject: fmt.Sprintf("coins/%s/servers/certificateChanged", pinner.coinCode),
+		Action:  action.Replace,
+		Object:  err,
+	}
+}
+
+// setCertificateFingerprint sets the pinned certificate fingerprint of an Electrum server of a coin
+// which is verified with rpc.VerificationTOFU.
+func (backend *Backend) setCertificateFingerprint(coinCode, server, fingerprint string) error {
+	appConfig := backend.config.Config()
+	coinConfig := coinConfig(appConfig, coinCode)
+	found := false
+	// The server infos are shared with the stored config, so they are copied before modifying them.
+	servers := make([]*rpc.ServerInfo, len(coinConfig.ElectrumServers))
+	for i, serverInfo := range coinConfig.ElectrumServers {
+		serverInfoCopy := *serverInfo
+		if serverInfo.Server == server && serverInfo.Verification == rpc.VerificationTOFU {
+			serverInfoCopy.Fingerprint = fingerprint
+			found = true
+		}
+		servers[i] = &serverInfoCopy
+	}
+	if !found {
+		return errp.Newf("coin %s: no server %s with certificate pinning", coinCode, server)
+	}
+	coinConfig.ElectrumServers = servers
+	appConfig.Backend.SetCoinConfig(coinCode, coinConfig)
+	return backend.config.Set(appConfig)
+}
+
+// ApproveCertificate replaces the pinned certificate of an Electrum server of a coin, after its
+// certificate changed. The next connection to the server accepts the certificate with the given
+// fingerprint.
+func (backend *Backend) ApproveCertificate(coinCode, server, fingerprint string) error {
+	if _, ok := registry.Get(coinCode); !ok {
+		return errp.Newf("unknown coin code %s", coinCode)
+	}
+	return backend.setCertificateFingerprint(coinCode, server, fingerprint)
+}
+
 // CheckElectrumServer checks if a tls connection can be established with the electrum server, and
 // whether the server is an electrum server.
 func (backend *Backend) CheckElectrumServer(server string, pemCert string) error {
 	backends := []rpc.Backend{
-		electrum.NewElectrum(backend.log, &rpc.ServerInfo{Server: server, TLS: true, PEMCert: pemCert}),
+		electrum.NewElectrum(backend.log,
+			&rpc.ServerInfo{Server: server, TLS: true, PEMCert: pemCert}, backend.socksProxy, nil),
 	}
 	conn, err := backends[0].EstablishConnection()
 	if err != nil {
@@ -567,6 +897,6 @@ func (backend *Backend) CheckElectrumServer(server string, pemCert string) error
 	jsonrpcClient := jsonrpc.NewRPCClient(backends, backend.log)
 	electrumClient := client.NewElectrumClient(jsonrpcClient, backend.log)
 	defer electrumClient.Close()
-	_, err = electrumClient.ServerVersion()
+	_, err = electrumClient.ServerFeatures()
 	return err
 }
@@ -0,0 +1,92 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/invoices"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateChangedOnce(t *testing.T) {
+	backend := &Backend{
+		events: make(chan interface{}, 10),
+		log:    logging.Get().WithGroup("backend_test"),
+	}
+	pinner := &certificatePinner{backend: backend, coinCode: "tbtc"}
+	changed := func(server, fingerprint string) *electrum.CertificateChangedError {
+		return &electrum.CertificateChangedError{
+			Server:            server,
+			PinnedFingerprint: "pinned",
+			Fingerprint:       fingerprint,
+		}
+	}
+
+	// Reconnecting to a server with the same changed certificate is reported only once.
+	pinner.CertificateChanged(changed("a", "new"))
+	pinner.CertificateChanged(changed("a", "new"))
+	pinner.CertificateChanged(changed("b", "new"))
+	pinner.CertificateChanged(changed("a", "newer"))
+	require.Len(t, backend.events, 3)
+	for _, expected := range []*electrum.CertificateChangedError{
+		changed("a", "new"), changed("b", "new"), changed("a", "newer"),
+	} {
+		event := (<-backend.events).(observable.Event)
+		require.Equal(t, "coins/tbtc/servers/certificateChanged", event.Subject)
+		require.Equal(t, expected, event.Object)
+	}
+}
+
+func TestReservedAddresses(t *testing.T) {
+	store, err := invoices.NewStore(test.TstTempFile("invoices"))
+	require.NoError(t, err)
+	now := time.Now()
+	add := func(scriptHashHex blockchain.ScriptHashHex, received int64, expires time.Time) {
+		require.NoError(t, store.Add(&invoices.Invoice{
+			AccountCode:   "tbtc-p2wpkh",
+			ScriptHashHex: scriptHashHex,
+			Amount:        100,
+			Received:      btcutil.Amount(received),
+			Expires:       expires,
+		}, expires.Add(-time.Hour)))
+	}
+	add("expired", 0, now.Add(-time.Minute))
+	add("partially-paid", 10, now.Add(-time.Minute))
+	add("unpaid", 0, now.Add(time.Hour))
+	backend := &Backend{
+		invoices: store,
+		events:   make(chan interface{}, 10),
+		log:      logging.Get().WithGroup("backend_test"),
+	}
+	require.Equal(t,
+		[]blockchain.ScriptHashHex{"expired", "partially-paid", "unpaid"},
+		backend.reservedAddresses("tbtc-p2wpkh"))
+
+	// Invoices which expired unpaid do not reserve their address anymore.
+	backend.updateInvoices()
+	require.Len(t, backend.events, 2)
+	require.Equal(t,
+		[]blockchain.ScriptHashHex{"partially-paid", "unpaid"},
+		backend.reservedAddresses("tbtc-p2wpkh"))
+	require.Empty(t, backend.reservedAddresses("tbtc-p2pkh"))
+}
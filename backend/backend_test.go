@@ -0,0 +1,169 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/arguments"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/signet"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/registry"
+	registrytest "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/registry/test"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+var fakeDefinition = func() *registry.Definition {
+	definition := registrytest.FakeDefinition("fake", "fake-p2wpkh", "fake-p2pkh")
+	definition.Accounts[1].ActiveByDefault = false
+	return definition
+}()
+
+// fakeETHDefinition is a regtest Ethereum coin whose node can't be reached.
+var fakeETHDefinition = &registry.Definition{
+	Code:          "feth",
+	Unit:          "FETH",
+	Type:          registry.TypeETH,
+	Network:       registry.NetworkRegtest,
+	ETHParams:     params.TestnetChainConfig,
+	ETHNodeURL:    "http://127.0.0.1:1",
+	BIP44CoinType: 1,
+	Accounts: []registry.AccountTemplate{
+		{Code: "feth", Name: "Fake Ethereum", ActiveByDefault: true},
+	},
+}
+
+func init() {
+	registry.MustRegister(fakeDefinition)
+	registry.MustRegister(fakeETHDefinition)
+}
+
+func TestRegisteredCoin(t *testing.T) {
+	theBackend := backend.NewBackend(arguments.NewArguments(
+		test.TstTempDir("bitbox-wallet-backend-"), true, true, false, false))
+	theBackend.OnAccountInit(func(btc.Interface) {})
+	theBackend.OnAccountUninit(func(btc.Interface) {})
+
+	fakeCoin, ok := theBackend.Coin("fake").(*btc.Coin)
+	require.True(t, ok)
+	require.Equal(t, "FAKE", fakeCoin.Unit())
+	require.Equal(t, fakeDefinition.UTXOParams, fakeCoin.Net())
+
+	theBackend.RegisterKeystore(software.NewKeystoreFromPIN(0, "1234"))
+	codes := []string{}
+	for _, account := range theBackend.Accounts() {
+		codes = append(codes, account.Code())
+	}
+	// Only the active accounts of the regtest coins are added.
+	require.Equal(t, []string{"rbtc-p2wpkh-p2sh", "fake-p2wpkh", "feth"}, codes)
+}
+
+func TestETHAccounts(t *testing.T) {
+	theBackend := backend.NewBackend(arguments.NewArguments(
+		test.TstTempDir("bitbox-wallet-backend-"), true, true, false, false))
+	theBackend.OnAccountInit(func(btc.Interface) {})
+	theBackend.OnAccountUninit(func(btc.Interface) {})
+	go func() {
+		for range theBackend.Events() {
+		}
+	}()
+	require.Error(t, theBackend.AddETHAccount("feth"), "no keystore registered")
+
+	theBackend.RegisterKeystore(software.NewKeystoreFromPIN(0, "1234"))
+	require.NoError(t, theBackend.AddETHAccount("feth"))
+	ethCodes := func() []string {
+		codes := []string{}
+		for _, account := range theBackend.Accounts() {
+			if account.Coin().Code() == "FETH" {
+				codes = append(codes, account.Code())
+			}
+		}
+		return codes
+	}
+	// The first account keeps the code of the template.
+	require.Equal(t, []string{"feth", "feth-1"}, ethCodes())
+
+	// Adding accounts is serialized with replacing the keystores.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = theBackend.AddETHAccount("feth")
+		}()
+		go func() {
+			defer wg.Done()
+			theBackend.DeregisterKeystore()
+			theBackend.RegisterKeystore(software.NewKeystoreFromPIN(0, "1234"))
+		}()
+	}
+	wg.Wait()
+	require.Len(t, ethCodes(), theBackend.Config().Config().Backend.ETHConfig("feth").AccountsCount)
+}
+
+func TestSignetChallenge(t *testing.T) {
+	backendArguments := arguments.NewArguments(
+		test.TstTempDir("bitbox-wallet-backend-"), true, false, false, false)
+	theBackend := backend.NewBackend(backendArguments)
+	appConfig := theBackend.Config().Config()
+	appConfig.Backend.Coins = map[string]config.CoinConfig{
+		"tbtc-signet": {ElectrumServers: []*rpc.ServerInfo{}, SignetChallenge: "51"},
+	}
+	require.NoError(t, theBackend.Config().Set(appConfig))
+
+	signetCoin, ok := theBackend.Coin("tbtc-signet").(*btc.Coin)
+	require.True(t, ok)
+	require.True(t, signet.IsSignet(signetCoin.Net()))
+	require.Equal(t, signet.Net([]byte{0x51}), signetCoin.Net().Net)
+	require.NotEqual(t, signet.DefaultParams.Net, signetCoin.Net().Net)
+	// The headers of different signets are stored separately.
+	_, err := os.Stat(filepath.Join(backendArguments.CacheDirectoryPath(),
+		fmt.Sprintf("headers-tbtc-signet-%08x.db", uint32(signet.Net([]byte{0x51})))))
+	require.NoError(t, err)
+}
+
+func TestBlockchainFallback(t *testing.T) {
+	for _, coinConfig := range []config.CoinConfig{
+		{Blockchain: config.BlockchainBitcoind},
+		{Blockchain: config.BlockchainEsplora},
+		{Blockchain: config.BlockchainEsplora, Esplora: []byte(`"invalid"`)},
+		{Blockchain: config.BlockchainNeutrino},
+		{Blockchain: "unknown"},
+	} {
+		theBackend := backend.NewBackend(arguments.NewArguments(
+			test.TstTempDir("bitbox-wallet-backend-"), true, true, false, false))
+		appConfig := theBackend.Config().Config()
+		appConfig.Backend.SetCoinConfig("fake", coinConfig)
+		require.NoError(t, theBackend.Config().Set(appConfig))
+
+		// The coin falls back to its Electrum servers.
+		fakeCoin, ok := theBackend.Coin("fake").(*btc.Coin)
+		require.True(t, ok)
+		_, ok = fakeCoin.Blockchain().(*client.ElectrumClient)
+		require.True(t, ok, coinConfig.Blockchain)
+	}
+}
@@ -15,6 +15,7 @@
 package btc
 
 import (
+	"errors"
 	"fmt"
 	"path"
 	"sort"
@@ -37,6 +38,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/transactionsdb"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/labels"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 )
@@ -46,6 +48,11 @@ const (
 	changeGapLimit = 6
 )
 
+// ErrUnsupported is returned by the methods of Interface which an account does not support, e.g.
+// the ones based on the transaction history for Ethereum accounts, whose transactions are not
+// indexed.
+var ErrUnsupported = errors.New("not supported by this account")
+
 // Interface is the API of a Account.
 type Interface interface {
 	Info() *Info
@@ -59,7 +66,14 @@ type Interface interface {
 	Offline() bool
 	Close()
 	Transactions() []*transactions.TxInfo
+	// QueryTransactions returns the transactions matching the query, one page at a time.
+	QueryTransactions(*transactions.TxQuery) (*transactions.TxQueryResult, error)
+	// SetTxLabel sets the label of a transaction. An empty label removes it.
+	SetTxLabel(txID string, label string) error
 	Balance() *transactions.Balance
+	// BalanceHistory returns the running balance after each confirmed transaction, ordered by
+	// height. Returns ErrUnsupported if the account has no transaction history.
+	BalanceHistory() ([]*coin.BalancePoint, error)
 	// Creates, signs and broadcasts a transaction. Returns keystore.ErrSigningAborted on user
 	// abort.
 	SendTx(string, coin.SendAmount, FeeTargetCode, map[wire.OutPoint]struct{}) error
@@ -90,17 +104,27 @@ type Account struct {
 
 	receiveAddresses *addresses.AddressChain
 	changeAddresses  *addresses.AddressChain
+	// reservedAddresses are the receive addresses which were handed out for a specific purpose,
+	// e.g. an invoice. They are not returned by GetUnusedReceiveAddresses().
+	reservedAddresses map[blockchain.ScriptHashHex]struct{}
 
 	transactions *transactions.Transactions
 	headers      headers.Interface
+	// labels stores the labels of the transactions. It is nil if they could not be loaded.
+	labels *labels.Store
 
 	synchronizer *synchronizer.Synchronizer
 
 	feeTargets []*FeeTarget
 
+	// syncStateLock guards initialSyncDone and offline. It is not the account lock, as the sync can
+	// finish while the account lock is held.
+	syncStateLock   locker.Locker
 	initialSyncDone bool
 	offline         bool
 	onEvent         func(Event)
+	txNotifier      *txNotifier
+	onTxEvent       func(*TxEvent)
 	log             *logrus.Entry
 }
 
@@ -121,7 +145,9 @@ const (
 	OfflineMode Status = "offlineMode"
 )
 
-// NewAccount creats a new Account.
+// NewAccount creats a new Account. onTxEvent is called with the TxEvents after each sync, where
+// confirmationThresholds are the numbers of confirmations which are notified about. The labels of
+// the transactions are stored in txLabels, which can be nil if they are not available.
 func NewAccount(
 	coin *Coin,
 	dbFolder string,
@@ -129,7 +155,10 @@ func NewAccount(
 	name string,
 	getSigningConfiguration func() (*signing.Configuration, error),
 	keystores keystore.Keystores,
+	txLabels *labels.Store,
 	onEvent func(Event),
+	confirmationThresholds []int,
+	onTxEvent func(*TxEvent),
 	log *logrus.Entry,
 ) *Account {
 	log = log.WithField("group", "btc").
@@ -144,6 +173,7 @@ func NewAccount(
 		getSigningConfiguration: getSigningConfiguration,
 		signingConfiguration:    nil,
 		keystores:               keystores,
+		labels:                  txLabels,
 
 		// feeTargets must be sorted by ascending priority.
 		feeTargets: []*FeeTarget{
@@ -153,19 +183,30 @@ func NewAccount(
 			{Blocks: 2, Code: FeeTargetCodeHigh},
 		},
 		// initializing to false, to prevent flashing of offline notification in the frontend
-		offline:         false,
-		initialSyncDone: false,
-		onEvent:         onEvent,
-		log:             log,
+		offline:           false,
+		initialSyncDone:   false,
+		onEvent:           onEvent,
+		onTxEvent:         onTxEvent,
+		reservedAddresses: map[blockchain.ScriptHashHex]struct{}{},
+		log:               log,
 	}
+	account.txNotifier = newTxNotifier(
+		code, coin.Unit(), account.formatAmount, confirmationThresholds)
 	account.synchronizer = synchronizer.NewSynchronizer(
 		func() { onEvent(EventSyncStarted) },
 		func() {
-			if !account.initialSyncDone {
+			firstSync := func() bool {
+				defer account.syncStateLock.Lock()()
+				firstSync := !account.initialSyncDone
 				account.initialSyncDone = true
+				return firstSync
+			}()
+			if firstSync {
 				onEvent(EventStatusChanged)
 			}
 			onEvent(EventSyncDone)
+			// The transactions can only be read once the sync is done, which is after this callback.
+			go account.notifyTxEvents()
 		},
 		log,
 	)
@@ -192,8 +233,27 @@ func (account *Account) Coin() coin.Coin {
 	return account.coin
 }
 
+// openDB opens the database which persists the transactions of the account.
+func (account *Account) openDB(signingConfiguration *signing.Configuration) (*transactionsdb.DB, error) {
+	dbName := fmt.Sprintf("account-%s-%s%s.db",
+		signingConfiguration.Hash(), account.code, account.coin.chainTag())
+	account.log.Debugf("Opening the database '%s' to persist the transactions.", dbName)
+	// The cache is encrypted so that it can't be read while the keystores are not registered.
+	key, err := account.keystores.EncryptionKey("transactions cache")
+	if err != nil {
+		return nil, err
+	}
+	db, err := transactionsdb.NewDB(path.Join(account.dbFolder, dbName), key)
+	if err != nil {
+		return nil, err
+	}
+	account.log.Debugf("Opened the database '%s' to persist the transactions.", dbName)
+	return db, nil
+}
+
 // Init initializes the account.
 func (account *Account) Init() error {
+	var db *transactionsdb.DB
 	alreadyInitialized, err := func() (bool, error) {
 		defer account.Lock()()
 		if account.signingConfiguration != nil {
@@ -204,7 +264,14 @@ func (account *Account) Init() error {
 		if err != nil {
 			return false, err
 		}
+		// The signing configuration marks the account as initialized, so it is only set once the
+		// database is open. Otherwise, a failed Init would leave the account half initialized.
+		db, err = account.openDB(signingConfiguration)
+		if err != nil {
+			return false, err
+		}
 		account.signingConfiguration = signingConfiguration
+		account.db = db
 		return false, nil
 	}()
 	if err != nil {
@@ -214,25 +281,21 @@ func (account *Account) Init() error {
 		account.log.Debug("Account has already been initialized")
 		return nil
 	}
-	dbName := fmt.Sprintf("account-%s-%s.db", account.signingConfiguration.Hash(), account.code)
-	account.log.Debugf("Opening the database '%s' to persist the transactions.", dbName)
-	db, err := transactionsdb.NewDB(path.Join(account.dbFolder, dbName))
-	if err != nil {
-		return err
+	if reset := db.Migration().Reset; reset != nil {
+		account.log.WithError(reset).Warn("Could not migrate the database, resyncing the transactions")
+		account.onEvent(EventDBReset)
 	}
-	account.db = db
-	account.log.Debugf("Opened the database '%s' to persist the transactions.", dbName)
 
 	onConnectionStatusChanged := func(status blockchain.Status) {
 		if status == blockchain.DISCONNECTED {
 			account.log.Warn("Connection to blockchain backend lost")
-			account.offline = true
+			account.setOffline(true)
 			account.onEvent(EventStatusChanged)
 		} else if status == blockchain.CONNECTED {
 			// when we have previously been offline, the initial sync status is set back
 			// as we need to synchronize with the new backend.
-			account.initialSyncDone = false
-			account.offline = false
+			account.setInitialSyncDone(false)
+			account.setOffline(false)
 			account.onEvent(EventStatusChanged)
 			account.log.Debug("Connection to blockchain backend established")
 		} else {
@@ -240,7 +303,7 @@ func (account *Account) Init() error {
 		}
 	}
 	account.blockchain = account.coin.Blockchain()
-	account.offline = account.blockchain.ConnectionStatus() == blockchain.DISCONNECTED
+	account.setOffline(account.blockchain.ConnectionStatus() == blockchain.DISCONNECTED)
 	account.onEvent(EventStatusChanged)
 	account.blockchain.RegisterOnConnectionStatusChangedEvent(onConnectionStatusChanged)
 
@@ -251,9 +314,17 @@ func (account *Account) Init() error {
 			account.onEvent(EventHeadersSynced)
 		}
 	})
+	onTransactionsEvent := func(event transactions.Event) {
+		switch event {
+		case transactions.EventTxsUnverified:
+			account.onEvent(EventTxsUnverified)
+		case transactions.EventTxConflicted:
+			account.onEvent(EventTxConflicted)
+		}
+	}
 	account.transactions = transactions.NewTransactions(
 		account.coin.Net(), account.db, account.headers, account.synchronizer,
-		account.blockchain, account.log)
+		account.blockchain, onTransactionsEvent, account.log)
 
 	fixGapLimit := gapLimit
 	fixChangeGapLimit := changeGapLimit
@@ -270,6 +341,12 @@ func (account *Account) Init() error {
 
 	account.receiveAddresses = addresses.NewAddressChain(
 		account.signingConfiguration, account.coin.Net(), fixGapLimit, 0, account.log)
+	func() {
+		defer account.RLock()()
+		for scriptHashHex := range account.reservedAddresses {
+			account.receiveAddresses.Reserve(scriptHashHex)
+		}
+	}()
 	account.log.Debug("creating change address chain structure")
 	account.changeAddresses = addresses.NewAddressChain(
 		account.signingConfiguration, account.coin.Net(), fixChangeGapLimit, 1, account.log)
@@ -343,14 +420,26 @@ func (account *Account) onNewHeader(header *blockchain.Header) error {
 	return nil
 }
 
+func (account *Account) setOffline(offline bool) {
+	defer account.syncStateLock.Lock()()
+	account.offline = offline
+}
+
+func (account *Account) setInitialSyncDone(initialSyncDone bool) {
+	defer account.syncStateLock.Lock()()
+	account.initialSyncDone = initialSyncDone
+}
+
 // Offline returns true if the account is disconnected from the blockchain.
 func (account *Account) Offline() bool {
+	defer account.syncStateLock.RLock()()
 	return account.offline
 }
 
 // InitialSyncDone indicates whether the account has loaded and finished the initial sync of the
 // addresses.
 func (account *Account) InitialSyncDone() bool {
+	defer account.syncStateLock.RLock()()
 	return account.initialSyncDone
 }
 
@@ -365,7 +454,7 @@ func (account *Account) Close() {
 	}
 	// TODO: deregister from json RPC client. The client can be closed when no account uses
 	// the client any longer.
-	account.initialSyncDone = false
+	account.setInitialSyncDone(false)
 	if account.transactions != nil {
 		account.transactions.Close()
 	}
@@ -440,6 +529,11 @@ func (account *Account) Balance() *transactions.Balance {
 	return account.transactions.Balance()
 }
 
+// BalanceHistory wraps transaction.Transactions.BalanceHistory()
+func (account *Account) BalanceHistory() ([]*coin.BalancePoint, error) {
+	return account.transactions.BalanceHistory(), nil
+}
+
 func (account *Account) addresses(change bool) *addresses.AddressChain {
 	if change {
 		return account.changeAddresses
@@ -483,6 +577,12 @@ func (account *Account) onAddressStatus(address *addresses.AccountAddress, statu
 // changes, to keep the gapLimit tail.
 func (account *Account) ensureAddresses() {
 	defer account.Lock()()
+	account.extendAddressChains()
+}
+
+// extendAddressChains adds and subscribes to addresses until both address chains have their unused
+// tail. The account lock must be held.
+func (account *Account) extendAddressChains() {
 	defer account.synchronizer.IncRequestsCounter()()
 
 	dbTx, err := account.db.Begin()
@@ -526,6 +626,9 @@ func (account *Account) subscribeAddress(
 	}
 	address.HistoryStatus = addressHistory.Status()
 
+	if importer, ok := account.blockchain.(blockchain.ScriptImporter); ok {
+		importer.ImportScript(address.PubkeyScript())
+	}
 	account.blockchain.ScriptHashSubscribe(
 		account.synchronizer.IncRequestsCounter,
 		address.PubkeyScriptHashHex(),
@@ -534,12 +637,72 @@ func (account *Account) subscribeAddress(
 	return nil
 }
 
-// Transactions wraps transaction.Transactions.Transactions()
+func (account *Account) formatAmount(amount btcutil.Amount) string {
+	return account.coin.FormatAmount(coin.NewAmountFromInt64(int64(amount)))
+}
+
+// notifyTxEvents fires the TxEvents since the previous call.
+func (account *Account) notifyTxEvents() {
+	defer account.txNotifier.Lock()()
+	if !account.InitialSyncDone() {
+		// The account was closed.
+		return
+	}
+	for _, event := range account.txNotifier.update(account.Transactions()) {
+		account.onTxEvent(event)
+	}
+}
+
+func (account *Account) isChange(scriptHashHex blockchain.ScriptHashHex) bool {
+	return account.changeAddresses.LookupByScriptHashHex(scriptHashHex) != nil
+}
+
+// txLabels returns the labels of the transactions of the account.
+func (account *Account) txLabels() map[chainhash.Hash]string {
+	result := map[chainhash.Hash]string{}
+	if account.labels == nil {
+		return result
+	}
+	for txID, label := range account.labels.Labels(account.code) {
+		txHash, err := chainhash.NewHashFromStr(txID)
+		if err != nil {
+			account.log.WithError(err).Error("Invalid txid of a label")
+			continue
+		}
+		result[*txHash] = label
+	}
+	return result
+}
+
+// Transactions returns all transactions of the account, see QueryTransactions.
 func (account *Account) Transactions() []*transactions.TxInfo {
-	return account.transactions.Transactions(
-		func(scriptHashHex blockchain.ScriptHashHex) bool {
-			return account.changeAddresses.LookupByScriptHashHex(scriptHashHex) != nil
-		})
+	result, err := account.QueryTransactions(&transactions.TxQuery{})
+	if err != nil {
+		// TODO
+		panic(err)
+	}
+	return result.Transactions
+}
+
+// QueryTransactions wraps transaction.Transactions.QueryTransactions()
+func (account *Account) QueryTransactions(
+	query *transactions.TxQuery) (*transactions.TxQueryResult, error) {
+	return account.transactions.QueryTransactions(query, account.isChange, account.txLabels())
+}
+
+// SetTxLabel stores the label of a transaction of the account.
+func (account *Account) SetTxLabel(txID string, label string) error {
+	txHash, err := chainhash.NewHashFromStr(txID)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	if account.labels == nil {
+		return errp.New("the labels could not be loaded")
+	}
+	if !account.transactions.HasTransaction(*txHash) {
+		return errp.Newf("Unknown transaction %s", txHash)
+	}
+	return account.labels.SetLabel(account.code, txHash.String(), label)
 }
 
 // GetUnusedReceiveAddresses returns a number of unused addresses.
@@ -555,6 +718,54 @@ func (account *Account) GetUnusedReceiveAddresses() []coin.Address {
 	return addresses
 }
 
+// ReserveAddresses marks the receive addresses with the given script hashes as handed out, so that
+// GetUnusedReceiveAddresses() does not return them anymore. It is used to restore the reservations
+// of a previous session and must be called before Init().
+func (account *Account) ReserveAddresses(scriptHashHexes []blockchain.ScriptHashHex) {
+	defer account.Lock()()
+	for _, scriptHashHex := range scriptHashHexes {
+		account.reservedAddresses[scriptHashHex] = struct{}{}
+	}
+}
+
+// ReleaseAddresses undoes the reservations of the receive addresses with the given script hashes,
+// e.g. of invoices which expired unpaid, so that they do not count as used anymore.
+func (account *Account) ReleaseAddresses(scriptHashHexes []blockchain.ScriptHashHex) {
+	defer account.Lock()()
+	for _, scriptHashHex := range scriptHashHexes {
+		delete(account.reservedAddresses, scriptHashHex)
+		if account.receiveAddresses != nil {
+			account.receiveAddresses.Release(scriptHashHex)
+		}
+	}
+}
+
+// ReserveReceiveAddress returns the first unused receive address and reserves it, so that it is not
+// returned by GetUnusedReceiveAddresses() anymore. Returns an error if the account is not
+// initialized, or if `gapLimit` reserved addresses are still unused. Beyond that, a wallet
+// restored from the seed would not find the funds received on the next addresses.
+func (account *Account) ReserveReceiveAddress() (*addresses.AccountAddress, error) {
+	account.synchronizer.WaitSynchronized()
+	defer account.Lock()()
+	if account.receiveAddresses == nil {
+		return nil, errp.New("the account is not initialized")
+	}
+	if account.receiveAddresses.UnusedReservedCount() >= gapLimit {
+		return nil, errp.New("too many addresses are reserved for unpaid invoices")
+	}
+	address := account.receiveAddresses.GetUnused()[0]
+	account.reservedAddresses[address.PubkeyScriptHashHex()] = struct{}{}
+	account.receiveAddresses.Reserve(address.PubkeyScriptHashHex())
+	// Extend the chain to keep the unused tail before anyone can look up the unused addresses.
+	account.extendAddressChains()
+	return address, nil
+}
+
+// Received wraps transaction.Transactions.Received()
+func (account *Account) Received(scriptHashHex blockchain.ScriptHashHex) (btcutil.Amount, btcutil.Amount) {
+	return account.transactions.Received(scriptHashHex)
+}
+
 // VerifyAddress verifies a receive address on a keystore. Returns false, nil if no secure output
 // exists.
 func (account *Account) VerifyAddress(addressID string) (bool, error) {
@@ -0,0 +1,121 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	addressesTest "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses/test"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	blockchainMock "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain/mocks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/synchronizer"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/transactionsdb"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitWithoutKeystore(t *testing.T) {
+	configuration, _ := addressesTest.NewAddressChain()
+	account := &Account{
+		coin:     &Coin{net: &chaincfg.TestNet3Params},
+		dbFolder: test.TstTempDir("bitbox-wallet-db-"),
+		getSigningConfiguration: func() (*signing.Configuration, error) {
+			return configuration, nil
+		},
+		// The encryption key of the database can't be derived without a keystore.
+		keystores: keystore.NewKeystores(),
+		log:       logging.Get().WithGroup("account_test"),
+	}
+	require.Error(t, account.Init())
+	// A failed Init does not leave the account half initialized, so it can be retried.
+	require.Nil(t, account.signingConfiguration)
+	require.Nil(t, account.db)
+	require.Error(t, account.Init())
+}
+
+func TestReserveReceiveAddress(t *testing.T) {
+	log := logging.Get().WithGroup("account_test")
+	theBlockchain := &blockchainMock.Interface{}
+	theBlockchain.On("ScriptHashSubscribe", mock.Anything, mock.Anything, mock.Anything).Return()
+	db, err := transactionsdb.NewDB(test.TstTempFile("bitbox-wallet-db-"), nil)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	account := &Account{
+		db:                db,
+		blockchain:        theBlockchain,
+		reservedAddresses: map[blockchain.ScriptHashHex]struct{}{},
+		synchronizer:      synchronizer.NewSynchronizer(func() {}, func() {}, log),
+		log:               log,
+	}
+
+	_, err = account.ReserveReceiveAddress()
+	require.Error(t, err, "the account is not initialized")
+
+	configuration, _ := addressesTest.NewAddressChain()
+	account.receiveAddresses = addresses.NewAddressChain(
+		configuration, &chaincfg.TestNet3Params, gapLimit, 0, log)
+	account.changeAddresses = addresses.NewAddressChain(
+		configuration, &chaincfg.TestNet3Params, changeGapLimit, 1, log)
+	account.ensureAddresses()
+
+	// Reservations must not interfere with concurrent lookups of the unused addresses.
+	const count = gapLimit
+	reserved := make(chan blockchain.ScriptHashHex, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			address, err := account.ReserveReceiveAddress()
+			require.NoError(t, err)
+			reserved <- address.PubkeyScriptHashHex()
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				require.Len(t, account.GetUnusedReceiveAddresses(), gapLimit)
+			}
+		}()
+	}
+	wg.Wait()
+	close(reserved)
+
+	reservedSet := map[blockchain.ScriptHashHex]struct{}{}
+	for scriptHashHex := range reserved {
+		reservedSet[scriptHashHex] = struct{}{}
+	}
+	require.Len(t, reservedSet, count)
+	for _, address := range account.GetUnusedReceiveAddresses() {
+		_, ok := reservedSet[address.(*addresses.AccountAddress).PubkeyScriptHashHex()]
+		require.False(t, ok)
+	}
+
+	// At most `gapLimit` reserved addresses can be unused.
+	_, err = account.ReserveReceiveAddress()
+	require.Error(t, err)
+	for scriptHashHex := range reservedSet {
+		account.ReleaseAddresses([]blockchain.ScriptHashHex{scriptHashHex})
+		break
+	}
+	_, err = account.ReserveReceiveAddress()
+	require.NoError(t, err)
+}
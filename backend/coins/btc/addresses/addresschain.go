@@ -29,7 +29,10 @@ type AddressChain struct {
 	gapLimit      int
 	chainIndex    uint32
 	addresses     []*AccountAddress
-	log           *logrus.Entry
+	// reserved contains the addresses which were handed out for a specific purpose, e.g. an
+	// invoice. They are treated as used, even if they have no history yet.
+	reserved map[blockchain.ScriptHashHex]struct{}
+	log      *logrus.Entry
 }
 
 // NewAddressChain creates an address chain starting at m/<chainIndex> from the given configuration.
@@ -52,6 +55,7 @@ func NewAddressChain(
 		gapLimit:      gapLimit,
 		chainIndex:    chainIndex,
 		addresses:     []*AccountAddress{},
+		reserved:      map[blockchain.ScriptHashHex]struct{}{},
 		log: log.WithFields(logrus.Fields{"group": "addresses", "net": net.Name,
 			"gap-limit": gapLimit, "configuration": configuration.String()}),
 	}
@@ -67,6 +71,30 @@ func (addresses *AddressChain) GetUnused() []*AccountAddress {
 	return addresses.addresses[len(addresses.addresses)-unusedTailCount:]
 }
 
+// Reserve marks the address with the given script hash as used, so that it is not returned by
+// GetUnused() anymore. The address does not have to be part of the chain yet. EnsureAddresses()
+// must be called afterwards to restore the unused tail.
+func (addresses *AddressChain) Reserve(scriptHashHex blockchain.ScriptHashHex) {
+	addresses.reserved[scriptHashHex] = struct{}{}
+}
+
+// Release undoes Reserve(). If the address has no history, it counts as unused again.
+func (addresses *AddressChain) Release(scriptHashHex blockchain.ScriptHashHex) {
+	delete(addresses.reserved, scriptHashHex)
+}
+
+// UnusedReservedCount returns the number of reserved addresses of the chain which have no history
+// yet.
+func (addresses *AddressChain) UnusedReservedCount() int {
+	count := 0
+	for _, address := range addresses.addresses {
+		if _, reserved := addresses.reserved[address.PubkeyScriptHashHex()]; reserved && !address.isUsed() {
+			count++
+		}
+	}
+	return count
+}
+
 // addAddress appends a new address at the end of the chain.
 func (addresses *AddressChain) addAddress() *AccountAddress {
 	addresses.log.Debug("Add new address to chain")
@@ -91,7 +119,8 @@ func (addresses *AddressChain) addAddress() *AccountAddress {
 func (addresses *AddressChain) unusedTailCount() int {
 	count := 0
 	for i := len(addresses.addresses) - 1; i >= 0; i-- {
-		if addresses.addresses[i].isUsed() {
+		address := addresses.addresses[i]
+		if _, reserved := addresses.reserved[address.PubkeyScriptHashHex()]; reserved || address.isUsed() {
 			break
 		}
 		count++
@@ -127,3 +127,36 @@ func (s *addressChainTestSuite) TestEnsureAddresses() {
 	newAddresses[s.gapLimit-1].HistoryStatus = "used"
 	require.Len(s.T(), s.addresses.EnsureAddresses(), s.gapLimit)
 }
+
+func (s *addressChainTestSuite) TestReserve() {
+	newAddresses := s.addresses.EnsureAddresses()
+	s.addresses.Reserve(newAddresses[0].PubkeyScriptHashHex())
+	// Need to call EnsureAddresses because a reserved address counts as used.
+	require.Panics(s.T(), func() { _ = s.addresses.GetUnused() })
+	require.Len(s.T(), s.addresses.EnsureAddresses(), 1)
+	require.Equal(s.T(), newAddresses[1], s.addresses.GetUnused()[0])
+
+	// Addresses can be reserved before they are derived.
+	restored := addresses.NewAddressChain(
+		signing.NewSinglesigConfiguration(signing.ScriptTypeP2PKH, signing.NewEmptyAbsoluteKeypath(), s.xpub),
+		net, s.gapLimit, s.chainIndex, s.log)
+	restored.Reserve(newAddresses[0].PubkeyScriptHashHex())
+	require.Len(s.T(), restored.EnsureAddresses(), s.gapLimit)
+	require.Len(s.T(), restored.EnsureAddresses(), 1)
+	require.Equal(s.T(), s.addresses.GetUnused(), restored.GetUnused())
+}
+
+func (s *addressChainTestSuite) TestRelease() {
+	newAddresses := s.addresses.EnsureAddresses()
+	s.addresses.Reserve(newAddresses[0].PubkeyScriptHashHex())
+	require.Len(s.T(), s.addresses.EnsureAddresses(), 1)
+	require.Equal(s.T(), 1, s.addresses.UnusedReservedCount())
+	newAddresses[0].HistoryStatus = "used"
+	require.Equal(s.T(), 0, s.addresses.UnusedReservedCount())
+	newAddresses[0].HistoryStatus = ""
+
+	s.addresses.Release(newAddresses[0].PubkeyScriptHashHex())
+	require.Equal(s.T(), 0, s.addresses.UnusedReservedCount())
+	require.Equal(s.T(), newAddresses[0], s.addresses.GetUnused()[0])
+	require.Len(s.T(), s.addresses.GetUnused(), s.gapLimit+1)
+}
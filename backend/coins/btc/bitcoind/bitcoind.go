@@ -0,0 +1,746 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bitcoind implements blockchain.Interface using the JSON-RPC interface of a Bitcoin Core
+// node, for users who run their own node without an Electrum server in front of it.
+//
+// Bitcoin Core has no index by script, so the pubkey scripts of the accounts are imported as raw()
+// descriptors into a watch-only descriptor wallet (Bitcoin Core 0.21+), and the address histories
+// are derived from the transactions of this wallet. The node does not push notifications over
+// JSON-RPC, so new blocks and transactions are discovered by polling.
+package bitcoind
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+)
+
+// pollInterval is the interval in which the node is polled for new blocks and transactions.
+var pollInterval = 10 * time.Second
+
+// headersPerBatch is the maximum number of headers returned by Headers.
+const headersPerBatch = 2016
+
+type subscription struct {
+	callback func(string) error
+	// status is the last status passed to the callback.
+	status string
+	// pending are the teardown functions of subscriptions which were not answered yet.
+	pending []func()
+}
+
+type headersSubscription struct {
+	callback func(*blockchain.Header) error
+	pending  func()
+}
+
+// walletTransaction is a transaction of the wallet. Height is 0 for unconfirmed transactions.
+type walletTransaction struct {
+	tx     *wire.MsgTx
+	height int
+}
+
+// Client is a blockchain.Interface backed by a Bitcoin Core node.
+type Client struct {
+	config *Config
+	rpc    *rpcClient
+
+	lock locker.Locker
+	// scripts are the pubkey scripts which have been imported into the wallet, or are queued to be
+	// imported, by script hash.
+	scripts     map[blockchain.ScriptHashHex][]byte
+	importQueue [][]byte
+	walletReady bool
+	// transactions are all transactions of the wallet as of the last sync.
+	transactions        map[chainhash.Hash]*walletTransaction
+	histories           map[blockchain.ScriptHashHex]blockchain.TxHistory
+	subscriptions       map[blockchain.ScriptHashHex]*subscription
+	headersSubscription []*headersSubscription
+	tip                 int
+
+	status                   blockchain.Status
+	onConnectionStatusChange []func(blockchain.Status)
+
+	wakeChan  chan struct{}
+	closeChan chan struct{}
+	closed    bool
+
+	log *logrus.Entry
+}
+
+// NewClient creates a new client and starts polling the node.
+func NewClient(config *Config, socksProxy *socksproxy.SocksProxy, log *logrus.Entry) *Client {
+	client := &Client{
+		config:        config,
+		rpc:           newRPCClient(config, socksProxy.HTTPClient(0)),
+		scripts:       map[blockchain.ScriptHashHex][]byte{},
+		transactions:  map[chainhash.Hash]*walletTransaction{},
+		histories:     map[blockchain.ScriptHashHex]blockchain.TxHistory{},
+		subscriptions: map[blockchain.ScriptHashHex]*subscription{},
+		tip:           -1,
+		status:        blockchain.CONNECTED,
+		wakeChan:      make(chan struct{}, 1),
+		closeChan:     make(chan struct{}),
+		log:           log.WithFields(logrus.Fields{"group": "bitcoind", "url": config.URL}),
+	}
+	go client.poll()
+	return client
+}
+
+func scriptHash(pkScript []byte) blockchain.ScriptHashHex {
+	return blockchain.ScriptHashHex(chainhash.HashH(pkScript).String())
+}
+
+// wake triggers a sync without waiting for the next poll.
+func (client *Client) wake() {
+	select {
+	case client.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+func (client *Client) poll() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := client.sync(); err != nil {
+			client.log.WithError(err).Error("Failed to sync with the node")
+		}
+		select {
+		case <-client.closeChan:
+			return
+		case <-ticker.C:
+		case <-client.wakeChan:
+		}
+	}
+}
+
+func (client *Client) setStatus(status blockchain.Status) {
+	callbacks := func() []func(blockchain.Status) {
+		defer client.lock.Lock()()
+		if client.status == status {
+			return nil
+		}
+		client.status = status
+		return client.onConnectionStatusChange
+	}()
+	for _, callback := range callbacks {
+		callback(status)
+	}
+}
+
+// ensureWallet loads the configured wallet, or creates it if it does not exist.
+func (client *Client) ensureWallet() error {
+	if client.walletReady || client.config.Wallet == "" {
+		return nil
+	}
+	err := client.rpc.call(true, nil, "getwalletinfo")
+	if isRPCError(err, rpcWalletNotFound) {
+		err = client.rpc.call(false, nil, "loadwallet", client.config.Wallet)
+		if isRPCError(err, rpcWalletNotFound) || isRPCError(err, rpcWalletError) {
+			client.log.WithField("wallet", client.config.Wallet).Info("Creating watch-only wallet")
+			// Arguments: wallet_name, disable_private_keys, blank, passphrase, avoid_reuse,
+			// descriptors.
+			err = client.rpc.call(false, nil, "createwallet",
+				client.config.Wallet, true, true, "", false, true)
+		}
+		if isRPCError(err, rpcWalletAlreadyLoaded) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	client.walletReady = true
+	return nil
+}
+
+// importScripts imports the queued scripts into the wallet, skipping the ones which have been
+// imported before, e.g. in a previous session. Importing makes the node rescan the chain from
+// ImportTimestamp, which can take a long time. Scripts which fail to be imported are queued again
+// and retried with the next sync.
+func (client *Client) importScripts() error {
+	queue := func() [][]byte {
+		defer client.lock.Lock()()
+		queue := client.importQueue
+		client.importQueue = nil
+		return queue
+	}()
+	if len(queue) == 0 {
+		return nil
+	}
+	requeue := func(pkScripts [][]byte) {
+		defer client.lock.Lock()()
+		client.importQueue = append(pkScripts, client.importQueue...)
+	}
+	imported := map[string]struct{}{}
+	var listDescriptors struct {
+		Descriptors []struct {
+			Desc string `json:"desc"`
+		} `json:"descriptors"`
+	}
+	// listdescriptors is available since Bitcoin Core 22.0.
+	if err := client.rpc.call(true, &listDescriptors, "listdescriptors"); err == nil {
+		for _, descriptor := range listDescriptors.Descriptors {
+			imported[descriptor.Desc] = struct{}{}
+		}
+	} else if _, ok := errp.Cause(err).(*rpcError); !ok {
+		requeue(queue)
+		return err
+	}
+	requests := []map[string]interface{}{}
+	// pkScripts are the scripts of the requests, in the same order.
+	pkScripts := [][]byte{}
+	for _, pkScript := range queue {
+		descriptor := rawDescriptor(pkScript)
+		if _, ok := imported[descriptor]; ok {
+			continue
+		}
+		requests = append(requests, map[string]interface{}{
+			"desc":      descriptor,
+			"timestamp": client.config.ImportTimestamp,
+		})
+		pkScripts = append(pkScripts, pkScript)
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	client.log.WithField("count", len(requests)).Info("Importing scripts")
+	results := []struct {
+		Success bool      `json:"success"`
+		Error   *rpcError `json:"error"`
+	}{}
+	if err := client.rpc.call(true, &results, "importdescriptors", requests); err != nil {
+		requeue(pkScripts)
+		return err
+	}
+	if len(results) != len(requests) {
+		requeue(pkScripts)
+		return errp.Newf("Expected %d import results, got %d", len(requests), len(results))
+	}
+	failed := [][]byte{}
+	for index, result := range results {
+		if result.Success {
+			continue
+		}
+		failed = append(failed, pkScripts[index])
+		if result.Error != nil {
+			client.log.WithError(result.Error).Error("Failed to import script")
+		} else {
+			client.log.Error("Failed to import script")
+		}
+	}
+	// The other scripts were imported, so the sync goes on and the failed ones are retried later.
+	requeue(failed)
+	return nil
+}
+
+// fetchTransactions returns the transactions of the wallet and their heights.
+func (client *Client) fetchTransactions() (map[chainhash.Hash]*walletTransaction, error) {
+	entries := []struct {
+		TXID          string `json:"txid"`
+		Confirmations int    `json:"confirmations"`
+		BlockHeight   int    `json:"blockheight"`
+	}{}
+	// Arguments: label, count, skip, include_watchonly.
+	if err := client.rpc.call(true, &entries, "listtransactions", "*", 1000000000, 0, true); err != nil {
+		return nil, err
+	}
+	transactions := map[chainhash.Hash]*walletTransaction{}
+	missing := []*chainhash.Hash{}
+	for _, entry := range entries {
+		// Conflicted transactions have negative confirmations.
+		if entry.Confirmations < 0 {
+			continue
+		}
+		txHash, err := chainhash.NewHashFromStr(entry.TXID)
+		if err != nil {
+			return nil, &connectionError{errp.WithStack(err)}
+		}
+		if _, ok := transactions[*txHash]; ok {
+			continue
+		}
+		height := 0
+		if entry.Confirmations > 0 {
+			height = entry.BlockHeight
+		}
+		transactions[*txHash] = &walletTransaction{height: height}
+		func() {
+			defer client.lock.RLock()()
+			if cached, ok := client.transactions[*txHash]; ok {
+				transactions[*txHash].tx = cached.tx
+			} else {
+				missing = append(missing, txHash)
+			}
+		}()
+	}
+	results := make([]struct {
+		Hex string `json:"hex"`
+	}, len(missing))
+	requests := make([]*request, len(missing))
+	for index, txHash := range missing {
+		requests[index] = &request{
+			method: "gettransaction",
+			params: []interface{}{txHash.String(), true},
+			result: &results[index],
+		}
+	}
+	if err := client.rpc.batch(true, requests); err != nil {
+		return nil, err
+	}
+	for index, txHash := range missing {
+		tx, err := parseTX(results[index].Hex)
+		if err != nil {
+			return nil, &connectionError{err}
+		}
+		transactions[*txHash].tx = tx
+	}
+	return transactions, nil
+}
+
+// histories derives the history of each script from the wallet transactions. A transaction
+// belongs to the history of a script if it has an output paying to the script or an input spending
+// such an output.
+func histories(
+	scripts map[blockchain.ScriptHashHex][]byte,
+	transactions map[chainhash.Hash]*walletTransaction,
+) map[blockchain.ScriptHashHex]blockchain.TxHistory {
+	result := map[blockchain.ScriptHashHex]blockchain.TxHistory{}
+	for txHash, transaction := range transactions {
+		touched := map[blockchain.ScriptHashHex]struct{}{}
+		for _, txOut := range transaction.tx.TxOut {
+			touched[scriptHash(txOut.PkScript)] = struct{}{}
+		}
+		for _, txIn := range transaction.tx.TxIn {
+			previous, ok := transactions[txIn.PreviousOutPoint.Hash]
+			if ok && int(txIn.PreviousOutPoint.Index) < len(previous.tx.TxOut) {
+				pkScript := previous.tx.TxOut[txIn.PreviousOutPoint.Index].PkScript
+				touched[scriptHash(pkScript)] = struct{}{}
+			}
+		}
+		for hash := range touched {
+			if _, ok := scripts[hash]; ok {
+				result[hash] = append(result[hash], &blockchain.TxInfo{
+					Height: transaction.height,
+					TXHash: blockchain.TXHash(txHash),
+				})
+			}
+		}
+	}
+	// Like Electrum, confirmed transactions are ordered by height, followed by the unconfirmed ones.
+	for _, history := range result {
+		sort.Slice(history, func(i, j int) bool {
+			heightI, heightJ := history[i].Height, history[j].Height
+			if (heightI == 0) != (heightJ == 0) {
+				return heightJ == 0
+			}
+			if heightI != heightJ {
+				return heightI < heightJ
+			}
+			hashI, hashJ := history[i].TXHash.Hash(), history[j].TXHash.Hash()
+			return hashI.String() < hashJ.String()
+		})
+	}
+	return result
+}
+
+// sync imports new scripts, fetches the wallet transactions and the chain tip, and notifies the
+// subscribers about changes.
+func (client *Client) sync() error {
+	err := func() error {
+		if err := client.ensureWallet(); err != nil {
+			return err
+		}
+		if err := client.importScripts(); err != nil {
+			return err
+		}
+		var tip int
+		if err := client.rpc.call(false, &tip, "getblockcount"); err != nil {
+			return err
+		}
+		transactions, err := client.fetchTransactions()
+		if err != nil {
+			return err
+		}
+		client.update(tip, transactions)
+		return nil
+	}()
+	if _, ok := errp.Cause(err).(*connectionError); ok {
+		client.setStatus(blockchain.DISCONNECTED)
+	} else {
+		client.setStatus(blockchain.CONNECTED)
+	}
+	return err
+}
+
+func (client *Client) update(tip int, transactions map[chainhash.Hash]*walletTransaction) {
+	notifications := []func(){}
+	func() {
+		defer client.lock.Lock()()
+		client.transactions = transactions
+		client.histories = histories(client.scripts, transactions)
+		for hash, sub := range client.subscriptions {
+			callback := sub.callback
+			status := client.histories[hash].Status()
+			if status == sub.status && len(sub.pending) == 0 {
+				continue
+			}
+			sub.status = status
+			pending := sub.pending
+			sub.pending = nil
+			notifications = append(notifications, func() {
+				if err := callback(status); err != nil {
+					client.log.WithError(err).Error("Failed to execute callback")
+				}
+				for _, teardown := range pending {
+					teardown()
+				}
+			})
+		}
+		tipChanged := tip != client.tip
+		client.tip = tip
+		for _, sub := range client.headersSubscription {
+			if !tipChanged && sub.pending == nil {
+				continue
+			}
+			callback, pending := sub.callback, sub.pending
+			sub.pending = nil
+			notifications = append(notifications, func() {
+				if err := callback(&blockchain.Header{BlockHeight: tip}); err != nil {
+					client.log.WithError(err).Error("could not handle header notification")
+				}
+				if pending != nil {
+					pending()
+				}
+			})
+		}
+	}()
+	for _, notification := range notifications {
+		notification()
+	}
+}
+
+// method runs the request in the background and then calls cleanup. Like requests to Electrum
+// servers, requests which fail because the node is unreachable are retried until they succeed or
+// the client is closed. Errors returned by the node are logged.
+func (client *Client) method(request func() error, cleanup func()) {
+	go func() {
+		defer cleanup()
+		for {
+			err := request()
+			if err == nil {
+				return
+			}
+			if _, ok := errp.Cause(err).(*connectionError); !ok {
+				client.log.WithError(err).Error("Request failed")
+				return
+			}
+			client.log.WithError(err).Debug("Retrying request")
+			client.setStatus(blockchain.DISCONNECTED)
+			select {
+			case <-client.closeChan:
+				return
+			case <-time.After(pollInterval):
+			}
+		}
+	}()
+}
+
+// ImportScript implements blockchain.ScriptImporter. The script is imported into the wallet in
+// the next sync.
+func (client *Client) ImportScript(pkScript []byte) {
+	defer client.lock.Lock()()
+	hash := scriptHash(pkScript)
+	if _, ok := client.scripts[hash]; ok {
+		return
+	}
+	client.scripts[hash] = pkScript
+	client.importQueue = append(client.importQueue, pkScript)
+}
+
+// ScriptHashGetHistory implements blockchain.Interface. The history is the one of the last sync.
+func (client *Client) ScriptHashGetHistory(
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(blockchain.TxHistory) error,
+	cleanup func(),
+) {
+	history := func() blockchain.TxHistory {
+		defer client.lock.RLock()()
+		return append(blockchain.TxHistory{}, client.histories[scriptHashHex]...)
+	}()
+	client.method(func() error { return success(history) }, cleanup)
+}
+
+// ScriptHashSubscribe implements blockchain.Interface. The current status is passed to the
+// callback after the next sync, which imports the script if it is not yet in the wallet.
+func (client *Client) ScriptHashSubscribe(
+	setupAndTeardown func() func(),
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(string) error,
+) {
+	teardown := setupAndTeardown()
+	func() {
+		defer client.lock.Lock()()
+		sub, ok := client.subscriptions[scriptHashHex]
+		if !ok {
+			sub = &subscription{}
+			client.subscriptions[scriptHashHex] = sub
+		}
+		sub.callback = success
+		sub.pending = append(sub.pending, teardown)
+	}()
+	client.wake()
+}
+
+// HeadersSubscribe implements blockchain.Interface.
+func (client *Client) HeadersSubscribe(
+	setupAndTeardown func() func(),
+	success func(*blockchain.Header) error,
+) {
+	// headers.Headers subscribes without a setup function.
+	teardown := func() {}
+	if setupAndTeardown != nil {
+		teardown = setupAndTeardown()
+	}
+	func() {
+		defer client.lock.Lock()()
+		client.headersSubscription = append(client.headersSubscription,
+			&headersSubscription{callback: success, pending: teardown})
+	}()
+	client.wake()
+}
+
+func parseTX(rawTXHex string) (*wire.MsgTx, error) {
+	rawTX, err := hex.DecodeString(rawTXHex)
+	if err != nil {
+		return nil, errp.Wrap(err, "Failed to decode transaction hex")
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.BtcDecode(bytes.NewReader(rawTX), 0, wire.WitnessEncoding); err != nil {
+		return nil, errp.Wrap(err, "Failed to decode BTC transaction")
+	}
+	return tx, nil
+}
+
+// TransactionGet implements blockchain.Interface. Transactions which are not in the wallet are
+// fetched with getrawtransaction, which requires the node to run with -txindex.
+func (client *Client) TransactionGet(
+	txHash chainhash.Hash,
+	success func(*wire.MsgTx) error,
+	cleanup func(),
+) {
+	client.method(func() error {
+		cached := func() *walletTransaction {
+			defer client.lock.RLock()()
+			return client.transactions[txHash]
+		}()
+		if cached != nil {
+			return success(cached.tx)
+		}
+		var walletTx struct {
+			Hex string `json:"hex"`
+		}
+		err := client.rpc.call(true, &walletTx, "gettransaction", txHash.String(), true)
+		rawTXHex := walletTx.Hex
+		if isRPCError(err, rpcInvalidAddressOrKey) {
+			err = client.rpc.call(false, &rawTXHex, "getrawtransaction", txHash.String(), false)
+		}
+		if err != nil {
+			return err
+		}
+		tx, err := parseTX(rawTXHex)
+		if err != nil {
+			return err
+		}
+		return success(tx)
+	}, cleanup)
+}
+
+// TransactionBroadcast implements blockchain.Interface.
+func (client *Client) TransactionBroadcast(transaction *wire.MsgTx) error {
+	rawTx := &bytes.Buffer{}
+	_ = transaction.BtcEncode(rawTx, 0, wire.WitnessEncoding)
+	var response string
+	if err := client.rpc.call(false, &response, "sendrawtransaction", hex.EncodeToString(rawTx.Bytes())); err != nil {
+		return errp.Wrap(err, "Failed to broadcast transaction")
+	}
+	if response != transaction.TxHash().String() {
+		return errp.WithContext(errp.New("Response is unexpected (expected TX hash)"),
+			errp.Context{"response": response})
+	}
+	client.wake()
+	return nil
+}
+
+// RelayFee implements blockchain.Interface.
+func (client *Client) RelayFee(success func(btcutil.Amount) error, cleanup func()) {
+	client.method(func() error {
+		var networkInfo struct {
+			RelayFee float64 `json:"relayfee"`
+		}
+		if err := client.rpc.call(false, &networkInfo, "getnetworkinfo"); err != nil {
+			return err
+		}
+		amount, err := btcutil.NewAmount(networkInfo.RelayFee)
+		if err != nil {
+			return errp.Wrap(err, "Failed to construct BTC amount")
+		}
+		return success(amount)
+	}, cleanup)
+}
+
+// EstimateFee implements blockchain.Interface using estimatesmartfee. If the node cannot estimate
+// the fee rate, e.g. because it has not seen enough blocks, `nil` is passed to the success
+// callback.
+func (client *Client) EstimateFee(number int, success func(*btcutil.Amount) error, cleanup func()) {
+	client.method(func() error {
+		var estimate struct {
+			FeeRate *float64 `json:"feerate"`
+		}
+		if err := client.rpc.call(false, &estimate, "estimatesmartfee", number); err != nil {
+			return err
+		}
+		if estimate.FeeRate == nil {
+			return success(nil)
+		}
+		amount, err := btcutil.NewAmount(*estimate.FeeRate)
+		if err != nil {
+			return errp.Wrap(err, "Failed to construct BTC amount")
+		}
+		return success(&amount)
+	}, cleanup)
+}
+
+// Headers implements blockchain.Interface.
+func (client *Client) Headers(
+	startHeight int, count int,
+	success func(headers []*wire.BlockHeader, max int) error,
+	cleanup func(),
+) {
+	client.method(func() error {
+		var tip int
+		if err := client.rpc.call(false, &tip, "getblockcount"); err != nil {
+			return err
+		}
+		if count > headersPerBatch {
+			count = headersPerBatch
+		}
+		if startHeight+count > tip+1 {
+			count = tip + 1 - startHeight
+		}
+		if count < 0 {
+			count = 0
+		}
+		blockHashes := make([]string, count)
+		requests := make([]*request, count)
+		for index := range requests {
+			requests[index] = &request{
+				method: "getblockhash",
+				params: []interface{}{startHeight + index},
+				result: &blockHashes[index],
+			}
+		}
+		if err := client.rpc.batch(false, requests); err != nil {
+			return err
+		}
+		headersHex := make([]string, count)
+		for index := range requests {
+			requests[index] = &request{
+				method: "getblockheader",
+				params: []interface{}{blockHashes[index], false},
+				result: &headersHex[index],
+			}
+		}
+		if err := client.rpc.batch(false, requests); err != nil {
+			return err
+		}
+		headers := make([]*wire.BlockHeader, count)
+		for index, headerHex := range headersHex {
+			headerBytes, err := hex.DecodeString(headerHex)
+			if err != nil {
+				return errp.WithStack(err)
+			}
+			headers[index] = &wire.BlockHeader{}
+			if err := headers[index].Deserialize(bytes.NewReader(headerBytes)); err != nil {
+				return errp.WithStack(err)
+			}
+		}
+		return success(headers, headersPerBatch)
+	}, cleanup)
+}
+
+// GetMerkle implements blockchain.Interface using gettxoutproof.
+func (client *Client) GetMerkle(
+	txHash chainhash.Hash, height int,
+	success func(merkle []blockchain.TXHash, pos int) error,
+	cleanup func(),
+) {
+	client.method(func() error {
+		var blockHash string
+		if err := client.rpc.call(false, &blockHash, "getblockhash", height); err != nil {
+			return err
+		}
+		var proofHex string
+		if err := client.rpc.call(false, &proofHex, "gettxoutproof",
+			[]string{txHash.String()}, blockHash); err != nil {
+			return err
+		}
+		proof, err := hex.DecodeString(proofHex)
+		if err != nil {
+			return errp.WithStack(err)
+		}
+		header, merkle, pos, err := parseMerkleProof(proof, txHash)
+		if err != nil {
+			return err
+		}
+		if header.BlockHash().String() != blockHash {
+			return errp.Newf("merkle proof: expected block %s, got %s", blockHash, header.BlockHash())
+		}
+		return success(merkle, pos)
+	}, cleanup)
+}
+
+// Close implements blockchain.Interface.
+func (client *Client) Close() {
+	defer client.lock.Lock()()
+	if client.closed {
+		return
+	}
+	client.closed = true
+	close(client.closeChan)
+}
+
+// ConnectionStatus implements blockchain.Interface.
+func (client *Client) ConnectionStatus() blockchain.Status {
+	defer client.lock.RLock()()
+	return client.status
+}
+
+// RegisterOnConnectionStatusChangedEvent implements blockchain.Interface.
+func (client *Client) RegisterOnConnectionStatusChangedEvent(onConnectionStatusChanged func(blockchain.Status)) {
+	defer client.lock.Lock()()
+	client.onConnectionStatusChange = append(client.onConnectionStatusChange, onConnectionStatusChanged)
+}
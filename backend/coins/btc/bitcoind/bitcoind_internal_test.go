@@ -0,0 +1,573 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitcoind
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	btcdBlockchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/bloom"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+)
+
+const testTimeout = 5 * time.Second
+
+func init() {
+	pollInterval = 20 * time.Millisecond
+}
+
+// fakeNode is a Bitcoin Core JSON-RPC server replying with canned results.
+type fakeNode struct {
+	*httptest.Server
+	t *testing.T
+
+	lock    sync.Mutex
+	methods map[string]func(params []interface{}) (interface{}, *rpcError)
+	// calls are the methods called, prefixed with the URL path.
+	calls []string
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	node := &fakeNode{t: t, methods: map[string]func([]interface{}) (interface{}, *rpcError){}}
+	node.Server = httptest.NewServer(http.HandlerFunc(node.serve))
+	return node
+}
+
+func (node *fakeNode) handle(method string, handler func(params []interface{}) (interface{}, *rpcError)) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.methods[method] = handler
+}
+
+func (node *fakeNode) result(method string, result interface{}) {
+	node.handle(method, func([]interface{}) (interface{}, *rpcError) { return result, nil })
+}
+
+func (node *fakeNode) called(call string) bool {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	for _, c := range node.calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+func (node *fakeNode) reply(path string, request *rpcRequest) map[string]interface{} {
+	node.lock.Lock()
+	node.calls = append(node.calls, path+" "+request.Method)
+	handler, ok := node.methods[request.Method]
+	node.lock.Unlock()
+	response := map[string]interface{}{"id": request.ID, "result": nil, "error": nil}
+	if !ok {
+		response["error"] = &rpcError{Code: -32601, Message: "Method not found"}
+		return response
+	}
+	result, err := handler(request.Params)
+	if err != nil {
+		response["error"] = err
+	} else {
+		response["result"] = result
+	}
+	return response
+}
+
+func (node *fakeNode) serve(writer http.ResponseWriter, httpRequest *http.Request) {
+	user, password, ok := httpRequest.BasicAuth()
+	if !ok || user != "user" || password != "password" {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(httpRequest.Body)
+	require.NoError(node.t, err)
+	var response interface{}
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		requests := []*rpcRequest{}
+		require.NoError(node.t, json.Unmarshal(body, &requests))
+		responses := []interface{}{}
+		for _, request := range requests {
+			responses = append(responses, node.reply(httpRequest.URL.Path, request))
+		}
+		response = responses
+	} else {
+		request := &rpcRequest{}
+		require.NoError(node.t, json.Unmarshal(body, request))
+		response = node.reply(httpRequest.URL.Path, request)
+	}
+	require.NoError(node.t, json.NewEncoder(writer).Encode(response))
+}
+
+func (node *fakeNode) config(wallet string) *Config {
+	return &Config{URL: node.URL, User: "user", Password: "password", Wallet: wallet, ImportTimestamp: 1500000000}
+}
+
+func newTestClient(config *Config) *Client {
+	return NewClient(config, socksproxy.NewSocksProxy(socksproxy.Config{}), logging.Get().WithGroup("bitcoind_test"))
+}
+
+func txHex(tx *wire.MsgTx) string {
+	var buffer bytes.Buffer
+	_ = tx.BtcEncode(&buffer, 0, wire.WitnessEncoding)
+	return hex.EncodeToString(buffer.Bytes())
+}
+
+// testTransactions returns transactions with distinct hashes and no data pushes in the outputs.
+func testTransactions(count int) []*wire.MsgTx {
+	txs := make([]*wire.MsgTx, count)
+	for i := range txs {
+		txs[i] = wire.NewMsgTx(1)
+		txs[i].AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{byte(i + 1)}, 0), nil, nil))
+		txs[i].AddTxOut(wire.NewTxOut(int64(i+1), []byte{txscript.OP_TRUE}))
+	}
+	return txs
+}
+
+// testMerkleProof returns a block with the transactions and the serialized proof of the
+// transaction at the given index, as returned by gettxoutproof.
+func testMerkleProof(t *testing.T, txs []*wire.MsgTx, index int) (*wire.MsgBlock, []byte) {
+	block := wire.NewMsgBlock(&wire.BlockHeader{Version: 1, Timestamp: time.Unix(1500000000, 0)})
+	utilTxs := []*btcutil.Tx{}
+	for _, tx := range txs {
+		require.NoError(t, block.AddTransaction(tx))
+		utilTxs = append(utilTxs, btcutil.NewTx(tx))
+	}
+	merkles := btcdBlockchain.BuildMerkleTreeStore(utilTxs, false)
+	block.Header.MerkleRoot = *merkles[len(merkles)-1]
+	filter := bloom.NewFilter(1, 0, 0.000001, wire.BloomUpdateNone)
+	txHash := txs[index].TxHash()
+	filter.AddHash(&txHash)
+	merkleBlock, matched := bloom.NewMerkleBlock(btcutil.NewBlock(block), filter)
+	require.Equal(t, []uint32{uint32(index)}, matched)
+	var proof bytes.Buffer
+	require.NoError(t, merkleBlock.BtcEncode(&proof, wire.ProtocolVersion, wire.BaseEncoding))
+	return block, proof.Bytes()
+}
+
+func merkleRoot(merkle []blockchain.TXHash, start chainhash.Hash, pos int) chainhash.Hash {
+	for i, hash := range merkle {
+		if (pos>>uint(i))&1 == 0 {
+			start = chainhash.DoubleHashH(append(start[:], hash[:]...))
+		} else {
+			start = chainhash.DoubleHashH(append(hash[:], start[:]...))
+		}
+	}
+	return start
+}
+
+func TestDescriptorChecksum(t *testing.T) {
+	// Test vector of BIP380.
+	require.Equal(t, "raw(deadbeef)#89f8spxm", rawDescriptor([]byte{0xde, 0xad, 0xbe, 0xef}))
+	_, ok := descriptorChecksum("raw(é)")
+	require.False(t, ok)
+}
+
+func TestParseMerkleProof(t *testing.T) {
+	for _, count := range []int{1, 2, 5, 8} {
+		txs := testTransactions(count)
+		for index := range txs {
+			block, proof := testMerkleProof(t, txs, index)
+			header, merkle, pos, err := parseMerkleProof(proof, txs[index].TxHash())
+			require.NoError(t, err)
+			require.Equal(t, block.Header.BlockHash(), header.BlockHash())
+			require.Equal(t, index, pos)
+			require.Equal(t, block.Header.MerkleRoot, merkleRoot(merkle, txs[index].TxHash(), pos))
+		}
+	}
+	txs := testTransactions(3)
+	_, proof := testMerkleProof(t, txs, 1)
+	_, _, _, err := parseMerkleProof(proof, txs[0].TxHash())
+	require.Error(t, err)
+	proof[len(proof)-5] ^= 1
+	_, _, _, err = parseMerkleProof(proof, txs[1].TxHash())
+	require.Error(t, err)
+}
+
+func TestHistory(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.Close()
+
+	ownScript := []byte{txscript.OP_DUP, txscript.OP_HASH160, 0x01}
+	funding := wire.NewMsgTx(1)
+	funding.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	funding.AddTxOut(wire.NewTxOut(1000, ownScript))
+	spending := wire.NewMsgTx(1)
+	spending.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{2}, 0), nil, nil))
+	spending.AddTxIn(wire.NewTxIn(wire.NewOutPoint(ptr(funding.TxHash()), 0), nil, nil))
+	spending.AddTxOut(wire.NewTxOut(900, []byte{txscript.OP_TRUE}))
+	conflicted := testTransactions(1)[0]
+	conflicted.AddTxOut(wire.NewTxOut(1000, ownScript))
+	rawTransactions := map[string]string{
+		funding.TxHash().String():    txHex(funding),
+		spending.TxHash().String():   txHex(spending),
+		conflicted.TxHash().String(): txHex(conflicted),
+	}
+
+	walletCreated := false
+	var imported []interface{}
+	node.handle("getwalletinfo", func([]interface{}) (interface{}, *rpcError) {
+		if !walletCreated {
+			return nil, &rpcError{Code: rpcWalletNotFound, Message: "Requested wallet does not exist or is not loaded"}
+		}
+		return map[string]interface{}{}, nil
+	})
+	node.handle("loadwallet", func([]interface{}) (interface{}, *rpcError) {
+		return nil, &rpcError{Code: rpcWalletNotFound, Message: "Wallet file not found"}
+	})
+	node.handle("createwallet", func(params []interface{}) (interface{}, *rpcError) {
+		require.Equal(t, []interface{}{"bitbox", true, true, "", false, true}, params)
+		walletCreated = true
+		return map[string]interface{}{"name": "bitbox"}, nil
+	})
+	node.result("listdescriptors", map[string]interface{}{"descriptors": []interface{}{}})
+	node.handle("importdescriptors", func(params []interface{}) (interface{}, *rpcError) {
+		imported = params[0].([]interface{})
+		return []interface{}{map[string]interface{}{"success": true}}, nil
+	})
+	node.result("getblockcount", 100)
+	node.result("listtransactions", []interface{}{
+		map[string]interface{}{"txid": funding.TxHash().String(), "confirmations": 11, "blockheight": 90},
+		map[string]interface{}{"txid": spending.TxHash().String(), "confirmations": 0},
+		map[string]interface{}{"txid": conflicted.TxHash().String(), "confirmations": -1},
+	})
+	node.handle("gettransaction", func(params []interface{}) (interface{}, *rpcError) {
+		return map[string]interface{}{"hex": rawTransactions[params[0].(string)]}, nil
+	})
+
+	client := newTestClient(node.config("bitbox"))
+	defer client.Close()
+
+	expectedHistory := blockchain.TxHistory{
+		{Height: 90, TXHash: blockchain.TXHash(funding.TxHash())},
+		{Height: 0, TXHash: blockchain.TXHash(spending.TxHash())},
+	}
+	client.ImportScript(ownScript)
+	statusChan := make(chan string, 1)
+	doneChan := make(chan struct{})
+	client.ScriptHashSubscribe(
+		func() func() { return func() { close(doneChan) } },
+		scriptHash(ownScript),
+		func(status string) error { statusChan <- status; return nil },
+	)
+	select {
+	case status := <-statusChan:
+		require.Equal(t, expectedHistory.Status(), status)
+	case <-time.After(testTimeout):
+		require.Fail(t, "status not received")
+	}
+	select {
+	case <-doneChan:
+	case <-time.After(testTimeout):
+		require.Fail(t, "subscription not finished")
+	}
+	require.True(t, walletCreated)
+	require.True(t, node.called("/wallet/bitbox importdescriptors"))
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"desc":      rawDescriptor(ownScript),
+		"timestamp": float64(1500000000),
+	}}, imported)
+
+	historyChan := make(chan blockchain.TxHistory, 1)
+	client.ScriptHashGetHistory(scriptHash(ownScript),
+		func(history blockchain.TxHistory) error { historyChan <- history; return nil },
+		func() {})
+	select {
+	case history := <-historyChan:
+		require.Equal(t, expectedHistory, history)
+	case <-time.After(testTimeout):
+		require.Fail(t, "history not received")
+	}
+
+	// Wallet transactions are served from the last sync.
+	txChan := make(chan *wire.MsgTx, 1)
+	client.TransactionGet(spending.TxHash(),
+		func(tx *wire.MsgTx) error { txChan <- tx; return nil }, func() {})
+	select {
+	case tx := <-txChan:
+		require.Equal(t, spending.TxHash(), tx.TxHash())
+	case <-time.After(testTimeout):
+		require.Fail(t, "transaction not received")
+	}
+
+	// The funding transaction gets double spent, which changes the status.
+	node.result("listtransactions", []interface{}{
+		map[string]interface{}{"txid": funding.TxHash().String(), "confirmations": -1},
+		map[string]interface{}{"txid": conflicted.TxHash().String(), "confirmations": 1, "blockheight": 100},
+	})
+	select {
+	case status := <-statusChan:
+		require.Equal(t, blockchain.TxHistory{
+			{Height: 100, TXHash: blockchain.TXHash(conflicted.TxHash())},
+		}.Status(), status)
+	case <-time.After(testTimeout):
+		require.Fail(t, "status not received")
+	}
+}
+
+func TestImportRetry(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.Close()
+
+	importedChan := make(chan []interface{}, 10)
+	attempts := 0
+	node.result("listdescriptors", map[string]interface{}{"descriptors": []interface{}{}})
+	node.handle("importdescriptors", func(params []interface{}) (interface{}, *rpcError) {
+		requests := params[0].([]interface{})
+		importedChan <- requests
+		results := []interface{}{}
+		for range requests {
+			attempts++
+			if attempts == 1 {
+				results = append(results, map[string]interface{}{
+					"success": false,
+					"error":   map[string]interface{}{"code": -4, "message": "Rescan failed"},
+				})
+			} else {
+				results = append(results, map[string]interface{}{"success": true})
+			}
+		}
+		return results, nil
+	})
+	node.result("getblockcount", 100)
+	node.result("listtransactions", []interface{}{})
+
+	client := newTestClient(node.config(""))
+	defer client.Close()
+	pkScript := []byte{txscript.OP_DUP, txscript.OP_HASH160, 0x01}
+	client.ImportScript(pkScript)
+
+	// The failed import is retried with the next sync.
+	for i := 0; i < 2; i++ {
+		select {
+		case requests := <-importedChan:
+			require.Equal(t, []interface{}{map[string]interface{}{
+				"desc":      rawDescriptor(pkScript),
+				"timestamp": float64(1500000000),
+			}}, requests)
+		case <-time.After(testTimeout):
+			require.Fail(t, "import not retried")
+		}
+	}
+}
+
+func ptr(hash chainhash.Hash) *chainhash.Hash {
+	return &hash
+}
+
+func TestHeaders(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.Close()
+
+	headers := []*wire.BlockHeader{}
+	prevBlock := chainhash.Hash{}
+	for i := 0; i < 3; i++ {
+		header := &wire.BlockHeader{Version: 1, PrevBlock: prevBlock, Timestamp: time.Unix(int64(i), 0)}
+		headers = append(headers, header)
+		prevBlock = header.BlockHash()
+	}
+	var tipLock sync.Mutex
+	tip := 1
+	node.handle("getblockcount", func([]interface{}) (interface{}, *rpcError) {
+		tipLock.Lock()
+		defer tipLock.Unlock()
+		return tip, nil
+	})
+	node.handle("getblockhash", func(params []interface{}) (interface{}, *rpcError) {
+		return headers[int(params[0].(float64))].BlockHash().String(), nil
+	})
+	node.handle("getblockheader", func(params []interface{}) (interface{}, *rpcError) {
+		require.Equal(t, false, params[1])
+		for _, header := range headers {
+			if header.BlockHash().String() == params[0] {
+				var buffer bytes.Buffer
+				require.NoError(t, header.Serialize(&buffer))
+				return hex.EncodeToString(buffer.Bytes()), nil
+			}
+		}
+		return nil, &rpcError{Code: rpcInvalidAddressOrKey, Message: "Block not found"}
+	})
+	node.result("listtransactions", []interface{}{})
+
+	client := newTestClient(node.config(""))
+	defer client.Close()
+
+	headerChan := make(chan int, 2)
+	client.HeadersSubscribe(
+		nil,
+		func(header *blockchain.Header) error { headerChan <- header.BlockHeight; return nil })
+	receiveHeader := func() int {
+		select {
+		case height := <-headerChan:
+			return height
+		case <-time.After(testTimeout):
+			require.Fail(t, "header not received")
+			return 0
+		}
+	}
+	require.Equal(t, 1, receiveHeader())
+	tipLock.Lock()
+	tip = 2
+	tipLock.Unlock()
+	require.Equal(t, 2, receiveHeader())
+
+	type headersResult struct {
+		headers []*wire.BlockHeader
+		max     int
+	}
+	resultChan := make(chan headersResult, 1)
+	client.Headers(1, 10, func(headers []*wire.BlockHeader, max int) error {
+		resultChan <- headersResult{headers, max}
+		return nil
+	}, func() {})
+	select {
+	case result := <-resultChan:
+		require.Equal(t, headers[1:], result.headers)
+		require.Equal(t, headersPerBatch, result.max)
+	case <-time.After(testTimeout):
+		require.Fail(t, "headers not received")
+	}
+	require.Equal(t, blockchain.CONNECTED, client.ConnectionStatus())
+}
+
+func TestGetMerkle(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.Close()
+
+	txs := testTransactions(5)
+	block, proof := testMerkleProof(t, txs, 3)
+	node.result("getblockcount", 10)
+	node.result("listtransactions", []interface{}{})
+	node.handle("getblockhash", func(params []interface{}) (interface{}, *rpcError) {
+		require.Equal(t, []interface{}{float64(10)}, params)
+		return block.BlockHash().String(), nil
+	})
+	node.handle("gettxoutproof", func(params []interface{}) (interface{}, *rpcError) {
+		require.Equal(t, []interface{}{
+			[]interface{}{txs[3].TxHash().String()}, block.BlockHash().String()}, params)
+		return hex.EncodeToString(proof), nil
+	})
+
+	client := newTestClient(node.config(""))
+	defer client.Close()
+
+	doneChan := make(chan struct{})
+	client.GetMerkle(txs[3].TxHash(), 10, func(merkle []blockchain.TXHash, pos int) error {
+		require.Equal(t, 3, pos)
+		require.Equal(t, block.Header.MerkleRoot, merkleRoot(merkle, txs[3].TxHash(), pos))
+		return nil
+	}, func() { close(doneChan) })
+	select {
+	case <-doneChan:
+	case <-time.After(testTimeout):
+		require.Fail(t, "merkle proof not received")
+	}
+}
+
+func TestFees(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.Close()
+	node.result("getblockcount", 10)
+	node.result("listtransactions", []interface{}{})
+	node.result("getnetworkinfo", map[string]interface{}{"relayfee": 0.00001})
+
+	client := newTestClient(node.config(""))
+	defer client.Close()
+
+	relayFeeChan := make(chan btcutil.Amount, 1)
+	client.RelayFee(func(fee btcutil.Amount) error { relayFeeChan <- fee; return nil }, func() {})
+	select {
+	case fee := <-relayFeeChan:
+		require.Equal(t, btcutil.Amount(1000), fee)
+	case <-time.After(testTimeout):
+		require.Fail(t, "relay fee not received")
+	}
+
+	estimateFee := func() *btcutil.Amount {
+		feeChan := make(chan *btcutil.Amount, 1)
+		client.EstimateFee(2, func(fee *btcutil.Amount) error { feeChan <- fee; return nil }, func() {})
+		select {
+		case fee := <-feeChan:
+			return fee
+		case <-time.After(testTimeout):
+			require.Fail(t, "fee not received")
+			return nil
+		}
+	}
+	node.result("estimatesmartfee", map[string]interface{}{"feerate": 0.0002, "blocks": 2})
+	fee := estimateFee()
+	require.NotNil(t, fee)
+	require.Equal(t, btcutil.Amount(20000), *fee)
+	node.result("estimatesmartfee", map[string]interface{}{
+		"errors": []string{"Insufficient data or no feerate found"}, "blocks": 0})
+	require.Nil(t, estimateFee())
+}
+
+func TestTransactionBroadcast(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.Close()
+	node.result("getblockcount", 10)
+	node.result("listtransactions", []interface{}{})
+	tx := testTransactions(1)[0]
+	node.handle("sendrawtransaction", func(params []interface{}) (interface{}, *rpcError) {
+		require.Equal(t, []interface{}{txHex(tx)}, params)
+		return tx.TxHash().String(), nil
+	})
+
+	client := newTestClient(node.config(""))
+	defer client.Close()
+	require.NoError(t, client.TransactionBroadcast(tx))
+
+	node.handle("sendrawtransaction", func([]interface{}) (interface{}, *rpcError) {
+		return nil, &rpcError{Code: -26, Message: "min relay fee not met"}
+	})
+	err := client.TransactionBroadcast(tx)
+	require.Error(t, err)
+	require.True(t, isRPCError(err, -26))
+}
+
+func TestConnectionStatus(t *testing.T) {
+	node := newFakeNode(t)
+	node.result("getblockcount", 10)
+	node.result("listtransactions", []interface{}{})
+	client := newTestClient(node.config(""))
+	defer client.Close()
+
+	statusChan := make(chan blockchain.Status, 1)
+	client.RegisterOnConnectionStatusChangedEvent(func(status blockchain.Status) { statusChan <- status })
+	node.Close()
+	select {
+	case status := <-statusChan:
+		require.Equal(t, blockchain.DISCONNECTED, status)
+	case <-time.After(testTimeout):
+		require.Fail(t, "status not received")
+	}
+	require.Equal(t, blockchain.DISCONNECTED, client.ConnectionStatus())
+}
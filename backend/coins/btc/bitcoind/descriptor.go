@@ -0,0 +1,80 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitcoind
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	descriptorInputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+		"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+		"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+func descriptorPolymod(checksum uint64, value uint64) uint64 {
+	generator := [5]uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+	top := checksum >> 35
+	checksum = (checksum&0x7ffffffff)<<5 ^ value
+	for i, value := range generator {
+		if (top>>uint(i))&1 == 1 {
+			checksum ^= value
+		}
+	}
+	return checksum
+}
+
+// descriptorChecksum returns the checksum of an output script descriptor, see
+// https://github.com/bitcoin/bips/blob/master/bip-0380.mediawiki#checksum.
+func descriptorChecksum(descriptor string) (string, bool) {
+	checksum := uint64(1)
+	var class, classCount uint64
+	for _, char := range descriptor {
+		position := strings.IndexRune(descriptorInputCharset, char)
+		if position == -1 {
+			return "", false
+		}
+		checksum = descriptorPolymod(checksum, uint64(position&31))
+		class = class*3 + uint64(position>>5)
+		classCount++
+		if classCount == 3 {
+			checksum = descriptorPolymod(checksum, class)
+			class = 0
+			classCount = 0
+		}
+	}
+	if classCount > 0 {
+		checksum = descriptorPolymod(checksum, class)
+	}
+	for i := 0; i < 8; i++ {
+		checksum = descriptorPolymod(checksum, 0)
+	}
+	checksum ^= 1
+	result := make([]byte, 8)
+	for i := range result {
+		result[i] = descriptorChecksumCharset[(checksum>>(5*(7-uint(i))))&31]
+	}
+	return string(result), true
+}
+
+// rawDescriptor returns the descriptor of a pubkey script including the checksum.
+func rawDescriptor(pkScript []byte) string {
+	descriptor := "raw(" + hex.EncodeToString(pkScript) + ")"
+	// Hex characters are always in the input charset.
+	checksum, _ := descriptorChecksum(descriptor)
+	return descriptor + "#" + checksum
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitcoind
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// partialMerkleTree extracts the merkle branch of the single matched transaction of a merkle
+// block, as returned by gettxoutproof. See BIP37 for the format.
+type partialMerkleTree struct {
+	numTransactions uint32
+	hashes          []*chainhash.Hash
+	flags           []byte
+	hashesUsed      int
+	bitsUsed        int
+
+	matched *chainhash.Hash
+	pos     int
+	// branch are the siblings on the path from the matched transaction to the root.
+	branch []blockchain.TXHash
+}
+
+func (tree *partialMerkleTree) width(height uint) uint32 {
+	return (tree.numTransactions + (1 << height) - 1) >> height
+}
+
+func (tree *partialMerkleTree) nextBit() (bool, error) {
+	if tree.bitsUsed >= len(tree.flags)*8 {
+		return false, errp.New("merkle proof: not enough flag bits")
+	}
+	bit := (tree.flags[tree.bitsUsed/8]>>uint(tree.bitsUsed%8))&1 == 1
+	tree.bitsUsed++
+	return bit, nil
+}
+
+func (tree *partialMerkleTree) nextHash() (*chainhash.Hash, error) {
+	if tree.hashesUsed >= len(tree.hashes) {
+		return nil, errp.New("merkle proof: not enough hashes")
+	}
+	hash := tree.hashes[tree.hashesUsed]
+	tree.hashesUsed++
+	return hash, nil
+}
+
+// traverse returns the hash of the node at the given height and position. The second return value
+// is true if the matched transaction is below this node.
+func (tree *partialMerkleTree) traverse(height uint, pos uint32) (chainhash.Hash, bool, error) {
+	parentOfMatch, err := tree.nextBit()
+	if err != nil {
+		return chainhash.Hash{}, false, err
+	}
+	if height == 0 || !parentOfMatch {
+		hash, err := tree.nextHash()
+		if err != nil {
+			return chainhash.Hash{}, false, err
+		}
+		if height == 0 && parentOfMatch {
+			if tree.matched != nil {
+				return chainhash.Hash{}, false, errp.New("merkle proof: more than one transaction matched")
+			}
+			tree.matched = hash
+			tree.pos = int(pos)
+		}
+		return *hash, height == 0 && parentOfMatch, nil
+	}
+	left, leftMatched, err := tree.traverse(height-1, pos*2)
+	if err != nil {
+		return chainhash.Hash{}, false, err
+	}
+	right, rightMatched := left, false
+	if pos*2+1 < tree.width(height-1) {
+		right, rightMatched, err = tree.traverse(height-1, pos*2+1)
+		if err != nil {
+			return chainhash.Hash{}, false, err
+		}
+		if right == left {
+			// CVE-2012-2459
+			return chainhash.Hash{}, false, errp.New("merkle proof: duplicate hashes")
+		}
+	}
+	switch {
+	case leftMatched:
+		tree.branch = append(tree.branch, blockchain.TXHash(right))
+	case rightMatched:
+		tree.branch = append(tree.branch, blockchain.TXHash(left))
+	}
+	return chainhash.DoubleHashH(append(left[:], right[:]...)), leftMatched || rightMatched, nil
+}
+
+// parseMerkleProof parses the hex decoded output of gettxoutproof for a single transaction.
+// Returns the header of the block and the merkle branch and position of the transaction in the
+// format of Electrum's blockchain.transaction.get_merkle.
+func parseMerkleProof(proof []byte, txHash chainhash.Hash) (
+	*wire.BlockHeader, []blockchain.TXHash, int, error) {
+	merkleBlock := &wire.MsgMerkleBlock{}
+	if err := merkleBlock.BtcDecode(bytes.NewReader(proof), wire.ProtocolVersion, wire.BaseEncoding); err != nil {
+		return nil, nil, 0, errp.WithStack(err)
+	}
+	if merkleBlock.Transactions == 0 {
+		return nil, nil, 0, errp.New("merkle proof: no transactions")
+	}
+	tree := &partialMerkleTree{
+		numTransactions: merkleBlock.Transactions,
+		hashes:          merkleBlock.Hashes,
+		flags:           merkleBlock.Flags,
+	}
+	var height uint
+	for tree.width(height) > 1 {
+		height++
+	}
+	root, _, err := tree.traverse(height, 0)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if tree.hashesUsed != len(tree.hashes) || (tree.bitsUsed+7)/8 != len(tree.flags) {
+		return nil, nil, 0, errp.New("merkle proof: not all data was consumed")
+	}
+	if root != merkleBlock.Header.MerkleRoot {
+		return nil, nil, 0, errp.New("merkle proof: merkle root mismatch")
+	}
+	if tree.matched == nil || *tree.matched != txHash {
+		return nil, nil, 0, errp.Newf("merkle proof: does not prove %s", txHash)
+	}
+	return &merkleBlock.Header, tree.branch, tree.pos, nil
+}
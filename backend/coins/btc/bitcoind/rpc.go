@@ -0,0 +1,235 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitcoind
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Error codes of Bitcoin Core, see src/rpc/protocol.h.
+const (
+	rpcInvalidAddressOrKey = -5
+	rpcWalletError         = -4
+	rpcWalletNotFound      = -18
+	rpcWalletAlreadyLoaded = -35
+)
+
+// Config configures the connection to a Bitcoin Core node.
+type Config struct {
+	// URL is the address of the JSON-RPC interface, e.g. http://127.0.0.1:8332.
+	URL      string `json:"url"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// CookieFile is the path to the .cookie file of the node. It is used if no user is set.
+	CookieFile string `json:"cookieFile"`
+	// Wallet is the name of the watch-only descriptor wallet into which the scripts of the
+	// accounts are imported. It is created if it does not exist. If empty, the default wallet of
+	// the node is used.
+	Wallet string `json:"wallet"`
+	// ImportTimestamp is the UNIX time from which the node rescans the chain for transactions of
+	// newly imported scripts. 0 rescans the whole chain.
+	ImportTimestamp int64 `json:"importTimestamp"`
+}
+
+// rpcError is an error returned by the node, as opposed to a connection error.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *rpcError) Error() string {
+	return fmt.Sprintf("bitcoind error %d: %s", err.Code, err.Message)
+}
+
+// isRPCError returns true if the error is an rpcError with the given code.
+func isRPCError(err error, code int) bool {
+	rpcErr, ok := errp.Cause(err).(*rpcError)
+	return ok && rpcErr.Code == code
+}
+
+// connectionError is returned if the node could not be reached or returned an unexpected reply.
+type connectionError struct {
+	error
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int32         `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+	ID     int32           `json:"id"`
+}
+
+// request is a single call in a batch. The result is json-deserialized into result.
+type request struct {
+	method string
+	params []interface{}
+	result interface{}
+}
+
+// rpcClient does JSON-RPC calls over HTTP.
+type rpcClient struct {
+	config     *Config
+	httpClient *http.Client
+	msgID      int32
+}
+
+func newRPCClient(config *Config, httpClient *http.Client) *rpcClient {
+	return &rpcClient{config: config, httpClient: httpClient}
+}
+
+func (client *rpcClient) credentials() (string, string, error) {
+	if client.config.User != "" || client.config.CookieFile == "" {
+		return client.config.User, client.config.Password, nil
+	}
+	cookie, err := ioutil.ReadFile(client.config.CookieFile)
+	if err != nil {
+		return "", "", errp.WithStack(err)
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(cookie)), ":", 2)
+	if len(parts) != 2 {
+		return "", "", errp.New("invalid cookie file")
+	}
+	return parts[0], parts[1], nil
+}
+
+// post sends the JSON-RPC body to the node and returns the response body. Wallet calls are sent to
+// the endpoint of the configured wallet.
+func (client *rpcClient) post(wallet bool, body interface{}) ([]byte, error) {
+	endpoint := strings.TrimSuffix(client.config.URL, "/") + "/"
+	if wallet && client.config.Wallet != "" {
+		endpoint += "wallet/" + url.PathEscape(client.config.Wallet)
+	}
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	httpRequest, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	user, password, err := client.credentials()
+	if err != nil {
+		return nil, &connectionError{err}
+	}
+	httpRequest.SetBasicAuth(user, password)
+	httpRequest.Header.Set("Content-Type", "application/json")
+	response, err := client.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, &connectionError{errp.WithStack(err)}
+	}
+	defer func() { _ = response.Body.Close() }()
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, &connectionError{errp.WithStack(err)}
+	}
+	// Bitcoin Core replies to failed calls with an error status and a JSON-RPC error in the body.
+	if response.StatusCode != http.StatusOK && len(bytes.TrimSpace(responseBytes)) == 0 {
+		return nil, &connectionError{errp.Newf("unexpected HTTP status %s", response.Status)}
+	}
+	return responseBytes, nil
+}
+
+func (client *rpcClient) newRequest(method string, params []interface{}) *rpcRequest {
+	if params == nil {
+		params = []interface{}{}
+	}
+	return &rpcRequest{
+		JSONRPC: "1.0",
+		ID:      atomic.AddInt32(&client.msgID, 1),
+		Method:  method,
+		Params:  params,
+	}
+}
+
+func unmarshalResult(response *rpcResponse, result interface{}) error {
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return &connectionError{errp.Wrap(err, "Failed to unmarshal JSON")}
+	}
+	return nil
+}
+
+// call does a JSON-RPC call. The result is json-deserialized into result.
+func (client *rpcClient) call(wallet bool, result interface{}, method string, params ...interface{}) error {
+	rpcReq := client.newRequest(method, params)
+	responseBytes, err := client.post(wallet, rpcReq)
+	if err != nil {
+		return err
+	}
+	response := &rpcResponse{}
+	if err := json.Unmarshal(responseBytes, response); err != nil {
+		return &connectionError{errp.Wrap(err, "Failed to unmarshal JSON")}
+	}
+	return unmarshalResult(response, result)
+}
+
+// batch does the calls in one HTTP request. Returns the first error of any of the calls.
+func (client *rpcClient) batch(wallet bool, requests []*request) error {
+	if len(requests) == 0 {
+		return nil
+	}
+	rpcRequests := make([]*rpcRequest, len(requests))
+	requestsByID := map[int32]*request{}
+	for index, req := range requests {
+		rpcRequests[index] = client.newRequest(req.method, req.params)
+		requestsByID[rpcRequests[index].ID] = req
+	}
+	responseBytes, err := client.post(wallet, rpcRequests)
+	if err != nil {
+		return err
+	}
+	responses := []*rpcResponse{}
+	if err := json.Unmarshal(responseBytes, &responses); err != nil {
+		// A batch which is rejected as a whole is answered with a single error.
+		response := &rpcResponse{}
+		if json.Unmarshal(responseBytes, response) == nil && response.Error != nil {
+			return response.Error
+		}
+		return &connectionError{errp.Wrap(err, "Failed to unmarshal JSON")}
+	}
+	if len(responses) != len(requests) {
+		return &connectionError{errp.Newf("expected %d responses, got %d", len(requests), len(responses))}
+	}
+	for _, response := range responses {
+		req, ok := requestsByID[response.ID]
+		if !ok {
+			return &connectionError{errp.Newf("unexpected response ID %d", response.ID)}
+		}
+		if err := unmarshalResult(response, req.result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
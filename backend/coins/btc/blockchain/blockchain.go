@@ -25,6 +25,7 @@ import (
 	"github.com/btcsuite/btcutil"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
 )
 
 // TXHash wraps chainhash.Hash for json deserialization.
@@ -113,3 +114,27 @@ type Interface interface {
 	ConnectionStatus() Status
 	RegisterOnConnectionStatusChangedEvent(func(Status))
 }
+
+// ScriptImporter is implemented by backends which only track the scripts they have been told
+// about, e.g. a Bitcoin Core wallet. Accounts import the pubkey script of an address before
+// subscribing to its script hash.
+type ScriptImporter interface {
+	ImportScript(pkScript []byte)
+}
+
+// Disagreement describes two servers reporting different values for the same query, e.g. the tip
+// height or a fee estimate.
+type Disagreement struct {
+	Subject     string `json:"subject"`
+	Server      string `json:"server"`
+	Value       string `json:"value"`
+	OtherServer string `json:"otherServer"`
+	OtherValue  string `json:"otherValue"`
+}
+
+// ServerPool is implemented by backends which connect to one of several servers. They report the
+// health of each server and cross-check the responses of the active server with another one.
+type ServerPool interface {
+	Servers() []*rpc.ServerHealth
+	RegisterOnDisagreement(func(*Disagreement))
+}
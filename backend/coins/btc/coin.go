@@ -21,17 +21,22 @@ import (
 	"strings"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
 	"github.com/sirupsen/logrus"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/signet"
 	coinpkg "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/headersdb"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable/action"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 )
 
 // Coin models a Bitcoin-related coin.
@@ -41,13 +46,16 @@ type Coin struct {
 	net                   *chaincfg.Params
 	dbFolder              string
 	servers               []*rpc.ServerInfo
+	socksProxy            *socksproxy.SocksProxy
 	blockExplorerTxPrefix string
 
 	ratesUpdater coinpkg.RatesUpdater
 	observable.Implementation
 
 	blockchain blockchain.Interface
-	headers    *headers.Headers
+	pinner     electrum.CertificatePinner
+
+	headers *headers.Headers
 
 	log *logrus.Entry
 }
@@ -59,6 +67,7 @@ func NewCoin(
 	net *chaincfg.Params,
 	dbFolder string,
 	servers []*rpc.ServerInfo,
+	socksProxy *socksproxy.SocksProxy,
 	blockExplorerTxPrefix string,
 	ratesUpdater coinpkg.RatesUpdater,
 ) *Coin {
@@ -68,6 +77,7 @@ func NewCoin(
 		net:                   net,
 		dbFolder:              dbFolder,
 		servers:               servers,
+		socksProxy:            socksProxy,
 		blockExplorerTxPrefix: blockExplorerTxPrefix,
 		ratesUpdater:          ratesUpdater,
 
@@ -76,22 +86,58 @@ func NewCoin(
 	return coin
 }
 
+// SetBlockchain makes the coin use the given blockchain backend instead of connecting to its
+// Electrum servers. Must be called before Init.
+func (coin *Coin) SetBlockchain(blockchain blockchain.Interface) {
+	coin.blockchain = blockchain
+}
+
+// SetCertificatePinner sets the store of the certificates pinned for the Electrum servers verified
+// with rpc.VerificationTOFU. Must be called before Init.
+func (coin *Coin) SetCertificatePinner(pinner electrum.CertificatePinner) {
+	coin.pinner = pinner
+}
+
+// chainTag distinguishes the database files of the signets, which all share the coin code, by their
+// network magic, a hash of the challenge. It is empty for other chains.
+func (coin *Coin) chainTag() string {
+	if !signet.IsSignet(coin.net) {
+		return ""
+	}
+	return fmt.Sprintf("-%08x", uint32(coin.net.Net))
+}
+
 // Init initializes the coin - blockchain and headers.
 func (coin *Coin) Init() {
 	// Init blockchain
-	coin.blockchain = electrum.NewElectrumConnection(coin.servers, coin.log)
+	if coin.blockchain == nil {
+		coin.blockchain = electrum.NewElectrumConnection(
+			coin.servers, client.NewCheckpoint(coin.net), coin.socksProxy, coin.pinner, coin.log)
+	}
 
 	// Init Headers
 	db, err := headersdb.NewDB(
-		path.Join(coin.dbFolder, fmt.Sprintf("headers-%s.db", coin.code)))
+		path.Join(coin.dbFolder, fmt.Sprintf("headers-%s%s.db", coin.code, coin.chainTag())))
 	if err != nil {
 		coin.log.WithError(err).Panic("Could not open headers DB")
 	}
+	if reset := db.Migration().Reset; reset != nil {
+		coin.log.WithError(reset).Warn("Could not migrate the headers DB, resyncing the headers")
+		coin.Notify(observable.Event{
+			Subject: fmt.Sprintf("coins/%s/headers/reset", coin.code),
+			Action:  action.Replace,
+			Object:  reset.Error(),
+		})
+	}
 	coin.headers = headers.NewHeaders(
 		coin.net,
 		db,
 		coin.blockchain,
 		coin.log)
+	coin.headers.SetCheckpoint(headers.NewCheckpoint(coin.net))
+	if consumer, ok := coin.blockchain.(headers.Consumer); ok {
+		consumer.SetHeaders(coin.headers)
+	}
 	coin.headers.Init()
 	coin.headers.SubscribeEvent(func(event headers.Event) {
 		if event == headers.EventSyncing || event == headers.EventSynced {
@@ -107,6 +153,16 @@ func (coin *Coin) Init() {
 		}
 	})
 
+	if pool, ok := coin.blockchain.(blockchain.ServerPool); ok {
+		pool.RegisterOnDisagreement(func(disagreement *blockchain.Disagreement) {
+			coin.Notify(observable.Event{
+				Subject: fmt.Sprintf("coins/%s/servers/disagreement", coin.code),
+				Action:  action.Append,
+				Object:  disagreement,
+			})
+		})
+	}
+
 	if coin.ratesUpdater != nil {
 		coin.ratesUpdater.Observe(coin.Notify)
 	}
@@ -135,6 +191,24 @@ func (coin *Coin) FormatAmount(amount coinpkg.Amount) string {
 	)
 }
 
+// ParseAmount implements coin.Coin.
+func (coin *Coin) ParseAmount(amount string) (coinpkg.Amount, error) {
+	return coinpkg.NewAmountFromString(amount, big.NewInt(unitSatoshi))
+}
+
+// DecodeAddress decodes an address of the given network. coin.ErrInvalidAddress is returned if the
+// address is malformed or belongs to a different network.
+func DecodeAddress(address string, net *chaincfg.Params) (btcutil.Address, error) {
+	decoded, err := btcutil.DecodeAddress(address, net)
+	if err != nil {
+		return nil, errp.WithStack(coinpkg.ErrInvalidAddress)
+	}
+	if !decoded.IsForNet(net) {
+		return nil, errp.WithStack(coinpkg.ErrInvalidAddress)
+	}
+	return decoded, nil
+}
+
 // RatesUpdater returns current exchange rates.
 func (coin *Coin) RatesUpdater() coinpkg.RatesUpdater {
 	return coin.ratesUpdater
@@ -150,6 +224,15 @@ func (coin *Coin) Headers() *headers.Headers {
 	return coin.headers
 }
 
+// Servers returns the health of the servers the blockchain backend connects to. It is empty if
+// the backend does not connect to a pool of servers.
+func (coin *Coin) Servers() []*rpc.ServerHealth {
+	if pool, ok := coin.blockchain.(blockchain.ServerPool); ok {
+		return pool.Servers()
+	}
+	return []*rpc.ServerHealth{}
+}
+
 func (coin *Coin) String() string {
 	return coin.code
 }
@@ -0,0 +1,156 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package electrum
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// CertificateChangedError is returned when the certificate of a server verified with
+// rpc.VerificationTOFU does not match the pinned one.
+type CertificateChangedError struct {
+	Server            string `json:"server"`
+	PinnedFingerprint string `json:"pinnedFingerprint"`
+	Fingerprint       string `json:"fingerprint"`
+}
+
+func (err *CertificateChangedError) Error() string {
+	return fmt.Sprintf("The certificate of %s changed from %s to %s",
+		err.Server, err.PinnedFingerprint, err.Fingerprint)
+}
+
+// CertificatePinner stores the certificates pinned with rpc.VerificationTOFU.
+type CertificatePinner interface {
+	// PinnedFingerprint returns the pinned fingerprint of the server, or "" if none is pinned.
+	PinnedFingerprint(server string) string
+	// PinFingerprint pins the fingerprint of the server on the first connection.
+	PinFingerprint(server string, fingerprint string) error
+	// CertificateChanged is called when a connection is rejected because the certificate changed.
+	CertificateChanged(err *CertificateChangedError)
+}
+
+// Fingerprint returns the hex encoded SHA256 hash of a DER encoded certificate.
+func Fingerprint(rawCert []byte) string {
+	hash := sha256.Sum256(rawCert)
+	return hex.EncodeToString(hash[:])
+}
+
+// tlsConfig returns the TLS configuration verifying the server certificate as configured in the
+// server info.
+func tlsConfig(serverInfo *rpc.ServerInfo, pinner CertificatePinner) (*tls.Config, error) {
+	switch serverInfo.Verification {
+	case rpc.VerificationPEMCert:
+		return pemCertConfig(serverInfo.PEMCert)
+	case rpc.VerificationCA:
+		host, _, err := net.SplitHostPort(serverInfo.Server)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		return &tls.Config{ServerName: host}, nil
+	case rpc.VerificationTOFU:
+		return tofuConfig(serverInfo, pinner), nil
+	default:
+		return nil, errp.Newf("Unknown certificate verification %s", serverInfo.Verification)
+	}
+}
+
+func pemCertConfig(rootCert string) (*tls.Config, error) {
+	caCertPool := x509.NewCertPool()
+	if ok := caCertPool.AppendCertsFromPEM([]byte(rootCert)); !ok {
+		return nil, errp.New("Failed to append CA cert as trusted cert")
+	}
+	return &tls.Config{
+		RootCAs:            caCertPool,
+		InsecureSkipVerify: true, // Not actually skipping, we check the cert in VerifyPeerCertificate
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			// Code copy/pasted and adapted from
+			// https://github.com/golang/go/blob/81555cb4f3521b53f9de4ce15f64b77cc9df61b9/src/crypto/tls/handshake_client.go#L327-L344, but adapted to skip the hostname verification.
+			// See https://github.com/golang/go/issues/21971#issuecomment-412836078.
+
+			// If this is the first handshake on a connection, process and
+			// (optionally) verify the server's certificates.
+			certs := make([]*x509.Certificate, len(rawCerts))
+			for i, asn1Data := range rawCerts {
+				cert, err := x509.ParseCertificate(asn1Data)
+				if err != nil {
+					return errp.New("bitbox/electrum: failed to parse certificate from server: " + err.Error())
+				}
+				certs[i] = cert
+			}
+
+			opts := x509.VerifyOptions{
+				Roots:         caCertPool,
+				CurrentTime:   time.Now(),
+				DNSName:       "", // <- skip hostname verification
+				Intermediates: x509.NewCertPool(),
+			}
+
+			for i, cert := range certs {
+				if i == 0 {
+					continue
+				}
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(opts)
+			return err
+		},
+	}, nil
+}
+
+// tofuConfig accepts the certificate the server presents on the first connection and pins its
+// fingerprint. Later connections are rejected if the certificate does not match the pinned one.
+// Without a pinner, the fingerprint in the server info is used.
+func tofuConfig(serverInfo *rpc.ServerInfo, pinner CertificatePinner) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true, // Not actually skipping, we check the cert in VerifyPeerCertificate
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errp.New("no remote certs")
+			}
+			fingerprint := Fingerprint(rawCerts[0])
+			pinned := serverInfo.Fingerprint
+			if pinner != nil {
+				pinned = pinner.PinnedFingerprint(serverInfo.Server)
+			}
+			switch {
+			case pinned == "":
+				if pinner == nil {
+					return nil
+				}
+				return pinner.PinFingerprint(serverInfo.Server, fingerprint)
+			case pinned != fingerprint:
+				err := &CertificateChangedError{
+					Server:            serverInfo.Server,
+					PinnedFingerprint: pinned,
+					Fingerprint:       fingerprint,
+				}
+				if pinner != nil {
+					pinner.CertificateChanged(err)
+				}
+				return err
+			}
+			return nil
+		},
+	}
+}
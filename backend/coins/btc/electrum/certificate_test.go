@@ -0,0 +1,156 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package electrum_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+)
+
+// newCertificate creates a self-signed certificate for localhost.
+func newCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveTLS accepts TLS connections with the given certificate and returns the server address.
+func serveTLS(t *testing.T, certificate tls.Certificate) string {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0",
+		&tls.Config{Certificates: []tls.Certificate{certificate}})
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+type pinnerMock struct {
+	fingerprints map[string]string
+	changed      []*electrum.CertificateChangedError
+}
+
+func (pinner *pinnerMock) PinnedFingerprint(server string) string {
+	return pinner.fingerprints[server]
+}
+
+func (pinner *pinnerMock) PinFingerprint(server string, fingerprint string) error {
+	pinner.fingerprints[server] = fingerprint
+	return nil
+}
+
+func (pinner *pinnerMock) CertificateChanged(err *electrum.CertificateChangedError) {
+	pinner.changed = append(pinner.changed, err)
+}
+
+func connect(serverInfo *rpc.ServerInfo, pinner electrum.CertificatePinner) error {
+	conn, err := electrum.NewElectrum(logging.Get().WithGroup("electrum_test"), serverInfo,
+		socksproxy.NewSocksProxy(socksproxy.Config{}), pinner).EstablishConnection()
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestTOFU(t *testing.T) {
+	certificate := newCertificate(t)
+	fingerprint := electrum.Fingerprint(certificate.Certificate[0])
+	server := serveTLS(t, certificate)
+	serverInfo := &rpc.ServerInfo{Server: server, TLS: true, Verification: rpc.VerificationTOFU}
+	pinner := &pinnerMock{fingerprints: map[string]string{}}
+
+	// The certificate is pinned on first use.
+	require.NoError(t, connect(serverInfo, pinner))
+	require.Equal(t, fingerprint, pinner.fingerprints[server])
+	require.NoError(t, connect(serverInfo, pinner))
+	require.Empty(t, pinner.changed)
+
+	// A changed certificate is rejected.
+	pinner.fingerprints[server] = electrum.Fingerprint([]byte("other certificate"))
+	require.Error(t, connect(serverInfo, pinner))
+	require.Len(t, pinner.changed, 1)
+	require.Equal(t, &electrum.CertificateChangedError{
+		Server:            server,
+		PinnedFingerprint: electrum.Fingerprint([]byte("other certificate")),
+		Fingerprint:       fingerprint,
+	}, pinner.changed[0])
+
+	// The changed certificate is accepted after the approval.
+	pinner.fingerprints[server] = fingerprint
+	require.NoError(t, connect(serverInfo, pinner))
+	require.Len(t, pinner.changed, 1)
+
+	// Without a pinner, the fingerprint of the server info is used.
+	require.NoError(t, connect(
+		&rpc.ServerInfo{Server: server, TLS: true, Verification: rpc.VerificationTOFU, Fingerprint: fingerprint},
+		nil))
+	require.Error(t, connect(
+		&rpc.ServerInfo{Server: server, TLS: true, Verification: rpc.VerificationTOFU, Fingerprint: "00"},
+		nil))
+}
+
+func TestVerification(t *testing.T) {
+	certificate := newCertificate(t)
+	server := serveTLS(t, certificate)
+	pemCert := string(pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}))
+
+	require.NoError(t, connect(&rpc.ServerInfo{Server: server, TLS: true, PEMCert: pemCert}, nil))
+	otherPEMCert := string(pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: newCertificate(t).Certificate[0]}))
+	require.Error(t, connect(&rpc.ServerInfo{Server: server, TLS: true, PEMCert: otherPEMCert}, nil))
+
+	// The self-signed certificate is not trusted by the system.
+	require.Error(t, connect(
+		&rpc.ServerInfo{Server: server, TLS: true, Verification: rpc.VerificationCA}, nil))
+	require.Error(t, connect(
+		&rpc.ServerInfo{Server: server, TLS: true, Verification: "unknown"}, nil))
+}
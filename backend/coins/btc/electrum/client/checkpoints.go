@@ -0,0 +1,49 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// bundledCheckpoints are the checkpoints against which the headers retrieved from Electrum servers
+// are verified, by the genesis hash of the network. The root is the merkle root of the block hashes
+// up to the height, as reported in the root field of blockchain.block.headers with cp_height set to
+// the height. They have to be taken from a trusted node.
+var bundledCheckpoints = map[chainhash.Hash]struct {
+	height int
+	root   string
+}{
+	// The block hashes up to block 255, as in the mainnet blocks in the test data of btcd
+	// (database/testdata).
+	*chaincfg.MainNetParams.GenesisHash: {
+		height: 255,
+		root:   "0f799d144e131ca41b652c50c0818ffd1f410d8b8e32f45e9c38a1f4fbd2bad5",
+	},
+}
+
+// NewCheckpoint returns the bundled checkpoint of the network, or nil if there is none.
+func NewCheckpoint(net *chaincfg.Params) *Checkpoint {
+	bundled, ok := bundledCheckpoints[*net.GenesisHash]
+	if !ok {
+		return nil
+	}
+	root, err := chainhash.NewHashFromStr(bundled.root)
+	if err != nil {
+		panic(err)
+	}
+	return &Checkpoint{Height: bundled.height, Root: *root}
+}
@@ -24,21 +24,18 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	clientVersion         = "0.0.1"
-	clientProtocolVersion = "1.2"
-)
-
 // ElectrumClient is a high level API access to an ElectrumX server.
 // See https://github.com/kyuupichan/electrumx/blob/159db3f8e70b2b2cbb8e8cd01d1e9df3fe83828f/docs/PROTOCOL.rst.
 type ElectrumClient struct {
@@ -47,6 +44,15 @@ type ElectrumClient struct {
 	scriptHashNotificationCallbacks     map[string]func(string) error
 	scriptHashNotificationCallbacksLock sync.RWMutex
 
+	onDisagreement  []func(*blockchain.Disagreement)
+	lastCrossChecks map[string]time.Time
+	crossCheckLock  sync.Mutex
+
+	capabilities     Capabilities
+	negotiated       bool
+	capabilitiesLock locker.Locker
+	checkpoint       *Checkpoint
+
 	close bool
 	log   *logrus.Entry
 }
@@ -56,6 +62,8 @@ func NewElectrumClient(rpcClient rpc.Client, log *logrus.Entry) *ElectrumClient
 	electrumClient := &ElectrumClient{
 		rpc:                             rpcClient,
 		scriptHashNotificationCallbacks: map[string]func(string) error{},
+		lastCrossChecks:                 map[string]time.Time{},
+		capabilities:                    Capabilities{ProtocolVersion: clientProtocolMin},
 		log:                             log.WithField("group", "client"),
 	}
 	// Install a callback for the scripthash notifications, which directs the response to callbacks
@@ -91,18 +99,20 @@ func NewElectrumClient(rpcClient rpc.Client, log *logrus.Entry) *ElectrumClient
 	rpcClient.OnConnect(func() error {
 		// Sends the version and must be the first message, to establish which methods the server
 		// accepts.
-		version, err := electrumClient.ServerVersion()
-		if err != nil {
-			return err
-		}
-		log.WithField("server-version", version).Debug("electrumx server version")
-		return nil
+		return electrumClient.negotiate()
 	})
-	rpcClient.RegisterHeartbeat("server.version", clientVersion, clientProtocolVersion)
+	// server.version may only be sent once per connection since protocol 1.4.
+	rpcClient.RegisterHeartbeat("server.ping")
 
 	return electrumClient
 }
 
+// SetCheckpoint makes the client verify the headers up to the checkpoint height against the
+// checkpoint, if the server supports it. Must be called before requesting headers.
+func (client *ElectrumClient) SetCheckpoint(checkpoint *Checkpoint) {
+	client.checkpoint = checkpoint
+}
+
 // ConnectionStatus returns the current connection status of the backend.
 func (client *ElectrumClient) ConnectionStatus() blockchain.Status {
 	switch client.rpc.ConnectionStatus() {
@@ -151,17 +161,26 @@ func (version *ServerVersion) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-// ServerVersion does the server.version() RPC call.
-// https://github.com/kyuupichan/electrumx/blob/159db3f8e70b2b2cbb8e8cd01d1e9df3fe83828f/docs/PROTOCOL.rst#serverversion
+// ServerVersion does the server.version() RPC call, negotiating the highest protocol version
+// supported by both the client and the server. Since protocol 1.4, it must only be called once per
+// connection, which is done when connecting. See Capabilities() for the negotiated version.
+// https://github.com/kyuupichan/electrumx/blob/1.4/docs/protocol-methods.rst#serverversion
 func (client *ElectrumClient) ServerVersion() (*ServerVersion, error) {
 	response := &ServerVersion{}
-	err := client.rpc.MethodSync(response, "server.version", clientVersion, clientProtocolVersion)
+	err := client.rpc.MethodSync(response, "server.version",
+		clientVersion, []string{clientProtocolMin, clientProtocolMax})
 	return response, err
 }
 
 // ServerFeatures is returned by ServerFeatures().
 type ServerFeatures struct {
-	GenesisHash string `json:"genesis_hash"`
+	GenesisHash   string `json:"genesis_hash"`
+	HashFunction  string `json:"hash_function"`
+	ServerVersion string `json:"server_version"`
+	ProtocolMin   string `json:"protocol_min"`
+	ProtocolMax   string `json:"protocol_max"`
+	// Pruning is the pruning limit of the server, or nil if the server does not prune.
+	Pruning *int `json:"pruning"`
 }
 
 // ServerFeatures does the server.features() RPC call.
@@ -196,6 +215,7 @@ func (client *ElectrumClient) ScriptHashGetBalance(
 		func() func() {
 			return cleanup
 		},
+		nil,
 		"blockchain.scripthash.get_balance",
 		scriptHashHex)
 }
@@ -219,6 +239,7 @@ func (client *ElectrumClient) ScriptHashGetHistory(
 		func() func() {
 			return cleanup
 		},
+		nil,
 		"blockchain.scripthash.get_history",
 		string(scriptHashHex))
 }
@@ -246,6 +267,7 @@ func (client *ElectrumClient) ScriptHashSubscribe(
 			return success(*response)
 		},
 		setupAndTeardown,
+		nil,
 		"blockchain.scripthash.subscribe",
 		string(scriptHashHex))
 }
@@ -284,6 +306,7 @@ func (client *ElectrumClient) TransactionGet(
 		func() func() {
 			return cleanup
 		},
+		nil,
 		"blockchain.transaction.get",
 		txHash.String())
 }
@@ -300,7 +323,7 @@ func (client *ElectrumClient) HeadersSubscribe(
 	success func(*blockchain.Header) error,
 ) {
 	client.rpc.SubscribeNotifications("blockchain.headers.subscribe", func(responseBytes []byte) {
-		response := []*blockchain.Header{}
+		response := []*header{}
 		if err := json.Unmarshal(responseBytes, &response); err != nil {
 			client.log.WithError(err).Error("could not handle header notification")
 			return
@@ -309,20 +332,25 @@ func (client *ElectrumClient) HeadersSubscribe(
 			client.log.Error("could not handle header notification")
 			return
 		}
-		if err := success(response[0]); err != nil {
+		blockchainHeader := response[0].toBlockchainHeader()
+		client.onTip(response[0])
+		if err := success(blockchainHeader); err != nil {
 			client.log.WithError(err).Error("could not handle header notification")
 			return
 		}
 	})
 	client.rpc.Method(
 		func(responseBytes []byte) error {
-			response := &blockchain.Header{}
+			response := &header{}
 			if err := json.Unmarshal(responseBytes, response); err != nil {
 				return errp.WithStack(err)
 			}
-			return success(response)
+			blockchainHeader := response.toBlockchainHeader()
+			client.onTip(response)
+			return success(blockchainHeader)
 		},
 		setupAndTeardown,
+		nil,
 		"blockchain.headers.subscribe")
 }
 
@@ -406,7 +434,9 @@ func (client *ElectrumClient) RelayFee(
 			return errp.Wrap(err, "Failed to construct BTC amount")
 		}
 		return success(amount)
-	}, func() func() { return cleanup }, "blockchain.relayfee")
+	}, func() func() { return cleanup }, func(err error) {
+		client.log.WithError(err).Info("Could not get the relay fee")
+	}, "blockchain.relayfee")
 }
 
 // EstimateFee estimates the fee rate (unit/kB) needed to be confirmed within the given number of
@@ -431,11 +461,15 @@ func (client *ElectrumClient) EstimateFee(
 			if err != nil {
 				return errp.Wrap(err, "Failed to construct BTC amount")
 			}
+			client.onFeeEstimate(number, amount)
 			return success(&amount)
 		},
 		func() func() {
 			return cleanup
 		},
+		func(err error) {
+			client.log.WithError(err).Info("Could not estimate the fee")
+		},
 		"blockchain.estimatefee",
 		number)
 }
@@ -457,18 +491,37 @@ func parseHeaders(reader io.Reader) ([]*wire.BlockHeader, error) {
 }
 
 // Headers does the blockchain.block.headers() RPC call. See
-// https://github.com/kyuupichan/electrumx/blob/1.3/docs/protocol-methods.rst#blockchainblockheaders
+// https://github.com/kyuupichan/electrumx/blob/1.4/docs/protocol-methods.rst#blockchainblockheaders
+// If a checkpoint is set and the server supports it, headers up to the checkpoint are verified
+// against it. A batch crossing the checkpoint ends at the checkpoint, and max is reduced to the
+// number of requested headers, so that the caller continues with the next batch.
 func (client *ElectrumClient) Headers(
 	startHeight int, count int,
 	success func(headers []*wire.BlockHeader, max int) error,
 	cleanup func(),
 ) {
+	checkpoint := client.checkpoint
+	if checkpoint != nil &&
+		(startHeight > checkpoint.Height || !client.negotiatedCapabilities().Checkpoints) {
+		checkpoint = nil
+	}
+	params := []interface{}{startHeight, count}
+	truncated := false
+	if checkpoint != nil {
+		if startHeight+count-1 > checkpoint.Height {
+			count = checkpoint.Height - startHeight + 1
+			truncated = true
+		}
+		params = []interface{}{startHeight, count, checkpoint.Height}
+	}
 	client.rpc.Method(
 		func(responseBytes []byte) error {
 			var response struct {
-				Hex   string `json:"hex"`
-				Count int    `json:"count"`
-				Max   int    `json:"max"`
+				Hex    string   `json:"hex"`
+				Count  int      `json:"count"`
+				Max    int      `json:"max"`
+				Root   string   `json:"root"`
+				Branch []string `json:"branch"`
 			}
 			if err := json.Unmarshal(responseBytes, &response); err != nil {
 				return errp.WithStack(err)
@@ -483,13 +536,42 @@ func (client *ElectrumClient) Headers(
 					response.Count,
 					len(headers))
 			}
+			if checkpoint != nil && len(headers) != 0 {
+				if err := verifyCheckpoint(checkpoint, startHeight+len(headers)-1,
+					headers[len(headers)-1].BlockHash(), response.Branch, response.Root); err != nil {
+					return err
+				}
+			}
+			if truncated {
+				return success(headers, count)
+			}
 			return success(headers, response.Max)
 		},
 		func() func() {
 			return cleanup
 		},
+		nil,
 		"blockchain.block.headers",
-		startHeight, count)
+		params...)
+}
+
+// verifyCheckpoint verifies the hex encoded merkle branch and root of a blockchain.block.headers
+// response against the checkpoint.
+func verifyCheckpoint(
+	checkpoint *Checkpoint, height int, blockHash chainhash.Hash, branchHex []string, rootHex string) error {
+	root, err := chainhash.NewHashFromStr(rootHex)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	branch := make([]chainhash.Hash, len(branchHex))
+	for i, hashHex := range branchHex {
+		hash, err := chainhash.NewHashFromStr(hashHex)
+		if err != nil {
+			return errp.WithStack(err)
+		}
+		branch[i] = *hash
+	}
+	return checkpoint.verify(height, blockHash, branch, *root)
 }
 
 // GetMerkle does the blockchain.transaction.get_merkle() RPC call. See
@@ -517,6 +599,7 @@ func (client *ElectrumClient) GetMerkle(
 		func() func() {
 			return cleanup
 		},
+		nil,
 		"blockchain.transaction.get_merkle",
 		txHash.String(), height)
 }
@@ -0,0 +1,215 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+const (
+	// maxTipDisagreement is the number of blocks by which the tips reported by two servers may
+	// differ, as a new block does not reach all servers at the same time.
+	maxTipDisagreement = 2
+	// maxFeeRatio is the factor by which the fee estimates of two servers may differ.
+	maxFeeRatio = 2
+	// crossCheckInterval limits how often the same query is sent to a second server.
+	crossCheckInterval = time.Minute
+)
+
+// Servers implements blockchain.ServerPool. It returns the health of each server if the
+// underlying client connects to a pool of servers.
+func (client *ElectrumClient) Servers() []*rpc.ServerHealth {
+	pool, ok := client.rpc.(rpc.Pool)
+	if !ok {
+		return []*rpc.ServerHealth{}
+	}
+	return pool.Servers()
+}
+
+// RegisterOnDisagreement implements blockchain.ServerPool. The callback is called if a second
+// server disagrees with the active server about the tip height, the header at the height of the
+// lower tip or a fee estimate.
+func (client *ElectrumClient) RegisterOnDisagreement(onDisagreement func(*blockchain.Disagreement)) {
+	client.crossCheckLock.Lock()
+	defer client.crossCheckLock.Unlock()
+	client.onDisagreement = append(client.onDisagreement, onDisagreement)
+}
+
+// crossCheck queries a second server if the same query has not been checked recently. It returns
+// the pool and the active server, or false if the query should not be checked.
+func (client *ElectrumClient) crossCheck(key string) (rpc.Pool, string, bool) {
+	pool, ok := client.rpc.(rpc.Pool)
+	if !ok {
+		return nil, "", false
+	}
+	client.crossCheckLock.Lock()
+	defer client.crossCheckLock.Unlock()
+	if len(client.onDisagreement) == 0 {
+		return nil, "", false
+	}
+	if lastCheck, ok := client.lastCrossChecks[key]; ok && time.Since(lastCheck) < crossCheckInterval {
+		return nil, "", false
+	}
+	client.lastCrossChecks[key] = time.Now()
+	return pool, pool.ActiveServer(), true
+}
+
+func (client *ElectrumClient) disagree(disagreement *blockchain.Disagreement) {
+	client.log.WithField("disagreement", disagreement).Warning("Servers disagree")
+	client.crossCheckLock.Lock()
+	callbacks := client.onDisagreement
+	client.crossCheckLock.Unlock()
+	for _, callback := range callbacks {
+		callback(disagreement)
+	}
+}
+
+// onTip records the tip height of the active server and compares its tip with the tip of another
+// server.
+func (client *ElectrumClient) onTip(tip *header) {
+	pool, ok := client.rpc.(rpc.Pool)
+	if !ok {
+		return
+	}
+	height := tip.toBlockchainHeader().BlockHeight
+	pool.SetTipHeight(pool.ActiveServer(), height)
+	pool, server, ok := client.crossCheck("tip")
+	if !ok {
+		return
+	}
+	go func() {
+		otherTip := &header{}
+		otherServer, err := pool.QueryOther(otherTip, "blockchain.headers.subscribe")
+		if err != nil {
+			client.log.WithError(err).Info("Could not cross-check the tip height")
+			return
+		}
+		otherHeight := otherTip.toBlockchainHeader().BlockHeight
+		pool.SetTipHeight(otherServer, otherHeight)
+		difference := height - otherHeight
+		if difference < 0 {
+			difference = -difference
+		}
+		if difference > maxTipDisagreement {
+			client.disagree(&blockchain.Disagreement{
+				Subject:     "tipHeight",
+				Server:      server,
+				Value:       fmt.Sprint(height),
+				OtherServer: otherServer,
+				OtherValue:  fmt.Sprint(otherHeight),
+			})
+		}
+		client.crossCheckTipHeader(pool, server, tip, otherServer, otherTip)
+	}()
+}
+
+// crossCheckTipHeader compares the headers of both servers at the height of the lower tip. Servers
+// which do not report the tip header are not checked.
+func (client *ElectrumClient) crossCheckTipHeader(
+	pool rpc.Pool, server string, tip *header, otherServer string, otherTip *header) {
+	if tip.Hex == "" || otherTip.Hex == "" {
+		return
+	}
+	height := tip.toBlockchainHeader().BlockHeight
+	if otherHeight := otherTip.toBlockchainHeader().BlockHeight; otherHeight < height {
+		height = otherHeight
+	}
+	hash, err := headerHashAt(pool, server, tip, height)
+	if err != nil {
+		client.log.WithError(err).Info("Could not cross-check the tip header")
+		return
+	}
+	otherHash, err := headerHashAt(pool, otherServer, otherTip, height)
+	if err != nil {
+		client.log.WithError(err).Info("Could not cross-check the tip header")
+		return
+	}
+	if *hash != *otherHash {
+		client.disagree(&blockchain.Disagreement{
+			Subject:     fmt.Sprintf("header(%d)", height),
+			Server:      server,
+			Value:       hash.String(),
+			OtherServer: otherServer,
+			OtherValue:  otherHash.String(),
+		})
+	}
+}
+
+// headerHashAt returns the hash of the header at the given height reported by the server, whose
+// tip is given.
+func headerHashAt(pool rpc.Pool, server string, tip *header, height int) (*chainhash.Hash, error) {
+	headerHex := tip.Hex
+	if tip.toBlockchainHeader().BlockHeight != height {
+		if err := pool.QueryServer(server, &headerHex, "blockchain.block.header", height); err != nil {
+			return nil, err
+		}
+	}
+	headerBytes, err := hex.DecodeString(headerHex)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	blockHeader := &wire.BlockHeader{}
+	if err := blockHeader.Deserialize(bytes.NewReader(headerBytes)); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	hash := blockHeader.BlockHash()
+	return &hash, nil
+}
+
+// onFeeEstimate compares the fee estimate of the active server with the one of another server.
+func (client *ElectrumClient) onFeeEstimate(number int, fee btcutil.Amount) {
+	pool, server, ok := client.crossCheck(fmt.Sprintf("estimatefee-%d", number))
+	if !ok {
+		return
+	}
+	go func() {
+		var otherFee float64
+		otherServer, err := pool.QueryOther(&otherFee, "blockchain.estimatefee", number)
+		if err != nil {
+			client.log.WithError(err).Info("Could not cross-check the fee estimate")
+			return
+		}
+		if otherFee == -1 {
+			return
+		}
+		other, err := btcutil.NewAmount(otherFee)
+		if err != nil || other <= 0 || fee <= 0 {
+			return
+		}
+		low, high := fee, other
+		if low > high {
+			low, high = high, low
+		}
+		if high > low*maxFeeRatio {
+			client.disagree(&blockchain.Disagreement{
+				Subject:     fmt.Sprintf("feeEstimate(%d)", number),
+				Server:      server,
+				Value:       fee.String(),
+				OtherServer: otherServer,
+				OtherValue:  other.String(),
+			})
+		}
+	}()
+}
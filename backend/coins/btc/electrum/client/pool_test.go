@@ -0,0 +1,209 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc/test"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer returns an Electrum server reporting a fixed tip height and fee estimate.
+func newTestServer(t *testing.T, name string, tipHeight int, fee float64) *test.Server {
+	server := test.NewServer(t, name)
+	server.SetResult("blockchain.headers.subscribe", &blockchain.Header{BlockHeight: tipHeight})
+	server.SetResult("blockchain.estimatefee", fee)
+	return server
+}
+
+func newTestClient(t *testing.T, servers ...*test.Server) (*client.ElectrumClient, chan *blockchain.Disagreement) {
+	backends := make([]rpc.Backend, len(servers))
+	for index, server := range servers {
+		backends[index] = server
+	}
+	log := logging.Get().WithGroup("client_test")
+	electrumClient := client.NewElectrumClient(jsonrpc.NewRPCClient(backends, log), log)
+	disagreements := make(chan *blockchain.Disagreement, 10)
+	electrumClient.RegisterOnDisagreement(func(disagreement *blockchain.Disagreement) {
+		disagreements <- disagreement
+	})
+	return electrumClient, disagreements
+}
+
+func TestCrossCheckTip(t *testing.T) {
+	electrumClient, disagreements := newTestClient(t,
+		newTestServer(t, "a", 100, 0.0001),
+		newTestServer(t, "b", 90, 0.0001))
+
+	// Buffered, as the subscription is renewed when failing over to the other server.
+	tip := make(chan int, 2)
+	electrumClient.HeadersSubscribe(nil, func(header *blockchain.Header) error {
+		tip <- header.BlockHeight
+		return nil
+	})
+	firstTip := <-tip
+	select {
+	case disagreement := <-disagreements:
+		require.Equal(t, "tipHeight", disagreement.Subject)
+		values := []string{disagreement.Value, disagreement.OtherValue}
+		sort.Strings(values)
+		require.Equal(t, []string{"100", "90"}, values)
+		require.NotEqual(t, disagreement.Server, disagreement.OtherServer)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no disagreement")
+	}
+
+	// Both tips are recorded before the disagreement is reported, so the server behind is
+	// unhealthy. If it was the active server, the connection was closed and the subscription is
+	// renewed with the other server.
+	if firstTip == 90 {
+		select {
+		case height := <-tip:
+			require.Equal(t, 100, height)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "no failover")
+		}
+	}
+	servers := electrumClient.Servers()
+	require.Len(t, servers, 2)
+	for _, server := range servers {
+		require.Equal(t, server.TipHeight == 100, server.Healthy)
+		require.Equal(t, server.TipHeight == 100, server.Active)
+	}
+}
+
+func TestCrossCheckFee(t *testing.T) {
+	electrumClient, disagreements := newTestClient(t,
+		newTestServer(t, "a", 100, 0.0001),
+		newTestServer(t, "b", 100, 0.0003))
+
+	fee := make(chan *btcutil.Amount)
+	electrumClient.EstimateFee(2, func(amount *btcutil.Amount) error {
+		fee <- amount
+		return nil
+	}, func() {})
+	<-fee
+	select {
+	case disagreement := <-disagreements:
+		require.Equal(t, "feeEstimate(2)", disagreement.Subject)
+		values := []string{disagreement.Value, disagreement.OtherValue}
+		sort.Strings(values)
+		require.Equal(t, []string{"0.0001 BTC", "0.0003 BTC"}, values)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no disagreement")
+	}
+}
+
+func TestCrossCheckAgreement(t *testing.T) {
+	electrumClient, disagreements := newTestClient(t,
+		newTestServer(t, "a", 100, 0.0001),
+		newTestServer(t, "b", 99, 0.00015))
+
+	done := make(chan struct{})
+	electrumClient.HeadersSubscribe(nil, func(*blockchain.Header) error {
+		done <- struct{}{}
+		return nil
+	})
+	<-done
+	electrumClient.EstimateFee(2, func(*btcutil.Amount) error {
+		done <- struct{}{}
+		return nil
+	}, func() {})
+	<-done
+	select {
+	case disagreement := <-disagreements:
+		require.Fail(t, "unexpected disagreement", disagreement)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// testHeader returns a serialized header, which differs by nonce, and its hash.
+func testHeader(t *testing.T, nonce uint32) (string, string) {
+	header := &wire.BlockHeader{Nonce: nonce}
+	var buf bytes.Buffer
+	require.NoError(t, header.Serialize(&buf))
+	return hex.EncodeToString(buf.Bytes()), header.BlockHash().String()
+}
+
+// newTestHeaderServer returns an Electrum server reporting a tip with its header. Headers below the
+// tip are answered with the given header.
+func newTestHeaderServer(
+	t *testing.T, name string, tipHeight int, tipHeader string, header string) *test.Server {
+	server := test.NewServer(t, name)
+	server.SetResult("blockchain.headers.subscribe",
+		map[string]interface{}{"height": tipHeight, "hex": tipHeader})
+	server.SetResult("blockchain.block.header", header)
+	return server
+}
+
+func TestCrossCheckTipHeader(t *testing.T) {
+	headerA, hashA := testHeader(t, 1)
+	headerB, hashB := testHeader(t, 2)
+	electrumClient, disagreements := newTestClient(t,
+		newTestHeaderServer(t, "a", 100, headerA, headerA),
+		newTestHeaderServer(t, "b", 100, headerB, headerB))
+
+	done := make(chan struct{}, 2)
+	electrumClient.HeadersSubscribe(nil, func(*blockchain.Header) error {
+		done <- struct{}{}
+		return nil
+	})
+	<-done
+	select {
+	case disagreement := <-disagreements:
+		require.Equal(t, fmt.Sprintf("header(%d)", 100), disagreement.Subject)
+		values := []string{disagreement.Value, disagreement.OtherValue}
+		sort.Strings(values)
+		expected := []string{hashA, hashB}
+		sort.Strings(expected)
+		require.Equal(t, expected, values)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no disagreement")
+	}
+}
+
+func TestCrossCheckTipHeaderAgreement(t *testing.T) {
+	tipHeader, _ := testHeader(t, 1)
+	header, _ := testHeader(t, 2)
+	// a is one block ahead, and its header below the tip is the tip header of b.
+	electrumClient, disagreements := newTestClient(t,
+		newTestHeaderServer(t, "a", 100, tipHeader, header),
+		newTestHeaderServer(t, "b", 99, header, header))
+
+	done := make(chan struct{}, 2)
+	electrumClient.HeadersSubscribe(nil, func(*blockchain.Header) error {
+		done <- struct{}{}
+		return nil
+	})
+	<-done
+	select {
+	case disagreement := <-disagreements:
+		require.Fail(t, "unexpected disagreement", disagreement)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	clientVersion = "0.0.1"
+	// clientProtocolMin and clientProtocolMax are the range of protocol versions negotiated with
+	// server.version. The server picks the highest version in the range it supports.
+	clientProtocolMin = "1.2"
+	clientProtocolMax = "1.4"
+	// checkpointsProtocol is the protocol version in which blockchain.block.headers accepts a
+	// checkpoint (cp_height).
+	checkpointsProtocol = "1.3"
+)
+
+// compareVersions compares two dotted version numbers like "1.4" and returns -1, 0 or 1.
+func compareVersions(version, other string) int {
+	parts := strings.Split(version, ".")
+	otherParts := strings.Split(other, ".")
+	for i := 0; i < len(parts) || i < len(otherParts); i++ {
+		var part, otherPart int
+		if i < len(parts) {
+			part, _ = strconv.Atoi(parts[i])
+		}
+		if i < len(otherParts) {
+			otherPart, _ = strconv.Atoi(otherParts[i])
+		}
+		switch {
+		case part < otherPart:
+			return -1
+		case part > otherPart:
+			return 1
+		}
+	}
+	return 0
+}
+
+// Capabilities describes what the connected server supports.
+type Capabilities struct {
+	// ProtocolVersion is the negotiated protocol version.
+	ProtocolVersion string `json:"protocolVersion"`
+	// Features are the features reported by the server, or nil if the server did not report them.
+	Features *ServerFeatures `json:"features"`
+	// Checkpoints is true if headers can be verified against a checkpoint.
+	Checkpoints bool `json:"checkpoints"`
+}
+
+// Capabilities returns the capabilities of the connected server, which are determined when the
+// connection is established. Before that, the capabilities of the minimum protocol version are
+// returned.
+func (client *ElectrumClient) Capabilities() Capabilities {
+	defer client.capabilitiesLock.RLock()()
+	return client.capabilities
+}
+
+// negotiatedCapabilities returns the capabilities of the connected server, connecting first if the
+// protocol version has not been negotiated yet.
+func (client *ElectrumClient) negotiatedCapabilities() Capabilities {
+	unlock := client.capabilitiesLock.RLock()
+	negotiated := client.negotiated
+	unlock()
+	if !negotiated {
+		if err := client.rpc.MethodSync(nil, "server.ping"); err != nil {
+			client.log.WithError(err).Info("Could not negotiate the protocol version")
+		}
+	}
+	return client.Capabilities()
+}
+
+// negotiate negotiates the protocol version with the server and fetches its features. Must be the
+// first request on a new connection.
+func (client *ElectrumClient) negotiate() error {
+	version, err := client.ServerVersion()
+	if err != nil {
+		return err
+	}
+	if compareVersions(version.ProtocolVersion, clientProtocolMin) < 0 ||
+		compareVersions(version.ProtocolVersion, clientProtocolMax) > 0 {
+		return errp.Newf("unsupported protocol version %s", version.ProtocolVersion)
+	}
+	client.log.WithField("server-version", version).Debug("electrumx server version")
+	capabilities := Capabilities{
+		ProtocolVersion: version.ProtocolVersion,
+		Checkpoints:     compareVersions(version.ProtocolVersion, checkpointsProtocol) >= 0,
+	}
+	features, err := client.ServerFeatures()
+	if err != nil {
+		client.log.WithError(err).Info("Could not get the server features")
+	} else {
+		capabilities.Features = features
+	}
+	defer client.capabilitiesLock.Lock()()
+	client.capabilities = capabilities
+	client.negotiated = true
+	return nil
+}
+
+// header is the result of blockchain.headers.subscribe. The height is named block_height in
+// protocol 1.2 and height since protocol 1.3.
+type header struct {
+	BlockHeight int `json:"block_height"`
+	Height      int `json:"height"`
+	// Hex is the serialized header, which is reported from protocol 1.2 on.
+	Hex string `json:"hex"`
+}
+
+func (header *header) toBlockchainHeader() *blockchain.Header {
+	if header.Height != 0 {
+		return &blockchain.Header{BlockHeight: header.Height}
+	}
+	return &blockchain.Header{BlockHeight: header.BlockHeight}
+}
+
+// Checkpoint is the merkle root of the block hashes of all headers up to a height. Headers up to
+// this height retrieved with blockchain.block.headers are verified against it.
+type Checkpoint struct {
+	Height int
+	Root   chainhash.Hash
+}
+
+// verify checks that the merkle branch connects the block hash at the given height to the root of
+// the checkpoint.
+func (checkpoint *Checkpoint) verify(
+	height int, blockHash chainhash.Hash, branch []chainhash.Hash, root chainhash.Hash) error {
+	if root != checkpoint.Root {
+		return errp.Newf("checkpoint root should be %s, but got %s", checkpoint.Root, root)
+	}
+	if height > checkpoint.Height {
+		return errp.Newf("height %d is above the checkpoint %d", height, checkpoint.Height)
+	}
+	if len(branch) != bits.Len(uint(checkpoint.Height)) {
+		return errp.Newf("merkle branch should have %d hashes, but got %d",
+			bits.Len(uint(checkpoint.Height)), len(branch))
+	}
+	hash := blockHash
+	index := height
+	for _, sibling := range branch {
+		if index&1 == 1 {
+			hash = chainhash.DoubleHashH(append(sibling[:], hash[:]...))
+		} else {
+			hash = chainhash.DoubleHashH(append(hash[:], sibling[:]...))
+		}
+		index >>= 1
+	}
+	if hash != checkpoint.Root {
+		return errp.Newf("header at height %d does not match the checkpoint %d", height, checkpoint.Height)
+	}
+	return nil
+}
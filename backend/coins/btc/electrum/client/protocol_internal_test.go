@@ -0,0 +1,111 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	require.Equal(t, 0, compareVersions("1.4", "1.4"))
+	require.Equal(t, -1, compareVersions("1.2", "1.4"))
+	require.Equal(t, 1, compareVersions("1.4.2", "1.4"))
+	require.Equal(t, 0, compareVersions("1.4.0", "1.4"))
+	require.Equal(t, 1, compareVersions("1.10", "1.4"))
+}
+
+// merkleTree returns the root and the branches of all leaves of the merkle tree, duplicating the
+// last hash of levels with an odd number of hashes.
+func merkleTree(leaves []chainhash.Hash) (chainhash.Hash, [][]chainhash.Hash) {
+	branches := make([][]chainhash.Hash, len(leaves))
+	indices := make([]int, len(leaves))
+	for i := range indices {
+		indices[i] = i
+	}
+	level := leaves
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		for leaf, index := range indices {
+			branches[leaf] = append(branches[leaf], level[index^1])
+			indices[leaf] = index / 2
+		}
+		next := []chainhash.Hash{}
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, chainhash.DoubleHashH(append(level[i][:], level[i+1][:]...)))
+		}
+		level = next
+	}
+	return level[0], branches
+}
+
+func TestCheckpointVerify(t *testing.T) {
+	for _, height := range []int{0, 1, 2, 6, 7, 8, 100} {
+		leaves := make([]chainhash.Hash, height+1)
+		for i := range leaves {
+			leaves[i] = chainhash.HashH([]byte{byte(i)})
+		}
+		root, branches := merkleTree(leaves)
+		checkpoint := &Checkpoint{Height: height, Root: root}
+		for index, leaf := range leaves {
+			require.NoError(t, checkpoint.verify(index, leaf, branches[index], root))
+		}
+
+		otherLeaf := chainhash.HashH([]byte("other"))
+		require.Error(t, checkpoint.verify(height, otherLeaf, branches[height], root))
+		require.Error(t, checkpoint.verify(height, leaves[height], branches[height], otherLeaf))
+		require.Error(t, checkpoint.verify(height+1, leaves[height], branches[height], root))
+		require.Error(t, checkpoint.verify(
+			height, leaves[height], append(branches[height], otherLeaf), root))
+		if height > 0 {
+			require.Error(t, checkpoint.verify(height, leaves[height], branches[height][1:], root))
+			require.Error(t, checkpoint.verify(height-1, leaves[height], branches[height], root))
+		}
+	}
+}
+
+func TestNewCheckpoint(t *testing.T) {
+	require.Nil(t, NewCheckpoint(&chaincfg.RegressionNetParams))
+
+	// Blocks 1 to 255 of mainnet, from the test data of btcd (database/testdata).
+	content, err := ioutil.ReadFile("../../headers/testdata/mainnet-1-255.hex")
+	require.NoError(t, err)
+	blockHashes := []chainhash.Hash{*chaincfg.MainNetParams.GenesisHash}
+	for _, line := range strings.Fields(string(content)) {
+		headerBytes, err := hex.DecodeString(line)
+		require.NoError(t, err)
+		header := &wire.BlockHeader{}
+		require.NoError(t, header.Deserialize(bytes.NewReader(headerBytes)))
+		require.Equal(t, blockHashes[len(blockHashes)-1], header.PrevBlock)
+		blockHashes = append(blockHashes, header.BlockHash())
+	}
+	checkpoint := NewCheckpoint(&chaincfg.MainNetParams)
+	require.Len(t, blockHashes, checkpoint.Height+1)
+	root, branches := merkleTree(blockHashes)
+	require.Equal(t, root, checkpoint.Root)
+	for _, height := range []int{0, 100, checkpoint.Height} {
+		require.NoError(t, checkpoint.verify(height, blockHashes[height], branches[height], root))
+	}
+}
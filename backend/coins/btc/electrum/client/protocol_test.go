@@ -0,0 +1,270 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+// headersServer is an Electrum server serving a chain of headers, which proves headers against a
+// checkpoint like ElectrumX.
+type headersServer struct {
+	protocolVersion string
+	headers         []*wire.BlockHeader
+	listener        net.Listener
+	// versionRequests and headersRequests receive the params of the requests.
+	versionRequests chan []json.RawMessage
+	headersRequests chan []json.RawMessage
+}
+
+func newHeadersServer(t *testing.T, protocolVersion string, count int) *headersServer {
+	headers := make([]*wire.BlockHeader, count)
+	prevBlock := chainhash.Hash{}
+	for i := range headers {
+		headers[i] = &wire.BlockHeader{
+			Version:   1,
+			PrevBlock: prevBlock,
+			Timestamp: time.Unix(1231006505+int64(i)*600, 0),
+			Nonce:     uint32(i),
+		}
+		prevBlock = headers[i].BlockHash()
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &headersServer{
+		protocolVersion: protocolVersion,
+		headers:         headers,
+		listener:        listener,
+		versionRequests: make(chan []json.RawMessage, 100),
+		headersRequests: make(chan []json.RawMessage, 100),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// checkpoint returns the root of the block hashes up to the height and the branch of the block
+// hash at the index.
+func (server *headersServer) checkpoint(height int, index int) (chainhash.Hash, []chainhash.Hash) {
+	level := make([]chainhash.Hash, height+1)
+	for i := range level {
+		level[i] = server.headers[i].BlockHash()
+	}
+	branch := []chainhash.Hash{}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		branch = append(branch, level[index^1])
+		index /= 2
+		next := []chainhash.Hash{}
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, chainhash.DoubleHashH(append(level[i][:], level[i+1][:]...)))
+		}
+		level = next
+	}
+	return level[0], branch
+}
+
+func (server *headersServer) headersResult(params []json.RawMessage) interface{} {
+	var startHeight, count, cpHeight int
+	_ = json.Unmarshal(params[0], &startHeight)
+	_ = json.Unmarshal(params[1], &count)
+	if len(params) > 2 {
+		_ = json.Unmarshal(params[2], &cpHeight)
+	}
+	if startHeight+count > len(server.headers) {
+		count = len(server.headers) - startHeight
+	}
+	buf := &bytes.Buffer{}
+	for _, header := range server.headers[startHeight : startHeight+count] {
+		_ = header.Serialize(buf)
+	}
+	result := map[string]interface{}{
+		"hex":   hex.EncodeToString(buf.Bytes()),
+		"count": count,
+		"max":   2016,
+	}
+	if cpHeight != 0 && count != 0 {
+		root, branch := server.checkpoint(cpHeight, startHeight+count-1)
+		branchHex := make([]string, len(branch))
+		for i, hash := range branch {
+			branchHex[i] = hash.String()
+		}
+		result["root"] = root.String()
+		result["branch"] = branchHex
+	}
+	return result
+}
+
+func (server *headersServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		request := struct {
+			ID     int               `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}{}
+		if err := json.Unmarshal(line, &request); err != nil {
+			return
+		}
+		var result interface{}
+		switch request.Method {
+		case "server.version":
+			server.versionRequests <- request.Params
+			result = []string{"ElectrumX 1.10", server.protocolVersion}
+		case "server.features":
+			result = map[string]interface{}{
+				"genesis_hash":   server.headers[0].BlockHash().String(),
+				"hash_function":  "sha256",
+				"server_version": "ElectrumX 1.10",
+				"protocol_min":   "1.2",
+				"protocol_max":   server.protocolVersion,
+				"pruning":        nil,
+			}
+		case "blockchain.block.headers":
+			server.headersRequests <- request.Params
+			result = server.headersResult(request.Params)
+		}
+		response, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      request.ID,
+			"result":  result,
+		})
+		if _, err := conn.Write(append(response, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+func (server *headersServer) EstablishConnection() (io.ReadWriteCloser, error) {
+	return net.Dial("tcp", server.listener.Addr().String())
+}
+
+func (server *headersServer) ServerInfo() *rpc.ServerInfo {
+	return &rpc.ServerInfo{Server: server.listener.Addr().String()}
+}
+
+func newHeadersClient(server *headersServer, checkpoint *client.Checkpoint) *client.ElectrumClient {
+	log := logging.Get().WithGroup("client_test")
+	electrumClient := client.NewElectrumClient(
+		jsonrpc.NewRPCClient([]rpc.Backend{server}, log), log)
+	electrumClient.SetCheckpoint(checkpoint)
+	return electrumClient
+}
+
+type headersBatch struct {
+	headers []*wire.BlockHeader
+	max     int
+}
+
+func getHeaders(electrumClient *client.ElectrumClient, startHeight int, count int) headersBatch {
+	batch := make(chan headersBatch)
+	electrumClient.Headers(startHeight, count, func(headers []*wire.BlockHeader, max int) error {
+		batch <- headersBatch{headers, max}
+		return nil
+	}, func() {})
+	return <-batch
+}
+
+func TestNegotiation(t *testing.T) {
+	server := newHeadersServer(t, "1.4", 1)
+	electrumClient := newHeadersClient(server, nil)
+	require.Equal(t, "1.2", electrumClient.Capabilities().ProtocolVersion)
+
+	getHeaders(electrumClient, 0, 1)
+	require.JSONEq(t, `["1.2", "1.4"]`, string((<-server.versionRequests)[1]))
+	capabilities := electrumClient.Capabilities()
+	require.Equal(t, "1.4", capabilities.ProtocolVersion)
+	require.True(t, capabilities.Checkpoints)
+	require.NotNil(t, capabilities.Features)
+	require.Equal(t, server.headers[0].BlockHash().String(), capabilities.Features.GenesisHash)
+	require.Equal(t, "1.4", capabilities.Features.ProtocolMax)
+	require.Nil(t, capabilities.Features.Pruning)
+
+	// An older server does not support checkpoints.
+	server = newHeadersServer(t, "1.2", 1)
+	electrumClient = newHeadersClient(server, nil)
+	getHeaders(electrumClient, 0, 1)
+	capabilities = electrumClient.Capabilities()
+	require.Equal(t, "1.2", capabilities.ProtocolVersion)
+	require.False(t, capabilities.Checkpoints)
+}
+
+func TestHeadersCheckpoint(t *testing.T) {
+	server := newHeadersServer(t, "1.4", 30)
+	root, _ := server.checkpoint(20, 0)
+	electrumClient := newHeadersClient(server, &client.Checkpoint{Height: 20, Root: root})
+
+	// Headers below the checkpoint are verified against it.
+	batch := getHeaders(electrumClient, 0, 10)
+	require.Equal(t, server.headers[:10], batch.headers)
+	require.Equal(t, 2016, batch.max)
+	require.Len(t, <-server.headersRequests, 3)
+
+	// A batch crossing the checkpoint ends at the checkpoint.
+	batch = getHeaders(electrumClient, 15, 10)
+	require.Equal(t, server.headers[15:21], batch.headers)
+	require.Equal(t, 6, batch.max)
+	require.JSONEq(t, `[15, 6, 20]`, jsonParams(<-server.headersRequests))
+
+	// Headers above the checkpoint are requested without it.
+	batch = getHeaders(electrumClient, 21, 10)
+	require.Equal(t, server.headers[21:30], batch.headers)
+	require.Equal(t, 2016, batch.max)
+	require.JSONEq(t, `[21, 10]`, jsonParams(<-server.headersRequests))
+}
+
+func jsonParams(params []json.RawMessage) string {
+	result, _ := json.Marshal(params)
+	return string(result)
+}
+
+func TestHeadersWithoutCheckpointSupport(t *testing.T) {
+	server := newHeadersServer(t, "1.2", 30)
+	root, _ := server.checkpoint(20, 0)
+	electrumClient := newHeadersClient(server, &client.Checkpoint{Height: 20, Root: root})
+
+	batch := getHeaders(electrumClient, 15, 10)
+	require.Equal(t, server.headers[15:25], batch.headers)
+	require.JSONEq(t, `[15, 10]`, jsonParams(<-server.headersRequests))
+}
@@ -16,16 +16,14 @@ package electrum
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"io"
-	"net"
-	"time"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 	"github.com/sirupsen/logrus"
 )
 
@@ -36,11 +34,18 @@ type ConnectionError error
 type Electrum struct {
 	log        *logrus.Entry
 	serverInfo *rpc.ServerInfo
+	socksProxy *socksproxy.SocksProxy
+	pinner     CertificatePinner
 }
 
-// NewElectrum creates a new Electrum instance.
-func NewElectrum(log *logrus.Entry, serverInfo *rpc.ServerInfo) *Electrum {
-	return &Electrum{log, serverInfo}
+// NewElectrum creates a new Electrum instance, which connects through the given proxy. The pinner
+// stores the certificate fingerprint of servers verified with rpc.VerificationTOFU and can be nil.
+func NewElectrum(
+	log *logrus.Entry,
+	serverInfo *rpc.ServerInfo,
+	socksProxy *socksproxy.SocksProxy,
+	pinner CertificatePinner) *Electrum {
+	return &Electrum{log, serverInfo, socksProxy, pinner}
 }
 
 // ServerInfo returns the server info for this backend.
@@ -54,13 +59,13 @@ func (electrum *Electrum) EstablishConnection() (io.ReadWriteCloser, error) {
 	var conn io.ReadWriteCloser
 	if electrum.serverInfo.TLS {
 		var err error
-		conn, err = newTLSConnection(electrum.serverInfo.Server, electrum.serverInfo.PEMCert)
+		conn, err = newTLSConnection(electrum.socksProxy, electrum.serverInfo, electrum.pinner)
 		if err != nil {
 			return nil, ConnectionError(err)
 		}
 	} else {
 		var err error
-		conn, err = newTCPConnection(electrum.serverInfo.Server)
+		conn, err = newTCPConnection(electrum.socksProxy, electrum.serverInfo.Server)
 		if err != nil {
 			return nil, ConnectionError(err)
 		}
@@ -68,64 +73,38 @@ func (electrum *Electrum) EstablishConnection() (io.ReadWriteCloser, error) {
 	return conn, nil
 }
 
-func newTLSConnection(address string, rootCert string) (*tls.Conn, error) {
-	caCertPool := x509.NewCertPool()
-	if ok := caCertPool.AppendCertsFromPEM([]byte(rootCert)); !ok {
-		return nil, errp.New("Failed to append CA cert as trusted cert")
+func newTLSConnection(
+	socksProxy *socksproxy.SocksProxy,
+	serverInfo *rpc.ServerInfo,
+	pinner CertificatePinner) (*tls.Conn, error) {
+	config, err := tlsConfig(serverInfo, pinner)
+	if err != nil {
+		return nil, err
 	}
-	conn, err := tls.Dial("tcp", address, &tls.Config{
-		RootCAs:            caCertPool,
-		InsecureSkipVerify: true, // Not actually skipping, we check the cert in VerifyPeerCertificate
-		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			// Code copy/pasted and adapted from
-			// https://github.com/golang/go/blob/81555cb4f3521b53f9de4ce15f64b77cc9df61b9/src/crypto/tls/handshake_client.go#L327-L344, but adapted to skip the hostname verification.
-			// See https://github.com/golang/go/issues/21971#issuecomment-412836078.
-
-			// If this is the first handshake on a connection, process and
-			// (optionally) verify the server's certificates.
-			certs := make([]*x509.Certificate, len(rawCerts))
-			for i, asn1Data := range rawCerts {
-				cert, err := x509.ParseCertificate(asn1Data)
-				if err != nil {
-					return errp.New("bitbox/electrum: failed to parse certificate from server: " + err.Error())
-				}
-				certs[i] = cert
-			}
-
-			opts := x509.VerifyOptions{
-				Roots:         caCertPool,
-				CurrentTime:   time.Now(),
-				DNSName:       "", // <- skip hostname verification
-				Intermediates: x509.NewCertPool(),
-			}
-
-			for i, cert := range certs {
-				if i == 0 {
-					continue
-				}
-				opts.Intermediates.AddCert(cert)
-			}
-			_, err := certs[0].Verify(opts)
-			return err
-		},
-	})
+	tcpConn, err := socksProxy.Dial("tcp", serverInfo.Server)
 	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(tcpConn, config)
+	if err := conn.Handshake(); err != nil {
+		_ = tcpConn.Close()
 		return nil, errp.WithStack(err)
 	}
 	return conn, nil
 }
 
-func newTCPConnection(address string) (net.Conn, error) {
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		return nil, errp.WithStack(err)
-	}
-	return conn, nil
+func newTCPConnection(socksProxy *socksproxy.SocksProxy, address string) (io.ReadWriteCloser, error) {
+	return socksProxy.Dial("tcp", address)
 }
 
-// NewElectrumConnection connects to an Electrum server and returns a ElectrumClient instance to
-// communicate with it.
-func NewElectrumConnection(servers []*rpc.ServerInfo, log *logrus.Entry) blockchain.Interface {
+// NewElectrumConnection connects to an Electrum server through the given proxy and returns a
+// ElectrumClient instance to communicate with it. The checkpoint and the pinner can be nil.
+func NewElectrumConnection(
+	servers []*rpc.ServerInfo,
+	checkpoint *client.Checkpoint,
+	socksProxy *socksproxy.SocksProxy,
+	pinner CertificatePinner,
+	log *logrus.Entry) blockchain.Interface {
 	var serverList string
 	for _, serverInfo := range servers {
 		if serverList != "" {
@@ -138,8 +117,10 @@ func NewElectrumConnection(servers []*rpc.ServerInfo, log *logrus.Entry) blockch
 
 	backends := []rpc.Backend{}
 	for _, serverInfo := range servers {
-		backends = append(backends, &Electrum{log, serverInfo})
+		backends = append(backends, &Electrum{log, serverInfo, socksProxy, pinner})
 	}
 	jsonrpcClient := jsonrpc.NewRPCClient(backends, log)
-	return client.NewElectrumClient(jsonrpcClient, log)
+	electrumClient := client.NewElectrumClient(jsonrpcClient, log)
+	electrumClient.SetCheckpoint(checkpoint)
+	return electrumClient
 }
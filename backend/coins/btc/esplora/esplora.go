@@ -0,0 +1,648 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package esplora implements blockchain.Interface using the REST API of an Esplora server, e.g.
+// blockstream.info or mempool.space, for deployments which can only reach HTTPS. See
+// https://github.com/Blockstream/esplora/blob/master/API.md.
+//
+// Esplora has no push notifications, so subscriptions are emulated by polling, with the statuses
+// computed like Electrum does (see blockchain.TxHistory.Status()).
+package esplora
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+)
+
+// pollInterval is the interval in which the subscribed script hashes and the chain tip are polled.
+var pollInterval = 30 * time.Second
+
+const (
+	// confirmedTxsPerPage is the number of confirmed transactions returned per history request.
+	confirmedTxsPerPage = 25
+	// blocksPerPage is the number of blocks returned by /blocks/:height.
+	blocksPerPage = 10
+	// headersPerBatch is the maximum number of headers returned by Headers.
+	headersPerBatch = 10 * blocksPerPage
+	// minRelayFee is the default minimum relay fee of Bitcoin Core in satoshi per kB. Esplora does
+	// not expose the relay fee of its node.
+	minRelayFee = btcutil.Amount(1000)
+)
+
+// Config configures the connection to an Esplora server.
+type Config struct {
+	// URL is the base URL of the API, e.g. https://blockstream.info/api.
+	URL string `json:"url"`
+}
+
+// httpError is an error status returned by the server, as opposed to a connection error.
+type httpError struct {
+	status  int
+	message string
+}
+
+func (err *httpError) Error() string {
+	return fmt.Sprintf("esplora error %d: %s", err.status, err.message)
+}
+
+// connectionError is returned if the server could not be reached or returned an unexpected reply.
+type connectionError struct {
+	error
+}
+
+type subscription struct {
+	callback func(string) error
+	// status is the last status passed to the callback.
+	status string
+}
+
+// Client is a blockchain.Interface backed by an Esplora server.
+type Client struct {
+	url        string
+	httpClient *http.Client
+
+	lock                     locker.Locker
+	subscriptions            map[blockchain.ScriptHashHex]*subscription
+	headersSubscriptions     []func(*blockchain.Header) error
+	tip                      int
+	status                   blockchain.Status
+	onConnectionStatusChange []func(blockchain.Status)
+
+	closeChan chan struct{}
+	closed    bool
+
+	log *logrus.Entry
+}
+
+// NewClient creates a new client and starts polling the server.
+func NewClient(config *Config, socksProxy *socksproxy.SocksProxy, log *logrus.Entry) *Client {
+	client := &Client{
+		url:           strings.TrimSuffix(config.URL, "/"),
+		httpClient:    socksProxy.HTTPClient(time.Minute),
+		subscriptions: map[blockchain.ScriptHashHex]*subscription{},
+		tip:           -1,
+		status:        blockchain.CONNECTED,
+		closeChan:     make(chan struct{}),
+		log:           log.WithFields(logrus.Fields{"group": "esplora", "url": config.URL}),
+	}
+	go client.poll()
+	return client
+}
+
+func (client *Client) do(method string, path string, body []byte) ([]byte, error) {
+	request, err := http.NewRequest(method, client.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return nil, &connectionError{errp.WithStack(err)}
+	}
+	defer func() { _ = response.Body.Close() }()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, &connectionError{errp.WithStack(err)}
+	}
+	if response.StatusCode != http.StatusOK {
+		err := &httpError{status: response.StatusCode, message: strings.TrimSpace(string(responseBody))}
+		// Server errors and rate limiting are temporary.
+		if response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests {
+			return nil, &connectionError{err}
+		}
+		return nil, err
+	}
+	return responseBody, nil
+}
+
+func (client *Client) get(path string) ([]byte, error) {
+	return client.do(http.MethodGet, path, nil)
+}
+
+func (client *Client) getJSON(path string, result interface{}) error {
+	response, err := client.get(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(response, result); err != nil {
+		return &connectionError{errp.Wrap(err, "Failed to unmarshal JSON")}
+	}
+	return nil
+}
+
+func (client *Client) getText(path string) (string, error) {
+	response, err := client.get(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(response)), nil
+}
+
+func (client *Client) setStatus(status blockchain.Status) {
+	callbacks := func() []func(blockchain.Status) {
+		defer client.lock.Lock()()
+		if client.status == status {
+			return nil
+		}
+		client.status = status
+		return client.onConnectionStatusChange
+	}()
+	for _, callback := range callbacks {
+		callback(status)
+	}
+}
+
+// method runs the request in the background and then calls cleanup. Like requests to Electrum
+// servers, requests which fail because the server is unreachable are retried until they succeed or
+// the client is closed. Errors returned by the server are logged.
+func (client *Client) method(request func() error, cleanup func()) {
+	go func() {
+		defer cleanup()
+		for {
+			err := request()
+			if err == nil {
+				return
+			}
+			if _, ok := errp.Cause(err).(*connectionError); !ok {
+				client.log.WithError(err).Error("Request failed")
+				return
+			}
+			client.log.WithError(err).Debug("Retrying request")
+			client.setStatus(blockchain.DISCONNECTED)
+			select {
+			case <-client.closeChan:
+				return
+			case <-time.After(pollInterval):
+			}
+		}
+	}()
+}
+
+// esploraScriptHash converts the script hash from the reversed byte order used by Electrum to the
+// byte order used by Esplora.
+func esploraScriptHash(scriptHashHex blockchain.ScriptHashHex) (string, error) {
+	hash, err := chainhash.NewHashFromStr(string(scriptHashHex))
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	return hex.EncodeToString(hash[:]), nil
+}
+
+type transaction struct {
+	TXID   string `json:"txid"`
+	Fee    int64  `json:"fee"`
+	Status struct {
+		Confirmed   bool `json:"confirmed"`
+		BlockHeight int  `json:"block_height"`
+	} `json:"status"`
+}
+
+// history fetches all transactions of the script hash. The server returns the unconfirmed
+// transactions followed by the confirmed ones, newest first, and pages through the confirmed ones.
+func (client *Client) history(scriptHashHex blockchain.ScriptHashHex) (blockchain.TxHistory, error) {
+	scriptHash, err := esploraScriptHash(scriptHashHex)
+	if err != nil {
+		return nil, err
+	}
+	transactions := []*transaction{}
+	if err := client.getJSON(fmt.Sprintf("/scripthash/%s/txs", scriptHash), &transactions); err != nil {
+		return nil, err
+	}
+	confirmed := 0
+	for _, tx := range transactions {
+		if tx.Status.Confirmed {
+			confirmed++
+		}
+	}
+	for confirmed == confirmedTxsPerPage {
+		page := []*transaction{}
+		lastTXID := transactions[len(transactions)-1].TXID
+		if err := client.getJSON(
+			fmt.Sprintf("/scripthash/%s/txs/chain/%s", scriptHash, lastTXID), &page); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, page...)
+		confirmed = len(page)
+	}
+	history := blockchain.TxHistory{}
+	for index := len(transactions) - 1; index >= 0; index-- {
+		tx := transactions[index]
+		txHash, err := chainhash.NewHashFromStr(tx.TXID)
+		if err != nil {
+			return nil, &connectionError{errp.WithStack(err)}
+		}
+		txInfo := &blockchain.TxInfo{TXHash: blockchain.TXHash(*txHash)}
+		if tx.Status.Confirmed {
+			txInfo.Height = tx.Status.BlockHeight
+		} else {
+			// Like Electrum, the fee is only reported for unconfirmed transactions.
+			fee := tx.Fee
+			txInfo.Fee = &fee
+		}
+		history = append(history, txInfo)
+	}
+	// Like Electrum, confirmed transactions are ordered by height, followed by the unconfirmed ones.
+	sort.SliceStable(history, func(i, j int) bool {
+		heightI, heightJ := history[i].Height, history[j].Height
+		if (heightI == 0) != (heightJ == 0) {
+			return heightJ == 0
+		}
+		return heightI < heightJ
+	})
+	return history, nil
+}
+
+func (client *Client) fetchTip() (int, error) {
+	tipHeight, err := client.getText("/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+	tip, err := strconv.Atoi(tipHeight)
+	if err != nil {
+		return 0, &connectionError{errp.WithStack(err)}
+	}
+	return tip, nil
+}
+
+func (client *Client) poll() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.closeChan:
+			return
+		case <-ticker.C:
+		}
+		err := client.update()
+		if err != nil {
+			client.log.WithError(err).Error("Failed to poll the server")
+		}
+		if _, ok := errp.Cause(err).(*connectionError); ok {
+			client.setStatus(blockchain.DISCONNECTED)
+		} else {
+			client.setStatus(blockchain.CONNECTED)
+		}
+	}
+}
+
+// update notifies the subscribers of a new chain tip and of changed script hash statuses.
+func (client *Client) update() error {
+	tip, err := client.fetchTip()
+	if err != nil {
+		return err
+	}
+	headersSubscriptions := func() []func(*blockchain.Header) error {
+		defer client.lock.Lock()()
+		if tip == client.tip {
+			return nil
+		}
+		client.tip = tip
+		return client.headersSubscriptions
+	}()
+	for _, callback := range headersSubscriptions {
+		if err := callback(&blockchain.Header{BlockHeight: tip}); err != nil {
+			client.log.WithError(err).Error("could not handle header notification")
+		}
+	}
+
+	scriptHashes := func() []blockchain.ScriptHashHex {
+		defer client.lock.RLock()()
+		scriptHashes := []blockchain.ScriptHashHex{}
+		for scriptHashHex := range client.subscriptions {
+			scriptHashes = append(scriptHashes, scriptHashHex)
+		}
+		return scriptHashes
+	}()
+	for _, scriptHashHex := range scriptHashes {
+		history, err := client.history(scriptHashHex)
+		if err != nil {
+			return err
+		}
+		status := history.Status()
+		callback := func() func(string) error {
+			defer client.lock.Lock()()
+			sub := client.subscriptions[scriptHashHex]
+			if sub.status == status {
+				return nil
+			}
+			sub.status = status
+			return sub.callback
+		}()
+		if callback != nil {
+			if err := callback(status); err != nil {
+				client.log.WithError(err).Error("Failed to execute callback")
+			}
+		}
+	}
+	return nil
+}
+
+// ScriptHashGetHistory implements blockchain.Interface.
+func (client *Client) ScriptHashGetHistory(
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(blockchain.TxHistory) error,
+	cleanup func(),
+) {
+	client.method(func() error {
+		history, err := client.history(scriptHashHex)
+		if err != nil {
+			return err
+		}
+		return success(history)
+	}, cleanup)
+}
+
+// ScriptHashSubscribe implements blockchain.Interface. The server is polled for changes of the
+// status.
+func (client *Client) ScriptHashSubscribe(
+	setupAndTeardown func() func(),
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(string) error,
+) {
+	func() {
+		defer client.lock.Lock()()
+		client.subscriptions[scriptHashHex] = &subscription{callback: success}
+	}()
+	client.method(func() error {
+		history, err := client.history(scriptHashHex)
+		if err != nil {
+			return err
+		}
+		status := history.Status()
+		func() {
+			defer client.lock.Lock()()
+			client.subscriptions[scriptHashHex].status = status
+		}()
+		return success(status)
+	}, setupAndTeardown())
+}
+
+// HeadersSubscribe implements blockchain.Interface. The server is polled for new blocks.
+func (client *Client) HeadersSubscribe(
+	setupAndTeardown func() func(),
+	success func(*blockchain.Header) error,
+) {
+	func() {
+		defer client.lock.Lock()()
+		client.headersSubscriptions = append(client.headersSubscriptions, success)
+	}()
+	// headers.Headers subscribes without a setup function.
+	cleanup := func() {}
+	if setupAndTeardown != nil {
+		cleanup = setupAndTeardown()
+	}
+	client.method(func() error {
+		tip, err := client.fetchTip()
+		if err != nil {
+			return err
+		}
+		func() {
+			defer client.lock.Lock()()
+			client.tip = tip
+		}()
+		return success(&blockchain.Header{BlockHeight: tip})
+	}, cleanup)
+}
+
+func parseTX(rawTXHex string) (*wire.MsgTx, error) {
+	rawTX, err := hex.DecodeString(rawTXHex)
+	if err != nil {
+		return nil, errp.Wrap(err, "Failed to decode transaction hex")
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.BtcDecode(bytes.NewReader(rawTX), 0, wire.WitnessEncoding); err != nil {
+		return nil, errp.Wrap(err, "Failed to decode BTC transaction")
+	}
+	return tx, nil
+}
+
+// TransactionGet implements blockchain.Interface.
+func (client *Client) TransactionGet(
+	txHash chainhash.Hash,
+	success func(*wire.MsgTx) error,
+	cleanup func(),
+) {
+	client.method(func() error {
+		rawTXHex, err := client.getText(fmt.Sprintf("/tx/%s/hex", txHash))
+		if err != nil {
+			return err
+		}
+		tx, err := parseTX(rawTXHex)
+		if err != nil {
+			return err
+		}
+		if tx.TxHash() != txHash {
+			return errp.Newf("expected transaction %s, got %s", txHash, tx.TxHash())
+		}
+		return success(tx)
+	}, cleanup)
+}
+
+// TransactionBroadcast implements blockchain.Interface.
+func (client *Client) TransactionBroadcast(transaction *wire.MsgTx) error {
+	rawTx := &bytes.Buffer{}
+	_ = transaction.BtcEncode(rawTx, 0, wire.WitnessEncoding)
+	response, err := client.do(http.MethodPost, "/tx", []byte(hex.EncodeToString(rawTx.Bytes())))
+	if err != nil {
+		return errp.Wrap(err, "Failed to broadcast transaction")
+	}
+	if strings.TrimSpace(string(response)) != transaction.TxHash().String() {
+		return errp.WithContext(errp.New("Response is unexpected (expected TX hash)"),
+			errp.Context{"response": string(response)})
+	}
+	return nil
+}
+
+// RelayFee implements blockchain.Interface. Esplora does not expose the relay fee of its node, so
+// the default minimum relay fee of Bitcoin Core is returned.
+func (client *Client) RelayFee(success func(btcutil.Amount) error, cleanup func()) {
+	client.method(func() error { return success(minRelayFee) }, cleanup)
+}
+
+// EstimateFee implements blockchain.Interface. The server provides estimates in sat/vB for a fixed
+// set of confirmation targets. The estimate of the largest target not exceeding the requested one
+// is used. If there is none, `nil` is passed to the success callback.
+func (client *Client) EstimateFee(number int, success func(*btcutil.Amount) error, cleanup func()) {
+	client.method(func() error {
+		estimates := map[string]float64{}
+		if err := client.getJSON("/fee-estimates", &estimates); err != nil {
+			return err
+		}
+		bestTarget := 0
+		var feeRate float64
+		for targetString, estimate := range estimates {
+			target, err := strconv.Atoi(targetString)
+			if err != nil {
+				return &connectionError{errp.WithStack(err)}
+			}
+			if target <= number && target > bestTarget {
+				bestTarget = target
+				feeRate = estimate
+			}
+		}
+		if bestTarget == 0 {
+			return success(nil)
+		}
+		// sat/vB to sat/kB.
+		amount := btcutil.Amount(feeRate * 1000)
+		return success(&amount)
+	}, cleanup)
+}
+
+type block struct {
+	ID                string  `json:"id"`
+	Height            int     `json:"height"`
+	Version           int32   `json:"version"`
+	Timestamp         int64   `json:"timestamp"`
+	Bits              uint32  `json:"bits"`
+	Nonce             uint32  `json:"nonce"`
+	MerkleRoot        string  `json:"merkle_root"`
+	PreviousBlockHash *string `json:"previousblockhash"`
+}
+
+func (block *block) header() (*wire.BlockHeader, error) {
+	header := &wire.BlockHeader{
+		Version:   block.Version,
+		Timestamp: time.Unix(block.Timestamp, 0),
+		Bits:      block.Bits,
+		Nonce:     block.Nonce,
+	}
+	merkleRoot, err := chainhash.NewHashFromStr(block.MerkleRoot)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	header.MerkleRoot = *merkleRoot
+	// The genesis block has no previous block.
+	if block.PreviousBlockHash != nil {
+		prevBlock, err := chainhash.NewHashFromStr(*block.PreviousBlockHash)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		header.PrevBlock = *prevBlock
+	}
+	if header.BlockHash().String() != block.ID {
+		return nil, errp.Newf("header of block %d does not match its hash", block.Height)
+	}
+	return header, nil
+}
+
+// Headers implements blockchain.Interface. The headers are reconstructed from the block summaries
+// returned by /blocks/:height, which are ten per request.
+func (client *Client) Headers(
+	startHeight int, count int,
+	success func(headers []*wire.BlockHeader, max int) error,
+	cleanup func(),
+) {
+	client.method(func() error {
+		tip, err := client.fetchTip()
+		if err != nil {
+			return err
+		}
+		if count > headersPerBatch {
+			count = headersPerBatch
+		}
+		if startHeight+count > tip+1 {
+			count = tip + 1 - startHeight
+		}
+		headers := []*wire.BlockHeader{}
+		for len(headers) < count {
+			height := startHeight + len(headers)
+			// Blocks are returned from the given height downwards.
+			top := height + blocksPerPage - 1
+			if top > startHeight+count-1 {
+				top = startHeight + count - 1
+			}
+			blocks := []*block{}
+			if err := client.getJSON(fmt.Sprintf("/blocks/%d", top), &blocks); err != nil {
+				return err
+			}
+			for index := len(blocks) - 1; index >= 0; index-- {
+				if blocks[index].Height != height {
+					continue
+				}
+				header, err := blocks[index].header()
+				if err != nil {
+					return err
+				}
+				headers = append(headers, header)
+				height++
+			}
+			if height != top+1 {
+				return errp.Newf("expected blocks up to %d, got up to %d", top, height-1)
+			}
+		}
+		return success(headers, headersPerBatch)
+	}, cleanup)
+}
+
+// GetMerkle implements blockchain.Interface.
+func (client *Client) GetMerkle(
+	txHash chainhash.Hash, height int,
+	success func(merkle []blockchain.TXHash, pos int) error,
+	cleanup func(),
+) {
+	client.method(func() error {
+		var response struct {
+			BlockHeight int                 `json:"block_height"`
+			Merkle      []blockchain.TXHash `json:"merkle"`
+			Pos         int                 `json:"pos"`
+		}
+		if err := client.getJSON(fmt.Sprintf("/tx/%s/merkle-proof", txHash), &response); err != nil {
+			return err
+		}
+		if response.BlockHeight != height {
+			return errp.Newf("height should be %d, but got %d", height, response.BlockHeight)
+		}
+		return success(response.Merkle, response.Pos)
+	}, cleanup)
+}
+
+// Close implements blockchain.Interface.
+func (client *Client) Close() {
+	defer client.lock.Lock()()
+	if client.closed {
+		return
+	}
+	client.closed = true
+	close(client.closeChan)
+}
+
+// ConnectionStatus implements blockchain.Interface.
+func (client *Client) ConnectionStatus() blockchain.Status {
+	defer client.lock.RLock()()
+	return client.status
+}
+
+// RegisterOnConnectionStatusChangedEvent implements blockchain.Interface.
+func (client *Client) RegisterOnConnectionStatusChangedEvent(onConnectionStatusChanged func(blockchain.Status)) {
+	defer client.lock.Lock()()
+	client.onConnectionStatusChange = append(client.onConnectionStatusChange, onConnectionStatusChanged)
+}
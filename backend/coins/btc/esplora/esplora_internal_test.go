@@ -0,0 +1,426 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package esplora
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+)
+
+const testTimeout = 5 * time.Second
+
+func init() {
+	pollInterval = 20 * time.Millisecond
+}
+
+// fixtureServer serves canned responses by method and path.
+type fixtureServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	handlers map[string]func(body string) (int, string)
+}
+
+func newFixtureServer() *fixtureServer {
+	server := &fixtureServer{handlers: map[string]func(string) (int, string){}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		server.lock.Lock()
+		handler, ok := server.handlers[request.Method+" "+request.URL.Path]
+		server.lock.Unlock()
+		if !ok {
+			writer.WriteHeader(http.StatusNotFound)
+			_, _ = writer.Write([]byte("not found"))
+			return
+		}
+		status, response := handler(string(body))
+		writer.WriteHeader(status)
+		_, _ = writer.Write([]byte(response))
+	}))
+	return server
+}
+
+func (server *fixtureServer) handle(method string, path string, handler func(body string) (int, string)) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.handlers[method+" "+path] = handler
+}
+
+func (server *fixtureServer) get(path string, response interface{}) {
+	server.handle(http.MethodGet, path, func(string) (int, string) {
+		if text, ok := response.(string); ok {
+			return http.StatusOK, text
+		}
+		jsonBytes, err := json.Marshal(response)
+		if err != nil {
+			panic(err)
+		}
+		return http.StatusOK, string(jsonBytes)
+	})
+}
+
+func newTestClient(server *fixtureServer) *Client {
+	return NewClient(&Config{URL: server.URL + "/api/"},
+		socksproxy.NewSocksProxy(socksproxy.Config{}), logging.Get().WithGroup("esplora_test"))
+}
+
+func testTxID(i int) string {
+	return chainhash.Hash{byte(i), byte(i >> 8), 1}.String()
+}
+
+func confirmedTx(i int, height int) map[string]interface{} {
+	return map[string]interface{}{
+		"txid": testTxID(i), "fee": 100,
+		"status": map[string]interface{}{"confirmed": true, "block_height": height},
+	}
+}
+
+func TestScriptHash(t *testing.T) {
+	pkScript := []byte{txscript.OP_TRUE}
+	electrumScriptHash := blockchain.ScriptHashHex(chainhash.HashH(pkScript).String())
+	scriptHash, err := esploraScriptHash(electrumScriptHash)
+	require.NoError(t, err)
+	sha := sha256.Sum256(pkScript)
+	require.Equal(t, hex.EncodeToString(sha[:]), scriptHash)
+}
+
+func TestHistory(t *testing.T) {
+	server := newFixtureServer()
+	defer server.Close()
+	server.get("/api/blocks/tip/height", "200")
+
+	scriptHashHex := blockchain.ScriptHashHex(chainhash.HashH([]byte{txscript.OP_TRUE}).String())
+	scriptHash, err := esploraScriptHash(scriptHashHex)
+	require.NoError(t, err)
+
+	// One unconfirmed transaction and 27 confirmed ones, newest first, in two pages.
+	firstPage := []interface{}{map[string]interface{}{
+		"txid": testTxID(1000), "fee": 250, "status": map[string]interface{}{"confirmed": false},
+	}}
+	for i := 26; i >= 2; i-- {
+		firstPage = append(firstPage, confirmedTx(i, 100+i))
+	}
+	server.get(fmt.Sprintf("/api/scripthash/%s/txs", scriptHash), firstPage)
+	server.get(fmt.Sprintf("/api/scripthash/%s/txs/chain/%s", scriptHash, testTxID(2)),
+		[]interface{}{confirmedTx(1, 101), confirmedTx(0, 101)})
+
+	client := newTestClient(server)
+	defer client.Close()
+
+	historyChan := make(chan blockchain.TxHistory, 1)
+	client.ScriptHashGetHistory(scriptHashHex,
+		func(history blockchain.TxHistory) error { historyChan <- history; return nil },
+		func() {})
+	var history blockchain.TxHistory
+	select {
+	case history = <-historyChan:
+	case <-time.After(testTimeout):
+		require.Fail(t, "history not received")
+	}
+	require.Len(t, history, 28)
+	require.Equal(t, testTxID(0), history[0].TXHash.Hash().String())
+	require.Equal(t, 101, history[0].Height)
+	require.Nil(t, history[0].Fee)
+	require.Equal(t, testTxID(1), history[1].TXHash.Hash().String())
+	require.Equal(t, testTxID(26), history[26].TXHash.Hash().String())
+	require.Equal(t, 126, history[26].Height)
+	require.Equal(t, testTxID(1000), history[27].TXHash.Hash().String())
+	require.Equal(t, 0, history[27].Height)
+	require.Equal(t, int64(250), *history[27].Fee)
+}
+
+func TestSubscriptions(t *testing.T) {
+	server := newFixtureServer()
+	defer server.Close()
+	server.get("/api/blocks/tip/height", "100")
+	scriptHashHex := blockchain.ScriptHashHex(chainhash.HashH([]byte{txscript.OP_TRUE}).String())
+	scriptHash, err := esploraScriptHash(scriptHashHex)
+	require.NoError(t, err)
+	historyPath := fmt.Sprintf("/api/scripthash/%s/txs", scriptHash)
+	server.get(historyPath, []interface{}{})
+
+	client := newTestClient(server)
+	defer client.Close()
+
+	headerChan := make(chan int, 10)
+	client.HeadersSubscribe(nil,
+		func(header *blockchain.Header) error { headerChan <- header.BlockHeight; return nil })
+	statusChan := make(chan string, 10)
+	client.ScriptHashSubscribe(func() func() { return func() {} }, scriptHashHex,
+		func(status string) error { statusChan <- status; return nil })
+	receive := func(channel interface{}) interface{} {
+		switch channel := channel.(type) {
+		case chan int:
+			select {
+			case value := <-channel:
+				return value
+			case <-time.After(testTimeout):
+			}
+		case chan string:
+			select {
+			case value := <-channel:
+				return value
+			case <-time.After(testTimeout):
+			}
+		}
+		require.Fail(t, "notification not received")
+		return nil
+	}
+	require.Equal(t, 100, receive(headerChan))
+	require.Equal(t, "", receive(statusChan))
+
+	server.get("/api/blocks/tip/height", "101")
+	server.get(historyPath, []interface{}{confirmedTx(1, 101)})
+	require.Equal(t, 101, receive(headerChan))
+	txHash, err := chainhash.NewHashFromStr(testTxID(1))
+	require.NoError(t, err)
+	require.Equal(t,
+		blockchain.TxHistory{{Height: 101, TXHash: blockchain.TXHash(*txHash)}}.Status(),
+		receive(statusChan))
+
+	// Unchanged statuses are not notified again.
+	time.Sleep(5 * pollInterval)
+	require.Empty(t, statusChan)
+	require.Empty(t, headerChan)
+}
+
+func TestTransactions(t *testing.T) {
+	server := newFixtureServer()
+	defer server.Close()
+	server.get("/api/blocks/tip/height", "100")
+
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+	var rawTx bytes.Buffer
+	require.NoError(t, tx.BtcEncode(&rawTx, 0, wire.WitnessEncoding))
+	rawTxHex := hex.EncodeToString(rawTx.Bytes())
+	server.get(fmt.Sprintf("/api/tx/%s/hex", tx.TxHash()), rawTxHex)
+
+	client := newTestClient(server)
+	defer client.Close()
+
+	txChan := make(chan *wire.MsgTx, 1)
+	client.TransactionGet(tx.TxHash(), func(tx *wire.MsgTx) error { txChan <- tx; return nil }, func() {})
+	select {
+	case received := <-txChan:
+		require.Equal(t, tx.TxHash(), received.TxHash())
+	case <-time.After(testTimeout):
+		require.Fail(t, "transaction not received")
+	}
+
+	server.handle(http.MethodPost, "/api/tx", func(body string) (int, string) {
+		if body != rawTxHex {
+			return http.StatusBadRequest, "unexpected body"
+		}
+		return http.StatusOK, tx.TxHash().String()
+	})
+	require.NoError(t, client.TransactionBroadcast(tx))
+	server.handle(http.MethodPost, "/api/tx", func(string) (int, string) {
+		return http.StatusBadRequest, "sendrawtransaction RPC error: {\"code\":-26,\"message\":\"dust\"}"
+	})
+	require.Error(t, client.TransactionBroadcast(tx))
+}
+
+func TestFees(t *testing.T) {
+	server := newFixtureServer()
+	defer server.Close()
+	server.get("/api/blocks/tip/height", "100")
+	server.get("/api/fee-estimates", map[string]float64{"2": 20.5, "3": 10, "6": 5, "144": 1})
+
+	client := newTestClient(server)
+	defer client.Close()
+
+	estimateFee := func(number int) *btcutil.Amount {
+		feeChan := make(chan *btcutil.Amount, 1)
+		client.EstimateFee(number, func(fee *btcutil.Amount) error { feeChan <- fee; return nil }, func() {})
+		select {
+		case fee := <-feeChan:
+			return fee
+		case <-time.After(testTimeout):
+			require.Fail(t, "fee not received")
+			return nil
+		}
+	}
+	require.Nil(t, estimateFee(1))
+	require.Equal(t, btcutil.Amount(20500), *estimateFee(2))
+	require.Equal(t, btcutil.Amount(5000), *estimateFee(24))
+	require.Equal(t, btcutil.Amount(1000), *estimateFee(1000))
+
+	relayFeeChan := make(chan btcutil.Amount, 1)
+	client.RelayFee(func(fee btcutil.Amount) error { relayFeeChan <- fee; return nil }, func() {})
+	select {
+	case fee := <-relayFeeChan:
+		require.Equal(t, minRelayFee, fee)
+	case <-time.After(testTimeout):
+		require.Fail(t, "relay fee not received")
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	server := newFixtureServer()
+	defer server.Close()
+
+	// A chain of 25 blocks, served ten per page from the requested height downwards.
+	blocks := []*block{}
+	headers := []*wire.BlockHeader{}
+	for height := 0; height < 25; height++ {
+		header := &wire.BlockHeader{
+			Version:    1,
+			MerkleRoot: chainhash.Hash{byte(height)},
+			Timestamp:  time.Unix(int64(1500000000+height), 0),
+			Bits:       0x207fffff,
+			Nonce:      uint32(height),
+		}
+		summary := &block{
+			ID:         header.BlockHash().String(),
+			Height:     height,
+			Version:    header.Version,
+			Timestamp:  header.Timestamp.Unix(),
+			Bits:       header.Bits,
+			Nonce:      header.Nonce,
+			MerkleRoot: header.MerkleRoot.String(),
+		}
+		if height > 0 {
+			header.PrevBlock = headers[height-1].BlockHash()
+			summary.ID = header.BlockHash().String()
+			prevBlock := header.PrevBlock.String()
+			summary.PreviousBlockHash = &prevBlock
+		}
+		headers = append(headers, header)
+		blocks = append(blocks, summary)
+	}
+	server.get("/api/blocks/tip/height", "24")
+	for height := range blocks {
+		page := []*block{}
+		for index := height; index >= 0 && index > height-blocksPerPage; index-- {
+			page = append(page, blocks[index])
+		}
+		server.get(fmt.Sprintf("/api/blocks/%d", height), page)
+	}
+
+	client := newTestClient(server)
+	defer client.Close()
+
+	type result struct {
+		headers []*wire.BlockHeader
+		max     int
+	}
+	getHeaders := func(start, count int) result {
+		resultChan := make(chan result, 1)
+		client.Headers(start, count, func(headers []*wire.BlockHeader, max int) error {
+			resultChan <- result{headers, max}
+			return nil
+		}, func() {})
+		select {
+		case res := <-resultChan:
+			return res
+		case <-time.After(testTimeout):
+			require.Fail(t, "headers not received")
+			return result{}
+		}
+	}
+	res := getHeaders(0, 2016)
+	require.Equal(t, headers, res.headers)
+	require.Equal(t, headersPerBatch, res.max)
+	require.Equal(t, headers[3:8], getHeaders(3, 5).headers)
+	require.Empty(t, getHeaders(25, 10).headers)
+
+	// Headers which do not match the block hash are rejected.
+	blocks[20].Nonce++
+	doneChan := make(chan struct{})
+	client.Headers(15, 10, func([]*wire.BlockHeader, int) error {
+		require.Fail(t, "invalid headers accepted")
+		return nil
+	}, func() { close(doneChan) })
+	select {
+	case <-doneChan:
+	case <-time.After(testTimeout):
+		require.Fail(t, "request not finished")
+	}
+}
+
+func TestGetMerkle(t *testing.T) {
+	server := newFixtureServer()
+	defer server.Close()
+	server.get("/api/blocks/tip/height", "100")
+	txHash := chainhash.Hash{1}
+	sibling := chainhash.Hash{2}
+	server.get(fmt.Sprintf("/api/tx/%s/merkle-proof", txHash), map[string]interface{}{
+		"block_height": 90, "merkle": []string{sibling.String()}, "pos": 1,
+	})
+
+	client := newTestClient(server)
+	defer client.Close()
+
+	doneChan := make(chan struct{})
+	client.GetMerkle(txHash, 90, func(merkle []blockchain.TXHash, pos int) error {
+		require.Equal(t, []blockchain.TXHash{blockchain.TXHash(sibling)}, merkle)
+		require.Equal(t, 1, pos)
+		close(doneChan)
+		return nil
+	}, func() {})
+	select {
+	case <-doneChan:
+	case <-time.After(testTimeout):
+		require.Fail(t, "merkle proof not received")
+	}
+}
+
+func TestConnectionStatus(t *testing.T) {
+	server := newFixtureServer()
+	defer server.Close()
+	server.get("/api/blocks/tip/height", "100")
+
+	client := newTestClient(server)
+	defer client.Close()
+	statusChan := make(chan blockchain.Status, 1)
+	client.RegisterOnConnectionStatusChangedEvent(func(status blockchain.Status) { statusChan <- status })
+
+	server.handle(http.MethodGet, "/api/blocks/tip/height", func(string) (int, string) {
+		return http.StatusServiceUnavailable, "unavailable"
+	})
+	select {
+	case status := <-statusChan:
+		require.Equal(t, blockchain.DISCONNECTED, status)
+	case <-time.After(testTimeout):
+		require.Fail(t, "status not received")
+	}
+	server.get("/api/blocks/tip/height", "100")
+	select {
+	case status := <-statusChan:
+		require.Equal(t, blockchain.CONNECTED, status)
+	case <-time.After(testTimeout):
+		require.Fail(t, "status not received")
+	}
+}
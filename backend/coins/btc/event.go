@@ -34,4 +34,18 @@ const (
 
 	// EventFeeTargetsChanged is fired when the fee targets change.
 	EventFeeTargetsChanged Event = "feeTargetsChanged"
+
+	// EventDBReset is fired when the transactions database could not be migrated to the current
+	// schema and was cleared. The transactions are synced again from scratch.
+	EventDBReset Event = "dbReset"
+
+	// EventTxsUnverified is fired when transactions were unverified after a reorg. They are
+	// verified again against the new chain.
+	EventTxsUnverified Event = "txsUnverified"
+
+	// EventTxConflicted is fired when an unconfirmed transaction is double spent by another one.
+	EventTxConflicted Event = "txConflicted"
+
+	// EventTx identifies the account events which carry a TxEvent.
+	EventTx Event = "tx"
 )
@@ -17,6 +17,8 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
@@ -24,32 +26,53 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/util"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
 
 // Handlers provides a web api to the account.
 type Handlers struct {
-	account btc.Interface
-	log     *logrus.Entry
+	account          btc.Interface
+	historicalRate   HistoricalRate
+	contactRecipient ContactRecipient
+	log              *logrus.Entry
 }
 
-// NewHandlers creates a new Handlers instance.
+// ContactRecipient returns the address to send to when paying the contact with the given id with
+// the given coin, and whether the address was used before even though the contact asked for address
+// rotation. used reports whether coins were sent to an address before.
+type ContactRecipient func(id string, coinCode string, used func(address string) bool) (string, bool, error)
+
+// HistoricalRate returns the exchange rate of the coin with the given code in the given fiat
+// currency at the end of the day (UTC) of the given time.
+type HistoricalRate func(coinCode string, fiat string, t time.Time) (float64, error)
+
+// NewHandlers creates a new Handlers instance. historicalRate is used to value balances in fiat.
+// contactRecipient resolves the contacts referenced by send requests.
 func NewHandlers(
-	handleFunc func(string, func(*http.Request) (interface{}, error)) *mux.Route, log *logrus.Entry) *Handlers {
-	handlers := &Handlers{log: log}
+	handleFunc func(string, func(*http.Request) (interface{}, error)) *mux.Route,
+	historicalRate HistoricalRate,
+	contactRecipient ContactRecipient,
+	log *logrus.Entry) *Handlers {
+	handlers := &Handlers{historicalRate: historicalRate, contactRecipient: contactRecipient, log: log}
 
 	handleFunc("/init", handlers.postInit).Methods("POST")
 	handleFunc("/status", handlers.getAccountStatus).Methods("GET")
 	handleFunc("/transactions", handlers.ensureAccountInitialized(handlers.getAccountTransactions)).Methods("GET")
+	handleFunc("/transactions/query", handlers.ensureAccountInitialized(handlers.getAccountTransactionsQuery)).Methods("GET")
+	handleFunc("/tx-label", handlers.ensureAccountInitialized(handlers.postTxLabel)).Methods("POST")
 	handleFunc("/info", handlers.ensureAccountInitialized(handlers.getAccountInfo)).Methods("GET")
 	handleFunc("/utxos", handlers.ensureAccountInitialized(handlers.getUTXOs)).Methods("GET")
 	handleFunc("/balance", handlers.ensureAccountInitialized(handlers.getAccountBalance)).Methods("GET")
+	handleFunc("/balance-history", handlers.ensureAccountInitialized(handlers.getAccountBalanceHistory)).Methods("GET")
 	handleFunc("/sendtx", handlers.ensureAccountInitialized(handlers.postAccountSendTx)).Methods("POST")
 	handleFunc("/fee-targets", handlers.ensureAccountInitialized(handlers.getAccountFeeTargets)).Methods("GET")
 	handleFunc("/tx-proposal", handlers.ensureAccountInitialized(handlers.getAccountTxProposal)).Methods("POST")
@@ -57,6 +80,9 @@ func NewHandlers(
 	handleFunc("/receive-addresses", handlers.ensureAccountInitialized(handlers.getReceiveAddresses)).Methods("GET")
 	handleFunc("/verify-address", handlers.ensureAccountInitialized(handlers.postVerifyAddress)).Methods("POST")
 	handleFunc("/convert-to-legacy-address", handlers.ensureAccountInitialized(handlers.postConvertToLegacyAddress)).Methods("POST")
+	handleFunc("/sign-message", handlers.ensureAccountInitialized(handlers.postSignMessage)).Methods("POST")
+	handleFunc("/sign-typed-data", handlers.ensureAccountInitialized(handlers.postSignTypedData)).Methods("POST")
+	handleFunc("/verify-message", handlers.ensureAccountInitialized(handlers.postVerifyMessage)).Methods("POST")
 	return handlers
 }
 
@@ -105,6 +131,16 @@ type Transaction struct {
 	FeeRatePerKb     formattedAmount `json:"feeRatePerKb"`
 	Time             *string         `json:"time"`
 	Addresses        []string        `json:"addresses"`
+	Verified         bool            `json:"verified"`
+	Conflicted       bool            `json:"conflicted"`
+	Label            string          `json:"label"`
+}
+
+// txTypes maps the transaction types to their names in the api.
+var txTypes = map[transactions.TxType]string{
+	transactions.TxTypeReceive:  "receive",
+	transactions.TxTypeSend:     "send",
+	transactions.TxTypeSendSelf: "send_to_self",
 }
 
 func (handlers *Handlers) ensureAccountInitialized(h func(*http.Request) (interface{}, error)) func(*http.Request) (interface{}, error) {
@@ -118,38 +154,189 @@ func (handlers *Handlers) ensureAccountInitialized(h func(*http.Request) (interf
 
 func (handlers *Handlers) getAccountTransactions(_ *http.Request) (interface{}, error) {
 	result := []Transaction{}
-	txs := handlers.account.Transactions()
-	for _, txInfo := range txs {
-		var feeString, feeRatePerKb formattedAmount
-		if txInfo.Fee != nil {
-			feeString = handlers.formatBTCAmountAsJSON(*txInfo.Fee)
-			feeRatePerKb = handlers.formatBTCAmountAsJSON(*txInfo.FeeRatePerKb())
+	for _, txInfo := range handlers.account.Transactions() {
+		result = append(result, handlers.transaction(txInfo))
+	}
+	return result, nil
+}
+
+func (handlers *Handlers) transaction(txInfo *transactions.TxInfo) Transaction {
+	var feeString, feeRatePerKb formattedAmount
+	if txInfo.Fee != nil {
+		feeString = handlers.formatBTCAmountAsJSON(*txInfo.Fee)
+		feeRatePerKb = handlers.formatBTCAmountAsJSON(*txInfo.FeeRatePerKb())
+	}
+	var formattedTime *string
+	if txInfo.Timestamp != nil {
+		t := txInfo.Timestamp.Format(time.RFC3339)
+		formattedTime = &t
+	}
+	return Transaction{
+		ID:               txInfo.Tx.TxHash().String(),
+		NumConfirmations: txInfo.NumConfirmations,
+		VSize:            txInfo.VSize,
+		Size:             txInfo.Size,
+		Weight:           txInfo.Weight,
+		Height:           txInfo.Height,
+		Type:             txTypes[txInfo.Type],
+		Amount:           handlers.formatBTCAmountAsJSON(txInfo.Amount),
+		Fee:              feeString,
+		FeeRatePerKb:     feeRatePerKb,
+		Time:             formattedTime,
+		Addresses:        txInfo.Addresses,
+		Verified:         txInfo.Verified,
+		Conflicted:       txInfo.Conflicted,
+		Label:            txInfo.Label,
+	}
+}
+
+// parseTxQuery parses the query parameters of the /transactions/query endpoint. All parameters are
+// optional:
+//   - types: comma separated list of receive, send, send_to_self
+//   - from, to: RFC3339 timestamps bounding the time of confirmation, from inclusive, to exclusive
+//   - minAmount, maxAmount: in the unit of the coin, inclusive
+//   - address: an address the transaction sent to or received on
+//   - txid: substring of the transaction id
+//   - label: substring of the transaction label, case insensitive
+//   - status: confirmed, unconfirmed or conflicted
+//   - order: desc (newest first, default) or asc
+//   - cursor, limit: pagination, see transactions.TxQuery
+func (handlers *Handlers) parseTxQuery(r *http.Request) (*transactions.TxQuery, error) {
+	params := r.URL.Query()
+	query := &transactions.TxQuery{
+		Address: params.Get("address"),
+		TxID:    params.Get("txid"),
+		Label:   params.Get("label"),
+		Cursor:  params.Get("cursor"),
+	}
+	if types := params.Get("types"); types != "" {
+	typesLoop:
+		for _, name := range strings.Split(types, ",") {
+			for txType, txTypeName := range txTypes {
+				if name == txTypeName {
+					query.Types = append(query.Types, txType)
+					continue typesLoop
+				}
+			}
+			return nil, errp.Newf("unknown transaction type %q", name)
 		}
-		var formattedTime *string
-		if txInfo.Timestamp != nil {
-			t := txInfo.Timestamp.Format(time.RFC3339)
-			formattedTime = &t
+	}
+	parseTime := func(key string) (*time.Time, error) {
+		value := params.Get(key)
+		if value == "" {
+			return nil, nil
 		}
-		result = append(result, Transaction{
-			ID:               txInfo.Tx.TxHash().String(),
-			NumConfirmations: txInfo.NumConfirmations,
-			VSize:            txInfo.VSize,
-			Size:             txInfo.Size,
-			Weight:           txInfo.Weight,
-			Height:           txInfo.Height,
-			Type: map[transactions.TxType]string{
-				transactions.TxTypeReceive:  "receive",
-				transactions.TxTypeSend:     "send",
-				transactions.TxTypeSendSelf: "send_to_self",
-			}[txInfo.Type],
-			Amount:       handlers.formatBTCAmountAsJSON(txInfo.Amount),
-			Fee:          feeString,
-			FeeRatePerKb: feeRatePerKb,
-			Time:         formattedTime,
-			Addresses:    txInfo.Addresses,
-		})
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, errp.Newf("invalid %s: %q", key, value)
+		}
+		return &t, nil
 	}
-	return result, nil
+	parseAmount := func(key string) (*btcutil.Amount, error) {
+		value := params.Get(key)
+		if value == "" {
+			return nil, nil
+		}
+		amount, err := handlers.account.Coin().ParseAmount(value)
+		if err != nil {
+			return nil, err
+		}
+		amountInt64, err := amount.Int64()
+		if err != nil {
+			return nil, err
+		}
+		btcAmount := btcutil.Amount(amountInt64)
+		return &btcAmount, nil
+	}
+	var err error
+	if query.From, err = parseTime("from"); err != nil {
+		return nil, err
+	}
+	if query.To, err = parseTime("to"); err != nil {
+		return nil, err
+	}
+	if query.MinAmount, err = parseAmount("minAmount"); err != nil {
+		return nil, err
+	}
+	if query.MaxAmount, err = parseAmount("maxAmount"); err != nil {
+		return nil, err
+	}
+	switch status := transactions.TxStatus(params.Get("status")); status {
+	case "", transactions.TxStatusConfirmed, transactions.TxStatusUnconfirmed,
+		transactions.TxStatusConflicted:
+		query.Status = status
+	default:
+		return nil, errp.Newf("unknown status %q", status)
+	}
+	switch order := params.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return nil, errp.Newf("unknown order %q", order)
+	}
+	if limit := params.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 0 {
+			return nil, errp.Newf("invalid limit %q", limit)
+		}
+	}
+	return query, nil
+}
+
+func (handlers *Handlers) getAccountTransactionsQuery(r *http.Request) (interface{}, error) {
+	query, err := handlers.parseTxQuery(r)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	queryResult, err := handlers.account.QueryTransactions(query)
+	if errp.Cause(err) == btc.ErrUnsupported {
+		return map[string]interface{}{
+			"success":     false,
+			"unsupported": true,
+			"errMsg":      "querying the transactions is not supported for this account",
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := []Transaction{}
+	for _, txInfo := range queryResult.Transactions {
+		result = append(result, handlers.transaction(txInfo))
+	}
+	return map[string]interface{}{
+		"success":      true,
+		"transactions": result,
+		"nextCursor":   queryResult.NextCursor,
+	}, nil
+}
+
+func (handlers *Handlers) postTxLabel(r *http.Request) (interface{}, error) {
+	jsonBody := struct {
+		TxID  string `json:"txID"`
+		Label string `json:"label"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	err := handlers.account.SetTxLabel(jsonBody.TxID, jsonBody.Label)
+	if errp.Cause(err) == btc.ErrUnsupported {
+		return map[string]interface{}{
+			"success":     false,
+			"unsupported": true,
+			"errMsg":      "transaction labels are not supported for this account",
+		}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
 }
 
 func (handlers *Handlers) getAccountInfo(_ *http.Request) (interface{}, error) {
@@ -178,8 +365,108 @@ func (handlers *Handlers) getAccountBalance(_ *http.Request) (interface{}, error
 	}, nil
 }
 
+// BalanceHistoryPoint is the info returned per interval by the /balance-history endpoint.
+type BalanceHistoryPoint struct {
+	Time    string          `json:"time"`
+	Balance formattedAmount `json:"balance"`
+	// FiatValue is the balance valued at the exchange rate of the end of the interval, or the
+	// current one for the current interval. It is nil if no rate is available.
+	FiatValue *string `json:"fiatValue"`
+}
+
+// BalanceHistoryQuery parses the interval and fiat currency of a balance history request. The
+// interval defaults to a day and the fiat currency to USD.
+func BalanceHistoryQuery(r *http.Request) (coin.Interval, string, error) {
+	intervalString := r.URL.Query().Get("interval")
+	if intervalString == "" {
+		intervalString = string(coin.IntervalDay)
+	}
+	interval, err := coin.NewInterval(intervalString)
+	if err != nil {
+		return "", "", err
+	}
+	fiat := r.URL.Query().Get("fiat")
+	if fiat == "" {
+		fiat = "USD"
+	}
+	return interval, fiat, nil
+}
+
+// BalanceFiatValue values a point of a balance history, bucketed by the given interval, in the
+// given fiat currency at the exchange rate of the end of the interval, or of now for the current
+// interval.
+func BalanceFiatValue(
+	c coin.Coin,
+	point *coin.BalancePoint,
+	interval coin.Interval,
+	now time.Time,
+	fiat string,
+	historicalRate HistoricalRate,
+) (float64, error) {
+	rateTime := interval.End(point.Time)
+	if rateTime.After(now) {
+		rateTime = now
+	}
+	rate, err := historicalRate(c.Code(), fiat, rateTime)
+	if err != nil {
+		return 0, err
+	}
+	amountAsFloat, err := strconv.ParseFloat(c.FormatAmount(point.Balance), 64)
+	if err != nil {
+		return 0, errp.WithStack(err)
+	}
+	return amountAsFloat * rate, nil
+}
+
+func (handlers *Handlers) getAccountBalanceHistory(r *http.Request) (interface{}, error) {
+	interval, fiat, err := BalanceHistoryQuery(r)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	history, err := handlers.account.BalanceHistory()
+	if errp.Cause(err) == btc.ErrUnsupported {
+		return map[string]interface{}{
+			"success":     false,
+			"unsupported": true,
+			"errMsg":      "the balance history is not supported for this account",
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	result := []BalanceHistoryPoint{}
+	for _, point := range coin.BucketBalances(history, interval, now) {
+		var formattedFiatValue *string
+		fiatValue, err := BalanceFiatValue(
+			handlers.account.Coin(), point, interval, now, fiat, handlers.historicalRate)
+		if err == nil {
+			formatted := strconv.FormatFloat(fiatValue, 'f', 2, 64)
+			formattedFiatValue = &formatted
+		} else {
+			handlers.log.WithError(err).Debug("No exchange rate for the balance history")
+		}
+		result = append(result, BalanceHistoryPoint{
+			Time:      point.Time.Format(time.RFC3339),
+			Balance:   handlers.formatAmountAsJSON(point.Balance),
+			FiatValue: formattedFiatValue,
+		})
+	}
+	return map[string]interface{}{
+		"success":  true,
+		"interval": interval,
+		"fiat":     fiat,
+		"points":   result,
+	}, nil
+}
+
 type sendTxInput struct {
-	address       string
+	address string
+	// contact is the id of an address book contact to send to instead of address.
+	contact       string
 	sendAmount    coin.SendAmount
 	feeTargetCode btc.FeeTargetCode
 	selectedUTXOs map[wire.OutPoint]struct{}
@@ -188,6 +475,7 @@ type sendTxInput struct {
 func (input *sendTxInput) UnmarshalJSON(jsonBytes []byte) error {
 	jsonBody := struct {
 		Address       string   `json:"address"`
+		Contact       string   `json:"contact"`
 		SendAll       string   `json:"sendAll"`
 		FeeTarget     string   `json:"feeTarget"`
 		Amount        string   `json:"amount"`
@@ -197,6 +485,10 @@ func (input *sendTxInput) UnmarshalJSON(jsonBytes []byte) error {
 		return errp.WithStack(err)
 	}
 	input.address = jsonBody.Address
+	input.contact = jsonBody.Contact
+	if input.address != "" && input.contact != "" {
+		return errp.New("either an address or a contact can be given, but not both")
+	}
 	var err error
 	input.feeTargetCode, err = btc.NewFeeTargetCode(jsonBody.FeeTarget)
 	if err != nil {
@@ -218,11 +510,77 @@ func (input *sendTxInput) UnmarshalJSON(jsonBytes []byte) error {
 	return nil
 }
 
+// addressReuse tells whether the address of a contact, who asked for address rotation, was used
+// before.
+type addressReuse string
+
+const (
+	addressReuseNo      addressReuse = "no"
+	addressReuseYes     addressReuse = "yes"
+	addressReuseUnknown addressReuse = "unknown"
+)
+
+// sentToAddresses returns the addresses to which coins were sent in previous transactions. The
+// second return value is false if the transaction history of the account is not available
+// (Ethereum).
+func (handlers *Handlers) sentToAddresses() (map[string]struct{}, bool) {
+	if _, isETH := handlers.account.(*eth.Account); isETH {
+		return nil, false
+	}
+	addresses := map[string]struct{}{}
+	for _, txInfo := range handlers.account.Transactions() {
+		if txInfo.Type == transactions.TxTypeReceive {
+			continue
+		}
+		for _, address := range txInfo.Addresses {
+			addresses[address] = struct{}{}
+		}
+	}
+	return addresses, true
+}
+
+// resolveRecipient replaces the contact reference of the input with the address of the contact. The
+// result tells whether the address was used before even though the contact asked for address
+// rotation, which is unknown if the transaction history of the account is not available.
+func (handlers *Handlers) resolveRecipient(input *sendTxInput) (addressReuse, error) {
+	if input.contact == "" {
+		return addressReuseNo, nil
+	}
+	sentTo, known := handlers.sentToAddresses()
+	// used is only consulted if the contact asked for address rotation.
+	rotating := false
+	used := func(address string) bool {
+		rotating = true
+		_, ok := sentTo[address]
+		return ok
+	}
+	address, reused, err := handlers.contactRecipient(
+		input.contact, handlers.account.Coin().Code(), used)
+	if err != nil {
+		return "", err
+	}
+	input.address = address
+	switch {
+	case rotating && !known:
+		return addressReuseUnknown, nil
+	case reused:
+		return addressReuseYes, nil
+	default:
+		return addressReuseNo, nil
+	}
+}
+
 func (handlers *Handlers) postAccountSendTx(r *http.Request) (interface{}, error) {
 	var input sendTxInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		return nil, errp.WithStack(err)
 	}
+	if _, err := handlers.resolveRecipient(&input); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
 	err := handlers.account.SendTx(input.address, input.sendAmount, input.feeTargetCode, input.selectedUTXOs)
 	if errp.Cause(err) == keystore.ErrSigningAborted {
 		return map[string]interface{}{"success": false}, nil
@@ -254,6 +612,13 @@ func (handlers *Handlers) getAccountTxProposal(r *http.Request) (interface{}, er
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		return txProposalError(errp.WithStack(err))
 	}
+	reuse, err := handlers.resolveRecipient(&input)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
 	outputAmount, fee, total, err := handlers.account.TxProposal(
 		input.address,
 		input.sendAmount,
@@ -264,10 +629,12 @@ func (handlers *Handlers) getAccountTxProposal(r *http.Request) (interface{}, er
 		return txProposalError(err)
 	}
 	return map[string]interface{}{
-		"success": true,
-		"amount":  handlers.formatAmountAsJSON(outputAmount),
-		"fee":     handlers.formatAmountAsJSON(fee),
-		"total":   handlers.formatAmountAsJSON(total),
+		"success":      true,
+		"amount":       handlers.formatAmountAsJSON(outputAmount),
+		"fee":          handlers.formatAmountAsJSON(fee),
+		"total":        handlers.formatAmountAsJSON(total),
+		"address":      input.address,
+		"addressReuse": reuse,
 	}, nil
 }
 
@@ -351,3 +718,88 @@ func (handlers *Handlers) postConvertToLegacyAddress(r *http.Request) (interface
 	}
 	return address.EncodeAddress(), nil
 }
+
+func (handlers *Handlers) ethAccount() (*eth.Account, error) {
+	ethAccount, ok := handlers.account.(*eth.Account)
+	if !ok {
+		return nil, errp.New("Message signing is only supported by Ethereum accounts.")
+	}
+	return ethAccount, nil
+}
+
+func signatureResult(signature []byte, err error) (interface{}, error) {
+	if errp.Cause(err) == keystore.ErrSigningAborted {
+		return map[string]interface{}{"success": false}, nil
+	}
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to sign")
+	}
+	return map[string]interface{}{
+		"success":   true,
+		"signature": hexutil.Encode(signature),
+	}, nil
+}
+
+func (handlers *Handlers) postSignMessage(r *http.Request) (interface{}, error) {
+	ethAccount, err := handlers.ethAccount()
+	if err != nil {
+		return nil, err
+	}
+	jsonBody := struct {
+		Message string `json:"message"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return signatureResult(ethAccount.SignMessage([]byte(jsonBody.Message)))
+}
+
+func (handlers *Handlers) postSignTypedData(r *http.Request) (interface{}, error) {
+	ethAccount, err := handlers.ethAccount()
+	if err != nil {
+		return nil, err
+	}
+	var typedData eth.TypedData
+	decoder := json.NewDecoder(r.Body)
+	// Keep big numbers exact.
+	decoder.UseNumber()
+	if err := decoder.Decode(&typedData); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return signatureResult(ethAccount.SignTypedData(&typedData))
+}
+
+// postVerifyMessage recovers the address which signed either the message (personal_sign) or the
+// typed data (EIP-712).
+func (handlers *Handlers) postVerifyMessage(r *http.Request) (interface{}, error) {
+	if _, err := handlers.ethAccount(); err != nil {
+		return nil, err
+	}
+	jsonBody := struct {
+		Message   *string        `json:"message"`
+		TypedData *eth.TypedData `json:"typedData"`
+		Signature string         `json:"signature"`
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&jsonBody); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	signature, err := hexutil.Decode(jsonBody.Signature)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	var signer common.Address
+	switch {
+	case jsonBody.Message != nil:
+		signer, err = eth.RecoverMessageSigner([]byte(*jsonBody.Message), signature)
+	case jsonBody.TypedData != nil:
+		signer, err = eth.RecoverTypedDataSigner(jsonBody.TypedData, signature)
+	default:
+		return nil, errp.New("Either a message or typed data is required.")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"address": signer.Hex()}, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package headers
+
+import (
+	"math/big"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Checkpoint is a trusted block from which a new install starts syncing headers, instead of
+// syncing from the genesis block. The headers below the checkpoint are downloaded and verified
+// against it when they are needed, e.g. to verify a transaction.
+type Checkpoint struct {
+	Height int
+	Hash   chainhash.Hash
+	// Work is the accumulated proof of work of the chain up to and including the checkpoint block.
+	Work *big.Int
+	// Headers are the headers of the retarget window needed to compute the difficulty of the
+	// headers following the checkpoint, by height. It includes the header of the checkpoint block.
+	// On networks with the minimum difficulty rule (testnets), all headers of the retarget window
+	// up to the checkpoint are needed.
+	Headers map[int]*wire.BlockHeader
+}
+
+// header returns the header of the checkpoint block, after checking it against the checkpoint.
+func (checkpoint *Checkpoint) header() (*wire.BlockHeader, error) {
+	header, ok := checkpoint.Headers[checkpoint.Height]
+	if !ok {
+		return nil, errp.Newf("checkpoint %d is missing its header", checkpoint.Height)
+	}
+	if header.BlockHash() != checkpoint.Hash {
+		return nil, errp.Newf("checkpoint %d header hash should be %s, but got %s",
+			checkpoint.Height, checkpoint.Hash, header.BlockHash())
+	}
+	return header, nil
+}
+
+// SetCheckpoint sets the checkpoint to start syncing from. Must be called before Init.
+func (headers *Headers) SetCheckpoint(checkpoint *Checkpoint) {
+	headers.checkpoint = checkpoint
+}
+
+// applyCheckpoint moves the tip to the checkpoint if the headers are not synced up to it yet.
+func (headers *Headers) applyCheckpoint() error {
+	if headers.checkpoint == nil {
+		return nil
+	}
+	header, err := headers.checkpoint.header()
+	if err != nil {
+		return err
+	}
+	defer headers.lock.Lock()()
+	dbTx, err := headers.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+	tip, err := dbTx.Tip()
+	if err != nil {
+		return err
+	}
+	if tip >= headers.checkpoint.Height {
+		return nil
+	}
+	headers.log.Infof("Syncing from the checkpoint at %d", headers.checkpoint.Height)
+	if err := dbTx.PutBaseHeader(headers.checkpoint.Height, header); err != nil {
+		return err
+	}
+	if err := dbTx.PutTip(headers.checkpoint.Height); err != nil {
+		return err
+	}
+	return dbTx.Commit()
+}
+
+// headerByHeight returns the header at the given height from the checkpoint if it is one of its
+// headers, otherwise from the database.
+func (headers *Headers) headerByHeight(dbTx DBTxInterface, height int) (*wire.BlockHeader, error) {
+	if headers.checkpoint != nil {
+		if header, ok := headers.checkpoint.Headers[height]; ok {
+			return header, nil
+		}
+	}
+	return dbTx.HeaderByHeight(height)
+}
+
+// requestBackfill schedules the download of the headers from the given height up to the base.
+func (headers *Headers) requestBackfill(height int) {
+	defer headers.backfillLock.Lock()()
+	if headers.backfillHeight < 0 || height < headers.backfillHeight {
+		headers.backfillHeight = height
+	}
+	headers.kick()
+}
+
+// backfill downloads a batch of the headers below the base, going down to the requested height.
+// The headers are verified by checking that they connect to the base header.
+func (headers *Headers) backfill(dbTx DBTxInterface) error {
+	unlock := headers.backfillLock.RLock()
+	target := headers.backfillHeight
+	unlock()
+	base, err := dbTx.Base()
+	if err != nil {
+		return err
+	}
+	if target < 0 || target >= base {
+		return nil
+	}
+	baseHeader, err := dbTx.HeaderByHeight(base)
+	if err != nil {
+		return err
+	}
+	if baseHeader == nil {
+		return errp.Newf("base header %d is missing", base)
+	}
+	start := base - headers.headersPerBatch
+	if start < target {
+		start = target
+	}
+	batch := headers.fetch(start, base-start)
+	if len(batch.blockHeaders) != base-start {
+		return errp.Newf("expected %d headers below %d, but got %d",
+			base-start, base, len(batch.blockHeaders))
+	}
+	next := baseHeader
+	for height := base - 1; height >= start; height-- {
+		header := batch.blockHeaders[height-start]
+		if next.PrevBlock != header.BlockHash() {
+			return errp.Newf("header %d does not connect to %d", height, height+1)
+		}
+		if height == 0 && header.BlockHash() != *headers.net.GenesisHash {
+			return errp.Newf("wrong genesis hash, got %s, expected %s",
+				header.BlockHash(), *headers.net.GenesisHash)
+		}
+		if err := dbTx.PutBaseHeader(height, header); err != nil {
+			return err
+		}
+		next = header
+	}
+	if start > target {
+		headers.kick()
+		return nil
+	}
+	func() {
+		defer headers.backfillLock.Lock()()
+		if headers.backfillHeight >= start {
+			headers.backfillHeight = -1
+		}
+	}()
+	headers.log.Debugf("Backfilled headers down to %d", start)
+	headers.notifyEvent(EventSynced)
+	return nil
+}
@@ -0,0 +1,309 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package headers
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	btcdBlockchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain/mocks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+// chainBlockchain serves the headers of a chain.
+type chainBlockchain struct {
+	mocks.Interface
+	chain []*wire.BlockHeader
+}
+
+func (b *chainBlockchain) Headers(
+	startHeight int, count int, success func([]*wire.BlockHeader, int) error, cleanup func()) {
+	end := startHeight + count
+	if end > len(b.chain) {
+		end = len(b.chain)
+	}
+	result := []*wire.BlockHeader{}
+	if startHeight < end {
+		result = b.chain[startHeight:end]
+	}
+	go func() {
+		_ = success(result, 2016)
+		cleanup()
+	}()
+}
+
+func (b *chainBlockchain) HeadersSubscribe(
+	setupAndTeardown func() func(), success func(*blockchain.Header) error) {
+	go func() { _ = success(&blockchain.Header{BlockHeight: len(b.chain) - 1}) }()
+}
+
+// mine sets the nonce of the header such that its hash meets the target of the header.
+func mine(header *wire.BlockHeader) {
+	target := btcdBlockchain.CompactToBig(header.Bits)
+	for {
+		hash := header.BlockHash()
+		if btcdBlockchain.HashToBig(&hash).Cmp(target) <= 0 {
+			return
+		}
+		header.Nonce++
+	}
+}
+
+// regtestChain returns a chain of headers on top of the regtest genesis block.
+func regtestChain(length int) []*wire.BlockHeader {
+	chain := []*wire.BlockHeader{&chaincfg.RegressionNetParams.GenesisBlock.Header}
+	for len(chain) < length {
+		previous := chain[len(chain)-1]
+		chain = append(chain, &wire.BlockHeader{
+			Version:   0x20000000,
+			PrevBlock: previous.BlockHash(),
+			Timestamp: previous.Timestamp.Add(10 * time.Minute),
+			Bits:      previous.Bits,
+		})
+		mine(chain[len(chain)-1])
+	}
+	return chain
+}
+
+func newCheckpoint(chain []*wire.BlockHeader, height int) *Checkpoint {
+	return &Checkpoint{
+		Height:  height,
+		Hash:    chain[height].BlockHash(),
+		Work:    big.NewInt(int64(2 * (height + 1))),
+		Headers: map[int]*wire.BlockHeader{height: chain[height]},
+	}
+}
+
+// base returns the base of the database of the headers.
+func base(headers *Headers) int {
+	defer headers.lock.RLock()()
+	base, _ := headers.db.(*memoryDB).tx.Base()
+	return base
+}
+
+// waitFor polls the condition until it is true or fails the test after a while.
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 200; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Fail(t, "condition not met")
+}
+
+func TestCheckpointSync(t *testing.T) {
+	chain := regtestChain(40)
+	db := &memoryDB{tx: newMemoryDBTx()}
+	headers := NewHeaders(&chaincfg.RegressionNetParams, db,
+		&chainBlockchain{chain: chain}, logging.Get().WithGroup("headers_test"))
+	headers.SetCheckpoint(newCheckpoint(chain, 29))
+	synced := make(chan struct{}, 100)
+	headers.SubscribeEvent(func(event Event) {
+		if event == EventSynced {
+			synced <- struct{}{}
+		}
+	})
+	headers.Init()
+
+	// Syncing starts at the checkpoint.
+	waitFor(t, func() bool { return headers.tip() == 39 })
+	require.Equal(t, 29, base(headers))
+	require.Equal(t, 29, headers.tipAtInitTime)
+	header, err := headers.HeaderByHeight(35)
+	require.NoError(t, err)
+	require.Equal(t, chain[35], header)
+
+	// Headers below the checkpoint are downloaded on demand.
+	header, err = headers.HeaderByHeight(5)
+	require.NoError(t, err)
+	require.Nil(t, header)
+	waitFor(t, func() bool {
+		header, err := headers.HeaderByHeight(5)
+		require.NoError(t, err)
+		return header != nil
+	})
+	header, err = headers.HeaderByHeight(5)
+	require.NoError(t, err)
+	require.Equal(t, chain[5], header)
+	require.Equal(t, 5, base(headers))
+	require.NotEmpty(t, synced)
+}
+
+func TestCheckpointBackfillMismatch(t *testing.T) {
+	chain := regtestChain(40)
+	otherChain := append([]*wire.BlockHeader{}, chain...)
+	tampered := *chain[20]
+	tampered.Nonce++
+	otherChain[20] = &tampered
+	db := &memoryDB{tx: newMemoryDBTx()}
+	headers := NewHeaders(&chaincfg.RegressionNetParams, db,
+		&chainBlockchain{chain: otherChain}, logging.Get().WithGroup("headers_test"))
+	headers.SetCheckpoint(newCheckpoint(chain, 29))
+	headers.Init()
+	waitFor(t, func() bool { return headers.tip() == 39 })
+
+	header, err := headers.HeaderByHeight(5)
+	require.NoError(t, err)
+	require.Nil(t, header)
+	time.Sleep(100 * time.Millisecond)
+	header, err = headers.HeaderByHeight(5)
+	require.NoError(t, err)
+	require.Nil(t, header)
+	// The headers down to the tampered one are stored, as they connect to the checkpoint.
+	require.Equal(t, 21, base(headers))
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	chain := regtestChain(40)
+	headers := NewHeaders(&chaincfg.RegressionNetParams, nil, nil,
+		logging.Get().WithGroup("headers_test"))
+	checkpoint := newCheckpoint(chain, 29)
+	headers.SetCheckpoint(checkpoint)
+	dbTx := newMemoryDBTx()
+	for height, header := range chain[:29] {
+		require.NoError(t, dbTx.PutHeader(height, header))
+	}
+	require.NoError(t, headers.canConnect(dbTx, 29, chain[29]))
+	forked := *chain[29]
+	forked.Nonce++
+	require.Error(t, headers.canConnect(dbTx, 29, &forked))
+
+	// The header of the checkpoint must match its hash.
+	checkpoint.Headers[29] = &forked
+	_, err := checkpoint.header()
+	require.Error(t, err)
+}
+
+func TestCheckpointReorg(t *testing.T) {
+	chain := regtestChain(140)
+	headers := NewHeaders(&chaincfg.RegressionNetParams, nil, nil,
+		logging.Get().WithGroup("headers_test"))
+	events := make(chan Event, 10)
+	headers.SubscribeEvent(func(event Event) { events <- event })
+	dbTx := newMemoryDBTx()
+	for height, header := range chain {
+		require.NoError(t, dbTx.PutHeader(height, header))
+	}
+	headers.reorg(dbTx, 139)
+	require.Equal(t, 39, dbTx.tip)
+	require.Equal(t, EventReorg, <-events)
+	headers.reorg(dbTx, 39)
+	require.Equal(t, -1, dbTx.tip)
+
+	// The chain is not reorganized below the checkpoint.
+	headers.SetCheckpoint(newCheckpoint(chain, 80))
+	headers.reorg(dbTx, 139)
+	require.Equal(t, 80, dbTx.tip)
+
+	// Nor below the base.
+	headers.SetCheckpoint(nil)
+	require.NoError(t, dbTx.PutBaseHeader(60, chain[60]))
+	headers.reorg(dbTx, 139)
+	require.Equal(t, 60, dbTx.tip)
+}
+
+func TestCheckpointTarget(t *testing.T) {
+	net := &chaincfg.MainNetParams
+	first := &wire.BlockHeader{Timestamp: time.Unix(1500000000, 0), Bits: 0x1800d0f6}
+	last := &wire.BlockHeader{
+		Timestamp: time.Unix(1500000000+12*24*3600, 0), Bits: 0x1800d0f6, Nonce: 1}
+	headers := NewHeaders(net, nil, nil, logging.Get().WithGroup("headers_test"))
+	dbTx := newMemoryDBTx()
+	require.NoError(t, dbTx.PutHeader(2016, first))
+	require.NoError(t, dbTx.PutHeader(4031, last))
+	expected, err := headers.getTarget(dbTx, 4032, nil)
+	require.NoError(t, err)
+
+	// The target following the checkpoint is computed from the headers of the checkpoint.
+	headers.SetCheckpoint(&Checkpoint{
+		Height:  4031,
+		Hash:    last.BlockHash(),
+		Headers: map[int]*wire.BlockHeader{2016: first, 4031: last},
+	})
+	target, err := headers.getTarget(newMemoryDBTx(), 4032, nil)
+	require.NoError(t, err)
+	require.Equal(t, expected, target)
+	target, err = headers.getTarget(newMemoryDBTx(), 5000, nil)
+	require.NoError(t, err)
+	require.Equal(t, expected, target)
+}
+
+// requestsBlockchain records the start heights of the requested headers.
+type requestsBlockchain struct {
+	chainBlockchain
+	startHeights chan int
+}
+
+func (b *requestsBlockchain) Headers(
+	startHeight int, count int, success func([]*wire.BlockHeader, int) error, cleanup func()) {
+	b.startHeights <- startHeight
+	b.chainBlockchain.Headers(startHeight, count, success, cleanup)
+}
+
+func TestBundledCheckpoints(t *testing.T) {
+	require.NotNil(t, NewCheckpoint(&chaincfg.MainNetParams))
+	require.Nil(t, NewCheckpoint(&chaincfg.RegressionNetParams))
+	nets := []*chaincfg.Params{
+		&chaincfg.MainNetParams, &chaincfg.TestNet3Params, &ltc.MainNetParams, &ltc.TestNet4Params,
+	}
+	for _, net := range nets {
+		checkpoint := NewCheckpoint(net)
+		if checkpoint == nil {
+			continue
+		}
+		header, err := checkpoint.header()
+		require.NoError(t, err, net.Name)
+		require.Equal(t, &net.GenesisBlock.Header, checkpoint.Headers[0], net.Name)
+		minWork := new(big.Int).Mul(
+			btcdBlockchain.CalcWork(net.PowLimitBits), big.NewInt(int64(checkpoint.Height+1)))
+		require.True(t, checkpoint.Work.Cmp(minWork) >= 0, net.Name)
+		for height, header := range checkpoint.Headers {
+			if previous, ok := checkpoint.Headers[height-1]; ok {
+				require.Equal(t, previous.BlockHash(), header.PrevBlock, net.Name)
+			}
+		}
+
+		// The bundled headers are enough to compute the difficulty after the checkpoint.
+		headers := NewHeaders(net, nil, nil, logging.Get().WithGroup("headers_test"))
+		headers.SetCheckpoint(checkpoint)
+		_, err = headers.getTarget(newMemoryDBTx(), checkpoint.Height+1,
+			&wire.BlockHeader{Timestamp: header.Timestamp.Add(time.Minute)})
+		require.NoError(t, err, net.Name)
+
+		// The first sync of a new install starts after the checkpoint.
+		chain := make([]*wire.BlockHeader, checkpoint.Height+1)
+		chain[checkpoint.Height] = header
+		blockchain := &requestsBlockchain{
+			chainBlockchain: chainBlockchain{chain: chain},
+			startHeights:    make(chan int, 100),
+		}
+		headers = NewHeaders(net, &memoryDB{tx: newMemoryDBTx()}, blockchain,
+			logging.Get().WithGroup("headers_test"))
+		headers.SetCheckpoint(checkpoint)
+		headers.Init()
+		require.Equal(t, checkpoint.Height, headers.tipAtInitTime, net.Name)
+		require.Equal(t, checkpoint.Height+1, <-blockchain.startHeights, net.Name)
+		require.Equal(t, checkpoint.Height, base(headers), net.Name)
+	}
+}
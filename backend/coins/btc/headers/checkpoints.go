@@ -0,0 +1,86 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package headers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// bundledCheckpoint is the serialized form of a Checkpoint. The headers are hex encoded. The genesis
+// header, the first header of the first retarget window, is taken from the chain params.
+type bundledCheckpoint struct {
+	height  int
+	hash    string
+	work    string
+	headers map[int]string
+}
+
+// bundledCheckpoints are the checkpoints from which a new install starts syncing, by the genesis
+// hash of the network. They have to be taken from a trusted node, which cmd/checkpoint does.
+// Networks without a checkpoint sync from the genesis block.
+var bundledCheckpoints = map[chainhash.Hash]bundledCheckpoint{
+	// Block 255, as in the mainnet blocks in the test data of btcd (database/testdata). The work
+	// is that of 256 blocks at the initial difficulty.
+	*chaincfg.MainNetParams.GenesisHash: {
+		height: 255,
+		hash:   "00000000d0a75c861fabf9ff7b92022f60e4afeed9331fe5aa073d8e4706fe3c",
+		work:   "1099528405248",
+		headers: map[int]string{
+			255: "010000009c371af755f56db86fce75b282e9f16b2e5c1896d64d2e836acac36500000000" +
+				"9ed7bb8472c60a6ef80e0b0c1226ccb9068994f8bc08da09f3707ad7eebf09432abc6b49" +
+				"ffff001d3493f76e",
+		},
+	},
+}
+
+// NewCheckpoint returns the bundled checkpoint of the network, or nil if there is none.
+func NewCheckpoint(net *chaincfg.Params) *Checkpoint {
+	bundled, ok := bundledCheckpoints[*net.GenesisHash]
+	if !ok {
+		return nil
+	}
+	hash, err := chainhash.NewHashFromStr(bundled.hash)
+	if err != nil {
+		panic(err)
+	}
+	work, ok := new(big.Int).SetString(bundled.work, 10)
+	if !ok {
+		panic("invalid checkpoint work")
+	}
+	checkpoint := &Checkpoint{
+		Height:  bundled.height,
+		Hash:    *hash,
+		Work:    work,
+		Headers: map[int]*wire.BlockHeader{0: &net.GenesisBlock.Header},
+	}
+	for height, headerHex := range bundled.headers {
+		headerBytes, err := hex.DecodeString(headerHex)
+		if err != nil {
+			panic(err)
+		}
+		header := &wire.BlockHeader{}
+		if err := header.Deserialize(bytes.NewReader(headerBytes)); err != nil {
+			panic(err)
+		}
+		checkpoint.Headers[height] = header
+	}
+	return checkpoint
+}
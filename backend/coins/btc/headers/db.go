@@ -27,6 +27,11 @@ type DBTxInterface interface {
 	HeaderByHeight(height int) (*wire.BlockHeader, error)
 	PutTip(tip int) error
 	Tip() (int, error)
+	// PutBaseHeader stores a header below the base and makes its height the new base.
+	PutBaseHeader(base int, header *wire.BlockHeader) error
+	// Base returns the height from which on all headers up to the tip are stored. It is 0 unless
+	// syncing started at a checkpoint.
+	Base() (int, error)
 }
 
 // DBInterface can be implemented by database backends to open database transactions.
@@ -26,6 +26,7 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/signet"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
@@ -46,6 +47,9 @@ const (
 	EventSynced Event = "synced"
 	// EventNewTip is fired when a new tip is known.
 	EventNewTip Event = "newTip"
+	// EventReorg is fired when a reorg was detected and the chain was rolled back. The headers
+	// above the new tip are synced again, after which EventSynced is fired.
+	EventReorg Event = "reorg"
 )
 
 // Interface represents the public API of this package.
@@ -58,6 +62,12 @@ type Interface interface {
 	Status() (*Status, error)
 }
 
+// Consumer is implemented by blockchain backends which need the validated headers, e.g. to check
+// the data they fetch from untrusted peers.
+type Consumer interface {
+	SetHeaders(Interface)
+}
+
 // Headers manages syncing blockchain headers.
 type Headers struct {
 	log *logrus.Entry
@@ -74,6 +84,11 @@ type Headers struct {
 	tipAtInitTime int
 	kickChan      chan struct{}
 
+	checkpoint *Checkpoint
+	// backfillHeight is the lowest height below the base which was requested, or -1 if none.
+	backfillHeight int
+	backfillLock   locker.Locker
+
 	eventCallbacks []func(Event)
 	events         chan Event
 }
@@ -85,6 +100,9 @@ type Status struct {
 	// Only well defined if Tip >= 0
 	TipHashHex   blockchain.TXHash `json:"tipHashHex"`
 	TargetHeight int               `json:"targetHeight"`
+	// Base is the height from which on the headers are available. Headers below are downloaded
+	// when they are requested.
+	Base int `json:"base"`
 }
 
 // NewHeaders creates a new Headers instance.
@@ -105,6 +123,7 @@ func NewHeaders(
 		targetHeight:    0,
 		tipAtInitTime:   0,
 		kickChan:        make(chan struct{}, 1),
+		backfillHeight:  -1,
 
 		eventCallbacks: []func(Event){},
 		events:         make(chan Event),
@@ -129,6 +148,9 @@ func (headers *Headers) TipHeight() int {
 
 // Init starts the syncing process.
 func (headers *Headers) Init() {
+	if err := headers.applyCheckpoint(); err != nil {
+		headers.log.WithError(err).Error("Could not sync from the checkpoint")
+	}
 	headers.tipAtInitTime = headers.tip()
 	headers.log.Infof("last tip loaded: %d", headers.tipAtInitTime)
 	go headers.download()
@@ -163,43 +185,64 @@ func (headers *Headers) download() {
 				// TODO
 				panic(err)
 			}
-			batchChan := make(chan batchInfo)
-			headers.blockchain.Headers(
-				tip+1, headers.headersPerBatch,
-				func(blockHeaders []*wire.BlockHeader, max int) error {
-					batchChan <- batchInfo{blockHeaders, max}
-					return nil
-				}, func() {})
-			batch := <-batchChan
+			batch := headers.fetch(tip+1, headers.headersPerBatch)
 			if err := headers.processBatch(dbTx, tip, batch.blockHeaders, batch.max); err != nil {
 				headers.log.WithError(err).Panic("processBatch")
 			}
+			if err := headers.backfill(dbTx); err != nil {
+				headers.log.WithError(err).Error("Could not download the headers below the base")
+			}
 		}()
 	}
 }
 
+// fetch downloads a batch of headers and waits for the response.
+func (headers *Headers) fetch(startHeight int, count int) batchInfo {
+	batchChan := make(chan batchInfo)
+	headers.blockchain.Headers(
+		startHeight, count,
+		func(blockHeaders []*wire.BlockHeader, max int) error {
+			batchChan <- batchInfo{blockHeaders, max}
+			return nil
+		}, func() {})
+	return <-batchChan
+}
+
 var errPrevHash = errors.New("header prevhash does not match")
 
-func (headers *Headers) getTarget(dbTx DBTxInterface, index int) (*big.Int, error) {
+// getTarget returns the target the header at the given index must meet, following the
+// difficulty rules of the network.
+func (headers *Headers) getTarget(dbTx DBTxInterface, index int, header *wire.BlockHeader) (*big.Int, error) {
+	if headers.net.Net == chaincfg.RegressionNetParams.Net {
+		// The difficulty never changes on regtest.
+		previous, err := headers.requiredHeaderByHeight(dbTx, index-1)
+		if err != nil {
+			return nil, err
+		}
+		return btcdBlockchain.CompactToBig(previous.Bits), nil
+	}
 	targetTimespan := int64(headers.net.TargetTimespan / time.Second)
 	targetTimePerBlock := int64(headers.net.TargetTimePerBlock / time.Second)
 	blocksPerRetarget := int(targetTimespan / targetTimePerBlock)
+	if headers.net.ReduceMinDifficulty && index%blocksPerRetarget != 0 {
+		return headers.getMinDifficultyTarget(dbTx, index, header, blocksPerRetarget)
+	}
 	chunkIndex := (index / blocksPerRetarget) - 1
 	if chunkIndex == -1 {
 		return btcdBlockchain.CompactToBig(headers.net.GenesisBlock.Header.Bits), nil
 	}
 
 	firstIndex := chunkIndex * blocksPerRetarget
-	if headers.net.Net == ltc.MainNetParams.Net && chunkIndex > 0 {
+	if headers.isLitecoin() && chunkIndex > 0 {
 		// Litecoin includes the last block of the previous window to fix a time warp attack:
 		// https://litecoin.info/index.php/Time_warp_attack#cite_note-2
 		firstIndex--
 	}
-	first, err := dbTx.HeaderByHeight(firstIndex)
+	first, err := headers.requiredHeaderByHeight(dbTx, firstIndex)
 	if err != nil {
 		return nil, err
 	}
-	last, err := dbTx.HeaderByHeight((chunkIndex+1)*blocksPerRetarget - 1)
+	last, err := headers.requiredHeaderByHeight(dbTx, (chunkIndex+1)*blocksPerRetarget-1)
 	if err != nil {
 		return nil, err
 	}
@@ -221,28 +264,77 @@ func (headers *Headers) getTarget(dbTx DBTxInterface, index int) (*big.Int, erro
 	return newTarget, nil
 }
 
-func (headers *Headers) powHash(msg []byte) chainhash.Hash {
-	switch headers.net.Net {
-	case chaincfg.MainNetParams.Net:
-		return chainhash.DoubleHashH(msg)
-	case ltc.MainNetParams.Net:
-		const (
-			N = 1024
-			r = 1
-			p = 1
-		)
-		hashBytes, err := scrypt.Key(msg, msg, N, r, p, 32)
+// getMinDifficultyTarget implements the difficulty rule of testnets: if a block is not found
+// within `MinDiffReductionTime` (20 minutes on testnet3), a block with the minimum difficulty may
+// be mined. Otherwise, the target is the one of the last block which was not mined with the minimum
+// difficulty in the current retarget window.
+func (headers *Headers) getMinDifficultyTarget(
+	dbTx DBTxInterface, index int, header *wire.BlockHeader, blocksPerRetarget int) (*big.Int, error) {
+	previous, err := headers.requiredHeaderByHeight(dbTx, index-1)
+	if err != nil {
+		return nil, err
+	}
+	if header.Timestamp.After(previous.Timestamp.Add(headers.net.MinDiffReductionTime)) {
+		return new(big.Int).Set(headers.net.PowLimit), nil
+	}
+	height := index - 1
+	for height%blocksPerRetarget != 0 && previous.Bits == headers.net.PowLimitBits {
+		height--
+		previous, err = headers.requiredHeaderByHeight(dbTx, height)
 		if err != nil {
-			panic(errp.WithStack(err))
+			return nil, err
 		}
-		hash := chainhash.Hash{}
-		if err := hash.SetBytes(hashBytes); err != nil {
-			panic(errp.WithStack(err))
-		}
-		return hash
-	default:
-		panic("unsupported coin")
 	}
+	return btcdBlockchain.CompactToBig(previous.Bits), nil
+}
+
+// requiredHeaderByHeight is like headerByHeight, but fails if the header is not available.
+func (headers *Headers) requiredHeaderByHeight(
+	dbTx DBTxInterface, height int) (*wire.BlockHeader, error) {
+	header, err := headers.headerByHeight(dbTx, height)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, errp.Newf("header %d is needed to compute the difficulty, but not available", height)
+	}
+	return header, nil
+}
+
+// isLitecoin returns true if the headers are Litecoin headers, which use scrypt as the proof of
+// work function.
+func (headers *Headers) isLitecoin() bool {
+	return headers.net.Net == ltc.MainNetParams.Net || headers.net.Net == ltc.TestNet4Params.Net
+}
+
+// checkPoW returns true if the difficulty and proof of work of the headers are validated.
+func (headers *Headers) checkPoW() bool {
+	switch headers.net.Net {
+	case chaincfg.MainNetParams.Net, chaincfg.TestNet3Params.Net, chaincfg.RegressionNetParams.Net,
+		ltc.MainNetParams.Net, ltc.TestNet4Params.Net:
+		return true
+	}
+	return signet.IsSignet(headers.net)
+}
+
+func (headers *Headers) powHash(msg []byte) chainhash.Hash {
+	if !headers.isLitecoin() {
+		return chainhash.DoubleHashH(msg)
+	}
+	const (
+		N = 1024
+		r = 1
+		p = 1
+	)
+	hashBytes, err := scrypt.Key(msg, msg, N, r, p, 32)
+	if err != nil {
+		panic(errp.WithStack(err))
+	}
+	hash := chainhash.Hash{}
+	if err := hash.SetBytes(hashBytes); err != nil {
+		panic(errp.WithStack(err))
+	}
+	return hash
 }
 
 func (headers *Headers) canConnect(dbTx DBTxInterface, tip int, header *wire.BlockHeader) error {
@@ -263,17 +355,31 @@ func (headers *Headers) canConnect(dbTx DBTxInterface, tip int, header *wire.Blo
 					header.PrevBlock, tip, prevBlock, tip-1))
 		}
 
-		lastCheckpoint := headers.net.Checkpoints[len(headers.net.Checkpoints)-1]
-		if tip == int(lastCheckpoint.Height) {
-			if *lastCheckpoint.Hash != header.BlockHash() {
-				return errp.Newf("checkpoint mismatch at %d. Expected %s, got %s",
-					tip, lastCheckpoint.Hash, header.BlockHash())
+		if headers.checkpoint != nil && tip == headers.checkpoint.Height &&
+			header.BlockHash() != headers.checkpoint.Hash {
+			return errp.Newf("checkpoint mismatch at %d. Expected %s, got %s",
+				tip, headers.checkpoint.Hash, header.BlockHash())
+		}
+
+		// Networks without checkpoints, e.g. custom signets, are validated from the genesis block.
+		lastCheckpointHeight := 0
+		if len(headers.net.Checkpoints) > 0 {
+			lastCheckpoint := headers.net.Checkpoints[len(headers.net.Checkpoints)-1]
+			lastCheckpointHeight = int(lastCheckpoint.Height)
+			if tip == lastCheckpointHeight {
+				if *lastCheckpoint.Hash != header.BlockHash() {
+					return errp.Newf("checkpoint mismatch at %d. Expected %s, got %s",
+						tip, lastCheckpoint.Hash, header.BlockHash())
+				}
+				headers.log.Infof("checkpoint at %d matches", tip)
 			}
-			headers.log.Infof("checkpoint at %d matches", tip)
 		}
-		// Check Difficulty, PoW.
-		if headers.net.Net == chaincfg.MainNetParams.Net || headers.net.Net == ltc.MainNetParams.Net {
-			newTarget, err := headers.getTarget(dbTx, tip)
+		// Check Difficulty, PoW. Signets follow the difficulty rules of mainnet. The signet block
+		// solution (BIP325) is not checked, as it is part of the coinbase transaction, which is not
+		// available when syncing headers. A signet header is thus only as trustworthy as its proof
+		// of work, which is low.
+		if headers.checkPoW() {
+			newTarget, err := headers.getTarget(dbTx, tip, header)
 			if err != nil {
 				return err
 			}
@@ -285,7 +391,7 @@ func (headers *Headers) canConnect(dbTx DBTxInterface, tip int, header *wire.Blo
 				panic(errp.WithStack(err))
 			}
 			// Skip PoW check before the checkpoint for performance.
-			if tip > int(lastCheckpoint.Height) {
+			if tip > lastCheckpointHeight {
 				powHash := headers.powHash(headerSerialized.Bytes())
 				proofOfWork := btcdBlockchain.HashToBig(&powHash)
 				if proofOfWork.Cmp(newTarget) > 0 {
@@ -312,10 +418,23 @@ func (headers *Headers) reorg(dbTx DBTxInterface, tip int) {
 	if newTip < -1 {
 		newTip = -1
 	}
+	// The chain can't be reorganized below the checkpoint or the base, from which on we synced.
+	if headers.checkpoint != nil && tip >= headers.checkpoint.Height &&
+		newTip < headers.checkpoint.Height {
+		newTip = headers.checkpoint.Height
+	}
+	base, err := dbTx.Base()
+	if err != nil {
+		panic(err)
+	}
+	if base > 0 && newTip < base {
+		newTip = base
+	}
 	if err := dbTx.PutTip(newTip); err != nil {
 		panic(err)
 	}
 	headers.kick()
+	headers.notifyEvent(EventReorg)
 }
 
 func (headers *Headers) notifyEvent(event Event) {
@@ -357,7 +476,8 @@ func (headers *Headers) processBatch(
 }
 
 // HeaderByHeight returns the header at the given height. Returns nil if the headers are not synced
-// up to this height yet.
+// up to this height yet. If the height is below the base, nil is returned and the missing headers
+// are downloaded in the background, after which EventSynced is fired.
 func (headers *Headers) HeaderByHeight(height int) (*wire.BlockHeader, error) {
 	defer headers.lock.RLock()()
 	dbTx, err := headers.db.Begin()
@@ -365,6 +485,14 @@ func (headers *Headers) HeaderByHeight(height int) (*wire.BlockHeader, error) {
 		return nil, err
 	}
 	defer dbTx.Rollback()
+	base, err := dbTx.Base()
+	if err != nil {
+		return nil, err
+	}
+	if height >= 0 && height < base {
+		headers.requestBackfill(height)
+		return nil, nil
+	}
 	return dbTx.HeaderByHeight(height)
 }
 
@@ -418,7 +546,12 @@ func (headers *Headers) Status() (*Status, error) {
 	if header != nil {
 		tipHashHex = blockchain.TXHash(header.BlockHash())
 	}
+	base, err := dbTx.Base()
+	if err != nil {
+		return nil, err
+	}
 	return &Status{
+		Base:          base,
 		TipAtInitTime: headers.tipAtInitTime,
 		Tip:           tip,
 		TargetHeight:  headers.targetHeight,
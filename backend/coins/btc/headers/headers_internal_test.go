@@ -0,0 +1,359 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package headers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	btcdBlockchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/signet"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+)
+
+// memoryDBTx is an in-memory DBTxInterface.
+type memoryDBTx struct {
+	headers map[int]*wire.BlockHeader
+	tip     int
+	base    int
+}
+
+func newMemoryDBTx() *memoryDBTx {
+	return &memoryDBTx{headers: map[int]*wire.BlockHeader{}, tip: -1}
+}
+
+func (tx *memoryDBTx) Commit() error { return nil }
+func (tx *memoryDBTx) Rollback()     {}
+func (tx *memoryDBTx) PutHeader(tip int, header *wire.BlockHeader) error {
+	tx.headers[tip] = header
+	tx.tip = tip
+	return nil
+}
+func (tx *memoryDBTx) HeaderByHeight(height int) (*wire.BlockHeader, error) {
+	return tx.headers[height], nil
+}
+func (tx *memoryDBTx) PutTip(tip int) error {
+	tx.tip = tip
+	return nil
+}
+func (tx *memoryDBTx) Tip() (int, error) { return tx.tip, nil }
+func (tx *memoryDBTx) PutBaseHeader(base int, header *wire.BlockHeader) error {
+	tx.headers[base] = header
+	tx.base = base
+	return nil
+}
+func (tx *memoryDBTx) Base() (int, error) { return tx.base, nil }
+
+// memoryDB is an in-memory DBInterface, whose transactions all operate on the same data.
+type memoryDB struct {
+	tx *memoryDBTx
+}
+
+func (db *memoryDB) Begin() (DBTxInterface, error) { return db.tx, nil }
+
+// signetBlock1 is a block on top of the signet genesis block with sufficient proof of work.
+func signetBlock1(net *chaincfg.Params) *wire.BlockHeader {
+	return &wire.BlockHeader{
+		Version:    0x20000000,
+		PrevBlock:  *net.GenesisHash,
+		MerkleRoot: chainhash.DoubleHashH([]byte("signet test block")),
+		Timestamp:  time.Unix(1598918400+600, 0),
+		Bits:       0x1e0377ae,
+		Nonce:      1015156,
+	}
+}
+
+func TestSignetCanConnect(t *testing.T) {
+	for _, net := range []*chaincfg.Params{signet.DefaultParams, signet.NewParams([]byte{0x51})} {
+		headers := NewHeaders(net, nil, nil, logging.Get().WithGroup("headers_test"))
+		dbTx := newMemoryDBTx()
+		require.NoError(t, headers.canConnect(dbTx, 0, &net.GenesisBlock.Header))
+		require.NoError(t, dbTx.PutHeader(0, &net.GenesisBlock.Header))
+
+		block1 := signetBlock1(net)
+		require.Equal(t,
+			"00000031aa96a44c86a765633f3e8f1191a59dd4d55682cea804e3217ae5989f",
+			block1.BlockHash().String())
+		require.NoError(t, headers.canConnect(dbTx, 1, block1))
+
+		insufficientPoW := signetBlock1(net)
+		insufficientPoW.Nonce++
+		require.Error(t, headers.canConnect(dbTx, 1, insufficientPoW))
+
+		wrongDifficulty := signetBlock1(net)
+		wrongDifficulty.Bits = 0x1d00ffff
+		require.Error(t, headers.canConnect(dbTx, 1, wrongDifficulty))
+
+		wrongGenesis := chaincfg.MainNetParams.GenesisBlock.Header
+		require.Error(t, headers.canConnect(newMemoryDBTx(), 0, &wrongGenesis))
+	}
+}
+
+func TestTestnet3CanConnect(t *testing.T) {
+	// Without checkpoints, so that the proof of work of the first blocks is checked.
+	net := chaincfg.TestNet3Params
+	net.Checkpoints = nil
+	headers := NewHeaders(&net, nil, nil, logging.Get().WithGroup("headers_test"))
+	require.True(t, headers.checkPoW())
+	dbTx := newMemoryDBTx()
+	require.NoError(t, headers.canConnect(dbTx, 0, &net.GenesisBlock.Header))
+	require.NoError(t, dbTx.PutHeader(0, &net.GenesisBlock.Header))
+
+	// Block 1 of testnet3.
+	merkleRoot, err := chainhash.NewHashFromStr(
+		"f0315ffc38709d70ad5647e22048358dd3745f3ce3874223c80a7c92fab0c8ba")
+	require.NoError(t, err)
+	block1 := func() *wire.BlockHeader {
+		return &wire.BlockHeader{
+			Version:    1,
+			PrevBlock:  *net.GenesisHash,
+			MerkleRoot: *merkleRoot,
+			Timestamp:  time.Unix(1296688928, 0),
+			Bits:       0x1d00ffff,
+			Nonce:      1924588547,
+		}
+	}
+	require.Equal(t,
+		"00000000b873e79784647a6c82962c70d228557d24a747ea4d1b8bbe878e1206",
+		block1().BlockHash().String())
+	require.NoError(t, headers.canConnect(dbTx, 1, block1()))
+
+	insufficientPoW := block1()
+	insufficientPoW.Nonce++
+	require.Error(t, headers.canConnect(dbTx, 1, insufficientPoW))
+}
+
+// readHeaders reads hex encoded headers, one per line.
+func readHeaders(t *testing.T, filename string) []*wire.BlockHeader {
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	headers := []*wire.BlockHeader{}
+	for _, line := range strings.Fields(string(content)) {
+		headerBytes, err := hex.DecodeString(line)
+		require.NoError(t, err)
+		header := &wire.BlockHeader{}
+		require.NoError(t, header.Deserialize(bytes.NewReader(headerBytes)))
+		headers = append(headers, header)
+	}
+	return headers
+}
+
+// recordedNets are the networks of the recorded headers in testdata, by the prefix of the file
+// names.
+var recordedNets = map[string]*chaincfg.Params{
+	"mainnet":  &chaincfg.MainNetParams,
+	"testnet3": &chaincfg.TestNet3Params,
+	"ltc":      &ltc.MainNetParams,
+	"tltc":     &ltc.TestNet4Params,
+}
+
+// TestRecordedHeaders verifies the headers recorded from real networks in
+// testdata/<net>-<from>-<to>.hex, which can be dumped from a node with cmd/checkpoint. A recording
+// starting at height 1 is verified from the genesis block. Otherwise, it must start with a retarget
+// window (Litecoin: the last header of the previous window), which is trusted like a checkpoint,
+// and the headers following the window are verified.
+func TestRecordedHeaders(t *testing.T) {
+	filenames, err := filepath.Glob("testdata/*-*-*.hex")
+	require.NoError(t, err)
+	require.NotEmpty(t, filenames)
+	for _, filename := range filenames {
+		parts := strings.Split(strings.TrimSuffix(filepath.Base(filename), ".hex"), "-")
+		require.Len(t, parts, 3, filename)
+		recordedNet, ok := recordedNets[parts[0]]
+		require.True(t, ok, filename)
+		from, err := strconv.Atoi(parts[1])
+		require.NoError(t, err, filename)
+		to, err := strconv.Atoi(parts[2])
+		require.NoError(t, err, filename)
+		chain := readHeaders(t, filename)
+		require.Len(t, chain, to-from+1, filename)
+
+		// Without checkpoints, so that the proof of work of all headers is checked.
+		net := *recordedNet
+		net.Checkpoints = nil
+		headers := NewHeaders(&net, nil, nil, logging.Get().WithGroup("headers_test"))
+		dbTx := newMemoryDBTx()
+		verifiedFrom := from
+		if from == 1 {
+			require.NoError(t, headers.canConnect(dbTx, 0, &net.GenesisBlock.Header))
+			require.NoError(t, dbTx.PutHeader(0, &net.GenesisBlock.Header))
+		} else {
+			blocksPerRetarget := int(net.TargetTimespan / net.TargetTimePerBlock)
+			windowStart := from
+			if headers.isLitecoin() {
+				windowStart++
+			}
+			require.Zero(t, windowStart%blocksPerRetarget, filename)
+			verifiedFrom = windowStart + blocksPerRetarget
+			require.True(t, verifiedFrom <= to, filename)
+			for height := from; height < verifiedFrom; height++ {
+				require.NoError(t, dbTx.PutHeader(height, chain[height-from]))
+			}
+		}
+		for height := verifiedFrom; height <= to; height++ {
+			header := chain[height-from]
+			insufficientPoW := *header
+			insufficientPoW.Nonce++
+			require.Error(t, headers.canConnect(dbTx, height, &insufficientPoW), filename)
+			wrongDifficulty := *header
+			wrongDifficulty.Bits = 0x1c00ffff
+			require.Error(t, headers.canConnect(dbTx, height, &wrongDifficulty), filename)
+
+			require.NoError(t, headers.canConnect(dbTx, height, header), "%s: %d", filename, height)
+			require.NoError(t, dbTx.PutHeader(height, header))
+		}
+	}
+}
+
+func TestMainnetRecording(t *testing.T) {
+	// Blocks 1 to 255 of mainnet, from the test data of btcd (database/testdata).
+	chain := readHeaders(t, "testdata/mainnet-1-255.hex")
+	require.Equal(t,
+		"00000000d0a75c861fabf9ff7b92022f60e4afeed9331fe5aa073d8e4706fe3c",
+		chain[254].BlockHash().String())
+}
+
+func TestMinDifficulty(t *testing.T) {
+	net := &chaincfg.TestNet3Params
+	headers := NewHeaders(net, nil, nil, logging.Get().WithGroup("headers_test"))
+	const bits = 0x1b0404cb
+	start := time.Unix(1500000000, 0)
+	dbTx := newMemoryDBTx()
+	require.NoError(t, dbTx.PutHeader(2016, &wire.BlockHeader{Timestamp: start, Bits: bits}))
+	for height := 2017; height < 2020; height++ {
+		require.NoError(t, dbTx.PutHeader(height, &wire.BlockHeader{
+			Timestamp: start.Add(time.Duration(height-2016) * time.Minute),
+			Bits:      net.PowLimitBits,
+		}))
+	}
+	previousTimestamp := start.Add(3 * time.Minute)
+
+	// A block found more than 20 minutes after the previous one can have the minimum difficulty.
+	target, err := headers.getTarget(dbTx, 2020, &wire.BlockHeader{
+		Timestamp: previousTimestamp.Add(20*time.Minute + time.Second)})
+	require.NoError(t, err)
+	require.Equal(t, net.PowLimit, target)
+
+	// Otherwise, the difficulty is the one of the last block without the minimum difficulty.
+	target, err = headers.getTarget(dbTx, 2020, &wire.BlockHeader{
+		Timestamp: previousTimestamp.Add(20 * time.Minute)})
+	require.NoError(t, err)
+	require.Equal(t, uint32(bits), btcdBlockchain.BigToCompact(target))
+
+	// The search for the last block without the minimum difficulty stops at the start of the
+	// retarget window.
+	require.NoError(t, dbTx.PutHeader(2016, &wire.BlockHeader{
+		Timestamp: start, Bits: net.PowLimitBits}))
+	require.NoError(t, dbTx.PutHeader(2015, &wire.BlockHeader{Bits: bits}))
+	target, err = headers.getTarget(dbTx, 2020, &wire.BlockHeader{Timestamp: previousTimestamp})
+	require.NoError(t, err)
+	require.Equal(t, net.PowLimitBits, btcdBlockchain.BigToCompact(target))
+
+	// The minimum difficulty rule does not apply to the first block of a retarget window.
+	require.NoError(t, dbTx.PutHeader(4031, &wire.BlockHeader{
+		Timestamp: start.Add(14 * 24 * time.Hour), Bits: bits}))
+	target, err = headers.getTarget(dbTx, 4032, &wire.BlockHeader{
+		Timestamp: start.Add(15 * 24 * time.Hour)})
+	require.NoError(t, err)
+	require.Equal(t, uint32(bits), btcdBlockchain.BigToCompact(target))
+
+	// Litecoin testnet4 allows the minimum difficulty after five minutes.
+	ltcHeaders := NewHeaders(&ltc.TestNet4Params, nil, nil, logging.Get().WithGroup("headers_test"))
+	ltcDBTx := newMemoryDBTx()
+	require.NoError(t, ltcDBTx.PutHeader(2016, &wire.BlockHeader{Timestamp: start, Bits: bits}))
+	target, err = ltcHeaders.getTarget(ltcDBTx, 2017, &wire.BlockHeader{
+		Timestamp: start.Add(5*time.Minute + time.Second)})
+	require.NoError(t, err)
+	require.Equal(t, ltc.TestNet4Params.PowLimit, target)
+	target, err = ltcHeaders.getTarget(ltcDBTx, 2017, &wire.BlockHeader{
+		Timestamp: start.Add(5 * time.Minute)})
+	require.NoError(t, err)
+	require.Equal(t, uint32(bits), btcdBlockchain.BigToCompact(target))
+
+	// The headers needed to compute the difficulty must be available.
+	_, err = headers.getTarget(newMemoryDBTx(), 2020, &wire.BlockHeader{Timestamp: start})
+	require.Error(t, err)
+}
+
+func TestRegtestCanConnect(t *testing.T) {
+	net := &chaincfg.RegressionNetParams
+	headers := NewHeaders(net, nil, nil, logging.Get().WithGroup("headers_test"))
+	require.True(t, headers.checkPoW())
+	chain := regtestChain(10)
+	dbTx := newMemoryDBTx()
+	for height, header := range chain {
+		require.NoError(t, headers.canConnect(dbTx, height, header))
+		require.NoError(t, dbTx.PutHeader(height, header))
+	}
+
+	next := &wire.BlockHeader{
+		Version:   0x20000000,
+		PrevBlock: chain[9].BlockHash(),
+		Timestamp: chain[9].Timestamp.Add(10 * time.Minute),
+		Bits:      net.PowLimitBits,
+	}
+	mine(next)
+	require.NoError(t, headers.canConnect(dbTx, 10, next))
+	insufficientPoW := *next
+	for {
+		insufficientPoW.Nonce++
+		hash := insufficientPoW.BlockHash()
+		if btcdBlockchain.HashToBig(&hash).Cmp(net.PowLimit) > 0 {
+			break
+		}
+	}
+	require.Error(t, headers.canConnect(dbTx, 10, &insufficientPoW))
+
+	// The difficulty never changes, not even at the end of a retarget window.
+	require.NoError(t, dbTx.PutHeader(2015, chain[9]))
+	target, err := headers.getTarget(dbTx, 2016, &wire.BlockHeader{Timestamp: time.Unix(0, 0)})
+	require.NoError(t, err)
+	require.Equal(t, net.PowLimitBits, btcdBlockchain.BigToCompact(target))
+}
+
+func TestLitecoinPoW(t *testing.T) {
+	// The genesis blocks of Litecoin are real headers with a scrypt proof of work.
+	for _, net := range []*chaincfg.Params{&ltc.MainNetParams, &ltc.TestNet4Params} {
+		headers := NewHeaders(net, nil, nil, logging.Get().WithGroup("headers_test"))
+		require.True(t, headers.checkPoW())
+		header := net.GenesisBlock.Header
+		serialized := &bytes.Buffer{}
+		require.NoError(t, header.BtcEncode(serialized, 0, wire.BaseEncoding))
+		powHash := headers.powHash(serialized.Bytes())
+		target := btcdBlockchain.CompactToBig(header.Bits)
+		require.True(t, btcdBlockchain.HashToBig(&powHash).Cmp(target) <= 0)
+		// The block hash is not the proof of work hash.
+		blockHash := header.BlockHash()
+		require.True(t, btcdBlockchain.HashToBig(&blockHash).Cmp(target) > 0)
+
+		header.Nonce++
+		serialized.Reset()
+		require.NoError(t, header.BtcEncode(serialized, 0, wire.BaseEncoding))
+		powHash = headers.powHash(serialized.Bytes())
+		require.True(t, btcdBlockchain.HashToBig(&powHash).Cmp(target) > 0)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package neutrino
+
+import (
+	"sort"
+
+	btcdBlockchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// feeBlocks is the number of recent blocks from which fees are estimated.
+const feeBlocks = 6
+
+// blockFeeRate returns the average fee rate in sat/kB of the transactions of the block at the given
+// height. The mempool is not available to this client, and the inputs of the transactions are not
+// known, so the fees are derived from the amount collected by the coinbase transaction.
+func blockFeeRate(block *wire.MsgBlock, height int, net *chaincfg.Params) btcutil.Amount {
+	if len(block.Transactions) < 2 {
+		return 0
+	}
+	collected := int64(0)
+	for _, txOut := range block.Transactions[0].TxOut {
+		collected += txOut.Value
+	}
+	fees := collected - btcdBlockchain.CalcBlockSubsidy(int32(height), net)
+	weight := int64(0)
+	for _, tx := range block.Transactions[1:] {
+		weight += btcdBlockchain.GetTransactionWeight(btcutil.NewTx(tx))
+	}
+	vsize := (weight + btcdBlockchain.WitnessScaleFactor - 1) / btcdBlockchain.WitnessScaleFactor
+	if fees <= 0 {
+		return 0
+	}
+	return btcutil.Amount(fees * 1000 / vsize)
+}
+
+// recentFeeRates returns the average fee rates of the last feeBlocks blocks, highest first. The fee
+// rates are cached by block, so that only new blocks are downloaded.
+func (client *Client) recentFeeRates() ([]btcutil.Amount, error) {
+	defer client.feeLock.Lock()()
+	headersInstance := func() headers.Interface {
+		defer client.lock.RLock()()
+		return client.headers
+	}()
+	if headersInstance == nil {
+		return nil, &connectionError{errp.New("The headers are not synced yet")}
+	}
+	status, err := headersInstance.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status.Tip < 0 {
+		return nil, &connectionError{errp.New("The headers are not synced yet")}
+	}
+	from := status.Tip - feeBlocks + 1
+	if from < 0 {
+		from = 0
+	}
+	hashes, err := blockHashes(headersInstance, from, status.Tip)
+	if err != nil {
+		return nil, err
+	}
+	feeRates := map[chainhash.Hash]btcutil.Amount{}
+	for index, hash := range hashes {
+		if feeRate, ok := client.feeRates[hash]; ok {
+			feeRates[hash] = feeRate
+			continue
+		}
+		peers := client.connectedPeers()
+		if len(peers) == 0 {
+			return nil, &connectionError{errp.New("No peer connected")}
+		}
+		block, err := getBlock(peers[0], hash)
+		if err != nil {
+			return nil, err
+		}
+		feeRates[hash] = blockFeeRate(block, from+index, client.net)
+	}
+	client.feeRates = feeRates
+	result := make([]btcutil.Amount, 0, len(feeRates))
+	for _, feeRate := range feeRates {
+		result = append(result, feeRate)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] > result[j] })
+	return result, nil
+}
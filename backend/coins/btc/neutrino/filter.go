@@ -0,0 +1,132 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package neutrino
+
+import (
+	"bytes"
+	"io"
+	"math/bits"
+
+	"github.com/aead/siphash"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// filterP is the Golomb-Rice coding parameter of basic filters (BIP158).
+	filterP = 19
+	// filterM is the inverse false positive rate of basic filters (BIP158).
+	filterM = 784931
+)
+
+// filter is a decoded BIP158 basic block filter.
+type filter struct {
+	n    uint64
+	key  [16]byte
+	data []byte
+}
+
+// parseFilter parses a serialized basic filter of the block with the given hash.
+func parseFilter(blockHash chainhash.Hash, serialized []byte) (*filter, error) {
+	reader := bytes.NewReader(serialized)
+	n, err := wire.ReadVarInt(reader, 0)
+	if err != nil {
+		return nil, errp.Wrap(err, "Invalid filter")
+	}
+	result := &filter{
+		n:    n,
+		data: serialized[len(serialized)-reader.Len():],
+	}
+	copy(result.key[:], blockHash[:16])
+	return result, nil
+}
+
+// hashItem maps an item to the range [0, N*M) as specified in BIP158.
+func (filter *filter) hashItem(item []byte) uint64 {
+	hi, _ := bits.Mul64(siphash.Sum64(item, &filter.key), filter.n*filterM)
+	return hi
+}
+
+// matchAny returns true if at least one of the items is in the filter. False positives happen
+// with a probability of 1/M per item.
+func (filter *filter) matchAny(items [][]byte) (bool, error) {
+	if filter.n == 0 || len(items) == 0 {
+		return false, nil
+	}
+	hashes := make(map[uint64]struct{}, len(items))
+	for _, item := range items {
+		hashes[filter.hashItem(item)] = struct{}{}
+	}
+	reader := &bitReader{data: filter.data}
+	var value uint64
+	for i := uint64(0); i < filter.n; i++ {
+		delta, err := reader.readGolombRice()
+		if err != nil {
+			return false, errp.Wrap(err, "Invalid filter")
+		}
+		value += delta
+		if _, ok := hashes[value]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// bitReader reads bits from a byte slice, most significant bit first.
+type bitReader struct {
+	data []byte
+	// position is the index of the next bit.
+	position int
+}
+
+func (reader *bitReader) readBit() (uint64, error) {
+	if reader.position >= len(reader.data)*8 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	bit := reader.data[reader.position/8] >> (7 - uint(reader.position%8)) & 1
+	reader.position++
+	return uint64(bit), nil
+}
+
+func (reader *bitReader) readGolombRice() (uint64, error) {
+	var quotient uint64
+	for {
+		bit, err := reader.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		quotient++
+	}
+	var remainder uint64
+	for i := 0; i < filterP; i++ {
+		bit, err := reader.readBit()
+		if err != nil {
+			return 0, err
+		}
+		remainder = remainder<<1 | bit
+	}
+	return quotient<<filterP | remainder, nil
+}
+
+// filterHeader computes the header of a filter, which commits to the filter and all previous
+// filters, from the filter hash and the header of the previous block's filter.
+func filterHeader(filterHash chainhash.Hash, previousHeader chainhash.Hash) chainhash.Hash {
+	return chainhash.DoubleHashH(append(filterHash[:], previousHeader[:]...))
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package neutrino
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+// bitWriter writes bits to a byte slice, most significant bit first.
+type bitWriter struct {
+	data []byte
+	// position is the index of the next bit.
+	position int
+}
+
+func (writer *bitWriter) writeBit(bit uint64) {
+	if writer.position%8 == 0 {
+		writer.data = append(writer.data, 0)
+	}
+	if bit != 0 {
+		writer.data[writer.position/8] |= 1 << (7 - uint(writer.position%8))
+	}
+	writer.position++
+}
+
+// buildFilter builds the serialized basic filter (BIP158) of the items for the given block.
+func buildFilter(blockHash chainhash.Hash, items [][]byte) []byte {
+	unique := map[string]struct{}{}
+	for _, item := range items {
+		unique[string(item)] = struct{}{}
+	}
+	result := &filter{n: uint64(len(unique))}
+	copy(result.key[:], blockHash[:16])
+	values := []uint64{}
+	for item := range unique {
+		values = append(values, result.hashItem([]byte(item)))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	writer := &bitWriter{}
+	var last uint64
+	for _, value := range values {
+		delta := value - last
+		last = value
+		for quotient := delta >> filterP; quotient > 0; quotient-- {
+			writer.writeBit(1)
+		}
+		writer.writeBit(0)
+		for i := filterP - 1; i >= 0; i-- {
+			writer.writeBit(delta >> uint(i) & 1)
+		}
+	}
+	serialized := &bytes.Buffer{}
+	if err := wire.WriteVarInt(serialized, 0, result.n); err != nil {
+		panic(err)
+	}
+	serialized.Write(writer.data)
+	return serialized.Bytes()
+}
+
+// TestGenesisFilter checks the filter of the testnet genesis block, from the BIP158 test vectors.
+func TestGenesisFilter(t *testing.T) {
+	genesis := chaincfg.TestNet3Params.GenesisBlock
+	blockHash := genesis.BlockHash()
+	serialized, err := hex.DecodeString("019dfca8")
+	require.NoError(t, err)
+
+	blockFilter, err := parseFilter(blockHash, serialized)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), blockFilter.n)
+	pkScript := genesis.Transactions[0].TxOut[0].PkScript
+	match, err := blockFilter.matchAny([][]byte{{0x51}, pkScript})
+	require.NoError(t, err)
+	require.True(t, match)
+	match, err = blockFilter.matchAny([][]byte{{0x51}})
+	require.NoError(t, err)
+	require.False(t, match)
+
+	require.Equal(t, serialized, buildFilter(blockHash, [][]byte{pkScript}))
+
+	expectedHeader, err := chainhash.NewHashFromStr(
+		"21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750")
+	require.NoError(t, err)
+	require.Equal(t, *expectedHeader,
+		filterHeader(chainhash.DoubleHashH(serialized), chainhash.Hash{}))
+}
+
+func TestFilterMatch(t *testing.T) {
+	blockHash := chainhash.DoubleHashH([]byte("block"))
+	items := [][]byte{}
+	for i := 0; i < 100; i++ {
+		items = append(items, chainhash.HashB([]byte{byte(i)}))
+	}
+	blockFilter, err := parseFilter(blockHash, buildFilter(blockHash, items))
+	require.NoError(t, err)
+	for _, item := range items {
+		match, err := blockFilter.matchAny([][]byte{item})
+		require.NoError(t, err)
+		require.True(t, match)
+	}
+	match, err := blockFilter.matchAny([][]byte{[]byte("other"), []byte("items")})
+	require.NoError(t, err)
+	require.False(t, match)
+
+	emptyFilter, err := parseFilter(blockHash, buildFilter(blockHash, nil))
+	require.NoError(t, err)
+	match, err = emptyFilter.matchAny(items)
+	require.NoError(t, err)
+	require.False(t, match)
+
+	// Truncated filter data is an error, unless the item is matched before the end.
+	serialized := buildFilter(blockHash, items)
+	truncated, err := parseFilter(blockHash, serialized[:len(serialized)/2])
+	require.NoError(t, err)
+	_, err = truncated.matchAny([][]byte{[]byte("other")})
+	require.Error(t, err)
+}
@@ -0,0 +1,1152 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package neutrino implements blockchain.Interface as a compact block filter light client (BIP157,
+// BIP158), which does not send the scripts of the accounts to anyone.
+//
+// Filter headers are synced from full nodes over the P2P protocol and cross-checked between the
+// configured peers. The scripts of the accounts are matched against the filter of each block, and
+// only the matching blocks are downloaded, from which the address histories are built locally.
+// Block headers are validated by headers.Headers, to which this client serves the headers from its
+// peers. Unconfirmed transactions are not tracked, except for the ones broadcast by this client.
+package neutrino
+
+import (
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/signet"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+)
+
+// pollInterval is the interval in which the peers are asked for new blocks, in addition to the
+// blocks they announce.
+var pollInterval = 30 * time.Second
+
+const (
+	// minRelayFee is the relay fee in sat/kB assumed if no peer announced one.
+	minRelayFee = 1000
+	// locatorConsecutive is the number of consecutive block hashes at the start of a block
+	// locator, after which the distance between the hashes doubles.
+	locatorConsecutive = 10
+)
+
+// Config holds the configuration of the client.
+type Config struct {
+	// Peers are the addresses (host:port) of full nodes serving compact block filters, e.g. Bitcoin
+	// Core with -blockfilterindex=1 and -peerblockfilters=1.
+	Peers []string `json:"peers"`
+	// StartHeight is the height from which the blocks are scanned for transactions of the accounts.
+	// It should be a height before the first transaction of the wallet.
+	StartHeight int `json:"startHeight"`
+}
+
+// connectionError is an error caused by an unreachable or misbehaving peer. Requests failing with
+// such an error are retried.
+type connectionError struct {
+	error
+}
+
+type subscription struct {
+	callback func(string) error
+	// status is the last status passed to the callback.
+	status string
+	// pending are the teardown functions of subscriptions which were not answered yet.
+	pending []func()
+}
+
+type headersSubscription struct {
+	callback func(*blockchain.Header) error
+	pending  func()
+}
+
+// transaction is a transaction of the accounts. Height is 0 for unconfirmed transactions.
+type transaction struct {
+	tx     *wire.MsgTx
+	height int
+	// merkle and pos prove the inclusion of a confirmed transaction in its block.
+	merkle []blockchain.TXHash
+	pos    int
+}
+
+// Client is a blockchain.Interface backed by compact block filters.
+type Client struct {
+	config     *Config
+	net        *chaincfg.Params
+	socksProxy *socksproxy.SocksProxy
+
+	lock    locker.Locker
+	headers headers.Interface
+	peers   map[string]*peer
+	// scripts are the pubkey scripts of the accounts by script hash. importQueue holds the ones
+	// which have not been scanned for yet.
+	scripts      map[blockchain.ScriptHashHex][]byte
+	importQueue  [][]byte
+	transactions map[chainhash.Hash]*transaction
+	histories    map[blockchain.ScriptHashHex]blockchain.TxHistory
+
+	subscriptions       map[blockchain.ScriptHashHex]*subscription
+	headersSubscription []*headersSubscription
+	tip                 int
+	// headersStalled is set if headers were requested before their block locator was known.
+	headersStalled bool
+	// locator is a block locator of the headers tip at locatorHeight, and locatorHeights are the
+	// heights of its hashes. lastHeaderHeight and lastHeaderHash identify the last header served
+	// by Headers.
+	locator          []chainhash.Hash
+	locatorHeights   []int
+	locatorHeight    int
+	lastHeaderHeight int
+	lastHeaderHash   chainhash.Hash
+
+	// The following fields are only accessed by the sync goroutine.
+	// filterHeaders are the verified filter headers, starting with the one at filterHeadersBase.
+	// The first one is the header of the block before StartHeight, which is taken from the peers
+	// (zero if StartHeight is 0). filterBlockHashes are the hashes of the corresponding blocks.
+	filterHeadersBase int
+	filterHeaders     []chainhash.Hash
+	filterBlockHashes []chainhash.Hash
+	scannedHeight     int
+
+	// feeLock serializes the fee estimations, so that the recent blocks are downloaded only once.
+	// feeRates are the average fee rates of the recent blocks by block hash.
+	feeLock  locker.Locker
+	feeRates map[chainhash.Hash]btcutil.Amount
+
+	status                   blockchain.Status
+	onConnectionStatusChange []func(blockchain.Status)
+
+	wakeChan  chan struct{}
+	closeChan chan struct{}
+	closed    bool
+
+	log *logrus.Entry
+}
+
+// NewClient creates a new client. Syncing starts once the headers are set with SetHeaders.
+func NewClient(
+	config *Config,
+	net *chaincfg.Params,
+	socksProxy *socksproxy.SocksProxy,
+	log *logrus.Entry,
+) *Client {
+	startHeight := config.StartHeight
+	if startHeight < 0 {
+		startHeight = 0
+	}
+	client := &Client{
+		config:            config,
+		net:               net,
+		socksProxy:        socksProxy,
+		peers:             map[string]*peer{},
+		scripts:           map[blockchain.ScriptHashHex][]byte{},
+		transactions:      map[chainhash.Hash]*transaction{},
+		histories:         map[blockchain.ScriptHashHex]blockchain.TxHistory{},
+		subscriptions:     map[blockchain.ScriptHashHex]*subscription{},
+		tip:               -1,
+		locatorHeight:     -1,
+		lastHeaderHeight:  -1,
+		filterHeadersBase: startHeight - 1,
+		scannedHeight:     startHeight - 1,
+		status:            blockchain.CONNECTED,
+		wakeChan:          make(chan struct{}, 1),
+		closeChan:         make(chan struct{}),
+		log:               log.WithField("group", "neutrino"),
+	}
+	go client.poll()
+	return client
+}
+
+// SetHeaders implements headers.Consumer. The headers are used to validate the block headers the
+// filters and blocks are synced for.
+func (client *Client) SetHeaders(headersInstance headers.Interface) {
+	func() {
+		defer client.lock.Lock()()
+		client.headers = headersInstance
+	}()
+	headersInstance.SubscribeEvent(func(event headers.Event) {
+		if event == headers.EventSynced {
+			client.wake()
+		}
+	})
+	client.wake()
+}
+
+// IsSupported returns true if the client can be used for the network. Only Bitcoin networks are
+// supported.
+func IsSupported(net *chaincfg.Params) bool {
+	switch net.Net {
+	case chaincfg.MainNetParams.Net, chaincfg.TestNet3Params.Net, chaincfg.RegressionNetParams.Net:
+		return true
+	}
+	return signet.IsSignet(net)
+}
+
+func scriptHash(pkScript []byte) blockchain.ScriptHashHex {
+	return blockchain.ScriptHashHex(chainhash.HashH(pkScript).String())
+}
+
+// wake triggers a sync without waiting for the next poll.
+func (client *Client) wake() {
+	select {
+	case client.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+func (client *Client) poll() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := client.sync(); err != nil {
+			client.log.WithError(err).Error("Failed to sync with the peers")
+		}
+		select {
+		case <-client.closeChan:
+			client.disconnect()
+			return
+		case <-ticker.C:
+		case <-client.wakeChan:
+		}
+	}
+}
+
+func (client *Client) setStatus(status blockchain.Status) {
+	callbacks := func() []func(blockchain.Status) {
+		defer client.lock.Lock()()
+		if client.status == status {
+			return nil
+		}
+		client.status = status
+		return client.onConnectionStatusChange
+	}()
+	for _, callback := range callbacks {
+		callback(status)
+	}
+}
+
+// connectPeers connects to the configured peers which are not connected and returns all connected
+// peers.
+func (client *Client) connectPeers() []*peer {
+	result := []*peer{}
+	for _, address := range client.config.Peers {
+		p := func() *peer {
+			defer client.lock.RLock()()
+			return client.peers[address]
+		}()
+		if p == nil || p.closed() {
+			var err error
+			p, err = connectPeer(client.socksProxy, address, client.net, client.wake, client.log)
+			if err != nil {
+				client.log.WithError(err).WithField("peer", address).Error("Could not connect to peer")
+				continue
+			}
+			func() {
+				defer client.lock.Lock()()
+				client.peers[address] = p
+			}()
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// connectedPeers returns the connected peers in the configured order.
+func (client *Client) connectedPeers() []*peer {
+	defer client.lock.RLock()()
+	result := []*peer{}
+	for _, address := range client.config.Peers {
+		if p, ok := client.peers[address]; ok && !p.closed() {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (client *Client) disconnect() {
+	for _, p := range client.connectedPeers() {
+		p.close()
+	}
+}
+
+// sync validates the filter headers up to the headers tip, scans the new blocks and notifies the
+// subscribers about changes.
+func (client *Client) sync() error {
+	err := func() error {
+		headersInstance := func() headers.Interface {
+			defer client.lock.RLock()()
+			return client.headers
+		}()
+		if headersInstance == nil {
+			return nil
+		}
+		peers := client.connectPeers()
+		if len(peers) == 0 {
+			return &connectionError{errp.New("No peer serving compact block filters is reachable")}
+		}
+		status, err := headersInstance.Status()
+		if err != nil {
+			return err
+		}
+		if err := client.updateLocator(headersInstance, status.Base, status.Tip); err != nil {
+			return err
+		}
+		networkTip, err := client.networkTip(peers[0], status.Tip)
+		if err != nil {
+			return err
+		}
+		client.notifyTip(networkTip)
+		if status.Tip < networkTip || status.Tip < 0 {
+			// The headers are still syncing. We continue when they are synced.
+			return nil
+		}
+		if err := client.syncFilterHeaders(peers, headersInstance, status.Tip); err != nil {
+			return err
+		}
+		if err := client.scan(peers[0], headersInstance, status.Tip); err != nil {
+			return err
+		}
+		client.update()
+		return nil
+	}()
+	if _, ok := errp.Cause(err).(*connectionError); ok {
+		client.setStatus(blockchain.DISCONNECTED)
+	} else {
+		client.setStatus(blockchain.CONNECTED)
+	}
+	return err
+}
+
+// blockHashes returns the hashes of the validated block headers from `from` to `to`.
+func blockHashes(headersInstance headers.Interface, from, to int) ([]chainhash.Hash, error) {
+	result := make([]chainhash.Hash, 0, to-from+1)
+	for height := from; height <= to; height++ {
+		header, err := headersInstance.HeaderByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		if header == nil {
+			return nil, errp.Newf("Header %d is not available", height)
+		}
+		result = append(result, header.BlockHash())
+	}
+	return result, nil
+}
+
+// updateLocator builds the block locator of the headers tip, which is used to request the
+// following headers.
+func (client *Client) updateLocator(headersInstance headers.Interface, base, tip int) error {
+	locator := []chainhash.Hash{}
+	heights := []int{}
+	step := 1
+	// The locator ends at the base, as the headers below are only downloaded on demand.
+	for height := tip; height >= base; height -= step {
+		header, err := headersInstance.HeaderByHeight(height)
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			return errp.Newf("Header %d is not available", height)
+		}
+		locator = append(locator, header.BlockHash())
+		heights = append(heights, height)
+		if len(locator) >= locatorConsecutive {
+			step *= 2
+		}
+		if height > base && height-step < base {
+			step = height - base
+		}
+	}
+	defer client.lock.Lock()()
+	client.locator = locator
+	client.locatorHeights = heights
+	client.locatorHeight = tip
+	return nil
+}
+
+// locatorFor returns a block locator of the header at the given height, if it is known.
+func (client *Client) locatorFor(height int) ([]chainhash.Hash, bool) {
+	defer client.lock.Lock()()
+	switch {
+	case height == client.lastHeaderHeight && height > client.locatorHeight:
+		return append([]chainhash.Hash{client.lastHeaderHash}, client.locator...), true
+	case height == client.locatorHeight && height >= 0:
+		return client.locator, true
+	case height == client.lastHeaderHeight && height >= 0:
+		return []chainhash.Hash{client.lastHeaderHash, *client.net.GenesisHash}, true
+	}
+	client.headersStalled = true
+	return nil, false
+}
+
+// getHeaders requests the headers following the first known block of the locator.
+func getHeaders(p *peer, locator []chainhash.Hash) ([]*wire.BlockHeader, error) {
+	msg := wire.NewMsgGetHeaders()
+	msg.ProtocolVersion = protocolVersion
+	for index := range locator {
+		if err := msg.AddBlockLocatorHash(&locator[index]); err != nil {
+			return nil, errp.WithStack(err)
+		}
+	}
+	var result []*wire.BlockHeader
+	err := p.request(msg, func(response wire.Message) (bool, error) {
+		headersMsg, ok := response.(*wire.MsgHeaders)
+		if !ok {
+			return false, nil
+		}
+		result = headersMsg.Headers
+		return true, nil
+	})
+	return result, err
+}
+
+// networkTip returns the height of the best chain of the peer. If the chain of the peer forks off
+// the headers chain, its height is larger than the headers tip, so that the headers reorganize.
+func (client *Client) networkTip(p *peer, headersTip int) (int, error) {
+	tip := headersTip
+	locator, heights, ok := func() ([]chainhash.Hash, []int, bool) {
+		defer client.lock.RLock()()
+		return client.locator, client.locatorHeights, client.locatorHeight == headersTip && headersTip >= 0
+	}()
+	if ok {
+		newHeaders, err := getHeaders(p, locator)
+		if err != nil {
+			return 0, err
+		}
+		for index, hash := range locator {
+			if len(newHeaders) > 0 && newHeaders[0].PrevBlock == hash {
+				tip = heights[index] + len(newHeaders)
+				break
+			}
+		}
+		if tip < headersTip {
+			tip = headersTip
+		}
+	}
+	if p.startHeight > tip {
+		tip = p.startHeight
+	}
+	return tip, nil
+}
+
+// notifyTip notifies the headers subscribers if the tip changed, if they were not answered yet, or
+// if headers could not be served before.
+func (client *Client) notifyTip(tip int) {
+	notifications := []func(){}
+	func() {
+		defer client.lock.Lock()()
+		notifyAll := tip != client.tip || client.headersStalled
+		client.tip = tip
+		client.headersStalled = false
+		for _, sub := range client.headersSubscription {
+			if !notifyAll && sub.pending == nil {
+				continue
+			}
+			callback, pending := sub.callback, sub.pending
+			sub.pending = nil
+			notifications = append(notifications, func() {
+				if err := callback(&blockchain.Header{BlockHeight: tip}); err != nil {
+					client.log.WithError(err).Error("could not handle header notification")
+				}
+				if pending != nil {
+					pending()
+				}
+			})
+		}
+	}()
+	for _, notification := range notifications {
+		notification()
+	}
+}
+
+// filterHeaderAt returns the verified filter header at the given height.
+func (client *Client) filterHeaderAt(height int) chainhash.Hash {
+	return client.filterHeaders[height-client.filterHeadersBase]
+}
+
+// syncFilterHeaders syncs the filter headers up to the tip. Filter headers of blocks which are not
+// in the chain anymore are dropped, together with the transactions found in these blocks. The
+// filter header of the tip is compared with the one of every other peer.
+func (client *Client) syncFilterHeaders(
+	peers []*peer, headersInstance headers.Interface, tip int) error {
+	if tip <= client.filterHeadersBase {
+		return nil
+	}
+	for len(client.filterHeaders) > 1 {
+		last := len(client.filterHeaders) - 1
+		height := client.filterHeadersBase + last
+		if height <= tip {
+			header, err := headersInstance.HeaderByHeight(height)
+			if err != nil {
+				return err
+			}
+			if header != nil && header.BlockHash() == client.filterBlockHashes[last] {
+				break
+			}
+		}
+		client.log.Infof("Block %d was reorganized", height)
+		client.filterHeaders = client.filterHeaders[:last]
+		client.filterBlockHashes = client.filterBlockHashes[:last]
+		client.rollback(height)
+	}
+	for {
+		next := client.filterHeadersBase + len(client.filterHeaders)
+		if len(client.filterHeaders) == 0 {
+			next++
+		}
+		if next > tip {
+			break
+		}
+		stop := next + wire.MaxCFHeadersPerMsg - 1
+		if stop > tip {
+			stop = tip
+		}
+		hashes, err := blockHashes(headersInstance, next, stop)
+		if err != nil {
+			return err
+		}
+		response, err := getCFHeaders(peers[0], next, hashes[len(hashes)-1])
+		if err != nil {
+			return err
+		}
+		if len(response.FilterHashes) != len(hashes) {
+			return errp.Newf("Expected %d filter hashes, got %d",
+				len(hashes), len(response.FilterHashes))
+		}
+		if len(client.filterHeaders) == 0 {
+			if next == 0 && response.PrevFilterHeader != (chainhash.Hash{}) {
+				return errp.New("Unexpected filter header before the genesis block")
+			}
+			client.filterHeaders = []chainhash.Hash{response.PrevFilterHeader}
+			client.filterBlockHashes = []chainhash.Hash{{}}
+		}
+		previous := client.filterHeaders[len(client.filterHeaders)-1]
+		if response.PrevFilterHeader != previous {
+			return errp.Newf("Filter headers at height %d do not connect", next)
+		}
+		for index, filterHash := range response.FilterHashes {
+			previous = filterHeader(*filterHash, previous)
+			client.filterHeaders = append(client.filterHeaders, previous)
+			client.filterBlockHashes = append(client.filterBlockHashes, hashes[index])
+		}
+	}
+	tipHash := client.filterBlockHashes[tip-client.filterHeadersBase]
+	for _, p := range peers[1:] {
+		response, err := getCFHeaders(p, tip, tipHash)
+		if err != nil {
+			client.log.WithError(err).WithField("peer", p.address).Error(
+				"Could not cross-check filter headers")
+			continue
+		}
+		if len(response.FilterHashes) != 1 ||
+			filterHeader(*response.FilterHashes[0], response.PrevFilterHeader) !=
+				client.filterHeaderAt(tip) {
+			return errp.Newf("Peers %s and %s disagree on the filter header at height %d",
+				peers[0].address, p.address, tip)
+		}
+	}
+	return nil
+}
+
+func getCFHeaders(p *peer, start int, stopHash chainhash.Hash) (*wire.MsgCFHeaders, error) {
+	var result *wire.MsgCFHeaders
+	err := p.request(
+		wire.NewMsgGetCFHeaders(wire.GCSFilterRegular, uint32(start), &stopHash),
+		func(response wire.Message) (bool, error) {
+			cfheaders, ok := response.(*wire.MsgCFHeaders)
+			if !ok || cfheaders.StopHash != stopHash {
+				return false, nil
+			}
+			result = cfheaders
+			return true, nil
+		})
+	return result, err
+}
+
+// rollback forgets the blocks from the given height on, so that they are scanned again.
+func (client *Client) rollback(height int) {
+	if client.scannedHeight >= height {
+		client.scannedHeight = height - 1
+	}
+	defer client.lock.Lock()()
+	for txHash, transaction := range client.transactions {
+		if transaction.height >= height {
+			delete(client.transactions, txHash)
+		}
+	}
+}
+
+// scan scans the blocks up to the tip for transactions of the accounts. Blocks which were scanned
+// before are scanned again for newly imported scripts.
+func (client *Client) scan(p *peer, headersInstance headers.Interface, tip int) error {
+	queue, scripts := func() ([][]byte, [][]byte) {
+		defer client.lock.Lock()()
+		queue := client.importQueue
+		client.importQueue = nil
+		scripts := make([][]byte, 0, len(client.scripts))
+		for _, pkScript := range client.scripts {
+			scripts = append(scripts, pkScript)
+		}
+		return queue, scripts
+	}()
+	startHeight := client.filterHeadersBase + 1
+	if len(queue) > 0 && client.scannedHeight >= startHeight {
+		client.log.WithField("count", len(queue)).Info("Scanning for new scripts")
+		if err := client.scanRange(p, headersInstance, startHeight, client.scannedHeight, queue); err != nil {
+			func() {
+				defer client.lock.Lock()()
+				client.importQueue = append(queue, client.importQueue...)
+			}()
+			return err
+		}
+	}
+	for client.scannedHeight < tip {
+		from := client.scannedHeight + 1
+		to := from + wire.MaxGetCFiltersReqRange - 1
+		if to > tip {
+			to = tip
+		}
+		if err := client.scanRange(p, headersInstance, from, to, scripts); err != nil {
+			return err
+		}
+		client.scannedHeight = to
+	}
+	return nil
+}
+
+// scanRange matches the scripts against the filters of the blocks from `from` to `to` and
+// processes the matching blocks.
+func (client *Client) scanRange(
+	p *peer, headersInstance headers.Interface, from, to int, scripts [][]byte) error {
+	if len(scripts) == 0 {
+		return nil
+	}
+	for start := from; start <= to; start += wire.MaxGetCFiltersReqRange {
+		stop := start + wire.MaxGetCFiltersReqRange - 1
+		if stop > to {
+			stop = to
+		}
+		hashes, err := blockHashes(headersInstance, start, stop)
+		if err != nil {
+			return err
+		}
+		matches := []int{}
+		received := 0
+		err = p.request(
+			wire.NewMsgGetCFilters(wire.GCSFilterRegular, uint32(start), &hashes[len(hashes)-1]),
+			func(response wire.Message) (bool, error) {
+				cfilter, ok := response.(*wire.MsgCFilter)
+				if !ok {
+					return false, nil
+				}
+				height := start + received
+				if cfilter.BlockHash != hashes[received] {
+					return false, errp.Newf("Unexpected filter for block %d", height)
+				}
+				if filterHeader(chainhash.DoubleHashH(cfilter.Data), client.filterHeaderAt(height-1)) !=
+					client.filterHeaderAt(height) {
+					return false, errp.Newf("Filter of block %d does not match its header", height)
+				}
+				blockFilter, err := parseFilter(cfilter.BlockHash, cfilter.Data)
+				if err != nil {
+					return false, err
+				}
+				match, err := blockFilter.matchAny(scripts)
+				if err != nil {
+					return false, err
+				}
+				if match {
+					matches = append(matches, height)
+				}
+				received++
+				return received == len(hashes), nil
+			})
+		if err != nil {
+			return err
+		}
+		for _, height := range matches {
+			block, err := getBlock(p, hashes[height-start])
+			if err != nil {
+				return err
+			}
+			if err := client.processBlock(block, height); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func getBlock(p *peer, blockHash chainhash.Hash) (*wire.MsgBlock, error) {
+	var result *wire.MsgBlock
+	getData := wire.NewMsgGetData()
+	if err := getData.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessBlock, &blockHash)); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	err := p.request(getData, func(response wire.Message) (bool, error) {
+		switch response := response.(type) {
+		case *wire.MsgBlock:
+			if response.BlockHash() != blockHash {
+				return false, nil
+			}
+			result = response
+			return true, nil
+		case *wire.MsgNotFound:
+			return false, errp.Newf("Peer %s does not have block %s", p.address, blockHash)
+		}
+		return false, nil
+	})
+	return result, err
+}
+
+// merkleBranch returns the merkle root of the transaction hashes and the branch proving the
+// inclusion of the transaction at the given position, bottom-up as in Electrum.
+func merkleBranch(txHashes []chainhash.Hash, pos int) (chainhash.Hash, []blockchain.TXHash) {
+	level := append([]chainhash.Hash{}, txHashes...)
+	branch := []blockchain.TXHash{}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		branch = append(branch, blockchain.TXHash(level[pos^1]))
+		next := make([]chainhash.Hash, len(level)/2)
+		for index := range next {
+			next[index] = chainhash.DoubleHashH(
+				append(level[2*index][:], level[2*index+1][:]...))
+		}
+		level = next
+		pos >>= 1
+	}
+	return level[0], branch
+}
+
+// processBlock stores the transactions of the block which pay to or spend from the scripts of the
+// accounts. Unconfirmed transactions which conflict with the block are dropped.
+func (client *Client) processBlock(block *wire.MsgBlock, height int) error {
+	txHashes := make([]chainhash.Hash, len(block.Transactions))
+	for index, tx := range block.Transactions {
+		txHashes[index] = tx.TxHash()
+	}
+	merkleRoot, _ := merkleBranch(txHashes, 0)
+	if merkleRoot != block.Header.MerkleRoot {
+		return errp.Newf("Block %d does not match its merkle root", height)
+	}
+	defer client.lock.Lock()()
+	spent := map[wire.OutPoint]chainhash.Hash{}
+	for index, tx := range block.Transactions {
+		if !client.isRelevant(tx) {
+			continue
+		}
+		_, merkle := merkleBranch(txHashes, index)
+		client.transactions[txHashes[index]] = &transaction{
+			tx:     tx,
+			height: height,
+			merkle: merkle,
+			pos:    index,
+		}
+		for _, txIn := range tx.TxIn {
+			spent[txIn.PreviousOutPoint] = txHashes[index]
+		}
+	}
+	for txHash, transaction := range client.transactions {
+		if transaction.height != 0 {
+			continue
+		}
+		for _, txIn := range transaction.tx.TxIn {
+			if spender, ok := spent[txIn.PreviousOutPoint]; ok && spender != txHash {
+				client.log.WithField("tx", txHash).Info("Dropping conflicted transaction")
+				delete(client.transactions, txHash)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// isRelevant returns true if the transaction pays to one of the scripts or spends an output of a
+// known transaction paying to one of the scripts. The lock must be held.
+func (client *Client) isRelevant(tx *wire.MsgTx) bool {
+	for _, txOut := range tx.TxOut {
+		if _, ok := client.scripts[scriptHash(txOut.PkScript)]; ok {
+			return true
+		}
+	}
+	for _, txIn := range tx.TxIn {
+		previous, ok := client.transactions[txIn.PreviousOutPoint.Hash]
+		if !ok || int(txIn.PreviousOutPoint.Index) >= len(previous.tx.TxOut) {
+			continue
+		}
+		pkScript := previous.tx.TxOut[txIn.PreviousOutPoint.Index].PkScript
+		if _, ok := client.scripts[scriptHash(pkScript)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// histories derives the history of each script from the transactions. A transaction belongs to
+// the history of a script if it has an output paying to the script or an input spending such an
+// output.
+func histories(
+	scripts map[blockchain.ScriptHashHex][]byte,
+	transactions map[chainhash.Hash]*transaction,
+) map[blockchain.ScriptHashHex]blockchain.TxHistory {
+	result := map[blockchain.ScriptHashHex]blockchain.TxHistory{}
+	for txHash, transaction := range transactions {
+		touched := map[blockchain.ScriptHashHex]struct{}{}
+		for _, txOut := range transaction.tx.TxOut {
+			touched[scriptHash(txOut.PkScript)] = struct{}{}
+		}
+		for _, txIn := range transaction.tx.TxIn {
+			previous, ok := transactions[txIn.PreviousOutPoint.Hash]
+			if ok && int(txIn.PreviousOutPoint.Index) < len(previous.tx.TxOut) {
+				pkScript := previous.tx.TxOut[txIn.PreviousOutPoint.Index].PkScript
+				touched[scriptHash(pkScript)] = struct{}{}
+			}
+		}
+		for hash := range touched {
+			if _, ok := scripts[hash]; ok {
+				result[hash] = append(result[hash], &blockchain.TxInfo{
+					Height: transaction.height,
+					TXHash: blockchain.TXHash(txHash),
+				})
+			}
+		}
+	}
+	// Like Electrum, confirmed transactions are ordered by height, followed by the unconfirmed ones.
+	for _, history := range result {
+		sort.Slice(history, func(i, j int) bool {
+			heightI, heightJ := history[i].Height, history[j].Height
+			if (heightI == 0) != (heightJ == 0) {
+				return heightJ == 0
+			}
+			if heightI != heightJ {
+				return heightI < heightJ
+			}
+			hashI, hashJ := history[i].TXHash.Hash(), history[j].TXHash.Hash()
+			return hashI.String() < hashJ.String()
+		})
+	}
+	return result
+}
+
+// update recomputes the histories and notifies the subscribers.
+func (client *Client) update() {
+	notifications := []func(){}
+	func() {
+		defer client.lock.Lock()()
+		client.histories = histories(client.scripts, client.transactions)
+		for hash, sub := range client.subscriptions {
+			callback := sub.callback
+			status := client.histories[hash].Status()
+			if status == sub.status && len(sub.pending) == 0 {
+				continue
+			}
+			sub.status = status
+			pending := sub.pending
+			sub.pending = nil
+			notifications = append(notifications, func() {
+				if err := callback(status); err != nil {
+					client.log.WithError(err).Error("Failed to execute callback")
+				}
+				for _, teardown := range pending {
+					teardown()
+				}
+			})
+		}
+	}()
+	for _, notification := range notifications {
+		notification()
+	}
+}
+
+// method runs the request in the background and then calls cleanup. Requests which fail because no
+// peer is reachable are retried until they succeed or the client is closed. Other errors are
+// logged.
+func (client *Client) method(request func() error, cleanup func()) {
+	go func() {
+		defer cleanup()
+		for {
+			err := request()
+			if err == nil {
+				return
+			}
+			if _, ok := errp.Cause(err).(*connectionError); !ok {
+				client.log.WithError(err).Error("Request failed")
+				return
+			}
+			client.log.WithError(err).Debug("Retrying request")
+			client.setStatus(blockchain.DISCONNECTED)
+			select {
+			case <-client.closeChan:
+				return
+			case <-time.After(pollInterval):
+			}
+		}
+	}()
+}
+
+// ImportScript implements blockchain.ScriptImporter. The blocks are scanned for the script in the
+// next sync.
+func (client *Client) ImportScript(pkScript []byte) {
+	defer client.lock.Lock()()
+	hash := scriptHash(pkScript)
+	if _, ok := client.scripts[hash]; ok {
+		return
+	}
+	client.scripts[hash] = pkScript
+	client.importQueue = append(client.importQueue, pkScript)
+}
+
+// ScriptHashGetHistory implements blockchain.Interface. The history is the one of the last sync.
+func (client *Client) ScriptHashGetHistory(
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(blockchain.TxHistory) error,
+	cleanup func(),
+) {
+	history := func() blockchain.TxHistory {
+		defer client.lock.RLock()()
+		return append(blockchain.TxHistory{}, client.histories[scriptHashHex]...)
+	}()
+	client.method(func() error { return success(history) }, cleanup)
+}
+
+// ScriptHashSubscribe implements blockchain.Interface. The current status is passed to the
+// callback after the next sync, which scans the blocks for the script if it is new.
+func (client *Client) ScriptHashSubscribe(
+	setupAndTeardown func() func(),
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(string) error,
+) {
+	teardown := setupAndTeardown()
+	func() {
+		defer client.lock.Lock()()
+		sub, ok := client.subscriptions[scriptHashHex]
+		if !ok {
+			sub = &subscription{}
+			client.subscriptions[scriptHashHex] = sub
+		}
+		sub.callback = success
+		sub.pending = append(sub.pending, teardown)
+	}()
+	client.wake()
+}
+
+// HeadersSubscribe implements blockchain.Interface.
+func (client *Client) HeadersSubscribe(
+	setupAndTeardown func() func(),
+	success func(*blockchain.Header) error,
+) {
+	// headers.Headers subscribes without a setup function.
+	teardown := func() {}
+	if setupAndTeardown != nil {
+		teardown = setupAndTeardown()
+	}
+	func() {
+		defer client.lock.Lock()()
+		client.headersSubscription = append(client.headersSubscription,
+			&headersSubscription{callback: success, pending: teardown})
+	}()
+	client.wake()
+}
+
+// TransactionGet implements blockchain.Interface. Only the transactions of the accounts are
+// available.
+func (client *Client) TransactionGet(
+	txHash chainhash.Hash,
+	success func(*wire.MsgTx) error,
+	cleanup func(),
+) {
+	client.method(func() error {
+		transaction := func() *transaction {
+			defer client.lock.RLock()()
+			return client.transactions[txHash]
+		}()
+		if transaction == nil {
+			return errp.Newf("Transaction %s is not known", txHash)
+		}
+		return success(transaction.tx)
+	}, cleanup)
+}
+
+// TransactionBroadcast implements blockchain.Interface. The transaction is sent to all connected
+// peers. From the next sync on, it is part of the histories as an unconfirmed transaction until it
+// is found in a block.
+func (client *Client) TransactionBroadcast(tx *wire.MsgTx) error {
+	peers := client.connectedPeers()
+	if len(peers) == 0 {
+		return errp.New("No peer connected")
+	}
+	sent := false
+	for _, p := range peers {
+		if err := p.send(tx); err != nil {
+			client.log.WithError(err).WithField("peer", p.address).Error(
+				"Failed to broadcast transaction")
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		return errp.New("Failed to broadcast transaction")
+	}
+	func() {
+		defer client.lock.Lock()()
+		txHash := tx.TxHash()
+		if _, ok := client.transactions[txHash]; !ok {
+			client.transactions[txHash] = &transaction{tx: tx}
+		}
+	}()
+	client.wake()
+	return nil
+}
+
+// relayFee returns the highest fee rate announced by the peers with feefilter (BIP133), or the
+// default minimum relay fee.
+func (client *Client) relayFee() btcutil.Amount {
+	relayFee := int64(minRelayFee)
+	for _, p := range client.connectedPeers() {
+		if fee := p.relayFee(); fee > relayFee {
+			relayFee = fee
+		}
+	}
+	return btcutil.Amount(relayFee)
+}
+
+// RelayFee implements blockchain.Interface.
+func (client *Client) RelayFee(success func(btcutil.Amount) error, cleanup func()) {
+	client.method(func() error { return success(client.relayFee()) }, cleanup)
+}
+
+// EstimateFee implements blockchain.Interface. The estimate for a confirmation within `number`
+// blocks is the number-th highest average fee rate of the recent blocks, i.e. a fee rate which
+// was paid on average in at least `number` of them. It is at least the relay fee.
+func (client *Client) EstimateFee(number int, success func(*btcutil.Amount) error, cleanup func()) {
+	client.method(func() error {
+		feeRates, err := client.recentFeeRates()
+		if err != nil {
+			return err
+		}
+		index := number - 1
+		if index >= len(feeRates) {
+			index = len(feeRates) - 1
+		}
+		if index < 0 {
+			index = 0
+		}
+		feeRate := feeRates[index]
+		if relayFee := client.relayFee(); feeRate < relayFee {
+			feeRate = relayFee
+		}
+		return success(&feeRate)
+	}, cleanup)
+}
+
+// Headers implements blockchain.Interface. Headers are fetched from the first connected peer.
+// Requests for headers which do not follow a known header are answered with no headers and
+// retried after the next sync via the headers subscription.
+func (client *Client) Headers(
+	startHeight int, count int,
+	success func(headers []*wire.BlockHeader, max int) error,
+	cleanup func(),
+) {
+	client.method(func() error {
+		peers := client.connectedPeers()
+		if len(peers) == 0 {
+			return &connectionError{errp.New("No peer connected")}
+		}
+		var locator []chainhash.Hash
+		result := []*wire.BlockHeader{}
+		if startHeight == 0 {
+			genesis := client.net.GenesisBlock.Header
+			result = append(result, &genesis)
+			locator = []chainhash.Hash{*client.net.GenesisHash}
+		} else {
+			var ok bool
+			locator, ok = client.locatorFor(startHeight - 1)
+			if !ok {
+				client.wake()
+				return success(nil, wire.MaxBlockHeadersPerMsg)
+			}
+		}
+		newHeaders, err := getHeaders(peers[0], locator)
+		if err != nil {
+			return err
+		}
+		result = append(result, newHeaders...)
+		if count < len(result) {
+			result = result[:count]
+		}
+		if startHeight == 0 || (len(result) > 0 && result[0].PrevBlock == locator[0]) {
+			func() {
+				defer client.lock.Lock()()
+				client.lastHeaderHeight = startHeight + len(result) - 1
+				if len(result) > 0 {
+					client.lastHeaderHash = result[len(result)-1].BlockHash()
+				}
+			}()
+		}
+		return success(result, wire.MaxBlockHeadersPerMsg)
+	}, cleanup)
+}
+
+// GetMerkle implements blockchain.Interface. The merkle branches are computed when the blocks are
+// processed.
+func (client *Client) GetMerkle(
+	txHash chainhash.Hash, height int,
+	success func(merkle []blockchain.TXHash, pos int) error,
+	cleanup func(),
+) {
+	client.method(func() error {
+		transaction := func() *transaction {
+			defer client.lock.RLock()()
+			return client.transactions[txHash]
+		}()
+		if transaction == nil || transaction.height != height || height == 0 {
+			return errp.Newf("Transaction %s is not known at height %d", txHash, height)
+		}
+		return success(transaction.merkle, transaction.pos)
+	}, cleanup)
+}
+
+// Close implements blockchain.Interface.
+func (client *Client) Close() {
+	defer client.lock.Lock()()
+	if client.closed {
+		return
+	}
+	client.closed = true
+	close(client.closeChan)
+}
+
+// ConnectionStatus implements blockchain.Interface.
+func (client *Client) ConnectionStatus() blockchain.Status {
+	defer client.lock.RLock()()
+	return client.status
+}
+
+// RegisterOnConnectionStatusChangedEvent implements blockchain.Interface.
+func (client *Client) RegisterOnConnectionStatusChangedEvent(onConnectionStatusChanged func(blockchain.Status)) {
+	defer client.lock.Lock()()
+	client.onConnectionStatusChange = append(client.onConnectionStatusChange, onConnectionStatusChanged)
+}
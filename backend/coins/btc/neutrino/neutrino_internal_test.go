@@ -0,0 +1,581 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package neutrino
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	btcdBlockchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/headersdb"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+const testTimeout = 10 * time.Second
+
+var testNet = &chaincfg.RegressionNetParams
+
+func init() {
+	pollInterval = 20 * time.Millisecond
+}
+
+func p2wpkh(seed byte) []byte {
+	return append([]byte{0x00, 0x14}, chainhash.HashB([]byte{seed})[:20]...)
+}
+
+var (
+	minerScript   = p2wpkh(0)
+	foreignScript = p2wpkh(1)
+	scriptA       = p2wpkh(2)
+	scriptB       = p2wpkh(3)
+)
+
+// fakeNode is a regtest full node serving headers, compact block filters and blocks over P2P.
+type fakeNode struct {
+	t        *testing.T
+	listener net.Listener
+
+	lock   sync.Mutex
+	blocks []*wire.MsgBlock
+	// filters are the serialized filters and filterHeaders their headers, by height.
+	filters       [][]byte
+	filterHeaders []chainhash.Hash
+	// pkScripts are the scripts of all outputs, used to build the filters.
+	pkScripts map[wire.OutPoint][]byte
+	// requestedBlocks are the heights of the requested blocks.
+	requestedBlocks []int
+	transactions    []*wire.MsgTx
+	conns           []net.Conn
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	node := &fakeNode{
+		t:         t,
+		listener:  listener,
+		pkScripts: map[wire.OutPoint][]byte{},
+	}
+	node.addBlock(testNet.GenesisBlock)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go node.serve(conn)
+		}
+	}()
+	return node
+}
+
+func (node *fakeNode) address() string {
+	return node.listener.Addr().String()
+}
+
+func (node *fakeNode) close() {
+	_ = node.listener.Close()
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	for _, conn := range node.conns {
+		_ = conn.Close()
+	}
+}
+
+func (node *fakeNode) tip() int {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return len(node.blocks) - 1
+}
+
+// mine adds a block with a coinbase paying to minerScript and the given transactions, and returns
+// the coinbase.
+func (node *fakeNode) mine(transactions ...*wire.MsgTx) *wire.MsgTx {
+	return node.mineWithFees(0, transactions...)
+}
+
+// mineWithFees is like mine, with a coinbase collecting the given fees in addition to the subsidy.
+func (node *fakeNode) mineWithFees(fees btcutil.Amount, transactions ...*wire.MsgTx) *wire.MsgTx {
+	node.lock.Lock()
+	height := len(node.blocks)
+	prevBlock := node.blocks[height-1].BlockHash()
+	node.lock.Unlock()
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript:  []byte{0x03, byte(height), byte(height >> 8), byte(len(transactions))},
+	})
+	coinbase.AddTxOut(wire.NewTxOut(50e8+int64(fees), minerScript))
+	block := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:   0x20000000,
+			PrevBlock: prevBlock,
+			Timestamp: testNet.GenesisBlock.Header.Timestamp.Add(time.Duration(height) * 10 * time.Minute),
+			Bits:      testNet.PowLimitBits,
+		},
+		Transactions: append([]*wire.MsgTx{coinbase}, transactions...),
+	}
+	txHashes := make([]chainhash.Hash, len(block.Transactions))
+	for index, tx := range block.Transactions {
+		txHashes[index] = tx.TxHash()
+	}
+	block.Header.MerkleRoot, _ = merkleBranch(txHashes, 0)
+	target := btcdBlockchain.CompactToBig(block.Header.Bits)
+	for {
+		blockHash := block.BlockHash()
+		if btcdBlockchain.HashToBig(&blockHash).Cmp(target) <= 0 {
+			break
+		}
+		block.Header.Nonce++
+	}
+	node.addBlock(block)
+	return coinbase
+}
+
+func (node *fakeNode) addBlock(block *wire.MsgBlock) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	items := [][]byte{}
+	for index, tx := range block.Transactions {
+		if index > 0 {
+			for _, txIn := range tx.TxIn {
+				items = append(items, node.pkScripts[txIn.PreviousOutPoint])
+			}
+		}
+		for outIndex, txOut := range tx.TxOut {
+			node.pkScripts[wire.OutPoint{Hash: tx.TxHash(), Index: uint32(outIndex)}] = txOut.PkScript
+			items = append(items, txOut.PkScript)
+		}
+	}
+	serialized := buildFilter(block.BlockHash(), items)
+	previous := chainhash.Hash{}
+	if len(node.filterHeaders) > 0 {
+		previous = node.filterHeaders[len(node.filterHeaders)-1]
+	}
+	node.blocks = append(node.blocks, block)
+	node.filters = append(node.filters, serialized)
+	node.filterHeaders = append(node.filterHeaders,
+		filterHeader(chainhash.DoubleHashH(serialized), previous))
+}
+
+// reorg removes the blocks from the given height on.
+func (node *fakeNode) reorg(height int) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.blocks = node.blocks[:height]
+	node.filters = node.filters[:height]
+	node.filterHeaders = node.filterHeaders[:height]
+}
+
+// announce sends an inv of the tip to all peers.
+func (node *fakeNode) announce() {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	blockHash := node.blocks[len(node.blocks)-1].BlockHash()
+	inv := wire.NewMsgInv()
+	require.NoError(node.t, inv.AddInvVect(wire.NewInvVect(wire.InvTypeBlock, &blockHash)))
+	for _, conn := range node.conns {
+		_ = wire.WriteMessage(conn, inv, protocolVersion, testNet.Net)
+	}
+}
+
+func (node *fakeNode) height(blockHash chainhash.Hash) int {
+	for height, block := range node.blocks {
+		if block.BlockHash() == blockHash {
+			return height
+		}
+	}
+	return -1
+}
+
+func (node *fakeNode) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	send := func(msg wire.Message) {
+		_, _ = wire.WriteMessageWithEncodingN(conn, msg, protocolVersion, testNet.Net, wire.WitnessEncoding)
+	}
+	for {
+		_, msg, _, err := wire.ReadMessageWithEncodingN(
+			conn, protocolVersion, testNet.Net, wire.WitnessEncoding)
+		if err != nil {
+			return
+		}
+		func() {
+			node.lock.Lock()
+			defer node.lock.Unlock()
+			switch msg := msg.(type) {
+			case *wire.MsgVersion:
+				version := wire.NewMsgVersion(
+					wire.NewNetAddressIPPort(net.IPv4zero, 0, 0),
+					wire.NewNetAddressIPPort(net.IPv4zero, 0, 0),
+					1, int32(len(node.blocks)-1))
+				version.Services = wire.SFNodeNetwork | wire.SFNodeWitness | wire.SFNodeCF
+				send(version)
+				send(wire.NewMsgVerAck())
+				node.conns = append(node.conns, conn)
+			case *wire.MsgGetHeaders:
+				start := 1
+				for _, hash := range msg.BlockLocatorHashes {
+					if height := node.height(*hash); height >= 0 {
+						start = height + 1
+						break
+					}
+				}
+				response := wire.NewMsgHeaders()
+				for height := start; height < len(node.blocks) &&
+					len(response.Headers) < wire.MaxBlockHeadersPerMsg; height++ {
+					require.NoError(node.t, response.AddBlockHeader(&node.blocks[height].Header))
+				}
+				send(response)
+			case *wire.MsgGetCFHeaders:
+				stop := node.height(msg.StopHash)
+				response := wire.NewMsgCFHeaders()
+				response.StopHash = msg.StopHash
+				if msg.StartHeight > 0 {
+					response.PrevFilterHeader = node.filterHeaders[msg.StartHeight-1]
+				}
+				for height := int(msg.StartHeight); height <= stop; height++ {
+					filterHash := chainhash.DoubleHashH(node.filters[height])
+					require.NoError(node.t, response.AddCFHash(&filterHash))
+				}
+				send(response)
+			case *wire.MsgGetCFilters:
+				stop := node.height(msg.StopHash)
+				for height := int(msg.StartHeight); height <= stop; height++ {
+					blockHash := node.blocks[height].BlockHash()
+					send(wire.NewMsgCFilter(wire.GCSFilterRegular, &blockHash, node.filters[height]))
+				}
+			case *wire.MsgGetData:
+				for _, inv := range msg.InvList {
+					height := node.height(inv.Hash)
+					if height < 0 {
+						notFound := wire.NewMsgNotFound()
+						require.NoError(node.t, notFound.AddInvVect(inv))
+						send(notFound)
+						continue
+					}
+					node.requestedBlocks = append(node.requestedBlocks, height)
+					send(node.blocks[height])
+				}
+			case *wire.MsgTx:
+				node.transactions = append(node.transactions, msg)
+			case *wire.MsgPing:
+				send(wire.NewMsgPong(msg.Nonce))
+			}
+		}()
+	}
+}
+
+// spend creates a transaction spending the outputs and paying to the scripts, 1 BTC each.
+func spend(outPoints []wire.OutPoint, pkScripts ...[]byte) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for _, outPoint := range outPoints {
+		tx.AddTxIn(wire.NewTxIn(&outPoint, nil, [][]byte{{0x01}}))
+	}
+	for _, pkScript := range pkScripts {
+		tx.AddTxOut(wire.NewTxOut(1e8, pkScript))
+	}
+	return tx
+}
+
+func newTestClient(t *testing.T, nodes ...*fakeNode) (*Client, *headers.Headers) {
+	peers := []string{}
+	for _, node := range nodes {
+		peers = append(peers, node.address())
+	}
+	log := logging.Get().WithGroup("neutrino_test")
+	client := NewClient(&Config{Peers: peers}, testNet, socksproxy.NewSocksProxy(socksproxy.Config{}), log)
+	db, err := headersdb.NewDB(test.TstTempFile("bitbox-wallet-headers-"))
+	require.NoError(t, err)
+	headersInstance := headers.NewHeaders(testNet, db, client, log)
+	client.SetHeaders(headersInstance)
+	headersInstance.Init()
+	return client, headersInstance
+}
+
+// subscribe subscribes to the script and returns the channel receiving the statuses.
+func subscribe(client *Client, pkScript []byte) <-chan string {
+	statuses := make(chan string, 10)
+	client.ImportScript(pkScript)
+	client.ScriptHashSubscribe(
+		func() func() { return func() {} },
+		scriptHash(pkScript),
+		func(status string) error {
+			statuses <- status
+			return nil
+		},
+	)
+	return statuses
+}
+
+// requireStatus waits until the subscription reports the status of the history.
+func requireStatus(t *testing.T, statuses <-chan string, expected blockchain.TxHistory) {
+	t.Helper()
+	for {
+		select {
+		case status := <-statuses:
+			if status == expected.Status() {
+				return
+			}
+		case <-time.After(testTimeout):
+			require.Fail(t, "timeout waiting for status")
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.close()
+	coinbase := node.mine()
+	node.mine()
+	node.mine()
+	txA := spend([]wire.OutPoint{{Hash: coinbase.TxHash()}}, scriptA, minerScript)
+	node.mine(txA)
+	node.mine()
+	node.mine()
+	txB := spend([]wire.OutPoint{{Hash: txA.TxHash()}}, foreignScript, scriptB)
+	node.mine(txB)
+	node.mine()
+
+	client, headersInstance := newTestClient(t, node)
+	defer client.Close()
+
+	statusesA := subscribe(client, scriptA)
+	requireStatus(t, statusesA, blockchain.TxHistory{
+		{Height: 4, TXHash: blockchain.TXHash(txA.TxHash())},
+		{Height: 7, TXHash: blockchain.TXHash(txB.TxHash())},
+	})
+	require.Equal(t, 8, headersInstance.TipHeight())
+	// Only the blocks with transactions of the scripts were downloaded.
+	node.lock.Lock()
+	require.Equal(t, []int{4, 7}, node.requestedBlocks)
+	node.lock.Unlock()
+
+	// Blocks which were scanned before are scanned again for new scripts.
+	statusesB := subscribe(client, scriptB)
+	requireStatus(t, statusesB, blockchain.TxHistory{
+		{Height: 7, TXHash: blockchain.TXHash(txB.TxHash())},
+	})
+	node.lock.Lock()
+	require.Equal(t, []int{4, 7, 7}, node.requestedBlocks)
+	node.lock.Unlock()
+
+	transactions := make(chan *wire.MsgTx, 1)
+	client.TransactionGet(txA.TxHash(), func(tx *wire.MsgTx) error {
+		transactions <- tx
+		return nil
+	}, func() {})
+	select {
+	case tx := <-transactions:
+		require.Equal(t, txA.TxHash(), tx.TxHash())
+	case <-time.After(testTimeout):
+		require.Fail(t, "timeout")
+	}
+
+	type proof struct {
+		merkle []blockchain.TXHash
+		pos    int
+	}
+	proofs := make(chan proof, 1)
+	client.GetMerkle(txB.TxHash(), 7, func(merkle []blockchain.TXHash, pos int) error {
+		proofs <- proof{merkle, pos}
+		return nil
+	}, func() {})
+	select {
+	case result := <-proofs:
+		require.Equal(t, 1, result.pos)
+		root := txB.TxHash()
+		for index, hash := range result.merkle {
+			if result.pos>>uint(index)&1 == 0 {
+				root = chainhash.DoubleHashH(append(root[:], hash[:]...))
+			} else {
+				root = chainhash.DoubleHashH(append(hash[:], root[:]...))
+			}
+		}
+		header, err := headersInstance.HeaderByHeight(7)
+		require.NoError(t, err)
+		require.Equal(t, header.MerkleRoot, root)
+	case <-time.After(testTimeout):
+		require.Fail(t, "timeout")
+	}
+
+	// New blocks are announced by the peer.
+	txC := spend([]wire.OutPoint{{Hash: txA.TxHash(), Index: 1}}, scriptA)
+	node.mine(txC)
+	node.announce()
+	requireStatus(t, statusesA, blockchain.TxHistory{
+		{Height: 4, TXHash: blockchain.TXHash(txA.TxHash())},
+		{Height: 7, TXHash: blockchain.TXHash(txB.TxHash())},
+		{Height: 9, TXHash: blockchain.TXHash(txC.TxHash())},
+	})
+}
+
+func TestBroadcast(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.close()
+	coinbase := node.mine()
+	node.mine()
+
+	client, _ := newTestClient(t, node)
+	defer client.Close()
+	statuses := subscribe(client, scriptA)
+	requireStatus(t, statuses, blockchain.TxHistory{})
+
+	tx := spend([]wire.OutPoint{{Hash: coinbase.TxHash()}}, scriptA)
+	require.NoError(t, client.TransactionBroadcast(tx))
+	requireStatus(t, statuses, blockchain.TxHistory{
+		{Height: 0, TXHash: blockchain.TXHash(tx.TxHash())},
+	})
+	node.lock.Lock()
+	require.Len(t, node.transactions, 1)
+	require.Equal(t, tx.TxHash(), node.transactions[0].TxHash())
+	node.lock.Unlock()
+
+	// A conflicting transaction is mined, which replaces the broadcast one.
+	conflict := spend([]wire.OutPoint{{Hash: coinbase.TxHash()}}, scriptA, foreignScript)
+	node.mine(conflict)
+	node.announce()
+	requireStatus(t, statuses, blockchain.TxHistory{
+		{Height: 3, TXHash: blockchain.TXHash(conflict.TxHash())},
+	})
+
+	relayFees := make(chan int64, 1)
+	client.RelayFee(func(fee btcutil.Amount) error {
+		relayFees <- int64(fee)
+		return nil
+	}, func() {})
+	require.Equal(t, int64(minRelayFee), <-relayFees)
+}
+
+func TestEstimateFee(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.close()
+	coinbase := node.mine()
+	node.mine()
+	tx1 := spend([]wire.OutPoint{{Hash: coinbase.TxHash()}}, scriptA, foreignScript)
+	vsize := (btcdBlockchain.GetTransactionWeight(btcutil.NewTx(tx1)) + 3) / 4
+	node.mineWithFees(btcutil.Amount(vsize*20), tx1)
+	tx2 := spend([]wire.OutPoint{{Hash: tx1.TxHash()}}, scriptA, foreignScript)
+	node.mineWithFees(btcutil.Amount(vsize*5), tx2)
+
+	client, _ := newTestClient(t, node)
+	defer client.Close()
+	estimate := func(number int) int64 {
+		feeRates := make(chan int64, 1)
+		client.EstimateFee(number, func(feeRate *btcutil.Amount) error {
+			require.NotNil(t, feeRate)
+			feeRates <- int64(*feeRate)
+			return nil
+		}, func() {})
+		select {
+		case feeRate := <-feeRates:
+			return feeRate
+		case <-time.After(testTimeout):
+			require.Fail(t, "timeout")
+			return 0
+		}
+	}
+	// The average fee rates of the recent blocks, highest first, and at least the relay fee.
+	require.Equal(t, int64(20000), estimate(1))
+	require.Equal(t, int64(5000), estimate(2))
+	require.Equal(t, int64(minRelayFee), estimate(3))
+	require.Equal(t, int64(minRelayFee), estimate(25))
+}
+
+func TestReorg(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.close()
+	coinbase := node.mine()
+	txA := spend([]wire.OutPoint{{Hash: coinbase.TxHash()}}, scriptA)
+	node.mine(txA)
+	node.mine()
+	txB := spend([]wire.OutPoint{{Hash: txA.TxHash()}}, scriptA)
+	node.mine(txB)
+
+	client, headersInstance := newTestClient(t, node)
+	defer client.Close()
+	statuses := subscribe(client, scriptA)
+	requireStatus(t, statuses, blockchain.TxHistory{
+		{Height: 2, TXHash: blockchain.TXHash(txA.TxHash())},
+		{Height: 4, TXHash: blockchain.TXHash(txB.TxHash())},
+	})
+
+	// The blocks 3 and 4 are replaced by a longer chain without txB.
+	node.reorg(3)
+	node.mine()
+	node.mine()
+	node.mine()
+	node.announce()
+	requireStatus(t, statuses, blockchain.TxHistory{
+		{Height: 2, TXHash: blockchain.TXHash(txA.TxHash())},
+	})
+	require.Equal(t, 5, headersInstance.TipHeight())
+}
+
+func TestPeersDisagree(t *testing.T) {
+	node1 := newFakeNode(t)
+	defer node1.close()
+	node2 := newFakeNode(t)
+	defer node2.close()
+	for _, node := range []*fakeNode{node1, node2} {
+		coinbase := node.mine()
+		node.mine(spend([]wire.OutPoint{{Hash: coinbase.TxHash()}}, scriptA))
+	}
+	// node2 serves a wrong filter for block 2, e.g. to hide the transaction.
+	node2.lock.Lock()
+	node2.filters[2] = buildFilter(node2.blocks[2].BlockHash(), nil)
+	previous := node2.filterHeaders[1]
+	node2.filterHeaders[2] = filterHeader(chainhash.DoubleHashH(node2.filters[2]), previous)
+	node2.lock.Unlock()
+
+	// The headers are synced from node1 by another client, so that the filter headers of this
+	// client, which has no headers set and thus does not sync by itself, can be synced here.
+	other, headersInstance := newTestClient(t, node1)
+	defer other.Close()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		status, err := headersInstance.Status()
+		require.NoError(t, err)
+		if status.Tip == 2 {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "timeout waiting for headers")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client := NewClient(&Config{Peers: []string{node1.address(), node2.address()}}, testNet,
+		socksproxy.NewSocksProxy(socksproxy.Config{}),
+		logging.Get().WithGroup("neutrino_test"))
+	defer client.Close()
+	peers := client.connectPeers()
+	require.Len(t, peers, 2)
+	err := client.syncFilterHeaders(peers, headersInstance, 2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "disagree on the filter header at height 2")
+
+	// A single peer can not be checked.
+	require.NoError(t, client.syncFilterHeaders(peers[1:], headersInstance, 2))
+}
@@ -0,0 +1,279 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package neutrino
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/sirupsen/logrus"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+)
+
+const (
+	// protocolVersion is the P2P protocol version we announce.
+	protocolVersion = wire.ProtocolVersion
+	// responseTimeout is the maximum time to wait for the next message of a response.
+	responseTimeout = 30 * time.Second
+)
+
+// pendingRequest receives the messages of the peer while a request is in flight.
+type pendingRequest struct {
+	messages chan wire.Message
+	done     chan struct{}
+}
+
+// peer is a minimal Bitcoin P2P client, which only supports the messages needed to sync compact
+// block filters and blocks. Requests are sent one at a time.
+type peer struct {
+	address string
+	conn    net.Conn
+	net     *chaincfg.Params
+	// startHeight is the chain height the peer announced in the handshake.
+	startHeight int
+	// onAnnouncement is called when the peer announces a new block.
+	onAnnouncement func()
+
+	writeLock   sync.Mutex
+	requestLock sync.Mutex
+
+	lock    locker.Locker
+	pending *pendingRequest
+	// feeFilter is the minimum fee rate in sat/kB of the transactions the peer relays.
+	feeFilter int64
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+
+	log *logrus.Entry
+}
+
+// connectPeer connects to a peer and performs the version handshake. Peers which do not serve
+// compact block filters (BIP157) are rejected.
+func connectPeer(
+	socksProxy *socksproxy.SocksProxy,
+	address string,
+	net *chaincfg.Params,
+	onAnnouncement func(),
+	log *logrus.Entry,
+) (*peer, error) {
+	conn, err := socksProxy.Dial("tcp", address)
+	if err != nil {
+		return nil, &connectionError{err}
+	}
+	p := &peer{
+		address:        address,
+		conn:           conn,
+		net:            net,
+		onAnnouncement: onAnnouncement,
+		closeChan:      make(chan struct{}),
+		log:            log.WithField("peer", address),
+	}
+	if err := p.handshake(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	go p.readLoop()
+	return p, nil
+}
+
+func (p *peer) handshake() error {
+	if err := p.conn.SetDeadline(time.Now().Add(responseTimeout)); err != nil {
+		return &connectionError{errp.WithStack(err)}
+	}
+	me := wire.NewNetAddressIPPort(net.IPv4zero, 0, 0)
+	you := wire.NewNetAddressIPPort(net.IPv4zero, 0, wire.SFNodeNetwork)
+	if tcpAddr, ok := p.conn.RemoteAddr().(*net.TCPAddr); ok {
+		you = wire.NewNetAddress(tcpAddr, wire.SFNodeNetwork)
+	}
+	version := wire.NewMsgVersion(me, you, rand.Uint64(), 0)
+	version.ProtocolVersion = int32(protocolVersion)
+	// We do not want to receive unconfirmed transactions.
+	version.DisableRelayTx = true
+	if err := version.AddUserAgent("BitBoxApp", "neutrino"); err != nil {
+		return errp.WithStack(err)
+	}
+	if err := p.send(version); err != nil {
+		return err
+	}
+	var gotVersion, gotVerAck bool
+	for !gotVersion || !gotVerAck {
+		msg, err := p.read()
+		if err != nil {
+			return err
+		}
+		switch msg := msg.(type) {
+		case *wire.MsgVersion:
+			if !msg.HasService(wire.SFNodeCF) {
+				return errp.Newf("peer %s does not serve compact block filters", p.address)
+			}
+			p.startHeight = int(msg.LastBlock)
+			gotVersion = true
+			if err := p.send(wire.NewMsgVerAck()); err != nil {
+				return err
+			}
+		case *wire.MsgVerAck:
+			gotVerAck = true
+		}
+	}
+	// We do not send sendheaders (BIP130), so that new blocks are announced with inv messages,
+	// which cannot be confused with responses to getheaders.
+	return errp.WithStack(p.conn.SetDeadline(time.Time{}))
+}
+
+// read returns the next known message. Messages with unknown commands are skipped.
+func (p *peer) read() (wire.Message, error) {
+	for {
+		_, msg, _, err := wire.ReadMessageWithEncodingN(
+			p.conn, protocolVersion, p.net.Net, wire.WitnessEncoding)
+		if _, ok := err.(*wire.MessageError); ok {
+			continue
+		}
+		if err != nil {
+			return nil, &connectionError{errp.WithStack(err)}
+		}
+		return msg, nil
+	}
+}
+
+func (p *peer) send(msg wire.Message) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	if err := p.conn.SetWriteDeadline(time.Now().Add(responseTimeout)); err != nil {
+		return &connectionError{errp.WithStack(err)}
+	}
+	_, err := wire.WriteMessageWithEncodingN(
+		p.conn, msg, protocolVersion, p.net.Net, wire.WitnessEncoding)
+	if err != nil {
+		p.close()
+		return &connectionError{errp.WithStack(err)}
+	}
+	return nil
+}
+
+func (p *peer) readLoop() {
+	defer p.close()
+	for {
+		msg, err := p.read()
+		if err != nil {
+			p.log.WithError(err).Debug("Peer disconnected")
+			return
+		}
+		switch msg := msg.(type) {
+		case *wire.MsgPing:
+			if err := p.send(wire.NewMsgPong(msg.Nonce)); err != nil {
+				return
+			}
+			continue
+		case *wire.MsgFeeFilter:
+			func() {
+				defer p.lock.Lock()()
+				p.feeFilter = msg.MinFee
+			}()
+			continue
+		case *wire.MsgInv:
+			for _, inv := range msg.InvList {
+				if inv.Type == wire.InvTypeBlock || inv.Type == wire.InvTypeWitnessBlock {
+					p.onAnnouncement()
+					break
+				}
+			}
+		}
+		pending := func() *pendingRequest {
+			defer p.lock.RLock()()
+			return p.pending
+		}()
+		if pending == nil {
+			continue
+		}
+		select {
+		case pending.messages <- msg:
+		case <-pending.done:
+		case <-p.closeChan:
+			return
+		}
+	}
+}
+
+// request sends a message and passes the received messages to handle until it returns true or an
+// error. Unrelated messages, e.g. announcements, are passed to handle as well and must be ignored
+// by it.
+func (p *peer) request(msg wire.Message, handle func(wire.Message) (bool, error)) error {
+	p.requestLock.Lock()
+	defer p.requestLock.Unlock()
+	pending := &pendingRequest{
+		messages: make(chan wire.Message),
+		done:     make(chan struct{}),
+	}
+	func() {
+		defer p.lock.Lock()()
+		p.pending = pending
+	}()
+	defer func() {
+		defer p.lock.Lock()()
+		p.pending = nil
+		close(pending.done)
+	}()
+	if err := p.send(msg); err != nil {
+		return err
+	}
+	for {
+		select {
+		case response := <-pending.messages:
+			done, err := handle(response)
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		case <-time.After(responseTimeout):
+			p.close()
+			return &connectionError{errp.Newf("peer %s timed out", p.address)}
+		case <-p.closeChan:
+			return &connectionError{errp.Newf("peer %s disconnected", p.address)}
+		}
+	}
+}
+
+// relayFee returns the minimum fee rate in sat/kB the peer announced with feefilter (BIP133), or
+// 0 if it did not.
+func (p *peer) relayFee() int64 {
+	defer p.lock.RLock()()
+	return p.feeFilter
+}
+
+func (p *peer) closed() bool {
+	select {
+	case <-p.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *peer) close() {
+	p.closeOnce.Do(func() {
+		close(p.closeChan)
+		_ = p.conn.Close()
+	})
+}
@@ -17,7 +17,10 @@ package btc
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"reflect"
 	"strings"
 	"time"
@@ -25,6 +28,8 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/sirupsen/logrus"
 
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable/action"
@@ -34,55 +39,138 @@ var coins = []string{"BTC", "LTC", "ETH"}
 var fiats = []string{"USD", "EUR", "CHF", "GBP", "JPY", "KRW", "CNY", "RUB"}
 
 const interval = time.Minute
-const url = "https://min-api.cryptocompare.com/data/pricemulti?fsyms=%s&tsyms=%s"
+const url = "https://min-api.cryptocompare.com/data/pricemultifull?fsyms=%s&tsyms=%s"
+const historicalURL = "https://min-api.cryptocompare.com/data/histoday?fsym=%s&tsym=%s&limit=%d&toTs=%d"
+
+// historicalDays is the number of days of rates fetched with one request.
+const historicalDays = 2000
+
+// historicalRequestInterval is the minimum time between two requests of historical rates.
+const historicalRequestInterval = time.Second
+
+const dayFormat = "2006-01-02"
 
 // RatesUpdater implements coin.RatesUpdater.
 type RatesUpdater struct {
 	observable.Implementation
-	last map[string]map[string]float64
-	log  *logrus.Entry
+	httpClient *http.Client
+	last       map[string]map[string]float64
+	// dayAgo are the rates of 24 hours before the last rates, as reported with them.
+	dayAgo   map[string]map[string]float64
+	lastLock locker.Locker
+	// historical caches the rates at the end of past days by pair ("unit/fiat") and day
+	// (YYYY-MM-DD). It is persisted in historicalFilename.
+	historical         map[string]map[string]float64
+	historicalFilename string
+	// fetchLocks serialize the requests of the historical rates of each pair, so that concurrent
+	// lookups of the same pair are served by one request.
+	fetchLocks     map[string]*locker.Locker
+	historicalLock locker.Locker
+	// nextRequest is the earliest time at which the historical rates may be requested again.
+	nextRequest     time.Time
+	nextRequestLock locker.Locker
+	log             *logrus.Entry
+}
+
+// NewRatesUpdater returns a new rates updater, which fetches the rates with the given client. The
+// historical rates are cached in the given file, which does not have to exist.
+func NewRatesUpdater(httpClient *http.Client, historicalFilename string) *RatesUpdater {
+	updater := newRatesUpdater(httpClient, historicalFilename)
+	go updater.start()
+	return updater
 }
 
-// NewRatesUpdater returns a new rates updater.
-func NewRatesUpdater() *RatesUpdater {
+func newRatesUpdater(httpClient *http.Client, historicalFilename string) *RatesUpdater {
 	updater := &RatesUpdater{
-		last: map[string]map[string]float64{},
-		log:  logging.Get().WithGroup("rates"),
+		httpClient:         httpClient,
+		last:               map[string]map[string]float64{},
+		historical:         map[string]map[string]float64{},
+		historicalFilename: historicalFilename,
+		fetchLocks:         map[string]*locker.Locker{},
+		log:                logging.Get().WithGroup("rates"),
+	}
+	jsonBytes, err := ioutil.ReadFile(historicalFilename)
+	if err != nil && !os.IsNotExist(err) {
+		updater.log.WithError(err).Error("Could not read the historical rates")
+	}
+	if err == nil {
+		if err := json.Unmarshal(jsonBytes, &updater.historical); err != nil {
+			updater.log.WithError(err).Error("Could not load the historical rates")
+			updater.historical = map[string]map[string]float64{}
+		}
 	}
-	go updater.start()
 	return updater
 }
 
 // Last returns the last rates for a given coin and fiat or nil if not available.
 func (updater *RatesUpdater) Last() map[string]map[string]float64 {
+	defer updater.lastLock.RLock()()
 	return updater.last
 }
 
+// DayAgo implements coin.RatesUpdater.
+func (updater *RatesUpdater) DayAgo() map[string]map[string]float64 {
+	defer updater.lastLock.RLock()()
+	return updater.dayAgo
+}
+
+// parseRates decodes the response of the rates API into the current rates and the rates of 24 hours
+// ago, which are left out if not reported.
+func parseRates(body io.Reader) (map[string]map[string]float64, map[string]map[string]float64, error) {
+	var response struct {
+		Raw map[string]map[string]struct {
+			Price      float64 `json:"PRICE"`
+			Open24Hour float64 `json:"OPEN24HOUR"`
+		} `json:"RAW"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, nil, errp.WithStack(err)
+	}
+	rates := map[string]map[string]float64{}
+	dayAgo := map[string]map[string]float64{}
+	for unit, fiatRates := range response.Raw {
+		rates[unit] = map[string]float64{}
+		dayAgo[unit] = map[string]float64{}
+		for fiat, rate := range fiatRates {
+			rates[unit][fiat] = rate.Price
+			if rate.Open24Hour > 0 {
+				dayAgo[unit][fiat] = rate.Open24Hour
+			}
+		}
+	}
+	return rates, dayAgo, nil
+}
+
+func (updater *RatesUpdater) setLast(rates, dayAgo map[string]map[string]float64) {
+	defer updater.lastLock.Lock()()
+	updater.last = rates
+	updater.dayAgo = dayAgo
+}
+
 func (updater *RatesUpdater) update() {
-	response, err := http.Get(fmt.Sprintf(url,
+	response, err := updater.httpClient.Get(fmt.Sprintf(url,
 		strings.Join(coins, ","),
 		strings.Join(fiats, ","),
 	))
 	if err != nil {
-		updater.last = nil
+		updater.setLast(nil, nil)
 		return
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
-	var rates map[string]map[string]float64
-	err = json.NewDecoder(response.Body).Decode(&rates)
+	rates, dayAgo, err := parseRates(response.Body)
 	if err != nil {
-		updater.last = nil
+		updater.setLast(nil, nil)
 		return
 	}
 
-	if reflect.DeepEqual(rates, updater.last) {
+	if reflect.DeepEqual(rates, updater.Last()) && reflect.DeepEqual(dayAgo, updater.DayAgo()) {
 		return
 	}
 
-	updater.last = rates
+	updater.setLast(rates, dayAgo)
 	updater.log.WithField("data", spew.Sprintf("%v", rates)).Debug("Exchange rates changed.")
 	updater.Notify(observable.Event{
 		Subject: "coins/rates",
@@ -91,6 +179,141 @@ func (updater *RatesUpdater) update() {
 	})
 }
 
+// HistoricalRate implements coin.RatesUpdater. The rates of the current day are not final yet, so
+// the last rates are returned for it. The rates of past days are fetched in batches of
+// historicalDays days and cached.
+func (updater *RatesUpdater) HistoricalRate(unit string, fiat string, t time.Time) (float64, error) {
+	day := t.UTC().Truncate(24 * time.Hour)
+	if !day.Add(24 * time.Hour).Before(time.Now()) {
+		if rate, ok := updater.Last()[unit][fiat]; ok {
+			return rate, nil
+		}
+	}
+	pair := unit + "/" + fiat
+	if rate, ok := updater.cachedHistoricalRate(pair, day); ok {
+		return rate, nil
+	}
+	defer updater.fetchLock(pair).Lock()()
+	// The rate might have been fetched while waiting for the lock.
+	if rate, ok := updater.cachedHistoricalRate(pair, day); ok {
+		return rate, nil
+	}
+	rates, err := updater.fetchHistoricalRates(unit, fiat, day)
+	if err != nil {
+		return 0, err
+	}
+	updater.cacheHistoricalRates(pair, rates)
+	rate, ok := rates[day.Format(dayFormat)]
+	if !ok {
+		return 0, errp.Newf("no %s rate for %s on %s", fiat, unit, day.Format(dayFormat))
+	}
+	return rate, nil
+}
+
+func (updater *RatesUpdater) cachedHistoricalRate(pair string, day time.Time) (float64, bool) {
+	defer updater.historicalLock.RLock()()
+	rate, ok := updater.historical[pair][day.Format(dayFormat)]
+	return rate, ok
+}
+
+func (updater *RatesUpdater) fetchLock(pair string) *locker.Locker {
+	defer updater.historicalLock.Lock()()
+	lock, ok := updater.fetchLocks[pair]
+	if !ok {
+		lock = &locker.Locker{}
+		updater.fetchLocks[pair] = lock
+	}
+	return lock
+}
+
+// cacheHistoricalRates adds the rates of the past days among the given ones to the cache and
+// persists it.
+func (updater *RatesUpdater) cacheHistoricalRates(pair string, rates map[string]float64) {
+	defer updater.historicalLock.Lock()()
+	pairRates, ok := updater.historical[pair]
+	if !ok {
+		pairRates = map[string]float64{}
+		updater.historical[pair] = pairRates
+	}
+	now := time.Now()
+	for day, rate := range rates {
+		start, err := time.Parse(dayFormat, day)
+		if err != nil || !start.Add(24*time.Hour).Before(now) {
+			continue
+		}
+		pairRates[day] = rate
+	}
+	jsonBytes, err := json.Marshal(updater.historical)
+	if err != nil {
+		updater.log.WithError(err).Error("Could not encode the historical rates")
+		return
+	}
+	if err := ioutil.WriteFile(updater.historicalFilename, jsonBytes, 0600); err != nil {
+		updater.log.WithError(err).Error("Could not store the historical rates")
+	}
+}
+
+// waitForRequest blocks until the historical rates may be requested again.
+func (updater *RatesUpdater) waitForRequest() {
+	wait := func() time.Duration {
+		defer updater.nextRequestLock.Lock()()
+		now := time.Now()
+		if updater.nextRequest.Before(now) {
+			updater.nextRequest = now
+		}
+		wait := updater.nextRequest.Sub(now)
+		updater.nextRequest = updater.nextRequest.Add(historicalRequestInterval)
+		return wait
+	}()
+	time.Sleep(wait)
+}
+
+// fetchHistoricalRates requests the rates at the end of the historicalDays days starting with the
+// given day, by day (YYYY-MM-DD). The days after the current day are left out.
+func (updater *RatesUpdater) fetchHistoricalRates(
+	unit string, fiat string, day time.Time) (map[string]float64, error) {
+	to := day.Add(historicalDays * 24 * time.Hour)
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+	updater.waitForRequest()
+	response, err := updater.httpClient.Get(
+		fmt.Sprintf(historicalURL, unit, fiat, historicalDays, to.Unix()))
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	return parseHistoricalRates(response.Body)
+}
+
+// parseHistoricalRates decodes the response of the daily rates API into the closing rates by day.
+// Days without a rate are left out.
+func parseHistoricalRates(body io.Reader) (map[string]float64, error) {
+	var response struct {
+		Response string `json:"Response"`
+		Message  string `json:"Message"`
+		Data     []struct {
+			Time  int64   `json:"time"`
+			Close float64 `json:"close"`
+		} `json:"Data"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if response.Response == "Error" {
+		return nil, errp.Newf("could not get the historical rates: %s", response.Message)
+	}
+	rates := map[string]float64{}
+	for _, entry := range response.Data {
+		if entry.Close > 0 {
+			rates[time.Unix(entry.Time, 0).UTC().Format(dayFormat)] = entry.Close
+		}
+	}
+	return rates, nil
+}
+
 func (updater *RatesUpdater) start() {
 	for {
 		updater.update()
@@ -0,0 +1,135 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestParseRates(t *testing.T) {
+	rates, dayAgo, err := parseRates(strings.NewReader(`{"RAW": {
+		"BTC": {"USD": {"PRICE": 100.5, "OPEN24HOUR": 90}, "EUR": {"PRICE": 80}},
+		"LTC": {"USD": {"PRICE": 50, "OPEN24HOUR": 55}}
+	}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]map[string]float64{
+		"BTC": {"USD": 100.5, "EUR": 80},
+		"LTC": {"USD": 50},
+	}, rates)
+	// The rates of 24 hours ago are left out if not reported.
+	require.Equal(t, map[string]map[string]float64{
+		"BTC": {"USD": 90},
+		"LTC": {"USD": 55},
+	}, dayAgo)
+
+	_, _, err = parseRates(strings.NewReader("invalid"))
+	require.Error(t, err)
+}
+
+func TestParseHistoricalRates(t *testing.T) {
+	rates, err := parseHistoricalRates(strings.NewReader(`{"Response": "Success", "Data": [
+		{"time": 1514764800, "close": 13657.2},
+		{"time": 1514851200, "close": 0},
+		{"time": 1514937600, "close": 15201}
+	]}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"2018-01-01": 13657.2, "2018-01-03": 15201}, rates)
+
+	_, err = parseHistoricalRates(strings.NewReader(`{"Response": "Error", "Message": "limit"}`))
+	require.Error(t, err)
+}
+
+func TestHistoricalRate(t *testing.T) {
+	var requests int32
+	// The mocked rate of each day is its number since the epoch.
+	httpClient := &http.Client{Transport: roundTripperFunc(
+		func(request *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&requests, 1)
+			query := request.URL.Query()
+			limit, err := strconv.ParseInt(query.Get("limit"), 10, 64)
+			require.NoError(t, err)
+			to, err := strconv.ParseInt(query.Get("toTs"), 10, 64)
+			require.NoError(t, err)
+			entries := []string{}
+			for day := to/86400 - limit; day <= to/86400; day++ {
+				entries = append(entries, fmt.Sprintf(`{"time": %d, "close": %d}`, day*86400, day))
+			}
+			body := `{"Response": "Success", "Data": [` + strings.Join(entries, ",") + `]}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader(body)),
+			}, nil
+		})}
+	dir := test.TstTempDir("rates")
+	defer func() { _ = os.RemoveAll(dir) }()
+	filename := path.Join(dir, "historical-rates.json")
+
+	updater := newRatesUpdater(httpClient, filename)
+	day := time.Now().UTC().Truncate(24 * time.Hour).Add(-10 * 24 * time.Hour)
+	dayNumber := float64(day.Unix() / 86400)
+
+	// Concurrent lookups of the same pair are served by one request.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rate, err := updater.HistoricalRate("BTC", "USD", day.Add(time.Hour))
+			require.NoError(t, err)
+			require.Equal(t, dayNumber, rate)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// The other days of the batch are cached.
+	rate, err := updater.HistoricalRate("BTC", "USD", day.Add(-100*24*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, dayNumber-100, rate)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// The rates of the current day are the last rates.
+	updater.setLast(map[string]map[string]float64{"BTC": {"USD": 42}}, nil)
+	rate, err = updater.HistoricalRate("BTC", "USD", time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 42.0, rate)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// The cache is persisted.
+	updater = newRatesUpdater(httpClient, filename)
+	rate, err = updater.HistoricalRate("BTC", "USD", day)
+	require.NoError(t, err)
+	require.Equal(t, dayNumber, rate)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
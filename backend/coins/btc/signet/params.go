@@ -0,0 +1,100 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signet provides the chain params of signets (BIP325), test networks whose blocks are
+// signed by the holders of a challenge script instead of being mined competitively.
+package signet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Name is the name of all signet chain params.
+const Name = "signet"
+
+// DefaultChallenge is the challenge script of the default signet, a 1-of-2 multisig.
+var DefaultChallenge, _ = hex.DecodeString(
+	"512103ad5e0edad18cb1f0fc0d28a3d4f1f3e445640337489abb10404f2d1e086be430210359ef5021964fe22d6f8e" +
+		"05b2463c9540ce96883fe3b278760f048f5189f2e6c452ae")
+
+// powLimit is the highest proof of work value a signet block can have.
+var powLimit, _ = new(big.Int).SetString(
+	"0x00000377ae000000000000000000000000000000000000000000000000000000", 0)
+
+// genesisBlock is shared by all signets. It only differs from the mainnet genesis block in the
+// timestamp, the difficulty and the nonce.
+var genesisBlock = wire.MsgBlock{
+	Header: wire.BlockHeader{
+		Version:    1,
+		PrevBlock:  chainhash.Hash{},
+		MerkleRoot: chaincfg.MainNetParams.GenesisBlock.Header.MerkleRoot,
+		Timestamp:  time.Unix(1598918400, 0), // 2020-09-01 00:00:00 +0000 UTC
+		Bits:       0x1e0377ae,
+		Nonce:      52613770,
+	},
+	Transactions: chaincfg.MainNetParams.GenesisBlock.Transactions,
+}
+
+var genesisHash = genesisBlock.BlockHash()
+
+// DefaultParams are the chain params of the default signet.
+var DefaultParams = NewParams(DefaultChallenge)
+
+// Net returns the network magic of the signet with the given challenge script, the first four
+// bytes of the double SHA256 of the serialized challenge.
+func Net(challenge []byte) wire.BitcoinNet {
+	var serialized bytes.Buffer
+	if err := wire.WriteVarBytes(&serialized, 0, challenge); err != nil {
+		panic(errp.WithStack(err))
+	}
+	hash := chainhash.DoubleHashB(serialized.Bytes())
+	return wire.BitcoinNet(binary.LittleEndian.Uint32(hash[:4]))
+}
+
+// NewParams returns the chain params of the signet with the given challenge script. The difficulty
+// rules are the ones of mainnet, with a lower proof of work limit. Addresses and keys are encoded
+// like on testnet.
+func NewParams(challenge []byte) *chaincfg.Params {
+	params := chaincfg.TestNet3Params
+	params.Name = Name
+	params.Net = Net(challenge)
+	params.DefaultPort = "38333"
+	params.DNSSeeds = nil
+	params.GenesisBlock = &genesisBlock
+	params.GenesisHash = &genesisHash
+	params.PowLimit = powLimit
+	params.PowLimitBits = 0x1e0377ae
+	params.BIP0034Height = 1
+	params.BIP0065Height = 1
+	params.BIP0066Height = 1
+	params.ReduceMinDifficulty = false
+	params.MinDiffReductionTime = 0
+	params.Checkpoints = nil
+	params.Deployments = [chaincfg.DefinedDeployments]chaincfg.ConsensusDeployment{}
+	return &params
+}
+
+// IsSignet returns true if the chain params are the ones of a signet.
+func IsSignet(params *chaincfg.Params) bool {
+	return params.Name == Name
+}
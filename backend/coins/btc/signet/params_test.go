@@ -0,0 +1,49 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signet_test
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/signet"
+)
+
+func TestDefaultParams(t *testing.T) {
+	require.Equal(t,
+		"00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6",
+		signet.DefaultParams.GenesisHash.String())
+	require.Equal(t, *signet.DefaultParams.GenesisHash, signet.DefaultParams.GenesisBlock.BlockHash())
+	// The message start of the default signet is 0a03cf40.
+	magic := make([]byte, 4)
+	binary.LittleEndian.PutUint32(magic, uint32(signet.DefaultParams.Net))
+	require.Equal(t, "0a03cf40", hex.EncodeToString(magic))
+	require.True(t, signet.IsSignet(signet.DefaultParams))
+	require.False(t, signet.IsSignet(&chaincfg.TestNet3Params))
+}
+
+func TestCustomParams(t *testing.T) {
+	// OP_TRUE, anyone can sign blocks.
+	params := signet.NewParams([]byte{0x51})
+	require.NotEqual(t, signet.DefaultParams.Net, params.Net)
+	require.Equal(t, signet.DefaultParams.GenesisHash, params.GenesisHash)
+	require.True(t, signet.IsSignet(params))
+	// The default params are not modified.
+	require.Equal(t, signet.Net(signet.DefaultChallenge), signet.DefaultParams.Net)
+}
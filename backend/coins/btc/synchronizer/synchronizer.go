@@ -70,13 +70,15 @@ func (synchronizer *Synchronizer) decRequestsCounter() {
 
 // WaitSynchronized blocks until all pending synchronization tasks are finished.
 func (synchronizer *Synchronizer) WaitSynchronized() {
-	synchronizer.log.WithFields(logrus.Fields{"requestCounter": synchronizer.requestsCounter}).
-		Debug("wait synchronized")
-	if func() int32 {
+	wait := func() chan struct{} {
 		defer synchronizer.waitLock.RLock()()
-		return synchronizer.requestsCounter
-	}() == 0 {
+		synchronizer.log.WithFields(logrus.Fields{"requestCounter": synchronizer.requestsCounter}).
+			Debug("wait synchronized")
+		// wait is nil if the counter is zero.
+		return synchronizer.wait
+	}()
+	if wait == nil {
 		return
 	}
-	<-synchronizer.wait
+	<-wait
 }
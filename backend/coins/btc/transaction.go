@@ -19,7 +19,6 @@ import (
 
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/btcsuite/btcutil"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
@@ -46,12 +45,9 @@ func (account *Account) newTx(
 
 	account.log.Debug("Prepare new transaction")
 
-	address, err := btcutil.DecodeAddress(recipientAddress, account.coin.Net())
+	address, err := DecodeAddress(recipientAddress, account.coin.Net())
 	if err != nil {
-		return nil, nil, errp.WithStack(coin.ErrInvalidAddress)
-	}
-	if !address.IsForNet(account.coin.Net()) {
-		return nil, nil, errp.WithStack(coin.ErrInvalidAddress)
+		return nil, nil, err
 	}
 
 	var feeTarget *FeeTarget
@@ -0,0 +1,194 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transactions
+
+import (
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// txBalance is the effect of a confirmed transaction on the balance of the wallet.
+type txBalance struct {
+	txHash    chainhash.Hash
+	height    int
+	timestamp time.Time
+	delta     int64
+	// balance is the running balance after this tx.
+	balance int64
+}
+
+// balanceHistory caches the effect of each confirmed transaction on the balance, so that the
+// balance history only needs to process transactions which changed since it was last computed.
+type balanceHistory struct {
+	locker.Locker
+
+	// initialized is false until all transactions of the database were processed once.
+	initialized bool
+	txs         map[chainhash.Hash]*txBalance
+	// sorted contains the values of txs, ordered by height.
+	sorted []*txBalance
+	// dirty contains the transactions which changed since they were last processed.
+	dirty map[chainhash.Hash]struct{}
+}
+
+func newBalanceHistory() *balanceHistory {
+	return &balanceHistory{
+		txs:   map[chainhash.Hash]*txBalance{},
+		dirty: map[chainhash.Hash]struct{}{},
+	}
+}
+
+// invalidate marks a transaction to be reprocessed the next time the balance history is computed.
+func (history *balanceHistory) invalidate(txHash chainhash.Hash) {
+	defer history.Lock()()
+	history.dirty[txHash] = struct{}{}
+}
+
+// txBalance computes the effect of a tx on the balance. The result is nil if the tx is unconfirmed
+// or not indexed anymore. The second return value is false if the tx is confirmed, but the header
+// it was confirmed in is not synced yet.
+func (transactions *Transactions) txBalance(
+	dbTx DBTxInterface, txHash chainhash.Hash) (*txBalance, bool) {
+	tx, _, height, timestamp, err := dbTx.TxInfo(txHash)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+	}
+	if tx == nil || height <= 0 {
+		return nil, true
+	}
+	if timestamp == nil {
+		header, err := transactions.headers.HeaderByHeight(height)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve header")
+		}
+		if header == nil {
+			return nil, false
+		}
+		timestamp = &header.Timestamp
+	}
+	var delta int64
+	for _, txIn := range tx.TxIn {
+		spentOut, err := dbTx.Output(txIn.PreviousOutPoint)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve output")
+		}
+		if spentOut != nil {
+			delta -= spentOut.Value
+		}
+	}
+	for index := range tx.TxOut {
+		output, err := dbTx.Output(wire.OutPoint{Hash: txHash, Index: uint32(index)})
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve output")
+		}
+		if output != nil {
+			delta += output.Value
+		}
+	}
+	return &txBalance{
+		txHash:    txHash,
+		height:    height,
+		timestamp: *timestamp,
+		delta:     delta,
+	}, true
+}
+
+// BalanceHistory returns the running balance after each confirmed transaction, ordered by height
+// and stamped with the time of the header the transaction was confirmed in. Transactions whose
+// header is not synced yet are left out until it is.
+//
+// Only the transactions which changed since the previous call are processed again, and the running
+// balance is only recomputed from the lowest height that changed.
+func (transactions *Transactions) BalanceHistory() []*coin.BalancePoint {
+	transactions.synchronizer.WaitSynchronized()
+	defer transactions.RLock()()
+	history := transactions.balanceHistory
+	defer history.Lock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+	if !history.initialized {
+		txHashes, err := dbTx.Transactions()
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve transactions")
+		}
+		for _, txHash := range txHashes {
+			history.dirty[txHash] = struct{}{}
+		}
+		history.initialized = true
+	}
+
+	// The running balances below this height are unaffected by the changes.
+	changedHeight := -1
+	markChanged := func(height int) {
+		if changedHeight == -1 || height < changedHeight {
+			changedHeight = height
+		}
+	}
+	for txHash := range history.dirty {
+		entry, ok := transactions.txBalance(dbTx, txHash)
+		if previous, found := history.txs[txHash]; found {
+			delete(history.txs, txHash)
+			markChanged(previous.height)
+		}
+		if entry != nil {
+			history.txs[txHash] = entry
+			markChanged(entry.height)
+		}
+		if ok {
+			delete(history.dirty, txHash)
+		}
+	}
+
+	if changedHeight != -1 {
+		sorted := make([]*txBalance, 0, len(history.txs))
+		for _, entry := range history.txs {
+			sorted = append(sorted, entry)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].height != sorted[j].height {
+				return sorted[i].height < sorted[j].height
+			}
+			return string(sorted[i].txHash[:]) < string(sorted[j].txHash[:])
+		})
+		start := sort.Search(len(sorted), func(i int) bool {
+			return sorted[i].height >= changedHeight
+		})
+		for index := start; index < len(sorted); index++ {
+			var previousBalance int64
+			if index > 0 {
+				previousBalance = sorted[index-1].balance
+			}
+			sorted[index].balance = previousBalance + sorted[index].delta
+		}
+		history.sorted = sorted
+	}
+
+	result := make([]*coin.BalancePoint, len(history.sorted))
+	for index, entry := range history.sorted {
+		result[index] = &coin.BalancePoint{
+			Time:    entry.timestamp,
+			Balance: coin.NewAmountFromInt64(entry.balance),
+		}
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transactions
+
+import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// isConflicted returns true if the tx is unconfirmed and one of its inputs is recorded as spent by
+// another transaction, or if it spends an output of a conflicted transaction.
+func (transactions *Transactions) isConflicted(
+	dbTx DBTxInterface, txHash chainhash.Hash, tx *wire.MsgTx, height int) bool {
+	return transactions.isConflictedVisited(dbTx, txHash, tx, height, map[chainhash.Hash]struct{}{})
+}
+
+func (transactions *Transactions) isConflictedVisited(
+	dbTx DBTxInterface,
+	txHash chainhash.Hash,
+	tx *wire.MsgTx,
+	height int,
+	visited map[chainhash.Hash]struct{},
+) bool {
+	if height > 0 {
+		return false
+	}
+	if _, ok := visited[txHash]; ok {
+		return false
+	}
+	visited[txHash] = struct{}{}
+	for _, txIn := range tx.TxIn {
+		spender, err := dbTx.Input(txIn.PreviousOutPoint)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve input from previous outpoint")
+		}
+		if spender != nil && *spender != txHash {
+			return true
+		}
+	}
+	for _, txIn := range tx.TxIn {
+		parentHash := txIn.PreviousOutPoint.Hash
+		parent, _, parentHeight, _, err := dbTx.TxInfo(parentHash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+		}
+		if parent != nil &&
+			transactions.isConflictedVisited(dbTx, parentHash, parent, parentHeight, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidateDescendants invalidates the indexed transactions spending the outputs of the tx, and
+// their descendants, as whether they are conflicted depends on the tx.
+func (transactions *Transactions) invalidateDescendants(dbTx DBTxInterface, txHash chainhash.Hash) {
+	visited := map[chainhash.Hash]struct{}{txHash: {}}
+	queue := []chainhash.Hash{txHash}
+	for len(queue) > 0 {
+		txHash := queue[0]
+		queue = queue[1:]
+		tx, _, _, _, err := dbTx.TxInfo(txHash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+		}
+		if tx == nil {
+			continue
+		}
+		for index := range tx.TxOut {
+			spenders, err := dbTx.Spenders(wire.OutPoint{Hash: txHash, Index: uint32(index)})
+			if err != nil {
+				transactions.log.WithError(err).Panic("Failed to retrieve spenders")
+			}
+			for _, spender := range spenders {
+				if _, ok := visited[spender]; ok {
+					continue
+				}
+				visited[spender] = struct{}{}
+				transactions.invalidate(dbTx, spender)
+				queue = append(queue, spender)
+			}
+		}
+	}
+}
+
+// replacesSpender decides which of two transactions spending the same output is recorded as its
+// spender, the other one being conflicted. A confirmed tx replaces an unconfirmed one. Otherwise,
+// the tx which was recorded first is kept, so that processing the same transactions again does not
+// flip the outcome.
+func (transactions *Transactions) replacesSpender(
+	dbTx DBTxInterface, spender chainhash.Hash, txHash chainhash.Hash) bool {
+	spenderTx, _, spenderHeight, _, err := dbTx.TxInfo(spender)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+	}
+	if spenderTx == nil {
+		return true
+	}
+	_, _, height, _, err := dbTx.TxInfo(txHash)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+	}
+	return spenderHeight <= 0 && height > 0
+}
+
+// removeInput removes the input of a tx which is being removed. If another indexed tx spends the
+// same output, it is recorded as the spender instead and is not conflicted anymore.
+func (transactions *Transactions) removeInput(
+	dbTx DBTxInterface, outPoint wire.OutPoint, txHash chainhash.Hash) {
+	spender, err := dbTx.Input(outPoint)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve input from previous outpoint")
+	}
+	if err := dbTx.RemoveSpender(outPoint, txHash); err != nil {
+		transactions.log.WithError(err).Panic("Failed to remove spender")
+	}
+	if spender == nil || *spender != txHash {
+		// The input of a conflicted tx, the output is spent by another one.
+		return
+	}
+	transactions.log.Debug("Deleting transaction input")
+	dbTx.DeleteInput(outPoint)
+
+	spenders, err := dbTx.Spenders(outPoint)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve spenders")
+	}
+	var replacement *chainhash.Hash
+	for _, otherTxHash := range spenders {
+		if otherTxHash == txHash {
+			continue
+		}
+		if replacement == nil || transactions.replacesSpender(dbTx, *replacement, otherTxHash) {
+			otherTxHash := otherTxHash
+			replacement = &otherTxHash
+		}
+	}
+	if replacement == nil {
+		return
+	}
+	if err := dbTx.PutInput(outPoint, *replacement); err != nil {
+		transactions.log.WithError(err).Panic("Failed to store the transaction input")
+	}
+	transactions.invalidate(dbTx, *replacement)
+	transactions.invalidateDescendants(dbTx, *replacement)
+}
@@ -52,12 +52,21 @@ type DBTxInterface interface {
 	// UnverifiedTransactions retrieves all stored transaction hashes of unverified transactions.
 	UnverifiedTransactions() ([]chainhash.Hash, error)
 
-	// MarkTxVerified marks a tx as verified. Stores timestamp of the header this tx appears in.
-	MarkTxVerified(txHash chainhash.Hash, headerTimestamp time.Time) error
+	// MarkTxVerified marks a tx as verified. Stores the hash and timestamp of the header this tx
+	// appears in.
+	MarkTxVerified(txHash chainhash.Hash, headerHash chainhash.Hash, headerTimestamp time.Time) error
+
+	// MarkTxUnverified marks a tx as unverified, e.g. because the header it was verified against
+	// was reorganized away. The header timestamp and hash are cleared.
+	MarkTxUnverified(txHash chainhash.Hash) error
+
+	// TxHeaderHash retrieves the hash of the header the tx was verified against. nil is returned if
+	// the tx is not verified, or was verified before the header hash was stored.
+	TxHeaderHash(txHash chainhash.Hash) (*chainhash.Hash, error)
 
 	// PutInput stores a transaction input. It is referenced by output it spends. The transaction
-	// hash of the transaction this input was found in is recorded. TODO: store slice of inputs
-	// along with the txhash they appear in. If there are more than one, a double spend is detected.
+	// hash of the transaction this input was found in is recorded. If several transactions spend
+	// the output, see AddSpender, the one which is not conflicted is recorded.
 	PutInput(wire.OutPoint, chainhash.Hash) error
 
 	// Input retrieves an input. `nil, nil` is returned if not found.
@@ -66,6 +75,18 @@ type DBTxInterface interface {
 	// DeleteInput deletes an input (nothing happens if not found).
 	DeleteInput(wire.OutPoint)
 
+	// AddSpender records that the tx spends the given output. All transactions spending an output
+	// are recorded, so that a double spend is detected. Returns false if the tx was already
+	// recorded.
+	AddSpender(wire.OutPoint, chainhash.Hash) (bool, error)
+
+	// RemoveSpender removes a tx recorded with AddSpender.
+	RemoveSpender(wire.OutPoint, chainhash.Hash) error
+
+	// Spenders returns the transactions recorded with AddSpender, in the order they were
+	// recorded.
+	Spenders(wire.OutPoint) ([]chainhash.Hash, error)
+
 	// PutOutput stores an Output.
 	PutOutput(wire.OutPoint, *wire.TxOut) error
 
@@ -81,6 +102,36 @@ type DBTxInterface interface {
 
 	// AddressHistory retrieves an address history. If not found, returns an empty history.
 	AddressHistory(blockchain.ScriptHashHex) (blockchain.TxHistory, error)
+
+	// MarkTxIndexStale marks the index entry of a tx to be recomputed, see StaleTxIndexEntries().
+	MarkTxIndexStale(chainhash.Hash) error
+
+	// StaleTxIndexEntries retrieves the hashes of the transactions whose index entry needs to be
+	// recomputed.
+	StaleTxIndexEntries() ([]chainhash.Hash, error)
+
+	// PutTxIndexEntry stores the index entry of a tx, replacing the previous one, and clears its
+	// stale mark.
+	PutTxIndexEntry(*TxIndexEntry) error
+
+	// DeleteTxIndexEntry deletes the index entry of a tx (nothing happens if not found) and clears
+	// its stale mark.
+	DeleteTxIndexEntry(chainhash.Hash) error
+
+	// ForEachTxIndexEntry calls f for the index entries ordered by height, unconfirmed transactions
+	// last, or in reverse if descending is true. The iteration starts after the entry with the given
+	// cursor, or at the beginning if the cursor is nil. It stops when f returns false. The cursor
+	// passed to f can be used to continue after the entry.
+	ForEachTxIndexEntry(
+		descending bool, cursor []byte, f func(cursor []byte, entry *TxIndexEntry) bool) error
+
+	// TxIndexEntry retrieves the index entry of a tx and its cursor, see ForEachTxIndexEntry. Returns
+	// nil if not found.
+	TxIndexEntry(chainhash.Hash) ([]byte, *TxIndexEntry, error)
+
+	// TxIndexAddressTransactions retrieves the hashes of the transactions whose index entry lists
+	// the address, see TxIndexEntry.Addresses.
+	TxIndexAddressTransactions(address string) ([]chainhash.Hash, error)
 }
 
 // DBInterface can be implemented by database backends to open database transactions.
@@ -15,7 +15,6 @@
 package transactions
 
 import (
-	"sort"
 	"time"
 
 	btcdBlockchain "github.com/btcsuite/btcd/blockchain"
@@ -48,6 +47,20 @@ func getScriptHashHex(txOut *wire.TxOut) blockchain.ScriptHashHex {
 	return blockchain.ScriptHashHex(chainhash.HashH(txOut.PkScript).String())
 }
 
+// Event instances are sent to the onEvent callback of the transactions.
+type Event string
+
+const (
+	// EventTxsUnverified is fired when verified transactions were unverified because the header
+	// they were verified against was reorganized away. They are verified again against the new
+	// chain once the headers are synced.
+	EventTxsUnverified Event = "txsUnverified"
+	// EventTxConflicted is fired when a double spend is detected, i.e. an unconfirmed transaction
+	// spends an input which is also spent by another transaction. The conflicted transaction is not
+	// part of the balance.
+	EventTxConflicted Event = "txConflicted"
+)
+
 // Transactions handles wallet transactions: keeping an index of the transactions, inputs, (unspent)
 // outputs, etc.
 type Transactions struct {
@@ -64,8 +77,11 @@ type Transactions struct {
 
 	unsubscribeHeadersEvent func()
 
+	balanceHistory *balanceHistory
+
 	synchronizer *synchronizer.Synchronizer
 	blockchain   blockchain.Interface
+	onEvent      func(Event)
 	log          *logrus.Entry
 }
 
@@ -76,6 +92,7 @@ func NewTransactions(
 	headers headers.Interface,
 	synchronizer *synchronizer.Synchronizer,
 	blockchain blockchain.Interface,
+	onEvent func(Event),
 	log *logrus.Entry,
 ) *Transactions {
 	transactions := &Transactions{
@@ -86,8 +103,11 @@ func NewTransactions(
 
 		headersTipHeight: headers.TipHeight(),
 
+		balanceHistory: newBalanceHistory(),
+
 		synchronizer: synchronizer,
 		blockchain:   blockchain,
+		onEvent:      onEvent,
 		log:          log.WithFields(logrus.Fields{"group": "transactions", "net": net.Name}),
 	}
 	transactions.unsubscribeHeadersEvent = headers.SubscribeEvent(transactions.onHeadersEvent)
@@ -99,6 +119,28 @@ func (transactions *Transactions) Close() {
 	transactions.unsubscribeHeadersEvent()
 }
 
+// invalidate is called when a tx or its relation to the wallet changed. It is processed again the
+// next time the balance history is computed, and reindexed the next time the transactions are
+// queried.
+func (transactions *Transactions) invalidate(dbTx DBTxInterface, txHash chainhash.Hash) {
+	transactions.balanceHistory.invalidate(txHash)
+	if err := dbTx.MarkTxIndexStale(txHash); err != nil {
+		transactions.log.WithError(err).Panic("Failed to mark the tx index stale")
+	}
+}
+
+// invalidateSpender invalidates the transaction spending the given output, as its effect on the
+// balance and its type depend on whether the output is ours.
+func (transactions *Transactions) invalidateSpender(dbTx DBTxInterface, outPoint wire.OutPoint) {
+	spender, err := dbTx.Input(outPoint)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve input for outPoint")
+	}
+	if spender != nil {
+		transactions.invalidate(dbTx, *spender)
+	}
+}
+
 func (transactions *Transactions) txInHistory(
 	dbTx DBTxInterface, scriptHashHex blockchain.ScriptHashHex, txHash chainhash.Hash) bool {
 	history, err := dbTx.AddressHistory(scriptHashHex)
@@ -130,9 +172,10 @@ func (transactions *Transactions) processTxForAddress(
 	if err := dbTx.PutTx(txHash, tx, height); err != nil {
 		transactions.log.WithError(err).Panic("Failed to put tx")
 	}
+	transactions.invalidate(dbTx, txHash)
 
-	// Newly confirmed tx. Try to verify it.
-	if previousHeight <= 0 && height > 0 {
+	// Newly confirmed tx, or confirmed in another block after a reorg. Try to verify it.
+	if height > 0 && previousHeight != height {
 		transactions.log.Debug("Try to verify newly confirmed tx")
 		go transactions.verifyTransaction(txHash, height)
 	}
@@ -155,14 +198,28 @@ func (transactions *Transactions) processInputsAndOutputsForAddress(
 		// multiple times for different addresses, we index all inputs, even those that didn't
 		// originate from our wallet. At this stage we don't know if it is one of our own inputs,
 		// since the output that it spends might be indexed later.
+		newSpender, err := dbTx.AddSpender(txIn.PreviousOutPoint, txHash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to add spender")
+		}
 		txInTxHash, err := dbTx.Input(txIn.PreviousOutPoint)
 		if err != nil {
 			transactions.log.WithError(err).Panic("Failed to retrieve input from previous outpoint")
 		}
 		if txInTxHash != nil && *txInTxHash != txHash {
-			transactions.log.WithFields(logrus.Fields{"txIn.PreviousOutPoint": txIn.PreviousOutPoint,
-				"txInTxHash": txInTxHash, "txHash": txHash}).
-				Warning("Double spend detected")
+			// The event is fired only once per double spend, not each time the tx is processed.
+			if newSpender {
+				transactions.log.WithFields(logrus.Fields{"txIn.PreviousOutPoint": txIn.PreviousOutPoint,
+					"txInTxHash": txInTxHash, "txHash": txHash}).
+					Warning("Double spend detected")
+				transactions.onEvent(EventTxConflicted)
+			}
+			transactions.invalidate(dbTx, *txInTxHash)
+			transactions.invalidateDescendants(dbTx, *txInTxHash)
+			transactions.invalidateDescendants(dbTx, txHash)
+			if !transactions.replacesSpender(dbTx, *txInTxHash, txHash) {
+				continue
+			}
 		}
 		if err := dbTx.PutInput(txIn.PreviousOutPoint, txHash); err != nil {
 			transactions.log.WithError(err).Panic("Failed to store the transaction input")
@@ -172,13 +229,12 @@ func (transactions *Transactions) processInputsAndOutputsForAddress(
 	for index, txOut := range tx.TxOut {
 		// Check if output is ours.
 		if getScriptHashHex(txOut) == scriptHashHex {
-			err := dbTx.PutOutput(
-				wire.OutPoint{Hash: txHash, Index: uint32(index)},
-				txOut,
-			)
+			outPoint := wire.OutPoint{Hash: txHash, Index: uint32(index)}
+			err := dbTx.PutOutput(outPoint, txOut)
 			if err != nil {
 				transactions.log.WithError(err).Panic("Failed to store the transaction output")
 			}
+			transactions.invalidateSpender(dbTx, outPoint)
 		}
 	}
 }
@@ -222,7 +278,8 @@ func (transactions *Transactions) SpendableOutputs() map[wire.OutPoint]*Spendabl
 		confirmed := height > 0
 
 		spent := transactions.isInputSpent(dbTx, outPoint)
-		if !spent && (confirmed || transactions.allInputsOurs(dbTx, tx)) {
+		conflicted := transactions.isConflicted(dbTx, outPoint.Hash, tx, height)
+		if !spent && !conflicted && (confirmed || transactions.allInputsOurs(dbTx, tx)) {
 			result[outPoint] = &SpendableOutput{
 				TxOut:   txOut,
 				Address: transactions.outputToAddress(txOut.PkScript),
@@ -258,20 +315,22 @@ func (transactions *Transactions) removeTxForAddress(
 	if err != nil {
 		transactions.log.WithError(err).Panic("Failed to remove address from tx")
 	}
+	transactions.invalidate(dbTx, txHash)
 	if empty {
 		// Tx is not touching any of our outputs anymore. Remove.
 
 		for _, txIn := range tx.TxIn {
-			transactions.log.Debug("Deleting transaction iput")
-			dbTx.DeleteInput(txIn.PreviousOutPoint)
+			transactions.removeInput(dbTx, txIn.PreviousOutPoint, txHash)
 		}
 
 		// Remove the outputs added by this tx.
 		for index := range tx.TxOut {
-			dbTx.DeleteOutput(wire.OutPoint{
+			outPoint := wire.OutPoint{
 				Hash:  txHash,
 				Index: uint32(index),
-			})
+			}
+			transactions.invalidateSpender(dbTx, outPoint)
+			dbTx.DeleteOutput(outPoint)
 		}
 
 		dbTx.DeleteTx(txHash)
@@ -400,6 +459,9 @@ func (transactions *Transactions) Balance() *Balance {
 		if err != nil {
 			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
 		}
+		if transactions.isConflicted(dbTx, outPoint.Hash, tx, height) {
+			continue
+		}
 		confirmed := height > 0
 		if confirmed || transactions.allInputsOurs(dbTx, tx) {
 			available += txOut.Value
@@ -413,21 +475,45 @@ func (transactions *Transactions) Balance() *Balance {
 	}
 }
 
-// byHeight defines the methods needed to satisify sort.Interface to sort transactions by their
-// height. Special case for unconfirmed transactions (height <=0), which come last.
-type byHeight []*TxInfo
-
-func (s byHeight) Len() int { return len(s) }
-func (s byHeight) Less(i, j int) bool {
-	if s[j].Height <= 0 {
-		return true
+// Received sums up the outputs paying to the address with the given script hash, split into
+// confirmed and unconfirmed funds. Outputs of conflicted transactions are left out. Spending the
+// outputs does not reduce the sums.
+func (transactions *Transactions) Received(
+	scriptHashHex blockchain.ScriptHashHex) (btcutil.Amount, btcutil.Amount) {
+	transactions.synchronizer.WaitSynchronized()
+	defer transactions.RLock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+	history, err := dbTx.AddressHistory(scriptHashHex)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to get address history")
 	}
-	if s[i].Height <= 0 {
-		return false
+	var confirmed, unconfirmed btcutil.Amount
+	for _, entry := range history {
+		txHash := entry.TXHash.Hash()
+		tx, _, height, _, err := dbTx.TxInfo(txHash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+		}
+		if tx == nil || transactions.isConflicted(dbTx, txHash, tx, height) {
+			continue
+		}
+		for _, txOut := range tx.TxOut {
+			if getScriptHashHex(txOut) != scriptHashHex {
+				continue
+			}
+			if height > 0 {
+				confirmed += btcutil.Amount(txOut.Value)
+			} else {
+				unconfirmed += btcutil.Amount(txOut.Value)
+			}
+		}
 	}
-	return s[i].Height < s[j].Height
+	return confirmed, unconfirmed
 }
-func (s byHeight) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 
 // TxType is a type of transaction. See the TxType* constants.
 type TxType string
@@ -465,6 +551,15 @@ type TxInfo struct {
 	Timestamp *time.Time
 	// Addresses money was sent to / received on (without change addresses).
 	Addresses []string
+	// Verified is true if the tx was verified to be in the header chain. It is reset if the header
+	// is reorganized away.
+	Verified bool
+	// Conflicted is true for an unconfirmed tx spending an output which is also spent by another
+	// transaction, or spending an output of a conflicted tx. Conflicted transactions are not part of
+	// the balance.
+	Conflicted bool
+	// Label is the label given to the tx by the user.
+	Label string
 }
 
 // FeeRatePerKb returns the fee rate of the tx (fee / tx size).
@@ -492,7 +587,6 @@ func (transactions *Transactions) txInfo(
 	height int,
 	timestamp *time.Time,
 	isChange func(blockchain.ScriptHashHex) bool) *TxInfo {
-	defer transactions.RLock()()
 	var sumOurInputs btcutil.Amount
 	var result btcutil.Amount
 	allInputsOurs := true
@@ -558,51 +652,39 @@ func (transactions *Transactions) txInfo(
 		addresses = receiveAddresses
 		result = sumOurReceive + sumOurChange - sumOurInputs
 	}
-	numConfirmations := 0
-	if height > 0 && transactions.headersTipHeight > 0 {
-		numConfirmations = transactions.headersTipHeight - height + 1
-	}
 	btcutilTx := btcutil.NewTx(tx)
 	return &TxInfo{
 		Tx:               tx,
 		VSize:            mempool.GetTxVirtualSize(btcutilTx),
 		Size:             int64(tx.SerializeSize()),
 		Weight:           btcdBlockchain.GetTransactionWeight(btcutilTx),
-		NumConfirmations: numConfirmations,
+		NumConfirmations: transactions.numConfirmations(height),
 		Height:           height,
 		Type:             txType,
 		Amount:           result,
 		Fee:              feeP,
 		Timestamp:        timestamp,
 		Addresses:        addresses,
+		Verified:         timestamp != nil,
+		Conflicted:       transactions.isConflicted(dbTx, tx.TxHash(), tx, height),
+	}
+}
+
+func (transactions *Transactions) numConfirmations(height int) int {
+	if height > 0 && transactions.headersTipHeight > 0 {
+		return transactions.headersTipHeight - height + 1
 	}
+	return 0
 }
 
-// Transactions returns an ordered list of transactions.
+// Transactions returns an ordered list of transactions, the unconfirmed ones first, followed by the
+// newest ones. Their labels are not set, see QueryTransactions.
 func (transactions *Transactions) Transactions(
 	isChange func(blockchain.ScriptHashHex) bool) []*TxInfo {
-	transactions.synchronizer.WaitSynchronized()
-	defer transactions.RLock()()
-	dbTx, err := transactions.db.Begin()
-	if err != nil {
-		// TODO
-		panic(err)
-	}
-	defer dbTx.Rollback()
-	txs := []*TxInfo{}
-	txHashes, err := dbTx.Transactions()
+	result, err := transactions.QueryTransactions(&TxQuery{}, isChange, nil)
 	if err != nil {
 		// TODO
 		panic(err)
 	}
-	for _, txHash := range txHashes {
-		tx, _, height, timestamp, err := dbTx.TxInfo(txHash)
-		if err != nil {
-			// TODO
-			panic(err)
-		}
-		txs = append(txs, transactions.txInfo(dbTx, tx, height, timestamp, isChange))
-	}
-	sort.Sort(sort.Reverse(byHeight(txs)))
-	return txs
+	return result.Transactions
 }
@@ -16,6 +16,7 @@ package transactions_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -25,11 +26,13 @@ import (
 	addressesTest "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses/test"
 	blockchainpkg "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	blockchainMock "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain/mocks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
 	headersMock "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers/mocks"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/synchronizer"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/transactionsdb"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
 	"github.com/sirupsen/logrus"
@@ -108,6 +111,8 @@ type transactionsSuite struct {
 	blockchainMock *BlockchainMock
 	headersMock    *headersMock.Interface
 	transactions   *transactions.Transactions
+	onHeadersEvent func(headers.Event)
+	events         chan transactions.Event
 
 	log *logrus.Entry
 }
@@ -119,12 +124,15 @@ func (s *transactionsSuite) SetupTest() {
 	_, s.addressChain = addressesTest.NewAddressChain()
 	s.synchronizer = synchronizer.NewSynchronizer(func() {}, func() {}, s.log)
 	s.blockchainMock = NewBlockchainMock()
-	db, err := transactionsdb.NewDB(test.TstTempFile("bitbox-wallet-db-"))
+	db, err := transactionsdb.NewDB(test.TstTempFile("bitbox-wallet-db-"), nil)
 	if err != nil {
 		panic(err)
 	}
 	s.headersMock = &headersMock.Interface{}
-	s.headersMock.On("SubscribeEvent", mock.AnythingOfType("func(headers.Event)")).Return(func() {})
+	s.headersMock.On("SubscribeEvent", mock.AnythingOfType("func(headers.Event)")).Run(
+		func(args mock.Arguments) { s.onHeadersEvent = args.Get(0).(func(headers.Event)) },
+	).Return(func() {})
+	s.events = make(chan transactions.Event, 100)
 	s.headersMock.On("TipHeight").Return(15).Once()
 	s.transactions = transactions.NewTransactions(
 		s.net,
@@ -132,6 +140,7 @@ func (s *transactionsSuite) SetupTest() {
 		s.headersMock,
 		s.synchronizer,
 		s.blockchainMock,
+		func(event transactions.Event) { s.events <- event },
 		s.log,
 	)
 }
@@ -387,6 +396,35 @@ func (s *transactionsSuite) TestBalance() {
 		s.transactions.Balance())
 }
 
+func (s *transactionsSuite) TestReceived() {
+	addresses := s.addressChain.EnsureAddresses()
+	address := addresses[0]
+	otherAddress := addresses[1]
+	tx1 := newTx(chainhash.HashH(nil), 0, address, 123)
+	tx2 := newTx(chainhash.HashH(nil), 1, address, 456)
+	tx1Spend := newTx(tx1.TxHash(), 0, otherAddress, 123)
+	s.blockchainMock.RegisterTxs(tx1, tx2, tx1Spend)
+	received := func() []btcutil.Amount {
+		confirmed, unconfirmed := s.transactions.Received(address.PubkeyScriptHashHex())
+		return []btcutil.Amount{confirmed, unconfirmed}
+	}
+	require.Equal(s.T(), []btcutil.Amount{0, 0}, received())
+	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(tx2.TxHash()), Height: 0},
+	})
+	require.Equal(s.T(), []btcutil.Amount{123, 456}, received())
+	// Spending the received funds does not reduce the received amounts.
+	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(tx2.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(tx1Spend.TxHash()), Height: 0},
+	})
+	require.Equal(s.T(), []btcutil.Amount{123, 456}, received())
+}
+
 func (s *transactionsSuite) TestRemoveTransaction() {
 	addresses := s.addressChain.EnsureAddresses()
 	address1 := addresses[0]
@@ -452,3 +490,376 @@ func (s *transactionsSuite) TestRemoveTransactionPendingDownload() {
 	require.Empty(s.T(),
 		s.transactions.Transactions(func(blockchainpkg.ScriptHashHex) bool { return false }))
 }
+
+func (s *transactionsSuite) requireBalanceHistory(expected map[int]int64, heights ...int) {
+	history := s.transactions.BalanceHistory()
+	require.Len(s.T(), history, len(heights))
+	for index, height := range heights {
+		require.Equal(s.T(), time.Unix(int64(height)*600, 0), history[index].Time)
+		require.Equal(s.T(), coin.NewAmountFromInt64(expected[height]), history[index].Balance)
+	}
+}
+
+// TestBalanceHistory checks that the balance history follows confirmations, transactions
+// appearing below already processed ones and removed transactions.
+func (s *transactionsSuite) TestBalanceHistory() {
+	s.headersMock.On("HeaderByHeight", mock.AnythingOfType("int")).Return(
+		func(height int) *wire.BlockHeader {
+			return &wire.BlockHeader{Timestamp: time.Unix(int64(height)*600, 0)}
+		}, nil)
+	s.blockchainMock.On("GetMerkle", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(
+		func(args mock.Arguments) { args.Get(3).(func())() })
+
+	require.Empty(s.T(), s.transactions.BalanceHistory())
+	addresses := s.addressChain.EnsureAddresses()
+	address := addresses[0]
+	otherAddress := addresses[2]
+	tx1 := newTx(chainhash.HashH(nil), 0, address, 100)
+	tx2 := newTx(chainhash.HashH(nil), 1, address, 50)
+	tx1Spend := newTx(tx1.TxHash(), 0, otherAddress, 100)
+	tx3 := newTx(chainhash.HashH(nil), 2, address, 7)
+	s.blockchainMock.RegisterTxs(tx1, tx2, tx1Spend, tx3)
+
+	// Unconfirmed transactions are not part of the history.
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(tx2.TxHash()), Height: 0},
+	})
+	s.requireBalanceHistory(map[int]int64{10: 100}, 10)
+
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(tx2.TxHash()), Height: 11},
+		{TXHash: blockchainpkg.TXHash(tx1Spend.TxHash()), Height: 12},
+	})
+	s.requireBalanceHistory(map[int]int64{10: 100, 11: 150, 12: 50}, 10, 11, 12)
+
+	// A transaction below the already processed ones shifts all later balances.
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx3.TxHash()), Height: 5},
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(tx2.TxHash()), Height: 11},
+		{TXHash: blockchainpkg.TXHash(tx1Spend.TxHash()), Height: 12},
+	})
+	s.requireBalanceHistory(map[int]int64{5: 7, 10: 107, 11: 157, 12: 57}, 5, 10, 11, 12)
+
+	// Removing the spend restores the balance.
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx3.TxHash()), Height: 5},
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(tx2.TxHash()), Height: 11},
+	})
+	s.requireBalanceHistory(map[int]int64{5: 7, 10: 107, 11: 157}, 5, 10, 11)
+}
+
+// TestReorg simulates reorgs through the headers mock. A verified tx whose block is reorganized
+// away is unverified, and verified again against the new chain.
+func (s *transactionsSuite) TestReorg() {
+	address := s.addressChain.EnsureAddresses()[0]
+	tx := newTx(chainhash.HashH(nil), 0, address, 123)
+	s.blockchainMock.RegisterTxs(tx)
+
+	chainLock := locker.Locker{}
+	// The tx is the only one in the block, so the merkle root is its hash.
+	chain := map[int]*wire.BlockHeader{10: {MerkleRoot: tx.TxHash(), Nonce: 1}}
+	s.headersMock.On("HeaderByHeight", mock.AnythingOfType("int")).Return(
+		func(height int) *wire.BlockHeader {
+			defer chainLock.RLock()()
+			return chain[height]
+		}, nil)
+	merkleRequests := make(chan struct{}, 10)
+	s.blockchainMock.On("GetMerkle", tx.TxHash(), 10, mock.Anything, mock.Anything).Run(
+		func(args mock.Arguments) {
+			success := args.Get(2).(func([]blockchainpkg.TXHash, int) error)
+			require.NoError(s.T(), success(nil, 0))
+			args.Get(3).(func())()
+			merkleRequests <- struct{}{}
+		})
+	isVerified := func() bool {
+		txs := s.transactions.Transactions(func(blockchainpkg.ScriptHashHex) bool { return false })
+		require.Len(s.T(), txs, 1)
+		return txs[0].Verified
+	}
+
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx.TxHash()), Height: 10},
+	})
+	<-merkleRequests
+	require.True(s.T(), isVerified())
+
+	// A reorg which does not touch the block keeps the tx verified.
+	s.onHeadersEvent(headers.EventReorg)
+	require.True(s.T(), isVerified())
+	require.Empty(s.T(), s.events)
+
+	// The block is replaced by another one containing the tx.
+	func() {
+		defer chainLock.Lock()()
+		chain[10] = &wire.BlockHeader{MerkleRoot: tx.TxHash(), Nonce: 2}
+	}()
+	s.onHeadersEvent(headers.EventReorg)
+	require.Equal(s.T(), transactions.EventTxsUnverified, <-s.events)
+	require.False(s.T(), isVerified())
+	s.onHeadersEvent(headers.EventSynced)
+	<-merkleRequests
+	require.True(s.T(), isVerified())
+
+	// The chain was rolled back below the block and is not synced up to it again yet.
+	func() {
+		defer chainLock.Lock()()
+		delete(chain, 10)
+	}()
+	s.onHeadersEvent(headers.EventReorg)
+	require.Equal(s.T(), transactions.EventTxsUnverified, <-s.events)
+	require.False(s.T(), isVerified())
+	s.onHeadersEvent(headers.EventSynced)
+	require.False(s.T(), isVerified())
+}
+
+// TestConflicts checks that an unconfirmed tx spending an output which is also spent by another tx
+// is conflicted and not part of the balance.
+func (s *transactionsSuite) TestConflicts() {
+	s.headersMock.On("HeaderByHeight", mock.AnythingOfType("int")).Return(nil, nil)
+	addresses := s.addressChain.EnsureAddresses()
+	address := addresses[0]
+	otherAddress := addresses[2]
+	tx1 := newTx(chainhash.HashH(nil), 0, address, 100)
+	// txA sends the funds back to the wallet, txB sends them away.
+	txA := newTx(tx1.TxHash(), 0, address, 90)
+	txB := newTx(tx1.TxHash(), 0, otherAddress, 80)
+	s.blockchainMock.RegisterTxs(tx1, txA, txB)
+	conflicted := func() map[chainhash.Hash]bool {
+		result := map[chainhash.Hash]bool{}
+		txs := s.transactions.Transactions(func(blockchainpkg.ScriptHashHex) bool { return false })
+		for _, txInfo := range txs {
+			result[txInfo.Tx.TxHash()] = txInfo.Conflicted
+		}
+		return result
+	}
+
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txA.TxHash()), Height: 0},
+	})
+	require.Equal(s.T(), newBalance(90, 0), s.transactions.Balance())
+
+	// txB double spends the output of tx1. txA was seen first and is kept.
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txA.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(txB.TxHash()), Height: 0},
+	})
+	require.Equal(s.T(), transactions.EventTxConflicted, <-s.events)
+	require.Equal(s.T(),
+		map[chainhash.Hash]bool{tx1.TxHash(): false, txA.TxHash(): false, txB.TxHash(): true},
+		conflicted())
+	require.Equal(s.T(), newBalance(90, 0), s.transactions.Balance())
+
+	// txB is confirmed, so txA is conflicted and its output is not part of the balance anymore.
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txA.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(txB.TxHash()), Height: 11},
+	})
+	// The double spend was already reported.
+	require.Empty(s.T(), s.events)
+	require.Equal(s.T(),
+		map[chainhash.Hash]bool{tx1.TxHash(): false, txA.TxHash(): true, txB.TxHash(): false},
+		conflicted())
+	require.Equal(s.T(), newBalance(0, 0), s.transactions.Balance())
+	require.Empty(s.T(), s.transactions.SpendableOutputs())
+
+	// txA disappears from the history.
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txB.TxHash()), Height: 11},
+	})
+	require.Equal(s.T(),
+		map[chainhash.Hash]bool{tx1.TxHash(): false, txB.TxHash(): false},
+		conflicted())
+	require.Equal(s.T(), newBalance(0, 0), s.transactions.Balance())
+}
+
+// TestConflictedDescendants checks that a tx spending an output of a conflicted tx is conflicted as
+// well.
+func (s *transactionsSuite) TestConflictedDescendants() {
+	s.headersMock.On("HeaderByHeight", mock.AnythingOfType("int")).Return(nil, nil)
+	addresses := s.addressChain.EnsureAddresses()
+	address := addresses[0]
+	otherAddress := addresses[2]
+	tx1 := newTx(chainhash.HashH(nil), 0, address, 100)
+	txA := newTx(tx1.TxHash(), 0, address, 90)
+	txAChild := newTx(txA.TxHash(), 0, address, 85)
+	txB := newTx(tx1.TxHash(), 0, otherAddress, 80)
+	s.blockchainMock.RegisterTxs(tx1, txA, txAChild, txB)
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txA.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(txAChild.TxHash()), Height: 0},
+	})
+	require.Equal(s.T(), newBalance(85, 0), s.transactions.Balance())
+
+	// txB double spends the output of tx1 and is confirmed, so txA and its child are conflicted.
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txA.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(txAChild.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(txB.TxHash()), Height: 11},
+	})
+	require.Equal(s.T(), transactions.EventTxConflicted, <-s.events)
+	txs := s.transactions.Transactions(func(blockchainpkg.ScriptHashHex) bool { return false })
+	require.Len(s.T(), txs, 4)
+	for _, txInfo := range txs {
+		txHash := txInfo.Tx.TxHash()
+		require.Equal(s.T(), txHash == txA.TxHash() || txHash == txAChild.TxHash(), txInfo.Conflicted)
+	}
+	require.Equal(s.T(), newBalance(0, 0), s.transactions.Balance())
+	require.Empty(s.T(), s.transactions.SpendableOutputs())
+}
+
+// TestConflictReplaced checks that the conflicted tx takes over when the tx it conflicts with is
+// removed.
+func (s *transactionsSuite) TestConflictReplaced() {
+	s.headersMock.On("HeaderByHeight", mock.AnythingOfType("int")).Return(nil, nil)
+	address := s.addressChain.EnsureAddresses()[0]
+	tx1 := newTx(chainhash.HashH(nil), 0, address, 100)
+	txA := newTx(tx1.TxHash(), 0, address, 90)
+	txB := newTx(tx1.TxHash(), 0, address, 80)
+	s.blockchainMock.RegisterTxs(tx1, txA, txB)
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txA.TxHash()), Height: 0},
+	})
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txA.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(txB.TxHash()), Height: 0},
+	})
+	require.Equal(s.T(), transactions.EventTxConflicted, <-s.events)
+	require.Equal(s.T(), newBalance(90, 0), s.transactions.Balance())
+
+	// txA was replaced by txB.
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txB.TxHash()), Height: 0},
+	})
+	txs := s.transactions.Transactions(func(blockchainpkg.ScriptHashHex) bool { return false })
+	require.Len(s.T(), txs, 2)
+	for _, txInfo := range txs {
+		require.False(s.T(), txInfo.Conflicted)
+	}
+	require.Equal(s.T(), newBalance(80, 0), s.transactions.Balance())
+}
+
+func (s *transactionsSuite) requireQuery(
+	query *transactions.TxQuery, labels map[chainhash.Hash]string, expected ...*wire.MsgTx) string {
+	result, err := s.transactions.QueryTransactions(
+		query, func(blockchainpkg.ScriptHashHex) bool { return false }, labels)
+	require.NoError(s.T(), err)
+	txHashes := []chainhash.Hash{}
+	for _, txInfo := range result.Transactions {
+		txHashes = append(txHashes, txInfo.Tx.TxHash())
+	}
+	expectedTxHashes := []chainhash.Hash{}
+	for _, tx := range expected {
+		expectedTxHashes = append(expectedTxHashes, tx.TxHash())
+	}
+	require.Equal(s.T(), expectedTxHashes, txHashes)
+	return result.NextCursor
+}
+
+// TestQueryTransactions checks the filters, the order and the pagination of transaction queries,
+// and that the index follows changes of the transactions.
+func (s *transactionsSuite) TestQueryTransactions() {
+	addresses := s.addressChain.EnsureAddresses()
+	address := addresses[0]
+	otherAddress := addresses[2]
+	tx1 := newTx(chainhash.HashH(nil), 0, address, 100)
+	tx2 := newTx(chainhash.HashH(nil), 1, otherAddress, 50)
+	tx3 := newTx(chainhash.HashH(nil), 2, address, 7)
+	s.blockchainMock.RegisterTxs(tx1, tx2, tx3)
+
+	// Each tx is the only one in its block, so the merkle root is its hash.
+	chain := map[int]*wire.BlockHeader{}
+	for height, tx := range map[int]*wire.MsgTx{10: tx1, 11: tx2, 12: tx3} {
+		chain[height] = &wire.BlockHeader{
+			MerkleRoot: tx.TxHash(),
+			Timestamp:  time.Unix(int64(height)*600, 0),
+		}
+	}
+	s.headersMock.On("HeaderByHeight", mock.AnythingOfType("int")).Return(
+		func(height int) *wire.BlockHeader { return chain[height] }, nil)
+	merkleRequests := make(chan struct{}, 10)
+	s.blockchainMock.On("GetMerkle", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(
+		func(args mock.Arguments) {
+			success := args.Get(2).(func([]blockchainpkg.TXHash, int) error)
+			require.NoError(s.T(), success(nil, 0))
+			args.Get(3).(func())()
+			merkleRequests <- struct{}{}
+		})
+
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(tx3.TxHash()), Height: 0},
+	})
+	s.updateAddressHistory(otherAddress, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx2.TxHash()), Height: 11},
+	})
+	<-merkleRequests
+	<-merkleRequests
+
+	s.requireQuery(&transactions.TxQuery{}, nil, tx3, tx2, tx1)
+	s.requireQuery(&transactions.TxQuery{Ascending: true}, nil, tx1, tx2, tx3)
+
+	// Pagination.
+	cursor := s.requireQuery(&transactions.TxQuery{Limit: 2}, nil, tx3, tx2)
+	require.NotEmpty(s.T(), cursor)
+	require.Empty(s.T(), s.requireQuery(&transactions.TxQuery{Limit: 2, Cursor: cursor}, nil, tx1))
+	cursor = s.requireQuery(&transactions.TxQuery{Limit: 1, Ascending: true}, nil, tx1)
+	s.requireQuery(&transactions.TxQuery{Ascending: true, Cursor: cursor}, nil, tx2, tx3)
+	_, err := s.transactions.QueryTransactions(
+		&transactions.TxQuery{Cursor: "xyz"}, func(blockchainpkg.ScriptHashHex) bool { return false }, nil)
+	require.Error(s.T(), err)
+
+	// Filters.
+	s.requireQuery(&transactions.TxQuery{Status: transactions.TxStatusUnconfirmed}, nil, tx3)
+	s.requireQuery(&transactions.TxQuery{Status: transactions.TxStatusConfirmed}, nil, tx2, tx1)
+	from := time.Unix(11*600, 0)
+	s.requireQuery(&transactions.TxQuery{From: &from}, nil, tx2)
+	s.requireQuery(&transactions.TxQuery{To: &from}, nil, tx1)
+	minAmount, maxAmount := btcutil.Amount(50), btcutil.Amount(99)
+	s.requireQuery(&transactions.TxQuery{MinAmount: &minAmount}, nil, tx2, tx1)
+	s.requireQuery(&transactions.TxQuery{MinAmount: &minAmount, MaxAmount: &maxAmount}, nil, tx2)
+	s.requireQuery(&transactions.TxQuery{Address: otherAddress.EncodeAddress()}, nil, tx2)
+	s.requireQuery(&transactions.TxQuery{TxID: tx1.TxHash().String()[10:20]}, nil, tx1)
+	s.requireQuery(&transactions.TxQuery{Types: []transactions.TxType{transactions.TxTypeSend}}, nil)
+
+	// Lookups by address, with pagination.
+	cursor = s.requireQuery(&transactions.TxQuery{Address: address.EncodeAddress(), Limit: 1}, nil, tx3)
+	s.requireQuery(&transactions.TxQuery{Address: address.EncodeAddress(), Cursor: cursor}, nil, tx1)
+	s.requireQuery(&transactions.TxQuery{
+		Address: address.EncodeAddress(), Status: transactions.TxStatusConfirmed}, nil, tx1)
+	s.requireQuery(&transactions.TxQuery{Address: "unknown"}, nil)
+	s.requireQuery(&transactions.TxQuery{TxID: tx2.TxHash().String()}, nil, tx2)
+
+	// Labels.
+	labels := map[chainhash.Hash]string{tx2.TxHash(): "Rent", tx1.TxHash(): "Salary"}
+	s.requireQuery(&transactions.TxQuery{Label: "rEN"}, labels, tx2)
+	s.requireQuery(&transactions.TxQuery{Label: "R", Ascending: true}, labels, tx1, tx2)
+	result, err := s.transactions.QueryTransactions(
+		&transactions.TxQuery{TxID: tx2.TxHash().String()},
+		func(blockchainpkg.ScriptHashHex) bool { return false }, labels)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "Rent", result.Transactions[0].Label)
+	s.requireQuery(&transactions.TxQuery{Label: "rent"}, nil)
+	require.True(s.T(), s.transactions.HasTransaction(tx2.TxHash()))
+	require.False(s.T(), s.transactions.HasTransaction(chainhash.HashH([]byte("unknown"))))
+
+	// The index follows confirmations and removals.
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx3.TxHash()), Height: 12},
+	})
+	<-merkleRequests
+	s.requireQuery(&transactions.TxQuery{Status: transactions.TxStatusUnconfirmed}, nil)
+	s.requireQuery(&transactions.TxQuery{}, nil, tx3, tx2)
+}
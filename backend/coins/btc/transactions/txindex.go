@@ -0,0 +1,378 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transactions
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// TxIndexEntry is the summary of a tx stored in the index of the transactions database. Queries are
+// answered from the index instead of computing the TxInfo of every transaction. See TxInfo for the
+// fields.
+type TxIndexEntry struct {
+	TxHash     chainhash.Hash  `json:"txHash"`
+	Height     int             `json:"height"`
+	Timestamp  *time.Time      `json:"timestamp"`
+	Type       TxType          `json:"type"`
+	Amount     btcutil.Amount  `json:"amount"`
+	Fee        *btcutil.Amount `json:"fee"`
+	VSize      int64           `json:"vsize"`
+	Size       int64           `json:"size"`
+	Weight     int64           `json:"weight"`
+	Addresses  []string        `json:"addresses"`
+	Conflicted bool            `json:"conflicted"`
+}
+
+// TxStatus is the confirmation status of a tx. See the TxStatus* constants.
+type TxStatus string
+
+const (
+	// TxStatusConfirmed is a tx included in a block.
+	TxStatusConfirmed TxStatus = "confirmed"
+	// TxStatusUnconfirmed is a tx which is not yet included in a block.
+	TxStatusUnconfirmed TxStatus = "unconfirmed"
+	// TxStatusConflicted is an unconfirmed tx which is double spent by another one.
+	TxStatusConflicted TxStatus = "conflicted"
+)
+
+func (entry *TxIndexEntry) status() TxStatus {
+	switch {
+	case entry.Height > 0:
+		return TxStatusConfirmed
+	case entry.Conflicted:
+		return TxStatusConflicted
+	default:
+		return TxStatusUnconfirmed
+	}
+}
+
+// TxQuery selects transactions. Fields with zero values do not filter.
+type TxQuery struct {
+	Types []TxType
+	// From (inclusive) and To (exclusive) bound the time of confirmation. Transactions whose time of
+	// confirmation is not known are left out if either is set.
+	From *time.Time
+	To   *time.Time
+	// MinAmount and MaxAmount bound TxInfo.Amount, inclusively.
+	MinAmount *btcutil.Amount
+	MaxAmount *btcutil.Amount
+	// Address matches the transactions which sent to or received on the address.
+	Address string
+	// TxID matches the transactions whose ID contains it.
+	TxID string
+	// Label matches the transactions whose label contains it, ignoring the case.
+	Label  string
+	Status TxStatus
+	// Ascending lists the oldest transactions first. By default, the unconfirmed transactions come
+	// first, followed by the newest ones.
+	Ascending bool
+	// Cursor continues a previous query after its last result, see TxQueryResult.NextCursor.
+	Cursor string
+	// Limit is the maximum number of results.
+	Limit int
+}
+
+// TxQueryResult is a page of transactions matching a TxQuery.
+type TxQueryResult struct {
+	Transactions []*TxInfo
+	// NextCursor continues the query with the next page. It is empty if there are no more results.
+	NextCursor string
+}
+
+func (transactions *Transactions) matches(
+	query *TxQuery, entry *TxIndexEntry, labels map[chainhash.Hash]string) bool {
+	if len(query.Types) != 0 {
+		found := false
+		for _, txType := range query.Types {
+			if entry.Type == txType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if query.From != nil || query.To != nil {
+		if entry.Timestamp == nil ||
+			(query.From != nil && entry.Timestamp.Before(*query.From)) ||
+			(query.To != nil && !entry.Timestamp.Before(*query.To)) {
+			return false
+		}
+	}
+	if (query.MinAmount != nil && entry.Amount < *query.MinAmount) ||
+		(query.MaxAmount != nil && entry.Amount > *query.MaxAmount) {
+		return false
+	}
+	if query.Address != "" {
+		found := false
+		for _, address := range entry.Addresses {
+			if address == query.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if query.TxID != "" && !strings.Contains(entry.TxHash.String(), strings.ToLower(query.TxID)) {
+		return false
+	}
+	if query.Status != "" && entry.status() != query.Status {
+		return false
+	}
+	if query.Label != "" &&
+		!strings.Contains(strings.ToLower(labels[entry.TxHash]), strings.ToLower(query.Label)) {
+		return false
+	}
+	return true
+}
+
+// updateTxIndex recomputes the stale entries of the index.
+func (transactions *Transactions) updateTxIndex(isChange func(blockchain.ScriptHashHex) bool) {
+	defer transactions.Lock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+	txHashes, err := dbTx.StaleTxIndexEntries()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve the stale index entries")
+	}
+	if len(txHashes) == 0 {
+		return
+	}
+	transactions.log.Debugf("Reindexing %d transactions", len(txHashes))
+	for _, txHash := range txHashes {
+		tx, _, height, timestamp, err := dbTx.TxInfo(txHash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+		}
+		if tx == nil {
+			if err := dbTx.DeleteTxIndexEntry(txHash); err != nil {
+				transactions.log.WithError(err).Panic("Failed to delete the index entry")
+			}
+			continue
+		}
+		txInfo := transactions.txInfo(dbTx, tx, height, timestamp, isChange)
+		err = dbTx.PutTxIndexEntry(&TxIndexEntry{
+			TxHash:     txHash,
+			Height:     txInfo.Height,
+			Timestamp:  txInfo.Timestamp,
+			Type:       txInfo.Type,
+			Amount:     txInfo.Amount,
+			Fee:        txInfo.Fee,
+			VSize:      txInfo.VSize,
+			Size:       txInfo.Size,
+			Weight:     txInfo.Weight,
+			Addresses:  txInfo.Addresses,
+			Conflicted: txInfo.Conflicted,
+		})
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to store the index entry")
+		}
+	}
+	if err := dbTx.Commit(); err != nil {
+		transactions.log.WithError(err).Panic("Failed to commit transaction")
+	}
+}
+
+// txIndexStale returns true if entries of the index need to be recomputed, see updateTxIndex.
+func (transactions *Transactions) txIndexStale() bool {
+	defer transactions.RLock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+	txHashes, err := dbTx.StaleTxIndexEntries()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve the stale index entries")
+	}
+	return len(txHashes) != 0
+}
+
+// lookupTxIndex returns the transactions which can match the query, if the query is by address,
+// label or txid. Otherwise, it returns false, and the whole index is scanned.
+func (transactions *Transactions) lookupTxIndex(
+	dbTx DBTxInterface, query *TxQuery, labels map[chainhash.Hash]string) ([]chainhash.Hash, bool) {
+	switch {
+	case query.Address != "":
+		txHashes, err := dbTx.TxIndexAddressTransactions(query.Address)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve the index entries of the address")
+		}
+		return txHashes, true
+	case query.Label != "":
+		txHashes := []chainhash.Hash{}
+		for txHash, label := range labels {
+			if strings.Contains(strings.ToLower(label), strings.ToLower(query.Label)) {
+				txHashes = append(txHashes, txHash)
+			}
+		}
+		return txHashes, true
+	case len(query.TxID) == 2*chainhash.HashSize:
+		txHash, err := chainhash.NewHashFromStr(query.TxID)
+		if err != nil {
+			return nil, false
+		}
+		return []chainhash.Hash{*txHash}, true
+	default:
+		return nil, false
+	}
+}
+
+// forEachTxIndexEntryOf is like DBTxInterface.ForEachTxIndexEntry, but only iterates over the
+// entries of the given transactions.
+func (transactions *Transactions) forEachTxIndexEntryOf(
+	dbTx DBTxInterface,
+	txHashes []chainhash.Hash,
+	descending bool,
+	cursor []byte,
+	f func(cursor []byte, entry *TxIndexEntry) bool,
+) {
+	type cursorEntry struct {
+		cursor []byte
+		entry  *TxIndexEntry
+	}
+	entries := []cursorEntry{}
+	for _, txHash := range txHashes {
+		entryCursor, entry, err := dbTx.TxIndexEntry(txHash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve the index entry")
+		}
+		if entry == nil {
+			continue
+		}
+		if cursor != nil {
+			order := bytes.Compare(entryCursor, cursor)
+			if (!descending && order <= 0) || (descending && order >= 0) {
+				continue
+			}
+		}
+		entries = append(entries, cursorEntry{cursor: entryCursor, entry: entry})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return (bytes.Compare(entries[i].cursor, entries[j].cursor) < 0) != descending
+	})
+	for _, entry := range entries {
+		if !f(entry.cursor, entry.entry) {
+			return
+		}
+	}
+}
+
+// QueryTransactions returns the transactions matching the query, with the given labels, see
+// TxInfo.Label. The stale entries of the index are recomputed first, after which only the index is
+// read. Queries by address, label or txid only read the entries of the matching transactions.
+func (transactions *Transactions) QueryTransactions(
+	query *TxQuery,
+	isChange func(blockchain.ScriptHashHex) bool,
+	labels map[chainhash.Hash]string,
+) (*TxQueryResult, error) {
+	var cursor []byte
+	if query.Cursor != "" {
+		var err error
+		cursor, err = hex.DecodeString(query.Cursor)
+		if err != nil {
+			return nil, errp.Newf("Invalid cursor %q", query.Cursor)
+		}
+	}
+	transactions.synchronizer.WaitSynchronized()
+	if transactions.txIndexStale() {
+		transactions.updateTxIndex(isChange)
+	}
+	defer transactions.RLock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer dbTx.Rollback()
+	result := &TxQueryResult{Transactions: []*TxInfo{}}
+	var lastCursor []byte
+	collect := func(entryCursor []byte, entry *TxIndexEntry) bool {
+		if !transactions.matches(query, entry, labels) {
+			return true
+		}
+		if query.Limit > 0 && len(result.Transactions) == query.Limit {
+			result.NextCursor = hex.EncodeToString(lastCursor)
+			return false
+		}
+		result.Transactions = append(
+			result.Transactions, transactions.indexedTxInfo(dbTx, entry, labels[entry.TxHash]))
+		lastCursor = append([]byte{}, entryCursor...)
+		return true
+	}
+	if txHashes, ok := transactions.lookupTxIndex(dbTx, query, labels); ok {
+		transactions.forEachTxIndexEntryOf(dbTx, txHashes, !query.Ascending, cursor, collect)
+		return result, nil
+	}
+	if err := dbTx.ForEachTxIndexEntry(!query.Ascending, cursor, collect); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// indexedTxInfo completes the info of an index entry with the tx, its label and the number of
+// confirmations.
+func (transactions *Transactions) indexedTxInfo(
+	dbTx DBTxInterface, entry *TxIndexEntry, label string) *TxInfo {
+	tx, _, _, _, err := dbTx.TxInfo(entry.TxHash)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+	}
+	return &TxInfo{
+		Tx:               tx,
+		VSize:            entry.VSize,
+		Size:             entry.Size,
+		Weight:           entry.Weight,
+		Height:           entry.Height,
+		NumConfirmations: transactions.numConfirmations(entry.Height),
+		Type:             entry.Type,
+		Amount:           entry.Amount,
+		Fee:              entry.Fee,
+		Timestamp:        entry.Timestamp,
+		Addresses:        entry.Addresses,
+		Verified:         entry.Timestamp != nil,
+		Conflicted:       entry.Conflicted,
+		Label:            label,
+	}
+}
+
+// HasTransaction returns true if the tx is one of the transactions of the wallet.
+func (transactions *Transactions) HasTransaction(txHash chainhash.Hash) bool {
+	defer transactions.RLock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+	tx, _, _, _, err := dbTx.TxInfo(txHash)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+	}
+	return tx != nil
+}
@@ -24,6 +24,8 @@ import (
 
 func (transactions *Transactions) onHeadersEvent(event headers.Event) {
 	switch event {
+	case headers.EventReorg:
+		transactions.unverifyReorgedTransactions()
 	case headers.EventSynced:
 		transactions.verifyTransactions()
 	case headers.EventNewTip:
@@ -58,6 +60,110 @@ func (transactions *Transactions) unverifiedTransactions() map[chainhash.Hash]in
 	return result
 }
 
+// verifiedTx is the block a verified tx was found in.
+type verifiedTx struct {
+	height     int
+	headerHash chainhash.Hash
+}
+
+// verifiedTransactions returns the block height and header hash of all verified transactions.
+func (transactions *Transactions) verifiedTransactions() map[chainhash.Hash]verifiedTx {
+	defer transactions.RLock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+	txHashes, err := dbTx.Transactions()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve transactions")
+	}
+	result := map[chainhash.Hash]verifiedTx{}
+	for _, txHash := range txHashes {
+		if verified, ok := transactions.txHeaderHash(dbTx, txHash); ok {
+			result[txHash] = verified
+		}
+	}
+	return result
+}
+
+// txHeaderHash returns the block height and header hash of the tx, and false if it is not
+// verified.
+func (transactions *Transactions) txHeaderHash(
+	dbTx DBTxInterface, txHash chainhash.Hash) (verifiedTx, bool) {
+	_, _, height, headerTimestamp, err := dbTx.TxInfo(txHash)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+	}
+	if headerTimestamp == nil || height <= 0 {
+		// Not verified.
+		return verifiedTx{}, false
+	}
+	headerHash, err := dbTx.TxHeaderHash(txHash)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve header hash")
+	}
+	if headerHash == nil {
+		return verifiedTx{}, false
+	}
+	return verifiedTx{height: height, headerHash: *headerHash}, true
+}
+
+// unverifyReorgedTransactions marks the verified transactions as unverified whose header is not part
+// of the chain anymore, or not synced anymore after a reorg rolled back the chain. They are verified
+// again the next time the headers are synced. The headers are looked up once per block, without
+// holding the lock.
+func (transactions *Transactions) unverifyReorgedTransactions() {
+	headerHashes := map[int]*chainhash.Hash{}
+	reorged := map[chainhash.Hash]verifiedTx{}
+	for txHash, verified := range transactions.verifiedTransactions() {
+		headerHash, ok := headerHashes[verified.height]
+		if !ok {
+			header, err := transactions.headers.HeaderByHeight(verified.height)
+			if err != nil {
+				transactions.log.WithError(err).Panic("Failed to retrieve header")
+			}
+			if header != nil {
+				blockHash := header.BlockHash()
+				headerHash = &blockHash
+			}
+			headerHashes[verified.height] = headerHash
+		}
+		if headerHash == nil || *headerHash != verified.headerHash {
+			reorged[txHash] = verified
+		}
+	}
+	if len(reorged) == 0 {
+		return
+	}
+
+	defer transactions.Lock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+	unverified := 0
+	for txHash, verified := range reorged {
+		// Skip the tx if it was verified again or changed in the meantime.
+		if current, ok := transactions.txHeaderHash(dbTx, txHash); !ok || current != verified {
+			continue
+		}
+		if err := dbTx.MarkTxUnverified(txHash); err != nil {
+			transactions.log.WithError(err).Panic("Failed to mark tx as unverified")
+		}
+		transactions.invalidate(dbTx, txHash)
+		unverified++
+	}
+	if err := dbTx.Commit(); err != nil {
+		transactions.log.WithError(err).Panic("Failed to commit transaction")
+	}
+	if unverified > 0 {
+		transactions.log.Infof("Unverified %d transactions after a reorg", unverified)
+		transactions.onEvent(EventTxsUnverified)
+	}
+}
+
 func hashMerkleRoot(merkle []blockchain.TXHash, start chainhash.Hash, pos int) chainhash.Hash {
 	for i := 0; i < len(merkle); i++ {
 		if (uint32(pos)>>uint32(i))&1 == 0 {
@@ -109,9 +215,10 @@ func (transactions *Transactions) verifyTransaction(txHash chainhash.Hash, heigh
 				panic(err)
 			}
 			defer dbTx.Rollback()
-			if err := dbTx.MarkTxVerified(txHash, header.Timestamp); err != nil {
+			if err := dbTx.MarkTxVerified(txHash, header.BlockHash(), header.Timestamp); err != nil {
 				return err
 			}
+			transactions.invalidate(dbTx, txHash)
 			return dbTx.Commit()
 		},
 		func() { done() })
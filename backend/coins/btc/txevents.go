@@ -0,0 +1,178 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// TxEventType is the type of a TxEvent.
+type TxEventType string
+
+const (
+	// TxEventIncoming is fired when a new incoming transaction is seen, confirmed or not.
+	TxEventIncoming TxEventType = "incoming"
+
+	// TxEventConfirmed is fired when an incoming transaction reaches one of the configured numbers
+	// of confirmations.
+	TxEventConfirmed TxEventType = "confirmed"
+
+	// TxEventOutgoingConfirmed is fired when an outgoing transaction reaches one of the configured
+	// numbers of confirmations.
+	TxEventOutgoingConfirmed TxEventType = "outgoingConfirmed"
+
+	// TxEventVerified is fired when a transaction was verified against the block headers (SPV).
+	TxEventVerified TxEventType = "verified"
+
+	// TxEventDropped is fired when a transaction is not part of the history of the account anymore,
+	// e.g. because it was evicted from the mempool or replaced.
+	TxEventDropped TxEventType = "dropped"
+)
+
+// TxEvent notifies about a transaction of an account.
+type TxEvent struct {
+	Type        TxEventType `json:"type"`
+	AccountCode string      `json:"accountCode"`
+	// TxID is empty if the transaction is not known, e.g. for Ethereum accounts, whose events are
+	// derived from the changes of the balance.
+	TxID string `json:"txID"`
+	// Amount is formatted in the unit of the coin, see transactions.TxInfo.Amount.
+	Amount           string `json:"amount"`
+	Unit             string `json:"unit"`
+	NumConfirmations int    `json:"numConfirmations"`
+}
+
+// notifiedTx is the state of a transaction as of the last update of the txNotifier.
+type notifiedTx struct {
+	txType           transactions.TxType
+	amount           btcutil.Amount
+	numConfirmations int
+	verified         bool
+}
+
+// txNotifier turns the changes of the transactions of an account into TxEvents.
+type txNotifier struct {
+	locker.Locker
+
+	accountCode  string
+	unit         string
+	formatAmount func(btcutil.Amount) string
+	// thresholds are the numbers of confirmations to notify about, in ascending order.
+	thresholds []int
+	// txs is nil until the first update.
+	txs map[chainhash.Hash]*notifiedTx
+}
+
+// newTxNotifier creates a new txNotifier. Non-positive thresholds are ignored.
+func newTxNotifier(
+	accountCode string,
+	unit string,
+	formatAmount func(btcutil.Amount) string,
+	thresholds []int,
+) *txNotifier {
+	sortedThresholds := []int{}
+	for _, threshold := range thresholds {
+		if threshold > 0 {
+			sortedThresholds = append(sortedThresholds, threshold)
+		}
+	}
+	sort.Ints(sortedThresholds)
+	return &txNotifier{
+		accountCode:  accountCode,
+		unit:         unit,
+		formatAmount: formatAmount,
+		thresholds:   sortedThresholds,
+	}
+}
+
+// crossedThreshold returns true if a threshold is in (from, to].
+func (notifier *txNotifier) crossedThreshold(from, to int) bool {
+	for _, threshold := range notifier.thresholds {
+		if from < threshold && threshold <= to {
+			return true
+		}
+	}
+	return false
+}
+
+func (notifier *txNotifier) event(
+	eventType TxEventType, txHash chainhash.Hash, tx *notifiedTx) *TxEvent {
+	return &TxEvent{
+		Type:             eventType,
+		AccountCode:      notifier.accountCode,
+		TxID:             txHash.String(),
+		Amount:           notifier.formatAmount(tx.amount),
+		Unit:             notifier.unit,
+		NumConfirmations: tx.numConfirmations,
+	}
+}
+
+// update returns the events which happened since the previous update, given the current
+// transactions. The first update only records the transactions, so that the history of the account
+// is not notified about.
+func (notifier *txNotifier) update(txs []*transactions.TxInfo) []*TxEvent {
+	initial := notifier.txs == nil
+	previousTxs := notifier.txs
+	notifier.txs = map[chainhash.Hash]*notifiedTx{}
+	events := []*TxEvent{}
+	for _, txInfo := range txs {
+		txHash := txInfo.Tx.TxHash()
+		tx := &notifiedTx{
+			txType:           txInfo.Type,
+			amount:           txInfo.Amount,
+			numConfirmations: txInfo.NumConfirmations,
+			verified:         txInfo.Verified,
+		}
+		notifier.txs[txHash] = tx
+		if initial {
+			continue
+		}
+		previousTx, seen := previousTxs[txHash]
+		if !seen {
+			previousTx = &notifiedTx{}
+			if tx.txType == transactions.TxTypeReceive {
+				events = append(events, notifier.event(TxEventIncoming, txHash, tx))
+			}
+		}
+		if notifier.crossedThreshold(previousTx.numConfirmations, tx.numConfirmations) {
+			eventType := TxEventOutgoingConfirmed
+			if tx.txType == transactions.TxTypeReceive {
+				eventType = TxEventConfirmed
+			}
+			events = append(events, notifier.event(eventType, txHash, tx))
+		}
+		if tx.verified && !previousTx.verified {
+			events = append(events, notifier.event(TxEventVerified, txHash, tx))
+		}
+	}
+	droppedTxHashes := []chainhash.Hash{}
+	for txHash := range previousTxs {
+		if _, ok := notifier.txs[txHash]; !ok {
+			droppedTxHashes = append(droppedTxHashes, txHash)
+		}
+	}
+	sort.Slice(droppedTxHashes, func(i, j int) bool {
+		return droppedTxHashes[i].String() < droppedTxHashes[j].String()
+	})
+	for _, txHash := range droppedTxHashes {
+		events = append(events, notifier.event(TxEventDropped, txHash, previousTxs[txHash]))
+	}
+	return events
+}
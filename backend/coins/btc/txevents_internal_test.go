@@ -0,0 +1,91 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/stretchr/testify/require"
+)
+
+func testTx(seed uint32) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.HashH(nil), Index: seed}, nil, nil))
+	return tx
+}
+
+func TestTxNotifier(t *testing.T) {
+	notifier := newTxNotifier("tbtc-p2pkh", "TBTC",
+		func(amount btcutil.Amount) string { return strconv.FormatInt(int64(amount), 10) },
+		[]int{6, 0, 1})
+	received := &transactions.TxInfo{
+		Tx: testTx(0), Type: transactions.TxTypeReceive, Amount: 100}
+	sent := &transactions.TxInfo{
+		Tx: testTx(1), Type: transactions.TxTypeSend, Amount: 50, NumConfirmations: 2}
+	event := func(eventType TxEventType, txInfo *transactions.TxInfo) *TxEvent {
+		return &TxEvent{
+			Type:             eventType,
+			AccountCode:      "tbtc-p2pkh",
+			TxID:             txInfo.Tx.TxHash().String(),
+			Amount:           strconv.FormatInt(int64(txInfo.Amount), 10),
+			Unit:             "TBTC",
+			NumConfirmations: txInfo.NumConfirmations,
+		}
+	}
+
+	// The existing transactions are not notified about.
+	require.Empty(t, notifier.update([]*transactions.TxInfo{sent}))
+	require.Empty(t, notifier.update([]*transactions.TxInfo{sent}))
+
+	require.Equal(t,
+		[]*TxEvent{event(TxEventIncoming, received)},
+		notifier.update([]*transactions.TxInfo{received, sent}))
+
+	received.NumConfirmations = 1
+	require.Equal(t,
+		[]*TxEvent{event(TxEventConfirmed, received)},
+		notifier.update([]*transactions.TxInfo{received, sent}))
+
+	received.Verified = true
+	received.NumConfirmations = 5
+	sent.NumConfirmations = 6
+	require.Equal(t,
+		[]*TxEvent{event(TxEventVerified, received), event(TxEventOutgoingConfirmed, sent)},
+		notifier.update([]*transactions.TxInfo{received, sent}))
+
+	// Crossing several thresholds at once is notified once.
+	received.NumConfirmations = 10
+	require.Equal(t,
+		[]*TxEvent{event(TxEventConfirmed, received)},
+		notifier.update([]*transactions.TxInfo{received, sent}))
+	received.NumConfirmations = 11
+	require.Empty(t, notifier.update([]*transactions.TxInfo{received, sent}))
+
+	require.Equal(t,
+		[]*TxEvent{event(TxEventDropped, sent)},
+		notifier.update([]*transactions.TxInfo{received}))
+
+	// A new transaction which is confirmed already.
+	confirmed := &transactions.TxInfo{
+		Tx: testTx(2), Type: transactions.TxTypeReceive, Amount: 7, NumConfirmations: 1}
+	require.Equal(t,
+		[]*TxEvent{event(TxEventIncoming, confirmed), event(TxEventConfirmed, confirmed)},
+		notifier.update([]*transactions.TxInfo{confirmed, received}))
+}
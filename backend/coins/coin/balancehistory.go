@@ -0,0 +1,112 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coin
+
+import (
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Interval is the period by which a balance history is bucketed. See the Interval* constants.
+type Interval string
+
+const (
+	// IntervalDay buckets by calendar day.
+	IntervalDay Interval = "day"
+	// IntervalWeek buckets by week, starting on Monday.
+	IntervalWeek Interval = "week"
+	// IntervalMonth buckets by calendar month.
+	IntervalMonth Interval = "month"
+)
+
+// NewInterval creates an Interval from its string representation.
+func NewInterval(interval string) (Interval, error) {
+	switch Interval(interval) {
+	case IntervalDay, IntervalWeek, IntervalMonth:
+		return Interval(interval), nil
+	}
+	return "", errp.Newf("Unrecognized interval %q", interval)
+}
+
+// Start returns the beginning of the interval containing the given time. All intervals are in UTC.
+func (interval Interval) Start(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	switch interval {
+	case IntervalWeek:
+		daysSinceMonday := (int(t.UTC().Weekday()) + 6) % 7
+		return time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+	case IntervalMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// End returns the last instant of the interval which starts at start.
+func (interval Interval) End(start time.Time) time.Time {
+	return interval.next(start).Add(-time.Nanosecond)
+}
+
+// next returns the beginning of the interval following the one which starts at start.
+func (interval Interval) next(start time.Time) time.Time {
+	switch interval {
+	case IntervalWeek:
+		return start.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// BalancePoint is the balance of an account at a point in time.
+type BalancePoint struct {
+	Time    time.Time
+	Balance Amount
+}
+
+// BucketBalances turns a list of running balances, ordered by block height, into one point per
+// interval, from the interval of the first balance up to and including the interval containing
+// until. Each point is stamped with the start of its interval and holds the balance at the end of
+// it. Intervals without a balance change carry over the previous balance.
+//
+// Block timestamps are not strictly increasing, so a balance is never moved into an interval
+// before the one of a balance preceding it.
+func BucketBalances(balances []*BalancePoint, interval Interval, until time.Time) []*BalancePoint {
+	result := []*BalancePoint{}
+	if len(balances) == 0 {
+		return result
+	}
+	last := interval.Start(until)
+	for _, balance := range balances {
+		start := interval.Start(balance.Time)
+		if len(result) > 0 {
+			current := result[len(result)-1]
+			if !start.After(current.Time) {
+				current.Balance = balance.Balance
+				continue
+			}
+			for next := interval.next(current.Time); next.Before(start); next = interval.next(next) {
+				result = append(result, &BalancePoint{Time: next, Balance: current.Balance})
+			}
+		}
+		result = append(result, &BalancePoint{Time: start, Balance: balance.Balance})
+	}
+	for current := result[len(result)-1]; current.Time.Before(last); current = result[len(result)-1] {
+		result = append(result, &BalancePoint{Time: interval.next(current.Time), Balance: current.Balance})
+	}
+	return result
+}
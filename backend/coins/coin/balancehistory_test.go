@@ -0,0 +1,117 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coin_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/stretchr/testify/require"
+)
+
+func date(year int, month time.Month, day int, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+func TestIntervalStart(t *testing.T) {
+	// Wednesday.
+	someTime := date(2018, time.August, 15, 13)
+	require.Equal(t, date(2018, time.August, 15, 0), coin.IntervalDay.Start(someTime))
+	require.Equal(t, date(2018, time.August, 13, 0), coin.IntervalWeek.Start(someTime))
+	require.Equal(t, date(2018, time.August, 1, 0), coin.IntervalMonth.Start(someTime))
+	// Sunday belongs to the week starting on the previous Monday.
+	require.Equal(t, date(2018, time.August, 13, 0), coin.IntervalWeek.Start(date(2018, time.August, 19, 23)))
+
+	// The end is the last instant before the next interval, whose day is the last day of the
+	// interval.
+	end := date(2018, time.August, 16, 0).Add(-time.Nanosecond)
+	require.Equal(t, end, coin.IntervalDay.End(date(2018, time.August, 15, 0)))
+	require.Equal(t, date(2018, time.August, 19, 0).Day(), coin.IntervalWeek.End(date(2018, time.August, 13, 0)).Day())
+	require.Equal(t, 31, coin.IntervalMonth.End(date(2018, time.August, 1, 0)).Day())
+
+	_, err := coin.NewInterval("year")
+	require.Error(t, err)
+	interval, err := coin.NewInterval("week")
+	require.NoError(t, err)
+	require.Equal(t, coin.IntervalWeek, interval)
+}
+
+func balancesOf(points []*coin.BalancePoint) ([]time.Time, []int64) {
+	times := []time.Time{}
+	balances := []int64{}
+	for _, point := range points {
+		times = append(times, point.Time)
+		balance, err := point.Balance.Int64()
+		if err != nil {
+			panic(err)
+		}
+		balances = append(balances, balance)
+	}
+	return times, balances
+}
+
+func TestBucketBalances(t *testing.T) {
+	require.Empty(t, coin.BucketBalances(nil, coin.IntervalDay, date(2018, time.August, 1, 0)))
+
+	balances := []*coin.BalancePoint{
+		{Time: date(2018, time.August, 1, 10), Balance: coin.NewAmountFromInt64(100)},
+		{Time: date(2018, time.August, 1, 12), Balance: coin.NewAmountFromInt64(150)},
+		// The timestamp is before the previous one, but the block is higher.
+		{Time: date(2018, time.August, 1, 11), Balance: coin.NewAmountFromInt64(120)},
+		{Time: date(2018, time.August, 4, 9), Balance: coin.NewAmountFromInt64(20)},
+	}
+
+	times, amounts := balancesOf(coin.BucketBalances(balances, coin.IntervalDay, date(2018, time.August, 5, 8)))
+	require.Equal(t, []time.Time{
+		date(2018, time.August, 1, 0),
+		date(2018, time.August, 2, 0),
+		date(2018, time.August, 3, 0),
+		date(2018, time.August, 4, 0),
+		date(2018, time.August, 5, 0),
+	}, times)
+	require.Equal(t, []int64{120, 120, 120, 20, 20}, amounts)
+
+	times, amounts = balancesOf(coin.BucketBalances(balances, coin.IntervalMonth, date(2018, time.October, 2, 0)))
+	require.Equal(t, []time.Time{
+		date(2018, time.August, 1, 0),
+		date(2018, time.September, 1, 0),
+		date(2018, time.October, 1, 0),
+	}, times)
+	require.Equal(t, []int64{20, 20, 20}, amounts)
+
+	// A block timestamp going backwards across a day boundary does not move the balance into an
+	// earlier day.
+	times, amounts = balancesOf(coin.BucketBalances([]*coin.BalancePoint{
+		{Time: date(2018, time.August, 2, 0), Balance: coin.NewAmountFromInt64(1)},
+		{Time: date(2018, time.August, 1, 23), Balance: coin.NewAmountFromInt64(2)},
+	}, coin.IntervalDay, date(2018, time.August, 2, 0)))
+	require.Equal(t, []time.Time{date(2018, time.August, 2, 0)}, times)
+	require.Equal(t, []int64{2}, amounts)
+}
+
+func TestRate(t *testing.T) {
+	rates := map[string]map[string]float64{"BTC": {"USD": 6000}}
+	rate, ok := coin.Rate(rates, "BTC", "USD")
+	require.True(t, ok)
+	require.Equal(t, 6000.0, rate)
+	// Testnet coins have no rates.
+	_, ok = coin.Rate(rates, "", "USD")
+	require.False(t, ok)
+	_, ok = coin.Rate(rates, "BTC", "EUR")
+	require.False(t, ok)
+	_, ok = coin.Rate(rates, "LTC", "USD")
+	require.False(t, ok)
+}
@@ -39,6 +39,9 @@ type Coin interface {
 	// FormatAmount formats the given amount as a number.
 	FormatAmount(Amount) string
 
+	// ParseAmount parses an amount given in the unit of the coin.
+	ParseAmount(string) (Amount, error)
+
 	// // Server returns the host and port of the full node used for blockchain synchronization.
 	// Server() string
 
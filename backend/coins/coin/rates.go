@@ -15,6 +15,8 @@
 package coin
 
 import (
+	"time"
+
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
 )
 
@@ -22,4 +24,20 @@ import (
 type RatesUpdater interface {
 	observable.Interface
 	Last() map[string]map[string]float64
+	// DayAgo returns the rates of 24 hours before the last rates, or nil if not available.
+	DayAgo() map[string]map[string]float64
+	// HistoricalRate returns the exchange rate of the coin whose rates are listed under the given
+	// unit in the given fiat currency at the end of the day (UTC) of the given time.
+	HistoricalRate(unit string, fiat string, t time.Time) (float64, error)
+}
+
+// Rate looks up the exchange rate of a coin in the given fiat currency. ratesUnit is the unit under
+// which the rates of the coin are listed, empty if the coin has no exchange rates. The second return
+// value is false if no rate is available.
+func Rate(rates map[string]map[string]float64, ratesUnit string, fiat string) (float64, bool) {
+	if ratesUnit == "" {
+		return 0, false
+	}
+	rate, ok := rates[ratesUnit][fiat]
+	return rate, ok
 }
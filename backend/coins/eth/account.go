@@ -1,8 +1,8 @@
 package eth
 
 import (
-	"context"
 	"math/big"
+	"time"
 
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
@@ -18,10 +18,13 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/sirupsen/logrus"
 )
 
+// pollInterval is the interval in which the node is asked for a new block if the connection does
+// not support new block notifications.
+var pollInterval = 15 * time.Second
+
 // Event instances are sent to the onEvent callback of the wallet.
 type Event string
 
@@ -38,15 +41,31 @@ type Account struct {
 	keystores               keystore.Keystores
 
 	initialSyncDone bool
-
-	address     Address
-	balance     coin.Amount
+	offline         bool
+	// quitChan is closed to stop following new blocks. doneChan is closed when that has stopped.
+	quitChan chan struct{}
+	doneChan chan struct{}
+
+	address Address
+	// balance is the balance at blockNumber.
+	balance coin.Amount
+	// incoming is the amount the pending balance exceeds the balance, i.e. unconfirmed funds
+	// received.
+	incoming     coin.Amount
+	pendingNonce uint64
+	// blockNumber is the height of the latest known block.
 	blockNumber *big.Int
 
-	log *logrus.Entry
+	onEvent func(Event)
+	// notifyConfirmations is true if confirmations are notified about, see balanceTxEvents.
+	notifyConfirmations bool
+	onTxEvent           func(*btc.TxEvent)
+	log                 *logrus.Entry
 }
 
-// NewAccount creats a new Account.
+// NewAccount creats a new Account. onTxEvent is called with the TxEvents derived from the changes
+// of the balance, see balanceTxEvents. Confirmations are notified about if confirmationThresholds
+// has a positive entry.
 func NewAccount(
 	coin *Coin,
 	dbFolder string,
@@ -55,8 +74,16 @@ func NewAccount(
 	getSigningConfiguration func() (*signing.Configuration, error),
 	keystores keystore.Keystores,
 	onEvent func(Event),
+	confirmationThresholds []int,
+	onTxEvent func(*btc.TxEvent),
 	log *logrus.Entry,
 ) *Account {
+	notifyConfirmations := false
+	for _, threshold := range confirmationThresholds {
+		if threshold > 0 {
+			notifyConfirmations = true
+		}
+	}
 	account := &Account{
 		coin:                    coin,
 		code:                    code,
@@ -66,14 +93,23 @@ func NewAccount(
 		keystores:               keystores,
 
 		initialSyncDone: false,
+		offline:         false,
 
-		log: log,
+		onEvent:             onEvent,
+		notifyConfirmations: notifyConfirmations,
+		onTxEvent:           onTxEvent,
+		log:                 log,
 	}
 	account.synchronizer = synchronizer.NewSynchronizer(
 		func() { onEvent(Event(btc.EventSyncStarted)) },
 		func() {
-			if !account.initialSyncDone {
+			firstSync := func() bool {
+				defer account.Lock()()
+				firstSync := !account.initialSyncDone
 				account.initialSyncDone = true
+				return firstSync
+			}()
+			if firstSync {
 				onEvent(Event(btc.EventStatusChanged))
 			}
 			onEvent(Event(btc.EventSyncDone))
@@ -125,32 +161,196 @@ func (account *Account) Init() error {
 		account.log.Debug("Account has already been initialized")
 		return nil
 	}
-	account.address = Address{
-		Address: crypto.PubkeyToAddress(*account.signingConfiguration.PublicKeys()[0].ToECDSA()),
+	account.address = Address{Address: configurationAddress(account.signingConfiguration)}
+	// If the node can't be reached, the account is offline until subscribeBlocks reaches it.
+	if err := account.updateToLatestBlock(); err != nil {
+		account.log.WithError(err).Error("Could not fetch the latest block")
+	}
+	quitChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	func() {
+		defer account.Lock()()
+		account.quitChan = quitChan
+		account.doneChan = doneChan
+	}()
+	go func() {
+		defer close(doneChan)
+		account.subscribeBlocks(quitChan)
+	}()
+	return nil
+}
+
+// updateToLatestBlock updates the account at the latest block. The account is set offline if the
+// node can't be reached.
+func (account *Account) updateToLatestBlock() error {
+	ctx, cancel := requestContext()
+	header, err := account.coin.client.HeaderByNumber(ctx, nil)
+	cancel()
+	if err != nil {
+		account.setOffline(true)
+		return errp.WithStack(err)
+	}
+	return account.update(header.Number)
+}
+
+// subscribeBlocks keeps the account up to date with the chain. New blocks are received through a
+// newHeads subscription if the node connection supports it (websockets). Otherwise, or if the
+// subscription fails, the node is polled for new blocks. Returns when quitChan is closed.
+func (account *Account) subscribeBlocks(quitChan <-chan struct{}) {
+	headersChan := make(chan *types.Header)
+	// The context only bounds setting up the subscription.
+	ctx, cancel := requestContext()
+	subscription, err := account.coin.client.SubscribeNewHead(ctx, headersChan)
+	cancel()
+	if err != nil {
+		account.log.WithError(err).Info("New block notifications not available, polling instead")
+		account.pollBlocks(quitChan)
+		return
+	}
+	defer subscription.Unsubscribe()
+	for {
+		select {
+		case <-quitChan:
+			return
+		case err := <-subscription.Err():
+			account.log.WithError(err).Error("New block subscription failed, polling instead")
+			account.pollBlocks(quitChan)
+			return
+		case header := <-headersChan:
+			account.onNewHeader(header)
+		}
+	}
+}
+
+// pollBlocks asks the node for the latest block every pollInterval. Returns when quitChan is
+// closed.
+func (account *Account) pollBlocks(quitChan <-chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quitChan:
+			return
+		case <-ticker.C:
+			ctx, cancel := requestContext()
+			header, err := account.coin.client.HeaderByNumber(ctx, nil)
+			cancel()
+			if err != nil {
+				account.log.WithError(err).Error("Could not fetch the latest block")
+				account.setOffline(true)
+				continue
+			}
+			account.onNewHeader(header)
+		}
 	}
+}
+
+func (account *Account) onNewHeader(header *types.Header) {
+	isNew := func() bool {
+		defer account.RLock()()
+		return account.blockNumber == nil || header.Number.Cmp(account.blockNumber) != 0
+	}()
+	if !isNew {
+		return
+	}
+	account.log.WithField("block-number", header.Number).Debug("Received new block")
+	if err := account.update(header.Number); err != nil {
+		account.log.WithError(err).Error("Could not update the account")
+	}
+}
+
+// update fetches the balance at the given block and the pending state, and sets the given block
+// as the latest known block.
+func (account *Account) update(blockNumber *big.Int) error {
 	defer account.synchronizer.IncRequestsCounter()()
-	balance, err := account.coin.client.BalanceAt(context.TODO(), account.address.Address, nil)
+	client := account.coin.client
+	ctx, cancel := requestContext()
+	defer cancel()
+	balance, err := client.BalanceAt(ctx, account.address.Address, blockNumber)
 	if err != nil {
-		return err
+		account.setOffline(true)
+		return errp.WithStack(err)
+	}
+	pendingBalance, err := client.PendingBalanceAt(ctx, account.address.Address)
+	if err != nil {
+		account.setOffline(true)
+		return errp.WithStack(err)
+	}
+	pendingNonce, err := client.PendingNonceAt(ctx, account.address.Address)
+	if err != nil {
+		account.setOffline(true)
+		return errp.WithStack(err)
+	}
+	account.setOffline(false)
+	incoming := new(big.Int).Sub(pendingBalance, balance)
+	if incoming.Sign() < 0 {
+		// Outgoing pending transactions are not subtracted from the available balance.
+		incoming.SetInt64(0)
+	}
+	previousBalance, previousIncoming, initial := func() (coin.Amount, coin.Amount, bool) {
+		defer account.Lock()()
+		previousBalance, previousIncoming := account.balance, account.incoming
+		initial := account.blockNumber == nil
+		account.blockNumber = new(big.Int).Set(blockNumber)
+		account.balance = coin.NewAmount(balance)
+		account.incoming = coin.NewAmount(incoming)
+		account.pendingNonce = pendingNonce
+		return previousBalance, previousIncoming, initial
+	}()
+	if !initial {
+		events := account.balanceTxEvents(
+			previousBalance.BigInt(), previousIncoming.BigInt(), balance, incoming)
+		for _, event := range events {
+			account.onTxEvent(event)
+		}
 	}
-	account.balance = coin.NewAmount(balance)
-	account.blockNumber = big.NewInt(6000000) // TODO fetch
 	return nil
 }
 
+func (account *Account) setOffline(offline bool) {
+	changed := func() bool {
+		defer account.Lock()()
+		changed := account.offline != offline
+		account.offline = offline
+		return changed
+	}()
+	if changed {
+		account.onEvent(Event(btc.EventStatusChanged))
+	}
+}
+
 // InitialSyncDone implements btc.Interface.
 func (account *Account) InitialSyncDone() bool {
+	defer account.RLock()()
 	return account.initialSyncDone
 }
 
 // Offline implements btc.Interface.
 func (account *Account) Offline() bool {
-	return false
+	defer account.RLock()()
+	return account.offline
 }
 
 // Close implements btc.Interface.
 func (account *Account) Close() {
-
+	doneChan := func() chan struct{} {
+		defer account.Lock()()
+		if account.quitChan == nil {
+			return nil
+		}
+		close(account.quitChan)
+		account.quitChan = nil
+		return account.doneChan
+	}()
+	if doneChan != nil {
+		// Wait for a pending update to finish.
+		<-doneChan
+	}
+	func() {
+		defer account.Lock()()
+		account.initialSyncDone = false
+	}()
+	account.onEvent(Event(btc.EventStatusChanged))
 }
 
 // Transactions implements btc.Interface.
@@ -158,15 +358,34 @@ func (account *Account) Transactions() []*transactions.TxInfo {
 	return nil
 }
 
+// QueryTransactions implements btc.Interface. The transactions of Ethereum accounts are not
+// indexed, so they can't be queried.
+func (account *Account) QueryTransactions(
+	query *transactions.TxQuery) (*transactions.TxQueryResult, error) {
+	return nil, errp.WithStack(btc.ErrUnsupported)
+}
+
+// SetTxLabel implements btc.Interface.
+func (account *Account) SetTxLabel(txID string, label string) error {
+	return errp.WithStack(btc.ErrUnsupported)
+}
+
 // Balance implements btc.Interface.
 func (account *Account) Balance() *transactions.Balance {
 	account.synchronizer.WaitSynchronized()
+	defer account.RLock()()
 	return &transactions.Balance{
 		Available: account.balance,
-		Incoming:  coin.NewAmountFromInt64(0),
+		Incoming:  account.incoming,
 	}
 }
 
+// BalanceHistory implements btc.Interface. Transactions are not indexed for Ethereum accounts, so
+// the history is not supported.
+func (account *Account) BalanceHistory() ([]*coin.BalancePoint, error) {
+	return nil, errp.WithStack(btc.ErrUnsupported)
+}
+
 // TxProposal holds all info needed to create and sign a transacstion.
 type TxProposal struct {
 	Tx  *types.Transaction
@@ -180,21 +399,25 @@ type TxProposal struct {
 func (account *Account) newTx(
 	recipientAddress string,
 	amount coin.SendAmount) (*TxProposal, error) {
-	if !common.IsHexAddress(recipientAddress) {
-		return nil, errp.WithStack(coin.ErrInvalidAddress)
+	if err := ValidateAddress(recipientAddress); err != nil {
+		return nil, err
 	}
 	const gasLimit = 21000 // simple transaction gas cost
 
-	nonce, err := account.coin.client.PendingNonceAt(context.TODO(), account.address.Address)
+	ctx, cancel := requestContext()
+	defer cancel()
+	nonce, err := account.coin.client.PendingNonceAt(ctx, account.address.Address)
 	if err != nil {
 		return nil, err
 	}
-	suggestedGasPrice, err := account.coin.client.SuggestGasPrice(context.TODO())
+	suggestedGasPrice, err := account.coin.client.SuggestGasPrice(ctx)
 	if err != nil {
 		return nil, err
 	}
 	fee := new(big.Int).Mul(big.NewInt(gasLimit), suggestedGasPrice)
 
+	defer account.RLock()()
+
 	var value *big.Int
 	if amount.SendAll() {
 		value = new(big.Int).Sub(account.balance.BigInt(), fee)
@@ -237,7 +460,9 @@ func (account *Account) SendTx(
 	if err := account.keystores.SignTransaction(txProposal); err != nil {
 		return err
 	}
-	return account.coin.client.SendTransaction(context.TODO(), txProposal.Tx)
+	ctx, cancel := requestContext()
+	defer cancel()
+	return account.coin.client.SendTransaction(ctx, txProposal.Tx)
 }
 
 // FeeTargets implements btc.Interface.
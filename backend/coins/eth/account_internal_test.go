@@ -0,0 +1,309 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	coinpkg "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+)
+
+type subscriptionMock struct {
+	errChan chan error
+}
+
+func (subscription *subscriptionMock) Unsubscribe()      {}
+func (subscription *subscriptionMock) Err() <-chan error { return subscription.errChan }
+
+// clientMock is a node with a single account. If headersChan is nil, new block notifications are
+// not supported.
+type clientMock struct {
+	lock locker.Locker
+
+	blockNumber    int64
+	balance        int64
+	pendingBalance int64
+	nonce          uint64
+	offline        bool
+
+	headersChan chan<- *types.Header
+	supportsSub bool
+}
+
+func (client *clientMock) HeaderByNumber(_ context.Context, number *big.Int) (*types.Header, error) {
+	defer client.lock.RLock()()
+	if client.offline {
+		return nil, errp.New("offline")
+	}
+	return &types.Header{Number: big.NewInt(client.blockNumber)}, nil
+}
+
+func (client *clientMock) SubscribeNewHead(
+	_ context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	defer client.lock.Lock()()
+	if !client.supportsSub {
+		return nil, errp.New("notifications not supported")
+	}
+	client.headersChan = ch
+	return &subscriptionMock{errChan: make(chan error)}, nil
+}
+
+func (client *clientMock) BalanceAt(
+	_ context.Context, _ common.Address, blockNumber *big.Int) (*big.Int, error) {
+	defer client.lock.RLock()()
+	if client.offline {
+		return nil, errp.New("offline")
+	}
+	return big.NewInt(client.balance), nil
+}
+
+func (client *clientMock) PendingBalanceAt(_ context.Context, _ common.Address) (*big.Int, error) {
+	defer client.lock.RLock()()
+	return big.NewInt(client.pendingBalance), nil
+}
+
+func (client *clientMock) PendingNonceAt(_ context.Context, _ common.Address) (uint64, error) {
+	defer client.lock.RLock()()
+	return client.nonce, nil
+}
+
+func (client *clientMock) SuggestGasPrice(context.Context) (*big.Int, error) {
+	return big.NewInt(1e9), nil
+}
+
+func (client *clientMock) SendTransaction(context.Context, *types.Transaction) error {
+	return nil
+}
+
+// mineBlock advances the chain and notifies the subscriber, if any.
+func (client *clientMock) mineBlock(balance, pendingBalance int64) {
+	headersChan := func() chan<- *types.Header {
+		defer client.lock.Lock()()
+		client.blockNumber++
+		client.balance = balance
+		client.pendingBalance = pendingBalance
+		return client.headersChan
+	}()
+	if headersChan != nil {
+		headersChan <- &types.Header{Number: big.NewInt(client.blockNumber)}
+	}
+}
+
+func newTestAccount(t *testing.T, client Client) (*Account, <-chan Event, <-chan *btc.TxEvent) {
+	master, err := hdkeychain.NewMaster(make([]byte, hdkeychain.RecommendedSeedLen), &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	keypath, err := signing.NewAbsoluteKeypath("m/44'/60'/0'/0/0")
+	require.NoError(t, err)
+	xprv, err := keypath.Derive(master)
+	require.NoError(t, err)
+	xpub, err := xprv.Neuter()
+	require.NoError(t, err)
+	configuration := signing.NewSinglesigConfiguration(signing.ScriptTypeP2WPKH, keypath, xpub)
+
+	coin := NewCoin("teth", params.TestnetChainConfig, "", "", nil, "")
+	coin.client = client
+	events := make(chan Event, 100)
+	txEvents := make(chan *btc.TxEvent, 100)
+	account := NewAccount(coin, "", "teth", "Ethereum Testnet",
+		func() (*signing.Configuration, error) { return configuration, nil },
+		keystore.NewKeystores(software.NewKeystore(0, master)),
+		func(event Event) { events <- event },
+		[]int{1, 6},
+		func(event *btc.TxEvent) { txEvents <- event },
+		logging.Get().WithGroup("eth_test"))
+	return account, events, txEvents
+}
+
+func waitForEvent(t *testing.T, events <-chan Event, expected Event) {
+	for {
+		select {
+		case event := <-events:
+			if event == expected {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timeout waiting for event", string(expected))
+		}
+	}
+}
+
+func TestAccountSubscribeBlocks(t *testing.T) {
+	client := &clientMock{blockNumber: 100, balance: 10, pendingBalance: 10, supportsSub: true}
+	account, events, _ := newTestAccount(t, client)
+	require.NoError(t, account.Init())
+	defer account.Close()
+	waitForEvent(t, events, Event(btc.EventSyncDone))
+	require.Equal(t, big.NewInt(10), account.Balance().Available.BigInt())
+	require.Equal(t, big.NewInt(0), account.Balance().Incoming.BigInt())
+
+	// Wait for the subscription to be established.
+	for subscribed := false; !subscribed; {
+		time.Sleep(10 * time.Millisecond)
+		func() {
+			defer client.lock.RLock()()
+			subscribed = client.headersChan != nil
+		}()
+	}
+
+	client.mineBlock(20, 25)
+	waitForEvent(t, events, Event(btc.EventSyncStarted))
+	waitForEvent(t, events, Event(btc.EventSyncDone))
+	require.Equal(t, big.NewInt(20), account.Balance().Available.BigInt())
+	require.Equal(t, big.NewInt(5), account.Balance().Incoming.BigInt())
+	func() {
+		defer account.RLock()()
+		require.Equal(t, big.NewInt(101), account.blockNumber)
+	}()
+}
+
+func TestAccountPollBlocks(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+
+	client := &clientMock{blockNumber: 100, balance: 10, pendingBalance: 5}
+	account, events, _ := newTestAccount(t, client)
+	require.NoError(t, account.Init())
+	defer account.Close()
+	waitForEvent(t, events, Event(btc.EventSyncDone))
+	// Outgoing pending transactions do not count as incoming.
+	require.Equal(t, big.NewInt(0), account.Balance().Incoming.BigInt())
+
+	client.mineBlock(30, 30)
+	waitForEvent(t, events, Event(btc.EventSyncDone))
+	require.Equal(t, big.NewInt(30), account.Balance().Available.BigInt())
+
+	func() {
+		defer client.lock.Lock()()
+		client.offline = true
+	}()
+	waitForEvent(t, events, Event(btc.EventStatusChanged))
+	require.True(t, account.Offline())
+	func() {
+		defer client.lock.Lock()()
+		client.offline = false
+	}()
+	client.mineBlock(30, 30)
+	waitForEvent(t, events, Event(btc.EventStatusChanged))
+	require.False(t, account.Offline())
+}
+
+func TestSignerFollowsBlockNumber(t *testing.T) {
+	// EIP155 is active from block 10 on the test network.
+	client := &clientMock{blockNumber: 9, balance: 1e18, pendingBalance: 1e18}
+	account, events, _ := newTestAccount(t, client)
+	require.NoError(t, account.Init())
+	defer account.Close()
+	const recipient = "0x0000000000000000000000000000000000000001"
+	txProposal, err := account.newTx(recipient, coinpkg.NewSendAmount("0.1"))
+	require.NoError(t, err)
+	require.Equal(t, types.HomesteadSigner{}, txProposal.Signer)
+
+	account.onNewHeader(&types.Header{Number: big.NewInt(10)})
+	waitForEvent(t, events, Event(btc.EventSyncDone))
+	txProposal, err = account.newTx(recipient, coinpkg.NewSendAmount("0.1"))
+	require.NoError(t, err)
+	require.Equal(t, types.NewEIP155Signer(params.TestnetChainConfig.ChainID), txProposal.Signer)
+}
+
+// stalledClientMock is a node which never answers.
+type stalledClientMock struct {
+	clientMock
+}
+
+func (client *stalledClientMock) HeaderByNumber(ctx context.Context, _ *big.Int) (*types.Header, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAccountRequestTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { requestTimeout = timeout }(requestTimeout)
+	requestTimeout = 10 * time.Millisecond
+
+	account, _, _ := newTestAccount(t, &stalledClientMock{})
+	require.NoError(t, account.Init())
+	defer account.Close()
+	require.True(t, account.Offline())
+}
+
+func TestAccountOfflineAtInit(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+
+	client := &clientMock{blockNumber: 100, balance: 10, pendingBalance: 10, offline: true}
+	account, events, _ := newTestAccount(t, client)
+	require.NoError(t, account.Init())
+	defer account.Close()
+	require.True(t, account.Offline())
+
+	// The account keeps polling and refreshes once the node is reachable.
+	func() {
+		defer client.lock.Lock()()
+		client.offline = false
+	}()
+	waitForEvent(t, events, Event(btc.EventSyncDone))
+	require.False(t, account.Offline())
+	require.Equal(t, big.NewInt(10), account.Balance().Available.BigInt())
+	// A second Init does not start another subscription.
+	require.NoError(t, account.Init())
+}
+
+func TestBalanceTxEvents(t *testing.T) {
+	client := &clientMock{blockNumber: 100, balance: 10, pendingBalance: 10, supportsSub: true}
+	account, events, txEvents := newTestAccount(t, client)
+	require.NoError(t, account.Init())
+	defer account.Close()
+	waitForEvent(t, events, Event(btc.EventSyncDone))
+	// The initial balance is not notified about.
+	require.Empty(t, txEvents)
+	for subscribed := false; !subscribed; {
+		time.Sleep(10 * time.Millisecond)
+		func() {
+			defer client.lock.RLock()()
+			subscribed = client.headersChan != nil
+		}()
+	}
+	requireTxEvent := func(eventType btc.TxEventType, amount string, numConfirmations int) {
+		select {
+		case event := <-txEvents:
+			require.Equal(t, &btc.TxEvent{
+				Type:             eventType,
+				AccountCode:      "teth",
+				Amount:           amount,
+				Unit:             "TETH",
+				NumConfirmations: numConfirmations,
+			}, event)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timeout waiting for tx event", string(eventType))
+		}
+	}
+
+	// Funds arrive in the pending state, and are confirmed in the next block.
+	client.mineBlock(10, 1e18+10)
+	requireTxEvent(btc.TxEventIncoming, "1", 0)
+	client.mineBlock(1e18+10, 1e18+10)
+	requireTxEvent(btc.TxEventConfirmed, "1", 1)
+	// Funds arrive confirmed directly.
+	client.mineBlock(2e18+10, 2e18+10)
+	requireTxEvent(btc.TxEventIncoming, "1", 0)
+	requireTxEvent(btc.TxEventConfirmed, "1", 1)
+	// Funds are sent. The pending outgoing tx is not notified about.
+	client.mineBlock(2e18+10, 1e18+10)
+	client.mineBlock(1e18+10, 1e18+10)
+	requireTxEvent(btc.TxEventOutgoingConfirmed, "1", 1)
+	require.Empty(t, txEvents)
+}
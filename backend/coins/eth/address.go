@@ -1,6 +1,14 @@
 package eth
 
-import "github.com/ethereum/go-ethereum/common"
+import (
+	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
 
 // Address holds an Ethereum address and implements coin.Address.
 type Address struct {
@@ -16,3 +24,25 @@ func (address Address) ID() string {
 func (address Address) EncodeForHumans() string {
 	return address.Address.Hex()
 }
+
+// configurationAddress returns the address of the public key of a singlesig configuration.
+func configurationAddress(configuration *signing.Configuration) common.Address {
+	return crypto.PubkeyToAddress(*configuration.PublicKeys()[0].ToECDSA())
+}
+
+// ValidateAddress checks that the address is a hex encoded address. If it is given in mixed case, it
+// must match its EIP-55 checksum. coin.ErrInvalidAddress is returned otherwise.
+func ValidateAddress(address string) error {
+	if !common.IsHexAddress(address) {
+		return errp.WithStack(coin.ErrInvalidAddress)
+	}
+	hex := strings.TrimPrefix(strings.TrimPrefix(address, "0x"), "0X")
+	if hex == strings.ToLower(hex) || hex == strings.ToUpper(hex) {
+		// No checksum.
+		return nil
+	}
+	if common.HexToAddress(address).Hex()[2:] != hex {
+		return errp.WithStack(coin.ErrInvalidAddress)
+	}
+	return nil
+}
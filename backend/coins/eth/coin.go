@@ -1,33 +1,77 @@
 package eth
 
 import (
+	"context"
 	"math/big"
 	"strings"
+	"time"
 
 	coinpkg "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
+// Client is the part of the Ethereum node API used by the accounts. It is implemented by
+// *ethclient.Client.
+type Client interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	// SubscribeNewHead only works if the connection supports notifications (websockets, IPC). Over
+	// HTTP, it returns an error.
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	PendingBalanceAt(ctx context.Context, account common.Address) (*big.Int, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+// requestTimeout bounds each request to the node, so that a stalled node does not block the
+// account forever.
+var requestTimeout = 30 * time.Second
+
+// requestContext returns the context of a request to the node.
+func requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), requestTimeout)
+}
+
 // Coin models an Ethereum coin.
 type Coin struct {
 	observable.Implementation
-	client                *ethclient.Client
+	client                Client
 	code                  string
 	net                   *params.ChainConfig
+	nodeURL               string
+	httpNodeURL           string
+	socksProxy            *socksproxy.SocksProxy
 	blockExplorerTxPrefix string
 }
 
-// NewCoin creates a new coin with the given parameters.
+// NewCoin creates a new coin with the given parameters. nodeURL is the URL of the Ethereum node. A
+// websocket URL (wss://...) enables new block notifications, otherwise new blocks are polled.
+// Websocket connections cannot be made through a proxy, so unless the connections are made
+// directly, the node is connected to at httpNodeURL instead. If it is empty, nodeURL is used, which
+// must then be an HTTP(S) URL.
 func NewCoin(
 	code string,
 	net *params.ChainConfig,
+	nodeURL string,
+	httpNodeURL string,
+	socksProxy *socksproxy.SocksProxy,
 	blockExplorerTxPrefix string,
 ) *Coin {
 	return &Coin{
 		code:                  code,
 		net:                   net,
+		nodeURL:               nodeURL,
+		httpNodeURL:           httpNodeURL,
+		socksProxy:            socksProxy,
 		blockExplorerTxPrefix: blockExplorerTxPrefix,
 	}
 }
@@ -37,11 +81,7 @@ func (coin *Coin) Net() *params.ChainConfig { return coin.net }
 
 // Init implements coin.Coin.
 func (coin *Coin) Init() {
-	url := `https://mainnet.infura.io`
-	if coin.code == "teth" {
-		url = `https://ropsten.infura.io`
-	}
-	client, err := ethclient.Dial(url)
+	client, err := coin.dial()
 	if err != nil {
 		// TODO: init conn lazily, feed error via EventStatusChanged
 		panic(err)
@@ -49,6 +89,25 @@ func (coin *Coin) Init() {
 	coin.client = client
 }
 
+// dial connects to the node, through the proxy if it is enabled.
+func (coin *Coin) dial() (*ethclient.Client, error) {
+	if coin.socksProxy.Direct() {
+		return ethclient.Dial(coin.nodeURL)
+	}
+	nodeURL := coin.httpNodeURL
+	if nodeURL == "" {
+		nodeURL = coin.nodeURL
+	}
+	if !strings.HasPrefix(nodeURL, "http://") && !strings.HasPrefix(nodeURL, "https://") {
+		return nil, errp.Newf("Cannot connect to %s through the proxy", nodeURL)
+	}
+	client, err := rpc.DialHTTPWithClient(nodeURL, coin.socksProxy.HTTPClient(time.Minute))
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return ethclient.NewClient(client), nil
+}
+
 // Code implements coin.Coin.
 func (coin *Coin) Code() string {
 	return strings.ToUpper(coin.code)
@@ -68,6 +127,11 @@ func (coin *Coin) FormatAmount(amount coinpkg.Amount) string {
 	)
 }
 
+// ParseAmount implements coin.Coin.
+func (coin *Coin) ParseAmount(amount string) (coinpkg.Amount, error) {
+	return coinpkg.NewAmountFromString(amount, big.NewInt(1e18))
+}
+
 // BlockExplorerTransactionURLPrefix implements coin.Coin.
 func (coin *Coin) BlockExplorerTransactionURLPrefix() string {
 	return coin.blockExplorerTxPrefix
@@ -0,0 +1,59 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy/test"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+)
+
+// newNode returns a JSON-RPC server which answers eth_gasPrice.
+func newNode(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		require.Equal(t, "eth_gasPrice", request.Method)
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      request.ID,
+			"result":  "0x3b9aca00",
+		}))
+	}))
+}
+
+func TestDialThroughProxy(t *testing.T) {
+	node := newNode(t)
+	defer node.Close()
+	nodeURL, err := url.Parse(node.URL)
+	require.NoError(t, err)
+	proxy := test.NewProxy(t, map[string]string{"eth-node.example:80": nodeURL.Host})
+	socksProxy := socksproxy.NewSocksProxy(socksproxy.Config{
+		UseProxy:     true,
+		ProxyAddress: proxy.Address(),
+	})
+	// The websocket URL is not used through the proxy.
+	coin := NewCoin("teth", params.TestnetChainConfig, "wss://eth-node.example/ws",
+		"http://eth-node.example", socksProxy, "")
+	client, err := coin.dial()
+	require.NoError(t, err)
+	gasPrice, err := client.SuggestGasPrice(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1e9), gasPrice.Int64())
+	require.Equal(t, []string{"eth-node.example:80"}, proxy.Requests())
+
+	// Without an HTTP URL, a websocket URL cannot be used through the proxy.
+	_, err = NewCoin("teth", params.TestnetChainConfig, "wss://eth-node.example/ws",
+		"", socksProxy, "").dial()
+	require.Error(t, err)
+}
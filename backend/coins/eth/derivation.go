@@ -0,0 +1,40 @@
+package eth
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// DerivationScheme determines at which keypath the n-th Ethereum account of a wallet is located.
+type DerivationScheme string
+
+const (
+	// DerivationSchemeAddressIndex derives the accounts at m/44'/<coin>'/0'/0/<index>, like
+	// MetaMask or MyEtherWallet.
+	DerivationSchemeAddressIndex DerivationScheme = "addressIndex"
+	// DerivationSchemeAccountIndex derives the accounts at m/44'/<coin>'/<index>'/0/0, like Ledger
+	// Live.
+	DerivationSchemeAccountIndex DerivationScheme = "accountIndex"
+)
+
+// Keypath returns the keypath of the account with the given index. coinType is the BIP44 coin type
+// of the network (60 for mainnet).
+func (scheme DerivationScheme) Keypath(coinType uint32, index uint32) (signing.AbsoluteKeypath, error) {
+	keypath := signing.NewEmptyAbsoluteKeypath().
+		Child(44, signing.Hardened).
+		Child(coinType, signing.Hardened)
+	switch scheme {
+	case DerivationSchemeAddressIndex:
+		return keypath.
+			Child(0, signing.Hardened).
+			Child(0, signing.NonHardened).
+			Child(index, signing.NonHardened), nil
+	case DerivationSchemeAccountIndex:
+		return keypath.
+			Child(index, signing.Hardened).
+			Child(0, signing.NonHardened).
+			Child(0, signing.NonHardened), nil
+	default:
+		return signing.AbsoluteKeypath{}, errp.Newf("unknown derivation scheme %s", scheme)
+	}
+}
@@ -0,0 +1,25 @@
+package eth_test
+
+import (
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDerivationSchemeKeypath(t *testing.T) {
+	keypath, err := eth.DerivationSchemeAddressIndex.Keypath(60, 3)
+	require.NoError(t, err)
+	require.Equal(t, "m/44'/60'/0'/0/3", keypath.Encode())
+
+	keypath, err = eth.DerivationSchemeAccountIndex.Keypath(60, 3)
+	require.NoError(t, err)
+	require.Equal(t, "m/44'/60'/3'/0/0", keypath.Encode())
+
+	keypath, err = eth.DerivationSchemeAccountIndex.Keypath(1, 0)
+	require.NoError(t, err)
+	require.Equal(t, "m/44'/1'/0'/0/0", keypath.Encode())
+
+	_, err = eth.DerivationScheme("unknown").Keypath(60, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package eth
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// maxDiscoveredAccounts limits the number of accounts scanned by DiscoverAccounts, so that a
+// misbehaving node cannot make us derive addresses forever.
+const maxDiscoveredAccounts = 100
+
+// addressUsed returns true if the address has sent a transaction or holds funds.
+func (coin *Coin) addressUsed(address common.Address) (bool, error) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	nonce, err := coin.client.PendingNonceAt(ctx, address)
+	if err != nil {
+		return false, errp.WithStack(err)
+	}
+	if nonce > 0 {
+		return true, nil
+	}
+	balance, err := coin.client.BalanceAt(ctx, address, nil)
+	if err != nil {
+		return false, errp.WithStack(err)
+	}
+	return balance.Sign() > 0, nil
+}
+
+// DiscoverAccounts scans the accounts starting at index `from` and returns the index of the first
+// unused account. getSigningConfiguration returns the configuration of the account with the given
+// index.
+func (coin *Coin) DiscoverAccounts(
+	from uint32,
+	getSigningConfiguration func(uint32) (*signing.Configuration, error),
+) (uint32, error) {
+	for index := from; index < maxDiscoveredAccounts; index++ {
+		signingConfiguration, err := getSigningConfiguration(index)
+		if err != nil {
+			return 0, err
+		}
+		used, err := coin.addressUsed(configurationAddress(signingConfiguration))
+		if err != nil {
+			return 0, err
+		}
+		if !used {
+			return index, nil
+		}
+	}
+	return maxDiscoveredAccounts, nil
+}
@@ -0,0 +1,70 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+)
+
+// addressesClientMock is a node on which some addresses have sent transactions or received funds.
+type addressesClientMock struct {
+	clientMock
+	nonces   map[common.Address]uint64
+	balances map[common.Address]int64
+}
+
+func (client *addressesClientMock) BalanceAt(
+	_ context.Context, address common.Address, _ *big.Int) (*big.Int, error) {
+	return big.NewInt(client.balances[address]), nil
+}
+
+func (client *addressesClientMock) PendingNonceAt(_ context.Context, address common.Address) (uint64, error) {
+	return client.nonces[address], nil
+}
+
+func TestDiscoverAccounts(t *testing.T) {
+	master, err := hdkeychain.NewMaster(make([]byte, hdkeychain.RecommendedSeedLen), &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	getSigningConfiguration := func(index uint32) (*signing.Configuration, error) {
+		keypath, err := DerivationSchemeAddressIndex.Keypath(1, index)
+		require.NoError(t, err)
+		xprv, err := keypath.Derive(master)
+		require.NoError(t, err)
+		xpub, err := xprv.Neuter()
+		require.NoError(t, err)
+		return signing.NewSinglesigConfiguration(signing.ScriptTypeP2WPKH, keypath, xpub), nil
+	}
+	address := func(index uint32) common.Address {
+		signingConfiguration, err := getSigningConfiguration(index)
+		require.NoError(t, err)
+		return configurationAddress(signingConfiguration)
+	}
+
+	client := &addressesClientMock{
+		// Account 0 has sent a transaction, account 1 has received funds, account 3 is used but
+		// after a gap.
+		nonces:   map[common.Address]uint64{address(0): 1},
+		balances: map[common.Address]int64{address(1): 1, address(3): 1},
+	}
+	coin := NewCoin("teth", params.TestnetChainConfig, "", "", nil, "")
+	coin.client = client
+
+	next, err := coin.DiscoverAccounts(0, getSigningConfiguration)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), next)
+
+	next, err = coin.DiscoverAccounts(2, getSigningConfiguration)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), next)
+
+	next, err = coin.DiscoverAccounts(3, getSigningConfiguration)
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), next)
+}
@@ -0,0 +1,83 @@
+package eth
+
+import (
+	"fmt"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// messageHash returns the hash signed by personal_sign according to EIP-191:
+// keccak256("\x19Ethereum Signed Message:\n" ‖ len(message) ‖ message).
+func messageHash(message []byte) []byte {
+	prefix := fmt.Sprintf("\x19Ethereum Signed Message:\n%d", len(message))
+	return crypto.Keccak256([]byte(prefix), message)
+}
+
+// SignMessage signs the message with the key of this account as done by personal_sign. Returns
+// the 65 byte signature [R ‖ S ‖ V], with V being 27 or 28.
+func (account *Account) SignMessage(message []byte) ([]byte, error) {
+	return account.signHash(messageHash(message))
+}
+
+// SignTypedData signs the typed data with the key of this account according to EIP-712. Returns
+// the 65 byte signature [R ‖ S ‖ V], with V being 27 or 28.
+func (account *Account) SignTypedData(typedData *TypedData) ([]byte, error) {
+	hash, err := typedData.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return account.signHash(hash)
+}
+
+func (account *Account) signHash(hash []byte) ([]byte, error) {
+	signingConfiguration := func() *signing.Configuration {
+		defer account.RLock()()
+		return account.signingConfiguration
+	}()
+	if signingConfiguration == nil {
+		return nil, errp.New("the account has not been initialized")
+	}
+	signature, err := account.keystores.SignHash(hash, signingConfiguration.AbsoluteKeypath())
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) != 65 {
+		return nil, errp.New("unexpected signature length")
+	}
+	signature[64] += 27
+	return signature, nil
+}
+
+// recoverSigner returns the address of the key which created the signature over the hash.
+func recoverSigner(hash []byte, signature []byte) (common.Address, error) {
+	if len(signature) != 65 {
+		return common.Address{}, errp.New("the signature must be 65 bytes long")
+	}
+	// Accept V as recovery ID (0, 1) as well as with the offset of 27 added by personal_sign.
+	signature = append([]byte{}, signature...)
+	if signature[64] >= 27 {
+		signature[64] -= 27
+	}
+	publicKey, err := crypto.SigToPub(hash, signature)
+	if err != nil {
+		return common.Address{}, errp.WithStack(err)
+	}
+	return crypto.PubkeyToAddress(*publicKey), nil
+}
+
+// RecoverMessageSigner returns the address which signed the message with personal_sign.
+func RecoverMessageSigner(message []byte, signature []byte) (common.Address, error) {
+	return recoverSigner(messageHash(message), signature)
+}
+
+// RecoverTypedDataSigner returns the address which signed the typed data according to EIP-712.
+func RecoverTypedDataSigner(typedData *TypedData, signature []byte) (common.Address, error) {
+	hash, err := typedData.Hash()
+	if err != nil {
+		return common.Address{}, err
+	}
+	return recoverSigner(hash, signature)
+}
@@ -0,0 +1,69 @@
+package eth
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageHash(t *testing.T) {
+	// Computed with web3.eth.accounts.hashMessage("Hello World").
+	require.Equal(t,
+		"0xa1de988600a42c4b4ab089b619297c17d53cffae5d5120d82d8a92d0bb3b78f2",
+		hexutil.Encode(messageHash([]byte("Hello World"))))
+}
+
+func TestAccountSignMessage(t *testing.T) {
+	client := &clientMock{blockNumber: 100}
+	account, _, _ := newTestAccount(t, client)
+	require.NoError(t, account.Init())
+	defer account.Close()
+
+	message := []byte("Please sign this login challenge: 1234")
+	signature, err := account.SignMessage(message)
+	require.NoError(t, err)
+	require.Len(t, signature, 65)
+	require.Contains(t, []byte{27, 28}, signature[64])
+	signer, err := RecoverMessageSigner(message, signature)
+	require.NoError(t, err)
+	require.Equal(t, account.address.Address, signer)
+
+	signer, err = RecoverMessageSigner([]byte("another message"), signature)
+	require.NoError(t, err)
+	require.NotEqual(t, account.address.Address, signer)
+
+	var typedData TypedData
+	require.NoError(t, json.Unmarshal([]byte(mailTypedData), &typedData))
+	signature, err = account.SignTypedData(&typedData)
+	require.NoError(t, err)
+	signer, err = RecoverTypedDataSigner(&typedData, signature)
+	require.NoError(t, err)
+	require.Equal(t, account.address.Address, signer)
+}
+
+func TestRecoverSigner(t *testing.T) {
+	// Signature of the EIP-712 example, signed with keccak256("cow").
+	privateKey, err := crypto.ToECDSA(crypto.Keccak256([]byte("cow")))
+	require.NoError(t, err)
+	var typedData TypedData
+	require.NoError(t, json.Unmarshal([]byte(mailTypedData), &typedData))
+	signature := hexutil.MustDecode("0x" +
+		"4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d" +
+		"07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b91562" +
+		"1c")
+	signer, err := RecoverTypedDataSigner(&typedData, signature)
+	require.NoError(t, err)
+	require.Equal(t, crypto.PubkeyToAddress(privateKey.PublicKey), signer)
+
+	// The recovery ID without offset is accepted as well.
+	signature[64] = 1
+	signer, err = RecoverTypedDataSigner(&typedData, signature)
+	require.NoError(t, err)
+	require.Equal(t, crypto.PubkeyToAddress(privateKey.PublicKey), signer)
+
+	_, err = RecoverTypedDataSigner(&typedData, signature[:64])
+	require.Error(t, err)
+}
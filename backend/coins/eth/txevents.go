@@ -0,0 +1,56 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"math/big"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+)
+
+// balanceTxEvents returns the TxEvents derived from a change of the balance, as the transactions of
+// Ethereum accounts are not indexed. Funds arriving, pending or not, are notified as incoming.
+// Changes of the confirmed balance are notified as confirmed at one confirmation, if confirmations
+// are notified about at all. The events have no txid.
+func (account *Account) balanceTxEvents(
+	previousBalance, previousIncoming, balance, incoming *big.Int) []*btc.TxEvent {
+	event := func(eventType btc.TxEventType, amount *big.Int, numConfirmations int) *btc.TxEvent {
+		return &btc.TxEvent{
+			Type:             eventType,
+			AccountCode:      account.code,
+			Amount:           account.coin.FormatAmount(coin.NewAmount(amount)),
+			Unit:             account.coin.Unit(),
+			NumConfirmations: numConfirmations,
+		}
+	}
+	events := []*btc.TxEvent{}
+	// The pending balance, not counting outgoing pending transactions.
+	previousPending := new(big.Int).Add(previousBalance, previousIncoming)
+	pending := new(big.Int).Add(balance, incoming)
+	if received := new(big.Int).Sub(pending, previousPending); received.Sign() > 0 {
+		events = append(events, event(btc.TxEventIncoming, received, 0))
+	}
+	if !account.notifyConfirmations {
+		return events
+	}
+	switch change := new(big.Int).Sub(balance, previousBalance); change.Sign() {
+	case 1:
+		events = append(events, event(btc.TxEventConfirmed, change, 1))
+	case -1:
+		events = append(events, event(btc.TxEventOutgoingConfirmed, change.Neg(change), 1))
+	}
+	return events
+}
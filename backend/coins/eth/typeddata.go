@@ -0,0 +1,280 @@
+package eth
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TypedDataField is a member of a struct type of typed data.
+type TypedDataField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// TypedData is structured data to be signed according to EIP-712, in the JSON format of
+// eth_signTypedData.
+type TypedData struct {
+	Types       map[string][]TypedDataField `json:"types"`
+	PrimaryType string                      `json:"primaryType"`
+	Domain      map[string]interface{}      `json:"domain"`
+	Message     map[string]interface{}      `json:"message"`
+}
+
+const typedDataDomainType = "EIP712Domain"
+
+var (
+	typedDataArrayRegexp = regexp.MustCompile(`^(.*)\[([0-9]*)\]$`)
+	typedDataIntRegexp   = regexp.MustCompile(`^(u?)int([0-9]*)$`)
+	typedDataBytesRegexp = regexp.MustCompile(`^bytes([0-9]+)$`)
+)
+
+// Hash returns the hash to be signed: keccak256("\x19\x01" ‖ domainSeparator ‖ hashStruct(message)).
+func (typedData *TypedData) Hash() ([]byte, error) {
+	if _, ok := typedData.Types[typedDataDomainType]; !ok {
+		return nil, errp.Newf("typed data is missing the type %s", typedDataDomainType)
+	}
+	domainSeparator, err := typedData.hashStruct(typedDataDomainType, typedData.Domain)
+	if err != nil {
+		return nil, err
+	}
+	messageHash, err := typedData.hashStruct(typedData.PrimaryType, typedData.Message)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator, messageHash), nil
+}
+
+func (typedData *TypedData) hashStruct(typeName string, data map[string]interface{}) ([]byte, error) {
+	encodedData, err := typedData.encodeData(typeName, data)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256(encodedData), nil
+}
+
+// dependencies adds the struct types referenced by typeName, including itself, to found.
+func (typedData *TypedData) dependencies(typeName string, found map[string]struct{}) {
+	// Strip array suffixes, e.g. Person[][2] -> Person.
+	for {
+		match := typedDataArrayRegexp.FindStringSubmatch(typeName)
+		if match == nil {
+			break
+		}
+		typeName = match[1]
+	}
+	if _, ok := found[typeName]; ok {
+		return
+	}
+	fields, ok := typedData.Types[typeName]
+	if !ok {
+		return
+	}
+	found[typeName] = struct{}{}
+	for _, field := range fields {
+		typedData.dependencies(field.Type, found)
+	}
+}
+
+// encodeType returns the type signature of the struct, followed by the ones of all referenced
+// struct types in alphabetical order, e.g. "Mail(Person from,Person to,string contents)Person(...)".
+func (typedData *TypedData) encodeType(typeName string) string {
+	found := map[string]struct{}{}
+	typedData.dependencies(typeName, found)
+	delete(found, typeName)
+	typeNames := []string{}
+	for dependency := range found {
+		typeNames = append(typeNames, dependency)
+	}
+	sort.Strings(typeNames)
+	typeNames = append([]string{typeName}, typeNames...)
+
+	var buffer bytes.Buffer
+	for _, name := range typeNames {
+		buffer.WriteString(name)
+		buffer.WriteString("(")
+		for index, field := range typedData.Types[name] {
+			if index > 0 {
+				buffer.WriteString(",")
+			}
+			buffer.WriteString(field.Type + " " + field.Name)
+		}
+		buffer.WriteString(")")
+	}
+	return buffer.String()
+}
+
+func (typedData *TypedData) encodeData(typeName string, data map[string]interface{}) ([]byte, error) {
+	fields, ok := typedData.Types[typeName]
+	if !ok {
+		return nil, errp.Newf("unknown type %s", typeName)
+	}
+	if len(data) > len(fields) {
+		return nil, errp.Newf("the data has more fields than the type %s", typeName)
+	}
+	var buffer bytes.Buffer
+	buffer.Write(crypto.Keccak256([]byte(typedData.encodeType(typeName))))
+	for _, field := range fields {
+		value, ok := data[field.Name]
+		if !ok {
+			return nil, errp.Newf("missing field %s of type %s", field.Name, typeName)
+		}
+		encodedValue, err := typedData.encodeValue(field.Type, value)
+		if err != nil {
+			return nil, errp.WithMessage(err, field.Name)
+		}
+		buffer.Write(encodedValue)
+	}
+	return buffer.Bytes(), nil
+}
+
+// encodeValue returns the 32 byte encoding of a value of the given type.
+func (typedData *TypedData) encodeValue(typeName string, value interface{}) ([]byte, error) {
+	if match := typedDataArrayRegexp.FindStringSubmatch(typeName); match != nil {
+		elements, ok := value.([]interface{})
+		if !ok {
+			return nil, errp.Newf("expected an array of %s", match[1])
+		}
+		if match[2] != "" {
+			length, err := strconv.Atoi(match[2])
+			if err != nil || length != len(elements) {
+				return nil, errp.Newf("expected %s elements", match[2])
+			}
+		}
+		var buffer bytes.Buffer
+		for _, element := range elements {
+			encodedElement, err := typedData.encodeValue(match[1], element)
+			if err != nil {
+				return nil, err
+			}
+			buffer.Write(encodedElement)
+		}
+		return crypto.Keccak256(buffer.Bytes()), nil
+	}
+	if _, ok := typedData.Types[typeName]; ok {
+		data, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errp.Newf("expected a %s", typeName)
+		}
+		return typedData.hashStruct(typeName, data)
+	}
+	switch typeName {
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return nil, errp.New("expected a string")
+		}
+		return crypto.Keccak256([]byte(text)), nil
+	case "bytes":
+		data, err := typedDataBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.Keccak256(data), nil
+	case "bool":
+		boolean, ok := value.(bool)
+		if !ok {
+			return nil, errp.New("expected a bool")
+		}
+		if boolean {
+			return math.PaddedBigBytes(big.NewInt(1), 32), nil
+		}
+		return make([]byte, 32), nil
+	case "address":
+		text, ok := value.(string)
+		if !ok || !common.IsHexAddress(text) {
+			return nil, errp.New("expected an address")
+		}
+		return common.LeftPadBytes(common.HexToAddress(text).Bytes(), 32), nil
+	}
+	if match := typedDataBytesRegexp.FindStringSubmatch(typeName); match != nil {
+		length, err := strconv.Atoi(match[1])
+		if err != nil || length < 1 || length > 32 {
+			return nil, errp.Newf("invalid type %s", typeName)
+		}
+		data, err := typedDataBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != length {
+			return nil, errp.Newf("expected %d bytes", length)
+		}
+		return common.RightPadBytes(data, 32), nil
+	}
+	if match := typedDataIntRegexp.FindStringSubmatch(typeName); match != nil {
+		bits := 256
+		if match[2] != "" {
+			var err error
+			bits, err = strconv.Atoi(match[2])
+			if err != nil || bits < 8 || bits > 256 || bits%8 != 0 {
+				return nil, errp.Newf("invalid type %s", typeName)
+			}
+		}
+		number, err := typedDataInteger(value)
+		if err != nil {
+			return nil, err
+		}
+		unsigned := match[1] == "u"
+		// For negative numbers, -number-1 has to fit into the remaining bits.
+		magnitude := number
+		if number.Sign() < 0 {
+			magnitude = new(big.Int).Not(number)
+		}
+		if (unsigned && (number.Sign() < 0 || number.BitLen() > bits)) ||
+			(!unsigned && magnitude.BitLen() > bits-1) {
+			return nil, errp.Newf("the number does not fit into %s", typeName)
+		}
+		return math.PaddedBigBytes(math.U256(number), 32), nil
+	}
+	return nil, errp.Newf("unknown type %s", typeName)
+}
+
+func typedDataBytes(value interface{}) ([]byte, error) {
+	text, ok := value.(string)
+	if !ok {
+		return nil, errp.New("expected hex encoded bytes")
+	}
+	data, err := hexutil.Decode(text)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return data, nil
+}
+
+// typedDataInteger parses a number given as a JSON number or as a decimal or 0x-prefixed hex
+// string.
+func typedDataInteger(value interface{}) (*big.Int, error) {
+	var text string
+	switch number := value.(type) {
+	case string:
+		text = number
+	case json.Number:
+		text = number.String()
+	case float64:
+		if number != float64(int64(number)) {
+			return nil, errp.New("expected an integer")
+		}
+		return big.NewInt(int64(number)), nil
+	default:
+		return nil, errp.New("expected a number")
+	}
+	negative := strings.HasPrefix(text, "-")
+	result, ok := math.ParseBig256(strings.TrimPrefix(text, "-"))
+	if !ok {
+		return nil, errp.Newf("invalid number %s", text)
+	}
+	if negative {
+		result.Neg(result)
+	}
+	return result, nil
+}
@@ -0,0 +1,112 @@
+package eth
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+// mailTypedData is the example of EIP-712.
+const mailTypedData = `{
+  "types": {
+    "EIP712Domain": [
+      {"name": "name", "type": "string"},
+      {"name": "version", "type": "string"},
+      {"name": "chainId", "type": "uint256"},
+      {"name": "verifyingContract", "type": "address"}
+    ],
+    "Person": [
+      {"name": "name", "type": "string"},
+      {"name": "wallet", "type": "address"}
+    ],
+    "Mail": [
+      {"name": "from", "type": "Person"},
+      {"name": "to", "type": "Person"},
+      {"name": "contents", "type": "string"}
+    ]
+  },
+  "primaryType": "Mail",
+  "domain": {
+    "name": "Ether Mail",
+    "version": "1",
+    "chainId": 1,
+    "verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+  },
+  "message": {
+    "from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+    "to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+    "contents": "Hello, Bob!"
+  }
+}`
+
+func TestTypedDataHash(t *testing.T) {
+	var typedData TypedData
+	require.NoError(t, json.Unmarshal([]byte(mailTypedData), &typedData))
+
+	require.Equal(t,
+		"Mail(Person from,Person to,string contents)Person(string name,address wallet)",
+		typedData.encodeType("Mail"))
+	require.Equal(t,
+		"0xa0cedeb2dc280ba39b857546d74f5549c3a1d7bdc2dd96bf881f76108e23dac2",
+		hexutil.Encode(crypto.Keccak256([]byte(typedData.encodeType("Mail")))))
+
+	domainSeparator, err := typedData.hashStruct("EIP712Domain", typedData.Domain)
+	require.NoError(t, err)
+	require.Equal(t,
+		"0xf2cee375fa42b42143804025fc449deafd50cc031ca257e0b194a650a912090f",
+		hexutil.Encode(domainSeparator))
+	messageHash, err := typedData.hashStruct("Mail", typedData.Message)
+	require.NoError(t, err)
+	require.Equal(t,
+		"0xc52c0ee5d84264471806290a3f2c4cecfc5490626bf912d01f240d7a274b371e",
+		hexutil.Encode(messageHash))
+
+	hash, err := typedData.Hash()
+	require.NoError(t, err)
+	require.Equal(t,
+		"0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2",
+		hexutil.Encode(hash))
+}
+
+func TestTypedDataEncodeValue(t *testing.T) {
+	typedData := &TypedData{Types: map[string][]TypedDataField{}}
+	encode := func(typeName string, value interface{}) string {
+		encoded, err := typedData.encodeValue(typeName, value)
+		require.NoError(t, err)
+		return hexutil.Encode(encoded)
+	}
+	require.Equal(t,
+		"0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff80",
+		encode("int8", "-128"))
+	require.Equal(t,
+		"0x00000000000000000000000000000000000000000000000000000000000000ff",
+		encode("uint8", json.Number("0xff")))
+	require.Equal(t,
+		"0x0000000000000000000000000000000000000000000000000000000000000001",
+		encode("bool", true))
+	require.Equal(t,
+		"0x1234000000000000000000000000000000000000000000000000000000000000",
+		encode("bytes2", "0x1234"))
+	require.Equal(t,
+		hexutil.Encode(crypto.Keccak256(make([]byte, 31), []byte{1}, make([]byte, 31), []byte{2})),
+		encode("uint256[2]", []interface{}{1.0, "2"}))
+
+	for _, invalid := range []struct {
+		typeName string
+		value    interface{}
+	}{
+		{"int8", "128"},
+		{"uint8", "256"},
+		{"uint8", "-1"},
+		{"bytes2", "0x12"},
+		{"address", "0x12"},
+		{"uint256[2]", []interface{}{1.0}},
+		{"unknown", "1"},
+	} {
+		_, err := typedData.encodeValue(invalid.typeName, invalid.value)
+		require.Error(t, err, invalid.typeName)
+	}
+}
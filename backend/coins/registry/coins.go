@@ -0,0 +1,218 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/signet"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// shiftServers returns the Electrum servers of Shift Crypto with the given addresses, which are
+// verified with its CA.
+func shiftServers(servers ...string) []*rpc.ServerInfo {
+	result := []*rpc.ServerInfo{}
+	for _, server := range servers {
+		result = append(result, &rpc.ServerInfo{Server: server, TLS: true, PEMCert: shiftRootCA})
+	}
+	return result
+}
+
+// devServers returns the Electrum servers used in dev mode with the given addresses.
+func devServers(servers ...string) []*rpc.ServerInfo {
+	result := []*rpc.ServerInfo{}
+	for _, server := range servers {
+		result = append(result, &rpc.ServerInfo{Server: server, TLS: true, PEMCert: devShiftCA})
+	}
+	return result
+}
+
+func init() {
+	MustRegister(&Definition{
+		Code:       "rbtc",
+		Unit:       "RBTC",
+		Type:       TypeUTXO,
+		URIScheme:  "bitcoin",
+		Network:    NetworkRegtest,
+		UTXOParams: &chaincfg.RegressionNetParams,
+		Servers:    []*rpc.ServerInfo{{Server: "127.0.0.1:52001", TLS: false, PEMCert: ""}},
+		Accounts: []AccountTemplate{
+			{
+				Code: "rbtc-p2pkh", Name: "Bitcoin Regtest Legacy", Keypath: "m/44'/1'/0'",
+				ScriptType: signing.ScriptTypeP2PKH, ActiveSetting: "bitcoinP2PKHActive",
+			},
+			{
+				Code: "rbtc-p2wpkh-p2sh", Name: "Bitcoin Regtest Segwit", Keypath: "m/49'/1'/0'",
+				ScriptType: signing.ScriptTypeP2WPKHP2SH, ActiveSetting: "bitcoinP2WPKHP2SHActive",
+			},
+		},
+	})
+	MustRegister(&Definition{
+		Code:                  "tbtc",
+		Unit:                  "TBTC",
+		Type:                  TypeUTXO,
+		URIScheme:             "bitcoin",
+		Network:               NetworkTestnet,
+		BlockExplorerTxPrefix: "https://testnet.blockchain.info/tx/",
+		UTXOParams:            &chaincfg.TestNet3Params,
+		Servers:               shiftServers("btc.shiftcrypto.ch:51002", "merkle.shiftcrypto.ch:51002"),
+		DevServers:            devServers("s1.dev.shiftcrypto.ch:51003", "s2.dev.shiftcrypto.ch:51003"),
+		Accounts: []AccountTemplate{
+			{
+				Code: "tbtc-p2wpkh-p2sh", Name: "Bitcoin Testnet", Keypath: "m/49'/1'/0'",
+				ScriptType: signing.ScriptTypeP2WPKHP2SH, ActiveSetting: "bitcoinP2WPKHP2SHActive",
+			},
+			{
+				Code: "tbtc-p2wpkh", Name: "Bitcoin Testnet: bech32", Keypath: "m/84'/1'/0'",
+				ScriptType: signing.ScriptTypeP2WPKH, ActiveSetting: "bitcoinP2WPKHActive",
+			},
+			{
+				Code: "tbtc-p2pkh", Name: "Bitcoin Testnet Legacy", Keypath: "m/44'/1'/0'",
+				ScriptType: signing.ScriptTypeP2PKH, ActiveSetting: "bitcoinP2PKHActive",
+			},
+		},
+	})
+	MustRegister(&Definition{
+		Code:                  "tbtc-signet",
+		Unit:                  "sBTC",
+		Type:                  TypeUTXO,
+		URIScheme:             "bitcoin",
+		Network:               NetworkTestnet,
+		BlockExplorerTxPrefix: "https://mempool.space/signet/tx/",
+		// The chain params are replaced by the ones of a custom signet if a challenge is configured.
+		UTXOParams: signet.DefaultParams,
+		// There are no default servers, so the accounts are disabled until they are enabled in
+		// accountsActive, after configuring the servers.
+		Servers: []*rpc.ServerInfo{},
+		Accounts: []AccountTemplate{
+			{
+				Code: "tbtc-signet-p2wpkh-p2sh", Name: "Bitcoin Signet", Keypath: "m/49'/1'/0'",
+				ScriptType: signing.ScriptTypeP2WPKHP2SH,
+			},
+			{
+				Code: "tbtc-signet-p2wpkh", Name: "Bitcoin Signet: bech32", Keypath: "m/84'/1'/0'",
+				ScriptType: signing.ScriptTypeP2WPKH,
+			},
+			{
+				Code: "tbtc-signet-p2pkh", Name: "Bitcoin Signet Legacy", Keypath: "m/44'/1'/0'",
+				ScriptType: signing.ScriptTypeP2PKH,
+			},
+		},
+	})
+	MustRegister(&Definition{
+		Code:                  "tltc",
+		Unit:                  "TLTC",
+		Type:                  TypeUTXO,
+		URIScheme:             "litecoin",
+		Network:               NetworkTestnet,
+		BlockExplorerTxPrefix: "http://explorer.litecointools.com/tx/",
+		UTXOParams:            &ltc.TestNet4Params,
+		Servers:               shiftServers("ltc.shiftcrypto.ch:51004", "ltc.shamir.shiftcrypto.ch:51004"),
+		DevServers:            devServers("dev.shiftcrypto.ch:51004"),
+		Accounts: []AccountTemplate{
+			{
+				Code: "tltc-p2wpkh-p2sh", Name: "Litecoin Testnet", Keypath: "m/49'/1'/0'",
+				ScriptType: signing.ScriptTypeP2WPKHP2SH, ActiveSetting: "litecoinP2WPKHP2SHActive",
+			},
+			{
+				Code: "tltc-p2wpkh", Name: "Litecoin Testnet: bech32", Keypath: "m/84'/1'/0'",
+				ScriptType: signing.ScriptTypeP2WPKH, ActiveSetting: "litecoinP2WPKHActive",
+			},
+		},
+	})
+	MustRegister(&Definition{
+		Code:                  "teth",
+		Unit:                  "TETH",
+		Type:                  TypeETH,
+		Network:               NetworkTestnet,
+		DevModeOnly:           true,
+		BlockExplorerTxPrefix: "https://ropsten.etherscan.io/address/",
+		ETHParams:             params.TestnetChainConfig,
+		ETHNodeURL:            "wss://ropsten.infura.io/ws",
+		ETHNodeHTTPURL:        "https://ropsten.infura.io",
+		BIP44CoinType:         1,
+		Accounts: []AccountTemplate{
+			{Code: "teth", Name: "Ethereum Testnet", ActiveSetting: "ethereumActive"},
+		},
+	})
+	MustRegister(&Definition{
+		Code:                  "btc",
+		Unit:                  "BTC",
+		Type:                  TypeUTXO,
+		URIScheme:             "bitcoin",
+		Network:               NetworkMainnet,
+		BlockExplorerTxPrefix: "https://blockchain.info/tx/",
+		RatesUnit:             "BTC",
+		UTXOParams:            &chaincfg.MainNetParams,
+		Servers:               shiftServers("btc.shiftcrypto.ch:443", "merkle.shiftcrypto.ch:443"),
+		DevServers:            devServers("dev.shiftcrypto.ch:50002"),
+		Accounts: []AccountTemplate{
+			{
+				Code: "btc-p2wpkh-p2sh", Name: "Bitcoin", Keypath: "m/49'/0'/0'",
+				ScriptType: signing.ScriptTypeP2WPKHP2SH, ActiveSetting: "bitcoinP2WPKHP2SHActive",
+			},
+			{
+				Code: "btc-p2wpkh", Name: "Bitcoin: bech32", Keypath: "m/84'/0'/0'",
+				ScriptType: signing.ScriptTypeP2WPKH, ActiveSetting: "bitcoinP2WPKHActive",
+			},
+			{
+				Code: "btc-p2pkh", Name: "Bitcoin Legacy", Keypath: "m/44'/0'/0'",
+				ScriptType: signing.ScriptTypeP2PKH, ActiveSetting: "bitcoinP2PKHActive",
+			},
+		},
+	})
+	MustRegister(&Definition{
+		Code:                  "ltc",
+		Unit:                  "LTC",
+		Type:                  TypeUTXO,
+		URIScheme:             "litecoin",
+		Network:               NetworkMainnet,
+		BlockExplorerTxPrefix: "https://insight.litecore.io/tx/",
+		RatesUnit:             "LTC",
+		UTXOParams:            &ltc.MainNetParams,
+		Servers:               shiftServers("ltc.shiftcrypto.ch:443", "ltc.shamir.shiftcrypto.ch:443"),
+		DevServers:            devServers("dev.shiftcrypto.ch:50004"),
+		Accounts: []AccountTemplate{
+			{
+				Code: "ltc-p2wpkh-p2sh", Name: "Litecoin", Keypath: "m/49'/2'/0'",
+				ScriptType: signing.ScriptTypeP2WPKHP2SH, ActiveSetting: "litecoinP2WPKHP2SHActive",
+			},
+			{
+				Code: "ltc-p2wpkh", Name: "Litecoin: bech32", Keypath: "m/84'/2'/0'",
+				ScriptType: signing.ScriptTypeP2WPKH, ActiveSetting: "litecoinP2WPKHActive",
+			},
+		},
+	})
+	MustRegister(&Definition{
+		Code:                  "eth",
+		Unit:                  "ETH",
+		Type:                  TypeETH,
+		Network:               NetworkMainnet,
+		RatesUnit:             "ETH",
+		DevModeOnly:           true,
+		BlockExplorerTxPrefix: "https://etherscan.io/address/",
+		ETHParams:             params.MainnetChainConfig,
+		ETHNodeURL:            "wss://mainnet.infura.io/ws",
+		ETHNodeHTTPURL:        "https://mainnet.infura.io",
+		BIP44CoinType:         60,
+		Accounts: []AccountTemplate{
+			{Code: "eth", Name: "Ethereum", ActiveSetting: "ethereumActive"},
+		},
+	})
+}
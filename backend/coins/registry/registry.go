@@ -0,0 +1,193 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package registry holds the definitions of all supported coins. The backend and the API handlers
+// are driven by these definitions, so that a coin is added by registering it here.
+package registry
+
+import (
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// Type is the kind of blockchain of a coin.
+type Type string
+
+const (
+	// TypeUTXO is a Bitcoin-like coin, backed by Electrum servers.
+	TypeUTXO Type = "utxo"
+	// TypeETH is an Ethereum-like coin, backed by an Ethereum node.
+	TypeETH Type = "eth"
+)
+
+// Network determines with which app arguments a coin is available.
+type Network string
+
+const (
+	// NetworkMainnet coins are available if the app is not started in testing mode.
+	NetworkMainnet Network = "mainnet"
+	// NetworkTestnet coins are available in testing mode.
+	NetworkTestnet Network = "testnet"
+	// NetworkRegtest coins are available in testing mode with regtest enabled.
+	NetworkRegtest Network = "regtest"
+)
+
+// AccountTemplate describes an account which is added for a coin once a keystore is registered.
+type AccountTemplate struct {
+	// Code identifies the account, e.g. btc-p2wpkh. For Ethereum coins, it is the code of the
+	// first account, and the following accounts are coded by their account index, e.g. eth-1.
+	Code string
+	// Name is shown to the user.
+	Name string
+	// Keypath is the account level keypath. Unused for Ethereum coins, whose keypaths depend on the
+	// configured derivation scheme.
+	Keypath    string
+	ScriptType signing.ScriptType
+	// ActiveSetting is the JSON key of a dedicated setting in the backend config enabling the
+	// account, e.g. bitcoinP2PKHActive. If empty, the account is enabled through the generic
+	// accountsActive setting, which defaults to ActiveByDefault.
+	ActiveSetting   string
+	ActiveByDefault bool
+}
+
+// Definition describes a coin.
+type Definition struct {
+	// Code identifies the coin, e.g. btc.
+	Code string
+	// Unit is the unit in which amounts are displayed, e.g. BTC.
+	Unit    string
+	Type    Type
+	Network Network
+	// DevModeOnly coins are only available in dev mode.
+	DevModeOnly           bool
+	BlockExplorerTxPrefix string
+	// RatesUnit is the unit under which the exchange rates of the coin are listed, e.g. BTC. Empty
+	// if the coin has no exchange rates, like the testnet coins, which have no value.
+	RatesUnit string
+
+	// URIScheme is the BIP21 URI scheme of a TypeUTXO coin, e.g. bitcoin.
+	URIScheme string
+	// UTXOParams are the chain params of a TypeUTXO coin.
+	UTXOParams *chaincfg.Params
+	// Servers are the default Electrum servers of a TypeUTXO coin.
+	Servers []*rpc.ServerInfo
+	// DevServers, if any, are used instead of the configured servers in dev mode.
+	DevServers []*rpc.ServerInfo
+
+	// ETHParams are the chain params of a TypeETH coin.
+	ETHParams *params.ChainConfig
+	// ETHNodeURL is the URL of the node of a TypeETH coin.
+	ETHNodeURL string
+	// ETHNodeHTTPURL is the HTTP(S) URL of the node of a TypeETH coin, used when connecting through
+	// a proxy, which does not support websockets. It can be left empty if ETHNodeURL is an HTTP(S)
+	// URL.
+	ETHNodeHTTPURL string
+	// BIP44CoinType is the coin type used in the keypaths of a TypeETH coin, e.g. 60.
+	BIP44CoinType uint32
+
+	// Accounts are added in this order.
+	Accounts []AccountTemplate
+}
+
+var (
+	lock        locker.Locker
+	definitions []*Definition
+)
+
+// Register adds a coin definition. Returns an error if a coin with the same code or an account
+// with the same code is already registered.
+func Register(definition *Definition) error {
+	defer lock.Lock()()
+	for _, registered := range definitions {
+		if registered.Code == definition.Code {
+			return errp.Newf("The coin %s is already registered.", definition.Code)
+		}
+		for _, registeredAccount := range registered.Accounts {
+			for _, account := range definition.Accounts {
+				if registeredAccount.Code == account.Code {
+					return errp.Newf("The account %s is already registered.", account.Code)
+				}
+			}
+		}
+	}
+	switch definition.Type {
+	case TypeUTXO:
+		if definition.UTXOParams == nil {
+			return errp.Newf("The coin %s has no chain params.", definition.Code)
+		}
+	case TypeETH:
+		if definition.ETHParams == nil {
+			return errp.Newf("The coin %s has no chain params.", definition.Code)
+		}
+		if definition.ETHNodeHTTPURL == "" && !isHTTPURL(definition.ETHNodeURL) {
+			return errp.Newf("The coin %s has no HTTP node URL.", definition.Code)
+		}
+	default:
+		return errp.Newf("The coin %s has an unknown type %s.", definition.Code, definition.Type)
+	}
+	definitions = append(definitions, definition)
+	return nil
+}
+
+// isHTTPURL returns whether the URL has the http or https scheme.
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// MustRegister is like Register, but panics on error.
+func MustRegister(definition *Definition) {
+	if err := Register(definition); err != nil {
+		panic(err)
+	}
+}
+
+// Get returns the definition of the coin with the given code.
+func Get(code string) (*Definition, bool) {
+	defer lock.RLock()()
+	for _, definition := range definitions {
+		if definition.Code == code {
+			return definition, true
+		}
+	}
+	return nil, false
+}
+
+// Definitions returns all coin definitions in the order in which they were registered.
+func Definitions() []*Definition {
+	defer lock.RLock()()
+	return append([]*Definition{}, definitions...)
+}
+
+// Account returns the coin and the template of the account with the given code. Ethereum account
+// codes match the template code followed by a dash and the account index.
+func Account(code string) (*Definition, *AccountTemplate, bool) {
+	defer lock.RLock()()
+	for _, definition := range definitions {
+		for index := range definition.Accounts {
+			template := &definition.Accounts[index]
+			if template.Code == code ||
+				(definition.Type == TypeETH && strings.HasPrefix(code, template.Code+"-")) {
+				return definition, template, true
+			}
+		}
+	}
+	return nil, nil, false
+}
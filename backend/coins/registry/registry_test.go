@@ -0,0 +1,83 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/registry"
+	registrytest "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/registry/test"
+)
+
+func TestBuiltinCoins(t *testing.T) {
+	for _, code := range []string{"rbtc", "tbtc", "tltc", "teth", "btc", "ltc", "eth"} {
+		definition, ok := registry.Get(code)
+		require.True(t, ok, code)
+		require.Equal(t, code, definition.Code)
+	}
+	definition, template, ok := registry.Account("btc-p2wpkh")
+	require.True(t, ok)
+	require.Equal(t, "btc", definition.Code)
+	require.Equal(t, "m/84'/0'/0'", template.Keypath)
+
+	// Ethereum accounts are coded by account index.
+	definition, template, ok = registry.Account("teth-3")
+	require.True(t, ok)
+	require.Equal(t, "teth", definition.Code)
+	require.Equal(t, "teth", template.Code)
+	definition, _, ok = registry.Account("eth")
+	require.True(t, ok)
+	require.Equal(t, "eth", definition.Code)
+
+	_, _, ok = registry.Account("unknown")
+	require.False(t, ok)
+	_, _, ok = registry.Account("btc-p2wpkh-1")
+	require.False(t, ok)
+}
+
+func TestRegister(t *testing.T) {
+	require.NoError(t, registry.Register(registrytest.FakeDefinition("fake", "fake-p2wpkh")))
+	definition, ok := registry.Get("fake")
+	require.True(t, ok)
+	require.Equal(t, "fakenet", definition.UTXOParams.Name)
+	definitions := registry.Definitions()
+	require.Equal(t, definition, definitions[len(definitions)-1])
+	definition, template, ok := registry.Account("fake-p2wpkh")
+	require.True(t, ok)
+	require.Equal(t, "fake", definition.Code)
+	require.Equal(t, "fake-p2wpkh", template.Code)
+
+	require.Error(t, registry.Register(registrytest.FakeDefinition("fake")))
+	require.Error(t, registry.Register(registrytest.FakeDefinition("fake2", "btc-p2wpkh")))
+	missingParams := registrytest.FakeDefinition("fake3")
+	missingParams.UTXOParams = nil
+	require.Error(t, registry.Register(missingParams))
+	_, ok = registry.Get("fake3")
+	require.False(t, ok)
+
+	// Ethereum nodes must be reachable over HTTP(S) for connections through the proxy.
+	websocketOnly := &registry.Definition{
+		Code:       "fake4",
+		Type:       registry.TypeETH,
+		ETHParams:  params.TestnetChainConfig,
+		ETHNodeURL: "wss://eth-node.example/ws",
+	}
+	require.Error(t, registry.Register(websocketOnly))
+	websocketOnly.ETHNodeHTTPURL = "https://eth-node.example"
+	require.NoError(t, registry.Register(websocketOnly))
+}
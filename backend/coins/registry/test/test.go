@@ -0,0 +1,46 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/registry"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// FakeDefinition returns the definition of a regtest coin for convenience in testing, with one
+// P2WPKH account per account code. The accounts are active by default.
+func FakeDefinition(code string, accountCodes ...string) *registry.Definition {
+	fakeNetParams := chaincfg.RegressionNetParams
+	fakeNetParams.Name = "fakenet"
+	accounts := []registry.AccountTemplate{}
+	for _, accountCode := range accountCodes {
+		accounts = append(accounts, registry.AccountTemplate{
+			Code: accountCode, Name: "Fake", Keypath: "m/84'/1'/0'",
+			ScriptType: signing.ScriptTypeP2WPKH, ActiveByDefault: true,
+		})
+	}
+	return &registry.Definition{
+		Code:       code,
+		Unit:       "FAKE",
+		Type:       registry.TypeUTXO,
+		Network:    registry.NetworkRegtest,
+		UTXOParams: &fakeNetParams,
+		Servers:    []*rpc.ServerInfo{{Server: "127.0.0.1:1", TLS: false}},
+		Accounts:   accounts,
+	}
+}
@@ -16,21 +16,73 @@ package config
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
+	"reflect"
+	"strings"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+)
+
+const (
+	// BlockchainElectrum connects a Bitcoin-like coin to its Electrum servers.
+	BlockchainElectrum = "electrum"
+	// BlockchainBitcoind connects a Bitcoin-like coin to a Bitcoin Core node.
+	BlockchainBitcoind = "bitcoind"
+	// BlockchainEsplora connects a Bitcoin-like coin to an Esplora REST server.
+	BlockchainEsplora = "esplora"
+	// BlockchainNeutrino connects a Bitcoin coin to full nodes serving compact block filters.
+	BlockchainNeutrino = "neutrino"
 )
 
 // CoinConfig holds configurations specific to a coin.
 type CoinConfig struct {
+	// Blockchain selects the backend of a Bitcoin-like coin, BlockchainElectrum (default),
+	// BlockchainBitcoind, BlockchainEsplora or BlockchainNeutrino.
+	Blockchain      string            `json:"blockchain,omitempty"`
 	ElectrumServers []*rpc.ServerInfo `json:"electrumServers"`
+	// Bitcoind configures the node used with BlockchainBitcoind. It is decoded by the backend into
+	// the config of the bitcoind package.
+	Bitcoind json.RawMessage `json:"bitcoind,omitempty"`
+	// Esplora configures the server used with BlockchainEsplora. It is decoded by the backend into
+	// the config of the esplora package.
+	Esplora json.RawMessage `json:"esplora,omitempty"`
+	// Neutrino configures the peers used with BlockchainNeutrino. It is decoded by the backend into
+	// the config of the neutrino package.
+	Neutrino json.RawMessage `json:"neutrino,omitempty"`
+	// SignetChallenge is the hex encoded challenge script of a custom signet. Only used by signet
+	// coins, which use the default signet if it is empty.
+	SignetChallenge string `json:"signetChallenge,omitempty"`
 }
 
-// Backend holds the backend specific configuration.
+// ETHConfig holds the configuration of the Ethereum accounts of a network.
+type ETHConfig struct {
+	// DerivationScheme is "addressIndex" (m/44'/60'/0'/0/<index>) or "accountIndex"
+	// (m/44'/60'/<index>'/0/0).
+	DerivationScheme string `json:"derivationScheme"`
+	// AccountsCount is the number of accounts. It is increased by account discovery and when
+	// adding an account.
+	AccountsCount int `json:"accountsCount"`
+}
+
+func defaultETHConfig() ETHConfig {
+	return ETHConfig{
+		DerivationScheme: "addressIndex",
+		AccountsCount:    1,
+	}
+}
+
+// Backend holds the backend specific configuration. The coins which predate the generic settings
+// keep their dedicated settings, whose JSON keys are the coin codes and the ActiveSetting keys of
+// the account templates, so that existing config files and the frontend keep working. All lookups
+// go through these keys, so that a new coin needs no code here.
 type Backend struct {
+	// Proxy configures the SOCKS5 proxy, e.g. Tor, used by all outbound connections. Changes take
+	// effect after a restart.
+	Proxy socksproxy.Config `json:"proxy"`
+
 	BitcoinP2PKHActive       bool `json:"bitcoinP2PKHActive"`
 	BitcoinP2WPKHP2SHActive  bool `json:"bitcoinP2WPKHP2SHActive"`
 	BitcoinP2WPKHActive      bool `json:"bitcoinP2WPKHActive"`
@@ -38,30 +90,107 @@ type Backend struct {
 	LitecoinP2WPKHActive     bool `json:"litecoinP2WPKHActive"`
 	EthereumActive           bool `json:"ethereumActive"`
 
+	// AccountsActive enables accounts which have no dedicated setting above, by account code.
+	AccountsActive map[string]bool `json:"accountsActive,omitempty"`
+
 	BTC  CoinConfig `json:"btc"`
 	TBTC CoinConfig `json:"tbtc"`
 	LTC  CoinConfig `json:"ltc"`
 	TLTC CoinConfig `json:"tltc"`
+	// Coins holds the configuration of the coins which have no dedicated setting above, by coin
+	// code.
+	Coins map[string]CoinConfig `json:"coins,omitempty"`
+
+	ETH  ETHConfig `json:"eth"`
+	TETH ETHConfig `json:"teth"`
+	// ETHCoins holds the configuration of the Ethereum coins which have no dedicated setting above,
+	// by coin code.
+	ETHCoins map[string]ETHConfig `json:"ethCoins,omitempty"`
+
+	// ConfirmationNotifications are the numbers of confirmations at which a notification about a
+	// transaction is sent. Changes take effect after the accounts are reinitialized.
+	ConfirmationNotifications []int `json:"confirmationNotifications"`
+}
+
+// field returns the dedicated setting with the given JSON key and type.
+func (backend *Backend) field(key string, fieldType reflect.Type) (reflect.Value, bool) {
+	value := reflect.ValueOf(backend).Elem()
+	for index := 0; index < value.NumField(); index++ {
+		field := value.Type().Field(index)
+		if field.Type == fieldType && strings.Split(field.Tag.Get("json"), ",")[0] == key {
+			return value.Field(index), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// AccountActive returns whether the account with the given code is enabled. activeSetting is the
+// JSON key of the dedicated setting enabling the account, e.g. bitcoinP2PKHActive. If it is empty,
+// the account is enabled through AccountsActive, which defaults to activeByDefault.
+func (backend Backend) AccountActive(code string, activeSetting string, activeByDefault bool) bool {
+	if activeSetting != "" {
+		if field, ok := backend.field(activeSetting, reflect.TypeOf(true)); ok {
+			return field.Bool()
+		}
+	}
+	if active, ok := backend.AccountsActive[code]; ok {
+		return active
+	}
+	return activeByDefault
 }
 
-// AccountActive returns the Active setting for a coin by code.
-func (backend Backend) AccountActive(code string) bool {
-	switch code {
-	case "tbtc-p2pkh", "btc-p2pkh", "rbtc-p2pkh":
-		return backend.BitcoinP2PKHActive
-	case "tbtc-p2wpkh-p2sh", "btc-p2wpkh-p2sh", "rbtc-p2wpkh-p2sh":
-		return backend.BitcoinP2WPKHP2SHActive
-	case "tbtc-p2wpkh", "btc-p2wpkh", "rbtc-p2wpkh":
-		return backend.BitcoinP2WPKHActive
-	case "tltc-p2wpkh-p2sh", "ltc-p2wpkh-p2sh":
-		return backend.LitecoinP2WPKHP2SHActive
-	case "tltc-p2wpkh", "ltc-p2wpkh":
-		return backend.LitecoinP2WPKHActive
-	case "eth", "teth":
-		return backend.EthereumActive
-	default:
-		panic(fmt.Sprintf("unknown code %s", code))
+// CoinConfig returns the configuration of a coin by code. Returns false if the coin has neither a
+// dedicated setting nor an entry in Coins.
+func (backend Backend) CoinConfig(code string) (CoinConfig, bool) {
+	if field, ok := backend.field(code, reflect.TypeOf(CoinConfig{})); ok {
+		return field.Interface().(CoinConfig), true
 	}
+	coinConfig, ok := backend.Coins[code]
+	return coinConfig, ok
+}
+
+// SetCoinConfig sets the configuration of a coin by code.
+func (backend *Backend) SetCoinConfig(code string, coinConfig CoinConfig) {
+	if field, ok := backend.field(code, reflect.TypeOf(CoinConfig{})); ok {
+		field.Set(reflect.ValueOf(coinConfig))
+		return
+	}
+	// The map is copied, as it is shared with the copies of the config handed out by Config().
+	coins := map[string]CoinConfig{code: coinConfig}
+	for otherCode, otherCoinConfig := range backend.Coins {
+		if otherCode != code {
+			coins[otherCode] = otherCoinConfig
+		}
+	}
+	backend.Coins = coins
+}
+
+// ETHConfig returns the configuration of the Ethereum accounts of a coin by code. Coins which are
+// not configured yet have a single account.
+func (backend Backend) ETHConfig(code string) ETHConfig {
+	if field, ok := backend.field(code, reflect.TypeOf(ETHConfig{})); ok {
+		return field.Interface().(ETHConfig)
+	}
+	if ethConfig, ok := backend.ETHCoins[code]; ok {
+		return ethConfig
+	}
+	return defaultETHConfig()
+}
+
+// SetETHConfig sets the configuration of the Ethereum accounts of a coin by code.
+func (backend *Backend) SetETHConfig(code string, ethConfig ETHConfig) {
+	if field, ok := backend.field(code, reflect.TypeOf(ETHConfig{})); ok {
+		field.Set(reflect.ValueOf(ethConfig))
+		return
+	}
+	// The map is copied, as it is shared with the copies of the config handed out by Config().
+	ethCoins := map[string]ETHConfig{code: ethConfig}
+	for otherCode, otherETHConfig := range backend.ETHCoins {
+		if otherCode != code {
+			ethCoins[otherCode] = otherETHConfig
+		}
+	}
+	backend.ETHCoins = ethCoins
 }
 
 // AppConfig holds the whole app configuration.
@@ -70,45 +199,8 @@ type AppConfig struct {
 	Frontend interface{} `json:"frontend"`
 }
 
-const shiftRootCA = `
------BEGIN CERTIFICATE-----
-MIIGGjCCBAKgAwIBAgIJAKRWPF0NRtHyMA0GCSqGSIb3DQEBDQUAMIGZMQswCQYD
-VQQGEwJDSDEPMA0GA1UECAwGWnVyaWNoMR0wGwYDVQQKDBRTaGlmdCBDcnlwdG9z
-ZWN1cml0eTEzMDEGA1UECwwqU2hpZnQgQ3J5cHRvc2VjdXJpdHkgQ2VydGlmaWNh
-dGUgQXV0aG9yaXR5MSUwIwYDVQQDDBxTaGlmdCBDcnlwdG9zZWN1cml0eSBSb290
-IENBMB4XDTE4MDYwODE0NTA0MloXDTM4MDYwMzE0NTA0MlowgZkxCzAJBgNVBAYT
-AkNIMQ8wDQYDVQQIDAZadXJpY2gxHTAbBgNVBAoMFFNoaWZ0IENyeXB0b3NlY3Vy
-aXR5MTMwMQYDVQQLDCpTaGlmdCBDcnlwdG9zZWN1cml0eSBDZXJ0aWZpY2F0ZSBB
-dXRob3JpdHkxJTAjBgNVBAMMHFNoaWZ0IENyeXB0b3NlY3VyaXR5IFJvb3QgQ0Ew
-ggIiMA0GCSqGSIb3DQEBAQUAA4ICDwAwggIKAoICAQC0H598q5C+yDJI9F8QYkYK
-6/48kFNQ0rbAKcKkgR0+H8CGuFVOGQdcv7tObCMe0Dyr8ioNkq7AP+Nt1e1TVgKQ
-ANmJqz2rKvA4sIIgdBjUs0DXPuCaDzGGbJHIXnGMuGANX6xnqvdOj7kIA6r6s7Hh
-eWQEB8tGiRdHWJitpkc1xEfW1DhnMQPnSihSJM5qltXVPKxzqqElv0iGI/La3S8W
-nJV7kTGTsLouX1CcwLjp6avlVy56utOYRXkgfuY88XxmOjlAECeoYCWFBGaSWK+h
-2sBLbRC9G0YWmNCqB+GjMj8myj06crLn7mZgBODEyUrFYMjAPrpmAScmw38y2rwN
-AK6ii75P+sHc3BPi05Vap2GoTAY0db62NiN3dsNxHB5DbehA4Zfaqzcakjv4CSRo
-zkg2JSlofOZWd3aomxIKfFLl+aVFjukXEKaz8P+2xe5/2/M35kKIIJCuHz1Ybor1
-Ze9YmLAnLnbTCA7VcKkUs25lskL/zRC4sdLzgJ2V2UdHWPAo/ttwBXtw6piw4v4N
-DfCuKDMiomxwNiGvb3GZWMhOHT30NLZ0nuRAGjeg7jFBqSh2SPeDu+hnImAAh2WX
-7ul3/kschLF+3otC/x7jmAMWXzb1oVWRqj2Gyjner1p82gWxj5k7Hs/2mG3TV6sv
-pyVqMqonbirxuO+kbzYLxQIDAQABo2MwYTAdBgNVHQ4EFgQU301oVCni/CbDXJ9V
-Fez6Vgu0arUwHwYDVR0jBBgwFoAU301oVCni/CbDXJ9VFez6Vgu0arUwDwYDVR0T
-AQH/BAUwAwEB/zAOBgNVHQ8BAf8EBAMCAYYwDQYJKoZIhvcNAQENBQADggIBAD1d
-/KJ3w1Je3oOx0afcXOf2IOoMvKSFbBg9u+rpXBh60cacjPtwbIMIyF3ynYYGzx7D
-x8mr6wagJ+uqKn9E7JGp2h0lKhT9cgxzqIk3r4D/jhvh1zijInCEbPPphwbemzIG
-JxxmpDOeURHVxCcSpIJlGfRURdfdXwleWiz9zCkNUvmgTDrfBjEk6ywSSKD4uJuT
-jBcav1P4OkeFokAPO1Uc9NCXox5NUAsDosdZUbxbH8vf61Xbr6fnxmy731s9D7cc
-djXPb3pbXtRL4A0hNnOWcuPM30hn4ZkIm08TGT+IMOFYBk+pe2IXSFzUcDYEL/ws
-wuHqctRlw/t4extJFYvzASOkBr4zFceR9jCSWR8kOkWY81evx/bxG+eQBJMkzrdw
-LOChedVDVIuoTZxfqNzU4Y2TgMGMRWsrEVvBvTMIY3qBue1yeT9M4jzAPhms3/It
-Ps7ZeqmF+HrRtFz5ctHQa0QOdZodsKJO0WwjjzjYTDMzZO+bnVFFUy9cG+Gr6mt1
-XMKJKkvXQuYTfbRrox4HzIjyfi54xYHnUI35uUUUzEO19Qtm4Ds+sz7/vyz3cYFI
-d8IgKoqstjsxtaRq1IS6WIj0bQ/nEqoTNg0I3bndrmCq5LbCoq0z2yXYr5Vl5Gvf
-ffbrVM+I91v3R03Svv2Nte2xdbx1RmoI/y3tMyZL
------END CERTIFICATE-----
-`
-
-// NewDefaultConfig returns the default app config.
+// NewDefaultConfig returns the default app config. The coins have no servers, which are added by
+// the backend from the coin definitions.
 func NewDefaultConfig() AppConfig {
 	return AppConfig{
 		Backend: Backend{
@@ -118,62 +210,11 @@ func NewDefaultConfig() AppConfig {
 			LitecoinP2WPKHP2SHActive: true,
 			LitecoinP2WPKHActive:     false,
 			EthereumActive:           true,
-			BTC: CoinConfig{
-				ElectrumServers: []*rpc.ServerInfo{
-					{
-						Server:  "btc.shiftcrypto.ch:443",
-						TLS:     true,
-						PEMCert: shiftRootCA,
-					},
-					{
-						Server:  "merkle.shiftcrypto.ch:443",
-						TLS:     true,
-						PEMCert: shiftRootCA,
-					},
-				},
-			},
-			TBTC: CoinConfig{
-				ElectrumServers: []*rpc.ServerInfo{
-					{
-						Server:  "btc.shiftcrypto.ch:51002",
-						TLS:     true,
-						PEMCert: shiftRootCA,
-					},
-					{
-						Server:  "merkle.shiftcrypto.ch:51002",
-						TLS:     true,
-						PEMCert: shiftRootCA,
-					},
-				},
-			},
-			LTC: CoinConfig{
-				ElectrumServers: []*rpc.ServerInfo{
-					{
-						Server:  "ltc.shiftcrypto.ch:443",
-						TLS:     true,
-						PEMCert: shiftRootCA,
-					},
-					{
-						Server:  "ltc.shamir.shiftcrypto.ch:443",
-						TLS:     true,
-						PEMCert: shiftRootCA,
-					},
-				},
-			},
-			TLTC: CoinConfig{
-				ElectrumServers: []*rpc.ServerInfo{
-					{
-						Server:  "ltc.shiftcrypto.ch:51004",
-						TLS:     true,
-						PEMCert: shiftRootCA,
-					},
-					{
-						Server:  "ltc.shamir.shiftcrypto.ch:51004",
-						TLS:     true,
-						PEMCert: shiftRootCA,
-					},
-				},
-			},
+
+			ETH:  defaultETHConfig(),
+			TETH: defaultETHConfig(),
+
+			ConfirmationNotifications: []int{1, 6},
 		},
 	}
 }
@@ -186,11 +227,11 @@ type Config struct {
 }
 
 // NewConfig creates a new Config, stored in the given location. The filename must be writable, but
-// does not have to exist.
-func NewConfig(filename string) *Config {
+// does not have to exist. The settings missing in the file are taken from defaultConfig.
+func NewConfig(filename string, defaultConfig AppConfig) *Config {
 	config := &Config{
 		filename: filename,
-		config:   NewDefaultConfig(),
+		config:   defaultConfig,
 	}
 	config.load()
 	return config
@@ -0,0 +1,75 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+func TestAccountActive(t *testing.T) {
+	backendConfig := config.NewDefaultConfig().Backend
+	require.True(t, backendConfig.AccountActive("btc-p2wpkh-p2sh", "bitcoinP2WPKHP2SHActive", false))
+	require.False(t, backendConfig.AccountActive("btc-p2wpkh", "bitcoinP2WPKHActive", true))
+	require.True(t, backendConfig.AccountActive("eth", "ethereumActive", false))
+
+	require.True(t, backendConfig.AccountActive("fake-p2wpkh", "", true))
+	require.False(t, backendConfig.AccountActive("fake-p2pkh", "", false))
+	// Unknown settings fall back to the generic setting.
+	require.False(t, backendConfig.AccountActive("fake-p2pkh", "unknownActive", false))
+
+	backendConfig.AccountsActive = map[string]bool{"fake-p2wpkh": false}
+	backendConfig.BitcoinP2PKHActive = true
+	require.False(t, backendConfig.AccountActive("fake-p2wpkh", "", true))
+	require.True(t, backendConfig.AccountActive("fake-p2pkh", "bitcoinP2PKHActive", false))
+}
+
+func TestCoinConfig(t *testing.T) {
+	backendConfig := config.NewDefaultConfig().Backend
+	servers := []*rpc.ServerInfo{{Server: "fake.example.com:50002", TLS: true}}
+
+	_, ok := backendConfig.CoinConfig("fake")
+	require.False(t, ok)
+	backendConfig.SetCoinConfig("fake", config.CoinConfig{ElectrumServers: servers})
+	coinConfig, ok := backendConfig.CoinConfig("fake")
+	require.True(t, ok)
+	require.Equal(t, servers, coinConfig.ElectrumServers)
+	require.Equal(t, servers, backendConfig.Coins["fake"].ElectrumServers)
+
+	// Coins with a dedicated setting use it.
+	_, ok = backendConfig.CoinConfig("btc")
+	require.True(t, ok)
+	backendConfig.SetCoinConfig("btc", config.CoinConfig{ElectrumServers: servers})
+	require.Equal(t, servers, backendConfig.BTC.ElectrumServers)
+	coinConfig, _ = backendConfig.CoinConfig("btc")
+	require.Equal(t, servers, coinConfig.ElectrumServers)
+	require.NotContains(t, backendConfig.Coins, "btc")
+}
+
+func TestETHConfig(t *testing.T) {
+	backendConfig := config.NewDefaultConfig().Backend
+	require.Equal(t, 1, backendConfig.ETHConfig("eth").AccountsCount)
+	require.Equal(t, 1, backendConfig.ETHConfig("fake-eth").AccountsCount)
+
+	backendConfig.SetETHConfig("teth", config.ETHConfig{DerivationScheme: "accountIndex", AccountsCount: 2})
+	require.Equal(t, 2, backendConfig.TETH.AccountsCount)
+	backendConfig.SetETHConfig("fake-eth", config.ETHConfig{DerivationScheme: "accountIndex", AccountsCount: 3})
+	require.Equal(t, 3, backendConfig.ETHConfig("fake-eth").AccountsCount)
+	require.Equal(t, "accountIndex", backendConfig.ETHCoins["fake-eth"].DerivationScheme)
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/addressbook"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/registry"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// validateAddress checks the address against the network params of the coin with the given code.
+// Ethereum addresses are checked against their EIP-55 checksum.
+func (backend *Backend) validateAddress(coinCode string, address string) error {
+	definition, ok := registry.Get(coinCode)
+	if !ok {
+		return errp.Newf("unknown coin code %s", coinCode)
+	}
+	switch definition.Type {
+	case registry.TypeUTXO:
+		net, err := backend.utxoParams(definition)
+		if err != nil {
+			return err
+		}
+		_, err = btc.DecodeAddress(address, net)
+		return err
+	case registry.TypeETH:
+		return eth.ValidateAddress(address)
+	default:
+		return errp.Newf("unknown coin type %s", definition.Type)
+	}
+}
+
+// AddressBook returns the address book. An error is returned if the stored address book could not
+// be loaded.
+func (backend *Backend) AddressBook() (*addressbook.AddressBook, error) {
+	if backend.addressBookErr != nil {
+		return nil, backend.addressBookErr
+	}
+	return backend.addressBook, nil
+}
+
+// ContactRecipient returns the address to send to when paying the contact with the given id with
+// the given coin. The second return value is true if the contact asked for address rotation, but
+// all their addresses were used already. used reports whether coins were sent to an address before.
+func (backend *Backend) ContactRecipient(
+	id string, coinCode string, used func(address string) bool) (string, bool, error) {
+	addressBook, err := backend.AddressBook()
+	if err != nil {
+		return "", false, err
+	}
+	return addressBook.Recipient(id, coinCode, used)
+}
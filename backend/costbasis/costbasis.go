@@ -0,0 +1,266 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package costbasis computes the capital gains of disposals of coins, matching them to the
+// acquisitions of the coins with the FIFO, LIFO or average cost method.
+package costbasis
+
+import (
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Method selects the acquisitions a disposal is matched with.
+type Method string
+
+const (
+	// MethodFIFO matches the oldest acquisitions first.
+	MethodFIFO Method = "fifo"
+	// MethodLIFO matches the newest acquisitions first.
+	MethodLIFO Method = "lifo"
+	// MethodAverage values a disposal at the average cost of all coins held.
+	MethodAverage Method = "average"
+)
+
+// NewMethod parses a method.
+func NewMethod(method string) (Method, error) {
+	switch Method(method) {
+	case MethodFIFO, MethodLIFO, MethodAverage:
+		return Method(method), nil
+	default:
+		return "", errp.Newf("unknown cost basis method %q", method)
+	}
+}
+
+// EventType is the type of an Event.
+type EventType string
+
+const (
+	// EventAcquisition adds coins to the holdings.
+	EventAcquisition EventType = "acquisition"
+	// EventDisposal removes coins from the holdings, e.g. a payment.
+	EventDisposal EventType = "disposal"
+	// EventFee removes the coins paid as a transaction fee from the holdings.
+	EventFee EventType = "fee"
+)
+
+// Event is an acquisition or a disposal of a coin.
+type Event struct {
+	Time time.Time
+	TxID string
+	Type EventType
+	// Unit identifies the coin. The events of different coins are matched separately.
+	Unit string
+	// Amount is in the unit of the coin.
+	Amount *big.Rat
+	// Value is the fiat value of the amount at the time of the event, which is the cost of an
+	// acquisition and the proceeds of a disposal.
+	Value *big.Rat
+}
+
+// Lot is the part of a disposal which is matched with one acquisition.
+type Lot struct {
+	Unit         string
+	Type         EventType
+	DisposalTime time.Time
+	DisposalTxID string
+	// AcquisitionTime and AcquisitionTxID are the matched acquisition. They are empty with
+	// MethodAverage, and if no acquisition is left to match, in which case the cost basis is zero.
+	AcquisitionTime *time.Time
+	AcquisitionTxID string
+	Amount          *big.Rat
+	Proceeds        *big.Rat
+	CostBasis       *big.Rat
+}
+
+// Gain is the proceeds minus the cost basis.
+func (lot *Lot) Gain() *big.Rat {
+	return new(big.Rat).Sub(lot.Proceeds, lot.CostBasis)
+}
+
+// YearSummary sums up the lots disposed of in a year (UTC).
+type YearSummary struct {
+	Year      int
+	Proceeds  *big.Rat
+	CostBasis *big.Rat
+	Gain      *big.Rat
+}
+
+// Report is the result of Compute.
+type Report struct {
+	Method Method
+	// Lots are in the order of the disposals.
+	Lots []*Lot
+	// Years are in ascending order.
+	Years []*YearSummary
+	// Unmatched is true if coins were disposed of for which no acquisition was left, e.g. because
+	// the history is incomplete.
+	Unmatched bool
+}
+
+// eventTypeOrder orders the events of the same time, so that the coins acquired are available to be
+// disposed of.
+var eventTypeOrder = map[EventType]int{
+	EventAcquisition: 0,
+	EventDisposal:    1,
+	EventFee:         2,
+}
+
+// sortEvents orders the events by time. Events of the same time are ordered by type and txid, so
+// that the result does not depend on the order of the input.
+func sortEvents(events []*Event) []*Event {
+	sorted := append([]*Event{}, events...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		if a.Type != b.Type {
+			return eventTypeOrder[a.Type] < eventTypeOrder[b.Type]
+		}
+		if a.TxID != b.TxID {
+			return a.TxID < b.TxID
+		}
+		return a.Unit < b.Unit
+	})
+	return sorted
+}
+
+// share returns value * part / whole.
+func share(value *big.Rat, part *big.Rat, whole *big.Rat) *big.Rat {
+	if whole.Sign() == 0 {
+		return new(big.Rat)
+	}
+	result := new(big.Rat).Mul(value, part)
+	return result.Quo(result, whole)
+}
+
+func minRat(a *big.Rat, b *big.Rat) *big.Rat {
+	if a.Cmp(b) < 0 {
+		return a
+	}
+	return b
+}
+
+// holding is an acquisition, or with MethodAverage all coins held, of which Amount is left.
+type holding struct {
+	acquisition *Event
+	amount      *big.Rat
+	cost        *big.Rat
+}
+
+// holdings are the coins held of one unit.
+type holdings struct {
+	method Method
+	// lots are ordered by the time of acquisition. With MethodAverage, there is at most one.
+	lots []*holding
+}
+
+func (holdings *holdings) acquire(event *Event) {
+	if holdings.method == MethodAverage && len(holdings.lots) == 1 {
+		pool := holdings.lots[0]
+		pool.amount = new(big.Rat).Add(pool.amount, event.Amount)
+		pool.cost = new(big.Rat).Add(pool.cost, event.Value)
+		return
+	}
+	holdings.lots = append(holdings.lots, &holding{
+		acquisition: event,
+		amount:      new(big.Rat).Set(event.Amount),
+		cost:        new(big.Rat).Set(event.Value),
+	})
+}
+
+// dispose matches the disposal with the holdings, and returns the lots. unmatched is true if not
+// enough coins were held.
+func (holdings *holdings) dispose(event *Event) (lots []*Lot, unmatched bool) {
+	newLot := func(amount *big.Rat, costBasis *big.Rat) *Lot {
+		return &Lot{
+			Unit:         event.Unit,
+			Type:         event.Type,
+			DisposalTime: event.Time,
+			DisposalTxID: event.TxID,
+			Amount:       amount,
+			Proceeds:     share(event.Value, amount, event.Amount),
+			CostBasis:    costBasis,
+		}
+	}
+	remaining := new(big.Rat).Set(event.Amount)
+	for remaining.Sign() > 0 && len(holdings.lots) > 0 {
+		index := 0
+		if holdings.method == MethodLIFO {
+			index = len(holdings.lots) - 1
+		}
+		held := holdings.lots[index]
+		amount := new(big.Rat).Set(minRat(remaining, held.amount))
+		costBasis := share(held.cost, amount, held.amount)
+		lot := newLot(amount, costBasis)
+		if holdings.method != MethodAverage {
+			acquisitionTime := held.acquisition.Time
+			lot.AcquisitionTime = &acquisitionTime
+			lot.AcquisitionTxID = held.acquisition.TxID
+		}
+		lots = append(lots, lot)
+		held.amount = new(big.Rat).Sub(held.amount, amount)
+		held.cost = new(big.Rat).Sub(held.cost, costBasis)
+		remaining.Sub(remaining, amount)
+		if held.amount.Sign() == 0 {
+			holdings.lots = append(holdings.lots[:index], holdings.lots[index+1:]...)
+		}
+	}
+	if remaining.Sign() > 0 {
+		lots = append(lots, newLot(remaining, new(big.Rat)))
+		unmatched = true
+	}
+	return lots, unmatched
+}
+
+// Compute matches the disposals with the acquisitions using the given method.
+func Compute(events []*Event, method Method) *Report {
+	report := &Report{Method: method, Lots: []*Lot{}, Years: []*YearSummary{}}
+	holdingsByUnit := map[string]*holdings{}
+	years := map[int]*YearSummary{}
+	for _, event := range sortEvents(events) {
+		unitHoldings, ok := holdingsByUnit[event.Unit]
+		if !ok {
+			unitHoldings = &holdings{method: method}
+			holdingsByUnit[event.Unit] = unitHoldings
+		}
+		if event.Type == EventAcquisition {
+			unitHoldings.acquire(event)
+			continue
+		}
+		lots, unmatched := unitHoldings.dispose(event)
+		if unmatched {
+			report.Unmatched = true
+		}
+		for _, lot := range lots {
+			report.Lots = append(report.Lots, lot)
+			year := lot.DisposalTime.UTC().Year()
+			summary, ok := years[year]
+			if !ok {
+				summary = &YearSummary{
+					Year: year, Proceeds: new(big.Rat), CostBasis: new(big.Rat), Gain: new(big.Rat)}
+				years[year] = summary
+				report.Years = append(report.Years, summary)
+			}
+			summary.Proceeds.Add(summary.Proceeds, lot.Proceeds)
+			summary.CostBasis.Add(summary.CostBasis, lot.CostBasis)
+			summary.Gain.Add(summary.Gain, lot.Gain())
+		}
+	}
+	return report
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package costbasis_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/costbasis"
+	"github.com/stretchr/testify/require"
+)
+
+func rat(s string) *big.Rat {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		panic(s)
+	}
+	return r
+}
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// workedExample buys 1 BTC for 1000 and 1 BTC for 3000, then pays 1.5 BTC at a price of 4000, with
+// a fee of 0.0001 BTC.
+func workedExample() []*costbasis.Event {
+	// In reverse order, the result must not depend on the order.
+	return []*costbasis.Event{
+		{Time: date("2021-03-01"), TxID: "d1", Type: costbasis.EventFee, Unit: "BTC",
+			Amount: rat("0.0001"), Value: rat("0.4")},
+		{Time: date("2021-03-01"), TxID: "d1", Type: costbasis.EventDisposal, Unit: "BTC",
+			Amount: rat("1.5"), Value: rat("6000")},
+		{Time: date("2020-06-01"), TxID: "a2", Type: costbasis.EventAcquisition, Unit: "BTC",
+			Amount: rat("1"), Value: rat("3000")},
+		{Time: date("2020-01-01"), TxID: "a1", Type: costbasis.EventAcquisition, Unit: "BTC",
+			Amount: rat("1"), Value: rat("1000")},
+	}
+}
+
+// lotRows formats the lots as disposal txid, acquisition txid, amount, proceeds, cost basis, gain.
+func lotRows(report *costbasis.Report) [][]string {
+	rows := [][]string{}
+	for _, lot := range report.Lots {
+		rows = append(rows, []string{
+			lot.DisposalTxID, lot.AcquisitionTxID, costbasis.FormatAmount(lot.Amount),
+			costbasis.FormatFiat(lot.Proceeds), costbasis.FormatFiat(lot.CostBasis),
+			costbasis.FormatFiat(lot.Gain()),
+		})
+	}
+	return rows
+}
+
+func requireYear(t *testing.T, report *costbasis.Report, year int, proceeds, costBasis, gain string) {
+	t.Helper()
+	for _, summary := range report.Years {
+		if summary.Year == year {
+			require.Equal(t, proceeds, costbasis.FormatFiat(summary.Proceeds))
+			require.Equal(t, costBasis, costbasis.FormatFiat(summary.CostBasis))
+			require.Equal(t, gain, costbasis.FormatFiat(summary.Gain))
+			return
+		}
+	}
+	require.Fail(t, "year not found")
+}
+
+func TestFIFO(t *testing.T) {
+	report := costbasis.Compute(workedExample(), costbasis.MethodFIFO)
+	require.Equal(t, [][]string{
+		{"d1", "a1", "1", "4000.00", "1000.00", "3000.00"},
+		{"d1", "a2", "0.5", "2000.00", "1500.00", "500.00"},
+		// The fee is paid from what is left of a2, 0.0001 * 3000.
+		{"d1", "a2", "0.0001", "0.40", "0.30", "0.10"},
+	}, lotRows(report))
+	require.Len(t, report.Years, 1)
+	requireYear(t, report, 2021, "6000.40", "2500.30", "3500.10")
+	require.False(t, report.Unmatched)
+}
+
+func TestLIFO(t *testing.T) {
+	report := costbasis.Compute(workedExample(), costbasis.MethodLIFO)
+	require.Equal(t, [][]string{
+		{"d1", "a2", "1", "4000.00", "3000.00", "1000.00"},
+		{"d1", "a1", "0.5", "2000.00", "500.00", "1500.00"},
+		// 0.0001 * 1000.
+		{"d1", "a1", "0.0001", "0.40", "0.10", "0.30"},
+	}, lotRows(report))
+	requireYear(t, report, 2021, "6000.40", "3500.10", "2500.30")
+}
+
+func TestAverage(t *testing.T) {
+	report := costbasis.Compute(workedExample(), costbasis.MethodAverage)
+	// The average cost is 2000 per BTC.
+	require.Equal(t, [][]string{
+		{"d1", "", "1.5", "6000.00", "3000.00", "3000.00"},
+		{"d1", "", "0.0001", "0.40", "0.20", "0.20"},
+	}, lotRows(report))
+	requireYear(t, report, 2021, "6000.40", "3000.20", "3000.20")
+	require.False(t, report.Unmatched)
+
+	// An acquisition after a disposal changes the average of what is left: 0.4999 BTC at 2000 plus
+	// 0.5001 BTC at 5000.
+	events := append(workedExample(),
+		&costbasis.Event{Time: date("2021-04-01"), TxID: "a3", Type: costbasis.EventAcquisition,
+			Unit: "BTC", Amount: rat("0.5001"), Value: rat("2500.5")},
+		&costbasis.Event{Time: date("2022-01-01"), TxID: "d2", Type: costbasis.EventDisposal,
+			Unit: "BTC", Amount: rat("1"), Value: rat("3000")},
+	)
+	report = costbasis.Compute(events, costbasis.MethodAverage)
+	require.Equal(t, []string{"d2", "", "1", "3000.00", "3500.30", "-500.30"}, lotRows(report)[2])
+	require.Len(t, report.Years, 2)
+	requireYear(t, report, 2022, "3000.00", "3500.30", "-500.30")
+}
+
+func TestUnmatched(t *testing.T) {
+	events := []*costbasis.Event{
+		{Time: date("2020-01-01"), TxID: "a1", Type: costbasis.EventAcquisition, Unit: "BTC",
+			Amount: rat("1"), Value: rat("1000")},
+		// Another coin is matched separately.
+		{Time: date("2020-01-02"), TxID: "a2", Type: costbasis.EventAcquisition, Unit: "LTC",
+			Amount: rat("10"), Value: rat("500")},
+		{Time: date("2020-02-01"), TxID: "d1", Type: costbasis.EventDisposal, Unit: "BTC",
+			Amount: rat("2"), Value: rat("4000")},
+	}
+	for _, method := range []costbasis.Method{
+		costbasis.MethodFIFO, costbasis.MethodLIFO, costbasis.MethodAverage} {
+		report := costbasis.Compute(events, method)
+		require.True(t, report.Unmatched)
+		rows := lotRows(report)
+		require.Len(t, rows, 2)
+		require.Equal(t, []string{"d1", "", "1", "2000.00", "0.00", "2000.00"}, rows[1])
+	}
+}
+
+func TestCSV(t *testing.T) {
+	report := costbasis.Compute(workedExample(), costbasis.MethodFIFO)
+	var lots bytes.Buffer
+	require.NoError(t, report.WriteLotsCSV(&lots))
+	require.Equal(t,
+		"Disposal Time,Disposal TxID,Type,Unit,Amount,Acquisition Time,Acquisition TxID,"+
+			"Proceeds,Cost Basis,Gain\n"+
+			"2021-03-01T00:00:00Z,d1,disposal,BTC,1,2020-01-01T00:00:00Z,a1,4000.00,1000.00,3000.00\n"+
+			"2021-03-01T00:00:00Z,d1,disposal,BTC,0.5,2020-06-01T00:00:00Z,a2,2000.00,1500.00,500.00\n"+
+			"2021-03-01T00:00:00Z,d1,fee,BTC,0.0001,2020-06-01T00:00:00Z,a2,0.40,0.30,0.10\n",
+		lots.String())
+	var years bytes.Buffer
+	require.NoError(t, report.WriteYearsCSV(&years))
+	require.Equal(t, "Year,Proceeds,Cost Basis,Gain\n2021,6000.40,2500.30,3500.10\n", years.String())
+}
+
+func TestNewMethod(t *testing.T) {
+	method, err := costbasis.NewMethod("lifo")
+	require.NoError(t, err)
+	require.Equal(t, costbasis.MethodLIFO, method)
+	_, err = costbasis.NewMethod("hifo")
+	require.Error(t, err)
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package costbasis
+
+import (
+	"encoding/csv"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// FormatAmount formats a coin amount with up to 18 decimals, without trailing zeros.
+func FormatAmount(amount *big.Rat) string {
+	formatted := amount.FloatString(18)
+	formatted = strings.TrimRight(formatted, "0")
+	return strings.TrimSuffix(formatted, ".")
+}
+
+// FormatFiat formats a fiat value with two decimals.
+func FormatFiat(value *big.Rat) string {
+	return value.FloatString(2)
+}
+
+// WriteLotsCSV writes the lots of the report as CSV, one row per lot.
+func (report *Report) WriteLotsCSV(writer io.Writer) error {
+	csvWriter := csv.NewWriter(writer)
+	err := csvWriter.Write([]string{
+		"Disposal Time", "Disposal TxID", "Type", "Unit", "Amount",
+		"Acquisition Time", "Acquisition TxID", "Proceeds", "Cost Basis", "Gain",
+	})
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	for _, lot := range report.Lots {
+		acquisitionTime := ""
+		if lot.AcquisitionTime != nil {
+			acquisitionTime = lot.AcquisitionTime.UTC().Format(time.RFC3339)
+		}
+		err := csvWriter.Write([]string{
+			lot.DisposalTime.UTC().Format(time.RFC3339),
+			lot.DisposalTxID,
+			string(lot.Type),
+			lot.Unit,
+			FormatAmount(lot.Amount),
+			acquisitionTime,
+			lot.AcquisitionTxID,
+			FormatFiat(lot.Proceeds),
+			FormatFiat(lot.CostBasis),
+			FormatFiat(lot.Gain()),
+		})
+		if err != nil {
+			return errp.WithStack(err)
+		}
+	}
+	csvWriter.Flush()
+	return errp.WithStack(csvWriter.Error())
+}
+
+// WriteYearsCSV writes the yearly summary of the report as CSV, one row per year.
+func (report *Report) WriteYearsCSV(writer io.Writer) error {
+	csvWriter := csv.NewWriter(writer)
+	if err := csvWriter.Write([]string{"Year", "Proceeds", "Cost Basis", "Gain"}); err != nil {
+		return errp.WithStack(err)
+	}
+	for _, year := range report.Years {
+		err := csvWriter.Write([]string{
+			strconv.Itoa(year.Year),
+			FormatFiat(year.Proceeds),
+			FormatFiat(year.CostBasis),
+			FormatFiat(year.Gain),
+		})
+		if err != nil {
+			return errp.WithStack(err)
+		}
+	}
+	csvWriter.Flush()
+	return errp.WithStack(csvWriter.Error())
+}
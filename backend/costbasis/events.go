@@ -0,0 +1,133 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package costbasis
+
+import (
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+)
+
+// AccountHistory is the transaction history of an account.
+type AccountHistory struct {
+	// Unit is the unit of the coin of the account. The transactions of the accounts of the same
+	// coin are matched together.
+	Unit         string
+	Transactions []*transactions.TxInfo
+}
+
+// coinAmount converts a btcutil.Amount to the unit of the coin.
+func coinAmount(amount btcutil.Amount) *big.Rat {
+	return big.NewRat(int64(amount), btcutil.SatoshiPerBitcoin)
+}
+
+// Events turns the histories of accounts into acquisitions and disposals, valued with the given
+// rate, which returns the fiat price of one coin of the given unit at the given time.
+//
+// Only confirmed transactions with a known time are taken into account. Transfers between the
+// accounts of the same coin and to the same account (transactions.TxTypeSendSelf) are not
+// disposals, but their fees are.
+func Events(
+	histories []*AccountHistory,
+	rate func(unit string, t time.Time) (float64, error),
+) ([]*Event, error) {
+	// txSums are the amounts of a transaction over all accounts of the same coin.
+	type txSums struct {
+		unit     string
+		txID     string
+		time     time.Time
+		received *big.Rat
+		sent     *big.Rat
+		fee      *big.Rat
+	}
+	txs := map[string]*txSums{}
+	for _, history := range histories {
+		for _, txInfo := range history.Transactions {
+			if txInfo.Height <= 0 || txInfo.Timestamp == nil || txInfo.Conflicted {
+				continue
+			}
+			txID := txInfo.Tx.TxHash().String()
+			key := history.Unit + "/" + txID
+			sums, ok := txs[key]
+			if !ok {
+				sums = &txSums{
+					unit:     history.Unit,
+					txID:     txID,
+					time:     *txInfo.Timestamp,
+					received: new(big.Rat),
+					sent:     new(big.Rat),
+					fee:      new(big.Rat),
+				}
+				txs[key] = sums
+			}
+			switch txInfo.Type {
+			case transactions.TxTypeReceive:
+				sums.received.Add(sums.received, coinAmount(txInfo.Amount))
+			case transactions.TxTypeSend:
+				sums.sent.Add(sums.sent, coinAmount(txInfo.Amount))
+			}
+			if txInfo.Fee != nil && txInfo.Type != transactions.TxTypeReceive {
+				sums.fee.Add(sums.fee, coinAmount(*txInfo.Fee))
+			}
+		}
+	}
+	keys := make([]string, 0, len(txs))
+	for key := range txs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	events := []*Event{}
+	for _, key := range keys {
+		sums := txs[key]
+		newEvent := func(eventType EventType, amount *big.Rat) error {
+			price, err := rate(sums.unit, sums.time)
+			if err != nil {
+				return err
+			}
+			priceRat := new(big.Rat)
+			priceRat.SetFloat64(price)
+			events = append(events, &Event{
+				Time:   sums.time,
+				TxID:   sums.txID,
+				Type:   eventType,
+				Unit:   sums.unit,
+				Amount: amount,
+				Value:  new(big.Rat).Mul(amount, priceRat),
+			})
+			return nil
+		}
+		// The amount sent to the other accounts is received by them.
+		net := new(big.Rat).Sub(sums.received, sums.sent)
+		switch net.Sign() {
+		case 1:
+			if err := newEvent(EventAcquisition, net); err != nil {
+				return nil, err
+			}
+		case -1:
+			if err := newEvent(EventDisposal, net.Neg(net)); err != nil {
+				return nil, err
+			}
+		}
+		if sums.fee.Sign() > 0 {
+			if err := newEvent(EventFee, sums.fee); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return events, nil
+}
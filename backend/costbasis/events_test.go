@@ -0,0 +1,99 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package costbasis_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/costbasis"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/require"
+)
+
+func txInfo(
+	lockTime uint32, txType transactions.TxType, amount btcutil.Amount, fee btcutil.Amount,
+	height int, day string) *transactions.TxInfo {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.LockTime = lockTime
+	timestamp := date(day)
+	info := &transactions.TxInfo{
+		Tx: tx, Type: txType, Amount: amount, Height: height, Timestamp: &timestamp}
+	if txType != transactions.TxTypeReceive {
+		info.Fee = &fee
+	}
+	return info
+}
+
+func TestEvents(t *testing.T) {
+	received := txInfo(1, transactions.TxTypeReceive, 1e8, 0, 10, "2020-01-01")
+	sent := txInfo(2, transactions.TxTypeSend, 3e7, 1000, 11, "2020-02-01")
+	// 2e7 go from the first account to the second one.
+	transferOut := txInfo(3, transactions.TxTypeSend, 2e7, 2000, 12, "2020-03-01")
+	transferIn := txInfo(3, transactions.TxTypeReceive, 2e7, 0, 12, "2020-03-01")
+	toSelf := txInfo(4, transactions.TxTypeSendSelf, 5e6, 3000, 13, "2020-04-01")
+	unconfirmed := txInfo(5, transactions.TxTypeReceive, 1e8, 0, 0, "2020-05-01")
+	unconfirmed.Timestamp = nil
+	conflicted := txInfo(6, transactions.TxTypeSend, 1e8, 500, 14, "2020-05-01")
+	conflicted.Conflicted = true
+	histories := []*costbasis.AccountHistory{
+		{Unit: "TBTC", Transactions: []*transactions.TxInfo{
+			received, sent, transferOut, toSelf, unconfirmed, conflicted}},
+		{Unit: "TBTC", Transactions: []*transactions.TxInfo{transferIn}},
+	}
+	prices := map[string]float64{
+		"2020-01-01": 1000, "2020-02-01": 2000, "2020-03-01": 3000, "2020-04-01": 4000}
+	rate := func(unit string, at time.Time) (float64, error) {
+		require.Equal(t, "TBTC", unit)
+		price, ok := prices[at.Format("2006-01-02")]
+		if !ok {
+			return 0, errp.New("no price")
+		}
+		return price, nil
+	}
+	events, err := costbasis.Events(histories, rate)
+	require.NoError(t, err)
+
+	type row struct {
+		txID   string
+		typ    costbasis.EventType
+		amount string
+		value  string
+	}
+	rows := map[row]bool{}
+	for _, event := range events {
+		rows[row{event.TxID, event.Type, costbasis.FormatAmount(event.Amount),
+			costbasis.FormatFiat(event.Value)}] = true
+	}
+	require.Equal(t, map[row]bool{
+		{received.Tx.TxHash().String(), costbasis.EventAcquisition, "1", "1000.00"}: true,
+		{sent.Tx.TxHash().String(), costbasis.EventDisposal, "0.3", "600.00"}:       true,
+		{sent.Tx.TxHash().String(), costbasis.EventFee, "0.00001", "0.02"}:          true,
+		{transferOut.Tx.TxHash().String(), costbasis.EventFee, "0.00002", "0.06"}:   true,
+		{toSelf.Tx.TxHash().String(), costbasis.EventFee, "0.00003", "0.12"}:        true,
+	}, rows)
+
+	// Events are deterministic.
+	events2, err := costbasis.Events(histories, rate)
+	require.NoError(t, err)
+	require.Equal(t, events, events2)
+
+	delete(prices, "2020-04-01")
+	_, err = costbasis.Events(histories, rate)
+	require.Error(t, err)
+}
@@ -21,50 +21,74 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	bbolt "github.com/coreos/bbolt"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/schema"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 )
 
 // DB is a bbolt key/value database.
 type DB struct {
-	db *bbolt.DB
+	db        *bbolt.DB
+	migration *schema.Result
 }
 
-// NewDB creates/opens a new db.
+// NewDB creates/opens a new db and migrates it to the latest schema version.
 func NewDB(filename string) (*DB, error) {
 	db, err := bbolt.Open(filename, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db: db}, nil
+	migration, err := schema.Migrate(db, migrations)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &DB{db: db, migration: migration}, nil
+}
+
+// Close closes the database.
+func (db *DB) Close() error {
+	return errp.WithStack(db.db.Close())
+}
+
+// Migration returns the outcome of the schema migration when the database was opened. If the
+// database had to be reset, the headers are synced again from scratch.
+func (db *DB) Migration() *schema.Result {
+	return db.migration
 }
 
 const (
+	bucketRoot    = "headers"
 	bucketInfo    = "info"
 	bucketHeaders = "headers"
 )
 
+// migrations upgrade the schema of the database, see schema.Migrate. New migrations are appended.
+var migrations = []schema.Migration{
+	// Version 1: the buckets, which were previously created when beginning a transaction.
+	func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketRoot))
+		if err != nil {
+			return errp.WithStack(err)
+		}
+		if _, err := bucket.CreateBucketIfNotExists([]byte(bucketInfo)); err != nil {
+			return errp.WithStack(err)
+		}
+		_, err = bucket.CreateBucketIfNotExists([]byte(bucketHeaders))
+		return errp.WithStack(err)
+	},
+}
+
 // Begin implements headers.DBInterface.
 func (db *DB) Begin() (headers.DBTxInterface, error) {
 	tx, err := db.db.Begin(true)
 	if err != nil {
 		return nil, err
 	}
-	bucket, err := tx.CreateBucketIfNotExists([]byte("headers"))
-	if err != nil {
-		return nil, err
-	}
-	bucketInfo, err := bucket.CreateBucketIfNotExists([]byte(bucketInfo))
-	if err != nil {
-		return nil, err
-	}
-	bucketHeaders, err := bucket.CreateBucketIfNotExists([]byte(bucketHeaders))
-	if err != nil {
-		return nil, err
-	}
+	bucket := tx.Bucket([]byte(bucketRoot))
 	return &Tx{
 		tx:            tx,
-		bucketInfo:    bucketInfo,
-		bucketHeaders: bucketHeaders,
+		bucketInfo:    bucket.Bucket([]byte(bucketInfo)),
+		bucketHeaders: bucket.Bucket([]byte(bucketHeaders)),
 	}, nil
 }
 
@@ -113,6 +137,30 @@ func (tx *Tx) Tip() (int, error) {
 	return -1, nil
 }
 
+// PutBaseHeader implements headers.DBTxInterface.
+func (tx *Tx) PutBaseHeader(base int, header *wire.BlockHeader) error {
+	var headerSer bytes.Buffer
+	if err := header.Serialize(&headerSer); err != nil {
+		return errp.WithStack(err)
+	}
+	if err := tx.bucketHeaders.Put(serInt(base), headerSer.Bytes()); err != nil {
+		return err
+	}
+	return tx.bucketInfo.Put([]byte("base"), serInt(base))
+}
+
+// Base implements headers.DBTxInterface.
+func (tx *Tx) Base() (int, error) {
+	if value := tx.bucketInfo.Get([]byte("base")); value != nil {
+		var base int64
+		if err := binary.Read(bytes.NewReader(value), binary.BigEndian, &base); err != nil {
+			return 0, errp.WithStack(err)
+		}
+		return int(base), nil
+	}
+	return 0, nil
+}
+
 // PutHeader implements headers.DBTxInterface.
 func (tx *Tx) PutHeader(tip int, header *wire.BlockHeader) error {
 	var headerSer bytes.Buffer
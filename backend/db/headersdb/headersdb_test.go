@@ -0,0 +1,125 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package headersdb_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	bbolt "github.com/coreos/bbolt"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/headersdb"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/schema"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func serInt(i int) []byte {
+	var buffer bytes.Buffer
+	if err := binary.Write(&buffer, binary.BigEndian, int64(i)); err != nil {
+		panic(err)
+	}
+	return buffer.Bytes()
+}
+
+// TestMigrateUnversioned opens a database as written before the schema was versioned.
+func TestMigrateUnversioned(t *testing.T) {
+	filename := test.TstTempFile("bitbox-wallet-headers-")
+	genesis := chaincfg.MainNetParams.GenesisBlock.Header
+	fixture, err := bbolt.Open(filename, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, fixture.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("headers"))
+		if err != nil {
+			return err
+		}
+		info, err := bucket.CreateBucket([]byte("info"))
+		if err != nil {
+			return err
+		}
+		if err := info.Put([]byte("tip"), serInt(0)); err != nil {
+			return err
+		}
+		headers, err := bucket.CreateBucket([]byte("headers"))
+		if err != nil {
+			return err
+		}
+		var header bytes.Buffer
+		if err := genesis.Serialize(&header); err != nil {
+			return err
+		}
+		return headers.Put(serInt(0), header.Bytes())
+	}))
+	require.NoError(t, fixture.Close())
+
+	db, err := headersdb.NewDB(filename)
+	require.NoError(t, err)
+	require.Equal(t, &schema.Result{FromVersion: 0}, db.Migration())
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+	tip, err := dbTx.Tip()
+	require.NoError(t, err)
+	require.Equal(t, 0, tip)
+	header, err := dbTx.HeaderByHeight(0)
+	require.NoError(t, err)
+	require.Equal(t, genesis.BlockHash(), header.BlockHash())
+	base, err := dbTx.Base()
+	require.NoError(t, err)
+	require.Equal(t, 0, base)
+}
+
+func TestNewDB(t *testing.T) {
+	db, err := headersdb.NewDB(test.TstTempFile("bitbox-wallet-headers-"))
+	require.NoError(t, err)
+	require.Equal(t, &schema.Result{FromVersion: 0}, db.Migration())
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+	tip, err := dbTx.Tip()
+	require.NoError(t, err)
+	require.Equal(t, -1, tip)
+}
+
+// TestReset opens a database written by a newer version of the app, which is cleared.
+func TestReset(t *testing.T) {
+	filename := test.TstTempFile("bitbox-wallet-headers-")
+	db, err := headersdb.NewDB(filename)
+	require.NoError(t, err)
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, dbTx.PutHeader(0, &chaincfg.MainNetParams.GenesisBlock.Header))
+	require.NoError(t, dbTx.Commit())
+	require.NoError(t, db.Close())
+
+	fixture, err := bbolt.Open(filename, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, fixture.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("meta")).Put([]byte("version"), serInt(100))
+	}))
+	require.NoError(t, fixture.Close())
+
+	db, err = headersdb.NewDB(filename)
+	require.NoError(t, err)
+	require.Equal(t, 100, db.Migration().FromVersion)
+	require.Error(t, db.Migration().Reset)
+	dbTx, err = db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+	tip, err := dbTx.Tip()
+	require.NoError(t, err)
+	require.Equal(t, -1, tip)
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schema versions the schema of bbolt databases and migrates them to the latest version.
+package schema
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	bbolt "github.com/coreos/bbolt"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	bucketMeta = "meta"
+	keyVersion = "version"
+)
+
+// Migration upgrades a database from the previous schema version to the next one. The first
+// migration upgrades a database without a schema version, which is version 0.
+type Migration func(tx *bbolt.Tx) error
+
+// Result describes the outcome of Migrate.
+type Result struct {
+	// FromVersion is the schema version of the database before the migration.
+	FromVersion int
+	// Reset is not nil if the database could not be migrated and was cleared instead, so that
+	// its contents are synced again from scratch. It holds the reason.
+	Reset error
+}
+
+// Version returns the schema version of the database, 0 if it has none.
+func Version(tx *bbolt.Tx) (int, error) {
+	bucket := tx.Bucket([]byte(bucketMeta))
+	if bucket == nil {
+		return 0, nil
+	}
+	value := bucket.Get([]byte(keyVersion))
+	if value == nil {
+		return 0, nil
+	}
+	var version int64
+	if err := binary.Read(bytes.NewReader(value), binary.BigEndian, &version); err != nil {
+		return 0, errp.WithStack(err)
+	}
+	return int(version), nil
+}
+
+func putVersion(tx *bbolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketMeta))
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	var buffer bytes.Buffer
+	if err := binary.Write(&buffer, binary.BigEndian, int64(version)); err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(bucket.Put([]byte(keyVersion), buffer.Bytes()))
+}
+
+// Migrate upgrades the database to the latest schema version, which is the number of migrations.
+// The pending migrations are run in order in one database transaction, so that none is applied
+// if one fails. A new database is initialized by running all migrations.
+//
+// If a migration fails, or if the database has a newer schema version than known, e.g. after a
+// downgrade of the app, all buckets are deleted and the database is initialized from scratch
+// instead. Result.Reset holds the reason in this case. An error is returned only if the database
+// could not be initialized at all.
+func Migrate(db *bbolt.DB, migrations []Migration) (*Result, error) {
+	latest := len(migrations)
+	result := &Result{}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		version, err := Version(tx)
+		if err != nil {
+			return err
+		}
+		result.FromVersion = version
+		if version > latest {
+			return errp.Newf("unknown schema version %d, the latest is %d", version, latest)
+		}
+		if version == latest {
+			return nil
+		}
+		for index := version; index < latest; index++ {
+			if err := migrations[index](tx); err != nil {
+				return errp.Wrap(err, fmt.Sprintf("migration to schema version %d failed", index+1))
+			}
+		}
+		return putVersion(tx, latest)
+	})
+	if err == nil {
+		return result, nil
+	}
+	result.Reset = err
+	if err := Reset(db, migrations); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Reset deletes all buckets of the database and initializes it with the latest schema version.
+func Reset(db *bbolt.DB, migrations []Migration) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		names := [][]byte{}
+		err := tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			names = append(names, append([]byte{}, name...))
+			return nil
+		})
+		if err != nil {
+			return errp.WithStack(err)
+		}
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return errp.WithStack(err)
+			}
+		}
+		for _, migration := range migrations {
+			if err := migration(tx); err != nil {
+				return err
+			}
+		}
+		return putVersion(tx, len(migrations))
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema_test
+
+import (
+	"errors"
+	"testing"
+
+	bbolt "github.com/coreos/bbolt"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/schema"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func openDB(t *testing.T) *bbolt.DB {
+	db, err := bbolt.Open(test.TstTempFile("bitbox-wallet-schema-"), 0600, nil)
+	require.NoError(t, err)
+	return db
+}
+
+// bucketMigration returns a migration creating a bucket with the given name, and records when it
+// is run.
+func bucketMigration(name string, run *[]string) schema.Migration {
+	return func(tx *bbolt.Tx) error {
+		*run = append(*run, name)
+		_, err := tx.CreateBucket([]byte(name))
+		return err
+	}
+}
+
+func version(t *testing.T, db *bbolt.DB) int {
+	var version int
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		var err error
+		version, err = schema.Version(tx)
+		return err
+	}))
+	return version
+}
+
+func hasBucket(t *testing.T, db *bbolt.DB, name string) bool {
+	var found bool
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		found = tx.Bucket([]byte(name)) != nil
+		return nil
+	}))
+	return found
+}
+
+func TestMigrate(t *testing.T) {
+	db := openDB(t)
+	defer func() { _ = db.Close() }()
+	run := []string{}
+	migrations := []schema.Migration{bucketMigration("one", &run), bucketMigration("two", &run)}
+
+	result, err := schema.Migrate(db, migrations)
+	require.NoError(t, err)
+	require.Equal(t, &schema.Result{FromVersion: 0}, result)
+	require.Equal(t, []string{"one", "two"}, run)
+	require.Equal(t, 2, version(t, db))
+
+	// Migrations are run only once.
+	result, err = schema.Migrate(db, migrations)
+	require.NoError(t, err)
+	require.Equal(t, &schema.Result{FromVersion: 2}, result)
+	require.Equal(t, []string{"one", "two"}, run)
+
+	// Only the new migrations are run.
+	migrations = append(migrations, bucketMigration("three", &run))
+	result, err = schema.Migrate(db, migrations)
+	require.NoError(t, err)
+	require.Equal(t, &schema.Result{FromVersion: 2}, result)
+	require.Equal(t, []string{"one", "two", "three"}, run)
+	require.Equal(t, 3, version(t, db))
+	require.True(t, hasBucket(t, db, "three"))
+}
+
+func TestMigrateFailure(t *testing.T) {
+	db := openDB(t)
+	defer func() { _ = db.Close() }()
+	run := []string{}
+	_, err := schema.Migrate(db, []schema.Migration{bucketMigration("one", &run)})
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("one")).Put([]byte("key"), []byte("value"))
+	}))
+
+	failed := false
+	migrations := []schema.Migration{
+		bucketMigration("one", &run),
+		bucketMigration("two", &run),
+		func(tx *bbolt.Tx) error {
+			if !failed {
+				failed = true
+				return errors.New("failed")
+			}
+			return nil
+		},
+	}
+	result, err := schema.Migrate(db, migrations)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.FromVersion)
+	require.Error(t, result.Reset)
+	// The database is initialized from scratch.
+	require.Equal(t, []string{"one", "two", "one", "two"}, run)
+	require.Equal(t, 3, version(t, db))
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("one")).Get([]byte("key")))
+		return nil
+	}))
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	db := openDB(t)
+	defer func() { _ = db.Close() }()
+	run := []string{}
+	_, err := schema.Migrate(db, []schema.Migration{
+		bucketMigration("one", &run), bucketMigration("two", &run)})
+	require.NoError(t, err)
+
+	result, err := schema.Migrate(db, []schema.Migration{bucketMigration("one", &run)})
+	require.NoError(t, err)
+	require.Equal(t, 2, result.FromVersion)
+	require.Error(t, result.Reset)
+	require.Equal(t, 1, version(t, db))
+	require.False(t, hasBucket(t, db, "two"))
+}
+
+func TestMigrateResetFailure(t *testing.T) {
+	db := openDB(t)
+	defer func() { _ = db.Close() }()
+	_, err := schema.Migrate(db, []schema.Migration{
+		func(tx *bbolt.Tx) error { return errors.New("failed") },
+	})
+	require.Error(t, err)
+}
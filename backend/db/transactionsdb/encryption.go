@@ -0,0 +1,158 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transactionsdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"math"
+
+	bbolt "github.com/coreos/bbolt"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const keyKeyID = "keyID"
+
+// keyID identifies the encryption key without revealing it, to detect a cache encrypted with
+// another key, or not encrypted.
+func keyID(key []byte) []byte {
+	if key == nil {
+		return []byte("unencrypted")
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte("key id"))
+	return mac.Sum(nil)
+}
+
+// newCipher returns the AES-256-GCM cipher for the given 32 byte key, or nil if key is nil.
+func newCipher(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return aead, nil
+}
+
+// newIDKey returns the key with which the IDs of the values are hashed, derived from the encryption
+// key, or nil if key is nil.
+func newIDKey(key []byte) []byte {
+	if key == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte("ids"))
+	return mac.Sum(nil)
+}
+
+// dbKey returns the key under which the value with the given ID, e.g. a txid, an outpoint or a
+// script hash, is stored. If the database is encrypted, it is the HMAC of the ID, so that the IDs
+// are not stored in the clear.
+func (tx *Tx) dbKey(id []byte) []byte {
+	if tx.cipher == nil {
+		return id
+	}
+	mac := hmac.New(sha256.New, tx.idKey)
+	_, _ = mac.Write(id)
+	return mac.Sum(nil)
+}
+
+// put stores the value with the given ID, see putAt.
+func (tx *Tx) put(bucket *bbolt.Bucket, id []byte, value []byte) error {
+	return tx.putAt(bucket, tx.dbKey(id), id, value)
+}
+
+// putAt stores the value with the given ID under the given key. If the database is encrypted, the
+// ID and the value are encrypted together, so that the ID can be recovered when iterating, and the
+// nonce is prepended to the ciphertext. The key is authenticated, so that values can't be swapped.
+func (tx *Tx) putAt(bucket *bbolt.Bucket, key []byte, id []byte, value []byte) error {
+	if tx.cipher != nil {
+		if len(id) > math.MaxUint8 {
+			return errp.New("id too long")
+		}
+		plaintext := append(append([]byte{byte(len(id))}, id...), value...)
+		nonce := make([]byte, tx.cipher.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return errp.WithStack(err)
+		}
+		value = tx.cipher.Seal(nonce, nonce, plaintext, key)
+	} else if value == nil {
+		// A nil value in a new inline bucket makes bbolt fail to delete the bucket in the same db
+		// transaction.
+		value = []byte{}
+	}
+	return errp.WithStack(bucket.Put(key, value))
+}
+
+// get returns the value stored with put(), or nil if there is none.
+func (tx *Tx) get(bucket *bbolt.Bucket, id []byte) ([]byte, error) {
+	key := tx.dbKey(id)
+	_, value, err := tx.decrypt(key, bucket.Get(key))
+	return value, err
+}
+
+// delete deletes the value stored with put().
+func (tx *Tx) delete(bucket *bbolt.Bucket, id []byte) error {
+	return errp.WithStack(bucket.Delete(tx.dbKey(id)))
+}
+
+// forEach calls f with the ID and the value of each entry of the bucket, in the order of the keys.
+func (tx *Tx) forEach(bucket *bbolt.Bucket, f func(id []byte, value []byte) error) error {
+	cursor := bucket.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		id, value, err := tx.decrypt(key, value)
+		if err != nil {
+			return err
+		}
+		if err := f(id, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decrypt returns the ID and the value of an entry stored with putAt(). The value is nil if there is
+// none.
+func (tx *Tx) decrypt(key []byte, value []byte) ([]byte, []byte, error) {
+	if tx.cipher == nil {
+		return key, value, nil
+	}
+	if value == nil {
+		return nil, nil, nil
+	}
+	nonceSize := tx.cipher.NonceSize()
+	if len(value) < nonceSize {
+		return nil, nil, errp.New("encrypted value too short")
+	}
+	plaintext, err := tx.cipher.Open(nil, value[:nonceSize], value[nonceSize:], key)
+	if err != nil {
+		return nil, nil, errp.WithStack(err)
+	}
+	if len(plaintext) == 0 || len(plaintext) < 1+int(plaintext[0]) {
+		return nil, nil, errp.New("encrypted value too short")
+	}
+	idLength := 1 + int(plaintext[0])
+	return plaintext[1:idLength], plaintext[idLength:], nil
+}
@@ -15,6 +15,8 @@
 package transactionsdb
 
 import (
+	"bytes"
+	"crypto/cipher"
 	"encoding/json"
 	"time"
 
@@ -24,6 +26,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/util"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/schema"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 )
 
@@ -33,55 +36,196 @@ const (
 	bucketInputs                 = "inputs"
 	bucketOutputs                = "outputs"
 	bucketAddressHistories       = "addressHistories"
+	bucketInfo                   = "info"
+	bucketTxIndex                = "txIndex"
+	bucketTxIndexKeys            = "txIndexKeys"
+	bucketTxIndexStale           = "txIndexStale"
+	bucketTxIndexAddresses       = "txIndexAddresses"
+	bucketSpenders               = "spenders"
+
+	// bucketTxLabels was used until version 6, the labels are stored outside of the database.
+	bucketTxLabels = "txLabels"
 )
 
-// DB is a bbolt key/value database.
-type DB struct {
-	db *bbolt.DB
+// dataBuckets are the buckets holding the cached data.
+var dataBuckets = []string{
+	bucketTransactions,
+	bucketUnverifiedTransactions,
+	bucketInputs,
+	bucketOutputs,
+	bucketAddressHistories,
 }
 
-// NewDB creates/opens a new db.
-func NewDB(filename string) (*DB, error) {
-	db, err := bbolt.Open(filename, 0600, nil)
-	if err != nil {
-		return nil, err
+// migrations upgrade the schema of the database, see schema.Migrate. New migrations are appended.
+var migrations = []schema.Migration{
+	// Version 1: the buckets, which were previously created when beginning a transaction.
+	func(tx *bbolt.Tx) error {
+		for _, name := range dataBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return errp.WithStack(err)
+			}
+		}
+		return nil
+	},
+	// Version 2: the values can be encrypted, with the ID of the key in the info bucket. The data
+	// of older versions, which is not encrypted, is dropped.
+	func(tx *bbolt.Tx) error {
+		for _, name := range dataBuckets {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return errp.WithStack(err)
+			}
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return errp.WithStack(err)
+			}
+		}
+		_, err := tx.CreateBucket([]byte(bucketInfo))
+		return errp.WithStack(err)
+	},
+	// Version 3: the index of the transactions, and their labels. All transactions are marked to be
+	// indexed.
+	func(tx *bbolt.Tx) error {
+		for _, name := range []string{
+			bucketTxIndex, bucketTxIndexKeys, bucketTxIndexStale, bucketTxLabels} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return errp.WithStack(err)
+			}
+		}
+		stale := tx.Bucket([]byte(bucketTxIndexStale))
+		return errp.WithStack(tx.Bucket([]byte(bucketTransactions)).ForEach(
+			func(txHash []byte, _ []byte) error {
+				// Not nil, which bbolt can't delete the bucket with, see putAt.
+				return stale.Put(txHash, []byte{})
+			}))
+	},
+	// Version 4: if the database is encrypted, the keys are the HMACs of the txids, outpoints and
+	// script hashes, which are stored encrypted with the values. The data of older versions, which
+	// has them in the clear, is dropped.
+	func(tx *bbolt.Tx) error {
+		return recreateBuckets(tx, append(dataBuckets,
+			bucketTxIndex, bucketTxIndexKeys, bucketTxIndexStale, bucketTxLabels)...)
+	},
+	// Version 5: all transactions spending an output are recorded. The data is dropped, so that the
+	// spenders of all transactions are recorded when they are synced again.
+	func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucket([]byte(bucketSpenders)); err != nil {
+			return errp.WithStack(err)
+		}
+		return recreateBuckets(tx, append(dataBuckets,
+			bucketTxIndex, bucketTxIndexKeys, bucketTxIndexStale, bucketTxLabels)...)
+	},
+	// Version 6: the index of the transactions by address. The labels are not stored in the
+	// database anymore. The data is dropped, so that all transactions are indexed again.
+	func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(bucketTxLabels)); err != nil {
+			return errp.WithStack(err)
+		}
+		if _, err := tx.CreateBucket([]byte(bucketTxIndexAddresses)); err != nil {
+			return errp.WithStack(err)
+		}
+		return recreateBuckets(tx, append(dataBuckets,
+			bucketTxIndex, bucketTxIndexKeys, bucketTxIndexStale, bucketSpenders)...)
+	},
+}
+
+// recreateBuckets deletes the buckets with the given names and creates them again, empty.
+func recreateBuckets(tx *bbolt.Tx, names ...string) error {
+	for _, name := range names {
+		if err := tx.DeleteBucket([]byte(name)); err != nil {
+			return errp.WithStack(err)
+		}
+		if _, err := tx.CreateBucket([]byte(name)); err != nil {
+			return errp.WithStack(err)
+		}
 	}
-	return &DB{db: db}, nil
+	return nil
 }
 
-// Begin implements transactions.Begin.
-func (db *DB) Begin() (transactions.DBTxInterface, error) {
-	tx, err := db.db.Begin(true)
+// DB is a bbolt key/value database.
+type DB struct {
+	db        *bbolt.DB
+	migration *schema.Result
+	cipher    cipher.AEAD
+	idKey     []byte
+}
+
+// NewDB creates/opens a new db and migrates it to the latest schema version. If key is not nil, the
+// values are encrypted with it (AES-256-GCM, 32 byte key), and the keys are HMACs of the txids,
+// outpoints and script hashes. A database encrypted with a different key, or not encrypted, can't
+// be read and is reset.
+func NewDB(filename string, key []byte) (*DB, error) {
+	aead, err := newCipher(key)
 	if err != nil {
 		return nil, err
 	}
-	bucketTransactions, err := tx.CreateBucketIfNotExists([]byte(bucketTransactions))
+	db, err := bbolt.Open(filename, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
-	bucketUnverifiedTransactions, err := tx.CreateBucketIfNotExists([]byte(bucketUnverifiedTransactions))
-	if err != nil {
-		return nil, err
+	migration, err := schema.Migrate(db, migrations)
+	if err == nil {
+		err = checkKey(db, key, migration)
 	}
-	bucketInputs, err := tx.CreateBucketIfNotExists([]byte(bucketInputs))
 	if err != nil {
+		_ = db.Close()
 		return nil, err
 	}
-	bucketOutputs, err := tx.CreateBucketIfNotExists([]byte(bucketOutputs))
+	return &DB{db: db, migration: migration, cipher: aead, idKey: newIDKey(key)}, nil
+}
+
+// checkKey resets the database if it was encrypted with another key than the given one, or not
+// encrypted, and records the reset in the migration result.
+func checkKey(db *bbolt.DB, key []byte, migration *schema.Result) error {
+	id := keyID(key)
+	var storedID []byte
+	err := db.View(func(tx *bbolt.Tx) error {
+		storedID = append([]byte{}, tx.Bucket([]byte(bucketInfo)).Get([]byte(keyKeyID))...)
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return errp.WithStack(err)
+	}
+	if bytes.Equal(storedID, id) {
+		return nil
+	}
+	if len(storedID) != 0 {
+		if migration.Reset == nil {
+			migration.Reset = errp.New("the database was encrypted with a different key")
+		}
+		if err := schema.Reset(db, migrations); err != nil {
+			return err
+		}
 	}
-	bucketAddressHistories, err := tx.CreateBucketIfNotExists([]byte(bucketAddressHistories))
+	return errp.WithStack(db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucketInfo)).Put([]byte(keyKeyID), id)
+	}))
+}
+
+// Migration returns the outcome of the schema migration when the database was opened. If the
+// database had to be reset, the transactions are synced again from scratch.
+func (db *DB) Migration() *schema.Result {
+	return db.migration
+}
+
+// Begin implements transactions.Begin.
+func (db *DB) Begin() (transactions.DBTxInterface, error) {
+	tx, err := db.db.Begin(true)
 	if err != nil {
 		return nil, err
 	}
 	return &Tx{
 		tx:                           tx,
-		bucketTransactions:           bucketTransactions,
-		bucketUnverifiedTransactions: bucketUnverifiedTransactions,
-		bucketInputs:                 bucketInputs,
-		bucketOutputs:                bucketOutputs,
-		bucketAddressHistories:       bucketAddressHistories,
+		cipher:                       db.cipher,
+		idKey:                        db.idKey,
+		bucketTransactions:           tx.Bucket([]byte(bucketTransactions)),
+		bucketUnverifiedTransactions: tx.Bucket([]byte(bucketUnverifiedTransactions)),
+		bucketInputs:                 tx.Bucket([]byte(bucketInputs)),
+		bucketOutputs:                tx.Bucket([]byte(bucketOutputs)),
+		bucketAddressHistories:       tx.Bucket([]byte(bucketAddressHistories)),
+		bucketTxIndex:                tx.Bucket([]byte(bucketTxIndex)),
+		bucketTxIndexKeys:            tx.Bucket([]byte(bucketTxIndexKeys)),
+		bucketTxIndexStale:           tx.Bucket([]byte(bucketTxIndexStale)),
+		bucketTxIndexAddresses:       tx.Bucket([]byte(bucketTxIndexAddresses)),
+		bucketSpenders:               tx.Bucket([]byte(bucketSpenders)),
 	}, nil
 }
 
@@ -93,12 +237,21 @@ func (db *DB) Close() error {
 // Tx implements transactions.DBTxInterface.
 type Tx struct {
 	tx *bbolt.Tx
+	// cipher encrypts the values, nil if they are not encrypted.
+	cipher cipher.AEAD
+	// idKey is the key of the HMACs of the IDs, see dbKey.
+	idKey []byte
 
 	bucketTransactions           *bbolt.Bucket
 	bucketUnverifiedTransactions *bbolt.Bucket
 	bucketInputs                 *bbolt.Bucket
 	bucketOutputs                *bbolt.Bucket
 	bucketAddressHistories       *bbolt.Bucket
+	bucketTxIndex                *bbolt.Bucket
+	bucketTxIndexKeys            *bbolt.Bucket
+	bucketTxIndexStale           *bbolt.Bucket
+	bucketTxIndexAddresses       *bbolt.Bucket
+	bucketSpenders               *bbolt.Bucket
 }
 
 // Rollback implements transactions.DBTxInterface.
@@ -118,6 +271,9 @@ type walletTransaction struct {
 	Addresses       map[string]bool `json:"addresses"`
 	Verified        *bool
 	HeaderTimestamp *time.Time `json:"ts"`
+	// HeaderHash is the hash of the header the tx was verified against. It is nil for transactions
+	// verified before it was stored.
+	HeaderHash *chainhash.Hash `json:"headerHash,omitempty"`
 }
 
 func newWalletTransaction() *walletTransaction {
@@ -128,34 +284,38 @@ func newWalletTransaction() *walletTransaction {
 	}
 }
 
-func readJSON(bucket *bbolt.Bucket, key []byte, value interface{}) (bool, error) {
-	if jsonBytes := bucket.Get(key); jsonBytes != nil {
+func (tx *Tx) readJSON(bucket *bbolt.Bucket, key []byte, value interface{}) (bool, error) {
+	jsonBytes, err := tx.get(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	if jsonBytes != nil {
 		return true, errp.WithStack(json.Unmarshal(jsonBytes, value))
 	}
 	return false, nil
 }
 
-func writeJSON(bucket *bbolt.Bucket, key []byte, value interface{}) error {
+func (tx *Tx) writeJSON(bucket *bbolt.Bucket, key []byte, value interface{}) error {
 	jsonBytes, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
-	return bucket.Put(key, jsonBytes)
+	return tx.put(bucket, key, jsonBytes)
 }
 
 func (tx *Tx) modifyTx(key []byte, f func(value *walletTransaction)) error {
 	walletTx := newWalletTransaction()
-	if _, err := readJSON(tx.bucketTransactions, key, walletTx); err != nil {
+	if _, err := tx.readJSON(tx.bucketTransactions, key, walletTx); err != nil {
 		return err
 	}
 	f(walletTx)
-	return writeJSON(tx.bucketTransactions, key, walletTx)
+	return tx.writeJSON(tx.bucketTransactions, key, walletTx)
 }
 
 // TxInfo implements transactions.DBTxInterface.
 func (tx *Tx) TxInfo(txHash chainhash.Hash) (*wire.MsgTx, []string, int, *time.Time, error) {
 	walletTx := newWalletTransaction()
-	if _, err := readJSON(tx.bucketTransactions, txHash[:], walletTx); err != nil {
+	if _, err := tx.readJSON(tx.bucketTransactions, txHash[:], walletTx); err != nil {
 		return nil, nil, 0, nil, err
 	}
 	addresses := []string{}
@@ -165,10 +325,13 @@ func (tx *Tx) TxInfo(txHash chainhash.Hash) (*wire.MsgTx, []string, int, *time.T
 	return walletTx.Tx, addresses, walletTx.Height, walletTx.HeaderTimestamp, nil
 }
 
-// PutTx implements transactions.DBTxInterface.
+// PutTx implements transactions.DBTxInterface. A verified tx whose height changes is unverified.
 func (tx *Tx) PutTx(txHash chainhash.Hash, msgTx *wire.MsgTx, height int) error {
 	var verified *bool
 	err := tx.modifyTx(txHash[:], func(walletTx *walletTransaction) {
+		if walletTx.Height != height {
+			unverify(walletTx)
+		}
 		verified = walletTx.Verified
 		walletTx.Tx = msgTx
 		walletTx.Height = height
@@ -177,7 +340,7 @@ func (tx *Tx) PutTx(txHash chainhash.Hash, msgTx *wire.MsgTx, height int) error
 		return err
 	}
 	if verified == nil {
-		return tx.bucketUnverifiedTransactions.Put(txHash[:], nil)
+		return tx.put(tx.bucketUnverifiedTransactions, txHash[:], nil)
 	}
 	return nil
 }
@@ -185,7 +348,7 @@ func (tx *Tx) PutTx(txHash chainhash.Hash, msgTx *wire.MsgTx, height int) error
 // DeleteTx implements transactions.DBTxInterface. It panics if called from a read-only db
 // transaction.
 func (tx *Tx) DeleteTx(txHash chainhash.Hash) {
-	if err := tx.bucketTransactions.Delete(txHash[:]); err != nil {
+	if err := tx.delete(tx.bucketTransactions, txHash[:]); err != nil {
 		panic(errp.WithStack(err))
 	}
 }
@@ -207,71 +370,159 @@ func (tx *Tx) RemoveAddressFromTx(txHash chainhash.Hash, scriptHashHex blockchai
 	return empty, err
 }
 
-func getTransactions(bucket *bbolt.Bucket) ([]chainhash.Hash, error) {
+func (tx *Tx) getTransactions(bucket *bbolt.Bucket) ([]chainhash.Hash, error) {
 	result := []chainhash.Hash{}
-	cursor := bucket.Cursor()
-	for txHashBytes, _ := cursor.First(); txHashBytes != nil; txHashBytes, _ = cursor.Next() {
+	err := tx.forEach(bucket, func(txHashBytes []byte, _ []byte) error {
 		var txHash chainhash.Hash
 		if err := txHash.SetBytes(txHashBytes); err != nil {
-			return nil, errp.WithStack(err)
+			return errp.WithStack(err)
 		}
 		result = append(result, txHash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return result, nil
 }
 
 // Transactions implements transactions.DBTxInterface.
 func (tx *Tx) Transactions() ([]chainhash.Hash, error) {
-	return getTransactions(tx.bucketTransactions)
+	return tx.getTransactions(tx.bucketTransactions)
 }
 
 // UnverifiedTransactions implements transactions.DBTxInterface.
 func (tx *Tx) UnverifiedTransactions() ([]chainhash.Hash, error) {
-	return getTransactions(tx.bucketUnverifiedTransactions)
+	return tx.getTransactions(tx.bucketUnverifiedTransactions)
 }
 
 // MarkTxVerified implements transactions.DBTxInterface.
-func (tx *Tx) MarkTxVerified(txHash chainhash.Hash, headerTimestamp time.Time) error {
-	if err := tx.bucketUnverifiedTransactions.Delete(txHash[:]); err != nil {
-		panic(errp.WithStack(err))
+func (tx *Tx) MarkTxVerified(
+	txHash chainhash.Hash, headerHash chainhash.Hash, headerTimestamp time.Time) error {
+	if err := tx.delete(tx.bucketUnverifiedTransactions, txHash[:]); err != nil {
+		panic(err)
 	}
 	return tx.modifyTx(txHash[:], func(walletTx *walletTransaction) {
 		truth := true
 		walletTx.Verified = &truth
 		walletTx.HeaderTimestamp = &headerTimestamp
+		walletTx.HeaderHash = &headerHash
 	})
 }
 
+func unverify(walletTx *walletTransaction) {
+	walletTx.Verified = nil
+	walletTx.HeaderTimestamp = nil
+	walletTx.HeaderHash = nil
+}
+
+// MarkTxUnverified implements transactions.DBTxInterface.
+func (tx *Tx) MarkTxUnverified(txHash chainhash.Hash) error {
+	if err := tx.modifyTx(txHash[:], unverify); err != nil {
+		return err
+	}
+	return tx.put(tx.bucketUnverifiedTransactions, txHash[:], nil)
+}
+
+// TxHeaderHash implements transactions.DBTxInterface.
+func (tx *Tx) TxHeaderHash(txHash chainhash.Hash) (*chainhash.Hash, error) {
+	walletTx := newWalletTransaction()
+	if _, err := tx.readJSON(tx.bucketTransactions, txHash[:], walletTx); err != nil {
+		return nil, err
+	}
+	return walletTx.HeaderHash, nil
+}
+
 // PutInput implements transactions.DBTxInterface.
 func (tx *Tx) PutInput(outPoint wire.OutPoint, txHash chainhash.Hash) error {
-	return tx.bucketInputs.Put([]byte(outPoint.String()), txHash[:])
+	return tx.put(tx.bucketInputs, []byte(outPoint.String()), txHash[:])
 }
 
 // Input implements transactions.DBTxInterface.
 func (tx *Tx) Input(outPoint wire.OutPoint) (*chainhash.Hash, error) {
-	if value := tx.bucketInputs.Get([]byte(outPoint.String())); value != nil {
-		return chainhash.NewHash(value)
+	value, err := tx.get(tx.bucketInputs, []byte(outPoint.String()))
+	if err != nil || value == nil {
+		return nil, err
 	}
-	return nil, nil
+	return chainhash.NewHash(value)
 }
 
 // DeleteInput implements transactions.DBTxInterface. It panics if called from a read-only db
 // transaction.
 func (tx *Tx) DeleteInput(outPoint wire.OutPoint) {
-	if err := tx.bucketInputs.Delete([]byte(outPoint.String())); err != nil {
-		panic(errp.WithStack(err))
+	if err := tx.delete(tx.bucketInputs, []byte(outPoint.String())); err != nil {
+		panic(err)
 	}
 }
 
+// Spenders implements transactions.DBTxInterface.
+func (tx *Tx) Spenders(outPoint wire.OutPoint) ([]chainhash.Hash, error) {
+	value, err := tx.get(tx.bucketSpenders, []byte(outPoint.String()))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+	if len(value)%chainhash.HashSize != 0 {
+		return nil, errp.New("invalid spenders")
+	}
+	spenders := make([]chainhash.Hash, len(value)/chainhash.HashSize)
+	for index := range spenders {
+		copy(spenders[index][:], value[index*chainhash.HashSize:])
+	}
+	return spenders, nil
+}
+
+func (tx *Tx) putSpenders(outPoint wire.OutPoint, spenders []chainhash.Hash) error {
+	if len(spenders) == 0 {
+		return tx.delete(tx.bucketSpenders, []byte(outPoint.String()))
+	}
+	value := make([]byte, 0, len(spenders)*chainhash.HashSize)
+	for _, spender := range spenders {
+		value = append(value, spender[:]...)
+	}
+	return tx.put(tx.bucketSpenders, []byte(outPoint.String()), value)
+}
+
+// AddSpender implements transactions.DBTxInterface.
+func (tx *Tx) AddSpender(outPoint wire.OutPoint, txHash chainhash.Hash) (bool, error) {
+	spenders, err := tx.Spenders(outPoint)
+	if err != nil {
+		return false, err
+	}
+	for _, spender := range spenders {
+		if spender == txHash {
+			return false, nil
+		}
+	}
+	return true, tx.putSpenders(outPoint, append(spenders, txHash))
+}
+
+// RemoveSpender implements transactions.DBTxInterface.
+func (tx *Tx) RemoveSpender(outPoint wire.OutPoint, txHash chainhash.Hash) error {
+	spenders, err := tx.Spenders(outPoint)
+	if err != nil {
+		return err
+	}
+	remaining := []chainhash.Hash{}
+	for _, spender := range spenders {
+		if spender != txHash {
+			remaining = append(remaining, spender)
+		}
+	}
+	return tx.putSpenders(outPoint, remaining)
+}
+
 // PutOutput implements transactions.DBTxInterface.
 func (tx *Tx) PutOutput(outPoint wire.OutPoint, txOut *wire.TxOut) error {
-	return writeJSON(tx.bucketOutputs, []byte(outPoint.String()), txOut)
+	return tx.writeJSON(tx.bucketOutputs, []byte(outPoint.String()), txOut)
 }
 
 // Output implements transactions.DBTxInterface.
 func (tx *Tx) Output(outPoint wire.OutPoint) (*wire.TxOut, error) {
 	txOut := &wire.TxOut{}
-	found, err := readJSON(tx.bucketOutputs, []byte(outPoint.String()), txOut)
+	found, err := tx.readJSON(tx.bucketOutputs, []byte(outPoint.String()), txOut)
 	if err != nil {
 		return nil, err
 	}
@@ -284,17 +535,20 @@ func (tx *Tx) Output(outPoint wire.OutPoint) (*wire.TxOut, error) {
 // Outputs implements transactions.DBTxInterface.
 func (tx *Tx) Outputs() (map[wire.OutPoint]*wire.TxOut, error) {
 	outputs := map[wire.OutPoint]*wire.TxOut{}
-	cursor := tx.bucketOutputs.Cursor()
-	for outPointBytes, txOutJSONBytes := cursor.First(); outPointBytes != nil; outPointBytes, txOutJSONBytes = cursor.Next() {
+	err := tx.forEach(tx.bucketOutputs, func(outPointBytes []byte, txOutJSONBytes []byte) error {
 		txOut := &wire.TxOut{}
 		if err := json.Unmarshal(txOutJSONBytes, txOut); err != nil {
-			return nil, errp.WithStack(err)
+			return errp.WithStack(err)
 		}
 		outPoint, err := util.ParseOutPoint(outPointBytes)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		outputs[*outPoint] = txOut
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return outputs, nil
 }
@@ -302,19 +556,19 @@ func (tx *Tx) Outputs() (map[wire.OutPoint]*wire.TxOut, error) {
 // DeleteOutput implements transactions.DBTxInterface. It panics if called from a read-only db
 // transaction.
 func (tx *Tx) DeleteOutput(outPoint wire.OutPoint) {
-	if err := tx.bucketOutputs.Delete([]byte(outPoint.String())); err != nil {
-		panic(errp.WithStack(err))
+	if err := tx.delete(tx.bucketOutputs, []byte(outPoint.String())); err != nil {
+		panic(err)
 	}
 }
 
 // PutAddressHistory implements transactions.DBTxInterface.
 func (tx *Tx) PutAddressHistory(scriptHashHex blockchain.ScriptHashHex, history blockchain.TxHistory) error {
-	return writeJSON(tx.bucketAddressHistories, []byte(string(scriptHashHex)), history)
+	return tx.writeJSON(tx.bucketAddressHistories, []byte(string(scriptHashHex)), history)
 }
 
 // AddressHistory implements transactions.DBTxInterface.
 func (tx *Tx) AddressHistory(scriptHashHex blockchain.ScriptHashHex) (blockchain.TxHistory, error) {
 	history := blockchain.TxHistory{}
-	_, err := readJSON(tx.bucketAddressHistories, []byte(string(scriptHashHex)), &history)
+	_, err := tx.readJSON(tx.bucketAddressHistories, []byte(string(scriptHashHex)), &history)
 	return history, err
 }
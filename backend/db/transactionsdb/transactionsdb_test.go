@@ -0,0 +1,466 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transactionsdb_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	bbolt "github.com/coreos/bbolt"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/schema"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/db/transactionsdb"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMigrateUnversioned opens a database as written before the schema was versioned, without the
+// address histories bucket, which was added later. Its data is not encrypted, and thus dropped.
+func TestMigrateUnversioned(t *testing.T) {
+	filename := test.TstTempFile("bitbox-wallet-db-")
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	fixture, err := bbolt.Open(filename, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, fixture.Update(func(tx *bbolt.Tx) error {
+		for _, name := range []string{"transactions", "unverifiedTransactions", "inputs", "outputs"} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		txHash := msgTx.TxHash()
+		value, err := json.Marshal(map[string]interface{}{
+			"Tx":        msgTx,
+			"Height":    10,
+			"addresses": map[string]bool{"scripthash": true},
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("transactions")).Put(txHash[:], value)
+	}))
+	require.NoError(t, fixture.Close())
+
+	db, err := transactionsdb.NewDB(filename, testKey(1))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	require.Equal(t, &schema.Result{FromVersion: 0}, db.Migration())
+
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+	storedTx, addresses, _, _, err := dbTx.TxInfo(msgTx.TxHash())
+	require.NoError(t, err)
+	require.Nil(t, storedTx)
+	require.Empty(t, addresses)
+	history := blockchain.TxHistory{}
+	require.NoError(t, dbTx.PutAddressHistory("scripthash", history))
+}
+
+func testKey(seed byte) []byte {
+	return bytes.Repeat([]byte{seed}, 32)
+}
+
+var secretScript = []byte("secret pkScript")
+
+func writeTestData(t *testing.T, db *transactionsdb.DB) wire.OutPoint {
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(1000, secretScript))
+	outPoint := wire.OutPoint{Hash: msgTx.TxHash(), Index: 0}
+	require.NoError(t, dbTx.PutTx(msgTx.TxHash(), msgTx, 10))
+	require.NoError(t, dbTx.PutInput(outPoint, msgTx.TxHash()))
+	require.NoError(t, dbTx.PutOutput(outPoint, msgTx.TxOut[0]))
+	require.NoError(t, dbTx.Commit())
+	return outPoint
+}
+
+// readTestData returns false if the data written by writeTestData is not in the database.
+func readTestData(t *testing.T, db *transactionsdb.DB, outPoint wire.OutPoint) bool {
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+	storedTx, _, height, _, err := dbTx.TxInfo(outPoint.Hash)
+	require.NoError(t, err)
+	if storedTx == nil {
+		return false
+	}
+	require.Equal(t, outPoint.Hash, storedTx.TxHash())
+	require.Equal(t, 10, height)
+	input, err := dbTx.Input(outPoint)
+	require.NoError(t, err)
+	require.Equal(t, outPoint.Hash, *input)
+	output, err := dbTx.Output(outPoint)
+	require.NoError(t, err)
+	require.Equal(t, secretScript, output.PkScript)
+	outputs, err := dbTx.Outputs()
+	require.NoError(t, err)
+	require.Equal(t, secretScript, outputs[outPoint].PkScript)
+	return true
+}
+
+func TestEncryption(t *testing.T) {
+	filename := test.TstTempFile("bitbox-wallet-db-")
+	db, err := transactionsdb.NewDB(filename, testKey(1))
+	require.NoError(t, err)
+	outPoint := writeTestData(t, db)
+	require.True(t, readTestData(t, db, outPoint))
+	require.NoError(t, db.Close())
+
+	// The values are not stored in the clear.
+	raw, err := bbolt.Open(filename, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, raw.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+			return bucket.ForEach(func(key, value []byte) error {
+				require.False(t, bytes.Contains(value, secretScript), string(name))
+				require.False(t, bytes.Contains(value, outPoint.Hash[:]), string(name))
+				return nil
+			})
+		})
+	}))
+	require.NoError(t, raw.Close())
+
+	db, err = transactionsdb.NewDB(filename, testKey(1))
+	require.NoError(t, err)
+	require.Nil(t, db.Migration().Reset)
+	require.True(t, readTestData(t, db, outPoint))
+	require.NoError(t, db.Close())
+
+	// The cache of another keystore is dropped.
+	db, err = transactionsdb.NewDB(filename, testKey(2))
+	require.NoError(t, err)
+	require.Error(t, db.Migration().Reset)
+	require.False(t, readTestData(t, db, outPoint))
+	outPoint = writeTestData(t, db)
+	require.NoError(t, db.Close())
+
+	// So is an encrypted cache opened without encryption.
+	db, err = transactionsdb.NewDB(filename, nil)
+	require.NoError(t, err)
+	require.Error(t, db.Migration().Reset)
+	require.False(t, readTestData(t, db, outPoint))
+	outPoint = writeTestData(t, db)
+	require.True(t, readTestData(t, db, outPoint))
+	require.NoError(t, db.Close())
+
+	// And an unencrypted cache opened with encryption.
+	db, err = transactionsdb.NewDB(filename, testKey(1))
+	require.NoError(t, err)
+	require.Error(t, db.Migration().Reset)
+	require.False(t, readTestData(t, db, outPoint))
+	require.NoError(t, db.Close())
+}
+
+// TestVerification checks that a tx is unverified when it is marked so, or when its height changes.
+func TestVerification(t *testing.T) {
+	db, err := transactionsdb.NewDB(test.TstTempFile("bitbox-wallet-db-"), testKey(1))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	txHash := msgTx.TxHash()
+	headerHash := chainhash.HashH([]byte("header"))
+	headerTimestamp := time.Unix(1500000000, 0)
+	requireUnverified := func() {
+		_, _, _, timestamp, err := dbTx.TxInfo(txHash)
+		require.NoError(t, err)
+		require.Nil(t, timestamp)
+		storedHeaderHash, err := dbTx.TxHeaderHash(txHash)
+		require.NoError(t, err)
+		require.Nil(t, storedHeaderHash)
+		unverified, err := dbTx.UnverifiedTransactions()
+		require.NoError(t, err)
+		require.Equal(t, []chainhash.Hash{txHash}, unverified)
+	}
+	verify := func() {
+		require.NoError(t, dbTx.MarkTxVerified(txHash, headerHash, headerTimestamp))
+		_, _, _, timestamp, err := dbTx.TxInfo(txHash)
+		require.NoError(t, err)
+		require.True(t, headerTimestamp.Equal(*timestamp))
+		storedHeaderHash, err := dbTx.TxHeaderHash(txHash)
+		require.NoError(t, err)
+		require.Equal(t, &headerHash, storedHeaderHash)
+		unverified, err := dbTx.UnverifiedTransactions()
+		require.NoError(t, err)
+		require.Empty(t, unverified)
+	}
+
+	require.NoError(t, dbTx.PutTx(txHash, msgTx, 10))
+	requireUnverified()
+	verify()
+	require.NoError(t, dbTx.MarkTxUnverified(txHash))
+	requireUnverified()
+
+	verify()
+	// Storing it again at the same height keeps it verified.
+	require.NoError(t, dbTx.PutTx(txHash, msgTx, 10))
+	storedHeaderHash, err := dbTx.TxHeaderHash(txHash)
+	require.NoError(t, err)
+	require.Equal(t, &headerHash, storedHeaderHash)
+	// It was reorganized into another block.
+	require.NoError(t, dbTx.PutTx(txHash, msgTx, 11))
+	requireUnverified()
+}
+
+// TestSpenders checks that all transactions spending an output are recorded once, in order.
+func TestSpenders(t *testing.T) {
+	db, err := transactionsdb.NewDB(test.TstTempFile("bitbox-wallet-db-"), testKey(1))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+
+	outPoint := wire.OutPoint{Hash: chainhash.HashH([]byte("tx")), Index: 1}
+	txA := chainhash.HashH([]byte("txA"))
+	txB := chainhash.HashH([]byte("txB"))
+	requireSpenders := func(expected ...chainhash.Hash) {
+		spenders, err := dbTx.Spenders(outPoint)
+		require.NoError(t, err)
+		require.Equal(t, expected, spenders)
+	}
+
+	requireSpenders()
+	added, err := dbTx.AddSpender(outPoint, txA)
+	require.NoError(t, err)
+	require.True(t, added)
+	added, err = dbTx.AddSpender(outPoint, txB)
+	require.NoError(t, err)
+	require.True(t, added)
+	added, err = dbTx.AddSpender(outPoint, txA)
+	require.NoError(t, err)
+	require.False(t, added)
+	requireSpenders(txA, txB)
+
+	require.NoError(t, dbTx.RemoveSpender(outPoint, txA))
+	requireSpenders(txB)
+	require.NoError(t, dbTx.RemoveSpender(outPoint, txB))
+	requireSpenders()
+}
+
+// TestMigrateHashedKeys opens a database of schema version 2, which has no index of the
+// transactions, and stores the txids, outpoints and script hashes in the clear. Its data is dropped.
+func TestMigrateHashedKeys(t *testing.T) {
+	filename := test.TstTempFile("bitbox-wallet-db-")
+	db, err := transactionsdb.NewDB(filename, testKey(1))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxOut(wire.NewTxOut(1000, secretScript))
+	txHash := msgTx.TxHash()
+	fixture, err := bbolt.Open(filename, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, fixture.Update(func(tx *bbolt.Tx) error {
+		for _, name := range []string{"txIndex", "txIndexKeys", "txIndexStale", "txIndexAddresses", "spenders"} {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket([]byte("transactions")).Put(txHash[:], []byte("{}")); err != nil {
+			return err
+		}
+		version := make([]byte, 8)
+		binary.BigEndian.PutUint64(version, 2)
+		return tx.Bucket([]byte("meta")).Put([]byte("version"), version)
+	}))
+	require.NoError(t, fixture.Close())
+
+	db, err = transactionsdb.NewDB(filename, testKey(1))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	require.Equal(t, &schema.Result{FromVersion: 2}, db.Migration())
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+	txHashes, err := dbTx.Transactions()
+	require.NoError(t, err)
+	require.Empty(t, txHashes)
+	stale, err := dbTx.StaleTxIndexEntries()
+	require.NoError(t, err)
+	require.Empty(t, stale)
+}
+
+// TestNoIDsInTheClear checks that the txids, outpoints and script hashes are not stored in the clear
+// in an encrypted database, neither as keys nor as values.
+func TestNoIDsInTheClear(t *testing.T) {
+	filename := test.TstTempFile("bitbox-wallet-db-")
+	db, err := transactionsdb.NewDB(filename, testKey(1))
+	require.NoError(t, err)
+	outPoint := writeTestData(t, db)
+	txHash := outPoint.Hash
+	scriptHashHex := blockchain.ScriptHashHex(chainhash.HashH(secretScript).String())
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, dbTx.AddAddressToTx(txHash, scriptHashHex))
+	require.NoError(t, dbTx.PutAddressHistory(scriptHashHex, blockchain.TxHistory{
+		{TXHash: blockchain.TXHash(txHash), Height: 10}}))
+	require.NoError(t, dbTx.MarkTxIndexStale(txHash))
+	require.NoError(t, dbTx.PutTxIndexEntry(&transactions.TxIndexEntry{
+		TxHash: txHash, Height: 10, Addresses: []string{"secret address"}}))
+	require.NoError(t, dbTx.Commit())
+
+	dbTx, err = db.Begin()
+	require.NoError(t, err)
+	txHashes, err := dbTx.Transactions()
+	require.NoError(t, err)
+	require.Equal(t, []chainhash.Hash{txHash}, txHashes)
+	history, err := dbTx.AddressHistory(scriptHashHex)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	dbTx.Rollback()
+	require.NoError(t, db.Close())
+
+	raw, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	for _, id := range [][]byte{
+		txHash[:],
+		[]byte(txHash.String()),
+		[]byte(hex.EncodeToString(txHash[:])),
+		[]byte(outPoint.String()),
+		[]byte(scriptHashHex),
+		secretScript,
+		[]byte("secret address"),
+	} {
+		require.False(t, bytes.Contains(raw, id), string(id))
+	}
+}
+
+func TestTxIndex(t *testing.T) {
+	db, err := transactionsdb.NewDB(test.TstTempFile("bitbox-wallet-db-"), testKey(1))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+
+	unconfirmed := chainhash.HashH([]byte("unconfirmed"))
+	confirmed5 := chainhash.HashH([]byte("confirmed 5"))
+	confirmed10 := chainhash.HashH([]byte("confirmed 10"))
+	for _, txHash := range []chainhash.Hash{unconfirmed, confirmed5, confirmed10} {
+		require.NoError(t, dbTx.MarkTxIndexStale(txHash))
+	}
+	require.NoError(t, dbTx.PutTxIndexEntry(&transactions.TxIndexEntry{TxHash: unconfirmed, Height: 0}))
+	require.NoError(t, dbTx.PutTxIndexEntry(&transactions.TxIndexEntry{TxHash: confirmed5, Height: 12}))
+	// Replaces the previous entry.
+	require.NoError(t, dbTx.PutTxIndexEntry(&transactions.TxIndexEntry{TxHash: confirmed5, Height: 5}))
+	stale, err := dbTx.StaleTxIndexEntries()
+	require.NoError(t, err)
+	require.Equal(t, []chainhash.Hash{confirmed10}, stale)
+	require.NoError(t, dbTx.PutTxIndexEntry(&transactions.TxIndexEntry{TxHash: confirmed10, Height: 10}))
+	stale, err = dbTx.StaleTxIndexEntries()
+	require.NoError(t, err)
+	require.Empty(t, stale)
+
+	// entries returns the tx hashes in the order of the index, and the cursor of each.
+	entries := func(descending bool, cursor []byte) ([]chainhash.Hash, [][]byte) {
+		txHashes := []chainhash.Hash{}
+		cursors := [][]byte{}
+		require.NoError(t, dbTx.ForEachTxIndexEntry(descending, cursor,
+			func(cursor []byte, entry *transactions.TxIndexEntry) bool {
+				txHashes = append(txHashes, entry.TxHash)
+				cursors = append(cursors, append([]byte{}, cursor...))
+				return true
+			}))
+		return txHashes, cursors
+	}
+	txHashes, cursors := entries(false, nil)
+	require.Equal(t, []chainhash.Hash{confirmed5, confirmed10, unconfirmed}, txHashes)
+	txHashes, _ = entries(false, cursors[0])
+	require.Equal(t, []chainhash.Hash{confirmed10, unconfirmed}, txHashes)
+	txHashes, _ = entries(true, nil)
+	require.Equal(t, []chainhash.Hash{unconfirmed, confirmed10, confirmed5}, txHashes)
+	txHashes, _ = entries(true, cursors[1])
+	require.Equal(t, []chainhash.Hash{confirmed5}, txHashes)
+	txHashes, _ = entries(true, cursors[2])
+	require.Equal(t, []chainhash.Hash{confirmed10, confirmed5}, txHashes)
+
+	require.NoError(t, dbTx.DeleteTxIndexEntry(confirmed10))
+	txHashes, _ = entries(false, nil)
+	require.Equal(t, []chainhash.Hash{confirmed5, unconfirmed}, txHashes)
+	// Continuing after a deleted entry.
+	txHashes, _ = entries(true, cursors[1])
+	require.Equal(t, []chainhash.Hash{confirmed5}, txHashes)
+	txHashes, _ = entries(false, cursors[1])
+	require.Equal(t, []chainhash.Hash{unconfirmed}, txHashes)
+
+	cursor, entry, err := dbTx.TxIndexEntry(confirmed5)
+	require.NoError(t, err)
+	require.Equal(t, cursors[0], cursor)
+	require.Equal(t, 5, entry.Height)
+	cursor, entry, err = dbTx.TxIndexEntry(confirmed10)
+	require.NoError(t, err)
+	require.Nil(t, cursor)
+	require.Nil(t, entry)
+}
+
+// TestTxIndexAddresses checks that the index entries can be looked up by address, and that the
+// lookup follows changes of the entries.
+func TestTxIndexAddresses(t *testing.T) {
+	db, err := transactionsdb.NewDB(test.TstTempFile("bitbox-wallet-db-"), testKey(1))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	dbTx, err := db.Begin()
+	require.NoError(t, err)
+	defer dbTx.Rollback()
+
+	tx1 := chainhash.HashH([]byte("tx1"))
+	tx2 := chainhash.HashH([]byte("tx2"))
+	requireAddress := func(address string, expected ...chainhash.Hash) {
+		expected = append([]chainhash.Hash{}, expected...)
+		txHashes, err := dbTx.TxIndexAddressTransactions(address)
+		require.NoError(t, err)
+		sort.Slice(txHashes, func(i, j int) bool {
+			return bytes.Compare(txHashes[i][:], txHashes[j][:]) < 0
+		})
+		sort.Slice(expected, func(i, j int) bool {
+			return bytes.Compare(expected[i][:], expected[j][:]) < 0
+		})
+		require.Equal(t, expected, txHashes)
+	}
+	require.NoError(t, dbTx.PutTxIndexEntry(&transactions.TxIndexEntry{
+		TxHash: tx1, Height: 10, Addresses: []string{"a", "ab"}}))
+	require.NoError(t, dbTx.PutTxIndexEntry(&transactions.TxIndexEntry{
+		TxHash: tx2, Height: 11, Addresses: []string{"a"}}))
+	requireAddress("a", tx1, tx2)
+	requireAddress("ab", tx1)
+	requireAddress("b")
+
+	// The entry is replaced.
+	require.NoError(t, dbTx.PutTxIndexEntry(&transactions.TxIndexEntry{
+		TxHash: tx1, Height: 10, Addresses: []string{"b"}}))
+	requireAddress("a", tx2)
+	requireAddress("ab")
+	requireAddress("b", tx1)
+
+	require.NoError(t, dbTx.DeleteTxIndexEntry(tx2))
+	requireAddress("a")
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transactionsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// txIndexKey is the key of an index entry. The entries are ordered by height, the unconfirmed
+// transactions last, and then by the key of the tx, see dbKey.
+func (tx *Tx) txIndexKey(height int, txHash chainhash.Hash) []byte {
+	sortHeight := uint64(height)
+	if height <= 0 {
+		sortHeight = math.MaxUint64
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sortHeight)
+	return append(key, tx.dbKey(txHash[:])...)
+}
+
+// txIndexAddressPrefix is the prefix of the keys of the entries of an address in the index by
+// address. It is followed by the key of the tx, see dbKey.
+func (tx *Tx) txIndexAddressPrefix(address string) []byte {
+	addressKey := tx.dbKey([]byte(address))
+	return append([]byte{byte(len(addressKey))}, addressKey...)
+}
+
+// MarkTxIndexStale implements transactions.DBTxInterface.
+func (tx *Tx) MarkTxIndexStale(txHash chainhash.Hash) error {
+	return tx.put(tx.bucketTxIndexStale, txHash[:], nil)
+}
+
+// StaleTxIndexEntries implements transactions.DBTxInterface.
+func (tx *Tx) StaleTxIndexEntries() ([]chainhash.Hash, error) {
+	return tx.getTransactions(tx.bucketTxIndexStale)
+}
+
+// PutTxIndexEntry implements transactions.DBTxInterface.
+func (tx *Tx) PutTxIndexEntry(entry *transactions.TxIndexEntry) error {
+	if err := tx.DeleteTxIndexEntry(entry.TxHash); err != nil {
+		return err
+	}
+	key := tx.txIndexKey(entry.Height, entry.TxHash)
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	if err := tx.putAt(tx.bucketTxIndex, key, entry.TxHash[:], jsonBytes); err != nil {
+		return err
+	}
+	for _, address := range entry.Addresses {
+		addressKey := append(tx.txIndexAddressPrefix(address), tx.dbKey(entry.TxHash[:])...)
+		if err := tx.putAt(tx.bucketTxIndexAddresses, addressKey, nil, entry.TxHash[:]); err != nil {
+			return err
+		}
+	}
+	return tx.put(tx.bucketTxIndexKeys, entry.TxHash[:], key)
+}
+
+// TxIndexEntry implements transactions.DBTxInterface.
+func (tx *Tx) TxIndexEntry(txHash chainhash.Hash) ([]byte, *transactions.TxIndexEntry, error) {
+	key, err := tx.get(tx.bucketTxIndexKeys, txHash[:])
+	if err != nil || key == nil {
+		return nil, nil, err
+	}
+	_, jsonBytes, err := tx.decrypt(key, tx.bucketTxIndex.Get(key))
+	if err != nil {
+		return nil, nil, err
+	}
+	if jsonBytes == nil {
+		return nil, nil, errp.New("missing index entry")
+	}
+	entry := &transactions.TxIndexEntry{}
+	if err := json.Unmarshal(jsonBytes, entry); err != nil {
+		return nil, nil, errp.WithStack(err)
+	}
+	return key, entry, nil
+}
+
+// TxIndexAddressTransactions implements transactions.DBTxInterface.
+func (tx *Tx) TxIndexAddressTransactions(address string) ([]chainhash.Hash, error) {
+	prefix := tx.txIndexAddressPrefix(address)
+	result := []chainhash.Hash{}
+	cursor := tx.bucketTxIndexAddresses.Cursor()
+	for key, value := cursor.Seek(prefix); bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		_, txHash, err := tx.decrypt(key, value)
+		if err != nil {
+			return nil, err
+		}
+		if len(txHash) != chainhash.HashSize {
+			return nil, errp.New("invalid index entry")
+		}
+		var hash chainhash.Hash
+		copy(hash[:], txHash)
+		result = append(result, hash)
+	}
+	return result, nil
+}
+
+// DeleteTxIndexEntry implements transactions.DBTxInterface.
+func (tx *Tx) DeleteTxIndexEntry(txHash chainhash.Hash) error {
+	key, entry, err := tx.TxIndexEntry(txHash)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		for _, address := range entry.Addresses {
+			addressKey := append(tx.txIndexAddressPrefix(address), tx.dbKey(txHash[:])...)
+			if err := tx.bucketTxIndexAddresses.Delete(addressKey); err != nil {
+				return errp.WithStack(err)
+			}
+		}
+		if err := tx.bucketTxIndex.Delete(key); err != nil {
+			return errp.WithStack(err)
+		}
+		if err := tx.delete(tx.bucketTxIndexKeys, txHash[:]); err != nil {
+			return err
+		}
+	}
+	return tx.delete(tx.bucketTxIndexStale, txHash[:])
+}
+
+// ForEachTxIndexEntry implements transactions.DBTxInterface.
+func (tx *Tx) ForEachTxIndexEntry(
+	descending bool,
+	cursor []byte,
+	f func(cursor []byte, entry *transactions.TxIndexEntry) bool,
+) error {
+	bucketCursor := tx.bucketTxIndex.Cursor()
+	next := bucketCursor.Next
+	if descending {
+		next = bucketCursor.Prev
+	}
+	var key, value []byte
+	switch {
+	case cursor == nil && !descending:
+		key, value = bucketCursor.First()
+	case cursor == nil:
+		key, value = bucketCursor.Last()
+	case !descending:
+		key, value = bucketCursor.Seek(cursor)
+		if bytes.Equal(key, cursor) {
+			key, value = bucketCursor.Next()
+		}
+	default:
+		// Seek positions at the first entry which is not before the cursor. The one before it comes
+		// next.
+		key, _ = bucketCursor.Seek(cursor)
+		if key == nil {
+			key, value = bucketCursor.Last()
+		} else {
+			key, value = bucketCursor.Prev()
+		}
+	}
+	for ; key != nil; key, value = next() {
+		_, jsonBytes, err := tx.decrypt(key, value)
+		if err != nil {
+			return err
+		}
+		entry := &transactions.TxIndexEntry{}
+		if err := json.Unmarshal(jsonBytes, entry); err != nil {
+			return errp.WithStack(err)
+		}
+		if !f(key, entry) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -44,6 +44,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 )
 
 var (
@@ -133,6 +134,8 @@ type Device struct {
 	// BitBox desktop app config directory.
 	// Used to read/store channel settings.
 	channelConfigDir string
+	// socksProxy is used to connect to the relay server.
+	socksProxy *socksproxy.SocksProxy
 
 	mu sync.RWMutex
 	// If set, the channel can be used to communicate to the mobile.
@@ -152,11 +155,13 @@ type Device struct {
 //
 // The channelConfigDir is the location of the channel settings file.
 // Callers can use util/config.AppDir to obtain user standard config dir.
+// The relay server for the communication with the mobile is connected to through socksProxy.
 func NewDevice(
 	deviceID string,
 	bootloader bool,
 	version *semver.SemVer,
 	channelConfigDir string,
+	socksProxy *socksproxy.SocksProxy,
 	communication CommunicationInterface) (*Device, error) {
 	log := logging.Get().WithGroup("device").WithField("deviceID", deviceID)
 	log.WithFields(logrus.Fields{"deviceID": deviceID, "version": version}).Info("Plugged in device")
@@ -171,8 +176,9 @@ func NewDevice(
 		version:          version,
 		communication:    communication,
 		closed:           false,
-		channel:          relay.NewChannelFromConfigFile(channelConfigDir),
+		channel:          relay.NewChannelFromConfigFile(channelConfigDir, socksProxy),
 		channelConfigDir: channelConfigDir,
+		socksProxy:       socksProxy,
 		log:              log,
 	}
 
@@ -1280,7 +1286,7 @@ func (dbb *Device) StartPairing() (*relay.Channel, error) {
 		dbb.fireEvent("pairingFalse", nil)
 	}
 
-	channel := relay.NewChannelWithRandomKey()
+	channel := relay.NewChannelWithRandomKey(dbb.socksProxy)
 	go dbb.processPairing(channel)
 	return channel, nil
 }
@@ -29,6 +29,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/mock"
@@ -68,7 +69,8 @@ func (s *dbbTestSuite) SetupTest() {
 		s.mockCommClosed = true
 	})
 	s.mockCommClosed = false
-	dbb, err := NewDevice(deviceID, false /* bootloader */, firmVer400, s.configDir, s.mockCommunication)
+	dbb, err := NewDevice(deviceID, false /* bootloader */, firmVer400, s.configDir,
+		socksproxy.NewSocksProxy(socksproxy.Config{}), s.mockCommunication)
 	dbb.Init(true)
 	require.NoError(s.T(), err)
 	s.dbb = dbb
@@ -387,7 +389,7 @@ func (s *dbbTestSuite) TestDeviceStatusEvent() {
 func TestNewDeviceReadsChannel(t *testing.T) {
 	configDir := test.TstTempDir("dbb_device_test")
 	defer func() { _ = os.RemoveAll(configDir) }()
-	mobchan := relay.NewChannelWithRandomKey()
+	mobchan := relay.NewChannelWithRandomKey(socksproxy.NewSocksProxy(socksproxy.Config{}))
 	if err := mobchan.StoreToConfigFile(configDir); err != nil {
 		t.Fatal(err)
 	}
@@ -397,7 +399,8 @@ func TestNewDeviceReadsChannel(t *testing.T) {
 	comm.On("SendPlain", jsonArgumentMatcher(map[string]interface{}{"ping": ""})).
 		Return(map[string]interface{}{"ping": ""}, nil)
 	comm.On("Close")
-	dbb, err := NewDevice("test-device-id", false /* bootloader */, firmVer400, configDir, comm)
+	dbb, err := NewDevice("test-device-id", false /* bootloader */, firmVer400, configDir,
+		socksproxy.NewSocksProxy(socksproxy.Config{}), comm)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -141,20 +141,40 @@ func (keystore *keystore) signETHTransaction(txProposal *eth.TxProposal) error {
 	if len(signatures) != 1 {
 		panic("expecting one signature")
 	}
-	signature := signatures[0]
 	// We serialize the sig (including the recid at the last byte) so we can use WithSignature()
 	// without modifications, even though it deserializes it again immediately. We do this because
 	// it also modifies the `V` value according to EIP155.
+	signedTx, err := txProposal.Tx.WithSignature(txProposal.Signer, serializeRecoverable(signatures[0]))
+	if err != nil {
+		return err
+	}
+	txProposal.Tx = signedTx
+	return nil
+}
+
+// serializeRecoverable returns the signature as [R || S || recID].
+func serializeRecoverable(signature SignatureWithRecID) []byte {
 	sig := make([]byte, 65)
 	copy(sig[:32], math.PaddedBigBytes(signature.R, 32))
 	copy(sig[32:64], math.PaddedBigBytes(signature.S, 32))
 	sig[64] = byte(signature.RecID)
-	signedTx, err := txProposal.Tx.WithSignature(txProposal.Signer, sig)
+	return sig
+}
+
+// SignHash implements keystore.Keystore.
+func (keystore *keystore) SignHash(hash []byte, keyPath signing.AbsoluteKeypath) ([]byte, error) {
+	if len(hash) != 32 {
+		return nil, errp.New("The hash to sign must be 32 bytes long.")
+	}
+	keystore.log.Info("Sign hash")
+	signatures, err := keystore.dbb.Sign(nil, [][]byte{hash}, []string{keyPath.Encode()})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	txProposal.Tx = signedTx
-	return nil
+	if len(signatures) != 1 {
+		panic("expecting one signature")
+	}
+	return serializeRecoverable(signatures[0]), nil
 }
 
 // SignTransaction implements keystore.Keystore.
@@ -25,6 +25,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/relay"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -57,7 +58,8 @@ func TestChannel(t *testing.T) {
 		panic("Cannot decode the testing authentication key!")
 	}
 
-	channel := relay.NewChannel(channelID, encryptionKey, authenticationKey)
+	channel := relay.NewChannel(channelID, encryptionKey, authenticationKey,
+		socksproxy.NewSocksProxy(socksproxy.Config{}))
 
 	assert.NoError(t, channel.SendPing())
 	assert.NoError(t, channel.WaitForPong(40*time.Second))
@@ -92,7 +94,7 @@ func TestFinishPairing(t *testing.T) {
 			dbb.onEvent = func(e device.Event, data interface{}) {
 				event = e
 			}
-			newChan := relay.NewChannelWithRandomKey()
+			newChan := relay.NewChannelWithRandomKey(socksproxy.NewSocksProxy(socksproxy.Config{}))
 			dbb.finishPairing(newChan)
 			if event != test.wantEvent {
 				t.Errorf("event = %q; want %q", event, test.wantEvent)
@@ -104,7 +106,7 @@ func TestFinishPairing(t *testing.T) {
 			if !test.wantPaired {
 				return
 			}
-			storedChan := relay.NewChannelFromConfigFile(test.configDir)
+			storedChan := relay.NewChannelFromConfigFile(test.configDir, nil)
 			if storedChan == nil {
 				t.Fatalf("relay.NewChannelFromConfigFile(%q) returned nil", test.configDir)
 			}
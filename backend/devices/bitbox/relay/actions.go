@@ -20,6 +20,7 @@ import (
 
 	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 )
 
 // PushMessage pushes the encryption of the given data as JSON to the given server.
@@ -44,6 +45,8 @@ func PushMessage(server Server, channel *Channel, data interface{}) error {
 		sender:  Desktop,
 		channel: channel,
 		content: &content,
+
+		socksProxy: channel.socksProxy,
 	}
 
 	response, err := request.send()
@@ -66,6 +69,8 @@ func PullOldestMessage(server Server, channel *Channel) ([]byte, error) {
 		command: PullOldestMessageCommand,
 		sender:  Desktop,
 		channel: channel,
+
+		socksProxy: channel.socksProxy,
 	}
 
 	response, err := request.send()
@@ -86,11 +91,13 @@ func PullOldestMessage(server Server, channel *Channel) ([]byte, error) {
 }
 
 // DeleteAllMessages deletes all messages in all channels which expired on the given server.
-func DeleteAllMessages(server Server) error {
+func DeleteAllMessages(server Server, socksProxy *socksproxy.SocksProxy) error {
 	request := &request{
 		server:  server,
 		command: DeleteAllMessagesCommand,
 		sender:  Desktop,
+
+		socksProxy: socksProxy,
 	}
 	_, err := request.send()
 	return err
@@ -23,6 +23,7 @@ import (
 	"github.com/btcsuite/btcutil/base58"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/random"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 	"github.com/sirupsen/logrus"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/util/config"
@@ -51,39 +52,49 @@ type Channel struct {
 	// messageBufferLock guards the message buffer.
 	messageBufferLock locker.Locker
 
+	// socksProxy is used to connect to the relay server.
+	socksProxy *socksproxy.SocksProxy
+
 	log *logrus.Entry
 }
 
 // NewChannel returns a new channel with the given channel ID, encryption and authentication key.
-func NewChannel(channelID string, encryptionKey []byte, authenticationKey []byte) *Channel {
+// The relay server is connected to through the given proxy.
+func NewChannel(
+	channelID string,
+	encryptionKey []byte,
+	authenticationKey []byte,
+	socksProxy *socksproxy.SocksProxy,
+) *Channel {
 	return &Channel{
 		ChannelID:         channelID,
 		EncryptionKey:     encryptionKey,
 		AuthenticationKey: authenticationKey,
+		socksProxy:        socksProxy,
 		log:               logging.Get().WithGroup("channel"),
 	}
 }
 
 // NewChannelWithRandomKey returns a new channel with a random encryption key and identifier.
-func NewChannelWithRandomKey() *Channel {
+func NewChannelWithRandomKey(socksProxy *socksproxy.SocksProxy) *Channel {
 	channelID := random.BytesOrPanic(32)
 	encryptionKey := random.BytesOrPanic(32)
 	authenticationKey := random.BytesOrPanic(32)
 
 	// The channel identifier may not contain '=' and thus it cannot be encoded with base64.
-	return NewChannel(base58.Encode(channelID), encryptionKey, authenticationKey)
+	return NewChannel(base58.Encode(channelID), encryptionKey, authenticationKey, socksProxy)
 }
 
 // NewChannelFromConfigFile returns a new channel with the channel identifier and encryption key
 // from the config file or nil if the config file does not exist.
-func NewChannelFromConfigFile(configDir string) *Channel {
+func NewChannelFromConfigFile(configDir string, socksProxy *socksproxy.SocksProxy) *Channel {
 	configFile := config.NewFile(configDir, configFileName)
 	if configFile.Exists() {
 		var configuration configuration
 		if err := configFile.ReadJSON(&configuration); err != nil {
 			return nil
 		}
-		return configuration.channel()
+		return configuration.channel(socksProxy)
 	}
 	return nil
 }
@@ -14,6 +14,8 @@
 
 package relay
 
+import "github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+
 type configuration struct {
 	ChannelID         string `json:"channel"`
 	EncryptionKey     []byte `json:"encryption"`
@@ -28,6 +30,6 @@ func newConfiguration(channel *Channel) *configuration {
 	}
 }
 
-func (config *configuration) channel() *Channel {
-	return NewChannel(config.ChannelID, config.EncryptionKey, config.AuthenticationKey)
+func (config *configuration) channel(socksProxy *socksproxy.SocksProxy) *Channel {
+	return NewChannel(config.ChannelID, config.EncryptionKey, config.AuthenticationKey, socksProxy)
 }
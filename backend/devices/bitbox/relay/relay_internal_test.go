@@ -21,14 +21,17 @@ import (
 	"time"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 	"github.com/stretchr/testify/assert"
 )
 
 const online = false
 
+var directProxy = socksproxy.NewSocksProxy(socksproxy.Config{})
+
 func TestDeleteAllMessages(t *testing.T) {
 	if online {
-		assert.NoError(t, DeleteAllMessages(relayServer()))
+		assert.NoError(t, DeleteAllMessages(relayServer(), directProxy))
 	}
 }
 
@@ -50,6 +53,8 @@ func sendPongAsMobile(channel *Channel) error {
 		sender:  Mobile,
 		channel: channel,
 		content: &content,
+
+		socksProxy: directProxy,
 	}
 
 	response, err := request.send()
@@ -62,7 +67,7 @@ func sendPongAsMobile(channel *Channel) error {
 
 func TestPingPong(t *testing.T) {
 	if online {
-		channel := NewChannelWithRandomKey()
+		channel := NewChannelWithRandomKey(directProxy)
 		assert.NoError(t, channel.SendPing())
 		assert.NoError(t, sendPongAsMobile(channel))
 		assert.NoError(t, channel.WaitForPong(2*time.Second))
@@ -18,10 +18,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
-	"net/http"
 	"strings"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 )
 
+// requestTimeout is the maximum duration of a request. Pulling a message waits up to ten seconds
+// for the message.
+const requestTimeout = time.Minute
+
 // request models a request to the relay server.
 type request struct {
 	// The relay server to which the request is sent.
@@ -39,6 +45,9 @@ type request struct {
 	// The encrypted content which is sent to the other communication party.
 	// This field may not be nil if the command is 'pushMessageCommand'.
 	content *string
+
+	// The proxy through which the relay server is connected to.
+	socksProxy *socksproxy.SocksProxy
 }
 
 // encode encodes the request to be transmitted to the relay server.
@@ -67,7 +76,7 @@ func (request *request) encode() string {
 
 // send sends the request to the relay server and returns its response.
 func (request *request) send() (*response, error) {
-	httpResponse, err := http.Post(
+	httpResponse, err := request.socksProxy.HTTPClient(requestTimeout).Post(
 		string(request.server),
 		"application/x-www-form-urlencoded",
 		strings.NewReader(request.encode()),
@@ -29,6 +29,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 )
 
 const (
@@ -57,6 +58,7 @@ func DeviceInfos() []hid.DeviceInfo {
 type Manager struct {
 	devices          map[string]device.Interface
 	channelConfigDir string // passed to each device during initialization
+	socksProxy       *socksproxy.SocksProxy
 
 	onRegister   func(device.Interface) error
 	onUnregister func(string)
@@ -67,16 +69,18 @@ type Manager struct {
 // NewManager creates a new Manager. onRegister is called when a device has been
 // inserted. onUnregister is called when the device has been removed.
 //
-// The channelConfigDir argument is passed to each device during initialization,
+// The channelConfigDir and socksProxy arguments are passed to each device during initialization,
 // before onRegister is called.
 func NewManager(
 	channelConfigDir string,
+	socksProxy *socksproxy.SocksProxy,
 	onRegister func(device.Interface) error,
 	onUnregister func(string),
 ) *Manager {
 	return &Manager{
 		devices:          map[string]device.Interface{},
 		channelConfigDir: channelConfigDir,
+		socksProxy:       socksProxy,
 		onRegister:       onRegister,
 		onUnregister:     onUnregister,
 		log:              logging.Get().WithGroup("manager"),
@@ -126,6 +130,7 @@ func (manager *Manager) register(deviceInfo hid.DeviceInfo) error {
 		bootloader,
 		firmwareVersion,
 		manager.channelConfigDir,
+		manager.socksProxy,
 		NewCommunication(hidDevice, usbWriteReportSize, usbReadReportSize),
 	)
 	if err != nil {
@@ -15,13 +15,16 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"runtime/debug"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -30,19 +33,24 @@ import (
 	"golang.org/x/text/language"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/addressbook"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
 	accountHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/handlers"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/registry"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/costbasis"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
 	bitboxHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/handlers"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/invoices"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/system"
 )
 
@@ -54,6 +62,7 @@ type Backend interface {
 	AccountsStatus() string
 	Testing() bool
 	Accounts() []btc.Interface
+	AddETHAccount(coinCode string) error
 	UserLanguage() language.Tag
 	OnAccountInit(f func(btc.Interface))
 	OnAccountUninit(f func(btc.Interface))
@@ -67,8 +76,17 @@ type Backend interface {
 	Register(device device.Interface) error
 	Deregister(deviceID string)
 	Rates() map[string]map[string]float64
+	HistoricalRate(coinCode string, fiat string, t time.Time) (float64, error)
+	Portfolio(fiat string) *backend.Portfolio
+	CostBasisReport(method costbasis.Method, fiat string) (*costbasis.Report, bool, []string, error)
+	AddressBook() (*addressbook.AddressBook, error)
+	ContactRecipient(id string, coinCode string, used func(address string) bool) (string, bool, error)
+	Invoices() (*invoices.Store, error)
+	CreateInvoice(accountCode string, amount string, memo string, expiry time.Duration) (*invoices.Invoice, error)
 	DownloadCert(string) (string, error)
 	CheckElectrumServer(string, string) error
+	ApproveCertificate(coinCode, server, fingerprint string) error
+	SocksProxy() *socksproxy.SocksProxy
 }
 
 // Handlers provides a web api to the backend.
@@ -143,17 +161,33 @@ func NewHandlers(
 	getAPIRouter(apiRouter)("/testing", handlers.getTestingHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/accounts", handlers.getAccountsHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/accounts-status", handlers.getAccountsStatusHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/portfolio", handlers.getPortfolioHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/portfolio/balance-history", handlers.getPortfolioBalanceHistoryHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/reports/cost-basis", handlers.getCostBasisReportHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/contacts", handlers.getContactsHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/contacts/add", handlers.postContactAddHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/contacts/update", handlers.postContactUpdateHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/contacts/delete", handlers.postContactDeleteHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/invoices", handlers.getInvoicesHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/invoices/create", handlers.postInvoiceCreateHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/test/register", handlers.registerTestKeyStoreHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/test/deregister", handlers.deregisterTestKeyStoreHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/coins/rates", handlers.getRatesHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/convertToFiat", handlers.getConvertToFiatHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/convertFromFiat", handlers.getConvertFromFiatHandler).Methods("GET")
-	getAPIRouter(apiRouter)("/coins/tltc/headers/status", handlers.getHeadersStatus("tltc")).Methods("GET")
-	getAPIRouter(apiRouter)("/coins/tbtc/headers/status", handlers.getHeadersStatus("tbtc")).Methods("GET")
-	getAPIRouter(apiRouter)("/coins/ltc/headers/status", handlers.getHeadersStatus("ltc")).Methods("GET")
-	getAPIRouter(apiRouter)("/coins/btc/headers/status", handlers.getHeadersStatus("btc")).Methods("GET")
+	for _, definition := range registry.Definitions() {
+		coinRouter := getAPIRouter(apiRouter.PathPrefix(fmt.Sprintf("/coins/%s", definition.Code)).Subrouter())
+		switch definition.Type {
+		case registry.TypeUTXO:
+			coinRouter("/headers/status", handlers.getHeadersStatus(definition.Code)).Methods("GET")
+			coinRouter("/servers", handlers.getServersHandler(definition.Code)).Methods("GET")
+		case registry.TypeETH:
+			coinRouter("/accounts", handlers.postETHAccountHandler(definition.Code)).Methods("POST")
+		}
+	}
 	getAPIRouter(apiRouter)("/certs/download", handlers.postCertsDownloadHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/certs/check", handlers.postCertsCheckHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/certs/approve", handlers.postCertsApproveHandler).Methods("POST")
 
 	devicesRouter := getAPIRouter(apiRouter.PathPrefix("/devices").Subrouter())
 	devicesRouter("/registered", handlers.getDevicesRegisteredHandler).Methods("GET")
@@ -166,7 +200,7 @@ func NewHandlers(
 		if _, ok := accountHandlersMap[accountCode]; !ok {
 			accountHandlersMap[accountCode] = accountHandlers.NewHandlers(getAPIRouter(
 				apiRouter.PathPrefix(fmt.Sprintf("/account/%s", accountCode)).Subrouter(),
-			), log)
+			), backend.HistoricalRate, backend.ContactRecipient, log)
 		}
 		accHandlers := accountHandlersMap[accountCode]
 		log.WithField("account-handlers", accHandlers).Debug("Account handlers")
@@ -211,19 +245,23 @@ func writeJSON(w io.Writer, value interface{}) {
 	}
 }
 
-func (handlers *Handlers) getQRCodeHandler(r *http.Request) (interface{}, error) {
-	data := r.URL.Query().Get("data")
+// qrCodeDataURI encodes the data as a QR code image, returned as a data URI.
+func qrCodeDataURI(data string) (string, error) {
 	qr, err := qrcode.New(data, qrcode.Medium)
 	if err != nil {
-		return nil, errp.WithStack(err)
+		return "", errp.WithStack(err)
 	}
 	bytes, err := qr.PNG(256)
 	if err != nil {
-		return nil, errp.WithStack(err)
+		return "", errp.WithStack(err)
 	}
 	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(bytes), nil
 }
 
+func (handlers *Handlers) getQRCodeHandler(r *http.Request) (interface{}, error) {
+	return qrCodeDataURI(r.URL.Query().Get("data"))
+}
+
 func (handlers *Handlers) getConfigHandler(_ *http.Request) (interface{}, error) {
 	return handlers.backend.Config().Config(), nil
 }
@@ -249,7 +287,7 @@ func (handlers *Handlers) postOpenHandler(r *http.Request) (interface{}, error)
 }
 
 func (handlers *Handlers) getUpdateHandler(_ *http.Request) (interface{}, error) {
-	return backend.CheckForUpdateIgnoringErrors(), nil
+	return backend.CheckForUpdateIgnoringErrors(handlers.backend.SocksProxy()), nil
 }
 
 func (handlers *Handlers) getVersionHandler(_ *http.Request) (interface{}, error) {
@@ -283,6 +321,328 @@ func (handlers *Handlers) getAccountsStatusHandler(_ *http.Request) (interface{}
 	return handlers.backend.AccountsStatus(), nil
 }
 
+// getPortfolioHandler sums up the balances of all accounts, by coin and in fiat. The fiat currency
+// is given by the fiat query parameter, by default the one chosen by the user.
+func (handlers *Handlers) getPortfolioHandler(r *http.Request) (interface{}, error) {
+	return handlers.backend.Portfolio(r.URL.Query().Get("fiat")), nil
+}
+
+// getPortfolioBalanceHistoryHandler sums up the balance histories of all accounts in fiat. Accounts
+// which are not synced yet, have no balance history or whose coin has no exchange rates are left
+// out, in which case the result is flagged as incomplete.
+func (handlers *Handlers) getPortfolioBalanceHistoryHandler(r *http.Request) (interface{}, error) {
+	interval, fiat, err := accountHandlers.BalanceHistoryQuery(r)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	now := time.Now()
+	incomplete := false
+	sums := map[time.Time]float64{}
+	// accountValues values the balance history of an account. It fails if the account has no
+	// history, or if a rate is missing.
+	accountValues := func(account btc.Interface) (map[time.Time]float64, error) {
+		history, err := account.BalanceHistory()
+		if err != nil {
+			return nil, err
+		}
+		values := map[time.Time]float64{}
+		for _, point := range coin.BucketBalances(history, interval, now) {
+			value, err := accountHandlers.BalanceFiatValue(
+				account.Coin(), point, interval, now, fiat, handlers.backend.HistoricalRate)
+			if err != nil {
+				return nil, err
+			}
+			values[point.Time] = value
+		}
+		return values, nil
+	}
+	for _, account := range handlers.backend.Accounts() {
+		if !account.InitialSyncDone() {
+			incomplete = true
+			continue
+		}
+		values, err := accountValues(account)
+		if err != nil {
+			handlers.log.WithField("account", account.Code()).WithError(err).
+				Debug("Leaving the account out of the portfolio balance history")
+			incomplete = true
+			continue
+		}
+		for t, value := range values {
+			sums[t] += value
+		}
+	}
+	times := make([]time.Time, 0, len(sums))
+	for t := range sums {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	type pointJSON struct {
+		Time      string `json:"time"`
+		FiatValue string `json:"fiatValue"`
+	}
+	points := []pointJSON{}
+	for _, t := range times {
+		points = append(points, pointJSON{
+			Time:      t.Format(time.RFC3339),
+			FiatValue: strconv.FormatFloat(sums[t], 'f', 2, 64),
+		})
+	}
+	return map[string]interface{}{
+		"success":    true,
+		"interval":   interval,
+		"fiat":       fiat,
+		"incomplete": incomplete,
+		"points":     points,
+	}, nil
+}
+
+// getCostBasisReportHandler computes the capital gains of all accounts. The query parameters are
+// method (fifo, lifo or average, default fifo) and fiat (default USD). The lots and the yearly
+// summary are returned as CSV too.
+func (handlers *Handlers) getCostBasisReportHandler(r *http.Request) (interface{}, error) {
+	methodString := r.URL.Query().Get("method")
+	if methodString == "" {
+		methodString = string(costbasis.MethodFIFO)
+	}
+	fiat := r.URL.Query().Get("fiat")
+	if fiat == "" {
+		fiat = "USD"
+	}
+	method, err := costbasis.NewMethod(methodString)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	report, incomplete, unsupported, err := handlers.backend.CostBasisReport(method, fiat)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	return costBasisReportJSON(report, fiat, incomplete, unsupported)
+}
+
+func (handlers *Handlers) getContactsHandler(_ *http.Request) (interface{}, error) {
+	addressBook, err := handlers.backend.AddressBook()
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	return map[string]interface{}{
+		"success":  true,
+		"contacts": addressBook.Contacts(),
+	}, nil
+}
+
+// postContactAddHandler adds a contact. The addresses are validated against their coins. The id of
+// the new contact is returned.
+func (handlers *Handlers) postContactAddHandler(r *http.Request) (interface{}, error) {
+	var contact addressbook.Contact
+	if err := json.NewDecoder(r.Body).Decode(&contact); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	addressBook, err := handlers.backend.AddressBook()
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	id, err := addressBook.Add(&contact)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	return map[string]interface{}{
+		"success": true,
+		"id":      id,
+	}, nil
+}
+
+// postContactUpdateHandler replaces the contact with the id given in the body.
+func (handlers *Handlers) postContactUpdateHandler(r *http.Request) (interface{}, error) {
+	var contact addressbook.Contact
+	if err := json.NewDecoder(r.Body).Decode(&contact); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	addressBook, err := handlers.backend.AddressBook()
+	if err == nil {
+		err = addressBook.Update(&contact)
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postContactDeleteHandler(r *http.Request) (interface{}, error) {
+	var input struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	addressBook, err := handlers.backend.AddressBook()
+	if err == nil {
+		err = addressBook.Delete(input.ID)
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+// invoiceJSON is the info returned per invoice by the /invoices endpoints.
+type invoiceJSON struct {
+	ID          string          `json:"id"`
+	AccountCode string          `json:"accountCode"`
+	Address     string          `json:"address"`
+	Amount      string          `json:"amount"`
+	Received    string          `json:"received"`
+	Unit        string          `json:"unit"`
+	Memo        string          `json:"memo"`
+	Created     string          `json:"created"`
+	Expires     string          `json:"expires"`
+	Status      invoices.Status `json:"status"`
+	// URI is the BIP21 URI and QR the QR code of it as a data URI.
+	URI string `json:"uri"`
+	QR  string `json:"qr"`
+}
+
+func newInvoiceJSON(invoice *invoices.Invoice) (*invoiceJSON, error) {
+	uri := invoice.URI()
+	qr, err := qrCodeDataURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &invoiceJSON{
+		ID:          invoice.ID,
+		AccountCode: invoice.AccountCode,
+		Address:     invoice.Address,
+		Amount:      invoices.FormatAmount(invoice.Amount),
+		Received:    invoices.FormatAmount(invoice.Received),
+		Unit:        invoice.Unit,
+		Memo:        invoice.Memo,
+		Created:     invoice.Created.Format(time.RFC3339),
+		Expires:     invoice.Expires.Format(time.RFC3339),
+		Status:      invoice.Status,
+		URI:         uri,
+		QR:          qr,
+	}, nil
+}
+
+// getInvoicesHandler returns all invoices, optionally only the ones of the account given by the
+// accountCode query parameter.
+func (handlers *Handlers) getInvoicesHandler(r *http.Request) (interface{}, error) {
+	store, err := handlers.backend.Invoices()
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	accountCode := r.URL.Query().Get("accountCode")
+	result := []*invoiceJSON{}
+	for _, invoice := range store.Invoices() {
+		if accountCode != "" && invoice.AccountCode != accountCode {
+			continue
+		}
+		jsonInvoice, err := newInvoiceJSON(invoice)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, jsonInvoice)
+	}
+	return map[string]interface{}{
+		"success":  true,
+		"invoices": result,
+	}, nil
+}
+
+// postInvoiceCreateHandler creates an invoice. The body contains the accountCode, the amount in the
+// unit of the coin, an optional memo and expiresIn, the validity in seconds.
+func (handlers *Handlers) postInvoiceCreateHandler(r *http.Request) (interface{}, error) {
+	var input struct {
+		AccountCode string `json:"accountCode"`
+		Amount      string `json:"amount"`
+		Memo        string `json:"memo"`
+		ExpiresIn   int64  `json:"expiresIn"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	invoice, err := handlers.backend.CreateInvoice(
+		input.AccountCode, input.Amount, input.Memo, time.Duration(input.ExpiresIn)*time.Second)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	jsonInvoice, err := newInvoiceJSON(invoice)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"success": true,
+		"invoice": jsonInvoice,
+	}, nil
+}
+
+func costBasisReportJSON(
+	report *costbasis.Report, fiat string, incomplete bool, unsupported []string) (interface{}, error) {
+	type yearJSON struct {
+		Year      int    `json:"year"`
+		Proceeds  string `json:"proceeds"`
+		CostBasis string `json:"costBasis"`
+		Gain      string `json:"gain"`
+	}
+	years := []yearJSON{}
+	for _, year := range report.Years {
+		years = append(years, yearJSON{
+			Year:      year.Year,
+			Proceeds:  costbasis.FormatFiat(year.Proceeds),
+			CostBasis: costbasis.FormatFiat(year.CostBasis),
+			Gain:      costbasis.FormatFiat(year.Gain),
+		})
+	}
+	var lotsCSV, yearsCSV bytes.Buffer
+	if err := report.WriteLotsCSV(&lotsCSV); err != nil {
+		return nil, err
+	}
+	if err := report.WriteYearsCSV(&yearsCSV); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"success":     true,
+		"method":      report.Method,
+		"fiat":        fiat,
+		"incomplete":  incomplete,
+		"unsupported": unsupported,
+		"unmatched":   report.Unmatched,
+		"years":       years,
+		"lotsCSV":     lotsCSV.String(),
+		"yearsCSV":    yearsCSV.String(),
+	}, nil
+}
+
 func (handlers *Handlers) getDevicesRegisteredHandler(_ *http.Request) (interface{}, error) {
 	return handlers.backend.DevicesRegistered(), nil
 }
@@ -354,6 +714,18 @@ func (handlers *Handlers) getHeadersStatus(coinCode string) func(*http.Request)
 	}
 }
 
+func (handlers *Handlers) getServersHandler(coinCode string) func(*http.Request) (interface{}, error) {
+	return func(_ *http.Request) (interface{}, error) {
+		return handlers.backend.Coin(coinCode).(*btc.Coin).Servers(), nil
+	}
+}
+
+func (handlers *Handlers) postETHAccountHandler(coinCode string) func(*http.Request) (interface{}, error) {
+	return func(_ *http.Request) (interface{}, error) {
+		return nil, handlers.backend.AddETHAccount(coinCode)
+	}
+}
+
 func (handlers *Handlers) postCertsDownloadHandler(r *http.Request) (interface{}, error) {
 	var server string
 	if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
@@ -394,6 +766,27 @@ func (handlers *Handlers) postCertsCheckHandler(r *http.Request) (interface{}, e
 	}, nil
 }
 
+func (handlers *Handlers) postCertsApproveHandler(r *http.Request) (interface{}, error) {
+	var certificate struct {
+		CoinCode    string `json:"coinCode"`
+		Server      string `json:"server"`
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&certificate); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.ApproveCertificate(
+		certificate.CoinCode, certificate.Server, certificate.Fingerprint); err != nil {
+		return map[string]interface{}{
+			"success":      false,
+			"errorMessage": err.Error(),
+		}, nil
+	}
+	return map[string]interface{}{
+		"success": true,
+	}, nil
+}
+
 func (handlers *Handlers) eventsHandler(w http.ResponseWriter, r *http.Request) {
 	conn, err := handlers.websocketUpgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -0,0 +1,159 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/registry"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/invoices"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// eventInvoice is the Data of the AccountEvent fired when the status of an invoice changed.
+const eventInvoice = "invoice"
+
+// Invoices returns the invoices. An error is returned if the stored invoices could not be loaded.
+func (backend *Backend) Invoices() (*invoices.Store, error) {
+	if backend.invoicesErr != nil {
+		return nil, backend.invoicesErr
+	}
+	return backend.invoices, nil
+}
+
+// CreateInvoice reserves a fresh receive address of the account with the given code and stores an
+// invoice requesting the given amount, in the unit of the coin, to it. The invoice expires after
+// the given duration.
+func (backend *Backend) CreateInvoice(
+	accountCode string, amount string, memo string, expiry time.Duration) (*invoices.Invoice, error) {
+	store, err := backend.Invoices()
+	if err != nil {
+		return nil, err
+	}
+	var found btc.Interface
+	for _, candidate := range backend.Accounts() {
+		if candidate.Code() == accountCode {
+			found = candidate
+		}
+	}
+	if found == nil {
+		return nil, errp.Newf("unknown account %s", accountCode)
+	}
+	account, ok := found.(*btc.Account)
+	if !ok {
+		return nil, errp.New("invoices are not supported for this account")
+	}
+	if !account.InitialSyncDone() {
+		return nil, errp.New("the account is not synced yet")
+	}
+	definition, ok := registry.Get(account.Coin().Code())
+	if !ok || definition.URIScheme == "" {
+		return nil, errp.New("invoices are not supported for this coin")
+	}
+	parsedAmount, err := account.Coin().ParseAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	if parsedAmount.BigInt().Sign() <= 0 || !parsedAmount.BigInt().IsInt64() {
+		return nil, errp.New("invalid amount")
+	}
+	if expiry <= 0 {
+		return nil, errp.New("the expiry must be positive")
+	}
+	address, err := account.ReserveReceiveAddress()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	invoice := &invoices.Invoice{
+		AccountCode:   accountCode,
+		URIScheme:     definition.URIScheme,
+		Unit:          account.Coin().Unit(),
+		Address:       address.EncodeAddress(),
+		ScriptHashHex: address.PubkeyScriptHashHex(),
+		Amount:        btcutil.Amount(parsedAmount.BigInt().Int64()),
+		Memo:          memo,
+		Created:       now,
+		Expires:       now.Add(expiry),
+	}
+	if err := store.Add(invoice, now); err != nil {
+		return nil, err
+	}
+	backend.scheduleInvoicesUpdate(invoice.Expires)
+	return invoice, nil
+}
+
+// releasesAddress returns whether the invoice expired without receiving anything. Its address is
+// then not reserved anymore, so that unpaid invoices do not push the receive addresses beyond the
+// gap limit, and later payments to the address are not attributed to the invoice.
+func releasesAddress(invoice *invoices.Invoice) bool {
+	return invoice.Status == invoices.StatusExpired && invoice.Received == 0
+}
+
+// reservedAddresses returns the addresses of the invoices of the account with the given code.
+func (backend *Backend) reservedAddresses(accountCode string) []blockchain.ScriptHashHex {
+	if backend.invoices == nil {
+		return nil
+	}
+	scriptHashHexes := []blockchain.ScriptHashHex{}
+	for _, invoice := range backend.invoices.Invoices() {
+		if invoice.AccountCode == accountCode && !releasesAddress(invoice) {
+			scriptHashHexes = append(scriptHashHexes, invoice.ScriptHashHex)
+		}
+	}
+	return scriptHashHexes
+}
+
+// scheduleInvoicesUpdate updates the invoices at the given time, so that invoices expire in time.
+func (backend *Backend) scheduleInvoicesUpdate(at time.Time) {
+	time.AfterFunc(time.Until(at), backend.updateInvoices)
+}
+
+// updateInvoices updates the amounts received by the invoices of the synced accounts and notifies
+// the frontend about each invoice whose status changed. The addresses of the invoices which expired
+// unpaid are released.
+func (backend *Backend) updateInvoices() {
+	if backend.invoices == nil {
+		return
+	}
+	accounts := map[string]*btc.Account{}
+	for _, account := range backend.Accounts() {
+		if btcAccount, ok := account.(*btc.Account); ok {
+			accounts[account.Code()] = btcAccount
+		}
+	}
+	changed, err := backend.invoices.Update(func(invoice *invoices.Invoice) (btcutil.Amount, bool) {
+		account, ok := accounts[invoice.AccountCode]
+		if !ok || !account.InitialSyncDone() || releasesAddress(invoice) {
+			return 0, false
+		}
+		confirmed, unconfirmed := account.Received(invoice.ScriptHashHex)
+		return confirmed + unconfirmed, true
+	}, time.Now())
+	if err != nil {
+		backend.log.WithError(err).Error("Could not update the invoices")
+	}
+	for _, invoice := range changed {
+		if account, ok := accounts[invoice.AccountCode]; ok && releasesAddress(invoice) {
+			account.ReleaseAddresses([]blockchain.ScriptHashHex{invoice.ScriptHashHex})
+		}
+		backend.events <- AccountEvent{
+			Type: "account", Code: invoice.AccountCode, Data: eventInvoice, Invoice: invoice}
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package invoices manages payment requests to reserved receive addresses.
+package invoices
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/random"
+)
+
+// Status is the payment status of an invoice. See the Status* constants.
+type Status string
+
+const (
+	// StatusUnpaid means that nothing was received yet.
+	StatusUnpaid Status = "unpaid"
+	// StatusPartiallyPaid means that less than the requested amount was received.
+	StatusPartiallyPaid Status = "partiallyPaid"
+	// StatusPaid means that exactly the requested amount was received.
+	StatusPaid Status = "paid"
+	// StatusOverpaid means that more than the requested amount was received.
+	StatusOverpaid Status = "overpaid"
+	// StatusExpired means that the invoice expired before the requested amount was received.
+	StatusExpired Status = "expired"
+)
+
+// ComputeStatus returns the status of an invoice requesting amount, given the amount received so
+// far. An invoice which is paid in full stays paid after it expires.
+func ComputeStatus(amount btcutil.Amount, received btcutil.Amount, expires time.Time, now time.Time) Status {
+	switch {
+	case received > amount:
+		return StatusOverpaid
+	case received == amount:
+		return StatusPaid
+	case !now.Before(expires):
+		return StatusExpired
+	case received > 0:
+		return StatusPartiallyPaid
+	default:
+		return StatusUnpaid
+	}
+}
+
+// Invoice requests a payment to a receive address which was reserved for it.
+type Invoice struct {
+	ID          string `json:"id"`
+	AccountCode string `json:"accountCode"`
+	// URIScheme is the BIP21 URI scheme of the coin of the account, e.g. bitcoin.
+	URIScheme string `json:"uriScheme"`
+	// Unit is the unit of the coin of the account, e.g. BTC.
+	Unit          string                   `json:"unit"`
+	Address       string                   `json:"address"`
+	ScriptHashHex blockchain.ScriptHashHex `json:"scriptHashHex"`
+	Amount        btcutil.Amount           `json:"amount"`
+	Memo          string                   `json:"memo"`
+	Created       time.Time                `json:"created"`
+	Expires       time.Time                `json:"expires"`
+	// Received is the amount received on the address so far, including unconfirmed funds.
+	Received btcutil.Amount `json:"received"`
+	Status   Status         `json:"status"`
+}
+
+// FormatAmount formats the amount in the unit of the coin, without trailing zeros.
+func FormatAmount(amount btcutil.Amount) string {
+	return strconv.FormatFloat(amount.ToBTC(), 'f', -1, 64)
+}
+
+// URI returns the BIP21 URI of the invoice, containing the address, the amount and the memo.
+func (invoice *Invoice) URI() string {
+	uri := fmt.Sprintf("%s:%s?amount=%s", invoice.URIScheme, invoice.Address, FormatAmount(invoice.Amount))
+	if invoice.Memo != "" {
+		// BIP21 requires spaces to be percent-encoded.
+		uri += "&message=" + strings.Replace(url.QueryEscape(invoice.Memo), "+", "%20", -1)
+	}
+	return uri
+}
+
+// Store manages the invoices, persisted in a json file.
+type Store struct {
+	lock     locker.Locker
+	filename string
+	invoices []*Invoice
+}
+
+// NewStore creates a new Store, stored in the given location. The filename must be writable, but
+// does not have to exist.
+func NewStore(filename string) (*Store, error) {
+	store := &Store{
+		filename: filename,
+		invoices: []*Invoice{},
+	}
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := json.Unmarshal(jsonBytes, &store.invoices); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return store, nil
+}
+
+func (store *Store) save() error {
+	jsonBytes, err := json.Marshal(store.invoices)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(store.filename, jsonBytes, 0600))
+}
+
+// Invoices returns all invoices, the oldest first.
+func (store *Store) Invoices() []*Invoice {
+	defer store.lock.RLock()()
+	invoices := make([]*Invoice, len(store.invoices))
+	for i, invoice := range store.invoices {
+		invoiceCopy := *invoice
+		invoices[i] = &invoiceCopy
+	}
+	return invoices
+}
+
+// Invoice returns the invoice with the given id, or nil if there is none.
+func (store *Store) Invoice(id string) *Invoice {
+	defer store.lock.RLock()()
+	for _, invoice := range store.invoices {
+		if invoice.ID == id {
+			invoiceCopy := *invoice
+			return &invoiceCopy
+		}
+	}
+	return nil
+}
+
+// Add assigns an id and an initial status to the invoice and persists it.
+func (store *Store) Add(invoice *Invoice, now time.Time) error {
+	defer store.lock.Lock()()
+	if invoice.Amount <= 0 {
+		return errp.New("the amount must be positive")
+	}
+	if !invoice.Expires.After(now) {
+		return errp.New("the expiry must be in the future")
+	}
+	id, err := random.HexString(8)
+	if err != nil {
+		return err
+	}
+	invoice.ID = id
+	invoice.Status = ComputeStatus(invoice.Amount, invoice.Received, invoice.Expires, now)
+	invoiceCopy := *invoice
+	store.invoices = append(store.invoices, &invoiceCopy)
+	if err := store.save(); err != nil {
+		store.invoices = store.invoices[:len(store.invoices)-1]
+		return err
+	}
+	return nil
+}
+
+// Update recomputes the status of all invoices. received returns the amount received so far for an
+// invoice, or false if it is not known at the moment, in which case the previous amount is kept.
+// The invoices whose status changed are returned.
+func (store *Store) Update(
+	received func(*Invoice) (btcutil.Amount, bool), now time.Time) ([]*Invoice, error) {
+	defer store.lock.Lock()()
+	changed := []*Invoice{}
+	modified := false
+	for _, invoice := range store.invoices {
+		if amount, ok := received(invoice); ok && amount != invoice.Received {
+			invoice.Received = amount
+			modified = true
+		}
+		status := ComputeStatus(invoice.Amount, invoice.Received, invoice.Expires, now)
+		if status == invoice.Status {
+			continue
+		}
+		invoice.Status = status
+		modified = true
+		invoiceCopy := *invoice
+		changed = append(changed, &invoiceCopy)
+	}
+	if !modified {
+		return changed, nil
+	}
+	return changed, store.save()
+}
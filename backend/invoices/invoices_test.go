@@ -0,0 +1,118 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invoices_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/invoices"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+func TestComputeStatus(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	for _, testCase := range []struct {
+		received btcutil.Amount
+		expires  time.Time
+		status   invoices.Status
+	}{
+		{0, later, invoices.StatusUnpaid},
+		{40, later, invoices.StatusPartiallyPaid},
+		{100, later, invoices.StatusPaid},
+		{101, later, invoices.StatusOverpaid},
+		{0, now, invoices.StatusExpired},
+		{40, now, invoices.StatusExpired},
+		{100, now, invoices.StatusPaid},
+		{101, now, invoices.StatusOverpaid},
+	} {
+		require.Equal(t, testCase.status,
+			invoices.ComputeStatus(100, testCase.received, testCase.expires, now), testCase)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	require.Equal(t, "0", invoices.FormatAmount(0))
+	require.Equal(t, "0.00000001", invoices.FormatAmount(1))
+	require.Equal(t, "21", invoices.FormatAmount(21e8))
+}
+
+func TestURI(t *testing.T) {
+	invoice := &invoices.Invoice{
+		URIScheme: "bitcoin",
+		Address:   "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2",
+		Amount:    1234,
+	}
+	require.Equal(t, "bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?amount=0.00001234", invoice.URI())
+	invoice.Amount = 150000000
+	invoice.Memo = "Order #12 & more"
+	require.Equal(t,
+		"bitcoin:1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2?amount=1.5&message=Order%20%2312%20%26%20more",
+		invoice.URI())
+}
+
+func TestStore(t *testing.T) {
+	filename := filepath.Join(test.TstTempDir("invoices"), "invoices.json")
+	store, err := invoices.NewStore(filename)
+	require.NoError(t, err)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Error(t, store.Add(&invoices.Invoice{Amount: 0, Expires: now.Add(time.Hour)}, now))
+	require.Error(t, store.Add(&invoices.Invoice{Amount: 100, Expires: now}, now))
+
+	invoice1 := &invoices.Invoice{AccountCode: "a", Amount: 100, Expires: now.Add(time.Hour)}
+	require.NoError(t, store.Add(invoice1, now))
+	require.Equal(t, invoices.StatusUnpaid, invoice1.Status)
+	invoice2 := &invoices.Invoice{AccountCode: "b", Amount: 100, Expires: now.Add(2 * time.Hour)}
+	require.NoError(t, store.Add(invoice2, now))
+	require.NotEqual(t, invoice1.ID, invoice2.ID)
+	require.Equal(t, invoice1, store.Invoice(invoice1.ID))
+	require.Nil(t, store.Invoice("unknown"))
+
+	received := map[string]btcutil.Amount{}
+	receivedFunc := func(invoice *invoices.Invoice) (btcutil.Amount, bool) {
+		amount, ok := received[invoice.AccountCode]
+		return amount, ok
+	}
+	changed, err := store.Update(receivedFunc, now)
+	require.NoError(t, err)
+	require.Empty(t, changed)
+
+	received["a"] = 40
+	changed, err = store.Update(receivedFunc, now)
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	require.Equal(t, invoice1.ID, changed[0].ID)
+	require.Equal(t, invoices.StatusPartiallyPaid, changed[0].Status)
+	require.Equal(t, btcutil.Amount(40), changed[0].Received)
+
+	// The first invoice expires. Its received amount is unknown at the moment, so the previous one
+	// is kept.
+	delete(received, "a")
+	changed, err = store.Update(receivedFunc, now.Add(90*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	require.Equal(t, invoices.StatusExpired, changed[0].Status)
+	require.Equal(t, btcutil.Amount(40), changed[0].Received)
+
+	reloaded, err := invoices.NewStore(filename)
+	require.NoError(t, err)
+	require.Equal(t, store.Invoices(), reloaded.Invoices())
+}
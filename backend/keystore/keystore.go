@@ -55,4 +55,8 @@ type Keystore interface {
 	// SignTransaction signs the given transaction proposal. Returns ErrSigningAborted if the user
 	// aborts.
 	SignTransaction(coin.ProposedTransaction) error
+
+	// SignHash signs the given 32 byte hash with the key at the given absolute keypath. Returns the
+	// 65 byte recoverable signature [R || S || recID]. Returns ErrSigningAborted if the user aborts.
+	SignHash([]byte, signing.AbsoluteKeypath) ([]byte, error)
 }
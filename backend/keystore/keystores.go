@@ -15,10 +15,14 @@
 package keystore
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 )
 
 // Keystores models a collection of keystores that can be passed from a wallet to its accounts.
@@ -43,18 +47,37 @@ type Keystores interface {
 	// ErrSigningAborted if the user aborts.
 	SignTransaction(coin.ProposedTransaction) error
 
+	// SignHash signs the given hash with the key at the given keypath. This is only supported with
+	// a single keystore.
+	SignHash([]byte, signing.AbsoluteKeypath) ([]byte, error)
+
 	// Configuration returns the configuration at the given path with the given signing threshold.
 	Configuration(signing.ScriptType, signing.AbsoluteKeypath, int) (*signing.Configuration, error)
+
+	// EncryptionKey returns a 32 byte key to encrypt data at rest for the given purpose, e.g. a
+	// cache. The key changes with the keystores, and can only be derived while they are
+	// registered.
+	EncryptionKey(purpose string) ([]byte, error)
 }
 
+// encryptionKeypath is the keypath of the extended public keys from which the encryption keys are
+// derived. They are not used for anything else, and never stored.
+const encryptionKeypath = "m/1001'/0'"
+
 type implementation struct {
 	keystores []Keystore
+
+	// encryptionXPubs caches the extended public key at encryptionKeypath of each keystore, so that
+	// it is fetched from the keystore only once.
+	encryptionXPubs     map[Keystore]string
+	encryptionXPubsLock locker.Locker
 }
 
 // NewKeystores returns a collection of the given keystores.
 func NewKeystores(keystores ...Keystore) Keystores {
 	return &implementation{
-		keystores: keystores,
+		keystores:       keystores,
+		encryptionXPubs: map[Keystore]string{},
 	}
 }
 
@@ -82,6 +105,8 @@ func (keystores *implementation) Remove(keystore Keystore) error {
 			keystores.keystores[index] = keystores.keystores[indexOfLastElement]
 			keystores.keystores[indexOfLastElement] = nil // Prevent memory leak
 			keystores.keystores = keystores.keystores[:indexOfLastElement]
+			defer keystores.encryptionXPubsLock.Lock()()
+			delete(keystores.encryptionXPubs, keystore)
 			return nil
 		}
 	}
@@ -129,6 +154,17 @@ func (keystores *implementation) SignTransaction(proposedTransaction coin.Propos
 	return nil
 }
 
+// SignHash implements the above interface.
+func (keystores *implementation) SignHash(
+	hash []byte,
+	absoluteKeypath signing.AbsoluteKeypath,
+) ([]byte, error) {
+	if len(keystores.keystores) != 1 {
+		return nil, errp.New("Signing a hash requires exactly one keystore.")
+	}
+	return keystores.keystores[0].SignHash(hash, absoluteKeypath)
+}
+
 // Configuration implements the above interface.
 func (keystores *implementation) Configuration(
 	scriptType signing.ScriptType,
@@ -149,3 +185,39 @@ func (keystores *implementation) Configuration(
 	return signing.NewConfiguration(
 		scriptType, absoluteKeypath, extendedPublicKeys, signingThreshold), nil
 }
+
+// EncryptionKey implements the above interface. The key is the HMAC-SHA256 of the extended public
+// keys of all keystores at encryptionKeypath, keyed with the purpose.
+func (keystores *implementation) EncryptionKey(purpose string) ([]byte, error) {
+	if len(keystores.keystores) == 0 {
+		return nil, errp.New("no keystore registered")
+	}
+	mac := hmac.New(sha256.New, []byte(purpose))
+	for _, keystore := range keystores.keystores {
+		extendedPublicKey, err := keystores.encryptionXPub(keystore)
+		if err != nil {
+			return nil, err
+		}
+		_, _ = mac.Write([]byte(extendedPublicKey))
+	}
+	return mac.Sum(nil), nil
+}
+
+// encryptionXPub returns the extended public key of the keystore at encryptionKeypath, fetching it
+// from the keystore only the first time.
+func (keystores *implementation) encryptionXPub(keystore Keystore) (string, error) {
+	defer keystores.encryptionXPubsLock.Lock()()
+	if extendedPublicKey, ok := keystores.encryptionXPubs[keystore]; ok {
+		return extendedPublicKey, nil
+	}
+	keypath, err := signing.NewAbsoluteKeypath(encryptionKeypath)
+	if err != nil {
+		return "", err
+	}
+	extendedPublicKey, err := keystore.ExtendedPublicKey(keypath)
+	if err != nil {
+		return "", err
+	}
+	keystores.encryptionXPubs[keystore] = extendedPublicKey.String()
+	return keystores.encryptionXPubs[keystore], nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keystore_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/mocks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newSoftwareKeystore(t *testing.T, seed byte) keystore.Keystore {
+	seedBytes := make([]byte, hdkeychain.RecommendedSeedLen)
+	seedBytes[0] = seed
+	master, err := hdkeychain.NewMaster(seedBytes, &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	return software.NewKeystore(0, master)
+}
+
+func TestEncryptionKey(t *testing.T) {
+	_, err := keystore.NewKeystores().EncryptionKey("cache")
+	require.Error(t, err)
+
+	keystores := keystore.NewKeystores(newSoftwareKeystore(t, 1))
+	key, err := keystores.EncryptionKey("cache")
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+	sameKey, err := keystore.NewKeystores(newSoftwareKeystore(t, 1)).EncryptionKey("cache")
+	require.NoError(t, err)
+	require.Equal(t, key, sameKey)
+
+	otherPurpose, err := keystores.EncryptionKey("other")
+	require.NoError(t, err)
+	require.NotEqual(t, key, otherPurpose)
+	otherKeystore, err := keystore.NewKeystores(newSoftwareKeystore(t, 2)).EncryptionKey("cache")
+	require.NoError(t, err)
+	require.NotEqual(t, key, otherKeystore)
+}
+
+// TestEncryptionKeyCached tests that the extended public key is fetched from the keystore only once.
+func TestEncryptionKeyCached(t *testing.T) {
+	softwareKeystore := newSoftwareKeystore(t, 1)
+	mockKeystore := &mocks.Keystore{}
+	mockKeystore.On("ExtendedPublicKey", mock.Anything).Return(
+		func(keypath signing.AbsoluteKeypath) *hdkeychain.ExtendedKey {
+			extendedPublicKey, err := softwareKeystore.ExtendedPublicKey(keypath)
+			require.NoError(t, err)
+			return extendedPublicKey
+		}, nil).Once()
+	keystores := keystore.NewKeystores(mockKeystore)
+	key, err := keystores.EncryptionKey("cache")
+	require.NoError(t, err)
+	sameKey, err := keystores.EncryptionKey("cache")
+	require.NoError(t, err)
+	require.Equal(t, key, sameKey)
+	otherPurpose, err := keystores.EncryptionKey("other")
+	require.NoError(t, err)
+	require.NotEqual(t, key, otherPurpose)
+	mockKeystore.AssertExpectations(t)
+}
@@ -99,6 +99,29 @@ func (_m *Keystore) OutputAddress(_a0 signing.AbsoluteKeypath, _a1 signing.Scrip
 	return r0
 }
 
+// SignHash provides a mock function with given fields: _a0, _a1
+func (_m *Keystore) SignHash(_a0 []byte, _a1 signing.AbsoluteKeypath) ([]byte, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 []byte
+	if rf, ok := ret.Get(0).(func([]byte, signing.AbsoluteKeypath) []byte); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]byte, signing.AbsoluteKeypath) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // SignTransaction provides a mock function with given fields: _a0
 func (_m *Keystore) SignTransaction(_a0 coin.ProposedTransaction) error {
 	ret := _m.Called(_a0)
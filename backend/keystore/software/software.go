@@ -24,6 +24,7 @@ import (
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/sirupsen/logrus"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
@@ -185,3 +186,24 @@ func (keystore *Keystore) SignTransaction(
 	}
 	return nil
 }
+
+// SignHash implements keystore.Keystore.
+func (keystore *Keystore) SignHash(hash []byte, keypath signing.AbsoluteKeypath) ([]byte, error) {
+	if len(hash) != 32 {
+		return nil, errp.New("The hash to sign must be 32 bytes long.")
+	}
+	keystore.log.Info("Sign hash.")
+	xprv, err := keypath.Derive(keystore.master)
+	if err != nil {
+		return nil, err
+	}
+	prv, err := xprv.ECPrivKey()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	signature, err := crypto.Sign(hash, prv.ToECDSA())
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return signature, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package labels stores the labels which the user gives to the transactions of the accounts.
+package labels
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// Store manages the labels, persisted in a json file. Unlike the transactions, which are cached
+// and downloaded again when the cache is dropped, the labels can't be recovered.
+type Store struct {
+	lock     locker.Locker
+	filename string
+	// labels maps account codes to txids to labels.
+	labels map[string]map[string]string
+}
+
+// NewStore creates a new Store, stored in the given location. The filename must be writable, but
+// does not have to exist.
+func NewStore(filename string) (*Store, error) {
+	store := &Store{
+		filename: filename,
+		labels:   map[string]map[string]string{},
+	}
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := json.Unmarshal(jsonBytes, &store.labels); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return store, nil
+}
+
+func (store *Store) save() error {
+	jsonBytes, err := json.Marshal(store.labels)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(ioutil.WriteFile(store.filename, jsonBytes, 0600))
+}
+
+// Labels returns the labels of the transactions of the account with the given code, by txid.
+func (store *Store) Labels(accountCode string) map[string]string {
+	defer store.lock.RLock()()
+	labels := make(map[string]string, len(store.labels[accountCode]))
+	for txID, label := range store.labels[accountCode] {
+		labels[txID] = label
+	}
+	return labels
+}
+
+// SetLabel persists the label of a transaction of the account with the given code. An empty label
+// removes it.
+func (store *Store) SetLabel(accountCode string, txID string, label string) error {
+	defer store.lock.Lock()()
+	accountLabels, ok := store.labels[accountCode]
+	if !ok {
+		accountLabels = map[string]string{}
+		store.labels[accountCode] = accountLabels
+	}
+	previous, hadPrevious := accountLabels[txID]
+	if label == "" {
+		delete(accountLabels, txID)
+	} else {
+		accountLabels[txID] = label
+	}
+	if len(accountLabels) == 0 {
+		delete(store.labels, accountCode)
+	}
+	if err := store.save(); err != nil {
+		if hadPrevious {
+			accountLabels[txID] = previous
+		} else {
+			delete(accountLabels, txID)
+		}
+		store.labels[accountCode] = accountLabels
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package labels_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/labels"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+)
+
+func TestStore(t *testing.T) {
+	filename := filepath.Join(test.TstTempDir("labels"), "labels.json")
+	store, err := labels.NewStore(filename)
+	require.NoError(t, err)
+	require.Empty(t, store.Labels("a"))
+
+	require.NoError(t, store.SetLabel("a", "txid1", "Rent"))
+	require.NoError(t, store.SetLabel("a", "txid2", "Groceries"))
+	require.NoError(t, store.SetLabel("b", "txid1", "Salary"))
+	require.Equal(t, map[string]string{"txid1": "Rent", "txid2": "Groceries"}, store.Labels("a"))
+	require.Equal(t, map[string]string{"txid1": "Salary"}, store.Labels("b"))
+
+	// The returned labels are a copy.
+	store.Labels("a")["txid1"] = "Changed"
+	require.Equal(t, "Rent", store.Labels("a")["txid1"])
+
+	require.NoError(t, store.SetLabel("a", "txid2", ""))
+	require.NoError(t, store.SetLabel("b", "txid1", ""))
+
+	reloaded, err := labels.NewStore(filename)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"txid1": "Rent"}, reloaded.Labels("a"))
+	require.Empty(t, reloaded.Labels("b"))
+}
@@ -0,0 +1,257 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"math/big"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable/action"
+)
+
+// defaultFiat is used if the user has not chosen a fiat currency.
+const defaultFiat = "USD"
+
+// PortfolioAccount is the balance of an account.
+type PortfolioAccount struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Available and Incoming are formatted in the unit of the coin.
+	Available string `json:"available"`
+	Incoming  string `json:"incoming"`
+	// FiatValue is the value of the available and incoming balance.
+	FiatValue string `json:"fiatValue"`
+	// Share is the percentage of the account in the total value of the portfolio.
+	Share string `json:"share"`
+}
+
+// PortfolioCoin sums up the balances of the accounts of a coin.
+type PortfolioCoin struct {
+	Code      string              `json:"code"`
+	Unit      string              `json:"unit"`
+	Available string              `json:"available"`
+	Incoming  string              `json:"incoming"`
+	FiatValue string              `json:"fiatValue"`
+	Share     string              `json:"share"`
+	Accounts  []*PortfolioAccount `json:"accounts"`
+}
+
+// Portfolio sums up the balances of all accounts in a fiat currency.
+type Portfolio struct {
+	Fiat string `json:"fiat"`
+	// Total is the value of the available and incoming balances.
+	Total     string `json:"total"`
+	Available string `json:"available"`
+	Incoming  string `json:"incoming"`
+	// Change24h is the change of Total over the last 24 hours due to the change of the exchange
+	// rates, i.e. the current balances valued at the current rates minus valued at the rates 24
+	// hours ago, as reported by the rates API. It is nil if the rates of 24 hours ago are not
+	// known. Change24hPercent is relative to the value 24 hours ago.
+	Change24h        *string `json:"change24h"`
+	Change24hPercent *string `json:"change24hPercent"`
+	// Incomplete is true if accounts were left out because they are not synced yet or have no
+	// exchange rate.
+	Incomplete bool             `json:"incomplete"`
+	Coins      []*PortfolioCoin `json:"coins"`
+}
+
+// portfolioAccount is the part of btc.Interface needed to compute a Portfolio.
+type portfolioAccount interface {
+	Code() string
+	Name() string
+	Coin() coin.Coin
+	InitialSyncDone() bool
+	Balance() *transactions.Balance
+}
+
+func formatFiat(value *big.Rat) string {
+	return value.FloatString(2)
+}
+
+func formatShare(value *big.Rat, total *big.Rat) string {
+	if total.Sign() == 0 {
+		return formatFiat(new(big.Rat))
+	}
+	share := new(big.Rat).Quo(value, total)
+	return formatFiat(share.Mul(share, big.NewRat(100, 1)))
+}
+
+// amountAsRat converts an amount to a rational number in the unit of the coin.
+func amountAsRat(c coin.Coin, amount coin.Amount) *big.Rat {
+	value, ok := new(big.Rat).SetString(c.FormatAmount(amount))
+	if !ok {
+		return new(big.Rat)
+	}
+	return value
+}
+
+// rateAsRat looks up a rate like coin.Rate, converted to a rational number.
+func rateAsRat(rates map[string]map[string]float64, ratesUnit string, fiat string) (*big.Rat, bool) {
+	rate, ok := coin.Rate(rates, ratesUnit, fiat)
+	if !ok {
+		return nil, false
+	}
+	return new(big.Rat).SetFloat64(rate), true
+}
+
+func addAmounts(amount1 coin.Amount, amount2 coin.Amount) coin.Amount {
+	return coin.NewAmount(new(big.Int).Add(amount1.BigInt(), amount2.BigInt()))
+}
+
+// computePortfolio sums up the balances of the accounts, valued at the given rates. previousRates
+// are the rates of 24 hours ago, nil if not known. The coins and their accounts are in the order of
+// the given accounts. The values are summed up exactly, and only rounded when formatted.
+func computePortfolio(
+	accounts []portfolioAccount,
+	rates map[string]map[string]float64,
+	previousRates map[string]map[string]float64,
+	fiat string,
+) *Portfolio {
+	type coinSums struct {
+		coin      coin.Coin
+		available coin.Amount
+		incoming  coin.Amount
+		fiatValue *big.Rat
+		accounts  []*PortfolioAccount
+		// accountValues are the fiat values of the accounts, to compute their share.
+		accountValues []*big.Rat
+	}
+	portfolio := &Portfolio{Fiat: fiat, Coins: []*PortfolioCoin{}}
+	coinsSums := []*coinSums{}
+	coinsByCode := map[string]*coinSums{}
+	total, available, incoming, change := new(big.Rat), new(big.Rat), new(big.Rat), new(big.Rat)
+	changeKnown := previousRates != nil
+	for _, account := range accounts {
+		if !account.InitialSyncDone() {
+			portfolio.Incomplete = true
+			continue
+		}
+		accountCoin := account.Coin()
+		rate, ok := rateAsRat(rates, ratesUnit(accountCoin.Code()), fiat)
+		if !ok {
+			portfolio.Incomplete = true
+			continue
+		}
+		balance := account.Balance()
+		accountAvailable := amountAsRat(accountCoin, balance.Available)
+		accountIncoming := amountAsRat(accountCoin, balance.Incoming)
+		accountBalance := new(big.Rat).Add(accountAvailable, accountIncoming)
+		accountValue := new(big.Rat).Mul(accountBalance, rate)
+		total.Add(total, accountValue)
+		available.Add(available, new(big.Rat).Mul(accountAvailable, rate))
+		incoming.Add(incoming, new(big.Rat).Mul(accountIncoming, rate))
+		if previousRate, ok := rateAsRat(previousRates, ratesUnit(accountCoin.Code()), fiat); ok {
+			rateChange := new(big.Rat).Sub(rate, previousRate)
+			change.Add(change, rateChange.Mul(rateChange, accountBalance))
+		} else {
+			changeKnown = false
+		}
+
+		sums, ok := coinsByCode[accountCoin.Code()]
+		if !ok {
+			sums = &coinSums{
+				coin:      accountCoin,
+				available: coin.NewAmountFromInt64(0),
+				incoming:  coin.NewAmountFromInt64(0),
+				fiatValue: new(big.Rat),
+			}
+			coinsByCode[accountCoin.Code()] = sums
+			coinsSums = append(coinsSums, sums)
+		}
+		sums.available = addAmounts(sums.available, balance.Available)
+		sums.incoming = addAmounts(sums.incoming, balance.Incoming)
+		sums.fiatValue.Add(sums.fiatValue, accountValue)
+		sums.accounts = append(sums.accounts, &PortfolioAccount{
+			Code:      account.Code(),
+			Name:      account.Name(),
+			Available: accountCoin.FormatAmount(balance.Available),
+			Incoming:  accountCoin.FormatAmount(balance.Incoming),
+			FiatValue: formatFiat(accountValue),
+		})
+		sums.accountValues = append(sums.accountValues, accountValue)
+	}
+	for _, sums := range coinsSums {
+		for i, account := range sums.accounts {
+			account.Share = formatShare(sums.accountValues[i], total)
+		}
+		portfolio.Coins = append(portfolio.Coins, &PortfolioCoin{
+			Code:      sums.coin.Code(),
+			Unit:      sums.coin.Unit(),
+			Available: sums.coin.FormatAmount(sums.available),
+			Incoming:  sums.coin.FormatAmount(sums.incoming),
+			FiatValue: formatFiat(sums.fiatValue),
+			Share:     formatShare(sums.fiatValue, total),
+			Accounts:  sums.accounts,
+		})
+	}
+	portfolio.Total = formatFiat(total)
+	portfolio.Available = formatFiat(available)
+	portfolio.Incoming = formatFiat(incoming)
+	if changeKnown {
+		formattedChange := formatFiat(change)
+		portfolio.Change24h = &formattedChange
+		if previousTotal := new(big.Rat).Sub(total, change); previousTotal.Sign() > 0 {
+			formattedPercent := formatShare(change, previousTotal)
+			portfolio.Change24hPercent = &formattedPercent
+		}
+	}
+	return portfolio
+}
+
+// mainFiat returns the fiat currency chosen by the user in the frontend settings.
+func (backend *Backend) mainFiat() string {
+	if frontendConfig, ok := backend.config.Config().Frontend.(map[string]interface{}); ok {
+		if fiat, ok := frontendConfig["fiatCode"].(string); ok && fiat != "" {
+			return fiat
+		}
+	}
+	return defaultFiat
+}
+
+// Portfolio sums up the balances of all accounts in the given fiat currency, or in the one chosen
+// by the user if fiat is empty.
+func (backend *Backend) Portfolio(fiat string) *Portfolio {
+	if fiat == "" {
+		fiat = backend.mainFiat()
+	}
+	accounts := []portfolioAccount{}
+	for _, account := range backend.Accounts() {
+		accounts = append(accounts, account)
+	}
+	return computePortfolio(
+		accounts,
+		backend.ratesUpdater.Last(),
+		backend.ratesUpdater.DayAgo(),
+		fiat,
+	)
+}
+
+// updatePortfolio notifies the frontend about the portfolio in the fiat currency chosen by the
+// user if its total changed.
+func (backend *Backend) updatePortfolio() {
+	defer backend.portfolioLock.Lock()()
+	portfolio := backend.Portfolio("")
+	if backend.portfolioTotal == portfolio.Fiat+portfolio.Total {
+		return
+	}
+	backend.portfolioTotal = portfolio.Fiat + portfolio.Total
+	backend.events <- observable.Event{
+		Subject: "portfolio",
+		Action:  action.Replace,
+		Object:  portfolio,
+	}
+}
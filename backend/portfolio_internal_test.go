@@ -0,0 +1,127 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/stretchr/testify/require"
+)
+
+type portfolioAccountMock struct {
+	code      string
+	coin      coin.Coin
+	synced    bool
+	available int64
+	incoming  int64
+}
+
+func (account *portfolioAccountMock) Code() string          { return account.code }
+func (account *portfolioAccountMock) Name() string          { return "Account " + account.code }
+func (account *portfolioAccountMock) Coin() coin.Coin       { return account.coin }
+func (account *portfolioAccountMock) InitialSyncDone() bool { return account.synced }
+func (account *portfolioAccountMock) Balance() *transactions.Balance {
+	return &transactions.Balance{
+		Available: coin.NewAmountFromInt64(account.available),
+		Incoming:  coin.NewAmountFromInt64(account.incoming),
+	}
+}
+
+func TestComputePortfolio(t *testing.T) {
+	btcCoin := btc.NewCoin("btc", "BTC", &chaincfg.MainNetParams, "", nil, nil, "", nil)
+	ltcCoin := btc.NewCoin("ltc", "LTC", &chaincfg.MainNetParams, "", nil, nil, "", nil)
+	accounts := []portfolioAccount{
+		&portfolioAccountMock{code: "btc-1", coin: btcCoin, synced: true, available: 1e8, incoming: 5e7},
+		&portfolioAccountMock{code: "ltc-1", coin: ltcCoin, synced: true, available: 2e8},
+		&portfolioAccountMock{code: "btc-2", coin: btcCoin, synced: true, available: 5e7},
+	}
+	rates := map[string]map[string]float64{
+		"BTC": {"USD": 100, "EUR": 90},
+		"LTC": {"USD": 50},
+	}
+
+	portfolio := computePortfolio(accounts, rates, nil, "USD")
+	require.Equal(t, &Portfolio{
+		Fiat:      "USD",
+		Total:     "300.00",
+		Available: "250.00",
+		Incoming:  "50.00",
+		Coins: []*PortfolioCoin{
+			{
+				Code: "btc", Unit: "BTC", Available: "1.5", Incoming: "0.5",
+				FiatValue: "200.00", Share: "66.67",
+				Accounts: []*PortfolioAccount{
+					{
+						Code: "btc-1", Name: "Account btc-1", Available: "1", Incoming: "0.5",
+						FiatValue: "150.00", Share: "50.00",
+					},
+					{
+						Code: "btc-2", Name: "Account btc-2", Available: "0.5", Incoming: "",
+						FiatValue: "50.00", Share: "16.67",
+					},
+				},
+			},
+			{
+				Code: "ltc", Unit: "LTC", Available: "2", Incoming: "",
+				FiatValue: "100.00", Share: "33.33",
+				Accounts: []*PortfolioAccount{
+					{
+						Code: "ltc-1", Name: "Account ltc-1", Available: "2", Incoming: "",
+						FiatValue: "100.00", Share: "33.33",
+					},
+				},
+			},
+		},
+	}, portfolio)
+
+	// The change over 24h is known if all rates of 24h ago are.
+	previousRates := map[string]map[string]float64{
+		"BTC": {"USD": 80},
+		"LTC": {"USD": 50},
+	}
+	portfolio = computePortfolio(accounts, rates, previousRates, "USD")
+	require.Equal(t, "40.00", *portfolio.Change24h)
+	require.Equal(t, "15.38", *portfolio.Change24hPercent)
+	delete(previousRates, "LTC")
+	portfolio = computePortfolio(accounts, rates, previousRates, "USD")
+	require.Nil(t, portfolio.Change24h)
+	require.Nil(t, portfolio.Change24hPercent)
+
+	// Testnet coins have no rates, not even the ones of their mainnet counterpart.
+	tbtcCoin := btc.NewCoin("tbtc", "TBTC", &chaincfg.TestNet3Params, "", nil, nil, "", nil)
+	portfolio = computePortfolio(append(accounts,
+		&portfolioAccountMock{code: "tbtc-1", coin: tbtcCoin, synced: true, available: 1e8},
+	), rates, nil, "USD")
+	require.True(t, portfolio.Incomplete)
+	require.Equal(t, "300.00", portfolio.Total)
+
+	// Accounts without a rate or which are not synced are left out.
+	portfolio = computePortfolio(accounts, rates, nil, "EUR")
+	require.True(t, portfolio.Incomplete)
+	require.Equal(t, "180.00", portfolio.Total)
+	require.Len(t, portfolio.Coins, 1)
+	accounts[0].(*portfolioAccountMock).synced = false
+	portfolio = computePortfolio(accounts, rates, nil, "USD")
+	require.True(t, portfolio.Incomplete)
+	require.Equal(t, "150.00", portfolio.Total)
+	// The coins are in the order of their first included account.
+	require.Equal(t, "ltc", portfolio.Coins[0].Code)
+	require.Equal(t, "66.67", portfolio.Coins[0].Share)
+	require.Equal(t, "33.33", portfolio.Coins[1].Share)
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/costbasis"
+)
+
+// CostBasisReport matches the disposals with the acquisitions of the coins of all accounts using
+// the given method, valued in the given fiat currency at the rates of the days of the transactions.
+// incomplete is true if accounts were left out because they are not synced yet. unsupported are the
+// codes of the accounts which were left out because their transactions can't be valued, as their
+// coin has no exchange rates or their transaction history is not available (Ethereum).
+func (backend *Backend) CostBasisReport(method costbasis.Method, fiat string) (
+	report *costbasis.Report, incomplete bool, unsupported []string, err error) {
+	histories := []*costbasis.AccountHistory{}
+	// coinCodes are the codes of the coins by unit.
+	coinCodes := map[string]string{}
+	unsupported = []string{}
+	for _, account := range backend.Accounts() {
+		if _, isETH := account.Coin().(*eth.Coin); isETH || ratesUnit(account.Coin().Code()) == "" {
+			unsupported = append(unsupported, account.Code())
+			continue
+		}
+		if !account.InitialSyncDone() {
+			incomplete = true
+			continue
+		}
+		coinCodes[account.Coin().Unit()] = account.Coin().Code()
+		histories = append(histories, &costbasis.AccountHistory{
+			Unit:         account.Coin().Unit(),
+			Transactions: account.Transactions(),
+		})
+	}
+	events, err := costbasis.Events(histories, func(unit string, t time.Time) (float64, error) {
+		return backend.HistoricalRate(coinCodes[unit], fiat, t)
+	})
+	if err != nil {
+		return nil, false, nil, err
+	}
+	return costbasis.Compute(events, method), incomplete, unsupported, nil
+}
@@ -16,14 +16,18 @@ package backend
 
 import (
 	"encoding/json"
-	"net/http"
+	"time"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/semver"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
 )
 
-const updateFileURL = "https://shiftcrypto.ch/updates/desktop.json"
+const (
+	updateFileURL = "https://shiftcrypto.ch/updates/desktop.json"
+	updateTimeout = time.Minute
+)
 
 var (
 	// Version of the backend as displayed to the user.
@@ -42,10 +46,11 @@ type UpdateFile struct {
 	Description string `json:"description"`
 }
 
-// CheckForUpdate checks whether a newer version of this application has been released.
-// It returns the retrieved update file if a newer version has been released and nil otherwise.
-func CheckForUpdate() (*UpdateFile, error) {
-	response, err := http.Get(updateFileURL)
+// CheckForUpdate checks whether a newer version of this application has been released, connecting
+// through the given proxy. It returns the retrieved update file if a newer version has been
+// released and nil otherwise.
+func CheckForUpdate(socksProxy *socksproxy.SocksProxy) (*UpdateFile, error) {
+	response, err := socksProxy.HTTPClient(updateTimeout).Get(updateFileURL)
 	if err != nil {
 		return nil, errp.WithStack(err)
 	}
@@ -68,8 +73,8 @@ func CheckForUpdate() (*UpdateFile, error) {
 }
 
 // CheckForUpdateIgnoringErrors suppresses any errors that are triggered, for example, when offline.
-func CheckForUpdateIgnoringErrors() *UpdateFile {
-	updateFile, err := CheckForUpdate()
+func CheckForUpdateIgnoringErrors(socksProxy *socksproxy.SocksProxy) *UpdateFile {
+	updateFile, err := CheckForUpdate(socksProxy)
 	if err != nil {
 		logging.Get().WithGroup("update").WithError(err).Warn("Check for update failed.")
 		return nil
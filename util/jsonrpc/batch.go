@@ -0,0 +1,139 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonrpc
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+const (
+	// batchDelay is how long requests are queued before they are sent together in a batch.
+	batchDelay = 5 * time.Millisecond
+	// maxBatchSize is the maximum number of requests sent in one batch.
+	maxBatchSize = 100
+)
+
+// enqueue queues the prepared request with the given ID. The queued requests are sent in a JSON-RPC
+// batch after batchDelay, or as soon as the batch is full. This saves round trips if many requests
+// are made at once, e.g. during the initial sync of an account with many addresses.
+func (client *RPCClient) enqueue(msgID int) {
+	unlock := client.queueLock.Lock()
+	client.queue = append(client.queue, msgID)
+	queued := len(client.queue)
+	unlock()
+	switch {
+	case queued >= maxBatchSize:
+		client.flush()
+	case queued == 1:
+		time.AfterFunc(batchDelay, client.flush)
+	}
+}
+
+// flush sends the queued requests.
+func (client *RPCClient) flush() {
+	unlock := client.queueLock.Lock()
+	msgIDs := client.queue
+	client.queue = nil
+	unlock()
+	if len(msgIDs) == 0 {
+		return
+	}
+	if err := client.write(msgIDs); err != nil {
+		client.log.Debugf("Resend triggered when sending %d requests", len(msgIDs))
+		go client.resendPendingRequestsAndSubscriptions(err.connection)
+	}
+}
+
+// write sends the pending requests with the given IDs, in a batch if there is more than one and the
+// server accepts batches. Requests which are not pending anymore are skipped. The timeout of the
+// requests starts anew.
+func (client *RPCClient) write(msgIDs []int) *SocketError {
+	conn, err := client.conn()
+	if err != nil {
+		return &SocketError{err, conn}
+	}
+	rejectsBatches := client.rejectsBatches(conn.backend)
+	messages := [][]byte{}
+	batched := false
+	func() {
+		defer client.pendingRequestsLock.Lock()()
+		now := time.Now()
+		requests := []*request{}
+		for _, msgID := range msgIDs {
+			request, ok := client.pendingRequests[msgID]
+			if !ok {
+				continue
+			}
+			request.sent = now
+			request.connection = conn
+			request.timer.Reset(request.timeout)
+			requests = append(requests, request)
+			messages = append(messages, bytes.TrimSuffix(request.jsonText, []byte{'\n'}))
+		}
+		batched = len(requests) > 1 && !rejectsBatches
+		for _, request := range requests {
+			request.batched = batched
+		}
+	}()
+	var msg []byte
+	switch {
+	case len(messages) == 0:
+		return nil
+	case !batched:
+		for _, message := range messages {
+			msg = append(append(msg, message...), '\n')
+		}
+	default:
+		msg = append(msg, '[')
+		msg = append(msg, bytes.Join(messages, []byte{','})...)
+		msg = append(msg, ']', '\n')
+	}
+	if _, err := conn.conn.Write(msg); err != nil {
+		return &SocketError{err, conn}
+	}
+	return nil
+}
+
+func (client *RPCClient) rejectsBatches(backend rpc.Backend) bool {
+	defer client.batchesRejectedLock.RLock()()
+	return client.batchesRejected[backend]
+}
+
+// batchRejected is called if the server responds with an error which does not belong to a request,
+// which is how servers not supporting batches respond to a batch. The requests sent over the
+// connection in a batch are resent one by one.
+func (client *RPCClient) batchRejected(conn *connection, message string) {
+	client.log.WithField("server", conn.backend.ServerInfo().Server).WithField("error", message).
+		Info("Error response without a request, sending the requests one by one")
+	unlock := client.batchesRejectedLock.Lock()
+	client.batchesRejected[conn.backend] = true
+	unlock()
+	unlock = client.pendingRequestsLock.RLock()
+	msgIDs := []int{}
+	for msgID, request := range client.pendingRequests {
+		if request.connection == conn && request.batched {
+			msgIDs = append(msgIDs, msgID)
+		}
+	}
+	unlock()
+	go func() {
+		if err := client.write(msgIDs); err != nil {
+			client.resendPendingRequestsAndSubscriptions(err.connection)
+		}
+	}()
+}
@@ -0,0 +1,295 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonrpc_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryRequest struct {
+	ID     int               `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// memoryBackend is an in-memory server, which answers any method with its first parameter.
+type memoryBackend struct {
+	name string
+
+	lock sync.Mutex
+	// quiet servers do not respond.
+	quiet bool
+	// rejectBatches makes the server respond to batches with an error, like servers which do not
+	// support them.
+	rejectBatches bool
+	// batchSizes are the number of requests of each received message.
+	batchSizes []int
+}
+
+func (backend *memoryBackend) setQuiet(quiet bool) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	backend.quiet = quiet
+}
+
+func (backend *memoryBackend) receivedBatchSizes() []int {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	return append([]int{}, backend.batchSizes...)
+}
+
+func (backend *memoryBackend) respond(request *memoryRequest) map[string]interface{} {
+	var result interface{} = "ok"
+	if len(request.Params) > 0 {
+		result = request.Params[0]
+	}
+	return map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": result}
+}
+
+func (backend *memoryBackend) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var response interface{}
+		backend.lock.Lock()
+		rejectBatches := backend.rejectBatches
+		backend.lock.Unlock()
+		switch {
+		case line[0] == '[' && rejectBatches:
+			response = map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      nil,
+				"error":   map[string]interface{}{"code": -32600, "message": "invalid request"},
+			}
+		case line[0] == '[':
+			requests := []*memoryRequest{}
+			if err := json.Unmarshal(line, &requests); err != nil {
+				return
+			}
+			responses := make([]interface{}, len(requests))
+			for index, request := range requests {
+				responses[index] = backend.respond(request)
+			}
+			response = responses
+		default:
+			request := &memoryRequest{}
+			if err := json.Unmarshal(line, request); err != nil {
+				return
+			}
+			response = backend.respond(request)
+		}
+		backend.lock.Lock()
+		quiet := backend.quiet
+		if responses, ok := response.([]interface{}); ok {
+			backend.batchSizes = append(backend.batchSizes, len(responses))
+		} else if line[0] != '[' {
+			backend.batchSizes = append(backend.batchSizes, 1)
+		}
+		backend.lock.Unlock()
+		if quiet {
+			continue
+		}
+		responseBytes, _ := json.Marshal(response)
+		if _, err := conn.Write(append(responseBytes, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+func (backend *memoryBackend) EstablishConnection() (io.ReadWriteCloser, error) {
+	client, server := net.Pipe()
+	go backend.serve(server)
+	return client, nil
+}
+
+func (backend *memoryBackend) ServerInfo() *rpc.ServerInfo {
+	return &rpc.ServerInfo{Server: backend.name}
+}
+
+func newMemoryClient(backends ...*memoryBackend) *jsonrpc.RPCClient {
+	rpcBackends := make([]rpc.Backend, len(backends))
+	for index, backend := range backends {
+		rpcBackends[index] = backend
+	}
+	client := jsonrpc.NewRPCClient(rpcBackends, logging.Get().WithGroup("jsonrpc_test"))
+	client.OnConnect(func() error { return nil })
+	return client
+}
+
+func TestBatching(t *testing.T) {
+	backend := &memoryBackend{name: "server"}
+	client := newMemoryClient(backend)
+
+	const count = 250
+	results := make([]string, count)
+	var wg sync.WaitGroup
+	wg.Add(count)
+	for index := 0; index < count; index++ {
+		index := index
+		client.Method(
+			func(responseBytes []byte) error {
+				defer wg.Done()
+				return json.Unmarshal(responseBytes, &results[index])
+			},
+			nil,
+			nil,
+			"echo", fmt.Sprint(index))
+	}
+	wg.Wait()
+	for index, result := range results {
+		require.Equal(t, fmt.Sprint(index), result)
+	}
+
+	batchSizes := backend.receivedBatchSizes()
+	total := 0
+	for _, batchSize := range batchSizes {
+		require.True(t, batchSize <= 100)
+		total += batchSize
+	}
+	require.Equal(t, count, total)
+	require.True(t, len(batchSizes) < count, "requests were not batched")
+
+	// A single request is not sent as a batch.
+	var response string
+	require.NoError(t, client.MethodSync(&response, "echo", "single"))
+	require.Equal(t, "single", response)
+	batchSizes = backend.receivedBatchSizes()
+	require.Equal(t, 1, batchSizes[len(batchSizes)-1])
+}
+
+func TestTimeout(t *testing.T) {
+	backends := map[string]*memoryBackend{
+		"first":  {name: "first"},
+		"second": {name: "second"},
+	}
+	client := newMemoryClient(backends["first"], backends["second"])
+	client.SetMethodTimeout("echo", 100*time.Millisecond)
+
+	var response string
+	require.NoError(t, client.MethodSync(&response, "echo", "a"))
+	active := client.ActiveServer()
+	other := "first"
+	if active == "first" {
+		other = "second"
+	}
+
+	// The request fails with a typed error and the client fails over to the other server.
+	backends[active].setQuiet(true)
+	err := client.MethodSync(&response, "echo", "b")
+	timeoutErr, ok := err.(*jsonrpc.TimeoutError)
+	require.True(t, ok, "unexpected error %v", err)
+	require.Equal(t, "echo", timeoutErr.Method)
+	require.Equal(t, 100*time.Millisecond, timeoutErr.Timeout)
+	require.NoError(t, client.MethodSync(&response, "echo", "c"))
+	require.Equal(t, "c", response)
+	require.Equal(t, other, client.ActiveServer())
+	require.NotZero(t, findServer(client.Servers(), active).Errors)
+
+	// Other methods use the default timeout.
+	require.NoError(t, client.MethodSync(&response, "server.version"))
+
+	// Requests without a failure callback are resent to another server.
+	backends[active].setQuiet(false)
+	backends[other].setQuiet(true)
+	responses := make(chan string, 1)
+	client.Method(
+		func(responseBytes []byte) error {
+			var response string
+			if err := json.Unmarshal(responseBytes, &response); err != nil {
+				return err
+			}
+			responses <- response
+			return nil
+		},
+		nil,
+		nil,
+		"echo", "d")
+	select {
+	case response := <-responses:
+		require.Equal(t, "d", response)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "request was not resent")
+	}
+	require.Equal(t, active, client.ActiveServer())
+}
+
+func TestBatchRejected(t *testing.T) {
+	backend := &memoryBackend{name: "server", rejectBatches: true}
+	client := newMemoryClient(backend)
+
+	const count = 10
+	results := make([]string, count)
+	var wg sync.WaitGroup
+	wg.Add(count)
+	for index := 0; index < count; index++ {
+		index := index
+		client.Method(
+			func(responseBytes []byte) error {
+				defer wg.Done()
+				return json.Unmarshal(responseBytes, &results[index])
+			},
+			nil,
+			nil,
+			"echo", fmt.Sprint(index))
+	}
+	wg.Wait()
+	for index, result := range results {
+		require.Equal(t, fmt.Sprint(index), result)
+	}
+	// The requests are resent one by one.
+	require.Equal(t, count, len(backend.receivedBatchSizes()))
+}
+
+func TestMethodFailure(t *testing.T) {
+	backend := &memoryBackend{name: "server", quiet: true}
+	client := newMemoryClient(backend)
+	client.SetMethodTimeout("echo", 100*time.Millisecond)
+
+	failures := make(chan error, 1)
+	cleanups := make(chan struct{}, 1)
+	client.Method(
+		func([]byte) error {
+			require.Fail(t, "unexpected response")
+			return nil
+		},
+		func() func() { return func() { cleanups <- struct{}{} } },
+		func(err error) { failures <- err },
+		"echo", "a")
+	select {
+	case err := <-failures:
+		timeoutErr, ok := err.(*jsonrpc.TimeoutError)
+		require.True(t, ok, "unexpected error %v", err)
+		require.Equal(t, "echo", timeoutErr.Method)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "request did not fail")
+	}
+	<-cleanups
+}
@@ -0,0 +1,343 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonrpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+const (
+	// healthWindow is the number of recent requests from which the error rate of a server is
+	// computed.
+	healthWindow = 20
+	// maxErrorRate is the error rate above which a server is unhealthy.
+	maxErrorRate = 0.5
+	// maxTipLag is the number of blocks a server can lag behind the best known tip while being
+	// healthy.
+	maxTipLag = 2
+	// latencyWeight is the weight of a new response time in the moving average.
+	latencyWeight = 0.2
+)
+
+// serverHealth tracks the health of a backend. It is protected by RPCClient.healthLock.
+type serverHealth struct {
+	latency  time.Duration
+	requests int
+	errors   int
+	// outcomes are the outcomes of the recent requests, true for failed ones.
+	outcomes  []bool
+	tipHeight int
+	lastError string
+}
+
+func newServerHealth() *serverHealth {
+	return &serverHealth{tipHeight: -1}
+}
+
+func (health *serverHealth) record(err error) {
+	health.requests++
+	health.outcomes = append(health.outcomes, err != nil)
+	if len(health.outcomes) > healthWindow {
+		health.outcomes = health.outcomes[1:]
+	}
+	if err != nil {
+		health.errors++
+		health.lastError = err.Error()
+	}
+}
+
+func (health *serverHealth) recordLatency(latency time.Duration) {
+	if health.latency == 0 {
+		health.latency = latency
+		return
+	}
+	health.latency = time.Duration(
+		latencyWeight*float64(latency) + (1-latencyWeight)*float64(health.latency))
+}
+
+func (health *serverHealth) errorRate() float64 {
+	if len(health.outcomes) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, outcome := range health.outcomes {
+		if outcome {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(health.outcomes))
+}
+
+func (health *serverHealth) healthy(bestTipHeight int) bool {
+	if health.errorRate() > maxErrorRate {
+		return false
+	}
+	return health.tipHeight < 0 || bestTipHeight-health.tipHeight <= maxTipLag
+}
+
+// bestTipHeight returns the highest tip height reported by any server. The health lock must be
+// held.
+func (client *RPCClient) bestTipHeight() int {
+	best := -1
+	for _, health := range client.health {
+		if health.tipHeight > best {
+			best = health.tipHeight
+		}
+	}
+	return best
+}
+
+func (client *RPCClient) recordSuccess(backend rpc.Backend, latency time.Duration) {
+	defer client.healthLock.Lock()()
+	health := client.health[backend]
+	health.record(nil)
+	health.recordLatency(latency)
+}
+
+func (client *RPCClient) recordError(backend rpc.Backend, err error) {
+	defer client.healthLock.Lock()()
+	client.health[backend].record(err)
+}
+
+// setActive records the connection in use, nil if there is none.
+func (client *RPCClient) setActive(connection *connection) {
+	defer client.healthLock.Lock()()
+	client.active = connection
+}
+
+// activeBackend returns the backend of the connection in use, or nil if there is none.
+func (client *RPCClient) activeBackend() rpc.Backend {
+	defer client.healthLock.RLock()()
+	if client.active == nil {
+		return nil
+	}
+	return client.active.backend
+}
+
+// orderedBackends returns the backends except the excluded one, healthy ones first. Among those,
+// servers with a lower latency come first, followed by the ones which have not responded yet.
+// Ties are broken randomly to balance the load.
+func (client *RPCClient) orderedBackends(exclude rpc.Backend) []rpc.Backend {
+	defer client.backendsLock.RLock()()
+	defer client.healthLock.RLock()()
+	result := []rpc.Backend{}
+	for _, index := range rand.Perm(len(client.backends)) {
+		if client.backends[index] != exclude {
+			result = append(result, client.backends[index])
+		}
+	}
+	bestTipHeight := client.bestTipHeight()
+	sort.SliceStable(result, func(i, j int) bool {
+		healthI, healthJ := client.health[result[i]], client.health[result[j]]
+		healthyI, healthyJ := healthI.healthy(bestTipHeight), healthJ.healthy(bestTipHeight)
+		if healthyI != healthyJ {
+			return healthyI
+		}
+		if (healthI.latency == 0) != (healthJ.latency == 0) {
+			return healthJ.latency == 0
+		}
+		return healthI.latency < healthJ.latency
+	})
+	return result
+}
+
+// setFailed records the backend of the last failed connection.
+func (client *RPCClient) setFailed(backend rpc.Backend) {
+	defer client.healthLock.Lock()()
+	client.failed = backend
+}
+
+// failoverBackends returns the backends in the order in which a connection is attempted, see
+// orderedBackends(). The backend of the last failed connection is tried last, so that a server
+// which accepts connections but does not respond is not reconnected to right away.
+func (client *RPCClient) failoverBackends() []rpc.Backend {
+	backends := client.orderedBackends(nil)
+	unlock := client.healthLock.RLock()
+	failed := client.failed
+	unlock()
+	for index, backend := range backends {
+		if backend == failed {
+			return append(append(backends[:index:index], backends[index+1:]...), backend)
+		}
+	}
+	return backends
+}
+
+// Servers implements rpc.Pool.
+func (client *RPCClient) Servers() []*rpc.ServerHealth {
+	active := client.activeBackend()
+	defer client.backendsLock.RLock()()
+	defer client.healthLock.RLock()()
+	bestTipHeight := client.bestTipHeight()
+	result := make([]*rpc.ServerHealth, len(client.backends))
+	for index, backend := range client.backends {
+		health := client.health[backend]
+		result[index] = &rpc.ServerHealth{
+			Server:        backend.ServerInfo().Server,
+			Active:        backend == active,
+			LatencyMillis: int64(health.latency / time.Millisecond),
+			Requests:      health.requests,
+			Errors:        health.errors,
+			ErrorRate:     health.errorRate(),
+			TipHeight:     health.tipHeight,
+			LastError:     health.lastError,
+			Healthy:       health.healthy(bestTipHeight),
+		}
+	}
+	return result
+}
+
+// ActiveServer implements rpc.Pool.
+func (client *RPCClient) ActiveServer() string {
+	active := client.activeBackend()
+	if active == nil {
+		return ""
+	}
+	return active.ServerInfo().Server
+}
+
+// SetTipHeight implements rpc.Pool. If the active server lags behind the other servers, the
+// connection is closed to fail over to a healthy server.
+func (client *RPCClient) SetTipHeight(server string, height int) {
+	defer client.backendsLock.RLock()()
+	defer client.healthLock.Lock()()
+	for _, backend := range client.backends {
+		if backend.ServerInfo().Server == server {
+			client.health[backend].tipHeight = height
+		}
+	}
+	if client.active == nil {
+		return
+	}
+	bestTipHeight := client.bestTipHeight()
+	if client.health[client.active.backend].healthy(bestTipHeight) {
+		return
+	}
+	for _, backend := range client.backends {
+		if backend != client.active.backend && client.health[backend].healthy(bestTipHeight) {
+			client.log.WithField("server", client.active.backend.ServerInfo().Server).Info(
+				"Server lags behind, failing over to another server")
+			// The read loop fails, which triggers the failover.
+			_ = client.active.conn.Close()
+			client.active = nil
+			return
+		}
+	}
+}
+
+// QueryOther implements rpc.Pool. The request is sent over a separate connection, which is closed
+// afterwards. The servers are tried in the order of their health.
+func (client *RPCClient) QueryOther(
+	response interface{}, method string, params ...interface{}) (string, error) {
+	for _, backend := range client.orderedBackends(client.activeBackend()) {
+		err := client.query(backend, response, method, params...)
+		if err == nil {
+			return backend.ServerInfo().Server, nil
+		}
+		client.log.WithError(err).WithField("server", backend.ServerInfo().Server).Debug(
+			"Query failed")
+	}
+	return "", errp.New("No other server responded")
+}
+
+// QueryServer implements rpc.Pool. The request is sent over a separate connection, which is closed
+// afterwards.
+func (client *RPCClient) QueryServer(
+	server string, response interface{}, method string, params ...interface{}) error {
+	backend := func() rpc.Backend {
+		defer client.backendsLock.RLock()()
+		for _, backend := range client.backends {
+			if backend.ServerInfo().Server == server {
+				return backend
+			}
+		}
+		return nil
+	}()
+	if backend == nil {
+		return errp.Newf("unknown server %s", server)
+	}
+	return client.query(backend, response, method, params...)
+}
+
+// query sends a single request to the backend over a new connection. The heartbeat request is sent
+// first, as a cheap request the server is expected to answer.
+func (client *RPCClient) query(
+	backend rpc.Backend, response interface{}, method string, params ...interface{}) error {
+	conn, err := backend.EstablishConnection()
+	if err != nil {
+		client.recordError(backend, err)
+		return err
+	}
+	timer := time.AfterFunc(responseTimeout, func() { _ = conn.Close() })
+	defer timer.Stop()
+	defer func() { _ = conn.Close() }()
+
+	start := time.Now()
+	err = func() error {
+		if client.heartBeat != nil {
+			_, jsonText := client.transform(client.heartBeat.method, client.heartBeat.params...)
+			if _, err := conn.Write(jsonText); err != nil {
+				return errp.WithStack(err)
+			}
+		}
+		msgID, jsonText := client.transform(method, params...)
+		if _, err := conn.Write(jsonText); err != nil {
+			return errp.WithStack(err)
+		}
+		return readResponse(bufio.NewReader(conn), msgID, response)
+	}()
+	if err != nil {
+		client.recordError(backend, err)
+		return err
+	}
+	client.recordSuccess(backend, time.Since(start))
+	return nil
+}
+
+// readResponse reads lines until the response with the given ID and unmarshals its result.
+func readResponse(reader *bufio.Reader, msgID int, response interface{}) error {
+	for {
+		line, err := reader.ReadBytes(byte('\n'))
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return errp.WithStack(err)
+		}
+		var message struct {
+			ID     *int             `json:"id"`
+			Error  *json.RawMessage `json:"error"`
+			Result json.RawMessage  `json:"result"`
+		}
+		if err := json.Unmarshal(line, &message); err != nil {
+			return errp.Wrap(err, "Failed to unmarshal response")
+		}
+		if message.ID == nil || *message.ID != msgID {
+			continue
+		}
+		if message.Error != nil {
+			return errp.Newf("Server error: %s", string(*message.Error))
+		}
+		if response == nil {
+			return nil
+		}
+		return errp.WithStack(json.Unmarshal(message.Result, response))
+	}
+}
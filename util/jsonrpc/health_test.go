@@ -0,0 +1,144 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonrpc_test
+
+import (
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc/test"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBackend(t *testing.T, name string, tipHeight int) *test.Server {
+	server := test.NewServer(t, name)
+	server.SetResult("blockchain.headers.subscribe", map[string]int{"block_height": tipHeight})
+	return server
+}
+
+func newTestClient(backends ...*test.Server) *jsonrpc.RPCClient {
+	rpcBackends := make([]rpc.Backend, len(backends))
+	for index, backend := range backends {
+		rpcBackends[index] = backend
+	}
+	client := jsonrpc.NewRPCClient(rpcBackends, logging.Get().WithGroup("jsonrpc_test"))
+	client.OnConnect(func() error { return nil })
+	client.RegisterHeartbeat("server.version")
+	return client
+}
+
+func findServer(servers []*rpc.ServerHealth, name string) *rpc.ServerHealth {
+	for _, server := range servers {
+		if server.Server == name {
+			return server
+		}
+	}
+	return nil
+}
+
+func TestServers(t *testing.T) {
+	down := newTestBackend(t, "down", 100)
+	up := newTestBackend(t, "up", 100)
+	client := newTestClient(down, up)
+
+	down.SetDown(true)
+	up.SetDown(true)
+	require.Equal(t, rpc.DISCONNECTED, client.ConnectionStatus())
+	require.Equal(t, "", client.ActiveServer())
+
+	up.SetDown(false)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, client.MethodSync(nil, "server.version"))
+	}
+	require.Equal(t, "up", client.ActiveServer())
+
+	servers := client.Servers()
+	require.Len(t, servers, 2)
+	upHealth := findServer(servers, "up")
+	require.True(t, upHealth.Active)
+	require.True(t, upHealth.Healthy)
+	require.Equal(t, 4, upHealth.Requests)
+	require.Equal(t, 1, upHealth.Errors)
+	require.Equal(t, 0.25, upHealth.ErrorRate)
+	require.Equal(t, -1, upHealth.TipHeight)
+
+	downHealth := findServer(servers, "down")
+	require.False(t, downHealth.Active)
+	require.False(t, downHealth.Healthy)
+	require.NotZero(t, downHealth.Errors)
+	require.Equal(t, downHealth.Requests, downHealth.Errors)
+	require.Equal(t, "connection refused", downHealth.LastError)
+}
+
+func TestQueryOther(t *testing.T) {
+	first := newTestBackend(t, "first", 100)
+	second := newTestBackend(t, "second", 101)
+	client := newTestClient(first, second)
+
+	require.NoError(t, client.MethodSync(nil, "server.version"))
+	active := client.ActiveServer()
+	response := struct {
+		BlockHeight int `json:"block_height"`
+	}{}
+	server, err := client.QueryOther(&response, "blockchain.headers.subscribe")
+	require.NoError(t, err)
+	require.NotEqual(t, active, server)
+	if server == "first" {
+		require.Equal(t, 100, response.BlockHeight)
+	} else {
+		require.Equal(t, 101, response.BlockHeight)
+	}
+	require.Equal(t, 1, findServer(client.Servers(), server).Requests)
+	// The active connection is kept.
+	require.Equal(t, active, client.ActiveServer())
+
+	require.NoError(t, client.QueryServer(server, &response, "blockchain.headers.subscribe"))
+	require.Equal(t, 2, findServer(client.Servers(), server).Requests)
+	require.Error(t, client.QueryServer("unknown", &response, "blockchain.headers.subscribe"))
+
+	second.SetDown(true)
+	first.SetDown(true)
+	_, err = client.QueryOther(&response, "blockchain.headers.subscribe")
+	require.Error(t, err)
+}
+
+func TestLaggingServerFailover(t *testing.T) {
+	first := newTestBackend(t, "first", 100)
+	second := newTestBackend(t, "second", 100)
+	client := newTestClient(first, second)
+
+	require.NoError(t, client.MethodSync(nil, "server.version"))
+	active := client.ActiveServer()
+	other := "first"
+	if active == "first" {
+		other = "second"
+	}
+
+	// Within the tolerance, the active server is kept.
+	client.SetTipHeight(other, 102)
+	client.SetTipHeight(active, 100)
+	require.Equal(t, active, client.ActiveServer())
+	require.True(t, findServer(client.Servers(), active).Healthy)
+
+	client.SetTipHeight(active, 90)
+	require.False(t, findServer(client.Servers(), active).Healthy)
+	// The connection to the lagging server is closed right away, and the next request is sent to
+	// the other server.
+	require.Equal(t, "", client.ActiveServer())
+	require.NoError(t, client.MethodSync(nil, "server.version"))
+	require.Equal(t, other, client.ActiveServer())
+}
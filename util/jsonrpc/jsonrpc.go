@@ -16,6 +16,7 @@ package jsonrpc
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -35,6 +36,8 @@ func init() {
 }
 
 const (
+	// responseTimeout is the default time to wait for the response to a request, see
+	// SetMethodTimeout().
 	responseTimeout = 30 * time.Second
 )
 
@@ -45,6 +48,9 @@ type callbacks struct {
 	setupAndTeardown func() func()
 	// cleanup will be called after the response has been received.
 	cleanup func()
+	// failure, if not nil, is called instead of success if the request times out. Requests without
+	// a failure callback are resent after the failover to another backend.
+	failure func(error)
 }
 
 // SocketError indicates an error when reading from or writing to a network socket.
@@ -76,6 +82,15 @@ type request struct {
 	method            string
 	params            []interface{}
 	jsonText          []byte
+	// sent is the time at which the request was last sent, to measure the latency of the server.
+	sent time.Time
+	// connection is the connection the request was last sent over, nil if it was not sent yet.
+	connection *connection
+	timeout    time.Duration
+	// timer fails the request if there is no response within the timeout.
+	timer *time.Timer
+	// batched is true if the request was last sent in a batch.
+	batched bool
 }
 
 type heartBeat struct {
@@ -86,23 +101,43 @@ type heartBeat struct {
 // RPCClient is a generic json rpc client, which is able to invoke remote methods and subscribe to
 // remote notifications.
 type RPCClient struct {
-	connection *connection
-	connLock   locker.Locker
+	// connection is the established connection, or nil. connectionLock guards the field, while
+	// connLock is held while establishing a new connection.
+	connection     *connection
+	connectionLock locker.Locker
+	connLock       locker.Locker
 
 	backends     []rpc.Backend
 	backendsLock locker.Locker
 
+	// health is the health of each backend.
+	health map[rpc.Backend]*serverHealth
+	// active is the connection in use, to report the health without locking connLock.
+	active *connection
+	// failed is the backend of the last failed connection.
+	failed     rpc.Backend
+	healthLock locker.Locker
+
 	pendingRequests     map[int]*request
 	pendingRequestsLock locker.Locker
 
+	// queue holds the IDs of the prepared requests which are sent in the next batch.
+	queue     []int
+	queueLock locker.Locker
+	// batchesRejected are the backends which rejected a batch. Requests are sent to them one by
+	// one.
+	batchesRejected     map[rpc.Backend]bool
+	batchesRejectedLock locker.Locker
+
+	methodTimeouts     map[string]time.Duration
+	methodTimeoutsLock locker.Locker
+
 	pingRequests     map[int]bool
 	pingRequestsLock locker.Locker
 
 	subscriptionRequests     []*request
 	subscriptionRequestsLock locker.Locker
 
-	retryLock locker.Locker
-
 	status                              rpc.Status
 	onConnectionStatusChangesNotify     []func(rpc.Status)
 	onConnectionStatusChangesNotifyLock locker.Locker
@@ -124,15 +159,21 @@ type RPCClient struct {
 func NewRPCClient(backends []rpc.Backend, log *logrus.Entry) *RPCClient {
 	client := &RPCClient{
 		backends:                        backends,
+		health:                          map[rpc.Backend]*serverHealth{},
+		batchesRejected:                 map[rpc.Backend]bool{},
 		msgID:                           0,
 		status:                          rpc.CONNECTED,
 		onConnectionStatusChangesNotify: []func(rpc.Status){},
 		pendingRequests:                 map[int]*request{},
+		methodTimeouts:                  map[string]time.Duration{},
 		pingRequests:                    map[int]bool{},
 		subscriptionRequests:            []*request{},
 		notificationsCallbacks:          map[string][]func([]byte){},
 		log:                             log,
 	}
+	for _, backend := range backends {
+		client.health[backend] = newServerHealth()
+	}
 	return client
 }
 
@@ -157,7 +198,8 @@ func (client *RPCClient) requeueSubscriptions() {
 	defer client.subscriptionRequestsLock.Lock()()
 	client.log.Debugf("Got %v subscriptions that need to be resubscribed", len(client.subscriptionRequests))
 	for _, r := range client.subscriptionRequests {
-		client.prepare(r.responseCallbacks.success, r.responseCallbacks.setupAndTeardown, r.method, r.params...)
+		client.prepare(r.responseCallbacks.success, r.responseCallbacks.setupAndTeardown,
+			r.responseCallbacks.failure, r.method, r.params...)
 	}
 	client.subscriptionRequests = []*request{}
 }
@@ -165,11 +207,20 @@ func (client *RPCClient) requeueSubscriptions() {
 func (client *RPCClient) resendPendingRequests() {
 	defer client.pendingRequestsLock.RLock()()
 	client.log.Debugf("Queueing %v pending requests to resend.", len(client.pendingRequests))
+	msgIDs := make([]int, 0, len(client.pendingRequests))
+	for msgID := range client.pendingRequests {
+		msgIDs = append(msgIDs, msgID)
+	}
 	// This needs to be executed in a go-routine so that it doesn't block if the connection fails
 	// and a failover is initiated.
 	go func() {
-		for _, request := range client.pendingRequests {
-			err := client.send(request.jsonText)
+		for len(msgIDs) > 0 {
+			batch := msgIDs
+			if len(batch) > maxBatchSize {
+				batch = batch[:maxBatchSize]
+			}
+			msgIDs = msgIDs[len(batch):]
+			err := client.write(batch)
 			if err != nil {
 				wait := time.Minute / 4
 				client.log.Debugf("Resending failed. Waiting for %v", wait)
@@ -188,14 +239,20 @@ func (client *RPCClient) resendPendingRequests() {
 // connection and tries to issue pending methods via another connection.
 func (client *RPCClient) resendPendingRequestsAndSubscriptions(failed *connection) {
 	alreadyHandled := func() bool {
-		defer client.retryLock.Lock()()
-		return client.connection != failed
+		defer client.connectionLock.Lock()()
+		if client.connection != failed {
+			return true
+		}
+		client.connection = nil
+		return false
 	}
 	if alreadyHandled() {
 		return
 	}
-	client.connection = nil
+	client.setActive(nil)
 	if failed != nil {
+		client.recordError(failed.backend, errp.New("Connection failed"))
+		client.setFailed(failed.backend)
 		client.log.Debugf("Backend %v failed. Trying to re-subscribe and send pending requests via another connection", failed.backend.ServerInfo().Server)
 	} else {
 		// in case socket error does not have any information about the connection, for example
@@ -242,15 +299,20 @@ func (client *RPCClient) read(connection *connection, success func(*connection,
 // separate go routine to listen for incoming data.
 func (client *RPCClient) establishConnection(backend rpc.Backend) error {
 	conn, err := backend.EstablishConnection()
-	client.log = client.log.WithField("backend", backend.ServerInfo().Server)
+	log := client.log.WithField("backend", backend.ServerInfo().Server)
 	if err != nil {
+		client.recordError(backend, err)
 		return err
 	}
-	client.log.Debugf("Established connection to backend")
-	client.connection = &connection{conn, backend}
-	go client.read(client.connection, client.handleResponse)
+	log.Debugf("Established connection to backend")
+	newConnection := &connection{conn, backend}
+	unlock := client.connectionLock.Lock()
+	client.connection = newConnection
+	unlock()
+	client.setActive(newConnection)
+	go client.read(newConnection, client.handleResponse)
 	if err := client.onConnectCallback(); err != nil {
-		client.log.WithError(err).Error("Error happened in connect callback")
+		log.WithError(err).Error("Error happened in connect callback")
 		return err
 	}
 	go client.ping()
@@ -272,51 +334,60 @@ func (client *RPCClient) setStatus(status rpc.Status) {
 
 // conn returns either the currently active connection or, if none was found, establishes a new connection
 // to any of the configured backends.
-// The selection process is randomized, to balance the load between multiple backends for multiple
-// desktop applications, but we store the active connection and ping it regularly to
-// keep it alive (see ping()).
+// Healthy backends are preferred (see failoverBackends()). The selection process is randomized, to
+// balance the load between multiple backends for multiple desktop applications, but we store the
+// active connection and ping it regularly to keep it alive (see ping()).
 func (client *RPCClient) conn() (*connection, error) {
-	if client.connection == nil {
-		defer client.connLock.Lock()()
-		if client.connection == nil {
-			defer client.backendsLock.RLock()()
-			start := 0
-			if len(client.backends) > 0 {
-				start = rand.Intn(len(client.backends))
-			}
-			for i := 0; i < len(client.backends); i++ {
-				client.log.Debugf("Trying to connect to backend %v", client.backends[start].ServerInfo().Server)
-				err := client.establishConnection(client.backends[start])
-				if err != nil {
-					client.log.WithError(err).Info("Failover: backend is down")
-					start = (start + 1) % len(client.backends)
-				} else {
-					client.log.Debug("Successfully connected to backend")
-					break
-				}
-			}
-			if client.connection == nil {
-				client.log = client.log.WithField("backend", "offline")
-				// tried all backends
-				client.setStatus(rpc.DISCONNECTED)
-				return nil, errp.Newf("Disconnected from all backends")
-			}
-			go client.setStatus(rpc.CONNECTED)
+	// The connect callback sends requests over the new connection while connLock is held, so the
+	// established connection is returned without locking connLock.
+	if conn := client.currentConnection(); conn != nil {
+		return conn, nil
+	}
+	defer client.connLock.Lock()()
+	if conn := client.currentConnection(); conn != nil {
+		return conn, nil
+	}
+	for _, backend := range client.failoverBackends() {
+		client.log.Debugf("Trying to connect to backend %v", backend.ServerInfo().Server)
+		err := client.establishConnection(backend)
+		if err != nil {
+			client.log.WithError(err).Info("Failover: backend is down")
+		} else {
+			client.log.Debug("Successfully connected to backend")
+			break
 		}
 	}
-	return client.connection, nil
+	conn := client.currentConnection()
+	if conn == nil {
+		client.log.WithField("backend", "offline").Debug("Tried all backends")
+		client.setStatus(rpc.DISCONNECTED)
+		return nil, errp.Newf("Disconnected from all backends")
+	}
+	go client.setStatus(rpc.CONNECTED)
+	return conn, nil
+}
+
+// currentConnection returns the established connection, or nil if there is none.
+func (client *RPCClient) currentConnection() *connection {
+	defer client.connectionLock.RLock()()
+	return client.connection
 }
 
 // cleanupFinishedRequest removes the finished request from the collection of pending requests
 // and collects the subscription requests. It blocks resendPendingRequests(), and if it is a
-// subscription request, resubscribe() and conn().
+// subscription request, resubscribe().
 // If resubscribe() is already running, the subscription request will remain a pending request and
 // be executed in the resendPendingRequest() function.
 // If resendPendingRequest() is already running, the pending requests are executed again but the
 // response is ignored because the request already finished with the previous connection.
 func (client *RPCClient) cleanupFinishedRequest(conn *connection, responseID int) {
 	defer client.pendingRequestsLock.Lock()()
-	finishedRequest := client.pendingRequests[responseID]
+	finishedRequest, ok := client.pendingRequests[responseID]
+	if !ok {
+		// The request timed out in the meantime.
+		return
+	}
+	finishedRequest.timer.Stop()
 	finishedRequest.responseCallbacks.cleanup()
 	if client.isSubscriptionRequest(finishedRequest.method) {
 		func() {
@@ -324,8 +395,7 @@ func (client *RPCClient) cleanupFinishedRequest(conn *connection, responseID int
 			// if connection is still up and running we add it to the list of subscription requests and
 			// remove it from the collection of pending requests.
 			// Otherwise it remains in the collection of pending requests.
-			defer client.connLock.Lock()()
-			if client.connection == conn {
+			if client.currentConnection() == conn {
 				client.subscriptionRequests = append(client.subscriptionRequests, finishedRequest)
 				delete(client.pendingRequests, responseID)
 			}
@@ -340,6 +410,18 @@ func (client *RPCClient) cleanupFinishedRequest(conn *connection, responseID int
 func (client *RPCClient) handleResponse(conn *connection, responseBytes []byte) {
 	// fmt.Println("got response ", string(responseBytes))
 
+	if batch := bytes.TrimSpace(responseBytes); len(batch) > 0 && batch[0] == '[' {
+		responses := []json.RawMessage{}
+		if err := json.Unmarshal(batch, &responses); err != nil {
+			// panic will be caught in read() and subscribed connections will be re-subscribed
+			panic(&ResponseError{errp.Wrap(err, "Failed to unmarshal batch response")})
+		}
+		for _, response := range responses {
+			client.handleResponse(conn, response)
+		}
+		return
+	}
+
 	// Catch all response.
 	// A notification contains:
 	// - jsonrpc
@@ -377,10 +459,20 @@ func (client *RPCClient) handleResponse(conn *connection, responseBytes []byte)
 		return errStruct.Message
 	}
 
+	// A server not supporting batches responds to a batch with an error without an ID.
+	if response.ID == nil && response.Method == nil && response.Error != nil {
+		client.batchRejected(conn, parseError(*response.Error))
+		return
+	}
+
 	// Handle method response.
 	if response.ID != nil {
 		runlock := client.pendingRequestsLock.RLock()
 		pendingRequest, ok := client.pendingRequests[*response.ID]
+		var sent time.Time
+		if ok {
+			sent = pendingRequest.sent
+		}
 		runlock()
 		var responseError *ResponseError
 		if ok {
@@ -393,8 +485,10 @@ func (client *RPCClient) handleResponse(conn *connection, responseBytes []byte)
 				responseError = &ResponseError{errp.Cause(err)}
 			}
 			if responseError != nil {
+				client.recordError(conn.backend, responseError)
 				panic(responseError)
 			}
+			client.recordSuccess(conn.backend, time.Since(sent))
 			defer client.cleanupFinishedRequest(conn, *response.ID)
 		} else {
 			unlock := client.pingRequestsLock.Lock()
@@ -490,13 +584,15 @@ func (client *RPCClient) transform(
 	}), byte('\n'))
 }
 
-// prepare ...
+// prepare registers a pending request, which fails after the timeout of the method unless a
+// response arrives. It returns the ID of the request.
 func (client *RPCClient) prepare(
 	success func([]byte) error,
 	setupAndTeardown func() func(),
+	failure func(error),
 	method string,
 	params ...interface{},
-) []byte {
+) int {
 	// Ideally, we should have a worker thread that processes a "to be send" list.
 	cleanup := func() {}
 	if setupAndTeardown != nil {
@@ -509,49 +605,57 @@ func (client *RPCClient) prepare(
 	defer client.log.Debugf("Releasing pending request lock (prepare).")
 	defer client.pendingRequestsLock.Lock()()
 	client.log.Debugf("Prepared: %v", string(jsonText))
+	timeout := client.methodTimeout(method)
 	client.pendingRequests[msgID] = &request{
-		callbacks{
+		responseCallbacks: callbacks{
 			success:          success,
 			setupAndTeardown: setupAndTeardown,
 			cleanup:          cleanup,
+			failure:          failure,
 		},
-		method,
-		params,
-		jsonText,
+		method:   method,
+		params:   params,
+		jsonText: jsonText,
+		sent:     time.Now(),
+		timeout:  timeout,
+		timer:    time.AfterFunc(timeout, func() { client.timeout(msgID) }),
 	}
-	return jsonText
+	return msgID
 }
 
 // Method sends invokes the remote method with the provided parameters. Before the request is send,
 // the setupAndTeardown callback is executed and the return value is stored as cleanup callback with the pending request.
 // The success callback is called with the response. cleanup is called afterwards.
+// Requests are queued shortly and sent together in a batch (see enqueue()). If the server does not
+// respond within the timeout of the method, the client fails over to another backend. Without a
+// failure callback, the request is resent. Otherwise, the failure callback is called with a
+// *TimeoutError, followed by cleanup.
 func (client *RPCClient) Method(
 	success func([]byte) error,
 	setupAndTeardown func() func(),
+	failure func(error),
 	method string,
 	params ...interface{},
 ) {
-	jsonText := client.prepare(success, setupAndTeardown, method, params...)
-	err := client.send(jsonText)
-	if err != nil {
-		client.log.Debugf("Resend triggered in Method (%v)", method)
-		go client.resendPendingRequestsAndSubscriptions(err.connection)
-	}
+	client.enqueue(client.prepare(success, setupAndTeardown, failure, method, params...))
 }
 
 // MethodSync is the same as method, but blocks until the response is available. The result is
-// json-deserialized into response.
+// json-deserialized into response. If the server does not respond within the timeout of the method,
+// a *TimeoutError is returned.
 func (client *RPCClient) MethodSync(response interface{}, method string, params ...interface{}) error {
-	responseChan := make(chan []byte)
-	errChan := make(chan error)
+	// Buffered, as the response can arrive concurrently to the timeout.
+	responseChan := make(chan []byte, 1)
+	errChan := make(chan error, 1)
 
-	client.Method(
+	client.enqueue(client.prepare(
 		func(responseBytes []byte) error {
 			responseChan <- responseBytes
 			return nil
 		},
 		func() func() { return func() {} },
-		method, params...)
+		func(err error) { errChan <- err },
+		method, params...))
 	select {
 	case err := <-errChan:
 		return err
@@ -561,8 +665,6 @@ func (client *RPCClient) MethodSync(response interface{}, method string, params
 				return &ResponseError{errp.Wrap(err, fmt.Sprintf("Failed to unmarshal response: %v", string(responseBytes)))}
 			}
 		}
-	case <-time.After(responseTimeout):
-		return &SocketError{errp.New("response timeout"), nil}
 	}
 	return nil
 }
@@ -0,0 +1,119 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package test provides a fake server for testing the clients of the jsonrpc package.
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+)
+
+// Server is a JSON-RPC server over TCP answering each method with a fixed result. It implements
+// rpc.Backend.
+type Server struct {
+	name     string
+	listener net.Listener
+
+	lock    locker.Locker
+	results map[string]interface{}
+	down    bool
+}
+
+// NewServer starts a server, which answers server.version with its name.
+func NewServer(t *testing.T, name string) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &Server{
+		name:     name,
+		listener: listener,
+		results:  map[string]interface{}{"server.version": []string{name, "1.2"}},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// SetResult sets the result with which the method is answered. Methods without a result are
+// answered with null.
+func (server *Server) SetResult(method string, result interface{}) {
+	defer server.lock.Lock()()
+	server.results[method] = result
+}
+
+// SetDown makes new connections to the server fail while down is true.
+func (server *Server) SetDown(down bool) {
+	defer server.lock.Lock()()
+	server.down = down
+}
+
+func (server *Server) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		request := struct {
+			ID     int    `json:"id"`
+			Method string `json:"method"`
+		}{}
+		if err := json.Unmarshal(line, &request); err != nil {
+			return
+		}
+		result := func() interface{} {
+			defer server.lock.RLock()()
+			return server.results[request.Method]
+		}()
+		response, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      request.ID,
+			"result":  result,
+		})
+		if _, err := conn.Write(append(response, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+// EstablishConnection implements rpc.Backend.
+func (server *Server) EstablishConnection() (io.ReadWriteCloser, error) {
+	defer server.lock.RLock()()
+	if server.down {
+		return nil, errors.New("connection refused")
+	}
+	return net.Dial("tcp", server.listener.Addr().String())
+}
+
+// ServerInfo implements rpc.Backend.
+func (server *Server) ServerInfo() *rpc.ServerInfo {
+	return &rpc.ServerInfo{Server: server.name}
+}
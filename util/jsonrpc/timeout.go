@@ -0,0 +1,84 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonrpc
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeoutError indicates that the server did not respond to a request within the timeout of its
+// method.
+type TimeoutError struct {
+	Method  string
+	Timeout time.Duration
+}
+
+func (err *TimeoutError) Error() string {
+	return fmt.Sprintf("no response to %s within %v", err.Method, err.Timeout)
+}
+
+// SetMethodTimeout sets the time to wait for the response to requests of the given method. The
+// default is 30 seconds.
+func (client *RPCClient) SetMethodTimeout(method string, timeout time.Duration) {
+	defer client.methodTimeoutsLock.Lock()()
+	client.methodTimeouts[method] = timeout
+}
+
+func (client *RPCClient) methodTimeout(method string) time.Duration {
+	defer client.methodTimeoutsLock.RLock()()
+	if timeout, ok := client.methodTimeouts[method]; ok {
+		return timeout
+	}
+	return responseTimeout
+}
+
+// timeout is called when the timer of the request with the given ID fires. Requests with a failure
+// callback fail with a *TimeoutError. If the request was sent over the active connection, the
+// connection is closed to fail over to another backend, over which the remaining pending requests
+// are resent.
+func (client *RPCClient) timeout(msgID int) {
+	unlock := client.pendingRequestsLock.Lock()
+	request, ok := client.pendingRequests[msgID]
+	if !ok || time.Since(request.sent) < request.timeout {
+		// Answered or resent in the meantime.
+		unlock()
+		return
+	}
+	err := &TimeoutError{Method: request.method, Timeout: request.timeout}
+	failure := request.responseCallbacks.failure
+	if failure != nil {
+		delete(client.pendingRequests, msgID)
+		request.responseCallbacks.cleanup()
+	}
+	conn := request.connection
+	unlock()
+
+	client.log.WithError(err).Warning("Request timed out")
+	if failure != nil {
+		failure(err)
+	}
+	if conn == nil {
+		return
+	}
+	client.recordError(conn.backend, err)
+	defer client.healthLock.Lock()()
+	if client.active != conn {
+		return
+	}
+	// The read loop fails, which triggers the failover.
+	_ = conn.conn.Close()
+	client.active = nil
+}
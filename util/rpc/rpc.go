@@ -30,7 +30,7 @@ const (
 
 // Client describes the methods needed to communicate with an RPC server.
 type Client interface {
-	Method(func([]byte) error, func() func(), string, ...interface{})
+	Method(func([]byte) error, func() func(), func(error), string, ...interface{})
 	MethodSync(interface{}, string, ...interface{}) error
 	SubscribeNotifications(string, func([]byte))
 	Close()
@@ -41,11 +41,60 @@ type Client interface {
 	RegisterOnConnectionStatusChangedEvent(func(Status))
 }
 
+const (
+	// VerificationPEMCert verifies the TLS certificate of a server against ServerInfo.PEMCert.
+	VerificationPEMCert = ""
+	// VerificationTOFU pins the fingerprint of the TLS certificate of a server on the first
+	// connection (trust on first use). Connections are rejected if the certificate changes.
+	VerificationTOFU = "tofu"
+	// VerificationCA verifies the TLS certificate of a server against the certificate authorities
+	// trusted by the system, including the hostname.
+	VerificationCA = "ca"
+)
+
 // ServerInfo holds information about the backend server(s).
 type ServerInfo struct {
 	Server  string `json:"server"`
 	TLS     bool   `json:"tls"`
 	PEMCert string `json:"pemCert"`
+	// Verification is how the TLS certificate is verified, one of the Verification* constants.
+	Verification string `json:"verification,omitempty"`
+	// Fingerprint is the hex encoded SHA256 hash of the certificate pinned with VerificationTOFU,
+	// or empty if none has been pinned yet.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// ServerHealth describes the health of a server of a pool, as observed by the client.
+type ServerHealth struct {
+	Server string `json:"server"`
+	// Active is true for the server the client is connected to.
+	Active bool `json:"active"`
+	// LatencyMillis is the moving average of the response times, 0 if unknown.
+	LatencyMillis int64 `json:"latencyMillis"`
+	Requests      int   `json:"requests"`
+	Errors        int   `json:"errors"`
+	// ErrorRate is the share of failed requests among the recent requests.
+	ErrorRate float64 `json:"errorRate"`
+	// TipHeight is the last reported tip height of the server, -1 if unknown.
+	TipHeight int    `json:"tipHeight"`
+	LastError string `json:"lastError,omitempty"`
+	// Healthy is false if the server fails too many requests or lags behind the other servers.
+	Healthy bool `json:"healthy"`
+}
+
+// Pool describes the methods of clients which connect to one of several servers and track their
+// health.
+type Pool interface {
+	Servers() []*ServerHealth
+	// ActiveServer returns the server the client is connected to, or "" if it is offline.
+	ActiveServer() string
+	// SetTipHeight records the tip height reported by a server.
+	SetTipHeight(server string, height int)
+	// QueryOther sends a request to a server other than the active one and returns the server
+	// which responded.
+	QueryOther(response interface{}, method string, params ...interface{}) (string, error)
+	// QueryServer sends a request to the given server.
+	QueryServer(server string, response interface{}, method string, params ...interface{}) error
 }
 
 // Backend describes the methods provided to connect to an RPC backend
@@ -0,0 +1,148 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package socksproxy routes outbound connections through a SOCKS5 proxy, e.g. a Tor daemon.
+package socksproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// DefaultTorAddress is the SOCKS5 port of a local Tor daemon.
+	DefaultTorAddress = "127.0.0.1:9050"
+
+	dialTimeout = 30 * time.Second
+)
+
+// Config configures the proxy used for all outbound connections.
+type Config struct {
+	// UseProxy routes all outbound connections through the SOCKS5 proxy at ProxyAddress.
+	UseProxy bool `json:"useProxy"`
+	// ProxyAddress is the host:port of the SOCKS5 proxy.
+	ProxyAddress string `json:"proxyAddress"`
+	// Username and Password authenticate with the proxy if Username is not empty.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Tor routes all outbound connections through Tor. It implies UseProxy and ProxyRequired, and
+	// ProxyAddress defaults to DefaultTorAddress.
+	Tor bool `json:"tor"`
+	// ProxyRequired makes outbound connections fail if the proxy is not enabled, instead of
+	// connecting directly.
+	ProxyRequired bool `json:"proxyRequired"`
+}
+
+// SocksProxy dials outbound connections, either through the configured proxy or directly. Names
+// are resolved by the proxy, so that no DNS requests leak.
+type SocksProxy struct {
+	config Config
+	// dialer is the SOCKS5 dialer, or nil if connections are made directly.
+	dialer proxy.Dialer
+	// err is returned for every connection if the proxy is required but not usable.
+	err error
+}
+
+// NewSocksProxy creates a new SocksProxy with the given configuration.
+func NewSocksProxy(config Config) *SocksProxy {
+	socksProxy := &SocksProxy{config: config}
+	useProxy := config.UseProxy || config.Tor
+	proxyRequired := config.ProxyRequired || config.Tor
+	address := config.ProxyAddress
+	if address == "" && config.Tor {
+		address = DefaultTorAddress
+	}
+	switch {
+	case useProxy && address == "":
+		socksProxy.err = errp.New("No proxy address configured")
+	case useProxy:
+		var auth *proxy.Auth
+		if config.Username != "" {
+			auth = &proxy.Auth{User: config.Username, Password: config.Password}
+		}
+		dialer, err := proxy.SOCKS5("tcp", address, auth, &net.Dialer{Timeout: dialTimeout})
+		if err != nil {
+			socksProxy.err = errp.WithStack(err)
+		} else {
+			socksProxy.dialer = dialer
+		}
+	case proxyRequired:
+		socksProxy.err = errp.New("A proxy is required, but it is not enabled")
+	}
+	return socksProxy
+}
+
+// Direct returns true if connections are made directly. It is false if the proxy is enabled, or
+// if it is required but not usable, in which case all connections fail.
+func (socksProxy *SocksProxy) Direct() bool {
+	return socksProxy.dialer == nil && socksProxy.err == nil
+}
+
+// isLoopback returns true if the host is the local machine, e.g. a local full node.
+func isLoopback(host string) bool {
+	if strings.ToLower(host) == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Dial connects to the address through the proxy, or directly if the proxy is not enabled.
+// Connections to the local machine do not leave it and are always made directly. Onion addresses
+// can only be reached through the proxy.
+func (socksProxy *SocksProxy) Dial(network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if isLoopback(host) {
+		conn, err := net.DialTimeout(network, address, dialTimeout)
+		return conn, errp.WithStack(err)
+	}
+	if socksProxy.err != nil {
+		return nil, socksProxy.err
+	}
+	if socksProxy.dialer == nil {
+		if strings.HasSuffix(strings.ToLower(host), ".onion") {
+			return nil, errp.Newf("Cannot connect to %s without a Tor proxy", address)
+		}
+		conn, err := net.DialTimeout(network, address, dialTimeout)
+		return conn, errp.WithStack(err)
+	}
+	conn, err := socksProxy.dialer.Dial(network, address)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to connect through the proxy")
+	}
+	return conn, nil
+}
+
+// HTTPClient returns an HTTP client which makes its connections with Dial. Proxy settings from
+// the environment are ignored.
+func (socksProxy *SocksProxy) HTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, network, address string) (net.Conn, error) {
+				return socksProxy.Dial(network, address)
+			},
+			TLSHandshakeTimeout: dialTimeout,
+		},
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socksproxy_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy/test"
+	"github.com/stretchr/testify/require"
+)
+
+// newEchoServer returns the address of a server which echoes the first line it receives.
+func newEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				line, err := bufio.NewReader(conn).ReadBytes('\n')
+				if err != nil {
+					return
+				}
+				_, _ = conn.Write(line)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func echo(t *testing.T, conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err := conn.Write([]byte("ping\n"))
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "ping\n", line)
+}
+
+func TestDial(t *testing.T) {
+	proxy := test.NewProxy(t, map[string]string{"electrum.example:50001": newEchoServer(t)})
+	socksProxy := socksproxy.NewSocksProxy(socksproxy.Config{
+		UseProxy:     true,
+		ProxyAddress: proxy.Address(),
+	})
+	require.False(t, socksProxy.Direct())
+	conn, err := socksProxy.Dial("tcp", "electrum.example:50001")
+	require.NoError(t, err)
+	echo(t, conn)
+	// The name is resolved by the proxy.
+	require.Equal(t, []string{"electrum.example:50001"}, proxy.Requests())
+
+	_, err = socksProxy.Dial("tcp", "unknown.example:50001")
+	require.Error(t, err)
+}
+
+func TestAuthentication(t *testing.T) {
+	proxy := test.NewProxy(t, map[string]string{"electrum.example:50001": newEchoServer(t)})
+	proxy.SetAuth("user", "secret")
+
+	config := socksproxy.Config{
+		UseProxy:     true,
+		ProxyAddress: proxy.Address(),
+		Username:     "user",
+		Password:     "wrong",
+	}
+	_, err := socksproxy.NewSocksProxy(config).Dial("tcp", "electrum.example:50001")
+	require.Error(t, err)
+
+	config.Password = "secret"
+	conn, err := socksproxy.NewSocksProxy(config).Dial("tcp", "electrum.example:50001")
+	require.NoError(t, err)
+	echo(t, conn)
+}
+
+func TestOnion(t *testing.T) {
+	const onion = "electrumxhqdsmlu.onion:50001"
+	proxy := test.NewProxy(t, map[string]string{onion: newEchoServer(t)})
+	conn, err := socksproxy.NewSocksProxy(socksproxy.Config{
+		Tor:          true,
+		ProxyAddress: proxy.Address(),
+	}).Dial("tcp", onion)
+	require.NoError(t, err)
+	echo(t, conn)
+
+	_, err = socksproxy.NewSocksProxy(socksproxy.Config{}).Dial("tcp", onion)
+	require.Error(t, err)
+}
+
+func TestProxyRequired(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	for _, config := range []socksproxy.Config{
+		{ProxyRequired: true},
+		{ProxyRequired: true, ProxyAddress: "127.0.0.1:9"},
+		{UseProxy: true},
+	} {
+		socksProxy := socksproxy.NewSocksProxy(config)
+		require.False(t, socksProxy.Direct())
+		_, err := socksProxy.Dial("tcp", "example.com:80")
+		require.Error(t, err)
+		// Connections to the local machine do not leave it and are allowed.
+		response, err := socksProxy.HTTPClient(time.Second).Get(server.URL)
+		require.NoError(t, err)
+		_ = response.Body.Close()
+	}
+	require.True(t, requested)
+}
+
+func TestProxyDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	// The connection fails instead of bypassing the proxy.
+	_, err = socksproxy.NewSocksProxy(socksproxy.Config{
+		UseProxy:     true,
+		ProxyAddress: address,
+	}).Dial("tcp", "example.com:80")
+	require.Error(t, err)
+}
+
+func TestHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = fmt.Fprintf(writer, "host %s", request.Host)
+	}))
+	defer server.Close()
+	proxy := test.NewProxy(t, map[string]string{"rates.example:80": server.Listener.Addr().String()})
+
+	socksProxy := socksproxy.NewSocksProxy(socksproxy.Config{
+		UseProxy:     true,
+		ProxyAddress: proxy.Address(),
+	})
+	response, err := socksProxy.HTTPClient(5 * time.Second).Get("http://rates.example/")
+	require.NoError(t, err)
+	defer func() { _ = response.Body.Close() }()
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, "host rates.example", string(body))
+	require.Equal(t, []string{"rates.example:80"}, proxy.Requests())
+}
+
+func TestDirect(t *testing.T) {
+	socksProxy := socksproxy.NewSocksProxy(socksproxy.Config{})
+	require.True(t, socksProxy.Direct())
+	conn, err := socksProxy.Dial("tcp", newEchoServer(t))
+	require.NoError(t, err)
+	echo(t, conn)
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package test provides a SOCKS5 proxy stand-in for testing the connections made through a proxy.
+package test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Proxy is a minimal SOCKS5 server. It connects to the targets by looking up their address in
+// hosts, so that the tests can use names which do not resolve.
+type Proxy struct {
+	listener net.Listener
+	hosts    map[string]string
+
+	lock      sync.Mutex
+	username  string
+	password  string
+	requested []string
+}
+
+// NewProxy starts a proxy, which connects to the targets given by address (host:port).
+func NewProxy(t *testing.T, hosts map[string]string) *Proxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	proxy := &Proxy{listener: listener, hosts: hosts}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.serve(conn)
+		}
+	}()
+	return proxy
+}
+
+// Address returns the host:port of the proxy.
+func (proxy *Proxy) Address() string {
+	return proxy.listener.Addr().String()
+}
+
+// SetAuth requires the clients to authenticate with the given username and password.
+func (proxy *Proxy) SetAuth(username string, password string) {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	proxy.username = username
+	proxy.password = password
+}
+
+// Requests returns the targets requested by the clients, in order.
+func (proxy *Proxy) Requests() []string {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	return append([]string{}, proxy.requested...)
+}
+
+func (proxy *Proxy) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	// Greeting: version, methods.
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return
+	}
+	proxy.lock.Lock()
+	username, password := proxy.username, proxy.password
+	proxy.lock.Unlock()
+	if username == "" {
+		_, _ = conn.Write([]byte{5, 0})
+	} else {
+		_, _ = conn.Write([]byte{5, 2})
+		// Username/password authentication (RFC 1929).
+		version := make([]byte, 2)
+		if _, err := io.ReadFull(reader, version); err != nil {
+			return
+		}
+		clientUsername := make([]byte, version[1])
+		if _, err := io.ReadFull(reader, clientUsername); err != nil {
+			return
+		}
+		passwordLength, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		clientPassword := make([]byte, passwordLength)
+		if _, err := io.ReadFull(reader, clientPassword); err != nil {
+			return
+		}
+		if string(clientUsername) != username || string(clientPassword) != password {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+	}
+	// Request: version, command, reserved, address type, address, port.
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	proxy.lock.Lock()
+	proxy.requested = append(proxy.requested, address)
+	proxy.lock.Unlock()
+
+	target, ok := proxy.hosts[address]
+	var targetConn net.Conn
+	var err error
+	if ok {
+		targetConn, err = net.Dial("tcp", target)
+	}
+	if !ok || err != nil {
+		// Host unreachable.
+		_, _ = conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer func() { _ = targetConn.Close() }()
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go func() { _, _ = io.Copy(targetConn, reader) }()
+	_, _ = io.Copy(conn, targetConn)
+}